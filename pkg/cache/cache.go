@@ -0,0 +1,17 @@
+// Package cache holds the stores of cached responses. The stores implement
+// CacheBackend, so the in-memory store can be swapped for a shared one.
+package cache
+
+import "time"
+
+// CacheBackend stores values by key until their TTL passes. Implementations must
+// be safe for concurrent use and may drop values before their TTL (e.g. to stay
+// within their size).
+type CacheBackend interface {
+	// Get returns the value of the key, false if there is none (or it expired)
+	Get(key string) ([]byte, bool)
+	// Set stores the value of the key for the TTL
+	Set(key string, value []byte, ttl time.Duration)
+	// Delete removes the value of the key
+	Delete(key string)
+}
@@ -0,0 +1,204 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryConfig configures a MemoryCache
+type MemoryConfig struct {
+	// MaxEntries is the number of entries the cache holds, split evenly between the
+	// shards
+	MaxEntries int `yaml:"max_entries"`
+	// Shards is the number of shards (a power of two), each with its own lock
+	Shards int `yaml:"shards"`
+}
+
+// DefaultMemoryConfig is the MemoryConfig used for unset fields
+var DefaultMemoryConfig = MemoryConfig{
+	MaxEntries: 10000,
+	Shards:     64,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *MemoryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultMemoryConfig
+	type plain MemoryConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c MemoryConfig) Validate() error {
+	if c.Shards <= 0 || c.Shards&(c.Shards-1) != 0 {
+		return fmt.Errorf("cache shards must be a power of two")
+	}
+	if c.MaxEntries < c.Shards {
+		return fmt.Errorf("cache max_entries must be at least the number of shards")
+	}
+	return nil
+}
+
+// MemoryStats are the stats of a MemoryCache
+type MemoryStats struct {
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"`
+}
+
+// NewMemoryCache returns an empty MemoryCache, the config must be valid
+func NewMemoryCache(cfg MemoryConfig) *MemoryCache {
+	c := &MemoryCache{
+		shards: make([]memoryShard, cfg.Shards),
+		mask:   uint64(cfg.Shards - 1),
+		now:    time.Now,
+	}
+	size := cfg.MaxEntries / cfg.Shards
+	for i := range c.shards {
+		c.shards[i].entries = make(map[string]*memoryEntry, size)
+		c.shards[i].ring = make([]*memoryEntry, 0, size)
+	}
+	return c
+}
+
+// MemoryCache is an in-memory CacheBackend. The keys are split between shards by
+// their hash, each shard with its own lock, so concurrent requests rarely contend.
+// Each shard evicts with the clock algorithm: an entry which was read since the
+// hand last passed it is given another round, so there is no LRU list to update
+// (under a write lock) on every read.
+type MemoryCache struct {
+	// The stats are first, so they are aligned for the atomic operations
+	hits      uint64
+	misses    uint64
+	evictions uint64
+
+	shards []memoryShard
+	mask   uint64
+	now    func() time.Time
+}
+
+// memoryEntry is an entry of a memoryShard
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires int64 // unix nanos
+	// referenced is set (atomically) when the entry is read, the clock hand clears it
+	referenced uint32
+	// slot is the index of the entry in the ring of its shard
+	slot int
+}
+
+// memoryShard is a shard of a MemoryCache. Its entries are in a ring, which the
+// clock hand sweeps for the entry to evict once the ring is full. Deleted entries
+// leave a nil slot, which is reused by the next Set.
+type memoryShard struct {
+	mu      sync.RWMutex
+	entries map[string]*memoryEntry
+	ring    []*memoryEntry
+	hand    int
+}
+
+// shard returns the shard of the key, by its FNV-1a hash
+func (c *MemoryCache) shard(key string) *memoryShard {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	return &c.shards[h&c.mask]
+}
+
+// Get returns the value of the key, false if there is none (or it expired)
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	s := c.shard(key)
+	s.mu.RLock()
+	e, ok := s.entries[key]
+	var value []byte
+	if ok && c.now().UnixNano() < e.expires {
+		atomic.StoreUint32(&e.referenced, 1)
+		value = e.value
+	} else {
+		ok = false
+	}
+	s.mu.RUnlock()
+
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+	return value, true
+}
+
+// Set stores the value of the key for the TTL, evicting an entry of its shard if
+// the shard is full
+func (c *MemoryCache) Set(key string, value []byte, ttl time.Duration) {
+	now := c.now().UnixNano()
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok {
+		e.value = value
+		e.expires = now + int64(ttl)
+		return
+	}
+
+	e := &memoryEntry{key: key, value: value, expires: now + int64(ttl)}
+	if len(s.ring) < cap(s.ring) {
+		e.slot = len(s.ring)
+		s.ring = append(s.ring, e)
+	} else {
+		var evicted bool
+		e.slot, evicted = s.evict(now)
+		s.ring[e.slot] = e
+		if evicted {
+			atomic.AddUint64(&c.evictions, 1)
+		}
+	}
+	s.entries[key] = e
+}
+
+// evict frees a slot of the full ring, returning its index and whether an entry
+// which hadn't expired was evicted for it. Free and expired slots are taken right
+// away, otherwise the hand gives referenced entries another round and evicts the
+// first entry which wasn't read since. s.mu must be held.
+func (s *memoryShard) evict(now int64) (int, bool) {
+	for {
+		slot := s.hand
+		s.hand = (s.hand + 1) % len(s.ring)
+		e := s.ring[slot]
+		if e == nil {
+			return slot, false
+		}
+		if now < e.expires && atomic.SwapUint32(&e.referenced, 0) == 1 {
+			continue
+		}
+		delete(s.entries, e.key)
+		return slot, now < e.expires
+	}
+}
+
+// Delete removes the value of the key
+func (c *MemoryCache) Delete(key string) {
+	s := c.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		delete(s.entries, key)
+		s.ring[e.slot] = nil
+	}
+}
+
+// Stats returns the stats of the cache
+func (c *MemoryCache) Stats() MemoryStats {
+	return MemoryStats{
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: atomic.LoadUint64(&c.evictions),
+	}
+}
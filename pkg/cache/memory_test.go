@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := NewMemoryCache(MemoryConfig{MaxEntries: 4, Shards: 1})
+	c.now = func() time.Time { return now }
+
+	c.Set("a", []byte("1"), time.Minute)
+	c.Set("b", []byte("2"), time.Second)
+	c.Set("c", []byte("3"), time.Minute)
+	c.Delete("c")
+	now = now.Add(2 * time.Second)
+
+	tests := []struct {
+		key   string
+		value string
+		ok    bool
+	}{
+		{key: "a", value: "1", ok: true},
+		// expired
+		{key: "b"},
+		// deleted
+		{key: "c"},
+		{key: "d"},
+	}
+	for _, test := range tests {
+		value, ok := c.Get(test.key)
+		if ok != test.ok || string(value) != test.value {
+			t.Fatalf("mismatch in %s expected=%q,%v actual=%q,%v", test.key, test.value, test.ok, value, ok)
+		}
+	}
+
+	expected := MemoryStats{Hits: 1, Misses: 3}
+	if stats := c.Stats(); stats != expected {
+		t.Fatalf("mismatch in stats expected=%+v actual=%+v", expected, stats)
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	c := NewMemoryCache(MemoryConfig{MaxEntries: 3, Shards: 1})
+	for _, key := range []string{"a", "b", "c"} {
+		c.Set(key, []byte(key), time.Minute)
+	}
+	// "a" was read, so the clock gives it another round and evicts "b"
+	c.Get("a")
+	c.Set("d", []byte("d"), time.Minute)
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true, "d": true} {
+		if _, ok := c.Get(key); ok != expected {
+			t.Fatalf("mismatch in %s expected=%v actual=%v", key, expected, ok)
+		}
+	}
+	if evictions := c.Stats().Evictions; evictions != 1 {
+		t.Fatalf("mismatch in evictions expected=%d actual=%d", 1, evictions)
+	}
+}
+
+func TestMemoryConfig(t *testing.T) {
+	tests := []struct {
+		cfg MemoryConfig
+		err bool
+	}{
+		{cfg: DefaultMemoryConfig},
+		{cfg: MemoryConfig{MaxEntries: 1, Shards: 1}},
+		{cfg: MemoryConfig{MaxEntries: 100, Shards: 3}, err: true},
+		{cfg: MemoryConfig{MaxEntries: 100, Shards: 0}, err: true},
+		{cfg: MemoryConfig{MaxEntries: 2, Shards: 4}, err: true},
+	}
+	for i, test := range tests {
+		if err := test.cfg.Validate(); (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+	}
+}
+
+func TestMemoryCacheConcurrent(t *testing.T) {
+	c := NewMemoryCache(MemoryConfig{MaxEntries: 64, Shards: 8})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				key := strconv.Itoa((i * j) % 100)
+				c.Set(key, []byte(key), time.Minute)
+				if value, ok := c.Get(key); ok && string(value) != key {
+					t.Errorf("mismatch in %s actual=%s", key, value)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// BenchmarkMemoryCache reads and writes concurrently (9 reads per write), with a
+// single shard as the baseline for the contention of a single lock
+func BenchmarkMemoryCache(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = "query_range:up:" + strconv.Itoa(i)
+	}
+	value := make([]byte, 128)
+
+	for _, shards := range []int{1, 64} {
+		b.Run("shards="+strconv.Itoa(shards), func(b *testing.B) {
+			c := NewMemoryCache(MemoryConfig{MaxEntries: 512, Shards: shards})
+			for _, key := range keys {
+				c.Set(key, value, time.Hour)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					key := keys[i%len(keys)]
+					if i%10 == 0 {
+						c.Set(key, value, time.Hour)
+					} else {
+						c.Get(key)
+					}
+					i++
+				}
+			})
+		})
+	}
+}
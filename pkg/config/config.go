@@ -3,10 +3,18 @@ package proxyconfig
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"time"
 
 	"github.com/prometheus/prometheus/config"
 
+	"github.com/jacksontj/promxy/pkg/promclient"
 	"github.com/jacksontj/promxy/pkg/servergroup"
+	"github.com/promproxy/pkg/grpcapi"
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/server"
+	"github.com/promproxy/pkg/server/middleware"
+	"github.com/promproxy/pkg/tracing"
 
 	yaml "gopkg.in/yaml.v2"
 )
@@ -15,8 +23,21 @@ import (
 // is loaded into
 var DefaultPromxyConfig = PromxyConfig{}
 
-// ConfigFromFile loads a config file at path
+// ConfigFromFile loads a config file at path. Files with the .pb extension are
+// loaded as a PromxyConfigProto (see LoadConfigProto), with the default Prometheus
+// config. Any other file is loaded as YAML.
 func ConfigFromFile(path string) (*Config, error) {
+	if filepath.Ext(path) == ".pb" {
+		promxyConfig, err := LoadConfigProto(path)
+		if err != nil {
+			return nil, err
+		}
+		return &Config{
+			PromConfig:   config.DefaultConfig,
+			PromxyConfig: *promxyConfig,
+		}, nil
+	}
+
 	// load the config file
 	cfg := &Config{
 		PromConfig:   config.DefaultConfig,
@@ -30,6 +51,9 @@ func ConfigFromFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("Error unmarshaling config: %v", err)
 	}
+	if err := cfg.ValidateVirtualProxies(); err != nil {
+		return nil, fmt.Errorf("Error validating config: %v", err)
+	}
 
 	return cfg, nil
 }
@@ -50,4 +74,191 @@ type Config struct {
 type PromxyConfig struct {
 	// Config for each of the server groups promxy is configured to aggregate
 	ServerGroups []*servergroup.Config `yaml:"server_groups"`
+
+	// MaxConcurrentSelects limits the number of Selects a single query may have
+	// in flight at once (0 means no limit)
+	MaxConcurrentSelects int `yaml:"max_concurrent_selects"`
+	// TenantMaxConcurrentSelects overrides MaxConcurrentSelects for specific tenants
+	TenantMaxConcurrentSelects map[string]int `yaml:"tenant_max_concurrent_selects"`
+
+	// MaxSeries limits the number of series a single Series call may return (0 means no limit)
+	MaxSeries int `yaml:"max_series"`
+	// FairSeriesBudget divides MaxSeries fairly between the servergroups, so one
+	// with many series can't crowd out the others. Calls over the max are then
+	// truncated (with a warning) rather than failed.
+	FairSeriesBudget bool `yaml:"fair_series_budget"`
+	// ExplainRouting adds a warning to the calls which were routed to only some of
+	// the servergroups (by their labels), naming those they were routed to
+	ExplainRouting bool `yaml:"explain_routing"`
+
+	// MaxQueryRange limits the time range a single Select may span (0 means no
+	// limit), which protects long-term stores from queries over years of data
+	MaxQueryRange time.Duration `yaml:"max_query_range"`
+
+	// EmptySeriesPolicy defines whether series without any samples (e.g. all their
+	// points were out of range) are returned from data Selects
+	EmptySeriesPolicy EmptySeriesPolicy `yaml:"empty_series_policy"`
+	// StreamSelects has the data Selects yield the series as the servergroups
+	// stream them (e.g. through the streamed remote read) instead of loading the
+	// whole result first, bounding the memory of Selects matching many series
+	StreamSelects bool `yaml:"stream_selects"`
+
+	// QueryLatencyBudget (if set) is the total time the downstream calls of a single
+	// query may take. Once a slow call used up the budget, the remaining calls of the
+	// query fail immediately rather than each waiting for the full timeout.
+	QueryLatencyBudget time.Duration `yaml:"query_latency_budget"`
+
+	// WorkerPoolSize is the number of downstream requests of the fanouts that may be
+	// in flight at once across all queries (promclient.DefaultWorkerPoolSize if 0,
+	// negative means no limit). Requests beyond that are queued.
+	WorkerPoolSize int `yaml:"worker_pool_size"`
+
+	// LoadShedding (if set) rejects new Selects while the memory usage is above a
+	// high-water mark, until it dropped below the low-water mark
+	LoadShedding *loadshed.Config `yaml:"load_shedding"`
+
+	// MetricAllowlist (if set) restricts the metrics which may be queried through
+	// promxy to those matching one of these (fully anchored) regexes. Queries which
+	// reference any other metric are rejected before they are sent downstream.
+	MetricAllowlist []string `yaml:"metric_allowlist"`
+
+	// MetricAliases (if set) presents metrics which are known under more than one
+	// name under their canonical name, querying all of the names downstream
+	MetricAliases *promclient.MetricAliasConfig `yaml:"metric_aliases"`
+
+	// Throttle (if set) rate limits the downstream calls across all queries. Calls
+	// over the limit are rejected with a Retry-After of when the limit admits a call
+	// again.
+	Throttle *promclient.ThrottleConfig `yaml:"throttle"`
+	// MaxConcurrency (if set) limits the number of downstream calls in flight at
+	// once, with separate limits for the metadata and the data calls
+	MaxConcurrency *promclient.ConcurrencyConfig `yaml:"max_concurrency"`
+
+	// FutureTime defines how calls for times in the future (e.g. from clients with
+	// a skewed clock) are handled, by default they are clamped to now
+	FutureTime promclient.FutureTimeConfig `yaml:"future_time"`
+
+	// InvertedTimeRange defines how calls whose start is after their end are
+	// handled, by default they fail with an ErrInvalidTimeRange
+	InvertedTimeRange promclient.TimeRangeConfig `yaml:"inverted_time_range"`
+
+	// LabelCache (if set) serves the label names and the values of the configured
+	// labels from a cache which is refreshed in the background, so autocompletion
+	// doesn't wait for the downstreams
+	LabelCache *promclient.LabelCacheConfig `yaml:"label_cache"`
+
+	// ResultCache (if set) caches the results of queries and series calls. Degraded
+	// results, e.g. partial ones or those produced close to their deadline, are
+	// never cached.
+	ResultCache *promclient.ResultCacheConfig `yaml:"result_cache"`
+
+	// QueryVerification (if set) verifies a sample of the range queries routed to a
+	// single servergroup against the result that servergroup evaluates itself
+	QueryVerification *promclient.QueryVerificationConfig `yaml:"query_verification"`
+
+	// Tracing (if set) exports the traces of the requests which are slow, fail or
+	// ask to be traced (see server.TracingMiddleware)
+	Tracing *tracing.Config `yaml:"tracing"`
+
+	// DryRun makes all requests dry runs, which respond with the downstream requests
+	// they would have sent rather than sending them (see server.DryRunMiddleware)
+	DryRun bool `yaml:"dry_run"`
+
+	// ReadOnly rejects the requests which change the data of the downstreams (e.g.
+	// /api/v1/admin/tsdb/delete_series)
+	ReadOnly bool `yaml:"read_only"`
+	// DeleteSeriesRateLimit (if set) replaces the rate limit of the deletes of series
+	// (server.DefaultDeleteSeriesRateLimit)
+	DeleteSeriesRateLimit *server.RateLimitConfig `yaml:"delete_series_rate_limit"`
+
+	// QueryAttributionHeaders maps request headers (e.g. X-Dashboard-Uid) to the
+	// fields of the query attribution, which the query_comment of the servergroups
+	// adds to the forwarded queries (see server.QueryAttributionMiddleware)
+	QueryAttributionHeaders map[string]string `yaml:"query_attribution_headers"`
+
+	// Middleware configures the middleware chain of the HTTP handlers
+	Middleware server.MiddlewareConfig `yaml:"middleware"`
+	// Auth configures the authentication of the proxy's own endpoints
+	Auth server.ServerAuthConfig `yaml:"auth"`
+	// RateLimit (if set) rate limits the requests to the proxy, requests over the
+	// limit are rejected with a 429
+	RateLimit *server.RateLimitConfig `yaml:"rate_limit"`
+	// CORS (if set) allows browser apps from the configured origins to call the API
+	CORS *middleware.CORSConfig `yaml:"cors"`
+	// Compression (if set) gzip compresses the responses for clients accepting it
+	Compression *middleware.CompressConfig `yaml:"compression"`
+	// MaxResponseSize (if set) limits the responses of the query endpoints to this
+	// many bytes, larger ones fail with a 413 (see server.ResponseSizeLimitMiddleware)
+	MaxResponseSize int64 `yaml:"max_response_size"`
+	// MaxQueryTimeout (if set) bounds the timeout param of the query endpoints, and
+	// is the timeout of the requests without one (see
+	// server.QueryTimeoutLimitMiddleware)
+	MaxQueryTimeout time.Duration `yaml:"max_query_timeout"`
+
+	// GRPC (if set) serves the query API over gRPC as well, on its own listen
+	// address with the Auth, RateLimit and MaxQueryTimeout of the HTTP API (see
+	// grpcapi.ListenAndServe)
+	GRPC *grpcapi.Config `yaml:"grpc"`
+
+	// VirtualProxies are the proxies served from this process besides the root one,
+	// each with its own server groups, limits, caches and auth (see
+	// proxystorage.VirtualProxies)
+	VirtualProxies map[string]*VirtualProxyConfig `yaml:"virtual_proxies"`
+}
+
+// EmptySeriesPolicy defines what is done with series that have no samples after
+// merging
+type EmptySeriesPolicy string
+
+// The empty series policies
+const (
+	// EmptySeriesAuto keeps empty series in metadata Selects and drops them in data
+	// Selects (the default)
+	EmptySeriesAuto EmptySeriesPolicy = "auto"
+	// EmptySeriesKeep keeps empty series in all Selects
+	EmptySeriesKeep EmptySeriesPolicy = "keep"
+	// EmptySeriesDrop drops empty series from data Selects. The series of metadata
+	// Selects never have samples, so they are always kept.
+	EmptySeriesDrop EmptySeriesPolicy = "drop"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (p *EmptySeriesPolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	policy := EmptySeriesPolicy(s)
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	*p = policy
+	return nil
+}
+
+// Validate returns an error if the policy isn't known
+func (p EmptySeriesPolicy) Validate() error {
+	switch p {
+	case "", EmptySeriesAuto, EmptySeriesKeep, EmptySeriesDrop:
+		return nil
+	default:
+		return fmt.Errorf("unknown empty_series_policy %q", string(p))
+	}
+}
+
+// DropEmptySeries returns whether series without samples should be dropped from a
+// Select of the given intent
+func (p EmptySeriesPolicy) DropEmptySeries(metadata bool) bool {
+	if metadata {
+		return false
+	}
+	return p != EmptySeriesKeep
+}
+
+// SelectLimit returns the max concurrent Selects for a query from the given tenant
+func (c *PromxyConfig) SelectLimit(tenant string) int {
+	if limit, ok := c.TenantMaxConcurrentSelects[tenant]; ok {
+		return limit
+	}
+	return c.MaxConcurrentSelects
 }
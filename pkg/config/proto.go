@@ -0,0 +1,386 @@
+package proxyconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	sd_config "github.com/prometheus/prometheus/discovery/config"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/servergroup"
+)
+
+// The messages of config.proto. They are encoded through the reflection of the
+// protobuf library, so the struct tags have to be kept in sync with config.proto.
+
+// PromxyConfigProto mirrors PromxyConfig
+type PromxyConfigProto struct {
+	ServerGroups               []*ServerGroupProto `protobuf:"bytes,1,rep,name=server_groups,json=serverGroups,proto3"`
+	MaxConcurrentSelects       int64               `protobuf:"varint,2,opt,name=max_concurrent_selects,json=maxConcurrentSelects,proto3"`
+	TenantMaxConcurrentSelects map[string]int64    `protobuf:"bytes,3,rep,name=tenant_max_concurrent_selects,json=tenantMaxConcurrentSelects,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	MaxSeries                  int64               `protobuf:"varint,4,opt,name=max_series,json=maxSeries,proto3"`
+	EmptySeriesPolicy          string              `protobuf:"bytes,5,opt,name=empty_series_policy,json=emptySeriesPolicy,proto3"`
+	QueryLatencyBudgetNs       int64               `protobuf:"varint,6,opt,name=query_latency_budget_ns,json=queryLatencyBudgetNs,proto3"`
+	WorkerPoolSize             int64               `protobuf:"varint,7,opt,name=worker_pool_size,json=workerPoolSize,proto3"`
+	MetricAllowlist            []string            `protobuf:"bytes,8,rep,name=metric_allowlist,json=metricAllowlist,proto3"`
+	MaxQueryRangeNs            int64               `protobuf:"varint,9,opt,name=max_query_range_ns,json=maxQueryRangeNs,proto3"`
+	FairSeriesBudget           bool                `protobuf:"varint,10,opt,name=fair_series_budget,json=fairSeriesBudget,proto3"`
+}
+
+// Reset implements proto.Message
+func (m *PromxyConfigProto) Reset() { *m = PromxyConfigProto{} }
+
+// String implements proto.Message
+func (m *PromxyConfigProto) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message
+func (*PromxyConfigProto) ProtoMessage() {}
+
+// ServerGroupProto mirrors servergroup.Config
+type ServerGroupProto struct {
+	StaticConfigs        []*StaticConfigProto `protobuf:"bytes,1,rep,name=static_configs,json=staticConfigs,proto3"`
+	Scheme               string               `protobuf:"bytes,2,opt,name=scheme,proto3"`
+	PathPrefix           string               `protobuf:"bytes,3,opt,name=path_prefix,json=pathPrefix,proto3"`
+	RemoteRead           bool                 `protobuf:"varint,4,opt,name=remote_read,json=remoteRead,proto3"`
+	Labels               map[string]string    `protobuf:"bytes,5,rep,name=labels,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	ExternalLabels       map[string]string    `protobuf:"bytes,6,rep,name=external_labels,json=externalLabels,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	AntiAffinityNs       int64                `protobuf:"varint,7,opt,name=anti_affinity_ns,json=antiAffinityNs,proto3"`
+	IgnoreError          bool                 `protobuf:"varint,8,opt,name=ignore_error,json=ignoreError,proto3"`
+	LabelValidation      string               `protobuf:"bytes,9,opt,name=label_validation,json=labelValidation,proto3"`
+	MergeMode            string               `protobuf:"bytes,10,opt,name=merge_mode,json=mergeMode,proto3"`
+	MetricAllowlist      []string             `protobuf:"bytes,11,rep,name=metric_allowlist,json=metricAllowlist,proto3"`
+	MetricDenylist       []string             `protobuf:"bytes,12,rep,name=metric_denylist,json=metricDenylist,proto3"`
+	ResolutionNs         int64                `protobuf:"varint,13,opt,name=resolution_ns,json=resolutionNs,proto3"`
+	ResampleResolutionNs int64                `protobuf:"varint,14,opt,name=resample_resolution_ns,json=resampleResolutionNs,proto3"`
+	QuerySplitIntervalNs int64                `protobuf:"varint,15,opt,name=query_split_interval_ns,json=querySplitIntervalNs,proto3"`
+	DialTimeoutNs        int64                `protobuf:"varint,16,opt,name=dial_timeout_ns,json=dialTimeoutNs,proto3"`
+	RequestIDHeader      string               `protobuf:"bytes,17,opt,name=request_id_header,json=requestIdHeader,proto3"`
+	EmptyMatchers        string               `protobuf:"bytes,18,opt,name=empty_matchers,json=emptyMatchers,proto3"`
+	EmptyMatchersLimit   int64                `protobuf:"varint,19,opt,name=empty_matchers_limit,json=emptyMatchersLimit,proto3"`
+	SeriesLimit          string               `protobuf:"bytes,20,opt,name=series_limit,json=seriesLimit,proto3"`
+}
+
+// Reset implements proto.Message
+func (m *ServerGroupProto) Reset() { *m = ServerGroupProto{} }
+
+// String implements proto.Message
+func (m *ServerGroupProto) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message
+func (*ServerGroupProto) ProtoMessage() {}
+
+// StaticConfigProto is a static target group
+type StaticConfigProto struct {
+	Targets []string          `protobuf:"bytes,1,rep,name=targets,proto3"`
+	Labels  map[string]string `protobuf:"bytes,2,rep,name=labels,proto3" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+}
+
+// Reset implements proto.Message
+func (m *StaticConfigProto) Reset() { *m = StaticConfigProto{} }
+
+// String implements proto.Message
+func (m *StaticConfigProto) String() string { return proto.CompactTextString(m) }
+
+// ProtoMessage implements proto.Message
+func (*StaticConfigProto) ProtoMessage() {}
+
+// LoadConfigProto loads a PromxyConfig encoded as a PromxyConfigProto from path
+func LoadConfigProto(path string) (*PromxyConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error loading config: %v", err)
+	}
+	return UnmarshalConfigProto(b)
+}
+
+// UnmarshalConfigProto decodes a PromxyConfig encoded as a PromxyConfigProto
+func UnmarshalConfigProto(b []byte) (*PromxyConfig, error) {
+	m := &PromxyConfigProto{}
+	if err := proto.Unmarshal(b, m); err != nil {
+		return nil, fmt.Errorf("Error unmarshaling config: %v", err)
+	}
+	return m.PromxyConfig()
+}
+
+// MarshalConfigProto encodes the PromxyConfig as a PromxyConfigProto. Configs with
+// options which config.proto doesn't mirror (e.g. auth or limits) can't be
+// encoded, as loading them would silently lose those options. Servergroups must
+// discover their hosts from static configs.
+func MarshalConfigProto(c *PromxyConfig) ([]byte, error) {
+	m, err := NewPromxyConfigProto(c)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(m)
+}
+
+// MarshalConfigFileProto encodes the Config as a PromxyConfigProto (see
+// MarshalConfigProto). Its Prometheus config must be the default, which is the
+// one ConfigFromFile loads with a PromxyConfigProto.
+func MarshalConfigFileProto(c *Config) ([]byte, error) {
+	prom := c.PromConfig
+	if !reflect.DeepEqual(prom.GlobalConfig, config.DefaultGlobalConfig) || len(prom.RuleFiles) > 0 ||
+		len(prom.ScrapeConfigs) > 0 || len(prom.RemoteWriteConfigs) > 0 || len(prom.RemoteReadConfigs) > 0 ||
+		len(prom.AlertingConfig.AlertmanagerConfigs) > 0 || len(prom.AlertingConfig.AlertRelabelConfigs) > 0 {
+		return nil, fmt.Errorf("the prometheus config isn't supported in the proto config format")
+	}
+	return MarshalConfigProto(&c.PromxyConfig)
+}
+
+// mirroredOptions are the fields of the PromxyConfig which config.proto mirrors
+var mirroredOptions = []string{
+	"ServerGroups",
+	"MaxConcurrentSelects",
+	"TenantMaxConcurrentSelects",
+	"MaxSeries",
+	"EmptySeriesPolicy",
+	"QueryLatencyBudget",
+	"WorkerPoolSize",
+	"MetricAllowlist",
+	"MaxQueryRange",
+	"FairSeriesBudget",
+}
+
+// mirroredServerGroupOptions are the fields of the servergroup.Config which
+// config.proto mirrors. The hosts and the http client are checked on their own
+var mirroredServerGroupOptions = []string{
+	"RemoteRead",
+	"HTTPConfig",
+	"Scheme",
+	"Labels",
+	"ExternalLabels",
+	"Hosts",
+	"ConsulWatchConfigs",
+	"PathPrefix",
+	"AntiAffinity",
+	"IgnoreError",
+	"LabelValidation",
+	"MergeMode",
+	"MetricAllowlist",
+	"MetricDenylist",
+	"Resolution",
+	"ResampleResolution",
+	"QuerySplitInterval",
+	"EmptyMatchers",
+	"EmptyMatchersLimit",
+	"SeriesLimit",
+}
+
+// mirroredHTTPClientOptions are the fields of the servergroup.HTTPClientConfig
+// which config.proto mirrors
+var mirroredHTTPClientOptions = []string{
+	"DialTimeout",
+	"RequestIDHeader",
+}
+
+// unmirroredOptions returns the names of the options of v (a struct) which aren't
+// mirrored, and which are set to something else than in def (of the same type)
+func unmirroredOptions(v, def interface{}, mirrored []string) []string {
+	skip := make(map[string]bool, len(mirrored))
+	for _, name := range mirrored {
+		skip[name] = true
+	}
+
+	rv, rdef := reflect.ValueOf(v), reflect.ValueOf(def)
+	var options []string
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if skip[field.Name] || field.PkgPath != "" {
+			continue
+		}
+		if reflect.DeepEqual(rv.Field(i).Interface(), rdef.Field(i).Interface()) {
+			continue
+		}
+		// The options of inlined structs are named as those of v
+		if field.Tag.Get("yaml") == ",inline" && field.Type.Kind() == reflect.Struct {
+			options = append(options, unmirroredOptions(rv.Field(i).Interface(), rdef.Field(i).Interface(), nil)...)
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "" {
+			name = strings.Split(field.Tag.Get("json"), ",")[0]
+		}
+		if name == "" {
+			name = field.Name
+		}
+		options = append(options, name)
+	}
+	return options
+}
+
+// NewPromxyConfigProto returns the PromxyConfigProto of the PromxyConfig. It fails
+// for configs with options which config.proto doesn't mirror.
+func NewPromxyConfigProto(c *PromxyConfig) (*PromxyConfigProto, error) {
+	if options := unmirroredOptions(*c, DefaultPromxyConfig, mirroredOptions); len(options) > 0 {
+		return nil, fmt.Errorf("options %s aren't supported in the proto config format", strings.Join(options, ", "))
+	}
+
+	m := &PromxyConfigProto{
+		ServerGroups:         make([]*ServerGroupProto, len(c.ServerGroups)),
+		MaxConcurrentSelects: int64(c.MaxConcurrentSelects),
+		MaxSeries:            int64(c.MaxSeries),
+		EmptySeriesPolicy:    string(c.EmptySeriesPolicy),
+		QueryLatencyBudgetNs: int64(c.QueryLatencyBudget),
+		WorkerPoolSize:       int64(c.WorkerPoolSize),
+		MetricAllowlist:      c.MetricAllowlist,
+		MaxQueryRangeNs:      int64(c.MaxQueryRange),
+		FairSeriesBudget:     c.FairSeriesBudget,
+	}
+	if len(c.TenantMaxConcurrentSelects) > 0 {
+		m.TenantMaxConcurrentSelects = make(map[string]int64, len(c.TenantMaxConcurrentSelects))
+		for tenant, limit := range c.TenantMaxConcurrentSelects {
+			m.TenantMaxConcurrentSelects[tenant] = int64(limit)
+		}
+	}
+
+	for i, sg := range c.ServerGroups {
+		if !reflect.DeepEqual(sg.Hosts, sd_config.ServiceDiscoveryConfig{StaticConfigs: sg.Hosts.StaticConfigs}) || len(sg.ConsulWatchConfigs) > 0 {
+			return nil, fmt.Errorf("servergroup %d: only static_configs are supported in the proto config format", i)
+		}
+		options := unmirroredOptions(*sg, servergroup.DefaultConfig, mirroredServerGroupOptions)
+		for _, option := range unmirroredOptions(sg.HTTPConfig, servergroup.DefaultConfig.HTTPConfig, mirroredHTTPClientOptions) {
+			options = append(options, "http_client."+option)
+		}
+		if len(options) > 0 {
+			return nil, fmt.Errorf("servergroup %d: options %s aren't supported in the proto config format", i, strings.Join(options, ", "))
+		}
+
+		sgm := &ServerGroupProto{
+			Scheme:               sg.Scheme,
+			PathPrefix:           sg.PathPrefix,
+			RemoteRead:           sg.RemoteRead,
+			Labels:               labelSetToProto(sg.Labels),
+			ExternalLabels:       labelSetToProto(sg.ExternalLabels),
+			AntiAffinityNs:       int64(sg.AntiAffinity),
+			IgnoreError:          sg.IgnoreError,
+			LabelValidation:      string(sg.LabelValidation),
+			MergeMode:            string(sg.MergeMode),
+			MetricAllowlist:      sg.MetricAllowlist,
+			MetricDenylist:       sg.MetricDenylist,
+			ResolutionNs:         int64(sg.Resolution),
+			ResampleResolutionNs: int64(sg.ResampleResolution),
+			QuerySplitIntervalNs: int64(sg.QuerySplitInterval),
+			DialTimeoutNs:        int64(sg.HTTPConfig.DialTimeout),
+			RequestIDHeader:      sg.HTTPConfig.RequestIDHeader,
+			EmptyMatchers:        string(sg.EmptyMatchers),
+			EmptyMatchersLimit:   int64(sg.EmptyMatchersLimit),
+			SeriesLimit:          string(sg.SeriesLimit),
+		}
+		for _, group := range sg.Hosts.StaticConfigs {
+			static := &StaticConfigProto{Labels: labelSetToProto(group.Labels)}
+			for _, target := range group.Targets {
+				static.Targets = append(static.Targets, string(target[model.AddressLabel]))
+			}
+			sgm.StaticConfigs = append(sgm.StaticConfigs, static)
+		}
+		m.ServerGroups[i] = sgm
+	}
+
+	return m, nil
+}
+
+// PromxyConfig returns the (validated) PromxyConfig of the message
+func (m *PromxyConfigProto) PromxyConfig() (*PromxyConfig, error) {
+	c := DefaultPromxyConfig
+	c.MaxConcurrentSelects = int(m.MaxConcurrentSelects)
+	c.MaxSeries = int(m.MaxSeries)
+	c.EmptySeriesPolicy = EmptySeriesPolicy(m.EmptySeriesPolicy)
+	c.QueryLatencyBudget = time.Duration(m.QueryLatencyBudgetNs)
+	c.WorkerPoolSize = int(m.WorkerPoolSize)
+	c.MetricAllowlist = m.MetricAllowlist
+	c.MaxQueryRange = time.Duration(m.MaxQueryRangeNs)
+	c.FairSeriesBudget = m.FairSeriesBudget
+	if err := c.EmptySeriesPolicy.Validate(); err != nil {
+		return nil, err
+	}
+	if len(m.TenantMaxConcurrentSelects) > 0 {
+		c.TenantMaxConcurrentSelects = make(map[string]int, len(m.TenantMaxConcurrentSelects))
+		for tenant, limit := range m.TenantMaxConcurrentSelects {
+			c.TenantMaxConcurrentSelects[tenant] = int(limit)
+		}
+	}
+
+	for i, sgm := range m.ServerGroups {
+		sg := servergroup.DefaultConfig
+		sg.RemoteRead = sgm.RemoteRead
+		sg.PathPrefix = sgm.PathPrefix
+		sg.Labels = labelSetFromProto(sgm.Labels)
+		sg.ExternalLabels = labelSetFromProto(sgm.ExternalLabels)
+		sg.IgnoreError = sgm.IgnoreError
+		sg.MetricAllowlist = sgm.MetricAllowlist
+		sg.MetricDenylist = sgm.MetricDenylist
+		sg.Resolution = time.Duration(sgm.ResolutionNs)
+		sg.ResampleResolution = time.Duration(sgm.ResampleResolutionNs)
+		sg.QuerySplitInterval = time.Duration(sgm.QuerySplitIntervalNs)
+		sg.HTTPConfig.RequestIDHeader = sgm.RequestIDHeader
+		// Zero values keep the defaults
+		if sgm.Scheme != "" {
+			sg.Scheme = sgm.Scheme
+		}
+		if sgm.AntiAffinityNs != 0 {
+			sg.AntiAffinity = time.Duration(sgm.AntiAffinityNs)
+		}
+		if sgm.LabelValidation != "" {
+			sg.LabelValidation = promclient.LabelValidationMode(sgm.LabelValidation)
+		}
+		if sgm.MergeMode != "" {
+			sg.MergeMode = promclient.MergeMode(sgm.MergeMode)
+		}
+		if sgm.DialTimeoutNs != 0 {
+			sg.HTTPConfig.DialTimeout = time.Duration(sgm.DialTimeoutNs)
+		}
+		if sgm.EmptyMatchers != "" {
+			sg.EmptyMatchers = promclient.EmptyMatchersPolicy(sgm.EmptyMatchers)
+		}
+		if sgm.EmptyMatchersLimit != 0 {
+			sg.EmptyMatchersLimit = int(sgm.EmptyMatchersLimit)
+		}
+		if sgm.SeriesLimit != "" {
+			sg.SeriesLimit = promclient.SeriesLimitSupport(sgm.SeriesLimit)
+		}
+
+		for _, static := range sgm.StaticConfigs {
+			group := &targetgroup.Group{Labels: labelSetFromProto(static.Labels)}
+			for _, target := range static.Targets {
+				group.Targets = append(group.Targets, model.LabelSet{model.AddressLabel: model.LabelValue(target)})
+			}
+			sg.Hosts.StaticConfigs = append(sg.Hosts.StaticConfigs, group)
+		}
+
+		if err := sg.Validate(); err != nil {
+			return nil, fmt.Errorf("servergroup %d: %v", i, err)
+		}
+		c.ServerGroups = append(c.ServerGroups, &sg)
+	}
+
+	return &c, nil
+}
+
+func labelSetToProto(ls model.LabelSet) map[string]string {
+	if len(ls) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(ls))
+	for k, v := range ls {
+		m[string(k)] = string(v)
+	}
+	return m
+}
+
+func labelSetFromProto(m map[string]string) model.LabelSet {
+	if len(m) == 0 {
+		return nil
+	}
+	ls := make(model.LabelSet, len(m))
+	for k, v := range m {
+		ls[model.LabelName(k)] = model.LabelValue(v)
+	}
+	return ls
+}
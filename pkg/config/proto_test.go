@@ -0,0 +1,173 @@
+package proxyconfig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/servergroup"
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/server"
+)
+
+func TestConfigProtoRoundTrip(t *testing.T) {
+	sg := servergroup.DefaultConfig
+	sg.Scheme = "https"
+	sg.PathPrefix = "/prometheus"
+	sg.RemoteRead = true
+	sg.Labels = model.LabelSet{"sg": "a"}
+	sg.ExternalLabels = model.LabelSet{"prometheus": "eu"}
+	sg.AntiAffinity = 30 * time.Second
+	sg.IgnoreError = true
+	sg.MergeMode = promclient.MergeModeConcat
+	sg.MetricAllowlist = []string{"up", "node_.*"}
+	sg.MetricDenylist = []string{"secret_.*"}
+	sg.Resolution = 5 * time.Minute
+	sg.QuerySplitInterval = 24 * time.Hour
+	sg.HTTPConfig.DialTimeout = time.Second
+	sg.HTTPConfig.RequestIDHeader = "X-Request-ID"
+	sg.EmptyMatchers = promclient.EmptyMatchersCatchAll
+	sg.EmptyMatchersLimit = 100
+	sg.SeriesLimit = promclient.SeriesLimitDisabled
+	sg.Hosts.StaticConfigs = []*targetgroup.Group{
+		{
+			Targets: []model.LabelSet{{model.AddressLabel: "prom-0:9090"}, {model.AddressLabel: "prom-1:9090"}},
+			Labels:  model.LabelSet{"az": "a"},
+		},
+		{
+			Targets: []model.LabelSet{{model.AddressLabel: "prom-2:9090"}},
+		},
+	}
+
+	// A servergroup with all the defaults
+	defaultSG := servergroup.DefaultConfig
+	defaultSG.Hosts.StaticConfigs = []*targetgroup.Group{
+		{Targets: []model.LabelSet{{model.AddressLabel: "localhost:9090"}}},
+	}
+
+	tests := []*PromxyConfig{
+		{},
+		{
+			ServerGroups:               []*servergroup.Config{&sg, &defaultSG},
+			MaxConcurrentSelects:       8,
+			TenantMaxConcurrentSelects: map[string]int{"batch": 2},
+			MaxSeries:                  10000,
+			EmptySeriesPolicy:          EmptySeriesKeep,
+			QueryLatencyBudget:         10 * time.Second,
+			WorkerPoolSize:             256,
+			MetricAllowlist:            []string{"up"},
+			MaxQueryRange:              31 * 24 * time.Hour,
+			FairSeriesBudget:           true,
+		},
+	}
+
+	for i, test := range tests {
+		b, err := MarshalConfigProto(test)
+		if err != nil {
+			t.Fatalf("%d: error marshaling: %v", i, err)
+		}
+		actual, err := UnmarshalConfigProto(b)
+		if err != nil {
+			t.Fatalf("%d: error unmarshaling: %v", i, err)
+		}
+		if !reflect.DeepEqual(test, actual) {
+			t.Fatalf("%d: mismatch in config expected=%+v actual=%+v", i, test, actual)
+		}
+	}
+}
+
+func TestConfigFromFileProto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	b, err := MarshalConfigProto(&PromxyConfig{MaxSeries: 10})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	path := filepath.Join(dir, "config.pb")
+	if err := ioutil.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	cfg, err := ConfigFromFile(path)
+	if err != nil {
+		t.Fatalf("error loading config: %v", err)
+	}
+	if cfg.MaxSeries != 10 {
+		t.Fatalf("mismatch in max_series expected=%d actual=%d", 10, cfg.MaxSeries)
+	}
+}
+
+func TestConfigProtoInvalid(t *testing.T) {
+	// Options are validated as they are for YAML configs
+	b, err := MarshalConfigProto(&PromxyConfig{EmptySeriesPolicy: "sometimes"})
+	if err != nil {
+		t.Fatalf("error marshaling: %v", err)
+	}
+	if _, err := UnmarshalConfigProto(b); err == nil {
+		t.Fatalf("expected error for an invalid empty_series_policy")
+	}
+
+	// Only static hosts can be encoded
+	sg := servergroup.DefaultConfig
+	sg.Hosts.DNSSDConfigs = append(sg.Hosts.DNSSDConfigs, nil)
+	if _, err := MarshalConfigProto(&PromxyConfig{ServerGroups: []*servergroup.Config{&sg}}); err == nil {
+		t.Fatalf("expected error for a servergroup with service discovery")
+	}
+}
+
+func TestConfigProtoUnmirroredOptions(t *testing.T) {
+	sgWith := func(f func(sg *servergroup.Config)) *PromxyConfig {
+		sg := servergroup.DefaultConfig
+		f(&sg)
+		return &PromxyConfig{ServerGroups: []*servergroup.Config{&sg}}
+	}
+
+	// Loading these from a proto config would silently lose their options
+	tests := map[string]*PromxyConfig{
+		"auth":          {Auth: server.ServerAuthConfig{AuthConfig: server.AuthConfig{BearerTokens: map[string]string{"token": "team"}}}},
+		"read_only":     {ReadOnly: true},
+		"rate_limit":    {RateLimit: &server.RateLimitConfig{}},
+		"load_shedding": {LoadShedding: &loadshed.Config{}},
+		"http_client.basic_auth": sgWith(func(sg *servergroup.Config) {
+			sg.HTTPConfig.HTTPConfig.BasicAuth = &config_util.BasicAuth{Username: "promxy"}
+		}),
+		"retry": sgWith(func(sg *servergroup.Config) {
+			sg.Retry = &promclient.RetryConfig{}
+		}),
+		"scrape": sgWith(func(sg *servergroup.Config) {
+			sg.Scrape = &promclient.ScrapeConfig{}
+		}),
+	}
+	for option, cfg := range tests {
+		t.Run(option, func(t *testing.T) {
+			_, err := MarshalConfigProto(cfg)
+			if err == nil || !strings.Contains(err.Error(), option) {
+				t.Fatalf("expected an error for %s, got: %v", option, err)
+			}
+		})
+	}
+
+	// Neither would the rules of the prometheus config
+	promCfg := &Config{PromConfig: config.DefaultConfig}
+	if _, err := MarshalConfigFileProto(promCfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	promCfg.PromConfig.RuleFiles = []string{"rules.yml"}
+	if _, err := MarshalConfigFileProto(promCfg); err == nil {
+		t.Fatalf("expected an error for rule_files")
+	}
+}
@@ -0,0 +1,87 @@
+package proxyconfig
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+)
+
+// virtualProxyNameRE is the pattern of the names of virtual proxies, which are
+// part of their URL prefix (/t/<name>/)
+var virtualProxyNameRE = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// VirtualProxyConfig is the config of a virtual proxy, which is a PromxyConfig
+// of its own (server groups, limits, caches and auth) served from the same
+// process as the root proxy
+type VirtualProxyConfig struct {
+	PromxyConfig `yaml:",inline"`
+
+	// Tenants are the tenants whose requests are served by the virtual proxy
+	// without the URL prefix. A tenant can only belong to one virtual proxy.
+	Tenants []string `yaml:"tenants"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *VirtualProxyConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = VirtualProxyConfig{PromxyConfig: DefaultPromxyConfig}
+	type plain VirtualProxyConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c *VirtualProxyConfig) Validate() error {
+	if len(c.VirtualProxies) > 0 {
+		return fmt.Errorf("virtual proxies can't have virtual_proxies of their own")
+	}
+	return nil
+}
+
+// ValidateVirtualProxies returns an error if any of the virtual proxies isn't
+// valid, or if a tenant belongs to more than one of them. A virtual proxy without
+// tenants is available to every request without a virtual proxy of its own (see
+// server.VirtualProxyMiddleware), so it must authenticate its requests itself
+func (c *PromxyConfig) ValidateVirtualProxies() error {
+	names := make([]string, 0, len(c.VirtualProxies))
+	for name := range c.VirtualProxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tenants := make(map[string]string)
+	for _, name := range names {
+		vp := c.VirtualProxies[name]
+		if !virtualProxyNameRE.MatchString(name) {
+			return fmt.Errorf("invalid virtual proxy name %q, it must match %s", name, virtualProxyNameRE)
+		}
+		if vp == nil {
+			return fmt.Errorf("virtual proxy %q has no config", name)
+		}
+		if err := vp.Validate(); err != nil {
+			return fmt.Errorf("virtual proxy %q: %v", name, err)
+		}
+		if len(vp.Tenants) == 0 && !vp.Auth.Enabled() {
+			return fmt.Errorf("virtual proxy %q has neither tenants nor auth", name)
+		}
+		for _, tenant := range vp.Tenants {
+			if other, ok := tenants[tenant]; ok {
+				return fmt.Errorf("tenant %q belongs to virtual proxies %q and %q", tenant, other, name)
+			}
+			tenants[tenant] = name
+		}
+	}
+	return nil
+}
+
+// VirtualProxyTenants maps the tenants of the virtual proxies to their names
+func (c *PromxyConfig) VirtualProxyTenants() map[string]string {
+	tenants := make(map[string]string)
+	for name, vp := range c.VirtualProxies {
+		for _, tenant := range vp.Tenants {
+			tenants[tenant] = name
+		}
+	}
+	return tenants
+}
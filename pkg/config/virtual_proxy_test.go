@@ -0,0 +1,54 @@
+package proxyconfig
+
+import (
+	"testing"
+
+	"github.com/promproxy/pkg/server"
+)
+
+func TestValidateVirtualProxies(t *testing.T) {
+	auth := server.ServerAuthConfig{AuthConfig: server.AuthConfig{BearerTokens: map[string]string{"token": "team"}}}
+
+	tests := []struct {
+		name    string
+		proxies map[string]*VirtualProxyConfig
+		err     bool
+	}{
+		{
+			name:    "tenants",
+			proxies: map[string]*VirtualProxyConfig{"a": {Tenants: []string{"alice"}}},
+		},
+		{
+			name:    "auth",
+			proxies: map[string]*VirtualProxyConfig{"a": {PromxyConfig: PromxyConfig{Auth: auth}}},
+		},
+		// Anyone could use the prefix of a virtual proxy without tenants or auth
+		{
+			name:    "neither tenants nor auth",
+			proxies: map[string]*VirtualProxyConfig{"a": {}},
+			err:     true,
+		},
+		{
+			name: "shared tenant",
+			proxies: map[string]*VirtualProxyConfig{
+				"a": {Tenants: []string{"alice"}},
+				"b": {Tenants: []string{"alice"}},
+			},
+			err: true,
+		},
+		{
+			name:    "invalid name",
+			proxies: map[string]*VirtualProxyConfig{"a/b": {Tenants: []string{"alice"}}},
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &PromxyConfig{VirtualProxies: test.proxies}
+			if err := cfg.ValidateVirtualProxies(); (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package proxyconfig
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// NewConfigWatcher returns a ConfigWatcher for the config file at path, which
+// applies the config to the reloadables
+func NewConfigWatcher(path string, reloadables ...Reloadable) *ConfigWatcher {
+	return &ConfigWatcher{
+		path:        path,
+		reloadables: reloadables,
+	}
+}
+
+// ConfigWatcher loads the config file and applies it to the Reloadables
+type ConfigWatcher struct {
+	path        string
+	reloadables []Reloadable
+
+	l       sync.Mutex
+	current *Config
+}
+
+// Current returns the currently applied config (nil if none has been applied)
+func (w *ConfigWatcher) Current() *Config {
+	w.l.Lock()
+	defer w.l.Unlock()
+	return w.current
+}
+
+// Reload loads the config file and applies it to all of the Reloadables, returning
+// the diff from the previous config. If the config can't be loaded or applied the
+// current config is left in place: if any Reloadable fails to apply it, the
+// current config is applied again to those which applied the new one.
+func (w *ConfigWatcher) Reload() ([]string, error) {
+	w.l.Lock()
+	defer w.l.Unlock()
+
+	cfg, err := ConfigFromFile(w.path)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := configDiff(w.current, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		failed  []string
+		applied []Reloadable
+	)
+	for _, r := range w.reloadables {
+		if err := r.ApplyConfig(cfg); err != nil {
+			failed = append(failed, err.Error())
+		} else {
+			applied = append(applied, r)
+		}
+	}
+	if len(failed) > 0 {
+		// Roll back, so the process isn't left with the config half applied
+		if w.current != nil {
+			for _, r := range applied {
+				if err := r.ApplyConfig(w.current); err != nil {
+					failed = append(failed, fmt.Sprintf("error rolling back: %v", err))
+				}
+			}
+		}
+		return diff, fmt.Errorf("error applying config: %s", strings.Join(failed, "; "))
+	}
+	w.current = cfg
+	return diff, nil
+}
+
+// configDiff returns a line diff of the YAML of the configs, with removed lines
+// prefixed by "-" and added lines by "+". Secrets (e.g. the auth bearer tokens and
+// password hashes) are marshaled as "<secret>", so they don't end up in the diff.
+func configDiff(a, b *Config) ([]string, error) {
+	lines := func(c *Config) ([]string, error) {
+		if c == nil {
+			return nil, nil
+		}
+		out, err := yaml.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		return strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"), nil
+	}
+
+	aLines, err := lines(a)
+	if err != nil {
+		return nil, err
+	}
+	bLines, err := lines(b)
+	if err != nil {
+		return nil, err
+	}
+	return diffLines(aLines, bLines), nil
+}
+
+// diffLines returns the lines removed from `a` and added in `b`, based on their
+// longest common subsequence
+func diffLines(a, b []string) []string {
+	// lcs[i][j] is the length of the LCS of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			diff = append(diff, "-"+a[i])
+			i++
+		default:
+			diff = append(diff, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		diff = append(diff, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		diff = append(diff, "+"+b[j])
+	}
+	return diff
+}
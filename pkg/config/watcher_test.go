@@ -0,0 +1,163 @@
+package proxyconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/promproxy/pkg/server"
+)
+
+// recordingReloadable records the last config applied to it
+type recordingReloadable struct {
+	cfg *Config
+	// err (if set) is returned by ApplyConfig
+	err error
+}
+
+func (r *recordingReloadable) ApplyConfig(cfg *Config) error {
+	r.cfg = cfg
+	return r.err
+}
+
+func TestReloadHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	writeConfig("promxy:\n  max_series: 10\n")
+	reloadable := &recordingReloadable{}
+	watcher := NewConfigWatcher(path, reloadable)
+	if _, err := watcher.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	h := server.ReloadHandler(watcher)
+	reload := func() (int, []string) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("POST", "/admin/reload", nil))
+		var resp struct {
+			Data struct {
+				Diff []string `json:"diff"`
+			} `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("error decoding response: %v", err)
+		}
+		return w.Code, resp.Data.Diff
+	}
+
+	writeConfig("promxy:\n  max_series: 20\n")
+	code, diff := reload()
+	if code != http.StatusOK {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusOK, code)
+	}
+	expectedDiff := []string{"-  max_series: 10", "+  max_series: 20"}
+	if len(diff) != len(expectedDiff) || diff[0] != expectedDiff[0] || diff[1] != expectedDiff[1] {
+		t.Fatalf("mismatch in diff expected=%v actual=%v", expectedDiff, diff)
+	}
+	if watcher.Current().MaxSeries != 20 || reloadable.cfg.MaxSeries != 20 {
+		t.Fatalf("new config not active")
+	}
+
+	// An invalid config is rejected and the current config stays in place
+	writeConfig("promxy:\n  max_series: [\n")
+	if code, _ := reload(); code != http.StatusBadRequest {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusBadRequest, code)
+	}
+	if watcher.Current().MaxSeries != 20 || reloadable.cfg.MaxSeries != 20 {
+		t.Fatalf("current config replaced by invalid config")
+	}
+}
+
+func TestConfigWatcherReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	writeConfig := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	writeConfig("promxy:\n  max_series: 10\n")
+	reloadable := &recordingReloadable{}
+	watcher := NewConfigWatcher(path, reloadable)
+	if _, err := watcher.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The secrets of the config don't end up in the diff
+	writeConfig("promxy:\n  max_series: 10\n  auth:\n    basic_auth_users:\n      alice: $2a$10$hash\n    bearer_tokens:\n      token-b: bob\n")
+	diff, err := watcher.Reload()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	joined := strings.Join(diff, "\n")
+	if strings.Contains(joined, "$2a$10$hash") || strings.Contains(joined, "token-b") {
+		t.Fatalf("secret in diff: %v", diff)
+	}
+	if !strings.Contains(joined, "alice") || !strings.Contains(joined, "bob") {
+		t.Fatalf("mismatch in diff expected=alice,bob actual=%v", diff)
+	}
+
+	// A config which fails to apply doesn't replace the current config
+	reloadable.err = errors.New("invalid config")
+	writeConfig("promxy:\n  max_series: 20\n")
+	if _, err := watcher.Reload(); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if watcher.Current().MaxSeries != 10 {
+		t.Fatalf("current config replaced by a config which failed to apply")
+	}
+}
+
+// TestConfigWatcherReloadRollback checks that the reloadables which applied a
+// config get the current config back if another one failed to apply it
+func TestConfigWatcherReloadRollback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "promxy")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+
+	if err := ioutil.WriteFile(path, []byte("promxy:\n  max_series: 10\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	applied, failing := &recordingReloadable{}, &recordingReloadable{}
+	watcher := NewConfigWatcher(path, applied, failing)
+	if _, err := watcher.Reload(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failing.err = errors.New("invalid config")
+	if err := ioutil.WriteFile(path, []byte("promxy:\n  max_series: 20\n"), 0644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := watcher.Reload(); err == nil {
+		t.Fatalf("expected an error")
+	}
+	if applied.cfg != watcher.Current() || applied.cfg.MaxSeries != 10 {
+		t.Fatalf("mismatch in applied max_series expected=%d actual=%d", 10, applied.cfg.MaxSeries)
+	}
+}
@@ -0,0 +1,427 @@
+// Package conformance checks that a downstream behaves like Prometheus for the
+// subset of the API promproxy uses, before it is added to a servergroup. The checks
+// only use constant expressions (such as vector(1)) and metrics which don't exist,
+// so they pass against any instance regardless of its data.
+package conformance
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/remote"
+)
+
+// The capabilities checked
+const (
+	CapabilityLabelNames       = "label_names"
+	CapabilityLabelNamesMatch  = "label_names_match"
+	CapabilityLabelValues      = "label_values"
+	CapabilityLabelValuesMatch = "label_values_match"
+	CapabilitySeries           = "series_multiple_selectors"
+	CapabilityInstantVector    = "instant_query_vector"
+	CapabilityInstantScalar    = "instant_query_scalar"
+	CapabilityInstantString    = "instant_query_string"
+	CapabilityRangeQuery       = "range_query"
+	CapabilityLabelReplace     = "query_label_replace"
+	CapabilityAbsent           = "query_absent"
+	CapabilityTime             = "query_time"
+	CapabilityErrorShape       = "error_shape"
+	CapabilityRemoteRead       = "remote_read"
+)
+
+// nonexistentMetric is a metric no instance is expected to have
+const nonexistentMetric = "promproxy_conformance_nonexistent_metric"
+
+// Target is a downstream being checked
+type Target struct {
+	// URL is the base URL of the downstream
+	URL *url.URL
+	// API talks to the downstream through the v1 HTTP API
+	API promclient.API
+	// Client makes raw requests for the parameters the API doesn't support
+	Client api.Client
+	// RemoteRead talks to the downstream through the remote read API
+	RemoteRead promclient.API
+	// Now is the time the queries are evaluated at
+	Now time.Time
+}
+
+// NewTarget returns the Target for the downstream at the given URL
+func NewTarget(u string, client *http.Client) (*Target, error) {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	apiClient, err := api.NewClient(api.Config{Address: u, RoundTripper: client.Transport})
+	if err != nil {
+		return nil, err
+	}
+	var promAPI promclient.API = &promclient.PromAPIV1{v1.NewAPI(apiClient)}
+
+	readURL := *parsed
+	readURL.Path = path.Join(readURL.Path, "api/v1/read")
+	remoteClient, err := remote.NewClient(1, &remote.ClientConfig{
+		URL:     &config_util.URL{URL: &readURL},
+		Timeout: model.Duration(time.Minute),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Target{
+		URL:        parsed,
+		API:        promAPI,
+		Client:     apiClient,
+		RemoteRead: &promclient.PromAPIRemoteRead{promAPI, remoteClient, readURL.String()},
+		Now:        time.Now(),
+	}, nil
+}
+
+// Check checks a single capability of a Target
+type Check struct {
+	Capability string
+	Run        func(ctx context.Context, t *Target) error
+}
+
+// Result is the outcome of a Check
+type Result struct {
+	Capability string
+	Passed     bool
+	// Error is why the check failed
+	Error string
+	Took  time.Duration
+}
+
+// Report is the outcome of all the checks against a target
+type Report struct {
+	Target  string
+	Results []Result
+}
+
+// Passed returns whether all the checks passed
+func (r *Report) Passed() bool {
+	for _, result := range r.Results {
+		if !result.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// Capabilities returns whether the target supports each capability
+func (r *Report) Capabilities() map[string]bool {
+	capabilities := make(map[string]bool, len(r.Results))
+	for _, result := range r.Results {
+		capabilities[result.Capability] = result.Passed
+	}
+	return capabilities
+}
+
+// WriteTo writes the report as a table
+func (r *Report) WriteTo(w io.Writer) (int64, error) {
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "CAPABILITY\tRESULT\tTOOK\tERROR\n")
+	passed := 0
+	for _, result := range r.Results {
+		status := "FAIL"
+		if result.Passed {
+			status = "PASS"
+			passed++
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%v\t%s\n", result.Capability, status, result.Took.Round(time.Millisecond), result.Error)
+	}
+	tw.Flush()
+	fmt.Fprintf(&b, "\n%s: %d/%d checks passed\n", r.Target, passed, len(r.Results))
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// Run runs the checks against the target, each with the given timeout
+func Run(ctx context.Context, t *Target, checks []Check, timeout time.Duration) *Report {
+	report := &Report{Target: t.URL.String()}
+	for _, check := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		err := check.Run(checkCtx, t)
+		cancel()
+
+		result := Result{Capability: check.Capability, Passed: err == nil, Took: time.Since(start)}
+		if err != nil {
+			result.Error = err.Error()
+		}
+		report.Results = append(report.Results, result)
+	}
+	return report
+}
+
+// Command runs the conformance subcommand with the given arguments (without the
+// name of the subcommand), writing the report to w. An error is returned if any
+// check failed.
+func Command(ctx context.Context, args []string, w io.Writer) error {
+	flags := flag.NewFlagSet("conformance", flag.ContinueOnError)
+	flags.SetOutput(w)
+	target := flags.String("target", "", "URL of the downstream to check")
+	timeout := flags.Duration("timeout", 30*time.Second, "timeout of each check")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *target == "" {
+		return fmt.Errorf("--target is required")
+	}
+
+	t, err := NewTarget(*target, nil)
+	if err != nil {
+		return err
+	}
+	report := Run(ctx, t, DefaultChecks, *timeout)
+	if _, err := report.WriteTo(w); err != nil {
+		return err
+	}
+	if !report.Passed() {
+		return fmt.Errorf("%s failed conformance checks", *target)
+	}
+	return nil
+}
+
+// DefaultChecks are the checks run by the conformance subcommand
+var DefaultChecks = []Check{
+	{CapabilityLabelNames, checkLabelNames},
+	{CapabilityLabelNamesMatch, checkRawList("/api/v1/labels", nonexistentMetric)},
+	{CapabilityLabelValues, checkLabelValues},
+	{CapabilityLabelValuesMatch, checkRawList("/api/v1/label/__name__/values", nonexistentMetric)},
+	{CapabilitySeries, checkSeries},
+	{CapabilityInstantVector, checkVector("vector(1)", model.Metric{}, 1)},
+	{CapabilityInstantScalar, checkScalar},
+	{CapabilityInstantString, checkString},
+	{CapabilityRangeQuery, checkRangeQuery},
+	{CapabilityLabelReplace, checkVector(`label_replace(vector(1), "foo", "bar", "", "")`, model.Metric{"foo": "bar"}, 1)},
+	{CapabilityAbsent, checkVector(`absent(`+nonexistentMetric+`{job="conformance"})`, model.Metric{"job": "conformance"}, 1)},
+	{CapabilityTime, checkTime},
+	{CapabilityErrorShape, checkErrorShape},
+	{CapabilityRemoteRead, checkRemoteRead},
+}
+
+func checkLabelNames(ctx context.Context, t *Target) error {
+	names, _, err := t.API.LabelNames(ctx)
+	if err != nil {
+		return err
+	}
+	if !sort.StringsAreSorted(names) {
+		return fmt.Errorf("label names aren't sorted")
+	}
+	return nil
+}
+
+func checkLabelValues(ctx context.Context, t *Target) error {
+	values, _, err := t.API.LabelValues(ctx, model.MetricNameLabel)
+	if err != nil {
+		return err
+	}
+	if !sort.IsSorted(values) {
+		return fmt.Errorf("label values aren't sorted")
+	}
+	return nil
+}
+
+// checkRawList checks that the list endpoint accepts a match[] parameter, the
+// selector matches nothing so the list must be empty
+func checkRawList(endpoint, match string) func(ctx context.Context, t *Target) error {
+	return func(ctx context.Context, t *Target) error {
+		u := t.Client.URL(endpoint, nil)
+		q := u.Query()
+		q.Set("match[]", match)
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return err
+		}
+		resp, body, _, err := t.Client.Do(ctx, req)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("unexpected status %s", resp.Status)
+		}
+
+		var envelope struct {
+			Status string   `json:"status"`
+			Data   []string `json:"data"`
+		}
+		if err := json.Unmarshal(body, &envelope); err != nil {
+			return errors.Wrap(err, "invalid response")
+		}
+		if envelope.Status != "success" {
+			return fmt.Errorf("unexpected status %q", envelope.Status)
+		}
+		if len(envelope.Data) != 0 {
+			return fmt.Errorf("match[] wasn't applied, expected no results got %d", len(envelope.Data))
+		}
+		return nil
+	}
+}
+
+func checkSeries(ctx context.Context, t *Target) error {
+	series, _, err := t.API.Series(ctx, []string{nonexistentMetric, `{__name__="` + nonexistentMetric + `", job="conformance"}`}, t.Now.Add(-time.Hour), t.Now)
+	if err != nil {
+		return err
+	}
+	if len(series) != 0 {
+		return fmt.Errorf("expected no series got %d", len(series))
+	}
+	return nil
+}
+
+// checkVector checks that the instant query returns a single sample with the
+// given metric and value
+func checkVector(query string, metric model.Metric, value model.SampleValue) func(ctx context.Context, t *Target) error {
+	return func(ctx context.Context, t *Target) error {
+		v, _, err := t.API.Query(ctx, query, t.Now)
+		if err != nil {
+			return err
+		}
+		vector, ok := v.(model.Vector)
+		if !ok {
+			return fmt.Errorf("expected a vector got %v", valueType(v))
+		}
+		if len(vector) != 1 {
+			return fmt.Errorf("expected 1 sample got %d", len(vector))
+		}
+		if !vector[0].Metric.Equal(metric) || vector[0].Value != value {
+			return fmt.Errorf("expected %v => %v got %v => %v", metric, value, vector[0].Metric, vector[0].Value)
+		}
+		return nil
+	}
+}
+
+func checkScalar(ctx context.Context, t *Target) error {
+	v, _, err := t.API.Query(ctx, "scalar(vector(2))", t.Now)
+	if err != nil {
+		return err
+	}
+	scalar, ok := v.(*model.Scalar)
+	if !ok {
+		return fmt.Errorf("expected a scalar got %v", valueType(v))
+	}
+	if scalar.Value != 2 {
+		return fmt.Errorf("expected 2 got %v", scalar.Value)
+	}
+	return nil
+}
+
+func checkString(ctx context.Context, t *Target) error {
+	v, _, err := t.API.Query(ctx, `"conformance"`, t.Now)
+	if err != nil {
+		return err
+	}
+	str, ok := v.(*model.String)
+	if !ok {
+		return fmt.Errorf("expected a string got %v", valueType(v))
+	}
+	if str.Value != "conformance" {
+		return fmt.Errorf("expected %q got %q", "conformance", str.Value)
+	}
+	return nil
+}
+
+func checkRangeQuery(ctx context.Context, t *Target) error {
+	r := v1.Range{Start: t.Now.Add(-time.Minute).Truncate(time.Second), Step: 15 * time.Second}
+	r.End = r.Start.Add(time.Minute)
+	v, _, err := t.API.QueryRange(ctx, "vector(1)", r)
+	if err != nil {
+		return err
+	}
+	matrix, ok := v.(model.Matrix)
+	if !ok {
+		return fmt.Errorf("expected a matrix got %v", valueType(v))
+	}
+	if len(matrix) != 1 {
+		return fmt.Errorf("expected 1 series got %d", len(matrix))
+	}
+	// The steps include both the start and the end
+	if len(matrix[0].Values) != 5 {
+		return fmt.Errorf("expected 5 samples got %d", len(matrix[0].Values))
+	}
+	for i, sample := range matrix[0].Values {
+		if expected := model.TimeFromUnixNano(r.Start.Add(time.Duration(i) * r.Step).UnixNano()); sample.Timestamp != expected || sample.Value != 1 {
+			return fmt.Errorf("expected sample %d to be 1 @%v got %v", i, expected, sample)
+		}
+	}
+	return nil
+}
+
+func checkTime(ctx context.Context, t *Target) error {
+	ts := t.Now.Truncate(time.Second)
+	v, _, err := t.API.Query(ctx, "time()", ts)
+	if err != nil {
+		return err
+	}
+	scalar, ok := v.(*model.Scalar)
+	if !ok {
+		return fmt.Errorf("expected a scalar got %v", valueType(v))
+	}
+	if int64(scalar.Value) != ts.Unix() {
+		return fmt.Errorf("expected %d got %v", ts.Unix(), scalar.Value)
+	}
+	return nil
+}
+
+// checkErrorShape checks that an invalid query fails with a bad_data error
+func checkErrorShape(ctx context.Context, t *Target) error {
+	_, _, err := t.API.Query(ctx, "sum(", t.Now)
+	if err == nil {
+		return fmt.Errorf("expected an error for an invalid query")
+	}
+	apiErr, ok := errors.Cause(err).(*v1.Error)
+	if !ok {
+		return fmt.Errorf("expected an API error got %T: %v", errors.Cause(err), err)
+	}
+	if apiErr.Type != v1.ErrBadData {
+		return fmt.Errorf("expected error type %s got %s", v1.ErrBadData, apiErr.Type)
+	}
+	return nil
+}
+
+func checkRemoteRead(ctx context.Context, t *Target) error {
+	matchers := []*labels.Matcher{{Type: labels.MatchEqual, Name: model.MetricNameLabel, Value: nonexistentMetric}}
+	v, _, err := t.RemoteRead.GetValue(ctx, t.Now.Add(-time.Minute), t.Now, matchers)
+	if err != nil {
+		return err
+	}
+	matrix, ok := v.(model.Matrix)
+	if !ok {
+		return fmt.Errorf("expected a matrix got %v", valueType(v))
+	}
+	if len(matrix) != 0 {
+		return fmt.Errorf("expected no series got %d", len(matrix))
+	}
+	return nil
+}
+
+func valueType(v model.Value) string {
+	if v == nil {
+		return "nothing"
+	}
+	return v.Type().String()
+}
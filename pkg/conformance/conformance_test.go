@@ -0,0 +1,172 @@
+package conformance
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// queryAPI responds to queries with the value (or error) configured for the query
+type queryAPI struct {
+	promclient.API
+	values map[string]model.Value
+	err    error
+}
+
+func (q *queryAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if q.err != nil {
+		return nil, nil, q.err
+	}
+	if query == "time()" {
+		return &model.Scalar{Value: model.SampleValue(ts.Unix()), Timestamp: model.TimeFromUnixNano(ts.UnixNano())}, nil, nil
+	}
+	return q.values[query], nil, nil
+}
+
+func TestChecks(t *testing.T) {
+	now := time.Unix(1000, 0)
+	labelReplace := `label_replace(vector(1), "foo", "bar", "", "")`
+
+	tests := []struct {
+		name   string
+		check  func(context.Context, *Target) error
+		api    *queryAPI
+		passed bool
+	}{
+		{
+			name:   "vector",
+			check:  checkVector("vector(1)", model.Metric{}, 1),
+			api:    &queryAPI{values: map[string]model.Value{"vector(1)": model.Vector{{Metric: model.Metric{}, Value: 1}}}},
+			passed: true,
+		},
+		{
+			name:   "vector wrong type",
+			check:  checkVector("vector(1)", model.Metric{}, 1),
+			api:    &queryAPI{values: map[string]model.Value{"vector(1)": &model.Scalar{Value: 1}}},
+			passed: false,
+		},
+		{
+			name:   "label_replace",
+			check:  checkVector(labelReplace, model.Metric{"foo": "bar"}, 1),
+			api:    &queryAPI{values: map[string]model.Value{labelReplace: model.Vector{{Metric: model.Metric{"foo": "bar"}, Value: 1}}}},
+			passed: true,
+		},
+		{
+			name:   "label_replace missing label",
+			check:  checkVector(labelReplace, model.Metric{"foo": "bar"}, 1),
+			api:    &queryAPI{values: map[string]model.Value{labelReplace: model.Vector{{Metric: model.Metric{}, Value: 1}}}},
+			passed: false,
+		},
+		{
+			name:   "scalar",
+			check:  checkScalar,
+			api:    &queryAPI{values: map[string]model.Value{"scalar(vector(2))": &model.Scalar{Value: 2}}},
+			passed: true,
+		},
+		{
+			name:   "time",
+			check:  checkTime,
+			api:    &queryAPI{},
+			passed: true,
+		},
+		{
+			name:   "error shape",
+			check:  checkErrorShape,
+			api:    &queryAPI{err: &v1.Error{Type: v1.ErrBadData, Msg: "parse error"}},
+			passed: true,
+		},
+		{
+			name:   "error shape wrong type",
+			check:  checkErrorShape,
+			api:    &queryAPI{err: &v1.Error{Type: v1.ErrServer, Msg: "internal error"}},
+			passed: false,
+		},
+		{
+			name:   "error shape not an API error",
+			check:  checkErrorShape,
+			api:    &queryAPI{err: fmt.Errorf("connection reset")},
+			passed: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := test.check(context.TODO(), &Target{API: test.api, Now: now})
+			if passed := err == nil; passed != test.passed {
+				t.Fatalf("mismatch in passed expected=%v actual=%v (%v)", test.passed, passed, err)
+			}
+		})
+	}
+}
+
+func TestCheckRawList(t *testing.T) {
+	tests := []struct {
+		response string
+		passed   bool
+	}{
+		{response: `{"status":"success","data":[]}`, passed: true},
+		// The match[] was ignored
+		{response: `{"status":"success","data":["__name__","job"]}`, passed: false},
+		{response: `not json`, passed: false},
+	}
+
+	for i, test := range tests {
+		var match string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			match = r.URL.Query().Get("match[]")
+			w.Write([]byte(test.response))
+		}))
+		client, err := api.NewClient(api.Config{Address: srv.URL})
+		if err != nil {
+			t.Fatalf("error creating client: %v", err)
+		}
+
+		err = checkRawList("/api/v1/labels", nonexistentMetric)(context.TODO(), &Target{Client: client})
+		srv.Close()
+		if passed := err == nil; passed != test.passed {
+			t.Fatalf("%d: mismatch in passed expected=%v actual=%v (%v)", i, test.passed, passed, err)
+		}
+		if match != nonexistentMetric {
+			t.Fatalf("%d: mismatch in match expected=%s actual=%s", i, nonexistentMetric, match)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	u, _ := url.Parse("http://prometheus:9090")
+	checks := []Check{
+		{"ok", func(context.Context, *Target) error { return nil }},
+		{"broken", func(context.Context, *Target) error { return fmt.Errorf("unsupported") }},
+	}
+
+	report := Run(context.TODO(), &Target{URL: u}, checks, time.Second)
+	if report.Passed() {
+		t.Fatalf("expected the report to fail")
+	}
+	expected := map[string]bool{"ok": true, "broken": false}
+	if capabilities := report.Capabilities(); !reflect.DeepEqual(capabilities, expected) {
+		t.Fatalf("mismatch in capabilities expected=%v actual=%v", expected, capabilities)
+	}
+
+	var b strings.Builder
+	if _, err := report.WriteTo(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range []string{"broken", "FAIL", "unsupported", "http://prometheus:9090: 1/2 checks passed"} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("missing %q in report:\n%s", s, b.String())
+		}
+	}
+}
@@ -0,0 +1,402 @@
+// Package consulsd discovers the hosts of a servergroup from the healthy instances
+// of a Consul service. Compared to the consul_sd_configs of Prometheus it re-reads
+// the ACL token from a file, damps flapping instances and keeps the last known
+// hosts while Consul can't be reached.
+package consulsd
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/prometheus/client_golang/prometheus"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/util/strutil"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/metrics"
+)
+
+// The defaults of an SDConfig
+const (
+	DefaultServer        = "localhost:8500"
+	DefaultScheme        = "http"
+	DefaultWatchTimeout  = 2 * time.Minute
+	DefaultRetryInterval = 15 * time.Second
+	DefaultDebounce      = 30 * time.Second
+)
+
+// The labels of the discovered targets, in addition to the address. These are
+// private (so dropped from the results) unless relabeled, see also MetaLabels.
+const (
+	metaLabelPrefix        = model.MetaLabelPrefix + "consul_"
+	nodeLabel              = metaLabelPrefix + "node"
+	datacenterLabel        = metaLabelPrefix + "dc"
+	serviceLabel           = metaLabelPrefix + "service"
+	serviceIDLabel         = metaLabelPrefix + "service_id"
+	tagsLabel              = metaLabelPrefix + "tags"
+	nodeMetaLabelPrefix    = metaLabelPrefix + "node_meta_"
+	serviceMetaLabelPrefix = metaLabelPrefix + "service_meta_"
+	// tagSeparator joins the tags, it also leads and trails them so a tag can be
+	// matched with a regex like .*,tag,.*
+	tagSeparator = ","
+)
+
+var (
+	discoveryErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "consul_sd_errors_total",
+		Help:      "Number of failed queries of the Consul service discovery",
+	}, []string{"service"})
+
+	discoveryFailing = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "consul_sd_failing",
+		Help:      "Whether the last query of the Consul service discovery failed, so the last known hosts are used",
+	}, []string{"service"})
+
+	discoveredHosts = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "consul_sd_hosts",
+		Help:      "Number of hosts discovered from Consul (including those kept while flapping)",
+	}, []string{"service"})
+)
+
+func init() {
+	metrics.MustRegister(discoveryErrorsTotal, discoveryFailing, discoveredHosts)
+}
+
+// DefaultSDConfig is the default SDConfig
+var DefaultSDConfig = SDConfig{
+	Server:        DefaultServer,
+	Scheme:        DefaultScheme,
+	WatchTimeout:  DefaultWatchTimeout,
+	RetryInterval: DefaultRetryInterval,
+	Debounce:      DefaultDebounce,
+}
+
+// SDConfig configures the discovery of the hosts of a servergroup from a Consul service
+type SDConfig struct {
+	// Server is the address of the Consul agent
+	Server string `yaml:"server"`
+	// Scheme is used to talk to the Consul agent (http or https)
+	Scheme string `yaml:"scheme"`
+	// Datacenter to query, the datacenter of the agent if empty
+	Datacenter string `yaml:"datacenter,omitempty"`
+	// Service is the name of the service whose healthy instances are the hosts
+	Service string `yaml:"service"`
+	// Tags filter the instances to those having all of the tags
+	Tags []string `yaml:"tags,omitempty"`
+	// Token is the ACL token
+	Token config_util.Secret `yaml:"token,omitempty"`
+	// TokenFile is read for the ACL token before every query, so a rotated token
+	// is picked up without a reload. Mutually exclusive with Token.
+	TokenFile string `yaml:"token_file,omitempty"`
+	// MetaLabels are the keys of the Consul metadata which are added as labels (of
+	// the same name) to the results of each host. The service metadata takes
+	// precedence over the node metadata.
+	MetaLabels []string `yaml:"meta_labels,omitempty"`
+	// WatchTimeout is how long a blocking query waits for a change
+	WatchTimeout time.Duration `yaml:"watch_timeout"`
+	// RetryInterval is how long to wait after a failed query
+	RetryInterval time.Duration `yaml:"retry_interval"`
+	// Debounce is how long a host has to be gone (unhealthy or deregistered) before
+	// it is removed, so a flapping instance doesn't churn the servergroup. New
+	// hosts are added immediately.
+	Debounce time.Duration `yaml:"debounce"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *SDConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultSDConfig
+	type plain SDConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c SDConfig) Validate() error {
+	if strings.TrimSpace(c.Service) == "" {
+		return fmt.Errorf("consul service must be set")
+	}
+	if c.Token != "" && c.TokenFile != "" {
+		return fmt.Errorf("at most one of consul token and token_file must be set")
+	}
+	for _, name := range c.MetaLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("consul meta label %q isn't a valid label name", name)
+		}
+	}
+	if c.WatchTimeout <= 0 || c.RetryInterval <= 0 {
+		return fmt.Errorf("consul watch_timeout and retry_interval must be positive")
+	}
+	if c.Debounce < 0 {
+		return fmt.Errorf("consul debounce must not be negative")
+	}
+	return nil
+}
+
+// NewDiscovery returns a Discovery for the given config
+func NewDiscovery(cfg *SDConfig) (*Discovery, error) {
+	client, err := consul.NewClient(&consul.Config{
+		Address:    cfg.Server,
+		Scheme:     cfg.Scheme,
+		Datacenter: cfg.Datacenter,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Discovery{
+		cfg:      cfg,
+		health:   client.Health(),
+		debounce: newDebouncer(cfg.Debounce),
+		logger:   logrus.WithField("consul_service", cfg.Service),
+		now:      time.Now,
+	}, nil
+}
+
+// Discovery maintains the hosts of a Consul service with blocking queries. It
+// implements the discovery.Discoverer interface of Prometheus.
+type Discovery struct {
+	cfg      *SDConfig
+	health   *consul.Health
+	debounce *debouncer
+	logger   *logrus.Entry
+	now      func() time.Time
+
+	// token is the last token read from the TokenFile
+	token string
+}
+
+// getToken returns the ACL token, reading it from the TokenFile if configured. If
+// the file can't be read the last token is used
+func (d *Discovery) getToken() string {
+	if d.cfg.TokenFile == "" {
+		return string(d.cfg.Token)
+	}
+	b, err := ioutil.ReadFile(d.cfg.TokenFile)
+	if err != nil {
+		d.logger.Errorf("Error reading the consul token file, using the last token: %v", err)
+		return d.token
+	}
+	d.token = strings.TrimSpace(string(b))
+	return d.token
+}
+
+// Run implements discovery.Discoverer, it sends the target group of the service
+// whenever its hosts changed
+func (d *Discovery) Run(ctx context.Context, ch chan<- []*targetgroup.Group) {
+	var index uint64
+	sent := false
+	for {
+		// Wake up in time to remove the hosts whose debounce expires
+		wait := d.cfg.WatchTimeout
+		if expiry := d.debounce.nextExpiry(); !expiry.IsZero() {
+			if untilExpiry := expiry.Sub(d.now()); untilExpiry < wait {
+				wait = untilExpiry
+			}
+		}
+		if wait < time.Second {
+			wait = time.Second
+		}
+
+		opts := &consul.QueryOptions{
+			WaitIndex:  index,
+			WaitTime:   wait,
+			Token:      d.getToken(),
+			AllowStale: true,
+		}
+		entries, meta, err := d.health.Service(d.cfg.Service, "", true, opts.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			// The last known hosts are kept, rather than emptying the servergroup
+			d.logger.Errorf("Error querying consul, keeping the last known hosts: %v", err)
+			discoveryErrorsTotal.WithLabelValues(d.cfg.Service).Inc()
+			discoveryFailing.WithLabelValues(d.cfg.Service).Set(1)
+			select {
+			case <-time.After(d.cfg.RetryInterval):
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		discoveryFailing.WithLabelValues(d.cfg.Service).Set(0)
+
+		// The index is reset if it went backwards (e.g. the raft snapshot of the
+		// servers was restored), see the consul docs on blocking queries
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		if changed := d.debounce.update(d.now(), d.targets(entries)); changed || !sent {
+			group := d.group()
+			discoveredHosts.WithLabelValues(d.cfg.Service).Set(float64(len(group.Targets)))
+			select {
+			case ch <- []*targetgroup.Group{group}:
+				sent = true
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// group returns the target group of the current hosts
+func (d *Discovery) group() *targetgroup.Group {
+	return &targetgroup.Group{
+		Source:  "consul/" + d.cfg.Service,
+		Targets: d.debounce.targets(),
+	}
+}
+
+// targets returns the targets of the entries (which have all the Tags) by address
+func (d *Discovery) targets(entries []*consul.ServiceEntry) map[string]model.LabelSet {
+	targets := make(map[string]model.LabelSet, len(entries))
+ENTRIES:
+	for _, entry := range entries {
+		for _, tag := range d.cfg.Tags {
+			if !hasTag(entry.Service.Tags, tag) {
+				continue ENTRIES
+			}
+		}
+		target := entryTarget(entry, d.cfg.MetaLabels)
+		targets[string(target[model.AddressLabel])] = target
+	}
+	return targets
+}
+
+func hasTag(tags []string, tag string) bool {
+	for _, t := range tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// entryTarget returns the target of a service instance. The address is that of the
+// service, falling back to the address of the node if the service has none.
+func entryTarget(entry *consul.ServiceEntry, metaLabels []string) model.LabelSet {
+	host := entry.Service.Address
+	if host == "" {
+		host = entry.Node.Address
+	}
+	target := model.LabelSet{
+		model.AddressLabel: model.LabelValue(net.JoinHostPort(host, strconv.Itoa(entry.Service.Port))),
+		nodeLabel:          model.LabelValue(entry.Node.Node),
+		datacenterLabel:    model.LabelValue(entry.Node.Datacenter),
+		serviceLabel:       model.LabelValue(entry.Service.Service),
+		serviceIDLabel:     model.LabelValue(entry.Service.ID),
+		tagsLabel:          model.LabelValue(tagSeparator + strings.Join(entry.Service.Tags, tagSeparator) + tagSeparator),
+	}
+	for k, v := range entry.Node.Meta {
+		target[model.LabelName(nodeMetaLabelPrefix+strutil.SanitizeLabelName(k))] = model.LabelValue(v)
+	}
+	for k, v := range entry.Service.Meta {
+		target[model.LabelName(serviceMetaLabelPrefix+strutil.SanitizeLabelName(k))] = model.LabelValue(v)
+	}
+	for _, name := range metaLabels {
+		if v, ok := entry.Service.Meta[name]; ok {
+			target[model.LabelName(name)] = model.LabelValue(v)
+		} else if v, ok := entry.Node.Meta[name]; ok {
+			target[model.LabelName(name)] = model.LabelValue(v)
+		}
+	}
+	return target
+}
+
+func newDebouncer(delay time.Duration) *debouncer {
+	return &debouncer{
+		delay:   delay,
+		current: make(map[string]model.LabelSet),
+		missing: make(map[string]time.Time),
+	}
+}
+
+// debouncer damps the removal of targets: a target which is gone is kept until it
+// has been gone for the delay, if it comes back before it is never removed
+type debouncer struct {
+	mu    sync.Mutex
+	delay time.Duration
+	// current are the targets by address
+	current map[string]model.LabelSet
+	// missing are the times since which the targets of current have been gone
+	missing map[string]time.Time
+}
+
+// update applies the discovered targets at now, returning whether the current
+// targets changed
+func (d *debouncer) update(now time.Time, discovered map[string]model.LabelSet) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	changed := false
+	for addr, target := range discovered {
+		delete(d.missing, addr)
+		if current, ok := d.current[addr]; !ok || !current.Equal(target) {
+			d.current[addr] = target
+			changed = true
+		}
+	}
+	for addr := range d.current {
+		if _, ok := discovered[addr]; ok {
+			continue
+		}
+		since, ok := d.missing[addr]
+		if !ok {
+			since = now
+			d.missing[addr] = now
+		}
+		if now.Sub(since) >= d.delay {
+			delete(d.current, addr)
+			delete(d.missing, addr)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// nextExpiry returns when the next missing target is due to be removed, zero if
+// none is missing
+func (d *debouncer) nextExpiry() time.Time {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var next time.Time
+	for _, since := range d.missing {
+		if expiry := since.Add(d.delay); next.IsZero() || expiry.Before(next) {
+			next = expiry
+		}
+	}
+	return next
+}
+
+// targets returns the current targets, sorted by address
+func (d *debouncer) targets() []model.LabelSet {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	addrs := make([]string, 0, len(d.current))
+	for addr := range d.current {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	targets := make([]model.LabelSet, len(addrs))
+	for i, addr := range addrs {
+		targets[i] = d.current[addr].Clone()
+	}
+	return targets
+}
@@ -0,0 +1,207 @@
+package consulsd
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consul "github.com/hashicorp/consul/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/sirupsen/logrus"
+)
+
+func TestDebouncer(t *testing.T) {
+	a := model.LabelSet{model.AddressLabel: "a:9090"}
+	b := model.LabelSet{model.AddressLabel: "b:9090"}
+	bTagged := model.LabelSet{model.AddressLabel: "b:9090", tagsLabel: ",prod,"}
+	d := newDebouncer(10 * time.Second)
+	now := time.Unix(0, 0)
+
+	tests := []struct {
+		advance    time.Duration
+		discovered []model.LabelSet
+		changed    bool
+		targets    []model.LabelSet
+	}{
+		// New targets are added immediately
+		{discovered: []model.LabelSet{a, b}, changed: true, targets: []model.LabelSet{a, b}},
+		{discovered: []model.LabelSet{a, b}, changed: false, targets: []model.LabelSet{a, b}},
+		// A target which is gone is kept within the delay
+		{advance: time.Second, discovered: []model.LabelSet{a}, changed: false, targets: []model.LabelSet{a, b}},
+		// So flapping back doesn't change anything
+		{advance: 5 * time.Second, discovered: []model.LabelSet{a, b}, changed: false, targets: []model.LabelSet{a, b}},
+		// The delay starts again once it is gone again
+		{advance: time.Second, discovered: []model.LabelSet{a}, changed: false, targets: []model.LabelSet{a, b}},
+		{advance: 9 * time.Second, discovered: []model.LabelSet{a}, changed: false, targets: []model.LabelSet{a, b}},
+		{advance: time.Second, discovered: []model.LabelSet{a}, changed: true, targets: []model.LabelSet{a}},
+		// Changes of the labels apply immediately
+		{advance: time.Second, discovered: []model.LabelSet{a, b}, changed: true, targets: []model.LabelSet{a, b}},
+		{advance: time.Second, discovered: []model.LabelSet{a, bTagged}, changed: true, targets: []model.LabelSet{a, bTagged}},
+	}
+
+	for i, test := range tests {
+		now = now.Add(test.advance)
+		discovered := make(map[string]model.LabelSet)
+		for _, target := range test.discovered {
+			discovered[string(target[model.AddressLabel])] = target
+		}
+		if changed := d.update(now, discovered); changed != test.changed {
+			t.Fatalf("%d: mismatch in changed expected=%v actual=%v", i, test.changed, changed)
+		}
+		if targets := d.targets(); !reflect.DeepEqual(targets, test.targets) {
+			t.Fatalf("%d: mismatch in targets expected=%v actual=%v", i, test.targets, targets)
+		}
+	}
+}
+
+func testEntry(node, addr string, port int, tags []string, nodeMeta, serviceMeta map[string]string) *consul.ServiceEntry {
+	return &consul.ServiceEntry{
+		Node: &consul.Node{Node: node, Address: "10.0.0.1", Datacenter: "dc1", Meta: nodeMeta},
+		Service: &consul.AgentService{
+			ID:      "prometheus-" + node,
+			Service: "prometheus",
+			Address: addr,
+			Port:    port,
+			Tags:    tags,
+			Meta:    serviceMeta,
+		},
+	}
+}
+
+func TestTargets(t *testing.T) {
+	d := &Discovery{cfg: &SDConfig{Tags: []string{"prod"}, MetaLabels: []string{"region", "shard"}}}
+	entries := []*consul.ServiceEntry{
+		testEntry("node1", "", 9090, []string{"prod", "v2"}, map[string]string{"region": "eu", "rack-id": "r1"}, map[string]string{"region": "eu-west"}),
+		testEntry("node2", "10.0.0.2", 9091, []string{"prod"}, nil, map[string]string{"shard": "2"}),
+		// Missing the prod tag
+		testEntry("node3", "10.0.0.3", 9090, []string{"staging"}, nil, nil),
+	}
+
+	expected := map[string]model.LabelSet{
+		"10.0.0.1:9090": {
+			model.AddressLabel:                "10.0.0.1:9090",
+			nodeLabel:                         "node1",
+			datacenterLabel:                   "dc1",
+			serviceLabel:                      "prometheus",
+			serviceIDLabel:                    "prometheus-node1",
+			tagsLabel:                         ",prod,v2,",
+			nodeMetaLabelPrefix + "region":    "eu",
+			nodeMetaLabelPrefix + "rack_id":   "r1",
+			serviceMetaLabelPrefix + "region": "eu-west",
+			"region":                          "eu-west",
+		},
+		"10.0.0.2:9091": {
+			model.AddressLabel:               "10.0.0.2:9091",
+			nodeLabel:                        "node2",
+			datacenterLabel:                  "dc1",
+			serviceLabel:                     "prometheus",
+			serviceIDLabel:                   "prometheus-node2",
+			tagsLabel:                        ",prod,",
+			serviceMetaLabelPrefix + "shard": "2",
+			"shard":                          "2",
+		},
+	}
+	if targets := d.targets(entries); !reflect.DeepEqual(targets, expected) {
+		t.Fatalf("mismatch in targets expected=%v actual=%v", expected, targets)
+	}
+}
+
+func TestTokenFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consulsd")
+	if err != nil {
+		t.Fatalf("error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+
+	d := &Discovery{cfg: &SDConfig{TokenFile: tokenFile}, logger: logrus.WithField("consul_service", "test")}
+	for _, token := range []string{"first", "rotated"} {
+		if err := ioutil.WriteFile(tokenFile, []byte(token+"\n"), 0600); err != nil {
+			t.Fatalf("error writing token file: %v", err)
+		}
+		if actual := d.getToken(); actual != token {
+			t.Fatalf("mismatch in token expected=%s actual=%s", token, actual)
+		}
+	}
+
+	// The last token is kept if the file can't be read
+	os.Remove(tokenFile)
+	if actual := d.getToken(); actual != "rotated" {
+		t.Fatalf("mismatch in token expected=%s actual=%s", "rotated", actual)
+	}
+}
+
+func TestRunKeepsHostsOnError(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		n := requests
+		mu.Unlock()
+
+		if !strings.HasPrefix(r.URL.Path, "/v1/health/service/prometheus") {
+			http.NotFound(w, r)
+			return
+		}
+		if n > 1 {
+			http.Error(w, "no cluster leader", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "5")
+		w.Header().Set("X-Consul-LastContact", "0")
+		w.Header().Set("X-Consul-KnownLeader", "true")
+		json.NewEncoder(w).Encode([]*consul.ServiceEntry{
+			testEntry("node1", "10.0.0.1", 9090, nil, nil, nil),
+			testEntry("node2", "10.0.0.2", 9090, nil, nil, nil),
+		})
+	}))
+	defer srv.Close()
+
+	cfg := DefaultSDConfig
+	cfg.Server = strings.TrimPrefix(srv.URL, "http://")
+	cfg.Service = "prometheus"
+	cfg.RetryInterval = 10 * time.Millisecond
+	d, err := NewDiscovery(&cfg)
+	if err != nil {
+		t.Fatalf("error creating discovery: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := make(chan []*targetgroup.Group, 10)
+	go d.Run(ctx, ch)
+
+	select {
+	case groups := <-ch:
+		if len(groups) != 1 || len(groups[0].Targets) != 2 {
+			t.Fatalf("mismatch in targets expected=%d actual=%v", 2, groups)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timeout waiting for the targets")
+	}
+
+	// The failing queries don't replace the hosts
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	n := requests
+	mu.Unlock()
+	if n < 3 {
+		t.Fatalf("expected the failed queries to be retried, got %d requests", n)
+	}
+	select {
+	case groups := <-ch:
+		t.Fatalf("unexpected update after errors: %v", groups)
+	default:
+	}
+}
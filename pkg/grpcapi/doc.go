@@ -0,0 +1,9 @@
+// Package grpcapi is a gRPC API exposing the merged view of the downstreams
+// (Query, QueryRange, Series, LabelNames and LabelValues) for consumers which want
+// to avoid the overhead of HTTP/JSON.
+//
+// The messages and the service stubs (NewQueryClient and RegisterQueryServer) are
+// generated from query.proto.
+package grpcapi
+
+//go:generate protoc --gogo_out=plugins=grpc:. query.proto
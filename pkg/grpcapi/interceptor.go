@@ -0,0 +1,111 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/server"
+)
+
+// Options are the auth and limits of the HTTP API, which apply to the gRPC calls
+// as well
+type Options struct {
+	// Auth authenticates the calls with the credentials of their authorization
+	// metadata and their TLS client certificate, as the HTTP requests are with
+	// their Authorization header
+	Auth server.ServerAuthConfig
+	// RateLimiter (if set) rate limits the calls, it may be shared with the HTTP API
+	// so both count against the same limit
+	RateLimiter *server.RateLimiter
+	// MaxQueryTimeout (if set) bounds the deadline of the calls, and is the
+	// timeout of the calls without one
+	MaxQueryTimeout time.Duration
+}
+
+// interceptor returns the interceptor of the options, which runs the calls through
+// the auth, tenancy and limits in the order of the stages of the HTTP middlewares
+func (o Options) interceptor() (grpc.UnaryServerInterceptor, error) {
+	auth, err := server.NewAuthenticator(o.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return chainUnaryInterceptors(
+		authInterceptor(auth),
+		tenancyInterceptor,
+		limitsInterceptor(o.RateLimiter, o.MaxQueryTimeout),
+	), nil
+}
+
+// chainUnaryInterceptors returns the interceptor calling the interceptors in order,
+// the last one calling the handler
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		next := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, inner := interceptors[i], next
+			next = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, inner)
+			}
+		}
+		return next(ctx, req)
+	}
+}
+
+// authInterceptor authenticates the calls (see server.AuthMiddleware)
+func authInterceptor(auth *server.Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		var authorization string
+		if md, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := md.Get("authorization"); len(values) > 0 {
+				authorization = values[0]
+			}
+		}
+		var state *tls.ConnectionState
+		if p, ok := peer.FromContext(ctx); ok {
+			if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+				state = &tlsInfo.State
+			}
+		}
+
+		ctx, err := auth.Authenticate(ctx, authorization, state)
+		if err != nil {
+			return nil, statusError(err)
+		}
+		return handler(ctx, req)
+	}
+}
+
+// tenancyInterceptor sets the tenant of authenticated calls to their identity (see
+// server.TenancyMiddleware)
+func tenancyInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if id := server.IdentityFromContext(ctx); id != nil {
+		ctx = promclient.WithTenant(ctx, id.Name)
+	}
+	return handler(ctx, req)
+}
+
+// limitsInterceptor rejects the calls over the limit of the limiter (if any) and
+// bounds their deadline to maxQueryTimeout (if set), see server.RateLimitMiddleware
+// and server.QueryTimeoutLimitMiddleware
+func limitsInterceptor(limiter *server.RateLimiter, maxQueryTimeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if limiter != nil {
+			if after, ok := limiter.Allow(); !ok {
+				return nil, statusError(&promclient.ErrRateLimited{After: after})
+			}
+		}
+		if maxQueryTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, maxQueryTimeout)
+			defer cancel()
+		}
+		return handler(ctx, req)
+	}
+}
@@ -0,0 +1,274 @@
+package grpcapi
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// Config configures the gRPC server, which listens on its own address
+type Config struct {
+	// ListenAddress is the address the gRPC server listens on
+	ListenAddress string `yaml:"listen_address"`
+	// TLS (if set) serves over TLS. A client CA requires the clients to present
+	// a certificate signed by it
+	TLS *TLSConfig `yaml:"tls"`
+}
+
+// TLSConfig is the TLS config of the gRPC server
+type TLSConfig struct {
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile (if set) is the CA the client certificates must be signed by
+	ClientCAFile string `yaml:"client_ca_file"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c *Config) Validate() error {
+	if c.ListenAddress == "" {
+		return fmt.Errorf("grpc listen_address is required")
+	}
+	if c.TLS != nil && (c.TLS.CertFile == "" || c.TLS.KeyFile == "") {
+		return fmt.Errorf("grpc tls requires a cert_file and key_file")
+	}
+	return nil
+}
+
+// tlsConfig returns the tls.Config of the server
+func (c *TLSConfig) tlsConfig() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading grpc tls certificate: %v", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCAFile != "" {
+		b, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error loading grpc tls client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("no certificates in grpc tls client CA %s", c.ClientCAFile)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// NewServer returns a grpc.Server serving the Query service of the given API,
+// which is the API the HTTP handlers are served from, with the auth and limits of
+// the options
+func NewServer(cfg *Config, client promclient.API, options Options) (*grpc.Server, error) {
+	interceptor, err := options.interceptor()
+	if err != nil {
+		return nil, err
+	}
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(interceptor)}
+	if cfg.TLS != nil {
+		tlsConfig, err := cfg.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	s := grpc.NewServer(opts...)
+	RegisterQueryServer(s, &Server{API: client})
+	return s, nil
+}
+
+// ListenAndServe serves the Query service of the given API on the listen address
+// of the config, until the server is stopped or fails
+func ListenAndServe(cfg *Config, client promclient.API, options Options) error {
+	s, err := NewServer(cfg, client, options)
+	if err != nil {
+		return err
+	}
+	l, err := net.Listen("tcp", cfg.ListenAddress)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Server implements the QueryServer on top of a promclient.API. The deadline of
+// the calls is that of the gRPC call, as the context is passed to the API.
+type Server struct {
+	API promclient.API
+}
+
+// Query performs a query for the given time.
+func (s *Server) Query(ctx context.Context, in *QueryRequest) (*QueryResponse, error) {
+	if _, err := promql.ParseExpr(in.Query); err != nil {
+		return nil, badData(err)
+	}
+	ts := time.Now()
+	if in.Time != 0 {
+		ts = timestamp(in.Time)
+	}
+	v, warnings, err := s.API.Query(ctx, in.Query, ts)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return queryResponse(v, warnings)
+}
+
+// QueryRange performs a query for the given range.
+func (s *Server) QueryRange(ctx context.Context, in *QueryRangeRequest) (*QueryResponse, error) {
+	if in.End < in.Start {
+		return nil, badData(fmt.Errorf("end timestamp must not be before start time"))
+	}
+	if in.StepMs <= 0 {
+		return nil, badData(fmt.Errorf("zero or negative query resolution step widths are not accepted. Try a positive integer"))
+	}
+	if _, err := promql.ParseExpr(in.Query); err != nil {
+		return nil, badData(err)
+	}
+	v, warnings, err := s.API.QueryRange(ctx, in.Query, v1.Range{
+		Start: timestamp(in.Start),
+		End:   timestamp(in.End),
+		Step:  time.Duration(in.StepMs) * time.Millisecond,
+	})
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return queryResponse(v, warnings)
+}
+
+// Series finds series by label matchers.
+func (s *Server) Series(ctx context.Context, in *SeriesRequest) (*SeriesResponse, error) {
+	if len(in.Match) == 0 {
+		return nil, badData(fmt.Errorf("no match parameter provided"))
+	}
+	for _, m := range in.Match {
+		if _, err := promql.ParseMetricSelector(m); err != nil {
+			return nil, badData(err)
+		}
+	}
+	v, warnings, err := s.API.Series(ctx, in.Match, timestamp(in.Start), timestamp(in.End))
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &SeriesResponse{Series: make([]*LabelSet, len(v)), Warnings: warnings}
+	for i, ls := range v {
+		resp.Series[i] = &LabelSet{Labels: protoLabels(model.Metric(ls))}
+	}
+	return resp, nil
+}
+
+// LabelNames returns the unique label names, of the series within the time range
+// if there is one
+func (s *Server) LabelNames(ctx context.Context, in *LabelNamesRequest) (*LabelNamesResponse, error) {
+	var (
+		v        []string
+		warnings api.Warnings
+		err      error
+	)
+	if in.Start != 0 || in.End != 0 {
+		v, warnings, err = promclient.LabelNamesInRange(ctx, s.API, timestamp(in.Start), timestamp(in.End))
+	} else {
+		v, warnings, err = s.API.LabelNames(ctx)
+	}
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &LabelNamesResponse{Names: v, Warnings: warnings}, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (s *Server) LabelValues(ctx context.Context, in *LabelValuesRequest) (*LabelValuesResponse, error) {
+	if !model.LabelNameRE.MatchString(in.Name) {
+		return nil, badData(fmt.Errorf("invalid label name: %q", in.Name))
+	}
+	v, warnings, err := s.API.LabelValues(ctx, in.Name)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	resp := &LabelValuesResponse{Values: make([]string, len(v)), Warnings: warnings}
+	for i, value := range v {
+		resp.Values[i] = string(value)
+	}
+	return resp, nil
+}
+
+// timestamp returns the time of a timestamp in milliseconds since the epoch
+func timestamp(ms int64) time.Time {
+	return model.Time(ms).Time()
+}
+
+// queryResponse returns the QueryResponse of a query result
+func queryResponse(v model.Value, warnings api.Warnings) (*QueryResponse, error) {
+	resp := &QueryResponse{Warnings: warnings}
+	switch typed := v.(type) {
+	case nil:
+		resp.ResultType = ResultType_RESULT_TYPE_VECTOR
+	case *model.Scalar:
+		resp.ResultType = ResultType_RESULT_TYPE_SCALAR
+		resp.Result = []*Series{{Samples: []*Sample{{Timestamp: int64(typed.Timestamp), Value: float64(typed.Value)}}}}
+	case *model.String:
+		resp.ResultType = ResultType_RESULT_TYPE_STRING
+		resp.StringValue = typed.Value
+	case model.Vector:
+		resp.ResultType = ResultType_RESULT_TYPE_VECTOR
+		resp.Result = make([]*Series, len(typed))
+		for i, sample := range typed {
+			resp.Result[i] = &Series{
+				Labels:  protoLabels(sample.Metric),
+				Samples: []*Sample{{Timestamp: int64(sample.Timestamp), Value: float64(sample.Value)}},
+			}
+		}
+	case model.Matrix:
+		resp.ResultType = ResultType_RESULT_TYPE_MATRIX
+		resp.Result = make([]*Series, len(typed))
+		for i, stream := range typed {
+			samples := make([]*Sample, len(stream.Values))
+			for j, pair := range stream.Values {
+				samples[j] = &Sample{Timestamp: int64(pair.Timestamp), Value: float64(pair.Value)}
+			}
+			resp.Result[i] = &Series{Labels: protoLabels(stream.Metric), Samples: samples}
+		}
+	default:
+		return nil, status.Errorf(codes.Internal, "unknown result type %T", v)
+	}
+	return resp, nil
+}
+
+// protoLabels returns the labels of a metric, sorted by name
+func protoLabels(m model.Metric) []*Label {
+	names := make(model.LabelNames, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Sort(names)
+
+	labels := make([]*Label, len(names))
+	for i, name := range names {
+		labels[i] = &Label{Name: string(name), Value: string(m[name])}
+	}
+	return labels
+}
@@ -0,0 +1,167 @@
+package grpcapi
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+	"github.com/promproxy/pkg/server"
+)
+
+// stubAPI returns its value and warnings from Query, or its error
+type stubAPI struct {
+	promclient.API
+	v        model.Value
+	warnings api.Warnings
+	err      error
+	// tenant is the tenant of the last Query
+	tenant string
+}
+
+func (s *stubAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	s.tenant = promclient.TenantFromContext(ctx)
+	if s.err != nil {
+		return nil, s.warnings, s.err
+	}
+	return s.v, s.warnings, nil
+}
+
+func TestServer(t *testing.T) {
+	stub := &stubAPI{
+		v:        model.Vector{{Metric: model.Metric{model.MetricNameLabel: "up", "job": "a"}, Timestamp: 1000, Value: 1}},
+		warnings: api.Warnings{"partial response"},
+	}
+	s := grpc.NewServer()
+	RegisterQueryServer(s, &Server{API: stub})
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go s.Serve(l)
+	defer s.Stop()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cc.Close()
+	client := NewQueryClient(cc)
+
+	resp, err := client.Query(context.TODO(), &QueryRequest{Query: "up", Time: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.ResultType != ResultType_RESULT_TYPE_VECTOR || len(resp.Result) != 1 {
+		t.Fatalf("mismatch in result expected=1 vector sample actual=%v", resp)
+	}
+	series := resp.Result[0]
+	if len(series.Labels) != 2 || series.Labels[0].Name != model.MetricNameLabel || series.Labels[1].Value != "a" {
+		t.Fatalf("mismatch in labels expected=up{job=a} actual=%v", series.Labels)
+	}
+	if len(series.Samples) != 1 || series.Samples[0].Timestamp != 1000 || series.Samples[0].Value != 1 {
+		t.Fatalf("mismatch in samples expected=1@1000 actual=%v", series.Samples)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != "partial response" {
+		t.Fatalf("mismatch in warnings expected=[partial response] actual=%v", resp.Warnings)
+	}
+
+	// Invalid queries are rejected before calling the API
+	if _, err := client.Query(context.TODO(), &QueryRequest{Query: "up{"}); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("mismatch in code expected=%v actual=%v", codes.InvalidArgument, err)
+	}
+
+	// Errors of the API have the code of their errorType
+	stub.err = context.DeadlineExceeded
+	if _, err := client.Query(context.TODO(), &QueryRequest{Query: "up"}); status.Code(err) != codes.DeadlineExceeded {
+		t.Fatalf("mismatch in code expected=%v actual=%v", codes.DeadlineExceeded, err)
+	}
+	stub.err = fmt.Errorf("connection refused")
+	if _, err := client.Query(context.TODO(), &QueryRequest{Query: "up"}); status.Code(err) != codes.Unavailable {
+		t.Fatalf("mismatch in code expected=%v actual=%v", codes.Unavailable, err)
+	}
+}
+
+func TestServerOptions(t *testing.T) {
+	stub := &stubAPI{v: model.Vector{}}
+	s, err := NewServer(&Config{ListenAddress: "127.0.0.1:0"}, stub, Options{
+		Auth: server.ServerAuthConfig{AuthConfig: server.AuthConfig{
+			BearerTokens: server.BearerTokens{"secret": "team-a"},
+		}},
+		RateLimiter: server.NewRateLimiter(server.RateLimitConfig{RequestsPerSecond: 0.001, Burst: 2}),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	go s.Serve(l)
+	defer s.Stop()
+
+	cc, err := grpc.Dial(l.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer cc.Close()
+	client := NewQueryClient(cc)
+
+	// Calls without valid credentials are rejected, without using up the rate limit
+	for _, authorization := range []string{"", "Bearer wrong"} {
+		ctx := context.TODO()
+		if authorization != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", authorization)
+		}
+		if _, err := client.Query(ctx, &QueryRequest{Query: "up"}); status.Code(err) != codes.Unauthenticated {
+			t.Fatalf("mismatch in code expected=%v actual=%v", codes.Unauthenticated, err)
+		}
+	}
+
+	// Authenticated calls have the tenant of their identity, until they are over
+	// the rate limit
+	ctx := metadata.AppendToOutgoingContext(context.TODO(), "authorization", "Bearer secret")
+	for i := 0; i < 2; i++ {
+		if _, err := client.Query(ctx, &QueryRequest{Query: "up"}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if stub.tenant != "team-a" {
+			t.Fatalf("mismatch in tenant expected=%s actual=%s", "team-a", stub.tenant)
+		}
+	}
+	if _, err := client.Query(ctx, &QueryRequest{Query: "up"}); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("mismatch in code expected=%v actual=%v", codes.ResourceExhausted, err)
+	}
+}
+
+func TestCode(t *testing.T) {
+	tests := []struct {
+		typ  promutil.ErrorType
+		code codes.Code
+	}{
+		{promutil.ErrorBadData, codes.InvalidArgument},
+		{promutil.ErrorExec, codes.FailedPrecondition},
+		{promutil.ErrorTimeout, codes.DeadlineExceeded},
+		{promutil.ErrorCanceled, codes.Canceled},
+		{promutil.ErrorUnauthorized, codes.Unauthenticated},
+		{promutil.ErrorForbidden, codes.PermissionDenied},
+		{promutil.ErrorThrottled, codes.ResourceExhausted},
+		{promutil.ErrorProxy, codes.Unavailable},
+		{promutil.ErrorInternal, codes.Internal},
+	}
+	for _, test := range tests {
+		if actual := code(test.typ); actual != test.code {
+			t.Fatalf("mismatch in code of %s expected=%v actual=%v", test.typ, test.code, actual)
+		}
+	}
+}
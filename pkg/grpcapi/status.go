@@ -0,0 +1,47 @@
+package grpcapi
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/promproxy/pkg/promutil"
+	"github.com/promproxy/pkg/server"
+)
+
+// code returns the gRPC status code of the given errorType, as its HTTP status
+// code is chosen for the HTTP API
+func code(typ promutil.ErrorType) codes.Code {
+	switch typ {
+	case promutil.ErrorBadData:
+		return codes.InvalidArgument
+	case promutil.ErrorExec:
+		return codes.FailedPrecondition
+	case promutil.ErrorTimeout:
+		return codes.DeadlineExceeded
+	case promutil.ErrorCanceled:
+		return codes.Canceled
+	case promutil.ErrorUnauthorized:
+		return codes.Unauthenticated
+	case promutil.ErrorForbidden:
+		return codes.PermissionDenied
+	case promutil.ErrorNotFound:
+		return codes.NotFound
+	case promutil.ErrorThrottled:
+		return codes.ResourceExhausted
+	case promutil.ErrorProxy, promutil.ErrorUnavailable:
+		return codes.Unavailable
+	default:
+		return codes.Internal
+	}
+}
+
+// statusError converts an error returned from the promclient.API into a gRPC
+// status error, with the errorType the HTTP API would report it as
+func statusError(err error) error {
+	return status.Error(code(server.ErrorType(err)), err.Error())
+}
+
+// badData returns a status error for invalid input from the client
+func badData(err error) error {
+	return status.Error(codes.InvalidArgument, err.Error())
+}
@@ -0,0 +1,283 @@
+// Package loadshed rejects new queries while promproxy is close to its memory
+// limit, rather than risking being OOM killed along with all in-flight queries.
+package loadshed
+
+import (
+	"fmt"
+	"io/ioutil"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/promproxy/pkg/logging"
+	"github.com/promproxy/pkg/metrics"
+)
+
+// The shedding applies to the Selects of the proxyquerier, so it logs as such
+var logger = logging.Component(logging.ComponentProxyQuerier)
+
+// DefaultSampleInterval is how often the memory usage is read if no interval is configured
+const DefaultSampleInterval = 100 * time.Millisecond
+
+// ErrOverloaded is returned for Selects rejected as the memory usage (in bytes) is
+// above the high-water mark. After is how long until the usage is read again, so
+// the earliest the shedding may stop.
+type ErrOverloaded struct {
+	Usage uint64
+	After time.Duration
+}
+
+func (e *ErrOverloaded) Error() string {
+	return fmt.Sprintf("promproxy is overloaded (memory usage of %d bytes), query rejected, retry after %v", e.Usage, e.After)
+}
+
+// RetryAfter returns how long the caller should wait before retrying
+func (e *ErrOverloaded) RetryAfter() time.Duration {
+	return e.After
+}
+
+// The sources of the memory usage
+const (
+	// SourceHeap is the size of the Go heap (runtime.MemStats.HeapAlloc)
+	SourceHeap = "heap"
+	// SourceCgroup is the memory usage of the cgroup (v2 or v1) promproxy runs in
+	SourceCgroup = "cgroup"
+)
+
+// Config configures the load shedding
+type Config struct {
+	// Source is where the memory usage is read from, "heap" (the default) or "cgroup"
+	Source string `yaml:"source"`
+	// HighWatermark is the memory usage (in bytes) from which new Selects are rejected
+	HighWatermark uint64 `yaml:"high_watermark"`
+	// LowWatermark is the memory usage (in bytes) below which Selects are admitted
+	// again once shedding (the HighWatermark if unset)
+	LowWatermark uint64 `yaml:"low_watermark"`
+	// ExemptMetadata admits the Selects of metadata calls (e.g. series), which are
+	// cheap, even while shedding (the default)
+	ExemptMetadata bool `yaml:"exempt_metadata"`
+	// SampleInterval is how often the memory usage is read (DefaultSampleInterval if 0)
+	SampleInterval time.Duration `yaml:"sample_interval"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = Config{Source: SourceHeap, ExemptMetadata: true}
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	if c.LowWatermark == 0 {
+		c.LowWatermark = c.HighWatermark
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c Config) Validate() error {
+	switch c.Source {
+	case "", SourceHeap, SourceCgroup:
+	default:
+		return fmt.Errorf("unknown load shedding source %q", c.Source)
+	}
+	if c.HighWatermark == 0 {
+		return fmt.Errorf("load shedding high_watermark must be positive")
+	}
+	// Below a low_watermark of 0 the shedding would never stop
+	if c.LowWatermark == 0 || c.LowWatermark > c.HighWatermark {
+		return fmt.Errorf("load shedding low_watermark must be positive and not above the high_watermark")
+	}
+	if c.SampleInterval < 0 {
+		return fmt.Errorf("load shedding sample_interval must not be negative")
+	}
+	return nil
+}
+
+func (c Config) sampleInterval() time.Duration {
+	if c.SampleInterval > 0 {
+		return c.SampleInterval
+	}
+	return DefaultSampleInterval
+}
+
+// MemorySource returns the current memory usage in bytes
+type MemorySource func() (uint64, error)
+
+// HeapSource returns the size of the Go heap. ReadMemStats stops the world, so
+// this is only read every SampleInterval.
+func HeapSource() (uint64, error) {
+	var stats runtime.MemStats
+	runtime.ReadMemStats(&stats)
+	return stats.HeapAlloc, nil
+}
+
+// The files of the memory usage of the cgroup (for v2 and v1)
+var cgroupUsageFiles = []string{
+	"/sys/fs/cgroup/memory.current",
+	"/sys/fs/cgroup/memory/memory.usage_in_bytes",
+}
+
+// CgroupSource returns the memory usage of the cgroup promproxy runs in
+func CgroupSource() (uint64, error) {
+	for _, path := range cgroupUsageFiles {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	}
+	return 0, fmt.Errorf("no cgroup memory usage found")
+}
+
+// DefaultShedder is the Shedder of the Selects of the ProxyStorage
+var DefaultShedder = NewShedder(nil, nil)
+
+func init() {
+	metrics.MustRegister(DefaultShedder)
+}
+
+// NewShedder returns a Shedder with the given config, which reads the memory usage
+// from source (the source of the config if nil). A nil config admits everything.
+func NewShedder(cfg *Config, source MemorySource) *Shedder {
+	s := &Shedder{now: time.Now}
+	s.ApplyConfig(cfg, source)
+	return s
+}
+
+// Shedder is an admission controller for Selects based on the memory usage. Once the
+// usage reaches the high-water mark new Selects are rejected with an ErrOverloaded,
+// until it dropped below the low-water mark.
+//
+// Admit is on the hot path of every Select, so the memory usage is only read every
+// SampleInterval (by the first Select after it passed), the Selects in between
+// only load the current state.
+type Shedder struct {
+	// Accessed atomically, first so they are 64-bit aligned
+	lastSample int64 // unix nanos
+	usage      uint64
+	rejected   uint64
+	shedding   int32
+
+	mu     sync.RWMutex
+	cfg    *Config
+	source MemorySource
+	now    func() time.Time
+}
+
+// ApplyConfig replaces the config (and source) of the Shedder, a nil config admits
+// everything
+func (s *Shedder) ApplyConfig(cfg *Config, source MemorySource) {
+	if cfg != nil && source == nil {
+		source = HeapSource
+		if cfg.Source == SourceCgroup {
+			source = CgroupSource
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cfg = cfg
+	s.source = source
+	atomic.StoreInt64(&s.lastSample, 0)
+	atomic.StoreInt32(&s.shedding, 0)
+}
+
+// Admit returns an ErrOverloaded if a Select (a metadata one if set) must be
+// rejected
+func (s *Shedder) Admit(metadata bool) error {
+	s.mu.RLock()
+	cfg, source := s.cfg, s.source
+	s.mu.RUnlock()
+	if cfg == nil || (metadata && cfg.ExemptMetadata) {
+		return nil
+	}
+
+	s.sample(cfg, source)
+	if atomic.LoadInt32(&s.shedding) == 1 {
+		atomic.AddUint64(&s.rejected, 1)
+		// The usage is read again by the first Select after the interval passed
+		after := time.Duration(atomic.LoadInt64(&s.lastSample) + int64(cfg.sampleInterval()) - s.now().UnixNano())
+		if after <= 0 {
+			after = cfg.sampleInterval()
+		}
+		return &ErrOverloaded{Usage: atomic.LoadUint64(&s.usage), After: after}
+	}
+	return nil
+}
+
+// sample reads the memory usage if the last sample is older than the interval
+func (s *Shedder) sample(cfg *Config, source MemorySource) {
+	interval := cfg.sampleInterval()
+	now := s.now().UnixNano()
+	last := atomic.LoadInt64(&s.lastSample)
+	if now-last < int64(interval) {
+		return
+	}
+	// Only a single Select reads the usage
+	if !atomic.CompareAndSwapInt64(&s.lastSample, last, now) {
+		return
+	}
+
+	usage, err := source()
+	if err != nil {
+		logger.Errorf("Error reading the memory usage: %v", err)
+		return
+	}
+	atomic.StoreUint64(&s.usage, usage)
+	switch {
+	case usage >= cfg.HighWatermark:
+		if atomic.SwapInt32(&s.shedding, 1) == 0 {
+			logger.Warnf("Memory usage of %d bytes reached the high-water mark, shedding load", usage)
+		}
+	case usage < cfg.LowWatermark:
+		if atomic.SwapInt32(&s.shedding, 0) == 1 {
+			logger.Infof("Memory usage of %d bytes dropped below the low-water mark, no longer shedding load", usage)
+		}
+	}
+}
+
+// Shedding returns whether Selects are currently rejected
+func (s *Shedder) Shedding() bool {
+	return atomic.LoadInt32(&s.shedding) == 1
+}
+
+var (
+	sheddingDesc = prometheus.NewDesc(
+		"promproxy_load_shedding",
+		"Whether Selects are rejected as the memory usage is above the high-water mark",
+		nil, nil,
+	)
+	memoryUsageDesc = prometheus.NewDesc(
+		"promproxy_load_shedding_memory_usage_bytes",
+		"The memory usage as last read by the load shedding",
+		nil, nil,
+	)
+	shedSelectsDesc = prometheus.NewDesc(
+		"promproxy_load_shed_selects_total",
+		"The number of Selects rejected by the load shedding",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (s *Shedder) Describe(ch chan<- *prometheus.Desc) {
+	ch <- sheddingDesc
+	ch <- memoryUsageDesc
+	ch <- shedSelectsDesc
+}
+
+// Collect implements prometheus.Collector
+func (s *Shedder) Collect(ch chan<- prometheus.Metric) {
+	shedding := 0.0
+	if s.Shedding() {
+		shedding = 1
+	}
+	ch <- prometheus.MustNewConstMetric(sheddingDesc, prometheus.GaugeValue, shedding)
+	ch <- prometheus.MustNewConstMetric(memoryUsageDesc, prometheus.GaugeValue, float64(atomic.LoadUint64(&s.usage)))
+	ch <- prometheus.MustNewConstMetric(shedSelectsDesc, prometheus.CounterValue, float64(atomic.LoadUint64(&s.rejected)))
+}
@@ -0,0 +1,115 @@
+package loadshed
+
+import (
+	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestShedder(t *testing.T) {
+	var usage uint64
+	reads := 0
+	source := func() (uint64, error) {
+		reads++
+		return usage, nil
+	}
+	now := time.Unix(0, 0)
+
+	s := NewShedder(&Config{
+		HighWatermark:  100,
+		LowWatermark:   50,
+		ExemptMetadata: true,
+		SampleInterval: time.Second,
+	}, source)
+	s.now = func() time.Time { return now }
+
+	tests := []struct {
+		usage    uint64
+		advance  time.Duration
+		metadata bool
+		admitted bool
+		after    time.Duration
+	}{
+		{usage: 10, advance: time.Second, admitted: true},
+		// The usage isn't read again within the interval
+		{usage: 120, advance: 0, admitted: true},
+		// Shedding engages at the high-water mark
+		{usage: 120, advance: time.Second, admitted: false, after: time.Second},
+		// Metadata is exempt
+		{usage: 120, advance: 0, metadata: true, admitted: true},
+		// Between the marks the shedding continues
+		{usage: 70, advance: time.Second, admitted: false, after: time.Second},
+		// Shedding disengages below the low-water mark
+		{usage: 40, advance: time.Second, admitted: true},
+		// Between the marks the admission continues
+		{usage: 70, advance: time.Second, admitted: true},
+		{usage: 100, advance: time.Second, admitted: false, after: time.Second},
+		// Within the interval the retry-after is the time until the next read
+		{usage: 100, advance: 400 * time.Millisecond, admitted: false, after: 600 * time.Millisecond},
+	}
+
+	for i, test := range tests {
+		usage = test.usage
+		now = now.Add(test.advance)
+		err := s.Admit(test.metadata)
+		if admitted := err == nil; admitted != test.admitted {
+			t.Fatalf("%d: mismatch in admitted expected=%v actual=%v (%v)", i, test.admitted, admitted, err)
+		}
+		if err != nil {
+			overloaded, ok := err.(*ErrOverloaded)
+			if !ok {
+				t.Fatalf("%d: mismatch in error type: %T", i, err)
+			}
+			if overloaded.RetryAfter() != test.after {
+				t.Fatalf("%d: mismatch in retry after expected=%v actual=%v", i, test.after, overloaded.RetryAfter())
+			}
+		}
+	}
+
+	if reads != 6 {
+		t.Fatalf("mismatch in reads expected=%d actual=%d", 6, reads)
+	}
+}
+
+func TestShedderDisabled(t *testing.T) {
+	s := NewShedder(nil, nil)
+	if err := s.Admit(false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Metadata is only exempt if configured
+	s.ApplyConfig(&Config{HighWatermark: 1}, func() (uint64, error) { return 2, nil })
+	if err := s.Admit(true); err == nil {
+		t.Fatalf("expected metadata to be shed")
+	}
+}
+
+func TestConfigWatermarks(t *testing.T) {
+	tests := []struct {
+		in  string
+		low uint64
+		err bool
+	}{
+		{in: "high_watermark: 100\nlow_watermark: 50", low: 50},
+		// The low-water mark defaults to the high-water mark
+		{in: "high_watermark: 100", low: 100},
+		{in: "high_watermark: 100\nlow_watermark: 200", err: true},
+		{in: "low_watermark: 50", err: true},
+	}
+
+	for i, test := range tests {
+		var cfg Config
+		err := yaml.Unmarshal([]byte(test.in), &cfg)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err == nil && cfg.LowWatermark != test.low {
+			t.Fatalf("%d: mismatch in low_watermark expected=%d actual=%d", i, test.low, cfg.LowWatermark)
+		}
+	}
+
+	if err := (Config{HighWatermark: 100}).Validate(); err == nil {
+		t.Fatalf("expected an error for a low_watermark of 0")
+	}
+}
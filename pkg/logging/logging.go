@@ -0,0 +1,249 @@
+package logging
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ComponentField is the log field used to tag which component a log line came from
+const ComponentField = "component"
+
+// Components which support their own log level
+const (
+	ComponentPromclient   = "promclient"
+	ComponentProxyQuerier = "proxyquerier"
+	ComponentServer       = "server"
+	ComponentRules        = "rules"
+	ComponentTracing      = "tracing"
+)
+
+// Components is the list of all components that can have their level overridden
+var Components = []string{
+	ComponentPromclient,
+	ComponentProxyQuerier,
+	ComponentServer,
+	ComponentRules,
+	ComponentTracing,
+}
+
+// Component returns a logger for the given component. All lines logged through
+// it are subject to the component's level override (if one is set)
+func Component(name string) *logrus.Entry {
+	return levels.logger(name).WithField(ComponentField, name)
+}
+
+var levels = &levelManager{
+	overrides: make(map[string]*override),
+	loggers:   make(map[string]*logrus.Logger),
+}
+
+// override is a temporary level for a component (or the base level)
+type override struct {
+	level    logrus.Level
+	previous logrus.Level // only used for the base level
+	expires  time.Time
+	timer    *time.Timer
+}
+
+// levelManager tracks the overrides of the base logrus level and of the
+// components. The base level is the level of the standard logger. Since logrus
+// drops entries below the level of their logger before any hook or formatter
+// sees them, each component logs through its own logger which passes on all the
+// levels. Its hooks and formatter drop the lines below the level of the
+// component, and pass the others on to those of the standard logger. So the level
+// of the standard logger is never raised for an override, and its hooks only see
+// the lines which are emitted.
+type levelManager struct {
+	l         sync.RWMutex
+	base      *override
+	overrides map[string]*override
+	loggers   map[string]*logrus.Logger
+}
+
+// logger returns the logger of the component
+func (m *levelManager) logger(component string) *logrus.Logger {
+	m.l.Lock()
+	defer m.l.Unlock()
+	if l, ok := m.loggers[component]; ok {
+		return l
+	}
+	l := &logrus.Logger{
+		Out:       standardOut{},
+		Formatter: &filterFormatter{component},
+		Hooks:     make(logrus.LevelHooks),
+		Level:     logrus.TraceLevel,
+	}
+	l.AddHook(&filterHook{component})
+	m.loggers[component] = l
+	return l
+}
+
+// levelFor returns the effective level for a component
+func (m *levelManager) levelFor(component string) logrus.Level {
+	m.l.RLock()
+	defer m.l.RUnlock()
+	if o, ok := m.overrides[component]; ok {
+		return o.level
+	}
+	return logrus.GetLevel()
+}
+
+func (m *levelManager) set(component string, level logrus.Level, d time.Duration) {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	o := &override{level: level}
+	if d > 0 {
+		o.expires = time.Now().Add(d)
+	}
+	if component == "" {
+		o.previous = logrus.GetLevel()
+		if m.base != nil {
+			if m.base.timer != nil {
+				m.base.timer.Stop()
+			}
+			o.previous = m.base.previous
+		}
+		m.base = o
+		logrus.SetLevel(level)
+	} else {
+		if existing, ok := m.overrides[component]; ok && existing.timer != nil {
+			existing.timer.Stop()
+		}
+		m.overrides[component] = o
+	}
+	if d > 0 {
+		o.timer = time.AfterFunc(d, func() { m.revert(component, o) })
+	}
+}
+
+// revert removes the override `o` for the component, if it is still the current one
+func (m *levelManager) revert(component string, o *override) {
+	m.l.Lock()
+	if component == "" {
+		if m.base != o {
+			m.l.Unlock()
+			return
+		}
+		m.base = nil
+		logrus.SetLevel(o.previous)
+	} else {
+		if m.overrides[component] != o {
+			m.l.Unlock()
+			return
+		}
+		delete(m.overrides, component)
+	}
+	m.l.Unlock()
+	logrus.WithFields(logrus.Fields{
+		ComponentField: component,
+	}).Info("Reverted log level override")
+}
+
+// filterFormatter drops the entries of a component whose effective level is
+// below the level of the entry, and formats the others with the formatter of the
+// standard logger
+type filterFormatter struct {
+	component string
+}
+
+// Format renders a single log entry
+func (f *filterFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	if entry.Level > levels.levelFor(f.component) {
+		return nil, nil
+	}
+	return logrus.StandardLogger().Formatter.Format(entry)
+}
+
+// filterHook fires the hooks of the standard logger for the entries of a
+// component which are at or above its effective level
+type filterHook struct {
+	component string
+}
+
+// Levels returns the levels the hook fires for
+func (h *filterHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire fires the hooks of the standard logger for the entry
+func (h *filterHook) Fire(entry *logrus.Entry) error {
+	if entry.Level > levels.levelFor(h.component) {
+		return nil
+	}
+	return logrus.StandardLogger().Hooks.Fire(entry.Level, entry)
+}
+
+// standardOut writes to the output of the standard logger
+type standardOut struct{}
+
+func (standardOut) Write(b []byte) (int, error) {
+	// Dropped entries are formatted to nothing
+	if len(b) == 0 {
+		return 0, nil
+	}
+	return logrus.StandardLogger().Out.Write(b)
+}
+
+// IsLevelEnabled returns whether lines at `level` are emitted for the component
+func IsLevelEnabled(component string, level logrus.Level) bool {
+	return levels.levelFor(component) >= level
+}
+
+// SetLevel sets the log level for a component (or the base level if component
+// is empty). If `d` is > 0 the change is reverted after that duration
+func SetLevel(component string, level logrus.Level, d time.Duration) error {
+	if component != "" {
+		found := false
+		for _, c := range Components {
+			if c == component {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("unknown component %q", component)
+		}
+	}
+	levels.set(component, level, d)
+	return nil
+}
+
+// LevelState is the current level of a component
+type LevelState struct {
+	Component string     `json:"component"`
+	Level     string     `json:"level"`
+	Expires   *time.Time `json:"expires,omitempty"`
+}
+
+// Levels returns the current effective levels of the base logger and all components
+func Levels() []LevelState {
+	levels.l.RLock()
+	defer levels.l.RUnlock()
+
+	state := func(component string, level logrus.Level, o *override) LevelState {
+		s := LevelState{Component: component, Level: level.String()}
+		if o != nil && !o.expires.IsZero() {
+			expires := o.expires
+			s.Expires = &expires
+		}
+		return s
+	}
+
+	ret := []LevelState{state("", logrus.GetLevel(), levels.base)}
+	components := make([]string, len(Components))
+	copy(components, Components)
+	sort.Strings(components)
+	for _, c := range components {
+		if o, ok := levels.overrides[c]; ok {
+			ret = append(ret, state(c, o.level, o))
+		} else {
+			ret = append(ret, state(c, logrus.GetLevel(), nil))
+		}
+	}
+	return ret
+}
@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestComponentLevels(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	logrus.SetLevel(logrus.InfoLevel)
+
+	if err := SetLevel("notacomponent", logrus.TraceLevel, 0); err == nil {
+		t.Fatalf("expected error setting level of unknown component")
+	}
+
+	if err := SetLevel(ComponentPromclient, logrus.TraceLevel, 50*time.Millisecond); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	Component(ComponentPromclient).Trace("client trace")
+	Component(ComponentServer).Debug("server debug")
+	logrus.Debug("base debug")
+
+	if !strings.Contains(buf.String(), "client trace") {
+		t.Fatalf("missing log line from overridden component: %s", buf.String())
+	}
+	if strings.Contains(buf.String(), "server debug") || strings.Contains(buf.String(), "base debug") {
+		t.Fatalf("unexpected log line from component without override: %s", buf.String())
+	}
+
+	// Ensure the override is reverted
+	time.Sleep(100 * time.Millisecond)
+	buf.Reset()
+	Component(ComponentPromclient).Trace("client trace")
+	if strings.Contains(buf.String(), "client trace") {
+		t.Fatalf("override was not reverted: %s", buf.String())
+	}
+	if IsLevelEnabled(ComponentPromclient, logrus.DebugLevel) {
+		t.Fatalf("override was not reverted")
+	}
+}
+
+// recordingHook records the messages of the entries it is fired for
+type recordingHook struct {
+	messages []string
+}
+
+func (h *recordingHook) Levels() []logrus.Level { return logrus.AllLevels }
+
+func (h *recordingHook) Fire(entry *logrus.Entry) error {
+	h.messages = append(h.messages, entry.Message)
+	return nil
+}
+
+func TestComponentLevelsHooks(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	logrus.SetLevel(logrus.InfoLevel)
+	hook := &recordingHook{}
+	logrus.AddHook(hook)
+	defer logrus.StandardLogger().ReplaceHooks(make(logrus.LevelHooks))
+
+	if err := SetLevel(ComponentServer, logrus.DebugLevel, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer SetLevel(ComponentServer, logrus.InfoLevel, 0)
+
+	// The override doesn't change the level of the standard logger
+	if level := logrus.GetLevel(); level != logrus.InfoLevel {
+		t.Fatalf("mismatch in standard logger level expected=%v actual=%v", logrus.InfoLevel, level)
+	}
+
+	Component(ComponentServer).Debug("server debug")
+	Component(ComponentPromclient).Debug("client debug")
+	logrus.Debug("base debug")
+	Component(ComponentPromclient).Info("client info")
+
+	expected := []string{"server debug", "client info"}
+	if !reflect.DeepEqual(hook.messages, expected) {
+		t.Fatalf("mismatch in hooked messages expected=%v actual=%v", expected, hook.messages)
+	}
+	for _, message := range expected {
+		if !strings.Contains(buf.String(), message) {
+			t.Fatalf("missing log line %q: %s", message, buf.String())
+		}
+	}
+	if strings.Contains(buf.String(), "client debug") || strings.Contains(buf.String(), "base debug") {
+		t.Fatalf("unexpected log line from component without override: %s", buf.String())
+	}
+}
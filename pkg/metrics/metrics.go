@@ -0,0 +1,52 @@
+// Package metrics holds the registry of promproxy's own operational metrics, which
+// are exposed separately from the proxied data.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace is the prefix of the names of all of promproxy's own metrics
+const Namespace = "promproxy"
+
+// Registry is the registry of promproxy's own metrics
+var Registry = prometheus.NewRegistry()
+
+func init() {
+	MustRegister(prometheus.NewGoCollector())
+	MustRegister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{Namespace: Namespace}))
+}
+
+// Register registers the collectors with the Registry. Registering a collector
+// which is already registered is not an error, so packages (and tests) don't need
+// to track whether they already did.
+func Register(cs ...prometheus.Collector) error {
+	for _, c := range cs {
+		if err := Registry.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// MustRegister is like Register but panics on any error
+func MustRegister(cs ...prometheus.Collector) {
+	if err := Register(cs...); err != nil {
+		panic(err)
+	}
+}
+
+// Gatherer returns the gatherer of promproxy's own metrics
+func Gatherer() prometheus.Gatherer {
+	return Registry
+}
+
+// Handler returns the handler serving promproxy's own metrics (e.g. at /metrics)
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
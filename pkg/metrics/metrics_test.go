@@ -0,0 +1,50 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/promproxy/pkg/metrics"
+	"github.com/promproxy/pkg/promclient"
+)
+
+func TestHandler(t *testing.T) {
+	promclient.DefaultHealthMonitor.Wrap("a:9090", nil)
+	promclient.DefaultCircuitBreakers.Wrap("a:9090", nil, promclient.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: 1})
+	promclient.DefaultRetryBudgets.Get("a:9090", promclient.DefaultRetryBudgetConfig)
+
+	// Registering twice (or registering an equal collector) doesn't panic
+	for i := 0; i < 2; i++ {
+		metrics.MustRegister(promclient.DefaultHealthMonitor, promclient.DefaultCircuitBreakers, promclient.DefaultRetryBudgets)
+	}
+
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+	}
+
+	families, err := (&expfmt.TextParser{}).TextToMetricFamilies(w.Body)
+	if err != nil {
+		t.Fatalf("error parsing metrics: %v", err)
+	}
+	for _, name := range []string{
+		"promproxy_upstream_disabled",
+		"promproxy_circuit_breaker_state",
+		"promproxy_retry_budget_tokens",
+		"go_goroutines",
+	} {
+		if _, ok := families[name]; !ok {
+			t.Fatalf("missing metric family %s", name)
+		}
+	}
+	for name := range families {
+		if !strings.HasPrefix(name, metrics.Namespace+"_") && !strings.HasPrefix(name, "go_") {
+			t.Fatalf("metric %s isn't namespaced", name)
+		}
+	}
+}
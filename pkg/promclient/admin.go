@@ -0,0 +1,132 @@
+package promclient
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// AdminAPI is the subset of the prometheus admin API which is passed on to the
+// downstreams
+type AdminAPI interface {
+	// DeleteSeries deletes the data of the series matching any of the matchers in
+	// the time range
+	DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error
+	// Snapshot creates a snapshot of the data, skipping the head block if skipHead
+	Snapshot(ctx context.Context, skipHead bool) (*SnapshotResult, error)
+}
+
+// SnapshotResult is the result of a snapshot. A snapshot of several downstreams
+// has a name for each of them, Name lists these comma separated.
+type SnapshotResult struct {
+	Name string `json:"name"`
+	// Downstreams are the names of the snapshots by downstream
+	Downstreams map[string]string `json:"promproxyDownstreams,omitempty"`
+}
+
+// PromAdminAPI implements AdminAPI for the prometheus host at Host
+type PromAdminAPI struct {
+	v1.API
+	Host string
+}
+
+// Snapshot creates a snapshot of the data of the host
+func (p *PromAdminAPI) Snapshot(ctx context.Context, skipHead bool) (*SnapshotResult, error) {
+	result, err := p.API.Snapshot(ctx, skipHead)
+	if err != nil {
+		return nil, err
+	}
+	return &SnapshotResult{Name: result.Name, Downstreams: map[string]string{p.Host: result.Name}}, nil
+}
+
+// AddLabelAdminAPI routes the admin calls on Labels like AddLabelClient does the
+// queries: the matchers on the labels are stripped from the matches of a delete,
+// and it is skipped if none of them matches the labels
+type AddLabelAdminAPI struct {
+	AdminAPI
+	Labels model.LabelSet
+}
+
+// DeleteSeries deletes the data of the series matching any of the matchers in
+// the time range. A selector left without matchers always fails the delete, as
+// forwarding a catch all would delete all the data of the downstream.
+func (a *AddLabelAdminAPI) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	filteredMatches, _, err := EmptyMatchersConfig{Policy: EmptyMatchersError}.filterMatches(a.Labels, matches)
+	if err != nil {
+		return err
+	}
+
+	// If no matchers remain, then we don't have anything -- so skip
+	if len(filteredMatches) == 0 {
+		return nil
+	}
+	return a.AdminAPI.DeleteSeries(ctx, filteredMatches, startTime, endTime)
+}
+
+// MultiAdminAPI calls all of its AdminAPIs concurrently, returning the first error.
+// The calls aren't rolled back, so after an error the call should be retried.
+type MultiAdminAPI []AdminAPI
+
+// DeleteSeries deletes the data of the series matching any of the matchers in the
+// time range from all the AdminAPIs
+func (m MultiAdminAPI) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	errs := make(chan error, len(m))
+	for _, a := range m {
+		go func(a AdminAPI) {
+			errs <- a.DeleteSeries(ctx, matches, startTime, endTime)
+		}(a)
+	}
+
+	var firstErr error
+	for range m {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Snapshot creates a snapshot of the data of all the AdminAPIs
+func (m MultiAdminAPI) Snapshot(ctx context.Context, skipHead bool) (*SnapshotResult, error) {
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		firstErr error
+		merged   = &SnapshotResult{Downstreams: make(map[string]string)}
+	)
+	for _, a := range m {
+		wg.Add(1)
+		go func(a AdminAPI) {
+			defer wg.Done()
+			result, err := a.Snapshot(ctx, skipHead)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			for downstream, name := range result.Downstreams {
+				merged.Downstreams[downstream] = name
+			}
+		}(a)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+
+	names := make([]string, 0, len(merged.Downstreams))
+	for _, name := range merged.Downstreams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	merged.Name = strings.Join(names, ",")
+	return merged, nil
+}
@@ -0,0 +1,75 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// deleteCaptureAPI records the matches of the deletes sent to it
+type deleteCaptureAPI struct {
+	AdminAPI
+	matches [][]string
+}
+
+func (d *deleteCaptureAPI) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	d.matches = append(d.matches, matches)
+	return nil
+}
+
+func TestAddLabelAdminAPI(t *testing.T) {
+	tests := []struct {
+		matches []string
+		// forwarded are the matches we expect the downstream to see, nil means
+		// the delete should not have been routed to the downstream
+		forwarded []string
+		err       bool
+	}{
+		// No label matchers, everything is forwarded
+		{
+			matches:   []string{`up`},
+			forwarded: []string{`up`},
+		},
+		// Matching labels are stripped
+		{
+			matches:   []string{`up{region="eu",job="a"}`},
+			forwarded: []string{`up{job="a"}`},
+		},
+		// Non-matching selectors are skipped
+		{
+			matches:   []string{`up{region="us"}`, `up{region="eu"}`},
+			forwarded: []string{`up`},
+		},
+		{
+			matches: []string{`up{region="us"}`},
+		},
+		// A selector without matchers left would delete everything
+		{
+			matches: []string{`{region="eu"}`},
+			err:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.matches[0], func(t *testing.T) {
+			downstream := &deleteCaptureAPI{}
+			a := &AddLabelAdminAPI{AdminAPI: downstream, Labels: model.LabelSet{"region": "eu"}}
+
+			err := a.DeleteSeries(context.TODO(), test.matches, time.Time{}, time.Time{})
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in err expected=%v actual=%v", test.err, err)
+			}
+
+			var forwarded []string
+			if len(downstream.matches) > 0 {
+				forwarded = downstream.matches[0]
+			}
+			if !reflect.DeepEqual(test.forwarded, forwarded) {
+				t.Fatalf("mismatch in forwarded expected=%v actual=%v", test.forwarded, forwarded)
+			}
+		})
+	}
+}
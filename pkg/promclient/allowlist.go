@@ -0,0 +1,178 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// ErrMetricNotAllowed is returned when a query references a metric which isn't in
+// the allowlist
+type ErrMetricNotAllowed string
+
+func (e ErrMetricNotAllowed) Error() string {
+	if e == "" {
+		return "selectors without a metric name are not allowed"
+	}
+	return fmt.Sprintf("metric %q is not allowed", string(e))
+}
+
+// NewAllowlistAPI returns an AllowlistAPI allowing only the metric names which match
+// one of the (fully anchored) regexes of the allowlist
+func NewAllowlistAPI(a API, allowlist []string) (*AllowlistAPI, error) {
+	allow := make([]*regexp.Regexp, len(allowlist))
+	for i, pattern := range allowlist {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, err
+		}
+		allow[i] = re
+	}
+	return &AllowlistAPI{API: a, allow: allow}, nil
+}
+
+// AllowlistAPI rejects requests which reference metrics that aren't allowlisted,
+// before they are sent to the wrapped API. Unlike the MetricFilterAPI (which
+// silently constrains the selectors) this is meant for exposed endpoints where
+// queries for other metrics are an error. Since a selector without an exact metric
+// name (e.g. `{job="a"}` or `{__name__=~"a.*"}`) could select any metric, such
+// selectors are rejected too.
+type AllowlistAPI struct {
+	API
+	allow []*regexp.Regexp
+}
+
+// Allowed returns whether the metric name is allowlisted
+func (a *AllowlistAPI) Allowed(name string) bool {
+	for _, re := range a.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkMatchers returns an error if the matchers may select a metric that isn't allowed
+func (a *AllowlistAPI) checkMatchers(matchers []*labels.Matcher) error {
+	for _, matcher := range matchers {
+		if matcher.Name == model.MetricNameLabel && matcher.Type == labels.MatchEqual {
+			if !a.Allowed(matcher.Value) {
+				return ErrMetricNotAllowed(matcher.Value)
+			}
+			return nil
+		}
+	}
+	return ErrMetricNotAllowed("")
+}
+
+// checkQuery returns an error if any selector of the query may select a metric
+// that isn't allowed
+func (a *AllowlistAPI) checkQuery(query string) error {
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return err
+	}
+
+	var checkErr error
+	promql.Inspect(e, func(node promql.Node, _ []promql.Node) error {
+		if checkErr != nil {
+			return checkErr
+		}
+		switch n := node.(type) {
+		case *promql.VectorSelector:
+			checkErr = a.checkMatchers(n.LabelMatchers)
+		case *promql.MatrixSelector:
+			checkErr = a.checkMatchers(n.LabelMatchers)
+		}
+		return checkErr
+	})
+	return checkErr
+}
+
+// checkMatches returns an error if any of the Series matches may select a metric
+// that isn't allowed
+func (a *AllowlistAPI) checkMatches(matches []string) error {
+	for _, match := range matches {
+		if err := a.checkQuery(match); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (a *AllowlistAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := a.API.LabelValues(ctx, label)
+	if err != nil || label != model.MetricNameLabel {
+		return v, w, err
+	}
+
+	filtered := make(model.LabelValues, 0, len(v))
+	for _, name := range v {
+		if a.Allowed(string(name)) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, w, nil
+}
+
+// Query performs a query for the given time.
+func (a *AllowlistAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if err := a.checkQuery(query); err != nil {
+		return nil, nil, err
+	}
+	return a.API.Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (a *AllowlistAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if err := a.checkQuery(query); err != nil {
+		return nil, nil, err
+	}
+	return a.API.QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (a *AllowlistAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if err := a.checkMatches(matches); err != nil {
+		return nil, nil, err
+	}
+	return a.API.Series(ctx, matches, startTime, endTime)
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (a *AllowlistAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if err := a.checkMatches(matches); err != nil {
+		return nil, err
+	}
+	return StreamSeries(ctx, a.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (a *AllowlistAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, a.API, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (a *AllowlistAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if err := a.checkMatchers(matchers); err != nil {
+		return nil, nil, err
+	}
+	return a.API.GetValue(ctx, start, end, matchers)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (a *AllowlistAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if err := a.checkMatchers(matchers); err != nil {
+		return nil, err
+	}
+	return StreamGetValue(ctx, a.API, start, end, matchers)
+}
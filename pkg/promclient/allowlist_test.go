@@ -0,0 +1,91 @@
+package promclient
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestAllowlistAPI(t *testing.T) {
+	leaky := &leakyAPI{}
+	a, err := NewAllowlistAPI(leaky, []string{"up", "node_.*"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		query   string
+		allowed bool
+	}{
+		{query: `up`, allowed: true},
+		{query: `sum(rate(node_cpu_seconds_total[5m])) by (mode)`, allowed: true},
+		{query: `up / node_load1`, allowed: true},
+		{query: `time()`, allowed: true},
+		{query: `secret_tokens`, allowed: false},
+		{query: `up or secret_tokens`, allowed: false},
+		{query: `max_over_time(secret_tokens[1h:1m])`, allowed: false},
+		// Selectors which could select any metric
+		{query: `{job="a"}`, allowed: false},
+		{query: `{__name__=~"up|secret_tokens"}`, allowed: false},
+		// The allowlist is anchored
+		{query: `upper`, allowed: false},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			leaky.queries = nil
+			_, _, err := a.Query(context.TODO(), test.query, time.Now())
+			if test.allowed {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(leaky.queries) != 1 {
+					t.Fatalf("allowed query not forwarded")
+				}
+				return
+			}
+
+			if _, ok := err.(ErrMetricNotAllowed); !ok {
+				t.Fatalf("expected ErrMetricNotAllowed, got: %v", err)
+			}
+			if len(leaky.queries) != 0 {
+				t.Fatalf("disallowed query was forwarded: %v", leaky.queries)
+			}
+
+			// The same applies to Series match[] terms
+			leaky.queries = nil
+			if _, _, err := a.Series(context.TODO(), []string{`up`, test.query}, time.Unix(0, 0), time.Unix(100, 0)); err == nil {
+				t.Fatalf("expected error for disallowed match")
+			}
+			if len(leaky.queries) != 0 {
+				t.Fatalf("disallowed match was forwarded: %v", leaky.queries)
+			}
+		})
+	}
+
+	// GetValue
+	leaky.matchers = nil
+	denied := labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "secret_tokens")
+	if _, _, err := a.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), []*labels.Matcher{denied}); err == nil || leaky.matchers != nil {
+		t.Fatalf("disallowed selector was forwarded")
+	}
+
+	// LabelValues only returns the allowed metric names
+	names, _, err := a.LabelValues(context.TODO(), model.MetricNameLabel)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 1 || names[0] != "up" {
+		t.Fatalf("disallowed metric names returned: %v", names)
+	}
+}
+
+func TestNewAllowlistAPIInvalid(t *testing.T) {
+	if _, err := NewAllowlistAPI(&leakyAPI{}, []string{"("}); err == nil {
+		t.Fatalf("expected error for invalid regex")
+	}
+}
@@ -3,6 +3,7 @@ package promclient
 import (
 	"context"
 	"fmt"
+	"io"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -10,9 +11,11 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
-	"github.com/prometheus/prometheus/storage/remote"
+	"github.com/prometheus/prometheus/prompb"
+	promremote "github.com/prometheus/prometheus/storage/remote"
 
 	"github.com/promproxy/pkg/promutil"
+	"github.com/promproxy/pkg/remote"
 )
 
 // PromAPIV1 implements our internal API interface using *only* the v1 HTTP API
@@ -24,32 +27,47 @@ type PromAPIV1 struct {
 // GetValue loads the raw data for a given set of matchers in the time range
 func (p *PromAPIV1) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	// http://localhost:8080/api/v1/query?query=scrape_duration_seconds%7Bjob%3D%22prometheus%22%7D&time=1507412244.663&_=1507412096887
-	pql, err := promutil.MatcherToString(matchers)
+	query, err := rawDataQuery(start, end, matchers)
 	if err != nil {
 		return nil, nil, err
 	}
+	return p.API.Query(ctx, query, end)
+}
+
+// rawDataQuery returns the query which selects the raw datapoints of the matchers
+// in the time range, when evaluated at end
+func rawDataQuery(start, end time.Time, matchers []*labels.Matcher) (string, error) {
+	pql, err := promutil.MatcherToString(matchers)
+	if err != nil {
+		return "", err
+	}
 
 	// We want to grab only the raw datapoints, so we do that through the query interface
 	// passing in a duration that is at least as long as ours (the added second is to deal
 	// with any rounding error etc since the duration is a floating point and we are casting
 	// to an int64
-	query := pql + fmt.Sprintf("[%ds]", int64(end.Sub(start).Seconds())+1)
-	return p.API.Query(ctx, query, end)
+	return pql + fmt.Sprintf("[%ds]", int64(end.Sub(start).Seconds())+1), nil
 }
 
 // PromAPIRemoteRead implements our internal API interface using a combination of
-// the v1 HTTP API and the "experimental" remote_read API
+// the v1 HTTP API and the "experimental" remote_read API. Streamed GetValue calls
+// use the streamed remote read of the hosts which support it.
 type PromAPIRemoteRead struct {
 	API
 	*remote.Client
+	// URL is the remote_read endpoint of the Client, for the requests of dry runs
+	URL string
 }
 
 // GetValue loads the raw data for a given set of matchers in the time range
 func (p *PromAPIRemoteRead) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
-	query, err := remote.ToQuery(int64(timestamp.FromTime(start)), int64(timestamp.FromTime(end)), matchers, nil)
+	query, err := p.query(ctx, start, end, matchers)
 	if err != nil {
 		return nil, nil, err
 	}
+	if query == nil {
+		return model.Matrix{}, nil, nil
+	}
 	result, err := p.Client.Read(ctx, query)
 	if err != nil {
 		return nil, nil, err
@@ -58,24 +76,87 @@ func (p *PromAPIRemoteRead) GetValue(ctx context.Context, start, end time.Time,
 	// convert result (timeseries) to SampleStream
 	matrix := make(model.Matrix, len(result.Timeseries))
 	for i, ts := range result.Timeseries {
-		metric := make(model.Metric)
-		for _, label := range ts.Labels {
-			metric[model.LabelName(label.Name)] = model.LabelValue(label.Value)
-		}
+		matrix[i] = sampleStream(ts)
+	}
+
+	return matrix, nil, nil
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range
+// through the streamed remote read, sending the series on the returned channel as
+// they are read
+func (p *PromAPIRemoteRead) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	query, err := p.query(ctx, start, end, matchers)
+	if err != nil {
+		return nil, err
+	}
+	if query == nil {
+		return emptyValueStream(), nil
+	}
+	stream, err := p.Client.ReadStream(ctx, query)
+	if err != nil {
+		return nil, err
+	}
 
-		samples := make([]model.SamplePair, len(ts.Samples))
-		for x, sample := range ts.Samples {
-			samples[x] = model.SamplePair{
-				Timestamp: model.Time(sample.Timestamp),
-				Value:     model.SampleValue(sample.Value),
+	return newValueStream(ctx, func(fn SampleStreamFunc) (api.Warnings, error) {
+		defer stream.Close()
+		for {
+			ts, err := stream.Next()
+			if err == io.EOF {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			if err := fn(sampleStream(ts)); err != nil {
+				return nil, err
 			}
 		}
+	}), nil
+}
+
+// query returns the remote read query of the GetValue call, nil for a dry run
+func (p *PromAPIRemoteRead) query(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (*prompb.Query, error) {
+	query, err := promremote.ToQuery(int64(timestamp.FromTime(start)), int64(timestamp.FromTime(end)), matchers, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The remote read client has its own transport, so the DryRunRoundTripper
+	// doesn't see its requests
+	if dryRun := DryRunFromContext(ctx); dryRun != nil {
+		dryRun.add(DryRunRequest{Method: "POST", URL: p.URL, Body: query.String()})
+		return nil, nil
+	}
+	return query, nil
+}
+
+// sampleStream converts a series of a remote read to a SampleStream
+func sampleStream(ts *prompb.TimeSeries) *model.SampleStream {
+	metric := make(model.Metric, len(ts.Labels))
+	for _, label := range ts.Labels {
+		metric[model.LabelName(label.Name)] = model.LabelValue(label.Value)
+	}
 
-		matrix[i] = &model.SampleStream{
-			Metric: metric,
-			Values: samples,
+	samples := make([]model.SamplePair, len(ts.Samples))
+	for x, sample := range ts.Samples {
+		samples[x] = model.SamplePair{
+			Timestamp: model.Time(sample.Timestamp),
+			Value:     model.SampleValue(sample.Value),
 		}
 	}
 
-	return matrix, nil, nil
+	return &model.SampleStream{
+		Metric: metric,
+		Values: samples,
+	}
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (p *PromAPIRemoteRead) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, p.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (p *PromAPIRemoteRead) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, p.API, startTime, endTime)
 }
@@ -0,0 +1,160 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/pkg/errors"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// ErrorCategory is a classification of an error returned from a backend
+type ErrorCategory string
+
+// The categories of backend errors
+const (
+	// ErrorCategoryTimeout means the query didn't finish within its deadline
+	ErrorCategoryTimeout ErrorCategory = "timeout"
+	// ErrorCategoryCanceled means the query was canceled
+	ErrorCategoryCanceled ErrorCategory = "canceled"
+	// ErrorCategoryBadData means the backend rejected the query as invalid
+	ErrorCategoryBadData ErrorCategory = "bad_data"
+	// ErrorCategoryExecution means the backend failed to execute the query
+	ErrorCategoryExecution ErrorCategory = "execution"
+	// ErrorCategoryServer means the backend returned a server error (5xx)
+	ErrorCategoryServer ErrorCategory = "server"
+	// ErrorCategoryBadResponse means the backend response couldn't be decoded
+	ErrorCategoryBadResponse ErrorCategory = "bad_response"
+	// ErrorCategoryUnavailable means the backend couldn't be reached
+	ErrorCategoryUnavailable ErrorCategory = "unavailable"
+	// ErrorCategoryThrottled means the backend is shedding load and asked to retry later
+	ErrorCategoryThrottled ErrorCategory = "throttled"
+	// ErrorCategoryUnknown is any error which doesn't fit the other categories
+	ErrorCategoryUnknown ErrorCategory = "unknown"
+)
+
+// CategorizeError returns the ErrorCategory for an error returned from a backend
+func CategorizeError(err error) ErrorCategory {
+	switch cause := errors.Cause(err).(type) {
+	case promql.ErrQueryTimeout:
+		return ErrorCategoryTimeout
+	case promql.ErrQueryCanceled:
+		return ErrorCategoryCanceled
+	case *v1.Error:
+		switch cause.Type {
+		case v1.ErrTimeout:
+			return ErrorCategoryTimeout
+		case v1.ErrCanceled:
+			return ErrorCategoryCanceled
+		case v1.ErrBadData:
+			return ErrorCategoryBadData
+		case v1.ErrExec:
+			return ErrorCategoryExecution
+		case v1.ErrServer:
+			return ErrorCategoryServer
+		case v1.ErrBadResponse:
+			return ErrorCategoryBadResponse
+		case v1.ErrClient:
+			return ErrorCategoryUnavailable
+		}
+	case *RetryAfterError:
+		return ErrorCategoryThrottled
+	case *url.Error:
+		if _, ok := AsRetryAfterError(cause); ok {
+			return ErrorCategoryThrottled
+		}
+		if cause.Timeout() {
+			return ErrorCategoryTimeout
+		}
+		// The http client returns the error of the context of the request
+		if cause.Err == context.Canceled {
+			return ErrorCategoryCanceled
+		}
+		return ErrorCategoryUnavailable
+	case ErrUpstreamDisabled, ErrCircuitOpen:
+		return ErrorCategoryUnavailable
+	case *ScrapeParseError:
+		return ErrorCategoryBadResponse
+	case ScrapeQueryError:
+		return ErrorCategoryBadData
+	case net.Error:
+		if cause.Timeout() {
+			return ErrorCategoryTimeout
+		}
+		return ErrorCategoryUnavailable
+	default:
+		switch cause {
+		case context.DeadlineExceeded:
+			return ErrorCategoryTimeout
+		case context.Canceled:
+			return ErrorCategoryCanceled
+		}
+	}
+	return ErrorCategoryUnknown
+}
+
+// The statuses of calls in metrics
+const (
+	CallStatusSuccess  = "success"
+	CallStatusError    = "error"
+	CallStatusCanceled = "canceled"
+)
+
+// CallStatus returns the status of a call which returned err for its metrics.
+// Canceled calls (e.g. as the client went away) are counted separately, as they
+// aren't failures of the backend.
+func CallStatus(err error) string {
+	switch {
+	case err == nil:
+		return CallStatusSuccess
+	case CategorizeError(err) == ErrorCategoryCanceled:
+		return CallStatusCanceled
+	default:
+		return CallStatusError
+	}
+}
+
+// BackendNamer is implemented by APIs that can identify the backend they talk to
+type BackendNamer interface {
+	BackendName() string
+}
+
+// backendWarningFormat is the format of the warnings created from backend errors
+const backendWarningFormat = "backend error: backend=%q category=%s error=%q"
+
+// BackendWarning is a warning generated from an error returned by a single backend
+// which was not fatal to the overall request
+type BackendWarning struct {
+	Backend  string
+	Category ErrorCategory
+	Err      string
+}
+
+// NewBackendWarning returns a BackendWarning for the given backend error
+func NewBackendWarning(backend string, err error) *BackendWarning {
+	return &BackendWarning{
+		Backend:  backend,
+		Category: CategorizeError(err),
+		Err:      err.Error(),
+	}
+}
+
+// String returns the warning as it is sent to clients
+func (w *BackendWarning) String() string {
+	return fmt.Sprintf(backendWarningFormat, w.Backend, w.Category, w.Err)
+}
+
+// ParseBackendWarning parses a warning string created by BackendWarning.String,
+// returning false if the warning isn't a BackendWarning
+func ParseBackendWarning(s string) (*BackendWarning, bool) {
+	w := &BackendWarning{}
+	var category string
+	if _, err := fmt.Sscanf(s, backendWarningFormat, &w.Backend, &category, &w.Err); err != nil {
+		return nil, false
+	}
+	w.Category = ErrorCategory(category)
+	return w, true
+}
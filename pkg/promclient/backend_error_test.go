@@ -0,0 +1,113 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestCategorizeError(t *testing.T) {
+	tests := []struct {
+		err      error
+		category ErrorCategory
+	}{
+		{context.DeadlineExceeded, ErrorCategoryTimeout},
+		{promql.ErrQueryTimeout("query timed out"), ErrorCategoryTimeout},
+		{context.Canceled, ErrorCategoryCanceled},
+		{promql.ErrQueryCanceled("query canceled"), ErrorCategoryCanceled},
+		{&v1.Error{Type: v1.ErrBadData, Msg: "parse error"}, ErrorCategoryBadData},
+		{&v1.Error{Type: v1.ErrExec, Msg: "exec error"}, ErrorCategoryExecution},
+		{&v1.Error{Type: v1.ErrServer, Msg: "server error"}, ErrorCategoryServer},
+		{&v1.Error{Type: v1.ErrBadResponse, Msg: "bad response"}, ErrorCategoryBadResponse},
+		{&url.Error{Op: "Post", URL: "http://a", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}}, ErrorCategoryUnavailable},
+		{&url.Error{Op: "Get", URL: "http://a", Err: &RetryAfterError{StatusCode: 429, After: time.Second}}, ErrorCategoryThrottled},
+		{&url.Error{Op: "Get", URL: "http://a", Err: context.Canceled}, ErrorCategoryCanceled},
+		{fmt.Errorf("something else"), ErrorCategoryUnknown},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if category := CategorizeError(test.err); category != test.category {
+				t.Fatalf("mismatch in category expected=%s actual=%s", test.category, category)
+			}
+		})
+	}
+}
+
+func TestCallStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status string
+	}{
+		{nil, CallStatusSuccess},
+		{context.Canceled, CallStatusCanceled},
+		{&url.Error{Op: "Get", URL: "http://a", Err: context.Canceled}, CallStatusCanceled},
+		{context.DeadlineExceeded, CallStatusError},
+		{&v1.Error{Type: v1.ErrServer, Msg: "server error"}, CallStatusError},
+	}
+
+	for i, test := range tests {
+		if status := CallStatus(test.err); status != test.status {
+			t.Fatalf("%d: mismatch in status expected=%s actual=%s", i, test.status, status)
+		}
+	}
+}
+
+func TestMultiAPIBackendWarnings(t *testing.T) {
+	stub := &stubAPI{
+		query: func() model.Value {
+			return model.Vector{}
+		},
+	}
+
+	backends := map[string]error{
+		"timeout":     context.DeadlineExceeded,
+		"baddata":     &v1.Error{Type: v1.ErrBadData, Msg: "parse error"},
+		"server":      &v1.Error{Type: v1.ErrServer, Msg: "server error"},
+		"unavailable": &url.Error{Op: "Post", URL: "http://a", Err: &net.OpError{Op: "dial", Err: fmt.Errorf("connection refused")}},
+	}
+	expected := map[string]ErrorCategory{
+		"timeout":     ErrorCategoryTimeout,
+		"baddata":     ErrorCategoryBadData,
+		"server":      ErrorCategoryServer,
+		"unavailable": ErrorCategoryUnavailable,
+	}
+
+	apis := []API{&DebugAPI{API: stub, PrefixMessage: "ok"}}
+	for name, err := range backends {
+		apis = append(apis, &DebugAPI{API: &errorAPI{stub, err}, PrefixMessage: name})
+	}
+
+	// Only a single backend is required, so the errors are returned as warnings
+	multi := NewMultiAPI(apis, model.Time(0), nil, 1)
+	_, warnings, err := multi.Query(context.TODO(), "up", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	found := make(map[string]ErrorCategory)
+	for _, w := range warnings {
+		backendWarning, ok := ParseBackendWarning(w)
+		if !ok {
+			t.Fatalf("unable to parse warning: %s", w)
+		}
+		found[backendWarning.Backend] = backendWarning.Category
+	}
+
+	if len(found) != len(expected) {
+		t.Fatalf("mismatch in warnings expected=%v actual=%v", expected, found)
+	}
+	for backend, category := range expected {
+		if found[backend] != category {
+			t.Fatalf("mismatch in category for %s expected=%s actual=%s", backend, category, found[backend])
+		}
+	}
+}
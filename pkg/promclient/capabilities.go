@@ -0,0 +1,192 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CapabilityStatus is the result of probing the capabilities of an upstream
+type CapabilityStatus struct {
+	Upstream string `json:"upstream"`
+	// Version is the prometheus version of the upstream (empty if it has no build
+	// info API)
+	Version string `json:"version"`
+	// SeriesLimit is whether the Series API supports the limit param
+	SeriesLimit bool `json:"series_limit"`
+	// QueryLimit is whether the query and query_range APIs support the limit param
+	QueryLimit bool      `json:"query_limit"`
+	ProbedAt   time.Time `json:"probed_at"`
+	// Error is why the probe failed, the upstream is probed again with the next
+	// request needing its capabilities
+	Error string `json:"error,omitempty"`
+}
+
+// CapabilityProbe detects the capabilities of a prometheus server from its build
+// info. The capabilities are probed once (when first needed) and then kept,
+// failed probes are retried with the next call.
+type CapabilityProbe struct {
+	l      sync.Mutex
+	client *http.Client
+	url    url.URL
+	probed bool
+	status CapabilityStatus
+}
+
+// NewCapabilityProbe returns the CapabilityProbe of the prometheus server at u
+func NewCapabilityProbe(name string, client *http.Client, u *url.URL) *CapabilityProbe {
+	return &CapabilityProbe{client: client, url: *u, status: CapabilityStatus{Upstream: name}}
+}
+
+// SeriesLimit returns whether the Series API supports the limit param
+func (p *CapabilityProbe) SeriesLimit(ctx context.Context) bool {
+	return p.capability(ctx, func(s CapabilityStatus) bool { return s.SeriesLimit })
+}
+
+// QueryLimit returns whether the query and query_range APIs support the limit param
+func (p *CapabilityProbe) QueryLimit(ctx context.Context) bool {
+	return p.capability(ctx, func(s CapabilityStatus) bool { return s.QueryLimit })
+}
+
+// capability returns a capability of the status, probing it first if needed
+func (p *CapabilityProbe) capability(ctx context.Context, capability func(CapabilityStatus) bool) bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+	if !p.probed {
+		// Dry runs don't send the probe, so its result isn't known
+		if DryRunFromContext(ctx) != nil {
+			return false
+		}
+		p.probe(ctx)
+	}
+	return capability(p.status)
+}
+
+// Probe probes the capabilities again (e.g. after the upstream was upgraded),
+// returning the fresh result
+func (p *CapabilityProbe) Probe(ctx context.Context) CapabilityStatus {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return p.probe(ctx)
+}
+
+// Status returns the result of the last probe
+func (p *CapabilityProbe) Status() CapabilityStatus {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return p.status
+}
+
+// probe must be called with the lock held
+func (p *CapabilityProbe) probe(ctx context.Context) CapabilityStatus {
+	status := CapabilityStatus{Upstream: p.status.Upstream, ProbedAt: time.Now()}
+	version, err := fetchVersion(ctx, p.client, p.url)
+	if err != nil {
+		logger.Debugf("Unable to probe the capabilities of %s: %v", p.url.Host, err)
+		status.Error = err.Error()
+	} else {
+		status.Version = version
+		status.SeriesLimit = versionAtLeast(version, seriesLimitVersion)
+		status.QueryLimit = versionAtLeast(version, queryLimitVersion)
+	}
+	p.probed = err == nil
+	p.status = status
+	return status
+}
+
+// fetchVersion fetches the version from the build info of the prometheus server.
+// Servers without the build info API (before 2.14) return an empty version.
+func fetchVersion(ctx context.Context, client *http.Client, u url.URL) (string, error) {
+	u.Path = path.Join(u.Path, "api/v1/status/buildinfo")
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected build info status %d", resp.StatusCode)
+	}
+	var buildInfo struct {
+		Data struct {
+			Version string `json:"version"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&buildInfo); err != nil {
+		return "", err
+	}
+	return buildInfo.Data.Version, nil
+}
+
+// DefaultCapabilityProbes are the CapabilityProbes used by the servergroups
+var DefaultCapabilityProbes = NewCapabilityProbes()
+
+// NewCapabilityProbes returns an empty CapabilityProbes
+func NewCapabilityProbes() *CapabilityProbes {
+	return &CapabilityProbes{probes: make(map[string]*CapabilityProbe)}
+}
+
+// CapabilityProbes holds the CapabilityProbe of each upstream by name, so the
+// capabilities are probed once per upstream (and not per client)
+type CapabilityProbes struct {
+	mu     sync.Mutex
+	probes map[string]*CapabilityProbe
+}
+
+// Get returns the probe of the named upstream, which is replaced if its URL changed
+func (c *CapabilityProbes) Get(name string, client *http.Client, u *url.URL) *CapabilityProbe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.probes[name]; ok && p.url == *u {
+		p.l.Lock()
+		p.client = client
+		p.l.Unlock()
+		return p
+	}
+	p := NewCapabilityProbe(name, client, u)
+	c.probes[name] = p
+	return p
+}
+
+// Lookup returns the probe of the named upstream (nil if there is none)
+func (c *CapabilityProbes) Lookup(name string) *CapabilityProbe {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.probes[name]
+}
+
+// Probe probes the capabilities of the named upstream again
+func (c *CapabilityProbes) Probe(ctx context.Context, name string) (CapabilityStatus, error) {
+	c.mu.Lock()
+	p, ok := c.probes[name]
+	c.mu.Unlock()
+	if !ok {
+		return CapabilityStatus{}, ErrUnknownUpstream(name)
+	}
+	return p.Probe(ctx), nil
+}
+
+// Names returns the sorted names of the upstreams
+func (c *CapabilityProbes) Names() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.probes))
+	for name := range c.probes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,337 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrCircuitOpen is returned for requests to an upstream whose circuit breaker is open
+type ErrCircuitOpen string
+
+func (e ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit breaker for upstream %q is open", string(e))
+}
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+// The circuit states
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen rejects all requests
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets requests through to check whether the upstream recovered,
+	// the first result closes (or re-opens) the circuit
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitBreakerConfig configures the circuit breakers of a servergroup
+type CircuitBreakerConfig struct {
+	// FailureThreshold is the number of consecutive failures which open the circuit
+	FailureThreshold int `yaml:"failure_threshold"`
+	// OpenDuration is how long the circuit stays open before it is half-open
+	OpenDuration time.Duration `yaml:"open_duration"`
+}
+
+// Validate returns an error if the config isn't valid
+func (c CircuitBreakerConfig) Validate() error {
+	if c.FailureThreshold <= 0 {
+		return fmt.Errorf("circuit breaker failure_threshold must be positive")
+	}
+	if c.OpenDuration <= 0 {
+		return fmt.Errorf("circuit breaker open_duration must be positive")
+	}
+	return nil
+}
+
+// circuitBreaker is the state of the circuit breaker of an upstream
+type circuitBreaker struct {
+	mu       sync.Mutex
+	state    CircuitState
+	failures int
+	openedAt time.Time
+
+	// shedding is the RetryAfterError of the upstream, requests aren't sent to it
+	// until shedUntil
+	shedding  *RetryAfterError
+	shedUntil time.Time
+}
+
+// DefaultCircuitBreakers are the CircuitBreakers used by the servergroups
+var DefaultCircuitBreakers = NewCircuitBreakers()
+
+// NewCircuitBreakers returns an empty CircuitBreakers
+func NewCircuitBreakers() *CircuitBreakers {
+	return &CircuitBreakers{apis: make(map[string]*CircuitBreakerAPI)}
+}
+
+// CircuitBreakers tracks the CircuitBreakerAPI of each upstream by name. As the
+// clients are rebuilt whenever the targets of a servergroup change, the state of a
+// circuit breaker is kept across wraps of the same upstream
+type CircuitBreakers struct {
+	mu   sync.Mutex
+	apis map[string]*CircuitBreakerAPI
+}
+
+// Wrap returns a CircuitBreakerAPI for the upstream with the given name
+func (c *CircuitBreakers) Wrap(name string, a API, cfg CircuitBreakerConfig) *CircuitBreakerAPI {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	breaker := &circuitBreaker{state: CircuitClosed}
+	if previous, ok := c.apis[name]; ok {
+		breaker = previous.breaker
+	}
+	cb := &CircuitBreakerAPI{API: a, Name: name, Config: cfg, breaker: breaker}
+	c.apis[name] = cb
+	return cb
+}
+
+// Get returns the CircuitBreakerAPI of the named upstream
+func (c *CircuitBreakers) Get(name string) (*CircuitBreakerAPI, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cb, ok := c.apis[name]
+	return cb, ok
+}
+
+// CircuitBreakerAPI stops sending requests to an upstream after FailureThreshold
+// consecutive failures, returning an ErrCircuitOpen instead. After OpenDuration the
+// circuit is half-open and the next result decides whether it closes again. Only
+// errors of the upstream itself (unavailable, timeouts, server errors) are failures,
+// a bad query doesn't count against the upstream. An upstream shedding load (see
+// RetryAfterError) isn't sent requests until its Retry-After passed, without
+// counting as a failure.
+type CircuitBreakerAPI struct {
+	API
+	Name    string
+	Config  CircuitBreakerConfig
+	breaker *circuitBreaker
+}
+
+// State returns the current state of the circuit
+func (c *CircuitBreakerAPI) State() CircuitState {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	if c.breaker.state == CircuitOpen && time.Since(c.breaker.openedAt) >= c.Config.OpenDuration {
+		c.breaker.state = CircuitHalfOpen
+	}
+	return c.breaker.state
+}
+
+// ForceOpen opens the circuit, as if the failure threshold was reached
+func (c *CircuitBreakerAPI) ForceOpen() {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	c.breaker.state = CircuitOpen
+	c.breaker.openedAt = time.Now()
+}
+
+// ForceClose closes the circuit (and resets the failures) without waiting for
+// it to be half-open
+func (c *CircuitBreakerAPI) ForceClose() {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	c.breaker.state = CircuitClosed
+	c.breaker.failures = 0
+}
+
+// allow returns an error if the request must not be sent to the upstream
+func (c *CircuitBreakerAPI) allow() error {
+	if c.State() == CircuitOpen {
+		return ErrCircuitOpen(c.Name)
+	}
+
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	if remaining := time.Until(c.breaker.shedUntil); c.breaker.shedding != nil && remaining > 0 {
+		return &RetryAfterError{StatusCode: c.breaker.shedding.StatusCode, After: remaining}
+	}
+	return nil
+}
+
+// record updates the state of the circuit with the result of a request. Dry runs
+// aren't sent to the upstream, so they don't tell anything about its state.
+func (c *CircuitBreakerAPI) record(ctx context.Context, err error) {
+	if DryRunFromContext(ctx) != nil {
+		return
+	}
+
+	// An upstream which is shedding load isn't failing, it is "soft-open" until the
+	// time it asked to be retried at
+	if retryErr, ok := AsRetryAfterError(err); ok {
+		c.breaker.mu.Lock()
+		defer c.breaker.mu.Unlock()
+		c.breaker.shedding = retryErr
+		c.breaker.shedUntil = time.Now().Add(retryErr.After)
+		return
+	}
+
+	failure := false
+	if err != nil {
+		switch CategorizeError(err) {
+		case ErrorCategoryTimeout, ErrorCategoryServer, ErrorCategoryBadResponse, ErrorCategoryUnavailable:
+			failure = true
+		}
+	}
+
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+	if !failure {
+		if err == nil {
+			c.breaker.state = CircuitClosed
+			c.breaker.failures = 0
+		}
+		return
+	}
+
+	c.breaker.failures++
+	if c.breaker.state == CircuitHalfOpen || (c.breaker.state == CircuitClosed && c.breaker.failures >= c.Config.FailureThreshold) {
+		logger.WithField("upstream", c.Name).Warnf("Circuit breaker opened after %d failures: %v", c.breaker.failures, err)
+		c.breaker.state = CircuitOpen
+		c.breaker.openedAt = time.Now()
+	}
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (c *CircuitBreakerAPI) Key() model.LabelSet {
+	return apiKey(c.API)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (c *CircuitBreakerAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.LabelNames(ctx)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (c *CircuitBreakerAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := LabelNamesInRange(ctx, c.API, startTime, endTime)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (c *CircuitBreakerAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.LabelValues(ctx, label)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (c *CircuitBreakerAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.Query(ctx, query, ts)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (c *CircuitBreakerAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.QueryRange(ctx, query, r)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (c *CircuitBreakerAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.Series(ctx, matches, startTime, endTime)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (c *CircuitBreakerAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+	w, err := StreamSeries(ctx, c.API, matches, startTime, endTime, fn)
+	c.record(ctx, err)
+	return w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (c *CircuitBreakerAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if err := c.allow(); err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.GetValue(ctx, start, end, matchers)
+	c.record(ctx, err)
+	return v, w, err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (c *CircuitBreakerAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+	ch, err := StreamGetValue(ctx, c.API, start, end, matchers)
+	if err != nil {
+		c.record(ctx, err)
+		return nil, err
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		c.record(ctx, err)
+		return w, err
+	}), nil
+}
+
+var circuitBreakerStateDesc = prometheus.NewDesc(
+	"promproxy_circuit_breaker_state",
+	"The state of the circuit breaker of the upstream (1 for the current state)",
+	[]string{"upstream", "state"}, nil,
+)
+
+// Describe implements prometheus.Collector
+func (c *CircuitBreakers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- circuitBreakerStateDesc
+}
+
+// Collect implements prometheus.Collector
+func (c *CircuitBreakers) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	apis := make([]*CircuitBreakerAPI, 0, len(c.apis))
+	for _, cb := range c.apis {
+		apis = append(apis, cb)
+	}
+	c.mu.Unlock()
+
+	for _, cb := range apis {
+		current := cb.State()
+		for _, state := range []CircuitState{CircuitClosed, CircuitOpen, CircuitHalfOpen} {
+			v := 0.0
+			if state == current {
+				v = 1
+			}
+			ch <- prometheus.MustNewConstMetric(circuitBreakerStateDesc, prometheus.GaugeValue, v, cb.Name, string(state))
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package promclient
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// Completeness counts the backends which the calls of a request were expected to
+// reach, and how many of them responded. Backends which were routed away from
+// (e.g. because their labels don't match the query) aren't expected, as they
+// can't have any of the data.
+type Completeness struct {
+	mu        sync.Mutex
+	expected  int
+	responded int
+}
+
+func (c *Completeness) add(expected, responded int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.expected += expected
+	c.responded += responded
+}
+
+// Score returns the fraction of the expected backends which responded, false if
+// no backend was expected (e.g. no call was made)
+func (c *Completeness) Score() (float64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expected == 0 {
+		return 0, false
+	}
+	return float64(c.responded) / float64(c.expected), true
+}
+
+type completenessKey struct{}
+
+// WithCompleteness returns a context in which the outermost MultiAPI records the
+// completeness of its calls in the returned Completeness
+func WithCompleteness(ctx context.Context) (context.Context, *Completeness) {
+	c := &Completeness{}
+	return context.WithValue(ctx, completenessKey{}, c), c
+}
+
+// CompletenessFromContext returns the Completeness of the context (nil if there
+// is none)
+func CompletenessFromContext(ctx context.Context) *Completeness {
+	c, _ := ctx.Value(completenessKey{}).(*Completeness)
+	return c
+}
+
+type routedAwayKey struct{}
+
+// RoutedAway marks the call of the context as not sent to its backend as the
+// backend can't have any matching data, so it doesn't count against the
+// completeness of the MultiAPI which made the call
+func RoutedAway(ctx context.Context) {
+	if flag, ok := ctx.Value(routedAwayKey{}).(*int32); ok {
+		atomic.StoreInt32(flag, 1)
+	}
+}
+
+// fanout tracks the backends of a MultiAPI call which were expected and responded.
+// Only the outermost MultiAPI records its completeness, nested ones instead mark
+// their call as routed away if none of their backends were expected.
+type fanout struct {
+	completeness *Completeness
+	routedAway   []int32
+	expected     int
+	responded    int
+	// outermost is whether the call isn't made by another MultiAPI
+	outermost bool
+}
+
+func newFanout(ctx context.Context, n int) *fanout {
+	return &fanout{
+		completeness: CompletenessFromContext(ctx),
+		routedAway:   make([]int32, n),
+		outermost:    ctx.Value(routedAwayKey{}) == nil,
+	}
+}
+
+// context returns the context for the call to the i-th api
+func (f *fanout) context(ctx context.Context, i int) context.Context {
+	if f.completeness != nil {
+		ctx = context.WithValue(ctx, completenessKey{}, (*Completeness)(nil))
+	}
+	return context.WithValue(ctx, routedAwayKey{}, &f.routedAway[i])
+}
+
+// isRoutedAway returns whether the call to the i-th api was routed away
+func (f *fanout) isRoutedAway(i int) bool {
+	return atomic.LoadInt32(&f.routedAway[i]) != 0
+}
+
+// result records the result of the call to the i-th api
+func (f *fanout) result(i int, err error) {
+	if f.isRoutedAway(i) {
+		return
+	}
+	f.expected++
+	if err == nil {
+		f.responded++
+	}
+}
+
+// finish records the completeness of the call made with ctx
+func (f *fanout) finish(ctx context.Context) {
+	if f.expected == 0 && len(f.routedAway) > 0 {
+		RoutedAway(ctx)
+	}
+	if f.completeness != nil {
+		f.completeness.add(f.expected, f.responded)
+	}
+}
@@ -0,0 +1,82 @@
+package promclient
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestCompleteness(t *testing.T) {
+	ok := func() API {
+		return &stubAPI{query: func() model.Value { return model.Vector{} }}
+	}
+	failing := func() API {
+		return &stubErrorAPI{err: errors.New("connection refused")}
+	}
+	// eu only has data with region="eu", so queries for other regions skip it
+	eu := func() API {
+		return &AddLabelClient{API: ok(), Labels: model.LabelSet{"region": "eu"}}
+	}
+
+	tests := []struct {
+		name  string
+		apis  []API
+		score float64
+		// scored is whether any backend was expected
+		scored bool
+	}{
+		{
+			name:   "all responded",
+			apis:   []API{ok(), ok(), ok()},
+			score:  1,
+			scored: true,
+		},
+		{
+			name:   "one of three failed",
+			apis:   []API{ok(), failing(), ok()},
+			score:  2.0 / 3,
+			scored: true,
+		},
+		// Backends which were routed away from aren't expected
+		{
+			name:   "routed away",
+			apis:   []API{ok(), failing(), ok(), eu()},
+			score:  2.0 / 3,
+			scored: true,
+		},
+		// A nested MultiAPI (e.g. a servergroup) responded if any of its apis did,
+		// and isn't expected if all of them were routed away
+		{
+			name:   "nested",
+			apis:   []API{NewMultiAPI([]API{ok(), failing()}, 0, nil, 1), NewMultiAPI([]API{eu(), eu()}, 0, nil, 1), failing()},
+			score:  0.5,
+			scored: true,
+		},
+		{
+			name: "all routed away",
+			apis: []API{eu(), NewMultiAPI([]API{eu()}, 0, nil, 1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			multi := NewMultiAPI(test.apis, 0, nil, 1)
+			ctx, completeness := WithCompleteness(context.TODO())
+			if _, _, err := multi.Query(ctx, `up{region="us"}`, time.Unix(100, 0)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			score, scored := completeness.Score()
+			if scored != test.scored {
+				t.Fatalf("mismatch in scored expected=%v actual=%v", test.scored, scored)
+			}
+			if math.Abs(score-test.score) > 0.001 {
+				t.Fatalf("mismatch in completeness expected=%.2f actual=%.2f", test.score, score)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// MergeMode defines how the MultiAPI combines the values from its apis
+type MergeMode string
+
+// The merge modes
+const (
+	// MergeModeDedupe merges series with the same labels from different apis
+	MergeModeDedupe MergeMode = "dedupe"
+	// MergeModeConcat assumes the apis have disjoint series, and simply appends
+	// their results. This is much cheaper than deduping, but any series that do
+	// exist in more than one api are returned multiple times.
+	MergeModeConcat MergeMode = "concat"
+)
+
+// Validate returns an error if the mode isn't a known MergeMode
+func (m MergeMode) Validate() error {
+	switch m {
+	case "", MergeModeDedupe, MergeModeConcat:
+		return nil
+	default:
+		return fmt.Errorf("unknown merge mode %q", m)
+	}
+}
+
+// DuplicatePolicy defines what is done when duplicate series are found in concat mode
+type DuplicatePolicy string
+
+// The duplicate policies
+const (
+	// DuplicatePolicyWarn adds a warning to the result
+	DuplicatePolicyWarn DuplicatePolicy = "warn"
+	// DuplicatePolicyError fails the request
+	DuplicatePolicyError DuplicatePolicy = "error"
+)
+
+// DuplicateCheck configures the detection of duplicate series in concat mode.
+// Instead of tracking every series only the series whose fingerprint falls in a
+// 1/SampleEvery sample are checked. Since a duplicate series has the same fingerprint
+// in every api it is always either sampled in all of them or none, so any real
+// overlap is caught as long as it covers more than a handful of series.
+type DuplicateCheck struct {
+	// SampleEvery is the inverse of the fraction of series checked (0 disables the check)
+	SampleEvery uint64 `yaml:"sample_every"`
+	// Policy is what to do if duplicates are found
+	Policy DuplicatePolicy `yaml:"policy"`
+}
+
+// Validate returns an error if the policy isn't a known DuplicatePolicy
+func (d DuplicateCheck) Validate() error {
+	switch d.Policy {
+	case "", DuplicatePolicyWarn, DuplicatePolicyError:
+		return nil
+	default:
+		return fmt.Errorf("unknown duplicate policy %q", d.Policy)
+	}
+}
+
+// valueMerger merges the values from the apis of a MultiAPI for a single request
+type valueMerger struct {
+	m *MultiAPI
+	// noDedup concats the series of the apis with their ReplicaLabel, rather than
+	// deduping them (see WithDedup)
+	noDedup bool
+	// seen is the api each sampled series was first returned from
+	seen       map[model.Fingerprint]int
+	duplicates int
+}
+
+func (m *MultiAPI) newValueMerger(ctx context.Context) *valueMerger {
+	v := &valueMerger{m: m}
+	if m.MergeMode != MergeModeConcat && !DedupFromContext(ctx) {
+		v.noDedup = true
+	}
+	if m.MergeMode == MergeModeConcat && m.DuplicateCheck.SampleEvery > 0 {
+		v.seen = make(map[model.Fingerprint]int)
+	}
+	return v
+}
+
+// merge merges `b` from the i-th api into `a`
+func (v *valueMerger) merge(i int, a, b model.Value) (model.Value, error) {
+	if v.noDedup {
+		return promutil.ConcatValues(a, withReplicaLabel(b, v.m.apiNames[i]))
+	}
+	if v.m.MergeMode != MergeModeConcat {
+		return promutil.MergeValuesTyped(v.m.antiAffinity, a, b, v.m.MetricTypes)
+	}
+
+	if v.seen != nil {
+		switch bTyped := b.(type) {
+		case model.Vector:
+			for _, sample := range bTyped {
+				v.sample(i, sample.Metric)
+			}
+		case model.Matrix:
+			for _, stream := range bTyped {
+				v.sample(i, stream.Metric)
+			}
+		}
+	}
+	return promutil.ConcatValues(a, b)
+}
+
+// sample checks whether the series was already returned by another api, if it
+// falls in the sample
+func (v *valueMerger) sample(i int, metric model.Metric) {
+	fp := promutil.NormalizedFingerprint(metric)
+	if uint64(fp)%v.m.DuplicateCheck.SampleEvery != 0 {
+		return
+	}
+	if first, ok := v.seen[fp]; !ok {
+		v.seen[fp] = i
+	} else if first != i {
+		v.duplicates++
+	}
+}
+
+// finish applies the DuplicatePolicy if any duplicates were found
+func (v *valueMerger) finish(warnings promutil.WarningSet) error {
+	if v.duplicates == 0 {
+		return nil
+	}
+	msg := fmt.Sprintf("concat merge found %d duplicate series (sampling 1 in %d) across backends, are the backends really disjoint?", v.duplicates, v.m.DuplicateCheck.SampleEvery)
+	if v.m.DuplicateCheck.Policy == DuplicatePolicyError {
+		return errors.New(msg)
+	}
+	warnings.AddWarning(msg)
+	return nil
+}
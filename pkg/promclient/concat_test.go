@@ -0,0 +1,87 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestMultiAPIConcat(t *testing.T) {
+	// matrix returns a func returning a matrix with a series for each of the instances
+	matrix := func(start, end int) func() model.Value {
+		return func() model.Value {
+			ret := model.Matrix{}
+			for i := start; i < end; i++ {
+				ret = append(ret, &model.SampleStream{
+					Metric: model.Metric{model.MetricNameLabel: "up", "instance": model.LabelValue(fmt.Sprintf("host-%d", i))},
+					Values: []model.SamplePair{{Timestamp: 100, Value: 1}},
+				})
+			}
+			return ret
+		}
+	}
+
+	tests := []struct {
+		apis     []API
+		policy   DuplicatePolicy
+		series   int
+		warnings int
+		err      bool
+	}{
+		// Disjoint backends
+		{
+			apis: []API{
+				&stubAPI{getValue: matrix(0, 100)},
+				&stubAPI{getValue: matrix(100, 200)},
+			},
+			series: 200,
+		},
+		// Overlapping backends
+		{
+			apis: []API{
+				&stubAPI{getValue: matrix(0, 100)},
+				&stubAPI{getValue: matrix(50, 150)},
+			},
+			series:   200,
+			warnings: 1,
+		},
+		{
+			apis: []API{
+				&stubAPI{getValue: matrix(0, 100)},
+				&stubAPI{getValue: matrix(50, 150)},
+			},
+			policy: DuplicatePolicyError,
+			err:    true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			multi := NewMultiAPI(test.apis, model.Time(0), nil, 1)
+			multi.MergeMode = MergeModeConcat
+			// Sample everything so the result doesn't depend on the fingerprints
+			multi.DuplicateCheck = DuplicateCheck{SampleEvery: 1, Policy: test.policy}
+
+			v, w, err := multi.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), nil)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if test.err {
+				return
+			}
+			if len(w) != test.warnings {
+				t.Fatalf("mismatch in warnings expected=%d actual=%v", test.warnings, w)
+			}
+			if test.warnings > 0 && !strings.Contains(w[0], "50 duplicate series") {
+				t.Fatalf("unexpected warning: %v", w)
+			}
+			if series := len(v.(model.Matrix)); series != test.series {
+				t.Fatalf("mismatch in series expected=%d actual=%d", test.series, series)
+			}
+		})
+	}
+}
@@ -2,6 +2,7 @@ package promclient
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -9,12 +10,41 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/logging"
 )
 
+var logger = logging.Component(logging.ComponentPromclient)
+
 // DebugAPI simply logs debug lines for the given API with the given prefix
 type DebugAPI struct {
 	API
 	PrefixMessage string
+	// Redact (if set) is applied to the logged fields, e.g. LabelRedactionAPI.Redact
+	// so the queries and results logged don't hold sensitive label values
+	Redact func(string) string
+}
+
+// withFields returns the logger with the given fields, redacted (if configured)
+func (d *DebugAPI) withFields(fields logrus.Fields) *logrus.Entry {
+	if d.Redact == nil {
+		return logger.WithFields(fields)
+	}
+	redacted := make(logrus.Fields, len(fields))
+	for k, v := range fields {
+		redacted[k] = d.Redact(fmt.Sprint(v))
+	}
+	return logger.WithFields(redacted)
+}
+
+// BackendName returns the name of the backend this API talks to
+func (d *DebugAPI) BackendName() string {
+	return d.PrefixMessage
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (d *DebugAPI) Key() model.LabelSet {
+	return apiKey(d.API)
 }
 
 // LabelNames returns all the unique label names present in the block in sorted order.
@@ -22,19 +52,44 @@ func (d *DebugAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, erro
 	fields := logrus.Fields{
 		"api": "LabelNames",
 	}
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.LabelNames(ctx)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
+		fields["value"] = v
+		fields["warnings"] = w
+		fields["error"] = err
+		d.withFields(fields).Trace(d.PrefixMessage)
+	} else {
+		d.withFields(fields).Debug(d.PrefixMessage)
+	}
+
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (d *DebugAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	fields := logrus.Fields{
+		"api":       "LabelNamesInRange",
+		"startTime": startTime,
+		"endTime":   endTime,
+	}
+	d.withFields(fields).Debug(d.PrefixMessage)
+
+	s := time.Now()
+	v, w, err := LabelNamesInRange(ctx, d.API, startTime, endTime)
+	fields["took"] = time.Now().Sub(s)
+
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 
 	return v, w, err
@@ -46,19 +101,19 @@ func (d *DebugAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 		"api":   "LabelValues",
 		"label": label,
 	}
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.LabelValues(ctx, label)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 
 	return v, w, err
@@ -71,19 +126,19 @@ func (d *DebugAPI) Query(ctx context.Context, query string, ts time.Time) (model
 		"query": query,
 		"ts":    ts,
 	}
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.Query(ctx, query, ts)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 
 	return v, w, err
@@ -96,19 +151,19 @@ func (d *DebugAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 		"query": query,
 		"r":     r,
 	}
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.QueryRange(ctx, query, r)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 
 	return v, w, err
@@ -122,23 +177,52 @@ func (d *DebugAPI) Series(ctx context.Context, matches []string, startTime time.
 		"startTime": startTime,
 		"endTime":   endTime,
 	}
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.Series(ctx, matches, startTime, endTime)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 	return v, w, err
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (d *DebugAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	fields := logrus.Fields{
+		"api":       "StreamSeries",
+		"matches":   matches,
+		"startTime": startTime,
+		"endTime":   endTime,
+	}
+	d.withFields(fields).Debug(d.PrefixMessage)
+
+	s := time.Now()
+	count := 0
+	w, err := StreamSeries(ctx, d.API, matches, startTime, endTime, func(ls model.LabelSet) error {
+		count++
+		return fn(ls)
+	})
+	fields["took"] = time.Now().Sub(s)
+	fields["count"] = count
+
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
+		fields["warnings"] = w
+		fields["error"] = err
+		d.withFields(fields).Trace(d.PrefixMessage)
+	} else {
+		d.withFields(fields).Debug(d.PrefixMessage)
+	}
+	return w, err
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (d *DebugAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	fields := logrus.Fields{
@@ -148,22 +232,52 @@ func (d *DebugAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 		"matchers": matchers,
 	}
 
-	logrus.WithFields(fields).Debug(d.PrefixMessage)
+	d.withFields(fields).Debug(d.PrefixMessage)
 
 	s := time.Now()
 	v, w, err := d.API.GetValue(ctx, start, end, matchers)
 	fields["took"] = time.Now().Sub(s)
 
-	if logrus.GetLevel() > logrus.DebugLevel {
+	if logging.IsLevelEnabled(logging.ComponentPromclient, logrus.TraceLevel) {
 		fields["value"] = v
 		fields["warnings"] = w
 		fields["error"] = err
-		logrus.WithFields(fields).Trace(d.PrefixMessage)
+		d.withFields(fields).Trace(d.PrefixMessage)
 	} else {
-		logrus.WithFields(fields).Debug(d.PrefixMessage)
+		d.withFields(fields).Debug(d.PrefixMessage)
 	}
 
 	return v, w, err
 }
 
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (d *DebugAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	fields := logrus.Fields{
+		"api":      "StreamGetValue",
+		"start":    start,
+		"end":      end,
+		"matchers": matchers,
+	}
+	d.withFields(fields).Debug(d.PrefixMessage)
+
+	s := time.Now()
+	ch, err := StreamGetValue(ctx, d.API, start, end, matchers)
+	if err != nil {
+		fields["took"] = time.Now().Sub(s)
+		fields["error"] = err
+		d.withFields(fields).Debug(d.PrefixMessage)
+		return nil, err
+	}
+
+	// The series aren't held on to, so only the end of the stream is logged
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		fields["took"] = time.Now().Sub(s)
+		fields["warnings"] = w
+		fields["error"] = err
+		d.withFields(fields).Debug(d.PrefixMessage)
+		return w, err
+	}), nil
+}
+
 
@@ -0,0 +1,61 @@
+package promclient
+
+import (
+	"context"
+
+	"github.com/prometheus/common/model"
+)
+
+// ReplicaLabel is added to the series of a query with dedup disabled (see
+// WithDedup), its value is the api (e.g. host) the series was returned from
+const ReplicaLabel = "promproxy_replica"
+
+type dedupKey struct{}
+
+// WithDedup returns a context whose queries are deduplicated by the MultiAPIs in
+// the (default) dedupe MergeMode only if dedup is set. Without dedup the series of
+// all the apis are returned, each with its ReplicaLabel, e.g. to debug replicas
+// which diverge. This only affects the queries made with the context.
+func WithDedup(ctx context.Context, dedup bool) context.Context {
+	return context.WithValue(ctx, dedupKey{}, dedup)
+}
+
+// DedupFromContext returns whether the queries of the context are deduplicated
+// (true if unset)
+func DedupFromContext(ctx context.Context) bool {
+	dedup, ok := ctx.Value(dedupKey{}).(bool)
+	return !ok || dedup
+}
+
+// withReplicaLabel returns the value with the ReplicaLabel of its series set to
+// replica, keeping the labels of series which have one already (e.g. from a
+// nested MultiAPI). The series are copied, as the value may be shared.
+func withReplicaLabel(v model.Value, replica string) model.Value {
+	label := func(metric model.Metric) model.Metric {
+		if _, ok := metric[ReplicaLabel]; ok {
+			return metric
+		}
+		metric = metric.Clone()
+		metric[ReplicaLabel] = model.LabelValue(replica)
+		return metric
+	}
+	switch value := v.(type) {
+	case model.Vector:
+		ret := make(model.Vector, len(value))
+		for i, sample := range value {
+			s := *sample
+			s.Metric = label(s.Metric)
+			ret[i] = &s
+		}
+		return ret
+	case model.Matrix:
+		ret := make(model.Matrix, len(value))
+		for i, stream := range value {
+			s := *stream
+			s.Metric = label(s.Metric)
+			ret[i] = &s
+		}
+		return ret
+	}
+	return v
+}
@@ -0,0 +1,51 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestMultiAPIDedup(t *testing.T) {
+	vector := model.Vector{{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: 1000}}
+	multi := NewMultiAPI([]API{&valueAPI{v: vector}, &valueAPI{v: vector}}, 0, nil, 1)
+
+	tests := []struct {
+		ctx      context.Context
+		expected model.Vector
+	}{
+		{
+			ctx:      context.TODO(),
+			expected: vector,
+		},
+		{
+			ctx:      WithDedup(context.TODO(), true),
+			expected: vector,
+		},
+		{
+			ctx: WithDedup(context.TODO(), false),
+			expected: model.Vector{
+				{Metric: model.Metric{"job": "a", ReplicaLabel: "0"}, Value: 1, Timestamp: 1000},
+				{Metric: model.Metric{"job": "a", ReplicaLabel: "1"}, Value: 1, Timestamp: 1000},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		v, _, err := multi.Query(test.ctx, "up", time.Now())
+		if err != nil {
+			t.Fatalf("%d: unexpected error: %v", i, err)
+		}
+		if !reflect.DeepEqual(v, test.expected) {
+			t.Fatalf("%d: mismatch in value expected=%v actual=%v", i, test.expected, v)
+		}
+	}
+
+	// The series of the apis are left alone
+	if _, ok := vector[0].Metric[ReplicaLabel]; ok {
+		t.Fatalf("mismatch in series of the api expected=%v actual=%v", model.Metric{"job": "a"}, vector[0].Metric)
+	}
+}
@@ -0,0 +1,129 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+)
+
+// DryRunWarning is the warning of the results of a dry run
+const DryRunWarning = "dry run: no requests were sent downstream, the result is empty"
+
+// DryRunRequest is a downstream request which a dry run didn't send
+type DryRunRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	// Body is the form body of POST requests (e.g. the query and its time range)
+	Body string `json:"body,omitempty"`
+}
+
+// DryRun records the downstream requests of the calls made with its context
+// instead of sending them. The routing of the calls is resolved as usual, so the
+// requests are exactly those which would have been sent.
+type DryRun struct {
+	mu       sync.Mutex
+	requests []DryRunRequest
+}
+
+func (d *DryRun) add(r DryRunRequest) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.requests = append(d.requests, r)
+}
+
+// Requests returns the downstream requests recorded so far
+func (d *DryRun) Requests() []DryRunRequest {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DryRunRequest(nil), d.requests...)
+}
+
+type dryRunKey struct{}
+
+// WithDryRun returns a context whose calls are dry runs, recording their
+// downstream requests in the returned DryRun
+func WithDryRun(ctx context.Context) (context.Context, *DryRun) {
+	d := &DryRun{}
+	return context.WithValue(ctx, dryRunKey{}, d), d
+}
+
+// DryRunFromContext returns the DryRun of the context (nil if the calls of the
+// context aren't dry runs)
+func DryRunFromContext(ctx context.Context) *DryRun {
+	d, _ := ctx.Value(dryRunKey{}).(*DryRun)
+	return d
+}
+
+// dryRunSecretHeaders are the headers whose values aren't recorded
+var dryRunSecretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// DryRunRoundTripper records the requests of dry runs (see WithDryRun) rather than
+// sending them, answering them with an empty result instead. It has to be the
+// innermost RoundTripper so the recorded requests have all of their headers.
+type DryRunRoundTripper struct {
+	RoundTripper http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction, unless the request is a dry run
+func (d *DryRunRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	dryRun := DryRunFromContext(req.Context())
+	if dryRun == nil {
+		return d.RoundTripper.RoundTrip(req)
+	}
+
+	r := DryRunRequest{Method: req.Method, URL: req.URL.String(), Header: make(http.Header, len(req.Header))}
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	for _, k := range dryRunSecretHeaders {
+		if r.Header.Get(k) != "" {
+			r.Header.Set(k, "<secret>")
+		}
+	}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		r.Body = string(b)
+	}
+	dryRun.add(r)
+
+	return dryRunResponse(req), nil
+}
+
+// dryRunResponse returns an empty response to the request
+func dryRunResponse(req *http.Request) *http.Response {
+	resp := &http.Response{
+		Status:     "200 OK",
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	// Anything but the prometheus API (e.g. scrapes) gets an empty body
+	var body string
+	if strings.Contains(req.URL.Path, "/api/v1/") {
+		switch path.Base(req.URL.Path) {
+		case "query":
+			body = `{"status":"success","data":{"resultType":"vector","result":[]}}`
+		case "query_range":
+			body = `{"status":"success","data":{"resultType":"matrix","result":[]}}`
+		default:
+			body = `{"status":"success","data":[]}`
+		}
+		resp.Header.Set("Content-Type", "application/json")
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+	resp.ContentLength = int64(len(body))
+	return resp
+}
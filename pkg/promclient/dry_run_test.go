@@ -0,0 +1,81 @@
+package promclient
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// roundTripperFunc adapts a func into a http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestDryRunRoundTripper(t *testing.T) {
+	sent := 0
+	dryRunRT := &DryRunRoundTripper{RoundTripper: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return nil, &url.Error{Op: "Get", URL: req.URL.String(), Err: context.Canceled}
+	})}
+	// The secrets are set outside of the DryRunRoundTripper, as in the servergroups
+	rt := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req.Header.Set("Authorization", "Bearer secret")
+		return dryRunRT.RoundTrip(req)
+	})
+
+	client, err := api.NewClient(api.Config{Address: "http://prom:9090", RoundTripper: rt})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, _ := url.Parse("http://prom:9090")
+	var a API = &SeriesStreamClient{
+		API:    &PromAPIV1{v1.NewAPI(client)},
+		Client: &http.Client{Transport: rt},
+		URL:    u,
+	}
+
+	ctx, dryRun := WithDryRun(context.TODO())
+	now := time.Unix(1000, 0)
+
+	// Every call gets an empty result without an error
+	if v, _, err := a.Query(ctx, "up", now); err != nil || v.(model.Vector).Len() != 0 {
+		t.Fatalf("mismatch in query result expected=empty actual=%v (%v)", v, err)
+	}
+	if v, _, err := a.QueryRange(ctx, "up", v1.Range{Start: now.Add(-time.Hour), End: now, Step: time.Minute}); err != nil || v.(model.Matrix).Len() != 0 {
+		t.Fatalf("mismatch in query_range result expected=empty actual=%v (%v)", v, err)
+	}
+	if v, _, err := a.Series(ctx, []string{"up"}, now.Add(-time.Hour), now); err != nil || len(v) != 0 {
+		t.Fatalf("mismatch in series result expected=empty actual=%v (%v)", v, err)
+	}
+	if _, err := StreamSeries(ctx, a, []string{"up"}, now.Add(-time.Hour), now, func(model.LabelSet) error { return nil }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _, err := a.LabelValues(ctx, "job"); err != nil || len(v) != 0 {
+		t.Fatalf("mismatch in label values result expected=empty actual=%v (%v)", v, err)
+	}
+
+	if sent != 0 {
+		t.Fatalf("mismatch in requests sent downstream expected=0 actual=%d", sent)
+	}
+	requests := dryRun.Requests()
+	if len(requests) != 5 {
+		t.Fatalf("mismatch in recorded requests expected=%d actual=%d", 5, len(requests))
+	}
+	for _, r := range requests {
+		if auth := r.Header.Get("Authorization"); auth != "<secret>" {
+			t.Fatalf("mismatch in Authorization header expected=%q actual=%q", "<secret>", auth)
+		}
+	}
+
+	// Without a dry run the requests are sent
+	a.Query(context.TODO(), "up", now)
+	if sent == 0 {
+		t.Fatalf("request without dry run not sent")
+	}
+}
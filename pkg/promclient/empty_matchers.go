@@ -0,0 +1,145 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// EmptyMatchersPolicy defines how a Series call is handled when stripping the
+// matchers on the labels of a client leaves a selector without any matcher that
+// selects series (e.g. `{region="eu"}` sent to the servergroup of region "eu").
+// Prometheus rejects such a selector, so it can't be forwarded as is.
+type EmptyMatchersPolicy string
+
+// The empty matchers policies
+const (
+	// EmptyMatchersError fails the call with an ErrEmptyMatchers (the default)
+	EmptyMatchersError EmptyMatchersPolicy = "error"
+	// EmptyMatchersCatchAll replaces the selector with one matching all series, of
+	// which only up to a limit are returned
+	EmptyMatchersCatchAll EmptyMatchersPolicy = "catch_all"
+)
+
+// Validate returns an error if the policy isn't known
+func (p EmptyMatchersPolicy) Validate() error {
+	switch p {
+	case "", EmptyMatchersError, EmptyMatchersCatchAll:
+		return nil
+	default:
+		return fmt.Errorf("unknown empty matchers policy %q", p)
+	}
+}
+
+// ErrEmptyMatchers is returned from Series calls with a selector which has no
+// matchers selecting series left after stripping the matchers on the labels
+type ErrEmptyMatchers struct {
+	Match  string
+	Labels model.LabelSet
+}
+
+func (e *ErrEmptyMatchers) Error() string {
+	return fmt.Sprintf("series selector %s has no matchers left after stripping the labels %v, at least one matcher on another label is required", e.Match, e.Labels)
+}
+
+// errEmptyMatchersLimit stops a catch all StreamSeries call at the limit
+var errEmptyMatchersLimit = fmt.Errorf("empty matchers limit reached")
+
+// EmptyMatchersConfig is the handling of Series selectors left without matchers
+// by a client stripping the matchers on its labels
+type EmptyMatchersConfig struct {
+	// Policy is the EmptyMatchersPolicy (EmptyMatchersError if unset)
+	Policy EmptyMatchersPolicy
+	// Limit is the max number of series returned for a catch all selector (no
+	// limit if 0)
+	Limit int
+}
+
+// filterMatches strips the matchers on the labels from the Series matches,
+// returning the matches to forward and whether one was replaced by a catch all.
+// Matches which don't match the labels are skipped.
+func (e EmptyMatchersConfig) filterMatches(ls model.LabelSet, matches []string) ([]string, bool, error) {
+	filteredMatches := make([]string, 0, len(matches))
+	catchAll := false
+	for _, match := range matches {
+		matchers, err := promql.ParseMetricSelector(match)
+		if err != nil {
+			return nil, false, err
+		}
+
+		filteredMatchers, ok := stripMatchers(ls, matchers)
+		// If we didn't match, lets skip
+		if !ok {
+			continue
+		}
+		if len(filteredMatchers) < len(matchers) && !selectsSeries(filteredMatchers) {
+			if e.Policy != EmptyMatchersCatchAll {
+				return nil, false, &ErrEmptyMatchers{Match: match, Labels: ls}
+			}
+			filteredMatchers = []*labels.Matcher{matchAllMatcher()}
+			catchAll = true
+		}
+		filteredMatches = append(filteredMatches, selectorString(filteredMatchers))
+	}
+	return filteredMatches, catchAll, nil
+}
+
+// limitWarning is the warning of a catch all Series call which hit the limit
+func (e EmptyMatchersConfig) limitWarning() string {
+	return fmt.Sprintf("series selector without matchers was limited to %d series", e.Limit)
+}
+
+// limitSeries limits the result of a catch all Series call
+func (e EmptyMatchersConfig) limitSeries(v []model.LabelSet, w api.Warnings) ([]model.LabelSet, api.Warnings) {
+	if e.Limit > 0 && len(v) > e.Limit {
+		return v[:e.Limit], append(w, e.limitWarning())
+	}
+	return v, w
+}
+
+// streamSeries calls StreamSeries on the client, stopping a catch all call at the limit
+func (e EmptyMatchersConfig) streamSeries(ctx context.Context, client API, matches []string, startTime time.Time, endTime time.Time, catchAll bool, fn SeriesFunc) (api.Warnings, error) {
+	if !catchAll || e.Limit <= 0 {
+		return StreamSeries(ctx, client, matches, startTime, endTime, fn)
+	}
+
+	count := 0
+	w, err := StreamSeries(ctx, client, matches, startTime, endTime, func(lset model.LabelSet) error {
+		if count >= e.Limit {
+			return errEmptyMatchersLimit
+		}
+		count++
+		return fn(lset)
+	})
+	if err == errEmptyMatchersLimit {
+		return append(w, e.limitWarning()), nil
+	}
+	return w, err
+}
+
+// selectsSeries returns whether any of the matchers doesn't match the empty
+// string, which prometheus requires of a selector
+func selectsSeries(matchers []*labels.Matcher) bool {
+	for _, m := range matchers {
+		if !m.Matches("") {
+			return true
+		}
+	}
+	return false
+}
+
+// selectorString returns the series selector of the matchers
+func selectorString(matchers []*labels.Matcher) string {
+	selector := &promql.VectorSelector{LabelMatchers: matchers}
+	for _, matcher := range matchers {
+		if matcher.Name == model.MetricNameLabel && matcher.Type == labels.MatchEqual {
+			selector.Name = matcher.Value
+		}
+	}
+	return selector.String()
+}
@@ -0,0 +1,135 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// seriesCaptureAPI records the matches sent to it and returns its series
+type seriesCaptureAPI struct {
+	API
+	matches []string
+	series  []model.LabelSet
+}
+
+func (s *seriesCaptureAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	s.matches = append(s.matches, matches...)
+	ret := make([]model.LabelSet, len(s.series))
+	for i, lset := range s.series {
+		ret[i] = lset.Clone()
+	}
+	return ret, nil, nil
+}
+
+func TestEmptyMatchers(t *testing.T) {
+	series := []model.LabelSet{
+		{model.MetricNameLabel: "up", "job": "a"},
+		{model.MetricNameLabel: "up", "job": "b"},
+	}
+
+	tests := []struct {
+		match  string
+		config EmptyMatchersConfig
+		// forwarded is the match we expect the downstream to see, empty means the
+		// call should have failed with an ErrEmptyMatchers
+		forwarded string
+		count     int
+		limited   bool
+	}{
+		// Selectors with matchers left are forwarded regardless of the policy
+		{
+			match:     `{prometheus="eu",job="a"}`,
+			forwarded: `{job="a"}`,
+			count:     2,
+		},
+		{
+			match:     `up{prometheus="eu"}`,
+			config:    EmptyMatchersConfig{Policy: EmptyMatchersCatchAll, Limit: 1},
+			forwarded: `up`,
+			count:     2,
+		},
+		// By default a selector without matchers fails
+		{
+			match: `{prometheus="eu"}`,
+		},
+		{
+			match:  `{prometheus="eu"}`,
+			config: EmptyMatchersConfig{Policy: EmptyMatchersError},
+		},
+		// Matchers which match the empty string don't select any series
+		{
+			match: `{prometheus="eu",job=~".*"}`,
+		},
+		// The catch all is limited
+		{
+			match:     `{prometheus="eu"}`,
+			config:    EmptyMatchersConfig{Policy: EmptyMatchersCatchAll, Limit: 1},
+			forwarded: `{__name__=~".+"}`,
+			count:     1,
+			limited:   true,
+		},
+		{
+			match:     `{prometheus=~"e.*",job=~".*"}`,
+			config:    EmptyMatchersConfig{Policy: EmptyMatchersCatchAll, Limit: 5},
+			forwarded: `{__name__=~".+"}`,
+			count:     2,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for _, method := range []string{"series", "stream_series"} {
+				capture := &seriesCaptureAPI{series: series}
+				client := &ExternalLabelClient{
+					API:           capture,
+					Labels:        model.LabelSet{"prometheus": "eu"},
+					EmptyMatchers: test.config,
+				}
+
+				var (
+					v   []model.LabelSet
+					w   api.Warnings
+					err error
+				)
+				switch method {
+				case "series":
+					v, w, err = client.Series(context.TODO(), []string{test.match}, time.Now(), time.Now())
+				case "stream_series":
+					w, err = client.StreamSeries(context.TODO(), []string{test.match}, time.Now(), time.Now(), func(lset model.LabelSet) error {
+						v = append(v, lset)
+						return nil
+					})
+				}
+
+				if test.forwarded == "" {
+					if _, ok := err.(*ErrEmptyMatchers); !ok {
+						t.Fatalf("%s: mismatch in error expected=%T actual=%v", method, &ErrEmptyMatchers{}, err)
+					}
+					if len(capture.matches) > 0 {
+						t.Fatalf("%s: call unexpectedly routed to downstream: %v", method, capture.matches)
+					}
+					continue
+				}
+
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", method, err)
+				}
+				if expected := []string{test.forwarded}; !reflect.DeepEqual(capture.matches, expected) {
+					t.Fatalf("%s: mismatch in forwarded expected=%v actual=%v", method, expected, capture.matches)
+				}
+				if len(v) != test.count {
+					t.Fatalf("%s: mismatch in count expected=%d actual=%d", method, test.count, len(v))
+				}
+				if limited := len(w) > 0; limited != test.limited {
+					t.Fatalf("%s: mismatch in limited expected=%v actual=%v (%v)", method, test.limited, limited, w)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// ExternalLabelClient routes queries to the wrapped API based on a set of external
+// labels. Unlike AddLabelClient these labels aren't added to the results, they
+// only exist to select which downstreams a query is sent to. Queries with matchers
+// that don't match the external labels are skipped (return nil,nil) and the
+// matchers that do match are stripped before the query is forwarded (as the
+// downstream doesn't have these as real series labels)
+type ExternalLabelClient struct {
+	API
+	Labels model.LabelSet
+	// EmptyMatchers is the handling of Series selectors which only had matchers
+	// on the Labels
+	EmptyMatchers EmptyMatchersConfig
+}
+
+// Key defines the labelset which identifies this client
+func (c *ExternalLabelClient) Key() model.LabelSet {
+	return c.Labels
+}
+
+// filterQuery strips the external label matchers from the given query, returning
+// the query to forward and whether the query matched our labels at all
+func (c *ExternalLabelClient) filterQuery(ctx context.Context, query string) (string, bool, error) {
+	// Parse out the promql query into expressions etc.
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return "", false, err
+	}
+
+	// Walk the expression, to filter out any LabelMatchers that match etc.
+	filterVisitor := &LabelFilterVisitor{c.Labels, true}
+	if _, err := promql.Walk(ctx, filterVisitor, &promql.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+		return "", false, err
+	}
+	if !filterVisitor.filterMatch {
+		return "", false, nil
+	}
+	return e.String(), true, nil
+}
+
+// Query performs a query for the given time.
+func (c *ExternalLabelClient) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	filteredQuery, ok, err := c.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		RoutedAway(ctx)
+		return nil, nil, nil
+	}
+	return c.API.Query(ctx, filteredQuery, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (c *ExternalLabelClient) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	filteredQuery, ok, err := c.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		RoutedAway(ctx)
+		return nil, nil, nil
+	}
+	return c.API.QueryRange(ctx, filteredQuery, r)
+}
+
+// Series finds series by label matchers.
+func (c *ExternalLabelClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	filteredMatches, catchAll, err := c.EmptyMatchers.filterMatches(c.Labels, matches)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// If no matchers remain, then we don't have anything -- so skip
+	if len(filteredMatches) == 0 {
+		RoutedAway(ctx)
+		return nil, nil, nil
+	}
+
+	v, w, err := c.API.Series(ctx, filteredMatches, startTime, endTime)
+	if err != nil || !catchAll {
+		return v, w, err
+	}
+	v, w = c.EmptyMatchers.limitSeries(v, w)
+	return v, w, nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (c *ExternalLabelClient) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	filteredMatches, catchAll, err := c.EmptyMatchers.filterMatches(c.Labels, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no matchers remain, then we don't have anything -- so skip
+	if len(filteredMatches) == 0 {
+		RoutedAway(ctx)
+		return nil, nil
+	}
+
+	return c.EmptyMatchers.streamSeries(ctx, c.API, filteredMatches, startTime, endTime, catchAll, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (c *ExternalLabelClient) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, c.API, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (c *ExternalLabelClient) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	filteredMatchers, ok := FilterMatchers(c.Labels, matchers)
+	if !ok {
+		RoutedAway(ctx)
+		return nil, nil, nil
+	}
+	return c.API.GetValue(ctx, start, end, filteredMatchers)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (c *ExternalLabelClient) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	filteredMatchers, ok := FilterMatchers(c.Labels, matchers)
+	if !ok {
+		RoutedAway(ctx)
+		return emptyValueStream(), nil
+	}
+	return StreamGetValue(ctx, c.API, start, end, filteredMatchers)
+}
@@ -0,0 +1,207 @@
+package promclient
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// captureAPI records the queries and matchers sent to it
+type captureAPI struct {
+	API
+	queries  []string
+	matchers []*labels.Matcher
+}
+
+func (c *captureAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	c.queries = append(c.queries, query)
+	return model.Vector{}, nil, nil
+}
+
+func (c *captureAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	c.queries = append(c.queries, query)
+	return model.Matrix{}, nil, nil
+}
+
+func (c *captureAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	c.queries = append(c.queries, matches...)
+	return []model.LabelSet{}, nil, nil
+}
+
+func (c *captureAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	c.matchers = matchers
+	return model.Matrix{}, nil, nil
+}
+
+func TestExternalLabelClient(t *testing.T) {
+	externalLabels := model.LabelSet{"prometheus": "eu"}
+
+	tests := []struct {
+		query string
+		// forwarded is the query we expect the downstream to see, empty means
+		// the query should not have been routed to the downstream
+		forwarded string
+		// emptySeries marks selectors without matchers after stripping, which
+		// Series handles per the EmptyMatchersPolicy (see TestEmptyMatchers)
+		emptySeries bool
+	}{
+		// No external label matchers, everything is forwarded
+		{
+			query:     `up`,
+			forwarded: `up`,
+		},
+		// Matching external label is stripped
+		{
+			query:     `up{prometheus="eu"}`,
+			forwarded: `up`,
+		},
+		{
+			query:     `up{prometheus="eu",job="a"}`,
+			forwarded: `up{job="a"}`,
+		},
+		{
+			query:     `sum(rate(up{prometheus=~"e.*",job="a"}[5m]))`,
+			forwarded: `sum(rate(up{job="a"}[5m]))`,
+		},
+		// If only external labels are in the selector we still need a valid selector
+		{
+			query:       `{prometheus="eu"}`,
+			forwarded:   `{__name__=~".+"}`,
+			emptySeries: true,
+		},
+		// Non-matching external labels are not routed here
+		{
+			query: `up{prometheus="us"}`,
+		},
+		{
+			query: `up{prometheus!="eu"}`,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			for _, method := range []string{"query", "query_range", "series"} {
+				capture := &captureAPI{}
+				client := &ExternalLabelClient{API: capture, Labels: externalLabels}
+
+				var err error
+				switch method {
+				case "query":
+					_, _, err = client.Query(context.TODO(), test.query, time.Now())
+				case "query_range":
+					_, _, err = client.QueryRange(context.TODO(), test.query, v1.Range{Start: time.Now(), End: time.Now(), Step: time.Second})
+				case "series":
+					// Series only accepts selectors
+					if _, parseErr := promql.ParseMetricSelector(test.query); parseErr != nil || test.emptySeries {
+						continue
+					}
+					_, _, err = client.Series(context.TODO(), []string{test.query}, time.Now(), time.Now())
+				}
+				if err != nil {
+					t.Fatalf("%s: unexpected error: %v", method, err)
+				}
+
+				if test.forwarded == "" {
+					if len(capture.queries) > 0 {
+						t.Fatalf("%s: query unexpectedly routed to downstream: %v", method, capture.queries)
+					}
+					continue
+				}
+
+				if len(capture.queries) != 1 {
+					t.Fatalf("%s: expected query to be routed to downstream", method)
+				}
+				// Ensure that the query the backend sees is still valid
+				e, err := promql.ParseExpr(capture.queries[0])
+				if err != nil {
+					t.Fatalf("%s: invalid query forwarded %s: %v", method, capture.queries[0], err)
+				}
+				expected, err := promql.ParseExpr(test.forwarded)
+				if err != nil {
+					t.Fatalf("%s: invalid expected query: %v", method, err)
+				}
+				if e.String() != expected.String() {
+					t.Fatalf("%s: mismatch in forwarded query expected=%s actual=%s", method, expected, e)
+				}
+			}
+		})
+	}
+}
+
+func TestExternalLabelClientGetValue(t *testing.T) {
+	externalLabels := model.LabelSet{"prometheus": "eu"}
+
+	newMatcher := func(t labels.MatchType, n, v string) *labels.Matcher {
+		m, err := labels.NewMatcher(t, n, v)
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}
+
+	tests := []struct {
+		matchers  []*labels.Matcher
+		forwarded []*labels.Matcher
+		routed    bool
+	}{
+		{
+			matchers: []*labels.Matcher{
+				newMatcher(labels.MatchEqual, model.MetricNameLabel, "up"),
+				newMatcher(labels.MatchEqual, "prometheus", "eu"),
+			},
+			forwarded: []*labels.Matcher{
+				newMatcher(labels.MatchEqual, model.MetricNameLabel, "up"),
+			},
+			routed: true,
+		},
+		{
+			matchers: []*labels.Matcher{
+				newMatcher(labels.MatchEqual, "prometheus", "eu"),
+			},
+			forwarded: []*labels.Matcher{
+				newMatcher(labels.MatchRegexp, model.MetricNameLabel, ".+"),
+			},
+			routed: true,
+		},
+		{
+			matchers: []*labels.Matcher{
+				newMatcher(labels.MatchEqual, model.MetricNameLabel, "up"),
+				newMatcher(labels.MatchEqual, "prometheus", "us"),
+			},
+			routed: false,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			capture := &captureAPI{}
+			client := &ExternalLabelClient{API: capture, Labels: externalLabels}
+			if _, _, err := client.GetValue(context.TODO(), time.Now(), time.Now(), test.matchers); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if !test.routed {
+				if capture.matchers != nil {
+					t.Fatalf("unexpectedly routed to downstream: %v", capture.matchers)
+				}
+				return
+			}
+
+			if len(capture.matchers) != len(test.forwarded) {
+				t.Fatalf("mismatch in forwarded matchers expected=%v actual=%v", test.forwarded, capture.matchers)
+			}
+			for j, m := range test.forwarded {
+				if m.String() != capture.matchers[j].String() {
+					t.Fatalf("mismatch in forwarded matchers expected=%v actual=%v", test.forwarded, capture.matchers)
+				}
+			}
+		})
+	}
+}
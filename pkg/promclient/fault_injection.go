@@ -0,0 +1,462 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/sirupsen/logrus"
+)
+
+// The methods of a Fault
+const (
+	FaultMethodLabelNames  = "label_names"
+	FaultMethodLabelValues = "label_values"
+	FaultMethodQuery       = "query"
+	FaultMethodQueryRange  = "query_range"
+	FaultMethodSeries      = "series"
+	FaultMethodGetValue    = "get_value"
+)
+
+// faultMethods are the known methods of a Fault
+var faultMethods = map[string]struct{}{
+	FaultMethodLabelNames:  {},
+	FaultMethodLabelValues: {},
+	FaultMethodQuery:       {},
+	FaultMethodQueryRange:  {},
+	FaultMethodSeries:      {},
+	FaultMethodGetValue:    {},
+}
+
+// MaxFaultDuration is the longest a Fault may be active for, so a forgotten drill
+// ends on its own
+const MaxFaultDuration = time.Hour
+
+// FaultWarningPrefix starts the warnings of the calls with an injected fault
+const FaultWarningPrefix = "injected fault (drill): "
+
+// ErrInjectedFault is the error of a call failed by an injected Fault
+type ErrInjectedFault struct {
+	Upstream string
+	Method   string
+}
+
+func (e *ErrInjectedFault) Error() string {
+	return fmt.Sprintf("injected fault (drill): %s of upstream %q failed", e.Method, e.Upstream)
+}
+
+// Fault is injected into the calls of the FaultInjectionAPIs matching its Upstream
+// and Method (empty matches all)
+type Fault struct {
+	Upstream string `json:"upstream"`
+	Method   string `json:"method"`
+	// Latency is added to the calls
+	Latency time.Duration `json:"latency"`
+	// ErrorRate is the fraction of the calls failed with an ErrInjectedFault
+	ErrorRate float64 `json:"error_rate"`
+	// TruncateRate is the fraction of the results (e.g. series) dropped
+	TruncateRate float64 `json:"truncate_rate"`
+	// Warning (if set) is added to the warnings of the calls
+	Warning string `json:"warning,omitempty"`
+	// Expires is when the fault is removed
+	Expires time.Time `json:"expires"`
+}
+
+// Validate returns an error if the fault isn't valid
+func (f Fault) Validate() error {
+	if f.Method != "" {
+		if _, ok := faultMethods[f.Method]; !ok {
+			return fmt.Errorf("unknown fault method %q", f.Method)
+		}
+	}
+	if f.Latency < 0 {
+		return fmt.Errorf("fault latency must not be negative")
+	}
+	if f.ErrorRate < 0 || f.ErrorRate > 1 || f.TruncateRate < 0 || f.TruncateRate > 1 {
+		return fmt.Errorf("fault error_rate and truncate_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// faultKey identifies the fault of an upstream and method
+type faultKey struct {
+	upstream string
+	method   string
+}
+
+// DefaultFaultInjector is the FaultInjector used by the servergroups
+var DefaultFaultInjector = NewFaultInjector()
+
+// NewFaultInjector returns a FaultInjector without faults
+func NewFaultInjector() *FaultInjector {
+	return &FaultInjector{
+		faults:   make(map[faultKey]Fault),
+		injected: make(map[injectedKey]float64),
+		now:      time.Now,
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())).Float64,
+	}
+}
+
+// FaultInjector holds the faults injected into the FaultInjectionAPIs, which are
+// set at runtime for resilience drills. The faults expire on their own.
+type FaultInjector struct {
+	mu       sync.Mutex
+	faults   map[faultKey]Fault
+	injected map[injectedKey]float64
+	now      func() time.Time
+	rand     func() float64
+}
+
+// Set injects the fault for the duration (at most MaxFaultDuration), replacing the
+// fault of the same upstream and method
+func (f *FaultInjector) Set(fault Fault, d time.Duration) (Fault, error) {
+	if err := fault.Validate(); err != nil {
+		return fault, err
+	}
+	if d <= 0 || d > MaxFaultDuration {
+		return fault, fmt.Errorf("fault duration must be positive and at most %s", MaxFaultDuration)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	fault.Expires = f.now().Add(d)
+	f.faults[faultKey{fault.Upstream, fault.Method}] = fault
+	logger.WithFields(logrus.Fields{
+		"drill":    true,
+		"upstream": fault.Upstream,
+		"method":   fault.Method,
+		"expires":  fault.Expires,
+	}).Warn("Fault injection set")
+	return fault, nil
+}
+
+// Clear removes the fault of the upstream and method
+func (f *FaultInjector) Clear(upstream, method string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.faults, faultKey{upstream, method})
+	logger.WithFields(logrus.Fields{
+		"drill":    true,
+		"upstream": upstream,
+		"method":   method,
+	}).Warn("Fault injection cleared")
+}
+
+// Faults returns the active faults
+func (f *FaultInjector) Faults() []Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	faults := make([]Fault, 0, len(f.faults))
+	for _, fault := range f.faults {
+		faults = append(faults, fault)
+	}
+	sort.Slice(faults, func(i, j int) bool {
+		if faults[i].Upstream != faults[j].Upstream {
+			return faults[i].Upstream < faults[j].Upstream
+		}
+		return faults[i].Method < faults[j].Method
+	})
+	return faults
+}
+
+// expire removes the expired faults, f.mu must be held
+func (f *FaultInjector) expire() {
+	now := f.now()
+	for k, fault := range f.faults {
+		if !now.Before(fault.Expires) {
+			delete(f.faults, k)
+		}
+	}
+}
+
+// fault returns the fault of a call, the most specific one which matches
+func (f *FaultInjector) fault(upstream, method string) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.faults) == 0 {
+		return Fault{}, false
+	}
+	f.expire()
+	for _, k := range []faultKey{{upstream, method}, {upstream, ""}, {"", method}, {"", ""}} {
+		if fault, ok := f.faults[k]; ok {
+			return fault, true
+		}
+	}
+	return Fault{}, false
+}
+
+// chance returns true with the probability p
+func (f *FaultInjector) chance(p float64) bool {
+	if p <= 0 {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.rand() < p
+}
+
+// injectedKey identifies the count of an injected kind of fault
+type injectedKey struct {
+	upstream string
+	method   string
+	kind     string
+}
+
+// record counts and logs an injected fault
+func (f *FaultInjector) record(upstream, method, kind string) {
+	f.mu.Lock()
+	f.injected[injectedKey{upstream, method, kind}]++
+	f.mu.Unlock()
+	logger.WithFields(logrus.Fields{
+		"drill":    true,
+		"upstream": upstream,
+		"method":   method,
+		"fault":    kind,
+	}).Info("Injected fault")
+}
+
+// Wrap returns a FaultInjectionAPI for the upstream with the given name
+func (f *FaultInjector) Wrap(name string, a API) *FaultInjectionAPI {
+	return &FaultInjectionAPI{API: a, Name: name, Injector: f}
+}
+
+var (
+	injectedFaultsDesc = prometheus.NewDesc(
+		"promproxy_injected_faults_total",
+		"Number of faults injected into upstream calls for resilience drills",
+		[]string{"upstream", "method", "fault"}, nil,
+	)
+	activeFaultsDesc = prometheus.NewDesc(
+		"promproxy_fault_injection_active",
+		"Whether a fault is injected into the calls of the upstream and method for a resilience drill (empty matches all)",
+		[]string{"upstream", "method"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (f *FaultInjector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- injectedFaultsDesc
+	ch <- activeFaultsDesc
+}
+
+// Collect implements prometheus.Collector
+func (f *FaultInjector) Collect(ch chan<- prometheus.Metric) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	for k, count := range f.injected {
+		ch <- prometheus.MustNewConstMetric(injectedFaultsDesc, prometheus.CounterValue, count, k.upstream, k.method, k.kind)
+	}
+	for k := range f.faults {
+		ch <- prometheus.MustNewConstMetric(activeFaultsDesc, prometheus.GaugeValue, 1, k.upstream, k.method)
+	}
+}
+
+// FaultInjectionAPI injects the faults of its FaultInjector into the calls to the
+// wrapped API. It is only part of the stack of the servergroups which enabled it,
+// so there is no overhead for the others. Every injected fault is logged, counted
+// and marked in the warnings, so a drill isn't mistaken for an outage.
+type FaultInjectionAPI struct {
+	API
+	Name     string
+	Injector *FaultInjector
+}
+
+// before injects the latency and error of the fault of the call, returning the
+// fault (if any) and the error the call fails with
+func (f *FaultInjectionAPI) before(ctx context.Context, method string) (*Fault, error) {
+	fault, ok := f.Injector.fault(f.Name, method)
+	if !ok {
+		return nil, nil
+	}
+	if fault.Latency > 0 {
+		f.Injector.record(f.Name, method, "latency")
+		timer := time.NewTimer(fault.Latency)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &fault, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if f.Injector.chance(fault.ErrorRate) {
+		f.Injector.record(f.Name, method, "error")
+		return &fault, &ErrInjectedFault{Upstream: f.Name, Method: method}
+	}
+	return &fault, nil
+}
+
+// warnings returns the warnings of a call with the fault
+func (f *FaultInjectionAPI) warnings(fault *Fault, method string, w api.Warnings) api.Warnings {
+	if fault == nil || fault.Warning == "" {
+		return w
+	}
+	f.Injector.record(f.Name, method, "warning")
+	return append(w, FaultWarningPrefix+fault.Warning)
+}
+
+// keep returns whether a result survives the truncation of the fault
+func (f *FaultInjectionAPI) keep(fault *Fault) bool {
+	return fault == nil || !f.Injector.chance(fault.TruncateRate)
+}
+
+// truncate drops results of the value as configured by the fault
+func (f *FaultInjectionAPI) truncate(fault *Fault, method string, v model.Value) model.Value {
+	if fault == nil || fault.TruncateRate <= 0 {
+		return v
+	}
+	f.Injector.record(f.Name, method, "truncate")
+	switch value := v.(type) {
+	case model.Matrix:
+		ret := make(model.Matrix, 0, len(value))
+		for _, stream := range value {
+			if f.keep(fault) {
+				ret = append(ret, stream)
+			}
+		}
+		return ret
+	case model.Vector:
+		ret := make(model.Vector, 0, len(value))
+		for _, sample := range value {
+			if f.keep(fault) {
+				ret = append(ret, sample)
+			}
+		}
+		return ret
+	}
+	return v
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (f *FaultInjectionAPI) Key() model.LabelSet {
+	return apiKey(f.API)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (f *FaultInjectionAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	return f.labelNames(ctx, f.API.LabelNames)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (f *FaultInjectionAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return f.labelNames(ctx, func(ctx context.Context) ([]string, api.Warnings, error) {
+		return LabelNamesInRange(ctx, f.API, startTime, endTime)
+	})
+}
+
+func (f *FaultInjectionAPI) labelNames(ctx context.Context, call func(context.Context) ([]string, api.Warnings, error)) ([]string, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodLabelNames)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodLabelNames, nil), err
+	}
+	v, w, err := call(ctx)
+	if fault != nil && fault.TruncateRate > 0 {
+		f.Injector.record(f.Name, FaultMethodLabelNames, "truncate")
+		ret := make([]string, 0, len(v))
+		for _, name := range v {
+			if f.keep(fault) {
+				ret = append(ret, name)
+			}
+		}
+		v = ret
+	}
+	return v, f.warnings(fault, FaultMethodLabelNames, w), err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (f *FaultInjectionAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodLabelValues)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodLabelValues, nil), err
+	}
+	v, w, err := f.API.LabelValues(ctx, label)
+	if fault != nil && fault.TruncateRate > 0 {
+		f.Injector.record(f.Name, FaultMethodLabelValues, "truncate")
+		ret := make(model.LabelValues, 0, len(v))
+		for _, value := range v {
+			if f.keep(fault) {
+				ret = append(ret, value)
+			}
+		}
+		v = ret
+	}
+	return v, f.warnings(fault, FaultMethodLabelValues, w), err
+}
+
+// Query performs a query for the given time.
+func (f *FaultInjectionAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodQuery)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodQuery, nil), err
+	}
+	v, w, err := f.API.Query(ctx, query, ts)
+	return f.truncate(fault, FaultMethodQuery, v), f.warnings(fault, FaultMethodQuery, w), err
+}
+
+// QueryRange performs a query for the given range.
+func (f *FaultInjectionAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodQueryRange)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodQueryRange, nil), err
+	}
+	v, w, err := f.API.QueryRange(ctx, query, r)
+	return f.truncate(fault, FaultMethodQueryRange, v), f.warnings(fault, FaultMethodQueryRange, w), err
+}
+
+// Series finds series by label matchers.
+func (f *FaultInjectionAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodSeries)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodSeries, nil), err
+	}
+	v, w, err := f.API.Series(ctx, matches, startTime, endTime)
+	if fault != nil && fault.TruncateRate > 0 {
+		f.Injector.record(f.Name, FaultMethodSeries, "truncate")
+		ret := make([]model.LabelSet, 0, len(v))
+		for _, ls := range v {
+			if f.keep(fault) {
+				ret = append(ret, ls)
+			}
+		}
+		v = ret
+	}
+	return v, f.warnings(fault, FaultMethodSeries, w), err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (f *FaultInjectionAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodSeries)
+	if err != nil {
+		return f.warnings(fault, FaultMethodSeries, nil), err
+	}
+	if fault != nil && fault.TruncateRate > 0 {
+		f.Injector.record(f.Name, FaultMethodSeries, "truncate")
+		next := fn
+		fn = func(ls model.LabelSet) error {
+			if !f.keep(fault) {
+				return nil
+			}
+			return next(ls)
+		}
+	}
+	w, err := StreamSeries(ctx, f.API, matches, startTime, endTime, fn)
+	return f.warnings(fault, FaultMethodSeries, w), err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (f *FaultInjectionAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	fault, err := f.before(ctx, FaultMethodGetValue)
+	if err != nil {
+		return nil, f.warnings(fault, FaultMethodGetValue, nil), err
+	}
+	v, w, err := f.API.GetValue(ctx, start, end, matchers)
+	return f.truncate(fault, FaultMethodGetValue, v), f.warnings(fault, FaultMethodGetValue, w), err
+}
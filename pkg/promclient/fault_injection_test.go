@@ -0,0 +1,132 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+func TestFaultInjectionAPI(t *testing.T) {
+	vector := model.Vector{
+		{Metric: model.Metric{"job": "a"}, Value: 1},
+		{Metric: model.Metric{"job": "b"}, Value: 2},
+	}
+	now := time.Unix(1000, 0)
+
+	tests := []struct {
+		name     string
+		fault    *Fault
+		elapsed  time.Duration
+		err      bool
+		value    model.Value
+		warnings api.Warnings
+	}{
+		{
+			name:     "no fault",
+			value:    vector,
+			warnings: api.Warnings{"upstream"},
+		},
+		{
+			name:     "other upstream",
+			fault:    &Fault{Upstream: "b:9090", ErrorRate: 1},
+			value:    vector,
+			warnings: api.Warnings{"upstream"},
+		},
+		{
+			name:     "other method",
+			fault:    &Fault{Method: FaultMethodSeries, ErrorRate: 1},
+			value:    vector,
+			warnings: api.Warnings{"upstream"},
+		},
+		{
+			name:  "error",
+			fault: &Fault{Upstream: "a:9090", Method: FaultMethodQuery, ErrorRate: 1},
+			err:   true,
+		},
+		{
+			name:     "truncate",
+			fault:    &Fault{TruncateRate: 1},
+			value:    model.Vector{},
+			warnings: api.Warnings{"upstream"},
+		},
+		{
+			name:     "warning",
+			fault:    &Fault{Warning: "drill"},
+			value:    vector,
+			warnings: api.Warnings{"upstream", FaultWarningPrefix + "drill"},
+		},
+		{
+			name:     "expired",
+			fault:    &Fault{ErrorRate: 1},
+			elapsed:  2 * time.Minute,
+			value:    vector,
+			warnings: api.Warnings{"upstream"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			injector := NewFaultInjector()
+			injector.now = func() time.Time { return now }
+			injector.rand = func() float64 { return 0.5 }
+			if test.fault != nil {
+				if _, err := injector.Set(*test.fault, time.Minute); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			injector.now = func() time.Time { return now.Add(test.elapsed) }
+
+			a := injector.Wrap("a:9090", &valueAPI{v: vector})
+			v, w, err := a.Query(context.TODO(), "up", now)
+			if test.err {
+				if _, ok := err.(*ErrInjectedFault); !ok {
+					t.Fatalf("mismatch in error expected=%T actual=%v", &ErrInjectedFault{}, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(v, test.value) {
+				t.Fatalf("mismatch in value expected=%v actual=%v", test.value, v)
+			}
+			if !reflect.DeepEqual(w, test.warnings) {
+				t.Fatalf("mismatch in warnings expected=%v actual=%v", test.warnings, w)
+			}
+		})
+	}
+}
+
+func TestFaultInjectorSet(t *testing.T) {
+	tests := []struct {
+		fault Fault
+		d     time.Duration
+		err   bool
+	}{
+		{fault: Fault{Latency: time.Second}, d: time.Minute},
+		{fault: Fault{Method: "unknown"}, d: time.Minute, err: true},
+		{fault: Fault{ErrorRate: 1.5}, d: time.Minute, err: true},
+		{fault: Fault{TruncateRate: -1}, d: time.Minute, err: true},
+		{fault: Fault{ErrorRate: 1}, d: 0, err: true},
+		{fault: Fault{ErrorRate: 1}, d: MaxFaultDuration + time.Second, err: true},
+	}
+
+	for i, test := range tests {
+		injector := NewFaultInjector()
+		_, err := injector.Set(test.fault, test.d)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		expected := 1
+		if test.err {
+			expected = 0
+		}
+		if faults := injector.Faults(); len(faults) != expected {
+			t.Fatalf("%d: mismatch in faults expected=%d actual=%d", i, expected, len(faults))
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// FutureTimePolicy defines how calls for times in the future are handled. Clients
+// with a skewed clock send end times slightly in the future, which some
+// downstreams answer with errors or (worse) empty results.
+type FutureTimePolicy string
+
+// The future time policies
+const (
+	// FutureTimeClamp clamps the time to now (plus the allowed skew) with a
+	// warning (the default)
+	FutureTimeClamp FutureTimePolicy = "clamp"
+	// FutureTimeReject fails the call with an ErrFutureTime
+	FutureTimeReject FutureTimePolicy = "reject"
+	// FutureTimePass passes the time on as is
+	FutureTimePass FutureTimePolicy = "pass"
+)
+
+// Validate returns an error if the policy isn't known
+func (p FutureTimePolicy) Validate() error {
+	switch p {
+	case "", FutureTimeClamp, FutureTimeReject, FutureTimePass:
+		return nil
+	default:
+		return fmt.Errorf("unknown future time policy %q", p)
+	}
+}
+
+// ErrFutureTime is returned for calls rejected as their time is after the Limit
+type ErrFutureTime struct {
+	Time  time.Time
+	Limit time.Time
+}
+
+func (e *ErrFutureTime) Error() string {
+	return fmt.Sprintf("time %s is in the future, the latest allowed time is %s", formatTime(e.Time), formatTime(e.Limit))
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// FutureTimeConfig configures the handling of calls for times in the future
+type FutureTimeConfig struct {
+	// Policy is the FutureTimePolicy (FutureTimeClamp if unset)
+	Policy FutureTimePolicy `yaml:"policy"`
+	// AllowedSkew is how far in the future a time may be before the policy applies
+	AllowedSkew time.Duration `yaml:"allowed_skew"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *FutureTimeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = FutureTimeConfig{}
+	type plain FutureTimeConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c FutureTimeConfig) Validate() error {
+	if err := c.Policy.Validate(); err != nil {
+		return err
+	}
+	if c.AllowedSkew < 0 {
+		return fmt.Errorf("future time allowed_skew must not be negative")
+	}
+	return nil
+}
+
+// Limit returns the latest time which isn't in the future at now, false if the
+// policy passes all times on
+func (c FutureTimeConfig) Limit(now time.Time) (time.Time, bool) {
+	if c.Policy == FutureTimePass {
+		return time.Time{}, false
+	}
+	return now.Add(c.AllowedSkew), true
+}
+
+// NewFutureTimeAPI returns a FutureTimeAPI
+func NewFutureTimeAPI(a API, cfg FutureTimeConfig) *FutureTimeAPI {
+	return &FutureTimeAPI{API: a, cfg: cfg, now: time.Now}
+}
+
+// FutureTimeAPI applies the FutureTimePolicy to the calls to the wrapped API. A
+// clamped range query keeps its start and step, so its end is the last step
+// before the limit and the result stays aligned to the steps of the original
+// query. As the calls to the wrapped API are for the clamped times, anything it
+// caches is keyed by those rather than the times of the original call.
+type FutureTimeAPI struct {
+	API
+	cfg FutureTimeConfig
+	now func() time.Time
+}
+
+// limit returns the latest time which isn't in the future
+func (f *FutureTimeAPI) limit() (time.Time, bool) {
+	return f.cfg.Limit(f.now())
+}
+
+// clamp returns t limited to the limit, with the warning if it was clamped
+func (f *FutureTimeAPI) clamp(t time.Time, limit time.Time) (time.Time, api.Warnings, error) {
+	if !t.After(limit) {
+		return t, nil, nil
+	}
+	if f.cfg.Policy == FutureTimeReject {
+		return t, nil, &ErrFutureTime{Time: t, Limit: limit}
+	}
+	return limit, api.Warnings{fmt.Sprintf("time %s is in the future, clamped to %s", formatTime(t), formatTime(limit))}, nil
+}
+
+// Query performs a query for the given time.
+func (f *FutureTimeAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return f.API.Query(ctx, query, ts)
+	}
+	ts, warnings, err := f.clamp(ts, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := f.API.Query(ctx, query, ts)
+	return v, append(warnings, w...), err
+}
+
+// QueryRange performs a query for the given range.
+func (f *FutureTimeAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return f.API.QueryRange(ctx, query, r)
+	}
+	end, warnings, err := f.clamp(r.End, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if warnings != nil {
+		// The whole range is in the future, so there is nothing to query
+		if r.Start.After(limit) {
+			return model.Matrix{}, warnings, nil
+		}
+		// The last step of the original query which isn't in the future
+		if r.Step > 0 {
+			end = r.Start.Add(end.Sub(r.Start) / r.Step * r.Step)
+		}
+		r.End = end
+	}
+	v, w, err := f.API.QueryRange(ctx, query, r)
+	return v, append(warnings, w...), err
+}
+
+// Series finds series by label matchers.
+func (f *FutureTimeAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return f.API.Series(ctx, matches, startTime, endTime)
+	}
+	endTime, warnings, err := f.clamp(endTime, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if startTime.After(endTime) {
+		return nil, warnings, nil
+	}
+	v, w, err := f.API.Series(ctx, matches, startTime, endTime)
+	return v, append(warnings, w...), err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (f *FutureTimeAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return StreamSeries(ctx, f.API, matches, startTime, endTime, fn)
+	}
+	endTime, warnings, err := f.clamp(endTime, limit)
+	if err != nil {
+		return nil, err
+	}
+	if startTime.After(endTime) {
+		return warnings, nil
+	}
+	w, err := StreamSeries(ctx, f.API, matches, startTime, endTime, fn)
+	return append(warnings, w...), err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (f *FutureTimeAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return LabelNamesInRange(ctx, f.API, startTime, endTime)
+	}
+	endTime, warnings, err := f.clamp(endTime, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if startTime.After(endTime) {
+		return nil, warnings, nil
+	}
+	v, w, err := LabelNamesInRange(ctx, f.API, startTime, endTime)
+	return v, append(warnings, w...), err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (f *FutureTimeAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return f.API.GetValue(ctx, start, end, matchers)
+	}
+	end, warnings, err := f.clamp(end, limit)
+	if err != nil {
+		return nil, nil, err
+	}
+	if start.After(end) {
+		return nil, warnings, nil
+	}
+	v, w, err := f.API.GetValue(ctx, start, end, matchers)
+	return v, append(warnings, w...), err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (f *FutureTimeAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	limit, ok := f.limit()
+	if !ok {
+		return StreamGetValue(ctx, f.API, start, end, matchers)
+	}
+	end, warnings, err := f.clamp(end, limit)
+	if err != nil {
+		return nil, err
+	}
+	if start.After(end) {
+		return streamValue(ctx, nil, warnings)
+	}
+	ch, err := StreamGetValue(ctx, f.API, start, end, matchers)
+	if err != nil || len(warnings) == 0 {
+		return ch, err
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		return append(warnings, w...), err
+	}), nil
+}
@@ -0,0 +1,145 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// timeCaptureAPI records the times of the calls sent to it
+type timeCaptureAPI struct {
+	API
+	ts    time.Time
+	r     *v1.Range
+	calls int
+}
+
+func (c *timeCaptureAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	c.calls++
+	c.ts = ts
+	return model.Vector{}, nil, nil
+}
+
+func (c *timeCaptureAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	c.calls++
+	c.r = &r
+	return model.Matrix{}, nil, nil
+}
+
+func TestFutureTimeAPI(t *testing.T) {
+	now := time.Unix(1000, 0)
+	at := func(s int64) time.Time { return time.Unix(s, 0) }
+
+	tests := []struct {
+		name   string
+		cfg    FutureTimeConfig
+		r      v1.Range
+		err    bool
+		warned bool
+		// expected is the range sent downstream (nil if none is)
+		expected *v1.Range
+	}{
+		{
+			name:     "past",
+			r:        v1.Range{Start: at(900), End: at(990), Step: 30 * time.Second},
+			expected: &v1.Range{Start: at(900), End: at(990), Step: 30 * time.Second},
+		},
+		// The end is clamped to the last step before now, so the steps stay aligned
+		{
+			name:     "clamp",
+			r:        v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+			warned:   true,
+			expected: &v1.Range{Start: at(900), End: at(990), Step: 30 * time.Second},
+		},
+		{
+			name:     "within allowed skew",
+			cfg:      FutureTimeConfig{AllowedSkew: 30 * time.Second},
+			r:        v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+			expected: &v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+		},
+		{
+			name:     "clamp to allowed skew",
+			cfg:      FutureTimeConfig{AllowedSkew: 10 * time.Second},
+			r:        v1.Range{Start: at(900), End: at(1020), Step: 7 * time.Second},
+			warned:   true,
+			expected: &v1.Range{Start: at(900), End: at(1005), Step: 7 * time.Second},
+		},
+		{
+			name:   "entirely in the future",
+			r:      v1.Range{Start: at(1100), End: at(1200), Step: 30 * time.Second},
+			warned: true,
+		},
+		{
+			name: "reject",
+			cfg:  FutureTimeConfig{Policy: FutureTimeReject},
+			r:    v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+			err:  true,
+		},
+		{
+			name:     "pass",
+			cfg:      FutureTimeConfig{Policy: FutureTimePass},
+			r:        v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+			expected: &v1.Range{Start: at(900), End: at(1020), Step: 30 * time.Second},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			capture := &timeCaptureAPI{}
+			f := NewFutureTimeAPI(capture, test.cfg)
+			f.now = func() time.Time { return now }
+
+			v, w, err := f.QueryRange(context.TODO(), "up", test.r)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if test.err {
+				if _, ok := err.(*ErrFutureTime); !ok {
+					t.Fatalf("mismatch in error type expected=%T actual=%T", &ErrFutureTime{}, err)
+				}
+				if capture.calls != 0 {
+					t.Fatalf("rejected query was sent downstream")
+				}
+				return
+			}
+			if v == nil {
+				t.Fatalf("missing result")
+			}
+			if warned := len(w) > 0; warned != test.warned {
+				t.Fatalf("mismatch in warned expected=%v actual=%v (%v)", test.warned, warned, w)
+			}
+
+			if test.expected == nil {
+				if capture.calls != 0 {
+					t.Fatalf("unexpected downstream call for %v", capture.r)
+				}
+				return
+			}
+			if capture.r == nil || !capture.r.Start.Equal(test.expected.Start) || !capture.r.End.Equal(test.expected.End) || capture.r.Step != test.expected.Step {
+				t.Fatalf("mismatch in range expected=%v actual=%v", test.expected, capture.r)
+			}
+		})
+	}
+}
+
+func TestFutureTimeAPIQuery(t *testing.T) {
+	now := time.Unix(1000, 0)
+	capture := &timeCaptureAPI{}
+	f := NewFutureTimeAPI(capture, FutureTimeConfig{AllowedSkew: 5 * time.Second})
+	f.now = func() time.Time { return now }
+
+	_, w, err := f.Query(context.TODO(), "up", time.Unix(1060, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := time.Unix(1005, 0); !capture.ts.Equal(expected) {
+		t.Fatalf("mismatch in time expected=%v actual=%v", expected, capture.ts)
+	}
+	if len(w) != 1 {
+		t.Fatalf("mismatch in warnings expected=1 actual=%v", w)
+	}
+}
@@ -0,0 +1,258 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrUpstreamDisabled is returned for requests to an upstream which has been
+// taken offline through the HealthMonitor
+type ErrUpstreamDisabled string
+
+func (e ErrUpstreamDisabled) Error() string {
+	return fmt.Sprintf("upstream %q is disabled", string(e))
+}
+
+// ErrUnknownUpstream is returned when changing the health of an upstream which
+// the HealthMonitor doesn't know about
+type ErrUnknownUpstream string
+
+func (e ErrUnknownUpstream) Error() string {
+	return fmt.Sprintf("unknown upstream %q", string(e))
+}
+
+// DefaultHealthMonitor is the HealthMonitor used by the servergroups
+var DefaultHealthMonitor = NewHealthMonitor()
+
+// NewHealthMonitor returns an empty HealthMonitor
+func NewHealthMonitor() *HealthMonitor {
+	return &HealthMonitor{
+		known:    make(map[string]struct{}),
+		disabled: make(map[string]struct{}),
+		owners:   make(map[interface{}]map[string]struct{}),
+	}
+}
+
+// HealthMonitor tracks the upstreams which have been forced into the unhealthy
+// state. This allows operators to take an upstream offline (e.g. during an
+// incident) without a config change, the requests are then served by the
+// remaining upstreams of the servergroup
+type HealthMonitor struct {
+	mu       sync.RWMutex
+	known    map[string]struct{}
+	disabled map[string]struct{}
+	// owners are the upstreams of each owner, see SetUpstreams
+	owners map[interface{}]map[string]struct{}
+}
+
+// Wrap returns a HealthCheckedAPI for the upstream with the given name
+func (h *HealthMonitor) Wrap(name string, a API) *HealthCheckedAPI {
+	h.mu.Lock()
+	h.known[name] = struct{}{}
+	h.mu.Unlock()
+	return &HealthCheckedAPI{API: a, Name: name, Monitor: h}
+}
+
+// SetUpstreams sets the upstreams of the owner (e.g. a servergroup, on each
+// change of its targets). The upstreams which the owner had before and no owner
+// has anymore are forgotten, along with their state, so the monitor doesn't grow
+// with every upstream which was ever discovered. Setting no upstreams removes the
+// owner.
+func (h *HealthMonitor) SetUpstreams(owner interface{}, names []string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous := h.owners[owner]
+	upstreams := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		upstreams[name] = struct{}{}
+		h.known[name] = struct{}{}
+	}
+	if len(upstreams) > 0 {
+		h.owners[owner] = upstreams
+	} else {
+		delete(h.owners, owner)
+	}
+
+PREVIOUS:
+	for name := range previous {
+		for _, other := range h.owners {
+			if _, ok := other[name]; ok {
+				continue PREVIOUS
+			}
+		}
+		delete(h.known, name)
+		delete(h.disabled, name)
+	}
+}
+
+// setDisabled changes the state of the named upstream
+func (h *HealthMonitor) setDisabled(name string, disabled bool) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.known[name]; !ok {
+		return ErrUnknownUpstream(name)
+	}
+	if disabled {
+		h.disabled[name] = struct{}{}
+	} else {
+		delete(h.disabled, name)
+	}
+	return nil
+}
+
+// Disable forces the named upstream into the unhealthy state
+func (h *HealthMonitor) Disable(name string) error {
+	return h.setDisabled(name, true)
+}
+
+// Enable returns the named upstream to the healthy state
+func (h *HealthMonitor) Enable(name string) error {
+	return h.setDisabled(name, false)
+}
+
+// Healthy returns whether the named upstream should receive requests
+func (h *HealthMonitor) Healthy(name string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	_, disabled := h.disabled[name]
+	return !disabled
+}
+
+// Disabled returns the sorted names of the disabled upstreams
+func (h *HealthMonitor) Disabled() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	names := make([]string, 0, len(h.disabled))
+	for name := range h.disabled {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HealthCheckedAPI returns an ErrUpstreamDisabled (without calling the wrapped API)
+// while the upstream is disabled in the HealthMonitor. Like any other backend error
+// the MultiAPI then serves the request from the remaining upstreams
+type HealthCheckedAPI struct {
+	API
+	Name    string
+	Monitor *HealthMonitor
+}
+
+func (h *HealthCheckedAPI) check() error {
+	if !h.Monitor.Healthy(h.Name) {
+		return ErrUpstreamDisabled(h.Name)
+	}
+	return nil
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (h *HealthCheckedAPI) Key() model.LabelSet {
+	return apiKey(h.API)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (h *HealthCheckedAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.LabelNames(ctx)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (h *HealthCheckedAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return LabelNamesInRange(ctx, h.API, startTime, endTime)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (h *HealthCheckedAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.LabelValues(ctx, label)
+}
+
+// Query performs a query for the given time.
+func (h *HealthCheckedAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (h *HealthCheckedAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (h *HealthCheckedAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.Series(ctx, matches, startTime, endTime)
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (h *HealthCheckedAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return StreamSeries(ctx, h.API, matches, startTime, endTime, fn)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (h *HealthCheckedAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if err := h.check(); err != nil {
+		return nil, nil, err
+	}
+	return h.API.GetValue(ctx, start, end, matchers)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (h *HealthCheckedAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if err := h.check(); err != nil {
+		return nil, err
+	}
+	return StreamGetValue(ctx, h.API, start, end, matchers)
+}
+
+var upstreamDisabledDesc = prometheus.NewDesc(
+	"promproxy_upstream_disabled",
+	"Whether the upstream was taken offline through the HealthMonitor",
+	[]string{"upstream"}, nil,
+)
+
+// Describe implements prometheus.Collector
+func (h *HealthMonitor) Describe(ch chan<- *prometheus.Desc) {
+	ch <- upstreamDisabledDesc
+}
+
+// Collect implements prometheus.Collector
+func (h *HealthMonitor) Collect(ch chan<- prometheus.Metric) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for name := range h.known {
+		disabled := 0.0
+		if _, ok := h.disabled[name]; ok {
+			disabled = 1
+		}
+		ch <- prometheus.MustNewConstMetric(upstreamDisabledDesc, prometheus.GaugeValue, disabled, name)
+	}
+}
@@ -0,0 +1,35 @@
+package promclient
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHealthMonitorSetUpstreams(t *testing.T) {
+	h := NewHealthMonitor()
+	a, b := &struct{ name string }{"a"}, &struct{ name string }{"b"}
+	h.SetUpstreams(a, []string{"x:9090", "y:9090"})
+	h.SetUpstreams(b, []string{"y:9090"})
+	for _, name := range []string{"x:9090", "y:9090"} {
+		if err := h.Disable(name); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// y:9090 is still an upstream of b
+	h.SetUpstreams(a, []string{"z:9090"})
+	if disabled := h.Disabled(); !reflect.DeepEqual(disabled, []string{"y:9090"}) {
+		t.Fatalf("mismatch in disabled expected=%v actual=%v", []string{"y:9090"}, disabled)
+	}
+	if _, ok := h.Disable("x:9090").(ErrUnknownUpstream); !ok {
+		t.Fatalf("expected x:9090 to be forgotten")
+	}
+
+	h.SetUpstreams(b, nil)
+	if disabled := h.Disabled(); len(disabled) != 0 {
+		t.Fatalf("mismatch in disabled expected=[] actual=%v", disabled)
+	}
+	if err := h.Disable("z:9090"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -45,6 +45,18 @@ func (n *IgnoreErrorAPI) Series(ctx context.Context, matches []string, startTime
 	return v, w, nil
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (n *IgnoreErrorAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	w, _ := StreamSeries(ctx, n.API, matches, startTime, endTime, fn)
+
+	return w, nil
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (n *IgnoreErrorAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, n.API, startTime, endTime)
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (n *IgnoreErrorAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	v, w, _ := n.API.GetValue(ctx, start, end, matchers)
@@ -52,10 +64,19 @@ func (n *IgnoreErrorAPI) GetValue(ctx context.Context, start, end time.Time, mat
 	return v, w, nil
 }
 
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (n *IgnoreErrorAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	ch, err := StreamGetValue(ctx, n.API, start, end, matchers)
+	if err != nil {
+		return emptyValueStream(), nil
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		return w, nil
+	}), nil
+}
+
 // Key returns a labelset used to determine other api clients that are the "same"
 func (n *IgnoreErrorAPI) Key() model.LabelSet {
-	if apiLabels, ok := n.API.(APILabels); ok {
-		return apiLabels.Key()
-	}
-	return nil
+	return apiKey(n.API)
 }
@@ -0,0 +1,171 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/sirupsen/logrus"
+)
+
+// CallArgs are the arguments of an intercepted call, only those of the method are set
+type CallArgs struct {
+	Label    string
+	Query    string
+	Time     time.Time
+	Range    v1.Range
+	Matches  []string
+	Start    time.Time
+	End      time.Time
+	Matchers []*labels.Matcher
+}
+
+// CallFunc makes an intercepted call (or calls the next CallInterceptor) with the
+// given context
+type CallFunc func(ctx context.Context) (interface{}, api.Warnings, error)
+
+// CallInterceptor intercepts the calls to an API. Unlike a wrapper of the API it
+// is implemented once for all the methods, rather than for each of them.
+type CallInterceptor interface {
+	// Intercept is called for every call with the name of the method and its
+	// arguments. It makes the call with next (possibly with a derived context) and
+	// returns its results, or returns without making it.
+	Intercept(ctx context.Context, method string, args *CallArgs, next CallFunc) (interface{}, api.Warnings, error)
+}
+
+// InterceptorAPI passes the calls to the wrapped API through the Interceptors, the
+// first of which is the outermost
+type InterceptorAPI struct {
+	API
+	Interceptors []CallInterceptor
+}
+
+// call chains the interceptors around fn
+func (i *InterceptorAPI) call(ctx context.Context, method string, args *CallArgs, fn CallFunc) (interface{}, api.Warnings, error) {
+	next := fn
+	for j := len(i.Interceptors) - 1; j >= 0; j-- {
+		interceptor, inner := i.Interceptors[j], next
+		next = func(ctx context.Context) (interface{}, api.Warnings, error) {
+			return interceptor.Intercept(ctx, method, args, inner)
+		}
+	}
+	return next(ctx)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (i *InterceptorAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	v, w, err := i.call(ctx, "LabelNames", &CallArgs{}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.LabelNames(ctx)
+	})
+	ret, _ := v.([]string)
+	return ret, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (i *InterceptorAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	v, w, err := i.call(ctx, "LabelNamesInRange", &CallArgs{Start: startTime, End: endTime}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return LabelNamesInRange(ctx, i.API, startTime, endTime)
+	})
+	ret, _ := v.([]string)
+	return ret, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (i *InterceptorAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := i.call(ctx, "LabelValues", &CallArgs{Label: label}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.LabelValues(ctx, label)
+	})
+	ret, _ := v.(model.LabelValues)
+	return ret, w, err
+}
+
+// Query performs a query for the given time.
+func (i *InterceptorAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	v, w, err := i.call(ctx, "Query", &CallArgs{Query: query, Time: ts}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.Query(ctx, query, ts)
+	})
+	ret, _ := v.(model.Value)
+	return ret, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (i *InterceptorAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	v, w, err := i.call(ctx, "QueryRange", &CallArgs{Query: query, Range: r}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.QueryRange(ctx, query, r)
+	})
+	ret, _ := v.(model.Value)
+	return ret, w, err
+}
+
+// Series finds series by label matchers.
+func (i *InterceptorAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	v, w, err := i.call(ctx, "Series", &CallArgs{Matches: matches, Start: startTime, End: endTime}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.Series(ctx, matches, startTime, endTime)
+	})
+	ret, _ := v.([]model.LabelSet)
+	return ret, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (i *InterceptorAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	_, w, err := i.call(ctx, "StreamSeries", &CallArgs{Matches: matches, Start: startTime, End: endTime}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		w, err := StreamSeries(ctx, i.API, matches, startTime, endTime, fn)
+		return nil, w, err
+	})
+	return w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (i *InterceptorAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	v, w, err := i.call(ctx, "GetValue", &CallArgs{Start: start, End: end, Matchers: matchers}, func(ctx context.Context) (interface{}, api.Warnings, error) {
+		return i.API.GetValue(ctx, start, end, matchers)
+	})
+	ret, _ := v.(model.Value)
+	return ret, w, err
+}
+
+// LoggingInterceptor logs every call (at debug level) with the given prefix
+type LoggingInterceptor string
+
+// Intercept implements CallInterceptor
+func (l LoggingInterceptor) Intercept(ctx context.Context, method string, args *CallArgs, next CallFunc) (interface{}, api.Warnings, error) {
+	start := time.Now()
+	v, w, err := next(ctx)
+	fields := logrus.Fields{
+		"api":  method,
+		"took": time.Since(start),
+	}
+	if args.Query != "" {
+		fields["query"] = args.Query
+	}
+	if err != nil {
+		fields["error"] = err
+	}
+	logger.WithFields(fields).Debug(string(l))
+	return v, w, err
+}
+
+// MetricsInterceptor observes the latency of every call by method and status
+// (see CallStatus), like the MultiAPIMetricFunc of the MultiAPI
+type MetricsInterceptor func(method, status string, took float64)
+
+// Intercept implements CallInterceptor
+func (m MetricsInterceptor) Intercept(ctx context.Context, method string, args *CallArgs, next CallFunc) (interface{}, api.Warnings, error) {
+	start := time.Now()
+	v, w, err := next(ctx)
+	m(method, CallStatus(err), time.Since(start).Seconds())
+	return v, w, err
+}
+
+// TimeoutInterceptor limits every call to the given duration
+type TimeoutInterceptor time.Duration
+
+// Intercept implements CallInterceptor
+func (t TimeoutInterceptor) Intercept(ctx context.Context, method string, args *CallArgs, next CallFunc) (interface{}, api.Warnings, error) {
+	childCtx, cancel := context.WithTimeout(ctx, time.Duration(t))
+	defer cancel()
+	return next(childCtx)
+}
@@ -0,0 +1,73 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// recordInterceptor records the calls passing through it
+type recordInterceptor struct {
+	name     string
+	calls    *[]string
+	deadline bool
+}
+
+func (r *recordInterceptor) Intercept(ctx context.Context, method string, args *CallArgs, next CallFunc) (interface{}, api.Warnings, error) {
+	*r.calls = append(*r.calls, r.name+" "+method+" "+args.Query)
+	_, r.deadline = ctx.Deadline()
+	return next(ctx)
+}
+
+func TestInterceptorAPI(t *testing.T) {
+	var calls []string
+	outer := &recordInterceptor{name: "outer", calls: &calls}
+	inner := &recordInterceptor{name: "inner", calls: &calls}
+	var observed []string
+
+	a := &InterceptorAPI{
+		API: &slowAPI{},
+		Interceptors: []CallInterceptor{
+			outer,
+			LoggingInterceptor("test"),
+			MetricsInterceptor(func(method, status string, took float64) {
+				observed = append(observed, method+" "+status)
+			}),
+			TimeoutInterceptor(time.Second),
+			inner,
+		},
+	}
+
+	v, _, err := a.Query(context.TODO(), "up", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v == nil {
+		t.Fatalf("missing value")
+	}
+
+	expectedCalls := []string{"outer Query up", "inner Query up"}
+	if !reflect.DeepEqual(calls, expectedCalls) {
+		t.Fatalf("mismatch in calls expected=%v actual=%v", expectedCalls, calls)
+	}
+	if expected := []string{"Query success"}; !reflect.DeepEqual(observed, expected) {
+		t.Fatalf("mismatch in observed expected=%v actual=%v", expected, observed)
+	}
+	// The timeout only applies to the interceptors within it
+	if outer.deadline || !inner.deadline {
+		t.Fatalf("mismatch in deadlines expected=%v,%v actual=%v,%v", false, true, outer.deadline, inner.deadline)
+	}
+
+	// A call exceeding the timeout is cut off
+	a.API = &slowAPI{latency: time.Second}
+	a.Interceptors[3] = TimeoutInterceptor(10 * time.Millisecond)
+	if _, _, err := a.Query(context.TODO(), "up", time.Now()); err != context.DeadlineExceeded {
+		t.Fatalf("mismatch in error expected=%v actual=%v", context.DeadlineExceeded, err)
+	}
+	if expected := []string{"Query success", "Query error"}; !reflect.DeepEqual(observed, expected) {
+		t.Fatalf("mismatch in observed expected=%v actual=%v", expected, observed)
+	}
+}
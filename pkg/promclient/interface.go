@@ -32,3 +32,27 @@ type APILabels interface {
 	// Key returns a labelset used to determine other api clients that are the "same"
 	Key() model.LabelSet
 }
+
+// apiKey returns the Key of the client (nil if it isn't an APILabels), for the
+// wrappers to pass on the Key of the API they wrap
+func apiKey(client API) model.LabelSet {
+	if apiLabels, ok := client.(APILabels); ok {
+		return apiLabels.Key()
+	}
+	return nil
+}
+
+// LabelNamesInRanger is implemented by APIs that can restrict LabelNames to a time range
+type LabelNamesInRanger interface {
+	// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+	LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error)
+}
+
+// LabelNamesInRange returns the label names of the client within the time range,
+// if the client isn't a LabelNamesInRanger all of its label names are returned
+func LabelNamesInRange(ctx context.Context, client API, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if ranger, ok := client.(LabelNamesInRanger); ok {
+		return ranger.LabelNamesInRange(ctx, startTime, endTime)
+	}
+	return client.LabelNames(ctx)
+}
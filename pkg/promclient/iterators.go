@@ -3,16 +3,21 @@ package promclient
 import (
 	"fmt"
 	"reflect"
-	"sort"
 
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/promutil"
 )
 
-// IteratorsForValue returns SeriesIterators for the value passed in
+// IteratorsForValue returns SeriesIterators for the value passed in. A nil value
+// (including a typed nil) has no iterators
 func IteratorsForValue(v model.Value) []*SeriesIterator {
 	switch valueTyped := v.(type) {
 	case *model.Scalar:
+		if valueTyped == nil {
+			return nil
+		}
 		return []*SeriesIterator{NewSeriesIterator(v)}
 	case *model.String:
 		panic("Not implemented")
@@ -118,25 +123,9 @@ func (s *SeriesIterator) Labels() labels.Labels {
 	case *model.Scalar:
 		panic("Unknown metric() scalar?")
 	case *model.Sample: // From a vector
-		ret := make(labels.Labels, 0, len(valueTyped.Metric))
-		for k, v := range valueTyped.Metric {
-			ret = append(ret, labels.Label{string(k), string(v)})
-		}
-		// TODO: move this into prom
-		// there is no reason me (the series iterator) should have to sort these
-		// if prom needs them sorted sometimes it should be responsible for doing so
-		sort.Sort(ret)
-		return ret
+		return promutil.ModelMetricToLabels(valueTyped.Metric)
 	case *model.SampleStream:
-		ret := make(labels.Labels, 0, len(valueTyped.Metric))
-		for k, v := range valueTyped.Metric {
-			ret = append(ret, labels.Label{string(k), string(v)})
-		}
-		// TODO: move this into prom
-		// there is no reason me (the series iterator) should have to sort these
-		// if prom needs them sorted sometimes it should be responsible for doing so
-		sort.Sort(ret)
-		return ret
+		return promutil.ModelMetricToLabels(valueTyped.Metric)
 	default:
 		panic("Unknown data type!")
 	}
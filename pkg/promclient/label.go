@@ -2,6 +2,7 @@ package promclient
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/prometheus/client_golang/api"
@@ -13,6 +14,28 @@ import (
 	"github.com/promproxy/pkg/promutil"
 )
 
+// ErrInvalidLabelName is returned for the LabelValues calls of an empty or invalid
+// label name, rather than sending them downstream
+type ErrInvalidLabelName struct {
+	Label string
+}
+
+func (e *ErrInvalidLabelName) Error() string {
+	if e.Label == "" {
+		return "label name must not be empty"
+	}
+	return fmt.Sprintf("invalid label name %q", e.Label)
+}
+
+// ValidateLabelName returns an ErrInvalidLabelName if the label name is empty or
+// invalid. __name__ is valid, its values are the names of the metrics.
+func ValidateLabelName(label string) error {
+	if !model.LabelName(label).IsValid() {
+		return &ErrInvalidLabelName{Label: label}
+	}
+	return nil
+}
+
 // MergeLabelValues merges the labels from b into a
 func MergeLabelValues(a, b []model.LabelValue) []model.LabelValue {
 	labels := make(map[model.LabelValue]struct{})
@@ -33,11 +56,11 @@ func MergeLabelValues(a, b []model.LabelValue) []model.LabelValue {
 func MergeLabelSets(a, b []model.LabelSet) []model.LabelSet {
 	added := make(map[model.Fingerprint]struct{})
 	for _, item := range a {
-		added[item.Fingerprint()] = struct{}{}
+		added[promutil.NormalizedFingerprint(model.Metric(item))] = struct{}{}
 	}
 
 	for _, item := range b {
-		fp := item.Fingerprint()
+		fp := promutil.NormalizedFingerprint(model.Metric(item))
 		if _, ok := added[fp]; !ok {
 			added[fp] = struct{}{}
 			a = append(a, item)
@@ -51,6 +74,9 @@ func MergeLabelSets(a, b []model.LabelSet) []model.LabelSet {
 type AddLabelClient struct {
 	API
 	Labels model.LabelSet
+	// EmptyMatchers is the handling of Series selectors which only had matchers
+	// on the Labels
+	EmptyMatchers EmptyMatchersConfig
 }
 
 // Key defines the labelset which identifies this client
@@ -64,7 +90,20 @@ func (c *AddLabelClient) LabelNames(ctx context.Context) ([]string, api.Warnings
 	if err != nil {
 		return nil, nil, err
 	}
+	return c.addLabelNames(l), w, nil
+}
 
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (c *AddLabelClient) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	l, w, err := LabelNamesInRange(ctx, c.API, startTime, endTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.addLabelNames(l), w, nil
+}
+
+// addLabelNames adds the names of the labels of the client to l
+func (c *AddLabelClient) addLabelNames(l []string) []string {
 	for k := range c.Labels {
 		found := false
 		for _, labelName := range l {
@@ -77,7 +116,7 @@ func (c *AddLabelClient) LabelNames(ctx context.Context) ([]string, api.Warnings
 		}
 	}
 
-	return l, w, err
+	return l
 }
 
 // LabelValues performs a query for the values of the given label.
@@ -108,6 +147,7 @@ func (c *AddLabelClient) Query(ctx context.Context, query string, ts time.Time)
 		return nil, nil, err
 	}
 	if !filterVisitor.filterMatch {
+		RoutedAway(ctx)
 		return nil, nil, nil
 	}
 
@@ -135,6 +175,7 @@ func (c *AddLabelClient) QueryRange(ctx context.Context, query string, r v1.Rang
 		return nil, nil, err
 	}
 	if !filterVisitor.filterMatch {
+		RoutedAway(ctx)
 		return nil, nil, nil
 	}
 
@@ -150,31 +191,14 @@ func (c *AddLabelClient) QueryRange(ctx context.Context, query string, r v1.Rang
 
 // Series finds series by label matchers.
 func (c *AddLabelClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
-	// Now we need to filter the matches sent to us for the labels associated with this
-	// servergroup
-	filteredMatches := make([]string, 0, len(matches))
-	for _, matcher := range matches {
-		// Parse out the promql query into expressions etc.
-		e, err := promql.ParseExpr(matcher)
-		if err != nil {
-			return nil, nil, err
-		}
-
-		// Walk the expression, to filter out any LabelMatchers that match etc.
-		filterVisitor := &LabelFilterVisitor{c.Labels, true}
-		if _, err := promql.Walk(ctx, filterVisitor, &promql.EvalStmt{Expr: e}, e, nil, nil); err != nil {
-			return nil, nil, err
-		}
-		// If we didn't match, lets skip
-		if !filterVisitor.filterMatch {
-			continue
-		}
-		// if we did match, lets assign the filtered version of the matcher
-		filteredMatches = append(filteredMatches, e.String())
+	filteredMatches, catchAll, err := c.EmptyMatchers.filterMatches(c.Labels, matches)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	// If no matchers remain, then we don't have anything -- so skip
 	if len(filteredMatches) == 0 {
+		RoutedAway(ctx)
 		return nil, nil, nil
 	}
 
@@ -182,6 +206,9 @@ func (c *AddLabelClient) Series(ctx context.Context, matches []string, startTime
 	if err != nil {
 		return nil, w, err
 	}
+	if catchAll {
+		v, w = c.EmptyMatchers.limitSeries(v, w)
+	}
 
 	// add our state's labels to the labelsets we return
 	for _, lset := range v {
@@ -193,10 +220,33 @@ func (c *AddLabelClient) Series(ctx context.Context, matches []string, startTime
 	return v, w, nil
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (c *AddLabelClient) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	filteredMatches, catchAll, err := c.EmptyMatchers.filterMatches(c.Labels, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	// If no matchers remain, then we don't have anything -- so skip
+	if len(filteredMatches) == 0 {
+		RoutedAway(ctx)
+		return nil, nil
+	}
+
+	return c.EmptyMatchers.streamSeries(ctx, c.API, filteredMatches, startTime, endTime, catchAll, func(lset model.LabelSet) error {
+		// add our state's labels to the labelsets we return
+		for k, v := range c.Labels {
+			lset[k] = v
+		}
+		return fn(lset)
+	})
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (c *AddLabelClient) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	filteredMatchers, ok := FilterMatchers(c.Labels, matchers)
 	if !ok {
+		RoutedAway(ctx)
 		return nil, nil, nil
 	}
 
@@ -210,3 +260,21 @@ func (c *AddLabelClient) GetValue(ctx context.Context, start, end time.Time, mat
 
 	return val, w, nil
 }
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (c *AddLabelClient) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	filteredMatchers, ok := FilterMatchers(c.Labels, matchers)
+	if !ok {
+		RoutedAway(ctx)
+		return emptyValueStream(), nil
+	}
+
+	ch, err := StreamGetValue(ctx, c.API, start, end, filteredMatchers)
+	if err != nil {
+		return nil, err
+	}
+	return filterValueStream(ctx, ch, func(s *model.SampleStream) (*model.SampleStream, error) {
+		return s, promutil.ValueAddLabelSet(model.Matrix{s}, c.Labels)
+	}, nil), nil
+}
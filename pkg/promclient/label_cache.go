@@ -0,0 +1,181 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/scheduler"
+)
+
+// LabelCacheConfig configures a LabelCacheAPI
+type LabelCacheConfig struct {
+	// RefreshInterval is how often the cached label names and values are refreshed
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+	// Labels are the labels whose values are cached, e.g. the ones used for
+	// autocompletion in dashboards. The label names are always cached.
+	Labels []string `yaml:"labels"`
+}
+
+// DefaultLabelCacheConfig is the LabelCacheConfig used for unset fields
+var DefaultLabelCacheConfig = LabelCacheConfig{
+	RefreshInterval: time.Minute,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *LabelCacheConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultLabelCacheConfig
+	type plain LabelCacheConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c LabelCacheConfig) Validate() error {
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("label cache refresh_interval must be positive")
+	}
+	for _, label := range c.Labels {
+		if !model.LabelName(label).IsValid() {
+			return fmt.Errorf("invalid label cache label %q", label)
+		}
+	}
+	return nil
+}
+
+// NewLabelCacheAPI returns a LabelCacheAPI with an empty cache, which is filled by
+// its Job (or Refresh)
+func NewLabelCacheAPI(a API, cfg LabelCacheConfig) *LabelCacheAPI {
+	return &LabelCacheAPI{
+		API:    a,
+		cfg:    cfg,
+		values: make(map[string]model.LabelValues, len(cfg.Labels)),
+	}
+}
+
+// LabelCacheAPI serves the label names and the values of the configured labels
+// from a cache which is refreshed in the background, so they are returned
+// instantly (e.g. for autocompletion) no matter how long the backends take.
+// Calls for anything which isn't cached (yet) are passed through.
+//
+// A refresh which fails (or only returned partial results, i.e. has warnings)
+// doesn't replace the cached value, so the last good one is served until a refresh
+// succeeds again.
+type LabelCacheAPI struct {
+	API
+	cfg LabelCacheConfig
+
+	mu     sync.RWMutex
+	names  []string
+	values map[string]model.LabelValues
+}
+
+// Job returns the job refreshing the cache every RefreshInterval, to be registered
+// with a scheduler.Scheduler. The cache is filled once the job is registered.
+func (c *LabelCacheAPI) Job() scheduler.Job {
+	return scheduler.Job{
+		Name:       "label_cache",
+		Downstream: "label_cache",
+		Interval:   c.cfg.RefreshInterval,
+		RunAtStart: true,
+		Run: func(ctx context.Context) {
+			if err := c.Refresh(ctx); err != nil {
+				logger.WithField("error", err).Warn("Error refreshing the label cache, keeping the cached values")
+			}
+		},
+	}
+}
+
+// Refresh fetches the label names and the values of the configured labels,
+// returning the first error. Each of them is refreshed independently, so a
+// failure only retains the previous value of the one that failed.
+func (c *LabelCacheAPI) Refresh(ctx context.Context) error {
+	// A refresh must not take longer than the interval, or they would pile up
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.RefreshInterval)
+	defer cancel()
+
+	var refreshErr error
+	names, w, err := c.API.LabelNames(ctx)
+	if err := refreshError("label names", w, err); err != nil {
+		refreshErr = err
+	} else {
+		// An empty result is cached as well
+		if names == nil {
+			names = []string{}
+		}
+		c.mu.Lock()
+		c.names = names
+		c.mu.Unlock()
+	}
+
+	for _, label := range c.cfg.Labels {
+		values, w, err := c.API.LabelValues(ctx, label)
+		if err := refreshError(fmt.Sprintf("values of label %q", label), w, err); err != nil {
+			if refreshErr == nil {
+				refreshErr = err
+			}
+			continue
+		}
+		c.mu.Lock()
+		c.values[label] = values
+		c.mu.Unlock()
+	}
+	return refreshErr
+}
+
+// refreshError returns the error of a refresh which mustn't replace the cached value
+func refreshError(what string, w api.Warnings, err error) error {
+	if err != nil {
+		return fmt.Errorf("error fetching %s: %v", what, err)
+	}
+	if len(w) > 0 {
+		return fmt.Errorf("partial results fetching %s: %v", what, w)
+	}
+	return nil
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (c *LabelCacheAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	c.mu.RLock()
+	names := c.names
+	c.mu.RUnlock()
+	if names != nil {
+		return append([]string(nil), names...), nil, nil
+	}
+	return c.API.LabelNames(ctx)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+// The cached label names aren't restricted to a time range, so these are never cached.
+func (c *LabelCacheAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, c.API, startTime, endTime)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (c *LabelCacheAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	c.mu.RLock()
+	values, ok := c.values[label]
+	c.mu.RUnlock()
+	if ok {
+		return append(model.LabelValues(nil), values...), nil, nil
+	}
+	return c.API.LabelValues(ctx, label)
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (c *LabelCacheAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, c.API, matches, startTime, endTime, fn)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (c *LabelCacheAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	return StreamGetValue(ctx, c.API, start, end, matchers)
+}
@@ -0,0 +1,107 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// labelStubAPI returns its label names and values (or error), counting the calls
+type labelStubAPI struct {
+	API
+	names    []string
+	values   map[string]model.LabelValues
+	warnings api.Warnings
+	err      error
+	calls    int
+}
+
+func (l *labelStubAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, nil, l.err
+	}
+	return l.names, l.warnings, nil
+}
+
+func (l *labelStubAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	l.calls++
+	if l.err != nil {
+		return nil, nil, l.err
+	}
+	return l.values[label], l.warnings, nil
+}
+
+func TestLabelCacheAPI(t *testing.T) {
+	stub := &labelStubAPI{
+		names:  []string{"__name__", "job"},
+		values: map[string]model.LabelValues{"job": {"a", "b"}, "instance": {"x"}},
+	}
+	cache := NewLabelCacheAPI(stub, LabelCacheConfig{RefreshInterval: time.Minute, Labels: []string{"job"}})
+
+	check := func(names []string, values model.LabelValues, calls int) {
+		t.Helper()
+		stub.calls = 0
+		n, _, err := cache.LabelNames(context.TODO())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(n, names) {
+			t.Fatalf("mismatch in names expected=%v actual=%v", names, n)
+		}
+		v, _, err := cache.LabelValues(context.TODO(), "job")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(v, values) {
+			t.Fatalf("mismatch in values expected=%v actual=%v", values, v)
+		}
+		if stub.calls != calls {
+			t.Fatalf("mismatch in downstream calls expected=%d actual=%d", calls, stub.calls)
+		}
+	}
+
+	// Until the first refresh the calls are passed through
+	check([]string{"__name__", "job"}, model.LabelValues{"a", "b"}, 2)
+
+	// Once refreshed the cache is served
+	if err := cache.Refresh(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stub.names = []string{"__name__", "job", "instance"}
+	stub.values["job"] = model.LabelValues{"a", "b", "c"}
+	check([]string{"__name__", "job"}, model.LabelValues{"a", "b"}, 0)
+
+	// Labels which aren't configured aren't cached
+	stub.calls = 0
+	if v, _, _ := cache.LabelValues(context.TODO(), "instance"); !reflect.DeepEqual(v, model.LabelValues{"x"}) || stub.calls != 1 {
+		t.Fatalf("mismatch in uncached values expected=%v actual=%v (%d calls)", model.LabelValues{"x"}, v, stub.calls)
+	}
+
+	// A failed refresh keeps the last good values
+	stub.err = fmt.Errorf("downstream unavailable")
+	if err := cache.Refresh(context.TODO()); err == nil {
+		t.Fatalf("expected refresh error")
+	}
+	check([]string{"__name__", "job"}, model.LabelValues{"a", "b"}, 0)
+
+	// So does a refresh with partial results
+	stub.err = nil
+	stub.warnings = api.Warnings{"servergroup b unavailable"}
+	if err := cache.Refresh(context.TODO()); err == nil {
+		t.Fatalf("expected refresh error")
+	}
+	check([]string{"__name__", "job"}, model.LabelValues{"a", "b"}, 0)
+
+	// A successful refresh replaces them
+	stub.warnings = nil
+	if err := cache.Refresh(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	check([]string{"__name__", "job", "instance"}, model.LabelValues{"a", "b", "c"}, 0)
+}
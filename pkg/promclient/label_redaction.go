@@ -0,0 +1,172 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/sirupsen/logrus"
+)
+
+// RedactedValue replaces the values of the redacted labels
+const RedactedValue = "<redacted>"
+
+// NewLabelRedactionAPI returns a LabelRedactionAPI redacting the values of the
+// given labels, logging to the logger (the promclient logger if nil)
+func NewLabelRedactionAPI(a API, labelNames []string, l logrus.FieldLogger) *LabelRedactionAPI {
+	if l == nil {
+		l = logger
+	}
+	if len(labelNames) == 0 {
+		return &LabelRedactionAPI{API: a, Logger: l}
+	}
+	quoted := make([]string, len(labelNames))
+	for i, name := range labelNames {
+		quoted[i] = regexp.QuoteMeta(name)
+	}
+	names := strings.Join(quoted, "|")
+	return &LabelRedactionAPI{
+		API:    a,
+		Logger: l,
+		// name="value" with any of the matcher operators (as in matchers and the
+		// string of a metric) and "name":"value" (as in JSON)
+		re: regexp.MustCompile(`(\b(?:` + names + `)\s*(?:=~|!~|!=|=)\s*|"(?:` + names + `)"\s*:\s*)"(?:[^"\\]|\\.)*"`),
+	}
+}
+
+// LabelRedactionAPI redacts the values of sensitive labels (e.g. user IDs) from
+// the errors and warnings of the API, and the calls it logs, as these end up in
+// logs and responses. The data itself is left alone.
+type LabelRedactionAPI struct {
+	API
+	Logger logrus.FieldLogger
+	re     *regexp.Regexp
+}
+
+// ErrRedacted is an error whose message had label values redacted, its Cause is
+// the original error
+type ErrRedacted struct {
+	msg string
+	err error
+}
+
+func (e *ErrRedacted) Error() string {
+	return e.msg
+}
+
+// Cause returns the original error, so its type can still be checked
+func (e *ErrRedacted) Cause() error {
+	return e.err
+}
+
+// Redact returns s with the values of the redacted labels replaced by RedactedValue
+func (r *LabelRedactionAPI) Redact(s string) string {
+	if r.re == nil {
+		return s
+	}
+	return r.re.ReplaceAllString(s, `${1}"`+RedactedValue+`"`)
+}
+
+// redact redacts the error and warnings of a call, logging the failed call
+func (r *LabelRedactionAPI) redact(method string, fields logrus.Fields, w api.Warnings, err error) (api.Warnings, error) {
+	if len(w) > 0 {
+		redacted := make(api.Warnings, len(w))
+		for i, warning := range w {
+			redacted[i] = r.Redact(warning)
+		}
+		w = redacted
+	}
+	if err == nil {
+		return w, nil
+	}
+	msg := err.Error()
+	if redacted := r.Redact(msg); redacted != msg {
+		err = &ErrRedacted{msg: redacted, err: err}
+	}
+	for k, v := range fields {
+		fields[k] = r.Redact(fmt.Sprint(v))
+	}
+	fields["api"] = method
+	fields["error"] = err.Error()
+	r.Logger.WithFields(fields).Debug("API call failed")
+	return w, err
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (r *LabelRedactionAPI) Key() model.LabelSet {
+	return apiKey(r.API)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (r *LabelRedactionAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	v, w, err := r.API.LabelNames(ctx)
+	w, err = r.redact("LabelNames", logrus.Fields{}, w, err)
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (r *LabelRedactionAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	v, w, err := LabelNamesInRange(ctx, r.API, startTime, endTime)
+	w, err = r.redact("LabelNamesInRange", logrus.Fields{"start": startTime, "end": endTime}, w, err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (r *LabelRedactionAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := r.API.LabelValues(ctx, label)
+	w, err = r.redact("LabelValues", logrus.Fields{"label": label}, w, err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (r *LabelRedactionAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.Query(ctx, query, ts)
+	w, err = r.redact("Query", logrus.Fields{"query": query, "ts": ts}, w, err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (r *LabelRedactionAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.QueryRange(ctx, query, rng)
+	w, err = r.redact("QueryRange", logrus.Fields{"query": query, "start": rng.Start, "end": rng.End}, w, err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (r *LabelRedactionAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	v, w, err := r.API.Series(ctx, matches, startTime, endTime)
+	w, err = r.redact("Series", logrus.Fields{"matches": matches, "start": startTime, "end": endTime}, w, err)
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (r *LabelRedactionAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	w, err := StreamSeries(ctx, r.API, matches, startTime, endTime, fn)
+	return r.redact("Series", logrus.Fields{"matches": matches, "start": startTime, "end": endTime}, w, err)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *LabelRedactionAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.GetValue(ctx, start, end, matchers)
+	w, err = r.redact("GetValue", logrus.Fields{"matchers": matchers, "start": start, "end": end}, w, err)
+	return v, w, err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (r *LabelRedactionAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	ch, err := StreamGetValue(ctx, r.API, start, end, matchers)
+	if err != nil {
+		_, err = r.redact("StreamGetValue", logrus.Fields{"matchers": matchers, "start": start, "end": end}, nil, err)
+		return nil, err
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		return r.redact("StreamGetValue", logrus.Fields{"matchers": matchers, "start": start, "end": end}, w, err)
+	}), nil
+}
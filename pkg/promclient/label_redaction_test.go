@@ -0,0 +1,137 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/sirupsen/logrus"
+)
+
+func TestLabelRedactionAPI(t *testing.T) {
+	upstreamErr := errors.New(`found duplicate series for the match group {email="jane@example.com", job="api"} on the right hand-side; {"user_id":"42"} and user_id=~"4.*"`)
+	expected := `found duplicate series for the match group {email="<redacted>", job="api"} on the right hand-side; {"user_id":"<redacted>"} and user_id=~"<redacted>"`
+
+	var buf bytes.Buffer
+	l := logrus.New()
+	l.Out = &buf
+	l.Level = logrus.DebugLevel
+	a := NewLabelRedactionAPI(&errorAPI{err: upstreamErr}, []string{"email", "user_id"}, l)
+
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "email", "jane@example.com")}
+	calls := map[string]func() error{
+		"LabelNames": func() error {
+			_, _, err := a.LabelNames(context.TODO())
+			return err
+		},
+		"LabelValues": func() error {
+			_, _, err := a.LabelValues(context.TODO(), "job")
+			return err
+		},
+		"Query": func() error {
+			_, _, err := a.Query(context.TODO(), `up{email="jane@example.com"}`, time.Now())
+			return err
+		},
+		"QueryRange": func() error {
+			_, _, err := a.QueryRange(context.TODO(), `up{email="jane@example.com"}`, v1.Range{Start: time.Now(), End: time.Now(), Step: time.Second})
+			return err
+		},
+		"Series": func() error {
+			_, _, err := a.Series(context.TODO(), []string{`{email="jane@example.com"}`}, time.Now(), time.Now())
+			return err
+		},
+		"GetValue": func() error {
+			_, _, err := a.GetValue(context.TODO(), time.Now(), time.Now(), matchers)
+			return err
+		},
+	}
+
+	for name, call := range calls {
+		t.Run(name, func(t *testing.T) {
+			buf.Reset()
+			err := call()
+			if err == nil || err.Error() != expected {
+				t.Fatalf("mismatch in error expected=%s actual=%v", expected, err)
+			}
+			if cause := err.(*ErrRedacted).Cause(); cause != upstreamErr {
+				t.Fatalf("mismatch in cause expected=%v actual=%v", upstreamErr, cause)
+			}
+			if !strings.Contains(buf.String(), "API call failed") {
+				t.Fatalf("mismatch in log expected=%s actual=%s", "API call failed", buf.String())
+			}
+			if strings.Contains(buf.String(), "jane@example.com") {
+				t.Fatalf("unredacted label value in log: %s", buf.String())
+			}
+		})
+	}
+}
+
+func TestLabelRedactionAPIRedact(t *testing.T) {
+	tests := []struct {
+		labels   []string
+		in       string
+		expected string
+	}{
+		{
+			labels:   []string{"user_id"},
+			in:       `{user_id="a\"b", job="x"}`,
+			expected: `{user_id="<redacted>", job="x"}`,
+		},
+		{
+			labels:   []string{"user_id"},
+			in:       `{other_user_id="a", user_id!="b"}`,
+			expected: `{other_user_id="a", user_id!="<redacted>"}`,
+		},
+		{
+			labels:   nil,
+			in:       `{user_id="a"}`,
+			expected: `{user_id="a"}`,
+		},
+	}
+	for i, test := range tests {
+		if actual := NewLabelRedactionAPI(nil, test.labels, nil).Redact(test.in); actual != test.expected {
+			t.Fatalf("%d: mismatch in redacted expected=%s actual=%s", i, test.expected, actual)
+		}
+	}
+}
+
+func TestDebugAPIRedact(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	logrus.SetLevel(logrus.TraceLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	stub := &valueAPI{v: model.Vector{{Metric: model.Metric{"email": "jane@example.com"}, Value: 1}}}
+	a := &DebugAPI{
+		API:           stub,
+		PrefixMessage: "debug",
+		Redact:        NewLabelRedactionAPI(stub, []string{"email"}, nil).Redact,
+	}
+	if _, _, err := a.Query(context.TODO(), `up{email="jane@example.com"}`, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Both the query and the (trace level) result are logged redacted
+	if !strings.Contains(buf.String(), "debug") {
+		t.Fatalf("mismatch in log expected=%s actual=%s", "debug", buf.String())
+	}
+	if strings.Contains(buf.String(), "jane@example.com") {
+		t.Fatalf("unredacted label value in log: %s", buf.String())
+	}
+
+	// Without Redact the fields are logged as is
+	buf.Reset()
+	a.Redact = nil
+	if _, _, err := a.Query(context.TODO(), `up{email="jane@example.com"}`, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(buf.String(), "jane@example.com") {
+		t.Fatalf("mismatch in log expected=%s actual=%s", "jane@example.com", buf.String())
+	}
+}
@@ -1,10 +1,14 @@
 package promclient
 
 import (
+	"context"
 	"reflect"
 	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/prometheus/client_golang/api"
 	model "github.com/prometheus/common/model"
 )
 
@@ -85,3 +89,90 @@ func TestMergeLabelSets(t *testing.T) {
 		})
 	}
 }
+
+// labelValuesAPI returns the values of each label, counting its calls
+type labelValuesAPI struct {
+	API
+	values map[string]model.LabelValues
+	calls  int32
+}
+
+func (l *labelValuesAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	atomic.AddInt32(&l.calls, 1)
+	return l.values[label], nil, nil
+}
+
+func TestMultiAPILabelValues(t *testing.T) {
+	a := &labelValuesAPI{values: map[string]model.LabelValues{
+		model.MetricNameLabel: {"up", "http_requests_total", "go_goroutines"},
+		"job":                 {"api"},
+	}}
+	b := &labelValuesAPI{values: map[string]model.LabelValues{
+		model.MetricNameLabel: {"node_load1", "up", "apiserver_request_total"},
+		"job":                 {"node", "api"},
+	}}
+	multi := NewMultiAPI([]API{a, b}, 0, nil, 1)
+
+	tests := []struct {
+		label    string
+		expected model.LabelValues
+		err      bool
+	}{
+		{label: "", err: true},
+		{label: "not-a-label", err: true},
+		{
+			label:    model.MetricNameLabel,
+			expected: model.LabelValues{"apiserver_request_total", "go_goroutines", "http_requests_total", "node_load1", "up"},
+		},
+		{label: "job", expected: model.LabelValues{"api", "node"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.label, func(t *testing.T) {
+			calls := atomic.LoadInt32(&a.calls)
+			v, _, err := multi.LabelValues(context.TODO(), test.label)
+			if test.err {
+				if _, ok := err.(*ErrInvalidLabelName); !ok {
+					t.Fatalf("mismatch in error expected=%T actual=%v", &ErrInvalidLabelName{}, err)
+				}
+				// Invalid label names aren't sent downstream
+				if c := atomic.LoadInt32(&a.calls); c != calls {
+					t.Fatalf("mismatch in downstream calls expected=%d actual=%d", calls, c)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(v, test.expected) {
+				t.Fatalf("mismatch in values expected=%v actual=%v", test.expected, v)
+			}
+		})
+	}
+
+	// The values of the downstreams are left alone
+	expected := model.LabelValues{"up", "http_requests_total", "go_goroutines"}
+	if !reflect.DeepEqual(a.values[model.MetricNameLabel], expected) {
+		t.Fatalf("mismatch in downstream values expected=%v actual=%v", expected, a.values[model.MetricNameLabel])
+	}
+}
+
+func TestAddLabelClientKeyWrapped(t *testing.T) {
+	labels := model.LabelSet{"az": "a"}
+	// Wrapped as the servergroups wrap the clients of their hosts
+	var client API = &AddLabelClient{API: &stubAPI{}, Labels: labels}
+	client = NewFaultInjector().Wrap("a:9090", client)
+	client = NewCircuitBreakers().Wrap("a:9090", client, CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: time.Minute})
+	client = NewHealthMonitor().Wrap("a:9090", client)
+	client = NewLabelRedactionAPI(client, []string{"user"}, nil)
+	client = &TracingAPI{API: client, Name: "a:9090"}
+	client = &DebugAPI{API: client, PrefixMessage: "http://a:9090"}
+
+	apiLabels, ok := client.(APILabels)
+	if !ok {
+		t.Fatalf("wrapped client doesn't implement APILabels")
+	}
+	if key := apiLabels.Key(); !reflect.DeepEqual(key, labels) {
+		t.Fatalf("mismatch in key expected=%v actual=%v", labels, key)
+	}
+}
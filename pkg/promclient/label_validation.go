@@ -0,0 +1,201 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// LabelValidationMode defines what is done with invalid label sets from a downstream
+type LabelValidationMode string
+
+// The label validation modes
+const (
+	// LabelValidationReject fails the request if any label set is invalid
+	LabelValidationReject LabelValidationMode = "reject"
+	// LabelValidationSanitize drops the invalid series and adds a warning
+	LabelValidationSanitize LabelValidationMode = "sanitize"
+	// LabelValidationNone skips validation, for trusted downstreams
+	LabelValidationNone LabelValidationMode = "none"
+)
+
+// Validate returns an error if the mode isn't a known LabelValidationMode
+func (m LabelValidationMode) Validate() error {
+	switch m {
+	case LabelValidationReject, LabelValidationSanitize, LabelValidationNone:
+		return nil
+	default:
+		return fmt.Errorf("unknown label validation mode %q", m)
+	}
+}
+
+// ValidLabelSet returns whether all label names in the set are non-empty and match
+// the prometheus label name regex, and all values are valid UTF-8
+func ValidLabelSet(ls model.LabelSet) bool {
+	for k, v := range ls {
+		if !k.IsValid() || !v.IsValid() {
+			return false
+		}
+	}
+	return true
+}
+
+// LabelValidationAPI validates the label sets returned from the downstream. Since
+// this has to run on every series it should be as close to the decode as possible
+// and is skipped entirely when the mode is LabelValidationNone.
+// Note: duplicate label names are lost when the JSON is decoded into a map, so
+// this can only catch the labels which are invalid on their own.
+type LabelValidationAPI struct {
+	API
+	Mode LabelValidationMode
+	// InvalidFunc (if set) is called with the number of invalid label sets seen
+	InvalidFunc func(count int)
+}
+
+// filterValue validates the label sets in the value
+func (l *LabelValidationAPI) filterValue(v model.Value, w api.Warnings, err error) (model.Value, api.Warnings, error) {
+	if err != nil || l.Mode == LabelValidationNone {
+		return v, w, err
+	}
+
+	invalid := 0
+	switch valueTyped := v.(type) {
+	case model.Vector:
+		filtered := valueTyped[:0]
+		for _, sample := range valueTyped {
+			if ValidLabelSet(model.LabelSet(sample.Metric)) {
+				filtered = append(filtered, sample)
+			} else {
+				invalid++
+			}
+		}
+		v = filtered
+	case model.Matrix:
+		filtered := valueTyped[:0]
+		for _, stream := range valueTyped {
+			if ValidLabelSet(model.LabelSet(stream.Metric)) {
+				filtered = append(filtered, stream)
+			} else {
+				invalid++
+			}
+		}
+		v = filtered
+	}
+
+	return l.result(v, w, invalid)
+}
+
+// result handles the invalid label sets based on the mode
+func (l *LabelValidationAPI) result(v model.Value, w api.Warnings, invalid int) (model.Value, api.Warnings, error) {
+	if invalid == 0 {
+		return v, w, nil
+	}
+	if l.InvalidFunc != nil {
+		l.InvalidFunc(invalid)
+	}
+
+	msg := fmt.Sprintf("downstream returned %d series with invalid label sets", invalid)
+	if l.Mode == LabelValidationReject {
+		return nil, w, &v1.Error{Type: v1.ErrBadResponse, Msg: msg}
+	}
+	return v, append(w, msg+", these series were dropped"), nil
+}
+
+// Query performs a query for the given time.
+func (l *LabelValidationAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	return l.filterValue(l.API.Query(ctx, query, ts))
+}
+
+// QueryRange performs a query for the given range.
+func (l *LabelValidationAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	return l.filterValue(l.API.QueryRange(ctx, query, r))
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (l *LabelValidationAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return l.filterValue(l.API.GetValue(ctx, start, end, matchers))
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (l *LabelValidationAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	ch, err := StreamGetValue(ctx, l.API, start, end, matchers)
+	if err != nil || l.Mode == LabelValidationNone {
+		return ch, err
+	}
+
+	invalid := 0
+	return filterValueStream(ctx, ch, func(s *model.SampleStream) (*model.SampleStream, error) {
+		if ValidLabelSet(model.LabelSet(s.Metric)) {
+			return s, nil
+		}
+		invalid++
+		if l.Mode == LabelValidationReject {
+			_, _, err := l.result(nil, nil, invalid)
+			return nil, err
+		}
+		return nil, nil
+	}, func(w api.Warnings, err error) (api.Warnings, error) {
+		if err != nil || l.Mode == LabelValidationReject {
+			return w, err
+		}
+		_, w, err = l.result(nil, w, invalid)
+		return w, err
+	}), nil
+}
+
+// Series finds series by label matchers.
+func (l *LabelValidationAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	v, w, err := l.API.Series(ctx, matches, startTime, endTime)
+	if err != nil || l.Mode == LabelValidationNone {
+		return v, w, err
+	}
+
+	filtered := v[:0]
+	for _, ls := range v {
+		if ValidLabelSet(ls) {
+			filtered = append(filtered, ls)
+		}
+	}
+
+	if _, w, err = l.result(nil, w, len(v)-len(filtered)); err != nil {
+		return nil, w, err
+	}
+	return filtered, w, nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (l *LabelValidationAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if l.Mode == LabelValidationNone {
+		return StreamSeries(ctx, l.API, matches, startTime, endTime, fn)
+	}
+
+	invalid := 0
+	w, err := StreamSeries(ctx, l.API, matches, startTime, endTime, func(ls model.LabelSet) error {
+		if !ValidLabelSet(ls) {
+			invalid++
+			if l.Mode == LabelValidationReject {
+				_, _, err := l.result(nil, nil, invalid)
+				return err
+			}
+			return nil
+		}
+		return fn(ls)
+	})
+	if err != nil {
+		return w, err
+	}
+
+	_, w, err = l.result(nil, w, invalid)
+	return w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (l *LabelValidationAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, l.API, startTime, endTime)
+}
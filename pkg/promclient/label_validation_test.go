@@ -0,0 +1,75 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestLabelValidationAPI(t *testing.T) {
+	matrix := func() model.Value {
+		return model.Matrix{
+			{Metric: model.Metric{model.MetricNameLabel: "up", "job": "a"}},
+			{Metric: model.Metric{model.MetricNameLabel: "up", "": "empty"}},
+			{Metric: model.Metric{model.MetricNameLabel: "up", "bad-name": "a"}},
+			{Metric: model.Metric{model.MetricNameLabel: "up", "job": "\xff"}},
+		}
+	}
+	stub := &stubAPI{
+		getValue: matrix,
+		series: func() []model.LabelSet {
+			return []model.LabelSet{
+				{model.MetricNameLabel: "up"},
+				{"1abc": "a"},
+			}
+		},
+	}
+
+	tests := []struct {
+		mode     LabelValidationMode
+		err      bool
+		series   int
+		invalid  int
+		warnings int
+	}{
+		{mode: LabelValidationSanitize, series: 1, invalid: 3, warnings: 1},
+		{mode: LabelValidationReject, err: true, invalid: 3},
+		{mode: LabelValidationNone, series: 4},
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.mode), func(t *testing.T) {
+			invalid := 0
+			a := &LabelValidationAPI{
+				API:         stub,
+				Mode:        test.mode,
+				InvalidFunc: func(count int) { invalid += count },
+			}
+
+			v, warnings, err := a.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), nil)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if invalid != test.invalid {
+				t.Fatalf("mismatch in invalid count expected=%d actual=%d", test.invalid, invalid)
+			}
+			if len(warnings) != test.warnings {
+				t.Fatalf("mismatch in warnings expected=%d actual=%v", test.warnings, warnings)
+			}
+			if err == nil && len(v.(model.Matrix)) != test.series {
+				t.Fatalf("mismatch in series expected=%d actual=%v", test.series, v)
+			}
+
+			// Series are validated the same way
+			labelsets, _, err := a.Series(context.TODO(), []string{"up"}, time.Unix(0, 0), time.Unix(100, 0))
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in series error expected=%v actual=%v", test.err, err)
+			}
+			if test.mode == LabelValidationSanitize && len(labelsets) != 1 {
+				t.Fatalf("invalid labelset not dropped: %v", labelsets)
+			}
+		})
+	}
+}
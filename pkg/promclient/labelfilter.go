@@ -53,6 +53,32 @@ func (l *LabelFilterVisitor) Visit(node promql.Node, path []promql.Node) (w prom
 // FilterMatchers applies the matchers to the given labelset to determine if there is a match
 // and to return all remaining matchers to be matched
 func FilterMatchers(ls model.LabelSet, matchers []*labels.Matcher) ([]*labels.Matcher, bool) {
+	filteredMatchers, ok := stripMatchers(ls, matchers)
+	if !ok {
+		return nil, false
+	}
+
+	// If all of the matchers were stripped we'd end up with an empty selector (`{}`)
+	// which prometheus rejects. Since everything left matched, we replace it with a
+	// selector that matches all series
+	if len(matchers) > 0 && len(filteredMatchers) == 0 {
+		filteredMatchers = append(filteredMatchers, matchAllMatcher())
+	}
+	return filteredMatchers, true
+}
+
+// matchAllMatcher returns a matcher which matches all series
+func matchAllMatcher() *labels.Matcher {
+	m, err := labels.NewMatcher(labels.MatchRegexp, model.MetricNameLabel, ".+")
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+// stripMatchers returns the matchers on labels which aren't in the given labelset,
+// and whether the matchers on the labels which are in it all match
+func stripMatchers(ls model.LabelSet, matchers []*labels.Matcher) ([]*labels.Matcher, bool) {
 	filteredMatchers := make([]*labels.Matcher, 0, len(matchers))
 
 	// Look over the matchers passed in, if any exist in our labels, we'll do the matcher, and then strip
@@ -0,0 +1,182 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrLatencyBudgetExhausted is returned for calls made after the latency budget
+// of the request was used up
+type ErrLatencyBudgetExhausted time.Duration
+
+func (e ErrLatencyBudgetExhausted) Error() string {
+	return fmt.Sprintf("latency budget of %v exhausted", time.Duration(e))
+}
+
+type latencyBudgetKey struct{}
+
+// WithLatencyBudget returns a context carrying the latency budget of the request
+func WithLatencyBudget(ctx context.Context, b *LatencyBudget) context.Context {
+	return context.WithValue(ctx, latencyBudgetKey{}, b)
+}
+
+// LatencyBudgetFromContext returns the LatencyBudget of the context (if there is one)
+func LatencyBudgetFromContext(ctx context.Context) *LatencyBudget {
+	b, _ := ctx.Value(latencyBudgetKey{}).(*LatencyBudget)
+	return b
+}
+
+// NewLatencyBudget returns a LatencyBudget of the given total
+func NewLatencyBudget(total time.Duration) *LatencyBudget {
+	return &LatencyBudget{total: total, remaining: total}
+}
+
+// LatencyBudget is the time the API calls of a single request (e.g. all the Selects
+// of a query) may take in total. Each call deducts its latency, so once a slow call
+// used up the budget the following calls fail immediately instead of each waiting
+// for the full upstream timeout. Concurrent calls each deduct their own latency.
+type LatencyBudget struct {
+	mu        sync.Mutex
+	total     time.Duration
+	remaining time.Duration
+}
+
+// Remaining returns the remaining budget
+func (b *LatencyBudget) Remaining() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.remaining
+}
+
+// spend deducts the latency of a call from the budget
+func (b *LatencyBudget) spend(d time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.remaining -= d
+}
+
+// LatencyBudgetAPI enforces the LatencyBudget of the context (if any) on the calls
+// to the wrapped API. A call is limited to the remaining budget and fails with an
+// ErrLatencyBudgetExhausted if there is none left.
+type LatencyBudgetAPI struct {
+	API
+}
+
+// call runs fn with the budget of the context
+func (l *LatencyBudgetAPI) call(ctx context.Context, fn func(context.Context) error) error {
+	b := LatencyBudgetFromContext(ctx)
+	if b == nil {
+		return fn(ctx)
+	}
+
+	remaining := b.Remaining()
+	if remaining <= 0 {
+		return ErrLatencyBudgetExhausted(b.total)
+	}
+	childCtx, cancel := context.WithTimeout(ctx, remaining)
+	defer cancel()
+
+	start := time.Now()
+	err := fn(childCtx)
+	b.spend(time.Since(start))
+
+	// Distinguish running out of budget from the deadline of the parent context
+	if err != nil && childCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		return ErrLatencyBudgetExhausted(b.total)
+	}
+	return err
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (l *LatencyBudgetAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	var v []string
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.LabelNames(ctx)
+		return err
+	})
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (l *LatencyBudgetAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	var v []string
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = LabelNamesInRange(ctx, l.API, startTime, endTime)
+		return err
+	})
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (l *LatencyBudgetAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	var v model.LabelValues
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.LabelValues(ctx, label)
+		return err
+	})
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (l *LatencyBudgetAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.Query(ctx, query, ts)
+		return err
+	})
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (l *LatencyBudgetAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.QueryRange(ctx, query, r)
+		return err
+	})
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (l *LatencyBudgetAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	var v []model.LabelSet
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.Series(ctx, matches, startTime, endTime)
+		return err
+	})
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (l *LatencyBudgetAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		w, err = StreamSeries(ctx, l.API, matches, startTime, endTime, fn)
+		return err
+	})
+	return w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (l *LatencyBudgetAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := l.call(ctx, func(ctx context.Context) (err error) {
+		v, w, err = l.API.GetValue(ctx, start, end, matchers)
+		return err
+	})
+	return v, w, err
+}
@@ -0,0 +1,70 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// slowAPI takes the configured latency for every query (or until the context is done)
+type slowAPI struct {
+	API
+	latency time.Duration
+	calls   int
+}
+
+func (s *slowAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	s.calls++
+	select {
+	case <-time.After(s.latency):
+		return model.Vector{}, nil, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+func TestLatencyBudgetAPI(t *testing.T) {
+	stub := &slowAPI{latency: 50 * time.Millisecond}
+	a := &LatencyBudgetAPI{stub}
+
+	// Without a budget calls are passed through
+	if _, _, err := a.Query(context.TODO(), "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The first call is cut off once it exhausted the budget
+	ctx := WithLatencyBudget(context.TODO(), NewLatencyBudget(20*time.Millisecond))
+	_, _, err := a.Query(ctx, "up", time.Now())
+	if _, ok := err.(ErrLatencyBudgetExhausted); !ok {
+		t.Fatalf("expected ErrLatencyBudgetExhausted, got: %v", err)
+	}
+
+	// So the second call fails immediately, without calling the API
+	calls := stub.calls
+	start := time.Now()
+	_, _, err = a.Query(ctx, "up", time.Now())
+	if _, ok := err.(ErrLatencyBudgetExhausted); !ok {
+		t.Fatalf("expected ErrLatencyBudgetExhausted, got: %v", err)
+	}
+	if stub.calls != calls {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", calls, stub.calls)
+	}
+	if took := time.Since(start); took > 10*time.Millisecond {
+		t.Fatalf("exhausted budget didn't fail immediately, took %v", took)
+	}
+
+	// Calls within the budget deduct their latency
+	b := NewLatencyBudget(time.Second)
+	ctx = WithLatencyBudget(context.TODO(), b)
+	for i := 0; i < 2; i++ {
+		if _, _, err := a.Query(ctx, "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if remaining := b.Remaining(); remaining > time.Second-100*time.Millisecond {
+		t.Fatalf("latency not deducted from the budget, remaining=%v", remaining)
+	}
+}
@@ -0,0 +1,183 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ConcurrencyConfig configures a LimitConcurrencyAPI. The metadata calls
+// (LabelNames, LabelValues and Series) and the data calls (Query, QueryRange and
+// GetValue) have limits of their own, so a burst of one (e.g. autocompletion while
+// dashboards load) can't starve the other.
+type ConcurrencyConfig struct {
+	// Metadata is the max number of metadata calls in flight at once (0 is no limit)
+	Metadata int `yaml:"metadata"`
+	// Data is the max number of data calls in flight at once (0 is no limit)
+	Data int `yaml:"data"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ConcurrencyConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = ConcurrencyConfig{}
+	type plain ConcurrencyConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c ConcurrencyConfig) Validate() error {
+	if c.Metadata < 0 {
+		return fmt.Errorf("concurrency metadata must not be negative")
+	}
+	if c.Data < 0 {
+		return fmt.Errorf("concurrency data must not be negative")
+	}
+	return nil
+}
+
+// concurrencyLimiter is a semaphore, a nil limiter doesn't limit anything
+type concurrencyLimiter chan struct{}
+
+func newConcurrencyLimiter(limit int) concurrencyLimiter {
+	if limit <= 0 {
+		return nil
+	}
+	return make(concurrencyLimiter, limit)
+}
+
+// acquire waits for a slot of the limiter, or until the context is done
+func (l concurrencyLimiter) acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees the slot of an acquire
+func (l concurrencyLimiter) release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// NewLimitConcurrencyAPI returns a LimitConcurrencyAPI with the limits of the config
+func NewLimitConcurrencyAPI(a API, cfg ConcurrencyConfig) *LimitConcurrencyAPI {
+	return &LimitConcurrencyAPI{
+		API:      a,
+		metadata: newConcurrencyLimiter(cfg.Metadata),
+		data:     newConcurrencyLimiter(cfg.Data),
+	}
+}
+
+// LimitConcurrencyAPI limits the number of calls in flight to the wrapped API,
+// with separate limits for the metadata and the data calls. Which limit applies is
+// decided by the method called. Calls over the limit wait for one of the calls in
+// flight to finish, or until their context is done.
+type LimitConcurrencyAPI struct {
+	API
+	metadata concurrencyLimiter
+	data     concurrencyLimiter
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (l *LimitConcurrencyAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	if err := l.metadata.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.metadata.release()
+	return l.API.LabelNames(ctx)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (l *LimitConcurrencyAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if err := l.metadata.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.metadata.release()
+	return LabelNamesInRange(ctx, l.API, startTime, endTime)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (l *LimitConcurrencyAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if err := l.metadata.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.metadata.release()
+	return l.API.LabelValues(ctx, label)
+}
+
+// Series finds series by label matchers.
+func (l *LimitConcurrencyAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if err := l.metadata.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.metadata.release()
+	return l.API.Series(ctx, matches, startTime, endTime)
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (l *LimitConcurrencyAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if err := l.metadata.acquire(ctx); err != nil {
+		return nil, err
+	}
+	defer l.metadata.release()
+	return StreamSeries(ctx, l.API, matches, startTime, endTime, fn)
+}
+
+// Query performs a query for the given time.
+func (l *LimitConcurrencyAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if err := l.data.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.data.release()
+	return l.API.Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (l *LimitConcurrencyAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if err := l.data.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.data.release()
+	return l.API.QueryRange(ctx, query, r)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (l *LimitConcurrencyAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if err := l.data.acquire(ctx); err != nil {
+		return nil, nil, err
+	}
+	defer l.data.release()
+	return l.API.GetValue(ctx, start, end, matchers)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (l *LimitConcurrencyAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if err := l.data.acquire(ctx); err != nil {
+		return nil, err
+	}
+	ch, err := StreamGetValue(ctx, l.API, start, end, matchers)
+	if err != nil {
+		l.data.release()
+		return nil, err
+	}
+	// The stream counts against the limit until it ends
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		l.data.release()
+		return w, err
+	}), nil
+}
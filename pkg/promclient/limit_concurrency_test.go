@@ -0,0 +1,59 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// TestLimitConcurrencyAPI saturates the metadata limit, the data calls still
+// proceed while further metadata calls wait
+func TestLimitConcurrencyAPI(t *testing.T) {
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+	stub := &stubAPI{
+		series: func() []model.LabelSet {
+			started <- struct{}{}
+			<-unblock
+			return nil
+		},
+		getValue:   func() model.Value { return model.Matrix{} },
+		query:      func() model.Value { return model.Vector{} },
+		queryRange: func() model.Value { return model.Matrix{} },
+	}
+	limited := NewLimitConcurrencyAPI(stub, ConcurrencyConfig{Metadata: 2, Data: 1})
+
+	// Saturate the metadata limit
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, _, err := limited.Series(context.TODO(), []string{"up"}, time.Unix(0, 0), time.Unix(100, 0))
+			done <- err
+		}()
+		<-started
+	}
+
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	if _, _, err := limited.GetValue(ctx, time.Unix(0, 0), time.Unix(100, 0), nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, err := limited.Query(ctx, "up", time.Unix(100, 0)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	waitCtx, waitCancel := context.WithTimeout(context.TODO(), 50*time.Millisecond)
+	defer waitCancel()
+	if _, _, err := limited.Series(waitCtx, []string{"up"}, time.Unix(0, 0), time.Unix(100, 0)); err != context.DeadlineExceeded {
+		t.Fatalf("mismatch in error of metadata call over the limit expected=%v actual=%v", context.DeadlineExceeded, err)
+	}
+
+	close(unblock)
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,446 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// The modes of a LoadBalancedAPI, which decide where requests without an
+// UpstreamHint go
+const (
+	// LoadBalanceRoundRobin spreads the requests evenly across the apis
+	LoadBalanceRoundRobin = "round_robin"
+	// LoadBalanceP2C (power of two choices) picks two apis at random and sends the
+	// request to the one with the lower latency, weighted by its requests in flight
+	LoadBalanceP2C = "p2c"
+)
+
+// coldHalfLives is the number of half-lives after which the latency of an api which
+// wasn't observed since is considered unknown
+const coldHalfLives = 5
+
+// LoadBalancerConfig configures a LoadBalancedAPI
+type LoadBalancerConfig struct {
+	// Mode is how the requests without an UpstreamHint are spread across the apis
+	// (LoadBalanceRoundRobin or LoadBalanceP2C)
+	Mode string `yaml:"mode"`
+	// EWMAHalfLife is the time after which an observed latency counts for half in
+	// the moving average of the latency of an api
+	EWMAHalfLife time.Duration `yaml:"ewma_half_life"`
+}
+
+// DefaultLoadBalancerConfig is the LoadBalancerConfig used for unset fields
+var DefaultLoadBalancerConfig = LoadBalancerConfig{
+	Mode:         LoadBalanceRoundRobin,
+	EWMAHalfLife: 10 * time.Second,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *LoadBalancerConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultLoadBalancerConfig
+	type plain LoadBalancerConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c LoadBalancerConfig) Validate() error {
+	switch c.Mode {
+	case LoadBalanceRoundRobin, LoadBalanceP2C:
+	default:
+		return fmt.Errorf("unknown load balancer mode %q", c.Mode)
+	}
+	if c.EWMAHalfLife <= 0 {
+		return fmt.Errorf("load balancer ewma_half_life must be positive")
+	}
+	return nil
+}
+
+// LatencyTracker tracks the latency of an upstream as an exponentially weighted
+// moving average, which decays with the time between the observations: after one
+// EWMAHalfLife the previous average counts for half.
+type LatencyTracker struct {
+	Name string
+
+	mu sync.Mutex
+	// ewma is the moving average latency in seconds, as of lastObserved
+	ewma         float64
+	lastObserved time.Time
+	// lastSuccess is the last time the upstream responded successfully
+	lastSuccess time.Time
+	inFlight    int
+	selections  uint64
+}
+
+// observe records the latency of a successful request which finished at now
+func (t *LatencyTracker) observe(took time.Duration, now time.Time, halfLife time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastObserved.IsZero() {
+		t.ewma = took.Seconds()
+	} else {
+		w := math.Exp2(-float64(now.Sub(t.lastObserved)) / float64(halfLife))
+		t.ewma = w*t.ewma + (1-w)*took.Seconds()
+	}
+	t.lastObserved = now
+	t.lastSuccess = now
+}
+
+// latency returns the moving average latency, and whether it is known: an upstream
+// which wasn't observed for coldHalfLives (or ever, e.g. as it was just discovered)
+// is cold
+func (t *LatencyTracker) latency(now time.Time, halfLife time.Duration) (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.lastObserved.IsZero() || now.Sub(t.lastObserved) > coldHalfLives*halfLife {
+		return 0, false
+	}
+	return t.ewma, true
+}
+
+// DefaultLatencyTrackers are the LatencyTrackers of the load balanced servergroups
+var DefaultLatencyTrackers = NewLatencyTrackers()
+
+// NewLatencyTrackers returns an empty LatencyTrackers
+func NewLatencyTrackers() *LatencyTrackers {
+	return &LatencyTrackers{trackers: make(map[string]*LatencyTracker)}
+}
+
+// LatencyTrackers holds the LatencyTracker of each upstream by name. As the clients
+// are rebuilt whenever the targets change, the latency of an upstream is kept across
+// the LoadBalancedAPIs it is part of, while newly discovered upstreams start cold.
+type LatencyTrackers struct {
+	mu       sync.Mutex
+	trackers map[string]*LatencyTracker
+}
+
+// Get returns the LatencyTracker of the named upstream
+func (l *LatencyTrackers) Get(name string) *LatencyTracker {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	t, ok := l.trackers[name]
+	if !ok {
+		t = &LatencyTracker{Name: name}
+		l.trackers[name] = t
+	}
+	return t
+}
+
+// LoadBalance returns a LoadBalancedAPI over the given equivalent apis, tracking the
+// latency of each with the LatencyTracker of its name
+func (l *LatencyTrackers) LoadBalance(names []string, apis []API, cfg LoadBalancerConfig) *LoadBalancedAPI {
+	trackers := make([]*LatencyTracker, len(apis))
+	for i := range apis {
+		trackers[i] = l.Get(names[i])
+	}
+	return &LoadBalancedAPI{
+		apis:     apis,
+		trackers: trackers,
+		cfg:      cfg,
+		now:      time.Now,
+		rand:     rand.Intn,
+	}
+}
+
+var (
+	loadBalancerLatencyDesc = prometheus.NewDesc(
+		"promproxy_load_balancer_latency_ewma_seconds",
+		"The moving average latency of the upstream, as used by the load balancer",
+		[]string{"upstream"}, nil,
+	)
+	loadBalancerSelectionsDesc = prometheus.NewDesc(
+		"promproxy_load_balancer_selections_total",
+		"Number of requests the load balancer sent to the upstream (its rate over the sum of the rates is its selection share)",
+		[]string{"upstream"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (l *LatencyTrackers) Describe(ch chan<- *prometheus.Desc) {
+	ch <- loadBalancerLatencyDesc
+	ch <- loadBalancerSelectionsDesc
+}
+
+// Collect implements prometheus.Collector
+func (l *LatencyTrackers) Collect(ch chan<- prometheus.Metric) {
+	l.mu.Lock()
+	trackers := make([]*LatencyTracker, 0, len(l.trackers))
+	for _, t := range l.trackers {
+		trackers = append(trackers, t)
+	}
+	l.mu.Unlock()
+
+	for _, t := range trackers {
+		t.mu.Lock()
+		ewma, selections := t.ewma, t.selections
+		t.mu.Unlock()
+		ch <- prometheus.MustNewConstMetric(loadBalancerLatencyDesc, prometheus.GaugeValue, ewma, t.Name)
+		ch <- prometheus.MustNewConstMetric(loadBalancerSelectionsDesc, prometheus.CounterValue, float64(selections), t.Name)
+	}
+}
+
+// NewLoadBalancedAPI returns a round-robin LoadBalancedAPI over the given equivalent
+// apis, named by their index
+func NewLoadBalancedAPI(apis []API) *LoadBalancedAPI {
+	names := make([]string, len(apis))
+	for i := range apis {
+		names[i] = strconv.Itoa(i)
+	}
+	return NewLatencyTrackers().LoadBalance(names, apis, DefaultLoadBalancerConfig)
+}
+
+// LoadBalancedAPI sends each request to a single one of the apis it wraps, which
+// are expected to be equivalent (e.g. replicas of the same prometheus). Unlike the
+// MultiAPI the results aren't merged, instead on error the request fails over to
+// the next api.
+//
+// By default requests are spread according to the mode of the config: round-robin
+// across the apis, or to the faster of two random apis (P2C). For P2C a cold api
+// (see LatencyTracker.latency) is assumed to be as fast as the average of the
+// others, so it gets observed without being flooded, and a slow api is tried again
+// once its latency went cold. An UpstreamHint on the request context changes which
+// api is tried first, with PreferConsistent the apis are always tried in the same
+// order whatever the mode.
+type LoadBalancedAPI struct {
+	apis     []API
+	trackers []*LatencyTracker
+	cfg      LoadBalancerConfig
+	now      func() time.Time
+	rand     func(n int) int
+
+	l    sync.Mutex
+	next int
+}
+
+// order returns the indexes of the apis in the order they should be tried
+func (l *LoadBalancedAPI) order(ctx context.Context) []int {
+	order := make([]int, len(l.apis))
+	for i := range order {
+		order[i] = i
+	}
+
+	switch UpstreamHintFromContext(ctx) {
+	case PreferFast:
+		// Apis without any observed latency sort first so they get observed
+		latency := l.latencies(false)
+		sort.SliceStable(order, func(i, j int) bool {
+			return latency[order[i]] < latency[order[j]]
+		})
+	case PreferFresh:
+		lastSuccess := make([]time.Time, len(l.trackers))
+		for i, t := range l.trackers {
+			t.mu.Lock()
+			lastSuccess[i] = t.lastSuccess
+			t.mu.Unlock()
+		}
+		sort.SliceStable(order, func(i, j int) bool {
+			return lastSuccess[order[i]].After(lastSuccess[order[j]])
+		})
+	case PreferConsistent:
+	default:
+		if len(order) == 0 {
+			break
+		}
+		l.l.Lock()
+		start := l.next % len(order)
+		l.next++
+		l.l.Unlock()
+		order = append(order[start:], order[:start]...)
+		if l.cfg.Mode == LoadBalanceP2C && len(order) > 1 {
+			l.powerOfTwoChoices(order)
+		}
+	}
+	return order
+}
+
+// latencies returns the latency of each api, cold apis have the average latency of
+// the others if warmCold is set (0 otherwise)
+func (l *LoadBalancedAPI) latencies(warmCold bool) []float64 {
+	now := l.now()
+	latency := make([]float64, len(l.trackers))
+	known := make([]bool, len(l.trackers))
+	var (
+		sum   float64
+		count int
+	)
+	for i, t := range l.trackers {
+		latency[i], known[i] = t.latency(now, l.cfg.EWMAHalfLife)
+		if known[i] {
+			sum += latency[i]
+			count++
+		}
+	}
+	if warmCold && count > 0 {
+		for i := range latency {
+			if !known[i] {
+				latency[i] = sum / float64(count)
+			}
+		}
+	}
+	return latency
+}
+
+// powerOfTwoChoices moves the better of two random apis of the order to the front,
+// followed by the other one. The cost of an api is its latency multiplied by its
+// requests in flight (plus this one), so the fastest api isn't sent everything.
+func (l *LoadBalancedAPI) powerOfTwoChoices(order []int) {
+	latency := l.latencies(true)
+	cost := func(i int) float64 {
+		t := l.trackers[i]
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		return latency[i] * float64(t.inFlight+1)
+	}
+
+	a := l.rand(len(order))
+	b := l.rand(len(order) - 1)
+	if b >= a {
+		b++
+	}
+	if cost(order[b]) < cost(order[a]) {
+		a, b = b, a
+	}
+	rest := make([]int, 0, len(order)-2)
+	for i, idx := range order {
+		if i != a && i != b {
+			rest = append(rest, idx)
+		}
+	}
+	order[0], order[1] = order[a], order[b]
+	copy(order[2:], rest)
+}
+
+// observe records the outcome of a request to the i-th api
+func (l *LoadBalancedAPI) observe(i int, took time.Duration, err error) {
+	t := l.trackers[i]
+	t.mu.Lock()
+	t.inFlight--
+	t.mu.Unlock()
+	if err != nil {
+		return
+	}
+	t.observe(took, l.now(), l.cfg.EWMAHalfLife)
+}
+
+// do calls fn with each api in order until one succeeds
+func (l *LoadBalancedAPI) do(ctx context.Context, fn func(API) (api.Warnings, error)) (api.Warnings, error) {
+	if len(l.apis) == 0 {
+		return nil, fmt.Errorf("no upstreams to load balance")
+	}
+
+	var (
+		w   api.Warnings
+		err error
+	)
+	for _, i := range l.order(ctx) {
+		t := l.trackers[i]
+		t.mu.Lock()
+		t.inFlight++
+		t.selections++
+		t.mu.Unlock()
+
+		start := time.Now()
+		w, err = fn(l.apis[i])
+		l.observe(i, time.Since(start), err)
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+	}
+	return w, err
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (l *LoadBalancedAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	var v []string
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.LabelNames(ctx)
+		return w, err
+	})
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (l *LoadBalancedAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	var v []string
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = LabelNamesInRange(ctx, a, startTime, endTime)
+		return w, err
+	})
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (l *LoadBalancedAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	var v model.LabelValues
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.LabelValues(ctx, label)
+		return w, err
+	})
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (l *LoadBalancedAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.Query(ctx, query, ts)
+		return w, err
+	})
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (l *LoadBalancedAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.QueryRange(ctx, query, r)
+		return w, err
+	})
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (l *LoadBalancedAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	var v []model.LabelSet
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.Series(ctx, matches, startTime, endTime)
+		return w, err
+	})
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (l *LoadBalancedAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := l.do(ctx, func(a API) (w api.Warnings, err error) {
+		v, w, err = a.GetValue(ctx, start, end, matchers)
+		return w, err
+	})
+	return v, w, err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// streaming the series from the first api which opens the stream. Once the stream
+// is open it doesn't fail over, as its series may already have been consumed.
+func (l *LoadBalancedAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	var ch <-chan StreamedSeries
+	_, err := l.do(ctx, func(a API) (_ api.Warnings, err error) {
+		ch, err = StreamGetValue(ctx, a, start, end, matchers)
+		return nil, err
+	})
+	return ch, err
+}
@@ -0,0 +1,159 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// latencyAPI responds to queries after a delay, counting the queries it received
+type latencyAPI struct {
+	API
+	delay   time.Duration
+	err     error
+	queries int
+}
+
+func (l *latencyAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	l.queries++
+	time.Sleep(l.delay)
+	if l.err != nil {
+		return nil, nil, l.err
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestLoadBalancedAPIPreferFast(t *testing.T) {
+	slow := &latencyAPI{delay: 20 * time.Millisecond}
+	fast := &latencyAPI{delay: time.Millisecond}
+	lb := NewLoadBalancedAPI([]API{slow, fast})
+
+	// Without a hint the queries are spread across the upstreams, which gives
+	// us a latency for each
+	for i := 0; i < 4; i++ {
+		if _, _, err := lb.Query(context.TODO(), "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if slow.queries != 2 || fast.queries != 2 {
+		t.Fatalf("mismatch in round-robin queries slow=%d fast=%d", slow.queries, fast.queries)
+	}
+
+	ctx := WithUpstreamHint(context.TODO(), PreferFast)
+	for i := 0; i < 10; i++ {
+		if _, _, err := lb.Query(ctx, "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if slow.queries != 2 || fast.queries != 12 {
+		t.Fatalf("PreferFast not routed to the fast upstream slow=%d fast=%d", slow.queries, fast.queries)
+	}
+}
+
+func TestLoadBalancedAPIHints(t *testing.T) {
+	a := &latencyAPI{}
+	b := &latencyAPI{}
+	lb := NewLoadBalancedAPI([]API{a, b})
+
+	// PreferConsistent always goes to the same upstream
+	ctx := WithUpstreamHint(context.TODO(), PreferConsistent)
+	for i := 0; i < 4; i++ {
+		lb.Query(ctx, "up", time.Now())
+	}
+	if a.queries != 4 || b.queries != 0 {
+		t.Fatalf("PreferConsistent not routed to a single upstream a=%d b=%d", a.queries, b.queries)
+	}
+
+	// Once a fails, queries fail over to b which is then the freshest
+	a.err = fmt.Errorf("down")
+	if _, _, err := lb.Query(ctx, "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if b.queries != 1 {
+		t.Fatalf("query didn't fail over: b=%d", b.queries)
+	}
+	a.err = nil
+	a.queries, b.queries = 0, 0
+	lb.Query(WithUpstreamHint(context.TODO(), PreferFresh), "up", time.Now())
+	if a.queries != 0 || b.queries != 1 {
+		t.Fatalf("PreferFresh not routed to the freshest upstream a=%d b=%d", a.queries, b.queries)
+	}
+
+	if hint := UpstreamHintFromContext(context.TODO()); hint != NoUpstreamHint {
+		t.Fatalf("mismatch in default hint expected=%v actual=%v", NoUpstreamHint, hint)
+	}
+}
+
+func TestLatencyTrackerHalfLife(t *testing.T) {
+	halfLife := 10 * time.Second
+	start := time.Unix(1000, 0)
+	tracker := &LatencyTracker{}
+
+	if _, ok := tracker.latency(start, halfLife); ok {
+		t.Fatalf("latency of an unobserved upstream isn't cold")
+	}
+	tracker.observe(100*time.Millisecond, start, halfLife)
+	// After one half-life the previous average counts for half
+	tracker.observe(0, start.Add(halfLife), halfLife)
+	if latency, ok := tracker.latency(start.Add(halfLife), halfLife); !ok || math.Abs(latency-0.05) > 1e-9 {
+		t.Fatalf("mismatch in latency expected=%v actual=%v (%v)", 0.05, latency, ok)
+	}
+	if _, ok := tracker.latency(start.Add(halfLife+coldHalfLives*halfLife+time.Second), halfLife); ok {
+		t.Fatalf("latency not observed for %d half-lives isn't cold", coldHalfLives)
+	}
+}
+
+func TestLoadBalancedAPIP2C(t *testing.T) {
+	slow := &latencyAPI{delay: 20 * time.Millisecond}
+	fast := &latencyAPI{delay: time.Millisecond}
+	trackers := NewLatencyTrackers()
+	cfg := LoadBalancerConfig{Mode: LoadBalanceP2C, EWMAHalfLife: time.Minute}
+	lb := trackers.LoadBalance([]string{"slow", "fast"}, []API{slow, fast}, cfg)
+	// Always pick the first two apis of the (round-robin) order, so the ties
+	// between cold apis go round-robin
+	lb.rand = func(n int) int { return 0 }
+
+	for i := 0; i < 12; i++ {
+		if _, _, err := lb.Query(context.TODO(), "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	// The first two queries observe both upstreams, from then on the fast one wins
+	if slow.queries != 1 || fast.queries != 11 {
+		t.Fatalf("P2C not routed to the fast upstream slow=%d fast=%d", slow.queries, fast.queries)
+	}
+	if selections := trackers.Get("fast").selections; selections != 11 {
+		t.Fatalf("mismatch in selections of fast expected=%d actual=%d", 11, selections)
+	}
+
+	// A newly discovered upstream is assumed to be as fast as the average of the
+	// others, so it beats the slow one but not the fast one
+	discovered := &latencyAPI{}
+	lb = trackers.LoadBalance([]string{"slow", "fast", "discovered"}, []API{slow, fast, discovered}, cfg)
+	picks := []int{0, 1}
+	lb.rand = func(n int) int {
+		pick := picks[0]
+		picks = picks[1:]
+		return pick
+	}
+	// The order is [slow fast discovered], the picks are slow and discovered
+	if _, _, err := lb.Query(context.TODO(), "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if slow.queries != 1 || discovered.queries != 1 {
+		t.Fatalf("cold upstream not tried slow=%d discovered=%d", slow.queries, discovered.queries)
+	}
+
+	// PreferConsistent still tries the upstreams in the same order
+	for i := 0; i < 4; i++ {
+		lb.Query(WithUpstreamHint(context.TODO(), PreferConsistent), "up", time.Now())
+	}
+	if slow.queries != 5 {
+		t.Fatalf("PreferConsistent not routed to a single upstream slow=%d", slow.queries)
+	}
+}
@@ -0,0 +1,229 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// MaxAgeConfig configures the MaxAgeEnforcerAPI of the hosts of a servergroup
+type MaxAgeConfig struct {
+	// MinAcceptableMaxAge is the lowest max-age of a response which isn't stale
+	MinAcceptableMaxAge time.Duration `yaml:"min_acceptable_max_age"`
+	// BackoffDuration is the wait before a stale response is requested again
+	BackoffDuration time.Duration `yaml:"backoff"`
+	// MaxRetries is how often a stale response is requested again (1 if 0)
+	MaxRetries int `yaml:"max_retries"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *MaxAgeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = MaxAgeConfig{}
+	type plain MaxAgeConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c MaxAgeConfig) Validate() error {
+	if c.MinAcceptableMaxAge < 0 || c.BackoffDuration < 0 || c.MaxRetries < 0 {
+		return fmt.Errorf("max_age min_acceptable_max_age, backoff and max_retries must not be negative")
+	}
+	return nil
+}
+
+// responseMaxAge holds the lowest Cache-Control max-age of the responses to the
+// requests made with its context
+type responseMaxAge struct {
+	mu     sync.Mutex
+	maxAge time.Duration
+	ok     bool
+}
+
+func (r *responseMaxAge) add(maxAge time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.ok || maxAge < r.maxAge {
+		r.maxAge = maxAge
+		r.ok = true
+	}
+}
+
+// get returns the max-age, false if no response had one
+func (r *responseMaxAge) get() (time.Duration, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.maxAge, r.ok
+}
+
+type responseMaxAgeKey struct{}
+
+func withResponseMaxAge(ctx context.Context) (context.Context, *responseMaxAge) {
+	r := &responseMaxAge{}
+	return context.WithValue(ctx, responseMaxAgeKey{}, r), r
+}
+
+// parseMaxAge returns the max-age of a Cache-Control header
+func parseMaxAge(cacheControl string) (time.Duration, bool) {
+	for _, directive := range strings.Split(cacheControl, ",") {
+		directive = strings.TrimSpace(directive)
+		if !strings.HasPrefix(strings.ToLower(directive), "max-age=") {
+			continue
+		}
+		seconds, err := strconv.ParseInt(strings.Trim(directive[len("max-age="):], `"`), 10, 64)
+		if err != nil || seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	return 0, false
+}
+
+// CacheControlRoundTripper captures the Cache-Control max-age of the responses to
+// the calls of a MaxAgeEnforcerAPI
+type CacheControlRoundTripper struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (c *CacheControlRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := c.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if r, ok := req.Context().Value(responseMaxAgeKey{}).(*responseMaxAge); ok {
+		if maxAge, ok := parseMaxAge(resp.Header.Get("Cache-Control")); ok {
+			r.add(maxAge)
+		}
+	}
+	return resp, nil
+}
+
+// MaxAgeEnforcerAPI requests responses again (after the BackoffDuration) whose
+// Cache-Control max-age is below the MinAcceptableMaxAge, as served by CDNs which
+// cache at the HTTP layer. The max-age is captured by the CacheControlRoundTripper,
+// so that has to be part of the transport of the wrapped API. Once the retries are
+// used up the stale response is returned with a warning.
+type MaxAgeEnforcerAPI struct {
+	API
+	MinAcceptableMaxAge time.Duration
+	BackoffDuration     time.Duration
+	MaxRetries          int
+}
+
+// do calls fn until its response isn't stale, or there are no retries left
+func (m *MaxAgeEnforcerAPI) do(ctx context.Context, fn func(context.Context) (api.Warnings, error)) (api.Warnings, error) {
+	maxRetries := m.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = 1
+	}
+	for attempt := 0; ; attempt++ {
+		callCtx, r := withResponseMaxAge(ctx)
+		w, err := fn(callCtx)
+		maxAge, ok := r.get()
+		if err != nil || !ok || maxAge >= m.MinAcceptableMaxAge {
+			return w, err
+		}
+
+		stale := false
+		if attempt >= maxRetries {
+			stale = true
+		} else {
+			select {
+			case <-ctx.Done():
+				stale = true
+			case <-time.After(m.BackoffDuration):
+			}
+		}
+		if stale {
+			return append(w, fmt.Sprintf("stale response: max-age of %s is below the minimum of %s", maxAge, m.MinAcceptableMaxAge)), nil
+		}
+	}
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (m *MaxAgeEnforcerAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	var v []string
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.LabelNames(ctx)
+		return w, err
+	})
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (m *MaxAgeEnforcerAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	var v []string
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = LabelNamesInRange(ctx, m.API, startTime, endTime)
+		return w, err
+	})
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (m *MaxAgeEnforcerAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	var v model.LabelValues
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.LabelValues(ctx, label)
+		return w, err
+	})
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (m *MaxAgeEnforcerAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.Query(ctx, query, ts)
+		return w, err
+	})
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (m *MaxAgeEnforcerAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.QueryRange(ctx, query, r)
+		return w, err
+	})
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (m *MaxAgeEnforcerAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	var v []model.LabelSet
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.Series(ctx, matches, startTime, endTime)
+		return w, err
+	})
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset. As the
+// labelsets are passed on while they are read this isn't requested again.
+func (m *MaxAgeEnforcerAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, m.API, matches, startTime, endTime, fn)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (m *MaxAgeEnforcerAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := m.do(ctx, func(ctx context.Context) (w api.Warnings, err error) {
+		v, w, err = m.API.GetValue(ctx, start, end, matchers)
+		return w, err
+	})
+	return v, w, err
+}
@@ -0,0 +1,85 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestParseMaxAge(t *testing.T) {
+	tests := []struct {
+		header string
+		maxAge time.Duration
+		ok     bool
+	}{
+		{header: "max-age=5", maxAge: 5 * time.Second, ok: true},
+		{header: "public, max-age=60, must-revalidate", maxAge: time.Minute, ok: true},
+		{header: "no-cache"},
+		{header: "max-age=soon"},
+		{header: ""},
+	}
+
+	for _, test := range tests {
+		maxAge, ok := parseMaxAge(test.header)
+		if ok != test.ok || maxAge != test.maxAge {
+			t.Fatalf("mismatch in max-age of %q expected=%v,%v actual=%v,%v", test.header, test.maxAge, test.ok, maxAge, ok)
+		}
+	}
+}
+
+func TestMaxAgeEnforcerAPI(t *testing.T) {
+	tests := []struct {
+		maxAge int
+		calls  int32
+		stale  bool
+	}{
+		// A response which is cached for less than the minimum is requested again
+		{maxAge: 5, calls: 3, stale: true},
+		{maxAge: 120, calls: 1},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("max-age=%d", test.maxAge), func(t *testing.T) {
+			var calls int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", test.maxAge))
+				fmt.Fprint(w, `{"status":"success","data":{"resultType":"vector","result":[]}}`)
+			}))
+			defer srv.Close()
+
+			client, err := api.NewClient(api.Config{
+				Address:      srv.URL,
+				RoundTripper: &CacheControlRoundTripper{RoundTripper: http.DefaultTransport},
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			a := &MaxAgeEnforcerAPI{
+				API:                 &PromAPIV1{v1.NewAPI(client)},
+				MinAcceptableMaxAge: time.Minute,
+				BackoffDuration:     time.Millisecond,
+				MaxRetries:          2,
+			}
+
+			_, w, err := a.Query(context.TODO(), "up", time.Now())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if c := atomic.LoadInt32(&calls); c != test.calls {
+				t.Fatalf("mismatch in calls expected=%d actual=%d", test.calls, c)
+			}
+			if stale := len(w) > 0; stale != test.stale {
+				t.Fatalf("mismatch in stale expected=%v actual=%v (%v)", test.stale, stale, w)
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// MetadataConfig configures the MetadataCache of a servergroup
+type MetadataConfig struct {
+	// RefreshInterval is how long the metric types are cached for
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// DefaultMetadataConfig is the MetadataConfig used for unset fields
+var DefaultMetadataConfig = MetadataConfig{
+	RefreshInterval: 10 * time.Minute,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *MetadataConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultMetadataConfig
+	type plain MetadataConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c MetadataConfig) Validate() error {
+	if c.RefreshInterval <= 0 {
+		return fmt.Errorf("metric_metadata refresh_interval must be positive")
+	}
+	return nil
+}
+
+// NewMetadataCache returns an empty MetadataCache
+func NewMetadataCache(client *http.Client, cfg MetadataConfig) *MetadataCache {
+	return &MetadataCache{client: client, cfg: cfg, now: time.Now}
+}
+
+// MetadataCache caches the types of the metrics of a servergroup, as reported by
+// the metadata API of its hosts. Lookups never wait for the hosts: once the cache
+// is stale it is refreshed in the background while the lookups are answered from
+// the cached types, so queries don't pay for a round-trip.
+type MetadataCache struct {
+	client *http.Client
+	cfg    MetadataConfig
+	now    func() time.Time
+
+	mu         sync.RWMutex
+	urls       []url.URL
+	types      map[string]promutil.MetricType
+	refreshed  time.Time
+	refreshing int32
+}
+
+// SetURLs sets the hosts whose metadata API is queried
+func (c *MetadataCache) SetURLs(urls []*url.URL) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.urls = make([]url.URL, len(urls))
+	for i, u := range urls {
+		c.urls[i] = *u
+	}
+}
+
+// Type returns the type of the named metric, MetricTypeUnknown if it isn't known
+// (yet). It implements promutil.MetricTypeFunc.
+func (c *MetadataCache) Type(name string) promutil.MetricType {
+	c.mu.RLock()
+	typ, ok := c.types[name]
+	stale := c.now().Sub(c.refreshed) >= c.cfg.RefreshInterval
+	c.mu.RUnlock()
+
+	if stale && atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		go func() {
+			defer atomic.StoreInt32(&c.refreshing, 0)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+			if err := c.Refresh(ctx); err != nil {
+				logger.Debugf("Unable to refresh the metric metadata: %v", err)
+			}
+		}()
+	}
+
+	if !ok {
+		return promutil.MetricTypeUnknown
+	}
+	return typ
+}
+
+// metricMetadata is an entry of the metadata API
+type metricMetadata struct {
+	Type string `json:"type"`
+}
+
+// metadataResponse is the response of the metadata API
+type metadataResponse struct {
+	Status string                      `json:"status"`
+	Data   map[string][]metricMetadata `json:"data"`
+	Error  string                      `json:"error"`
+}
+
+// Refresh fetches the metric types from the hosts. The types are merged across the
+// hosts, metrics whose type differs between them are unknown.
+func (c *MetadataCache) Refresh(ctx context.Context) error {
+	c.mu.RLock()
+	urls := c.urls
+	c.mu.RUnlock()
+
+	types := make(map[string]promutil.MetricType)
+	var lastErr error
+	fetched := false
+	for _, u := range urls {
+		metadata, err := c.fetch(ctx, u)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		fetched = true
+		for name, entries := range metadata {
+			for _, entry := range entries {
+				typ := promutil.MetricType(entry.Type)
+				if existing, ok := types[name]; ok && existing != typ {
+					typ = promutil.MetricTypeUnknown
+				}
+				types[name] = typ
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The hosts aren't asked again until the next refresh, the types fetched
+	// before are kept meanwhile
+	c.refreshed = c.now()
+	if !fetched && lastErr != nil {
+		return lastErr
+	}
+	c.types = types
+	return nil
+}
+
+// fetch returns the metadata of the host at u
+func (c *MetadataCache) fetch(ctx context.Context, u url.URL) (map[string][]metricMetadata, error) {
+	u.Path = path.Join(u.Path, "api/v1/metadata")
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var m metadataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, fmt.Errorf("error decoding metadata of %s (status %d): %v", u.Host, resp.StatusCode, err)
+	}
+	if m.Status != "success" {
+		return nil, fmt.Errorf("error fetching metadata of %s: %s", u.Host, m.Error)
+	}
+	return m.Data, nil
+}
@@ -0,0 +1,53 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+func TestMetadataCache(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path != "/api/v1/metadata" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, `{"status":"success","data":{"requests_total":[{"type":"counter","help":"","unit":""}],"temperature":[{"type":"gauge","help":"","unit":""}]}}`)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	now := time.Unix(0, 0)
+	cache := NewMetadataCache(http.DefaultClient, MetadataConfig{RefreshInterval: time.Minute})
+	cache.now = func() time.Time { return now }
+	cache.SetURLs([]*url.URL{u})
+	if err := cache.Refresh(context.TODO()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := map[string]promutil.MetricType{
+		"requests_total": promutil.MetricTypeCounter,
+		"temperature":    promutil.MetricTypeGauge,
+		"unknown":        promutil.MetricTypeUnknown,
+	}
+	// The lookups are answered from the cache
+	for i := 0; i < 10; i++ {
+		for name, expected := range tests {
+			if typ := cache.Type(name); typ != expected {
+				t.Fatalf("mismatch in type of %s expected=%s actual=%s", name, expected, typ)
+			}
+		}
+	}
+	if c := atomic.LoadInt32(&calls); c != 1 {
+		t.Fatalf("mismatch in metadata calls expected=%d actual=%d", 1, c)
+	}
+}
@@ -0,0 +1,475 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The policies which resolve the samples of a series at the same timestamp under
+// more than one name of a metric
+const (
+	// MetricAliasPreferCanonical keeps the sample of the canonical name
+	MetricAliasPreferCanonical = "prefer_canonical"
+	// MetricAliasPreferAlias keeps the sample of an alias, i.e. of the old name
+	MetricAliasPreferAlias = "prefer_alias"
+	// MetricAliasMax keeps the largest of the samples
+	MetricAliasMax = "max"
+)
+
+// MetricAliasConfig configures the MetricAliasAPI
+type MetricAliasConfig struct {
+	// Aliases maps the canonical name of a metric to the names it was known as
+	// (e.g. before it was renamed)
+	Aliases map[string][]string `yaml:"aliases"`
+	// ConflictPolicy resolves the samples of a series at the same timestamp under
+	// more than one of the names (MetricAliasPreferCanonical by default)
+	ConflictPolicy string `yaml:"conflict_policy"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *MetricAliasConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = MetricAliasConfig{ConflictPolicy: MetricAliasPreferCanonical}
+	type plain MetricAliasConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c MetricAliasConfig) Validate() error {
+	switch c.ConflictPolicy {
+	case MetricAliasPreferCanonical, MetricAliasPreferAlias, MetricAliasMax:
+	default:
+		return fmt.Errorf("unknown metric alias conflict_policy %q", c.ConflictPolicy)
+	}
+
+	names := make(map[string]string)
+	for canonical, aliases := range c.Aliases {
+		for _, name := range append([]string{canonical}, aliases...) {
+			if !model.IsValidMetricName(model.LabelValue(name)) {
+				return fmt.Errorf("invalid metric name %q in metric aliases", name)
+			}
+			if other, ok := names[name]; ok {
+				return fmt.Errorf("metric name %q is an alias of both %q and %q", name, other, canonical)
+			}
+			names[name] = canonical
+		}
+	}
+	return nil
+}
+
+// NewMetricAliasAPI returns a MetricAliasAPI for the aliases of the config
+func NewMetricAliasAPI(a API, cfg MetricAliasConfig) *MetricAliasAPI {
+	m := &MetricAliasAPI{
+		API:       a,
+		policy:    cfg.ConflictPolicy,
+		canonical: make(map[string]string),
+		names:     make(map[string][]string, len(cfg.Aliases)),
+	}
+	for canonical, aliases := range cfg.Aliases {
+		names := append([]string{canonical}, aliases...)
+		m.names[canonical] = names
+		for _, name := range names {
+			m.canonical[name] = canonical
+		}
+	}
+	return m
+}
+
+// MetricAliasAPI presents metrics which are known under more than one name (e.g.
+// as they were renamed, and not all downstreams have caught up) under their
+// canonical name. Selectors of any of the names are expanded to select all of
+// them downstream, and the series in the results are renamed to the canonical
+// name, merging the series which only differed by the name. The samples of a
+// series at the same timestamp under more than one name are resolved by the
+// conflict policy, with a warning.
+//
+// Only the equality and regex matchers of the metric name are expanded, negative
+// matchers select what they did. Queries evaluated downstream can't tell which
+// name the samples came from, so the expansion may (e.g. within a sum) count the
+// data of a downstream which has both names twice.
+type MetricAliasAPI struct {
+	API
+
+	policy string
+	// canonical maps each name to the canonical name of its metric
+	canonical map[string]string
+	// names are all the names of each canonical name, the canonical one first
+	names map[string][]string
+}
+
+// expandMatchers returns the matchers selecting all the names of the metrics
+// the name matchers select, and whether any matcher was expanded
+func (m *MetricAliasAPI) expandMatchers(matchers []*labels.Matcher) ([]*labels.Matcher, bool, error) {
+	expanded := make([]*labels.Matcher, len(matchers))
+	changed := false
+	for i, matcher := range matchers {
+		expanded[i] = matcher
+		if matcher.Name != model.MetricNameLabel {
+			continue
+		}
+
+		var names []string
+		switch matcher.Type {
+		case labels.MatchEqual:
+			canonical, ok := m.canonical[matcher.Value]
+			if !ok {
+				continue
+			}
+			names = m.names[canonical]
+		case labels.MatchRegexp:
+			names = []string{"(?:" + matcher.Value + ")"}
+			for _, canonical := range m.sortedCanonical() {
+				for _, name := range m.names[canonical] {
+					if matcher.Matches(name) {
+						names = append(names, m.names[canonical]...)
+						break
+					}
+				}
+			}
+			if len(names) == 1 {
+				continue
+			}
+		default:
+			continue
+		}
+
+		quoted := make([]string, len(names))
+		for j, name := range names {
+			quoted[j] = name
+			if matcher.Type == labels.MatchEqual || j > 0 {
+				quoted[j] = regexp.QuoteMeta(name)
+			}
+		}
+		expandedMatcher, err := labels.NewMatcher(labels.MatchRegexp, model.MetricNameLabel, strings.Join(quoted, "|"))
+		if err != nil {
+			return nil, false, err
+		}
+		expanded[i] = expandedMatcher
+		changed = true
+	}
+	return expanded, changed, nil
+}
+
+// sortedCanonical returns the canonical names in order, so expanded regexes are
+// the same for every call
+func (m *MetricAliasAPI) sortedCanonical() []string {
+	canonical := make([]string, 0, len(m.names))
+	for name := range m.names {
+		canonical = append(canonical, name)
+	}
+	sort.Strings(canonical)
+	return canonical
+}
+
+// metricAliasVisitor expands the name matchers of all selectors
+type metricAliasVisitor struct {
+	m *MetricAliasAPI
+}
+
+// Visit expands the name matchers of the selector nodes. Expanded selectors lose
+// their name, as it is printed as the equality matcher it no longer is.
+func (v *metricAliasVisitor) Visit(node promql.Node, path []promql.Node) (promql.Visitor, error) {
+	switch nodeTyped := node.(type) {
+	case *promql.VectorSelector:
+		matchers, changed, err := v.m.expandMatchers(nodeTyped.LabelMatchers)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			nodeTyped.Name = ""
+			nodeTyped.LabelMatchers = matchers
+		}
+	case *promql.MatrixSelector:
+		matchers, changed, err := v.m.expandMatchers(nodeTyped.LabelMatchers)
+		if err != nil {
+			return nil, err
+		}
+		if changed {
+			nodeTyped.Name = ""
+			nodeTyped.LabelMatchers = matchers
+		}
+	}
+	return v, nil
+}
+
+// expandQuery expands the selectors of the query to all the names of their metrics
+func (m *MetricAliasAPI) expandQuery(ctx context.Context, query string) (string, error) {
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+	if _, err := promql.Walk(ctx, &metricAliasVisitor{m: m}, &promql.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// expandMatches expands the Series matches to all the names of their metrics
+func (m *MetricAliasAPI) expandMatches(ctx context.Context, matches []string) ([]string, error) {
+	expanded := make([]string, len(matches))
+	for i, match := range matches {
+		var err error
+		if expanded[i], err = m.expandQuery(ctx, match); err != nil {
+			return nil, err
+		}
+	}
+	return expanded, nil
+}
+
+// canonicalMetric returns the metric with its canonical name, and its original name
+func (m *MetricAliasAPI) canonicalMetric(metric model.Metric) (model.Metric, model.LabelValue) {
+	name := metric[model.MetricNameLabel]
+	canonical, ok := m.canonical[string(name)]
+	if !ok || canonical == string(name) {
+		return metric, name
+	}
+	renamed := metric.Clone()
+	renamed[model.MetricNameLabel] = model.LabelValue(canonical)
+	return renamed, name
+}
+
+// aliasSample is a sample with the name of the series it came from
+type aliasSample struct {
+	model.SamplePair
+	name model.LabelValue
+}
+
+// prefer returns whether the sample a is kept over b, the other sample of the
+// series at the same timestamp
+func (m *MetricAliasAPI) prefer(canonical model.LabelValue, a, b aliasSample) bool {
+	switch m.policy {
+	case MetricAliasPreferAlias:
+		return a.name != canonical && b.name == canonical
+	case MetricAliasMax:
+		return a.Value > b.Value
+	default:
+		return a.name == canonical && b.name != canonical
+	}
+}
+
+// resolve returns the samples with a single sample per timestamp, resolving the
+// conflicts with the policy, and whether there were any
+func (m *MetricAliasAPI) resolve(canonical model.LabelValue, samples []aliasSample) ([]model.SamplePair, bool) {
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].Timestamp < samples[j].Timestamp
+	})
+	resolved := make([]model.SamplePair, 0, len(samples))
+	overlapped := false
+	for i := 0; i < len(samples); {
+		kept := samples[i]
+		j := i + 1
+		for ; j < len(samples) && samples[j].Timestamp == kept.Timestamp; j++ {
+			overlapped = overlapped || samples[j].name != kept.name
+			if m.prefer(canonical, samples[j], kept) {
+				kept = samples[j]
+			}
+		}
+		resolved = append(resolved, kept.SamplePair)
+		i = j
+	}
+	return resolved, overlapped
+}
+
+// canonicalValue renames the series of the value to their canonical names, merging
+// the series which then have the same labels. It returns the warnings for the
+// metrics whose samples overlapped under more than one name.
+func (m *MetricAliasAPI) canonicalValue(v model.Value) (model.Value, api.Warnings) {
+	overlapped := make(map[model.LabelValue]struct{})
+
+	switch valueTyped := v.(type) {
+	case model.Matrix:
+		var (
+			merged  model.Matrix
+			samples [][]aliasSample
+		)
+		index := make(map[model.Fingerprint]int, len(valueTyped))
+		for _, stream := range valueTyped {
+			metric, name := m.canonicalMetric(stream.Metric)
+			fp := metric.Fingerprint()
+			i, ok := index[fp]
+			if !ok {
+				i = len(merged)
+				index[fp] = i
+				merged = append(merged, &model.SampleStream{Metric: metric})
+				samples = append(samples, nil)
+			}
+			for _, pair := range stream.Values {
+				samples[i] = append(samples[i], aliasSample{SamplePair: pair, name: name})
+			}
+		}
+		for i, stream := range merged {
+			canonical := stream.Metric[model.MetricNameLabel]
+			var conflict bool
+			stream.Values, conflict = m.resolve(canonical, samples[i])
+			if conflict {
+				overlapped[canonical] = struct{}{}
+			}
+		}
+		v = merged
+
+	case model.Vector:
+		var (
+			merged model.Vector
+			names  []model.LabelValue
+		)
+		index := make(map[model.Fingerprint]int, len(valueTyped))
+		for _, sample := range valueTyped {
+			metric, name := m.canonicalMetric(sample.Metric)
+			fp := metric.Fingerprint()
+			i, ok := index[fp]
+			if !ok {
+				index[fp] = len(merged)
+				merged = append(merged, &model.Sample{Metric: metric, Value: sample.Value, Timestamp: sample.Timestamp})
+				names = append(names, name)
+				continue
+			}
+
+			canonical := metric[model.MetricNameLabel]
+			candidate := aliasSample{SamplePair: model.SamplePair{Timestamp: sample.Timestamp, Value: sample.Value}, name: name}
+			kept := aliasSample{SamplePair: model.SamplePair{Timestamp: merged[i].Timestamp, Value: merged[i].Value}, name: names[i]}
+			if candidate.name != kept.name {
+				overlapped[canonical] = struct{}{}
+			}
+			if m.prefer(canonical, candidate, kept) {
+				merged[i].Value, merged[i].Timestamp, names[i] = sample.Value, sample.Timestamp, name
+			}
+		}
+		v = merged
+	}
+
+	var warnings api.Warnings
+	for canonical := range overlapped {
+		warnings = append(warnings, fmt.Sprintf("samples of %s overlapped under the names %s, resolved by %s",
+			canonical, strings.Join(m.names[string(canonical)], ", "), m.policy))
+	}
+	sort.Strings(warnings)
+	return v, warnings
+}
+
+// canonicalLabelSet returns the labelset with its canonical metric name
+func (m *MetricAliasAPI) canonicalLabelSet(ls model.LabelSet) model.LabelSet {
+	metric, _ := m.canonicalMetric(model.Metric(ls))
+	return model.LabelSet(metric)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (m *MetricAliasAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := m.API.LabelValues(ctx, label)
+	if err != nil || label != model.MetricNameLabel {
+		return v, w, err
+	}
+
+	seen := make(map[model.LabelValue]struct{}, len(v))
+	canonical := make(model.LabelValues, 0, len(v))
+	for _, name := range v {
+		if c, ok := m.canonical[string(name)]; ok {
+			name = model.LabelValue(c)
+		}
+		if _, ok := seen[name]; !ok {
+			seen[name] = struct{}{}
+			canonical = append(canonical, name)
+		}
+	}
+	sort.Sort(canonical)
+	return canonical, w, nil
+}
+
+// Query performs a query for the given time.
+func (m *MetricAliasAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	expandedQuery, err := m.expandQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := m.API.Query(ctx, expandedQuery, ts)
+	if err != nil {
+		return nil, w, err
+	}
+	v, aliasWarnings := m.canonicalValue(v)
+	return v, append(w, aliasWarnings...), nil
+}
+
+// QueryRange performs a query for the given range.
+func (m *MetricAliasAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	expandedQuery, err := m.expandQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := m.API.QueryRange(ctx, expandedQuery, r)
+	if err != nil {
+		return nil, w, err
+	}
+	v, aliasWarnings := m.canonicalValue(v)
+	return v, append(w, aliasWarnings...), nil
+}
+
+// Series finds series by label matchers.
+func (m *MetricAliasAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	expandedMatches, err := m.expandMatches(ctx, matches)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := m.API.Series(ctx, expandedMatches, startTime, endTime)
+	if err != nil {
+		return nil, w, err
+	}
+
+	seen := make(map[model.Fingerprint]struct{}, len(v))
+	canonical := v[:0]
+	for _, ls := range v {
+		ls = m.canonicalLabelSet(ls)
+		if _, ok := seen[ls.Fingerprint()]; !ok {
+			seen[ls.Fingerprint()] = struct{}{}
+			canonical = append(canonical, ls)
+		}
+	}
+	return canonical, w, nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (m *MetricAliasAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	expandedMatches, err := m.expandMatches(ctx, matches)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[model.Fingerprint]struct{})
+	return StreamSeries(ctx, m.API, expandedMatches, startTime, endTime, func(ls model.LabelSet) error {
+		ls = m.canonicalLabelSet(ls)
+		if _, ok := seen[ls.Fingerprint()]; ok {
+			return nil
+		}
+		seen[ls.Fingerprint()] = struct{}{}
+		return fn(ls)
+	})
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (m *MetricAliasAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, m.API, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (m *MetricAliasAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	expandedMatchers, _, err := m.expandMatchers(matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := m.API.GetValue(ctx, start, end, expandedMatchers)
+	if err != nil {
+		return nil, w, err
+	}
+	v, aliasWarnings := m.canonicalValue(v)
+	return v, append(w, aliasWarnings...), nil
+}
@@ -0,0 +1,187 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// aliasRecordingAPI records what was sent downstream, returning the value
+type aliasRecordingAPI struct {
+	API
+	v        model.Value
+	query    string
+	matchers []*labels.Matcher
+}
+
+func (a *aliasRecordingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	a.query = query
+	return a.v, nil, nil
+}
+
+func (a *aliasRecordingAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	a.query = query
+	return a.v, nil, nil
+}
+
+func (a *aliasRecordingAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	a.matchers = matchers
+	return a.v, nil, nil
+}
+
+var testAliasConfig = MetricAliasConfig{
+	Aliases: map[string][]string{
+		"http_requests_total": {"http_request_count"},
+	},
+	ConflictPolicy: MetricAliasPreferCanonical,
+}
+
+func TestMetricAliasExpandQuery(t *testing.T) {
+	tests := []struct {
+		query    string
+		expanded string
+	}{
+		{
+			query:    `http_requests_total`,
+			expanded: `{__name__=~"http_requests_total|http_request_count"}`,
+		},
+		{
+			query:    `rate(http_request_count{job="api"}[5m])`,
+			expanded: `rate({job="api",__name__=~"http_requests_total|http_request_count"}[5m])`,
+		},
+		{
+			query:    `{__name__=~"http_.*_count"}`,
+			expanded: `{__name__=~"(?:http_.*_count)|http_requests_total|http_request_count"}`,
+		},
+		// Unaliased and negative matchers are left alone
+		{
+			query:    `up`,
+			expanded: `up`,
+		},
+		{
+			query:    `{__name__!="http_request_count"}`,
+			expanded: `{__name__!="http_request_count"}`,
+		},
+	}
+
+	m := NewMetricAliasAPI(nil, testAliasConfig)
+	for _, test := range tests {
+		expanded, err := m.expandQuery(context.TODO(), test.query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if expanded != test.expanded {
+			t.Fatalf("mismatch in expanded query of %s expected=%v actual=%v", test.query, test.expanded, expanded)
+		}
+	}
+}
+
+func TestMetricAliasGetValue(t *testing.T) {
+	stream := func(name string, samples ...model.SamplePair) *model.SampleStream {
+		return &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: model.LabelValue(name), "job": "api"}, Values: samples}
+	}
+	pair := func(ts int64, v float64) model.SamplePair {
+		return model.SamplePair{Timestamp: model.TimeFromUnix(ts), Value: model.SampleValue(v)}
+	}
+	downstream := model.Matrix{
+		stream("http_request_count", pair(100, 1), pair(200, 2)),
+		stream("http_requests_total", pair(200, 3), pair(300, 4)),
+	}
+
+	tests := []struct {
+		policy   string
+		expected []model.SamplePair
+	}{
+		{policy: MetricAliasPreferCanonical, expected: []model.SamplePair{pair(100, 1), pair(200, 3), pair(300, 4)}},
+		{policy: MetricAliasPreferAlias, expected: []model.SamplePair{pair(100, 1), pair(200, 2), pair(300, 4)}},
+		{policy: MetricAliasMax, expected: []model.SamplePair{pair(100, 1), pair(200, 3), pair(300, 4)}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			matrix := make(model.Matrix, len(downstream))
+			for i, s := range downstream {
+				matrix[i] = &model.SampleStream{Metric: s.Metric, Values: append([]model.SamplePair(nil), s.Values...)}
+			}
+			stub := &aliasRecordingAPI{v: matrix}
+			cfg := testAliasConfig
+			cfg.ConflictPolicy = test.policy
+			m := NewMetricAliasAPI(stub, cfg)
+
+			matchers, err := promql.ParseMetricSelector(`http_request_count{job="api"}`)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			v, w, err := m.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(300, 0), matchers)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for _, matcher := range stub.matchers {
+				if matcher.Name != model.MetricNameLabel {
+					continue
+				}
+				if matcher.Type != labels.MatchRegexp || !matcher.Matches("http_requests_total") || !matcher.Matches("http_request_count") {
+					t.Fatalf("mismatch in downstream name matcher expected both names actual=%v", matcher)
+				}
+			}
+
+			result := v.(model.Matrix)
+			if len(result) != 1 {
+				t.Fatalf("mismatch in number of series expected=%d actual=%d", 1, len(result))
+			}
+			if name := result[0].Metric[model.MetricNameLabel]; name != "http_requests_total" {
+				t.Fatalf("mismatch in metric name expected=%v actual=%v", "http_requests_total", name)
+			}
+			if !reflect.DeepEqual(result[0].Values, test.expected) {
+				t.Fatalf("mismatch in samples expected=%v actual=%v", test.expected, result[0].Values)
+			}
+			if len(w) != 1 {
+				t.Fatalf("mismatch in warnings expected=%d actual=%v", 1, w)
+			}
+		})
+	}
+
+	// Without overlapping samples the series are merged without a warning
+	stub := &aliasRecordingAPI{v: model.Vector{
+		{Metric: model.Metric{model.MetricNameLabel: "http_request_count", "job": "a"}, Value: 1},
+		{Metric: model.Metric{model.MetricNameLabel: "http_requests_total", "job": "b"}, Value: 2},
+	}}
+	v, w, err := NewMetricAliasAPI(stub, testAliasConfig).Query(context.TODO(), `http_requests_total`, time.Unix(300, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w) != 0 {
+		t.Fatalf("mismatch in warnings expected=none actual=%v", w)
+	}
+	for _, sample := range v.(model.Vector) {
+		if name := sample.Metric[model.MetricNameLabel]; name != "http_requests_total" {
+			t.Fatalf("mismatch in metric name expected=%v actual=%v", "http_requests_total", name)
+		}
+	}
+}
+
+func TestMetricAliasConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg MetricAliasConfig
+		ok  bool
+	}{
+		{cfg: testAliasConfig, ok: true},
+		{cfg: MetricAliasConfig{ConflictPolicy: "newest"}},
+		{cfg: MetricAliasConfig{Aliases: map[string][]string{"a": {"b"}, "c": {"b"}}, ConflictPolicy: MetricAliasMax}},
+		{cfg: MetricAliasConfig{Aliases: map[string][]string{"a": {"not a metric"}}, ConflictPolicy: MetricAliasMax}},
+	}
+
+	for i, test := range tests {
+		if err := test.cfg.Validate(); (err == nil) != test.ok {
+			t.Fatalf("mismatch in validation of config %d expected=%v actual=%v", i, test.ok, err)
+		}
+	}
+}
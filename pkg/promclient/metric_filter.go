@@ -0,0 +1,355 @@
+package promclient
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// MetricFilterAPI restricts the metrics which are exposed from the wrapped API
+// based on an allowlist and denylist of metric name regexes. This is enforced in
+// both directions:
+//   - every selector sent downstream is constrained to the allowed metric names,
+//     so denied metrics can't be used even within aggregations evaluated downstream.
+//     If a selector's metric name is denied outright the downstream is skipped.
+//   - series in the results whose metric name is denied are dropped (and counted),
+//     as are denied values of LabelValues(__name__)
+type MetricFilterAPI struct {
+	API
+
+	allow, deny []*regexp.Regexp
+	// matchers constrain selectors to the allowed metric names
+	matchers []*labels.Matcher
+
+	// DroppedFunc (if set) is called with the number of series that were dropped
+	DroppedFunc func(count int)
+}
+
+// NewMetricFilterAPI returns a MetricFilterAPI allowing only the metric names that
+// match an allow regex (if there are any) and don't match a deny regex. Like
+// prometheus' regex matchers the regexes are fully anchored
+func NewMetricFilterAPI(a API, allow, deny []string, droppedFunc func(int)) (*MetricFilterAPI, error) {
+	m := &MetricFilterAPI{API: a, DroppedFunc: droppedFunc}
+
+	compile := func(patterns []string, matchType labels.MatchType) ([]*regexp.Regexp, error) {
+		if len(patterns) == 0 {
+			return nil, nil
+		}
+		regexes := make([]*regexp.Regexp, len(patterns))
+		groups := make([]string, len(patterns))
+		for i, pattern := range patterns {
+			re, err := regexp.Compile("^(?:" + pattern + ")$")
+			if err != nil {
+				return nil, err
+			}
+			regexes[i] = re
+			groups[i] = "(?:" + pattern + ")"
+		}
+
+		matcher, err := labels.NewMatcher(matchType, model.MetricNameLabel, strings.Join(groups, "|"))
+		if err != nil {
+			return nil, err
+		}
+		m.matchers = append(m.matchers, matcher)
+		return regexes, nil
+	}
+
+	var err error
+	if m.allow, err = compile(allow, labels.MatchRegexp); err != nil {
+		return nil, err
+	}
+	if m.deny, err = compile(deny, labels.MatchNotRegexp); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Allowed returns whether the metric name is allowed
+func (m *MetricFilterAPI) Allowed(name string) bool {
+	for _, re := range m.deny {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+	if len(m.allow) == 0 {
+		return true
+	}
+	for _, re := range m.allow {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedLabelSet returns whether the labelset isn't a denied metric. Series without
+// a metric name (e.g. the result of an aggregation) are allowed, as the selectors
+// that produced them were constrained to the allowed metrics
+func (m *MetricFilterAPI) allowedLabelSet(ls model.LabelSet) bool {
+	name, ok := ls[model.MetricNameLabel]
+	return !ok || m.Allowed(string(name))
+}
+
+// filterMatchers adds the metric name constraints to the matchers, returning false
+// if the matchers select a metric name that is denied
+func (m *MetricFilterAPI) filterMatchers(matchers []*labels.Matcher) ([]*labels.Matcher, bool) {
+	for _, matcher := range matchers {
+		if matcher.Name == model.MetricNameLabel && matcher.Type == labels.MatchEqual && !m.Allowed(matcher.Value) {
+			return nil, false
+		}
+	}
+
+	filtered := make([]*labels.Matcher, 0, len(matchers)+len(m.matchers))
+	filtered = append(filtered, matchers...)
+	return append(filtered, m.matchers...), true
+}
+
+// metricFilterVisitor adds the metric name constraints to all selectors
+type metricFilterVisitor struct {
+	m *MetricFilterAPI
+	// selectors is the number of selectors in the query
+	selectors int
+	// allowed is whether any selector may select allowed metrics
+	allowed bool
+}
+
+// Visit adds the metric name constraints to the selector nodes
+func (v *metricFilterVisitor) Visit(node promql.Node, path []promql.Node) (promql.Visitor, error) {
+	switch nodeTyped := node.(type) {
+	case *promql.VectorSelector:
+		matchers, ok := v.m.filterMatchers(nodeTyped.LabelMatchers)
+		// Denied selectors still get the constraints, so they select nothing
+		if !ok {
+			matchers = append(nodeTyped.LabelMatchers, v.m.matchers...)
+		}
+		v.selectors++
+		v.allowed = v.allowed || ok
+		nodeTyped.LabelMatchers = matchers
+	case *promql.MatrixSelector:
+		matchers, ok := v.m.filterMatchers(nodeTyped.LabelMatchers)
+		if !ok {
+			matchers = append(nodeTyped.LabelMatchers, v.m.matchers...)
+		}
+		v.selectors++
+		v.allowed = v.allowed || ok
+		nodeTyped.LabelMatchers = matchers
+	}
+	return v, nil
+}
+
+// filterQuery constrains all selectors in the query to the allowed metrics, returning
+// false if there is no selector which may select allowed metrics
+func (m *MetricFilterAPI) filterQuery(ctx context.Context, query string) (string, bool, error) {
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return "", false, err
+	}
+
+	visitor := &metricFilterVisitor{m: m}
+	if _, err := promql.Walk(ctx, visitor, &promql.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+		return "", false, err
+	}
+
+	// Queries without any selectors (e.g. `time()`) have nothing to filter
+	if !visitor.allowed && visitor.selectors > 0 {
+		return "", false, nil
+	}
+	return e.String(), true, nil
+}
+
+// filterMatches constrains the Series matches to the allowed metrics
+func (m *MetricFilterAPI) filterMatches(ctx context.Context, matches []string) ([]string, error) {
+	filteredMatches := make([]string, 0, len(matches))
+	for _, match := range matches {
+		filteredMatch, ok, err := m.filterQuery(ctx, match)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filteredMatches = append(filteredMatches, filteredMatch)
+		}
+	}
+	return filteredMatches, nil
+}
+
+// filterValue drops the denied series from the value
+func (m *MetricFilterAPI) filterValue(v model.Value) model.Value {
+	dropped := 0
+	switch valueTyped := v.(type) {
+	case model.Vector:
+		filtered := valueTyped[:0]
+		for _, sample := range valueTyped {
+			if m.allowedLabelSet(model.LabelSet(sample.Metric)) {
+				filtered = append(filtered, sample)
+			} else {
+				dropped++
+			}
+		}
+		v = filtered
+	case model.Matrix:
+		filtered := valueTyped[:0]
+		for _, stream := range valueTyped {
+			if m.allowedLabelSet(model.LabelSet(stream.Metric)) {
+				filtered = append(filtered, stream)
+			} else {
+				dropped++
+			}
+		}
+		v = filtered
+	}
+	m.recordDropped(dropped)
+	return v
+}
+
+func (m *MetricFilterAPI) recordDropped(dropped int) {
+	if dropped > 0 && m.DroppedFunc != nil {
+		m.DroppedFunc(dropped)
+	}
+}
+
+// LabelValues performs a query for the values of the given label.
+func (m *MetricFilterAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := m.API.LabelValues(ctx, label)
+	if err != nil || label != model.MetricNameLabel {
+		return v, w, err
+	}
+
+	filtered := make(model.LabelValues, 0, len(v))
+	for _, name := range v {
+		if m.Allowed(string(name)) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, w, nil
+}
+
+// Query performs a query for the given time.
+func (m *MetricFilterAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	filteredQuery, ok, err := m.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+
+	v, w, err := m.API.Query(ctx, filteredQuery, ts)
+	if err != nil {
+		return nil, w, err
+	}
+	return m.filterValue(v), w, nil
+}
+
+// QueryRange performs a query for the given range.
+func (m *MetricFilterAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	filteredQuery, ok, err := m.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+
+	v, w, err := m.API.QueryRange(ctx, filteredQuery, r)
+	if err != nil {
+		return nil, w, err
+	}
+	return m.filterValue(v), w, nil
+}
+
+// Series finds series by label matchers.
+func (m *MetricFilterAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	filteredMatches, err := m.filterMatches(ctx, matches)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(filteredMatches) == 0 {
+		return nil, nil, nil
+	}
+
+	v, w, err := m.API.Series(ctx, filteredMatches, startTime, endTime)
+	if err != nil {
+		return nil, w, err
+	}
+
+	filtered := v[:0]
+	for _, ls := range v {
+		if m.allowedLabelSet(ls) {
+			filtered = append(filtered, ls)
+		}
+	}
+	m.recordDropped(len(v) - len(filtered))
+	return filtered, w, nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (m *MetricFilterAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	filteredMatches, err := m.filterMatches(ctx, matches)
+	if err != nil {
+		return nil, err
+	}
+	if len(filteredMatches) == 0 {
+		return nil, nil
+	}
+
+	dropped := 0
+	defer func() { m.recordDropped(dropped) }()
+	return StreamSeries(ctx, m.API, filteredMatches, startTime, endTime, func(ls model.LabelSet) error {
+		if !m.allowedLabelSet(ls) {
+			dropped++
+			return nil
+		}
+		return fn(ls)
+	})
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (m *MetricFilterAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, m.API, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (m *MetricFilterAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	filteredMatchers, ok := m.filterMatchers(matchers)
+	if !ok {
+		return nil, nil, nil
+	}
+
+	v, w, err := m.API.GetValue(ctx, start, end, filteredMatchers)
+	if err != nil {
+		return nil, w, err
+	}
+	return m.filterValue(v), w, nil
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (m *MetricFilterAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	filteredMatchers, ok := m.filterMatchers(matchers)
+	if !ok {
+		return emptyValueStream(), nil
+	}
+
+	ch, err := StreamGetValue(ctx, m.API, start, end, filteredMatchers)
+	if err != nil {
+		return nil, err
+	}
+	dropped := 0
+	return filterValueStream(ctx, ch, func(s *model.SampleStream) (*model.SampleStream, error) {
+		if !m.allowedLabelSet(model.LabelSet(s.Metric)) {
+			dropped++
+			return nil, nil
+		}
+		return s, nil
+	}, func(w api.Warnings, err error) (api.Warnings, error) {
+		m.recordDropped(dropped)
+		return w, err
+	}), nil
+}
@@ -0,0 +1,250 @@
+package promclient
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// leakyAPI returns both allowed and denied series regardless of what is asked for,
+// recording the queries and matchers it was sent
+type leakyAPI struct {
+	API
+	queries  []string
+	matchers []*labels.Matcher
+}
+
+func (l *leakyAPI) series() []model.LabelSet {
+	return []model.LabelSet{
+		{model.MetricNameLabel: "up", "job": "a"},
+		{model.MetricNameLabel: "secret_tokens", "job": "a"},
+		{model.MetricNameLabel: "secret_keys", "job": "a"},
+		{"job": "a"},
+	}
+}
+
+func (l *leakyAPI) matrix() model.Value {
+	ret := model.Matrix{}
+	for _, ls := range l.series() {
+		ret = append(ret, &model.SampleStream{Metric: model.Metric(ls)})
+	}
+	return ret
+}
+
+func (l *leakyAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if label == model.MetricNameLabel {
+		return model.LabelValues{"secret_keys", "secret_tokens", "up"}, nil, nil
+	}
+	return model.LabelValues{"a"}, nil, nil
+}
+
+func (l *leakyAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	l.queries = append(l.queries, query)
+	return l.matrix(), nil, nil
+}
+
+func (l *leakyAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	l.queries = append(l.queries, query)
+	return l.matrix(), nil, nil
+}
+
+func (l *leakyAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	l.queries = append(l.queries, matches...)
+	return l.series(), nil, nil
+}
+
+func (l *leakyAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	l.matchers = matchers
+	return l.matrix(), nil, nil
+}
+
+func TestMetricFilterAllowed(t *testing.T) {
+	tests := []struct {
+		allow, deny []string
+		name        string
+		allowed     bool
+	}{
+		{deny: []string{"secret_.*"}, name: "up", allowed: true},
+		{deny: []string{"secret_.*"}, name: "secret_tokens", allowed: false},
+		// Regexes are anchored
+		{deny: []string{"secret"}, name: "secret_tokens", allowed: true},
+		{deny: []string{"secret"}, name: "my_secret", allowed: true},
+		{allow: []string{"up", "node_.*"}, name: "up", allowed: true},
+		{allow: []string{"up", "node_.*"}, name: "node_load1", allowed: true},
+		{allow: []string{"up", "node_.*"}, name: "upper", allowed: false},
+		// Deny takes precedence over allow
+		{allow: []string{"node_.*"}, deny: []string{"node_secret"}, name: "node_secret", allowed: false},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			m, err := NewMetricFilterAPI(&leakyAPI{}, test.allow, test.deny, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if allowed := m.Allowed(test.name); allowed != test.allowed {
+				t.Fatalf("mismatch in allowed for %s expected=%v actual=%v", test.name, test.allowed, allowed)
+			}
+		})
+	}
+
+	if _, err := NewMetricFilterAPI(&leakyAPI{}, nil, []string{"("}, nil); err == nil {
+		t.Fatalf("expected error for invalid regex")
+	}
+}
+
+func TestMetricFilterAPI(t *testing.T) {
+	leaky := &leakyAPI{}
+	dropped := 0
+	m, err := NewMetricFilterAPI(leaky, nil, []string{"secret_.*"}, func(count int) { dropped += count })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// checkValue ensures that none of the denied series are returned
+	checkValue := func(v model.Value) {
+		t.Helper()
+		for _, stream := range v.(model.Matrix) {
+			if !m.allowedLabelSet(model.LabelSet(stream.Metric)) {
+				t.Fatalf("denied series returned: %v", stream.Metric)
+			}
+		}
+		// "up" and the series without a name
+		if len(v.(model.Matrix)) != 2 {
+			t.Fatalf("mismatch in number of series expected=2 actual=%v", v)
+		}
+	}
+
+	t.Run("query", func(t *testing.T) {
+		tests := []struct {
+			query string
+			// forwarded is the query we expect the downstream to see, empty means
+			// the downstream should have been skipped
+			forwarded string
+		}{
+			{
+				query:     `up`,
+				forwarded: `up{__name__!~"(?:secret_.*)"}`,
+			},
+			{
+				query:     `secret_tokens`,
+				forwarded: ``,
+			},
+			{
+				query:     `sum(rate(secret_tokens[5m]))`,
+				forwarded: ``,
+			},
+			// Regex matchers are constrained downstream
+			{
+				query:     `{__name__=~"secret_.*|up"}`,
+				forwarded: `{__name__!~"(?:secret_.*)",__name__=~"secret_.*|up"}`,
+			},
+			// Selectors without a metric name are constrained downstream
+			{
+				query:     `sum({job="a"})`,
+				forwarded: `sum({__name__!~"(?:secret_.*)",job="a"})`,
+			},
+			// Only some of the selectors are denied
+			{
+				query:     `up or secret_tokens`,
+				forwarded: `up{__name__!~"(?:secret_.*)"} or secret_tokens{__name__!~"(?:secret_.*)"}`,
+			},
+		}
+
+		for i, test := range tests {
+			t.Run(strconv.Itoa(i), func(t *testing.T) {
+				leaky.queries = nil
+				v, _, err := m.Query(context.TODO(), test.query, time.Now())
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+
+				if test.forwarded == "" {
+					if len(leaky.queries) != 0 {
+						t.Fatalf("query should have been skipped: %v", leaky.queries)
+					}
+					return
+				}
+				if len(leaky.queries) != 1 || leaky.queries[0] != test.forwarded {
+					t.Fatalf("mismatch in forwarded query expected=%s actual=%v", test.forwarded, leaky.queries)
+				}
+				checkValue(v)
+			})
+		}
+	})
+
+	t.Run("getvalue", func(t *testing.T) {
+		denied := labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "secret_tokens")
+		leaky.matchers = nil
+		if v, _, err := m.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), []*labels.Matcher{denied}); err != nil || v != nil || leaky.matchers != nil {
+			t.Fatalf("denied selector should have been skipped: %v %v", v, err)
+		}
+
+		regex := labels.MustNewMatcher(labels.MatchRegexp, model.MetricNameLabel, ".+")
+		v, _, err := m.GetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), []*labels.Matcher{regex})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(leaky.matchers) != 2 {
+			t.Fatalf("missing metric name constraint: %v", leaky.matchers)
+		}
+		checkValue(v)
+	})
+
+	t.Run("series", func(t *testing.T) {
+		leaky.queries = nil
+		labelsets, _, err := m.Series(context.TODO(), []string{`{job="a"}`, `secret_keys`}, time.Unix(0, 0), time.Unix(100, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(leaky.queries) != 1 {
+			t.Fatalf("denied match should have been skipped: %v", leaky.queries)
+		}
+		if len(labelsets) != 2 {
+			t.Fatalf("mismatch in number of series expected=2 actual=%v", labelsets)
+		}
+
+		streamed := 0
+		if _, err := m.StreamSeries(context.TODO(), []string{`{job="a"}`}, time.Unix(0, 0), time.Unix(100, 0), func(ls model.LabelSet) error {
+			if !m.allowedLabelSet(ls) {
+				t.Fatalf("denied series streamed: %v", ls)
+			}
+			streamed++
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if streamed != 2 {
+			t.Fatalf("mismatch in number of streamed series expected=2 actual=%d", streamed)
+		}
+	})
+
+	t.Run("labelvalues", func(t *testing.T) {
+		names, _, err := m.LabelValues(context.TODO(), model.MetricNameLabel)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(names) != 1 || names[0] != "up" {
+			t.Fatalf("denied metric names returned: %v", names)
+		}
+
+		// Other labels are unaffected
+		values, _, err := m.LabelValues(context.TODO(), "job")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(values) != 1 {
+			t.Fatalf("mismatch in label values: %v", values)
+		}
+	})
+
+	if dropped == 0 {
+		t.Fatalf("dropped series were not counted")
+	}
+}
@@ -3,8 +3,11 @@ package promclient
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -65,7 +68,14 @@ type MultiAPIMetricFunc func(i int, api, status string, took float64)
 func NewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricFunc, requiredCount int) *MultiAPI {
 	fingerprintCounts := make(map[model.Fingerprint]int)
 	apiFingerprints := make([]model.Fingerprint, len(apis))
+	apiNames := make([]string, len(apis))
 	for i, api := range apis {
+		if namer, ok := api.(BackendNamer); ok {
+			apiNames[i] = namer.BackendName()
+		} else {
+			apiNames[i] = strconv.Itoa(i)
+		}
+
 		var fingerprint model.Fingerprint
 		if apiLabels, ok := api.(APILabels); ok {
 			if keys := apiLabels.Key(); keys != nil {
@@ -86,6 +96,7 @@ func NewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricF
 	return &MultiAPI{
 		apis:            apis,
 		apiFingerprints: apiFingerprints,
+		apiNames:        apiNames,
 		antiAffinity:    antiAffinity,
 		metricFunc:      metricFunc,
 		requiredCount:   requiredCount,
@@ -96,9 +107,38 @@ func NewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricF
 type MultiAPI struct {
 	apis            []API
 	apiFingerprints []model.Fingerprint
+	apiNames        []string
 	antiAffinity    model.Time
 	metricFunc      MultiAPIMetricFunc
 	requiredCount   int // number "per key" that we require to respond
+
+	// MergeMode defines how values from the apis are combined (dedupe by default)
+	MergeMode MergeMode
+	// DuplicateCheck configures the detection of duplicate series in concat mode
+	DuplicateCheck DuplicateCheck
+	// WorkerPool runs the requests to the apis (DefaultWorkerPool if nil)
+	WorkerPool *WorkerPool
+	// MetricTypes (if set) returns the types of the metrics, so the series of
+	// counters are merged reset aware
+	MetricTypes promutil.MetricTypeFunc
+	// FairSeriesLimit divides the series limit (see WithSeriesLimit) fairly between
+	// the apis, rather than truncating their merged series
+	FairSeriesLimit bool
+	// ExplainRouting adds a warning explaining the routing of the calls which were
+	// routed away from any of the apis (see RoutingLabeler)
+	ExplainRouting bool
+}
+
+func (m *MultiAPI) pool() *WorkerPool {
+	if m.WorkerPool != nil {
+		return m.WorkerPool
+	}
+	return DefaultWorkerPool
+}
+
+// backendWarning returns the warning for a non-fatal error from the i-th api
+func (m *MultiAPI) backendWarning(i int, err error) string {
+	return NewBackendWarning(m.apiNames[i], err).String()
 }
 
 func (m *MultiAPI) recordMetric(i int, api, status string, took float64) {
@@ -109,8 +149,14 @@ func (m *MultiAPI) recordMetric(i int, api, status string, took float64) {
 
 // LabelValues performs a query for the values of the given label.
 func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	// The downstreams would fail on these with less helpful errors
+	if err := ValidateLabelName(label); err != nil {
+		return nil, nil, err
+	}
+
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        model.LabelValues
@@ -125,28 +171,27 @@ func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API, label string) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			start := time.Now()
-			result, w, err := api.LabelValues(childContext, label)
+			result, w, err := api.LabelValues(fan.context(childContext, i), label)
 			took := time.Now().Sub(start)
-			if err != nil {
-				m.recordMetric(i, "label_values", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "label_values", "success", took.Seconds())
-			}
+			m.recordMetric(i, "label_values", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api, label)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
 	}
 
 	// Wait for results as we get them
 	var result []model.LabelValue
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -156,16 +201,20 @@ func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
 				if result == nil {
-					result = ret.v
+					// Copied, as the merge appends to it
+					result = append(make([]model.LabelValue, 0, len(ret.v)), ret.v...)
 				} else {
 					result = MergeLabelValues(result, ret.v)
 				}
@@ -176,10 +225,12 @@ func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
 		}
 	}
 
+	m.finish(ctx, fan, warnings)
+
 	sort.Sort(model.LabelValues(result))
 
 	return result, warnings.Warnings(), nil
@@ -187,8 +238,23 @@ func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 
 // LabelNames returns all the unique label names present in the block in sorted order.
 func (m *MultiAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	return m.labelNames(ctx, func(ctx context.Context, client API) ([]string, api.Warnings, error) {
+		return client.LabelNames(ctx)
+	})
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (m *MultiAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return m.labelNames(ctx, func(ctx context.Context, client API) ([]string, api.Warnings, error) {
+		return LabelNamesInRange(ctx, client, startTime, endTime)
+	})
+}
+
+// labelNames merges the label names returned by call for each of the apis
+func (m *MultiAPI) labelNames(ctx context.Context, call func(context.Context, API) ([]string, api.Warnings, error)) ([]string, api.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        []string
@@ -203,28 +269,27 @@ func (m *MultiAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, erro
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			start := time.Now()
-			result, w, err := api.LabelNames(childContext)
+			result, w, err := call(fan.context(childContext, i), api)
 			took := time.Now().Sub(start)
-			if err != nil {
-				m.recordMetric(i, "label_names", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "label_names", "success", took.Seconds())
-			}
+			m.recordMetric(i, "label_names", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
 	}
 
 	// Wait for results as we get them
-	result := make(map[string]struct{})
+	result := make([]string, 0)
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -234,17 +299,18 @@ func (m *MultiAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, erro
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
-				for _, v := range ret.v {
-					result[v] = struct{}{}
-				}
+				result = promutil.UnionLabelNames(result, ret.v)
 			}
 		}
 	}
@@ -252,24 +318,20 @@ func (m *MultiAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, erro
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
 		}
 	}
 
-	stringResult := make([]string, 0, len(result))
-	for k := range result {
-		stringResult = append(stringResult, k)
-	}
+	m.finish(ctx, fan, warnings)
 
-	sort.Strings(stringResult)
-
-	return stringResult, warnings.Warnings(), nil
+	return result, warnings.Warnings(), nil
 }
 
 // Query performs a query for the given time.
 func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        model.Value
@@ -284,28 +346,28 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API, query string, ts time.Time) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			start := time.Now()
-			result, w, err := api.Query(childContext, query, ts)
+			result, w, err := api.Query(fan.context(childContext, i), query, ts)
 			took := time.Now().Sub(start)
-			if err != nil {
-				m.recordMetric(i, "query", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "query", "success", took.Seconds())
-			}
+			m.recordMetric(i, "query", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api, query, ts)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
 	}
 
 	// Wait for results as we get them
 	var result model.Value
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	merger := m.newValueMerger(ctx)
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -315,22 +377,21 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promutil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
+				var err error
+				result, err = merger.merge(i, result, ret.v)
+				if err != nil {
+					return nil, warnings.Warnings(), err
 				}
 			}
 		}
@@ -339,10 +400,16 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
 		}
 	}
 
+	m.finish(ctx, fan, warnings)
+
+	if err := merger.finish(warnings); err != nil {
+		return nil, warnings.Warnings(), err
+	}
+
 	return result, warnings.Warnings(), nil
 }
 
@@ -350,6 +417,7 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        model.Value
@@ -364,28 +432,28 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API, query string, r v1.Range) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			start := time.Now()
-			result, w, err := api.QueryRange(childContext, query, r)
+			result, w, err := api.QueryRange(fan.context(childContext, i), query, r)
 			took := time.Now().Sub(start)
-			if err != nil {
-				m.recordMetric(i, "query_range", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "query_range", "success", took.Seconds())
-			}
+			m.recordMetric(i, "query_range", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api, query, r)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
 	}
 
 	// Wait for results as we get them
 	var result model.Value
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	merger := m.newValueMerger(ctx)
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -395,22 +463,21 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promutil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
+				var err error
+				result, err = merger.merge(i, result, ret.v)
+				if err != nil {
+					return nil, warnings.Warnings(), err
 				}
 			}
 		}
@@ -419,10 +486,16 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
 		}
 	}
 
+	m.finish(ctx, fan, warnings)
+
+	if err := merger.finish(warnings); err != nil {
+		return nil, warnings.Warnings(), err
+	}
+
 	return result, warnings.Warnings(), nil
 }
 
@@ -430,6 +503,7 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 func (m *MultiAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        []model.LabelSet
@@ -444,28 +518,35 @@ func (m *MultiAPI) Series(ctx context.Context, matches []string, startTime time.
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			start := time.Now()
-			result, w, err := api.Series(childContext, matches, startTime, endTime)
+			result, w, err := api.Series(fan.context(childContext, i), matches, startTime, endTime)
 			took := time.Now().Sub(start)
-			if err != nil {
-				m.recordMetric(i, "series", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "series", "success", took.Seconds())
-			}
+			m.recordMetric(i, "series", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
+	}
+
+	// With a fair limit the series of the apis are merged once all are in
+	limit := SeriesLimitFromContext(ctx)
+	fair := m.FairSeriesLimit && limit > 0
+	var results [][]model.LabelSet
+	if fair {
+		results = make([][]model.LabelSet, len(m.apis))
 	}
 
 	// Wait for results as we get them
 	var result []model.LabelSet
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -475,18 +556,21 @@ func (m *MultiAPI) Series(ctx context.Context, matches []string, startTime time.
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
+				if fair {
+					results[i] = ret.v
 				} else {
-					result = MergeLabelSets(result, ret.v)
+					result = promutil.UnionLabelSets(result, ret.v)
 				}
 			}
 		}
@@ -495,17 +579,218 @@ func (m *MultiAPI) Series(ctx context.Context, matches []string, startTime time.
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
+		}
+	}
+
+	m.finish(ctx, fan, warnings)
+
+	// Each api returns up to the limit, their merged result may exceed it. The
+	// series are sorted so the result (and its truncation) doesn't depend on the
+	// order of the upstreams
+	if fair {
+		var truncated []string
+		result, truncated = m.fairSeries(results, limit)
+		if len(truncated) > 0 {
+			warnings.AddWarning(SeriesLimitWarning)
+			warnings.AddWarning(fmt.Sprintf("series of %s truncated to their fair share of the limit of %d", strings.Join(truncated, ", "), limit))
+		}
+		promutil.SortLabelSets(result)
+	} else {
+		// The union is sorted already
+		if limit > 0 && len(result) > limit {
+			result = result[:limit]
+			warnings.AddWarning(SeriesLimitWarning)
 		}
 	}
 
 	return result, warnings.Warnings(), nil
 }
 
+// fairSeries merges the series of the apis up to the limit. The apis take turns
+// contributing a series, so each of them gets an equal share of the limit and the
+// share an api doesn't use (as it has fewer series) goes to the others. The names
+// of the apis whose series were truncated are returned as well.
+func (m *MultiAPI) fairSeries(results [][]model.LabelSet, limit int) ([]model.LabelSet, []string) {
+	seen := make(map[model.Fingerprint]struct{})
+	merged := make([]model.LabelSet, 0, limit)
+	offsets := make([]int, len(results))
+	for added := true; added && len(merged) < limit; {
+		added = false
+		for i, result := range results {
+			if len(merged) >= limit {
+				break
+			}
+			// Series which another api contributed already are skipped
+			for offsets[i] < len(result) {
+				ls := result[offsets[i]]
+				offsets[i]++
+				fp := promutil.NormalizedFingerprint(model.Metric(ls))
+				if _, ok := seen[fp]; ok {
+					continue
+				}
+				seen[fp] = struct{}{}
+				merged = append(merged, ls)
+				added = true
+				break
+			}
+		}
+	}
+
+	var truncated []string
+	for i, result := range results {
+		for _, ls := range result[offsets[i]:] {
+			if _, ok := seen[promutil.NormalizedFingerprint(model.Metric(ls))]; !ok {
+				truncated = append(truncated, m.apiNames[i])
+				break
+			}
+		}
+	}
+	return merged, truncated
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset. The
+// labelsets from all apis are deduplicated as they are streamed, so only their
+// fingerprints (instead of the labelsets) are held in memory. fn is never called
+// concurrently, or after StreamSeries returns.
+func (m *MultiAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	// The fair share of the apis isn't known until all of them returned, so their
+	// series (at most the limit per api) are loaded before they are passed on
+	if limit := SeriesLimitFromContext(ctx); limit > 0 && m.FairSeriesLimit {
+		v, w, err := m.Series(ctx, matches, startTime, endTime)
+		if err != nil {
+			return w, err
+		}
+		for _, ls := range v {
+			if err := fn(ls); err != nil {
+				return w, err
+			}
+		}
+		return w, nil
+	}
+
+	// Each api streams up to the limit, the deduplicated stream is limited again
+	if limit := SeriesLimitFromContext(ctx); limit > 0 {
+		limiter := &seriesLimiter{limit: limit}
+		return limiter.result(m.streamSeries(ctx, matches, startTime, endTime, limiter.wrap(fn)))
+	}
+	return m.streamSeries(ctx, matches, startTime, endTime, fn)
+}
+
+func (m *MultiAPI) streamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	childContext, childContextCancel := context.WithCancel(ctx)
+	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
+
+	var (
+		l     sync.Mutex
+		done  bool
+		fnErr error
+		seen  = make(map[model.Fingerprint]struct{})
+	)
+	defer func() {
+		l.Lock()
+		done = true
+		l.Unlock()
+	}()
+	streamFn := func(ls model.LabelSet) error {
+		l.Lock()
+		defer l.Unlock()
+		if done {
+			return context.Canceled
+		}
+		if fnErr != nil {
+			return fnErr
+		}
+		fp := promutil.NormalizedFingerprint(model.Metric(ls))
+		if _, ok := seen[fp]; ok {
+			return nil
+		}
+		seen[fp] = struct{}{}
+		fnErr = fn(ls)
+		return fnErr
+	}
+
+	type chanResult struct {
+		warnings api.Warnings
+		err      error
+		ls       model.Fingerprint
+	}
+
+	resultChans := make([]chan chanResult, len(m.apis))
+	outstandingRequests := make(map[model.Fingerprint]int) // fingerprint -> outstanding
+
+	for i, api := range m.apis {
+		resultChans[i] = make(chan chanResult, 1)
+		outstandingRequests[m.apiFingerprints[i]]++
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
+			start := time.Now()
+			w, err := StreamSeries(fan.context(childContext, i), api, matches, startTime, endTime, streamFn)
+			took := time.Now().Sub(start)
+			m.recordMetric(i, "series", CallStatus(err), took.Seconds())
+			retChan <- chanResult{
+				warnings: w,
+				err:      NormalizePromError(err),
+				ls:       m.apiFingerprints[i],
+			}
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
+	}
+
+	// Wait for results as we get them
+	warnings := make(promutil.WarningSet)
+	var errs backendErrors
+	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
+	for i := 0; i < len(m.apis); i++ {
+		select {
+		case <-ctx.Done():
+			return warnings.Warnings(), ctx.Err()
+
+		case ret := <-resultChans[i]:
+			warnings.AddWarnings(ret.warnings)
+			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
+			if ret.err != nil {
+				// Errors from fn are returned as-is
+				l.Lock()
+				err := fnErr
+				l.Unlock()
+				if err != nil {
+					return warnings.Warnings(), err
+				}
+
+				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
+				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
+					errs.add(ret.err)
+					return warnings.Warnings(), errs.err()
+				}
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
+			} else {
+				successMap[ret.ls]++
+			}
+		}
+	}
+
+	// Verify that we hit the requiredCount for all of the buckets
+	for k := range outstandingRequests {
+		if successMap[k] < m.requiredCount {
+			return warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
+		}
+	}
+
+	m.finish(ctx, fan, warnings)
+
+	return warnings.Warnings(), nil
+}
+
 // GetValue fetches a `model.Value` which represents the actual collected data
 func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
 	defer childContextCancel()
+	fan := newFanout(ctx, len(m.apis))
 
 	type chanResult struct {
 		v        model.Value
@@ -521,28 +806,28 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 	for i, api := range m.apis {
 		resultChans[i] = make(chan chanResult, 1)
 		outstandingRequests[m.apiFingerprints[i]]++
-		go func(i int, retChan chan chanResult, api API) {
+		i, api, retChan := i, api, resultChans[i]
+		if err := m.pool().Go(childContext, func(childContext context.Context) {
 			queryStart := time.Now()
-			result, w, err := api.GetValue(childContext, start, end, matchers)
+			result, w, err := api.GetValue(fan.context(childContext, i), start, end, matchers)
 			took := time.Now().Sub(queryStart)
-			if err != nil {
-				m.recordMetric(i, "get_value", "error", took.Seconds())
-			} else {
-				m.recordMetric(i, "get_value", "success", took.Seconds())
-			}
+			m.recordMetric(i, "get_value", CallStatus(err), took.Seconds())
 			retChan <- chanResult{
 				v:        result,
 				warnings: w,
 				err:      NormalizePromError(err),
 				ls:       m.apiFingerprints[i],
 			}
-		}(i, resultChans[i], api)
+		}); err != nil {
+			retChan <- chanResult{err: err, ls: m.apiFingerprints[i]}
+		}
 	}
 
 	// Wait for results as we get them
 	var result model.Value
 	warnings := make(promutil.WarningSet)
-	var lastError error
+	merger := m.newValueMerger(ctx)
+	var errs backendErrors
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
 	for i := 0; i < len(m.apis); i++ {
 		select {
@@ -552,22 +837,21 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 		case ret := <-resultChans[i]:
 			warnings.AddWarnings(ret.warnings)
 			outstandingRequests[ret.ls]--
+			fan.result(i, ret.err)
 			if ret.err != nil {
 				// If there aren't enough outstanding requests to possibly succeed, no reason to wait
 				if (outstandingRequests[ret.ls] + successMap[ret.ls]) < m.requiredCount {
-					return nil, warnings.Warnings(), ret.err
+					errs.add(ret.err)
+					return nil, warnings.Warnings(), errs.err()
 				}
-				lastError = ret.err
+				errs.add(ret.err)
+				warnings.AddWarning(m.backendWarning(i, ret.err))
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promutil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
+				var err error
+				result, err = merger.merge(i, result, ret.v)
+				if err != nil {
+					return nil, warnings.Warnings(), err
 				}
 			}
 		}
@@ -576,9 +860,40 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 	// Verify that we hit the requiredCount for all of the buckets
 	for k := range outstandingRequests {
 		if successMap[k] < m.requiredCount {
-			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
+			return nil, warnings.Warnings(), errors.Wrap(errs.err(), "Unable to fetch from downstream servers")
 		}
 	}
 
+	m.finish(ctx, fan, warnings)
+
+	if err := merger.finish(warnings); err != nil {
+		return nil, warnings.Warnings(), err
+	}
+
 	return result, warnings.Warnings(), nil
 }
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read. The stream of a
+// single api is passed through as it is, the results of several apis have to be
+// merged so these are loaded (as by GetValue) before they are streamed.
+func (m *MultiAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if len(m.apis) != 1 {
+		v, w, err := m.GetValue(ctx, start, end, matchers)
+		if err != nil {
+			return nil, err
+		}
+		return streamValue(ctx, v, w)
+	}
+
+	queryStart := time.Now()
+	ch, err := StreamGetValue(ctx, m.apis[0], start, end, matchers)
+	if err != nil {
+		m.recordMetric(0, "get_value", CallStatus(err), time.Now().Sub(queryStart).Seconds())
+		return nil, NormalizePromError(err)
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		m.recordMetric(0, "get_value", CallStatus(err), time.Now().Sub(queryStart).Seconds())
+		return w, NormalizePromError(err)
+	}), nil
+}
@@ -167,7 +167,7 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// Ensure that simple label addition works
 		{
-			a:           &AddLabelClient{stub, model.LabelSet{"a": "b"}},
+			a:           &AddLabelClient{API: stub, Labels: model.LabelSet{"a": "b"}},
 			labelNames:  []string{"a"},
 			labelValues: []model.LabelValue{"b"},
 			v: model.Vector{
@@ -180,8 +180,8 @@ func TestMultiAPIMerging(t *testing.T) {
 		// Ensure a single layer of multi merges
 		{
 			a: NewMultiAPI([]API{
-				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
-				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
 			labelNames:  []string{"a"},
 			labelValues: []model.LabelValue{"1", "2"},
@@ -198,12 +198,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		{
 			a: NewMultiAPI([]API{
 				NewMultiAPI([]API{
-					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
-					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
 				}, model.Time(0), nil, 1),
 				NewMultiAPI([]API{
-					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
-					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
 			}, model.Time(0), nil, 2),
 			labelNames:  []string{"a"},
@@ -222,22 +222,22 @@ func TestMultiAPIMerging(t *testing.T) {
 			a: NewMultiAPI([]API{
 				NewMultiAPI([]API{
 					NewMultiAPI([]API{
-						&AddLabelClient{stub, model.LabelSet{"a": "1"}},
-						&AddLabelClient{stub, model.LabelSet{"a": "1"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
 					}, model.Time(0), nil, 1),
 					NewMultiAPI([]API{
-						&AddLabelClient{stub, model.LabelSet{"a": "2"}},
-						&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 					}, model.Time(0), nil, 1),
 				}, model.Time(0), nil, 2),
 				NewMultiAPI([]API{
 					NewMultiAPI([]API{
-						&AddLabelClient{stub, model.LabelSet{"b": "1"}},
-						&AddLabelClient{stub, model.LabelSet{"b": "1"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"b": "1"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"b": "1"}},
 					}, model.Time(0), nil, 1),
 					NewMultiAPI([]API{
-						&AddLabelClient{stub, model.LabelSet{"b": "2"}},
-						&AddLabelClient{stub, model.LabelSet{"b": "2"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"b": "2"}},
+						&AddLabelClient{API: stub, Labels: model.LabelSet{"b": "2"}},
 					}, model.Time(0), nil, 1),
 				}, model.Time(0), nil, 2),
 			}, model.Time(0), nil, 2),
@@ -267,12 +267,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		{
 			a: NewMultiAPI([]API{
 				NewMultiAPI([]API{
-					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
-					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
+					&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}}, fmt.Errorf("")},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
 				}, model.Time(0), nil, 1),
 				NewMultiAPI([]API{
-					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "2"}}, fmt.Errorf("")},
-					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+					&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}}, fmt.Errorf("")},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
 			}, model.Time(0), nil, 2),
 			labelNames:  []string{"a"},
@@ -290,12 +290,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		{
 			a: NewMultiAPI([]API{
 				NewMultiAPI([]API{
-					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
-					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
+					&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}}, fmt.Errorf("")},
+					&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 				}, model.Time(0), nil, 1),
 				NewMultiAPI([]API{
-					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
-					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
+					&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
 			}, model.Time(0), nil, 2),
 			err: true,
@@ -303,17 +303,17 @@ func TestMultiAPIMerging(t *testing.T) {
 		// if in a multi, all that "match" error, we should error
 		{
 			a: NewMultiAPI([]API{
-				&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
-				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+				&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}}, fmt.Errorf("")},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
 			err: true,
 		},
 		// however, in a multi if a single one succeeds for a given "group" then it should pass
 		{
 			a: NewMultiAPI([]API{
-				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
-				&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
-				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
+				&errorAPI{&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}}, fmt.Errorf("")},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
 			labelNames:  []string{"a"},
 			labelValues: []model.LabelValue{"1", "2"},
@@ -330,8 +330,8 @@ func TestMultiAPIMerging(t *testing.T) {
 		{
 			a: NewMultiAPI([]API{
 				stub,
-				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
-				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "1"}},
+				&AddLabelClient{API: stub, Labels: model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
 			labelNames:  []string{"a"},
 			labelValues: []model.LabelValue{"1", "2"},
@@ -340,10 +340,11 @@ func TestMultiAPIMerging(t *testing.T) {
 				getSample(model.LabelSet{model.MetricNameLabel: "testmetric", "a": "1"}),
 				getSample(model.LabelSet{model.MetricNameLabel: "testmetric", "a": "2"}),
 			},
+			// Sorted by their string representation
 			series: []model.LabelSet{
-				{model.MetricNameLabel: "testmetric"},
 				{model.MetricNameLabel: "testmetric", "a": "1"},
 				{model.MetricNameLabel: "testmetric", "a": "2"},
+				{model.MetricNameLabel: "testmetric"},
 			},
 		},
 	}
@@ -495,3 +496,105 @@ func TestMultiAPIMerging(t *testing.T) {
 	}
 }
 
+
+func TestMultiAPINormalizedMerging(t *testing.T) {
+	// The backends encode the same series differently
+	a := model.Metric{model.MetricNameLabel: "job:up:sum", "job": "a", "city": "caf\u00e9"}
+	b := model.Metric{"city": "cafe\u0301", "job": "a", "shard": "", model.MetricNameLabel: "job:up:sum"}
+	stubFor := func(m model.Metric, ts model.Time) *stubAPI {
+		return &stubAPI{
+			query: func() model.Value {
+				return model.Vector{{Metric: m.Clone(), Value: 1, Timestamp: ts}}
+			},
+			series: func() []model.LabelSet {
+				return []model.LabelSet{model.LabelSet(m.Clone())}
+			},
+		}
+	}
+
+	multi := NewMultiAPI([]API{stubFor(a, 100), stubFor(b, 100)}, model.Time(0), nil, 1)
+
+	v, _, err := multi.Query(context.TODO(), "job:up:sum", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vector := v.(model.Vector)
+	if len(vector) != 1 {
+		t.Fatalf("mismatch in series expected=%d actual=%d: %v", 1, len(vector), vector)
+	}
+	// The labels are those returned by one of the backends, not the normalized ones
+	if !vector[0].Metric.Equal(a) && !vector[0].Metric.Equal(b) {
+		t.Fatalf("mismatch in labels expected=%v or %v actual=%v", a, b, vector[0].Metric)
+	}
+
+	series, _, err := multi.Series(context.TODO(), []string{"job:up:sum"}, time.Now(), time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(series) != 1 {
+		t.Fatalf("mismatch in series expected=%d actual=%d: %v", 1, len(series), series)
+	}
+}
+
+func TestMultiAPIFairSeriesLimit(t *testing.T) {
+	seriesOf := func(job string, count int) func() []model.LabelSet {
+		return func() []model.LabelSet {
+			series := make([]model.LabelSet, count)
+			for i := range series {
+				series[i] = model.LabelSet{"job": model.LabelValue(job), "i": model.LabelValue(strconv.Itoa(i))}
+			}
+			return series
+		}
+	}
+	apis := []API{
+		&stubAPI{series: seriesOf("high", 100)},
+		&stubAPI{series: seriesOf("low", 5)},
+	}
+
+	tests := []struct {
+		limit int
+		// counts are the expected series per job
+		counts    map[string]int
+		truncated bool
+	}{
+		// The share the low cardinality backend doesn't use goes to the other one
+		{limit: 20, counts: map[string]int{"high": 15, "low": 5}, truncated: true},
+		{limit: 8, counts: map[string]int{"high": 4, "low": 4}, truncated: true},
+		{limit: 105, counts: map[string]int{"high": 100, "low": 5}},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.limit), func(t *testing.T) {
+			multi := NewMultiAPI(apis, model.TimeFromUnix(0), nil, 1)
+			multi.FairSeriesLimit = true
+
+			ctx := WithSeriesLimit(context.TODO(), test.limit)
+			series, w, err := multi.Series(ctx, []string{"{}"}, time.Time{}, time.Time{})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			counts := make(map[string]int)
+			for _, ls := range series {
+				counts[string(ls["job"])]++
+			}
+			if fmt.Sprint(counts) != fmt.Sprint(test.counts) {
+				t.Fatalf("mismatch in series per job expected=%v actual=%v", test.counts, counts)
+			}
+			if truncated := len(w) > 0; truncated != test.truncated {
+				t.Fatalf("mismatch in truncated expected=%v actual=%v (%v)", test.truncated, truncated, w)
+			}
+
+			// The stream has the same series
+			streamed := 0
+			if _, err := multi.StreamSeries(ctx, []string{"{}"}, time.Time{}, time.Time{}, func(model.LabelSet) error {
+				streamed++
+				return nil
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if streamed != len(series) {
+				t.Fatalf("mismatch in streamed series expected=%d actual=%d", len(series), streamed)
+			}
+		})
+	}
+}
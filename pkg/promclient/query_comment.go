@@ -0,0 +1,182 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// The fields of a query comment which aren't from the QueryAttribution
+const (
+	// QueryCommentUser is the tenant of the query
+	QueryCommentUser = "user"
+	// QueryCommentRequestID is the correlation ID of the query
+	QueryCommentRequestID = "request_id"
+)
+
+// QueryCommentPrefix starts the comments appended to the forwarded queries
+const QueryCommentPrefix = "# promproxy"
+
+// maxQueryCommentValue is the max length of a value of a query comment
+const maxQueryCommentValue = 128
+
+var (
+	queryCommentFieldRegex  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	queryCommentUnsafeRegex = regexp.MustCompile(`[^a-zA-Z0-9_.:/@-]`)
+)
+
+// QueryCommentConfig configures the comment appended to the queries forwarded to
+// the hosts of a servergroup, which attributes them in the query log of the hosts
+type QueryCommentConfig struct {
+	// Fields are the fields of the comment in order. These are QueryCommentUser,
+	// QueryCommentRequestID or the fields of the QueryAttribution of the query
+	// (e.g. the dashboard).
+	Fields []string `yaml:"fields"`
+}
+
+// DefaultQueryCommentConfig is the QueryCommentConfig used for unset fields
+var DefaultQueryCommentConfig = QueryCommentConfig{
+	Fields: []string{QueryCommentUser, QueryCommentRequestID},
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *QueryCommentConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultQueryCommentConfig
+	type plain QueryCommentConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c QueryCommentConfig) Validate() error {
+	for _, field := range c.Fields {
+		if !queryCommentFieldRegex.MatchString(field) {
+			return fmt.Errorf("invalid query comment field %q", field)
+		}
+	}
+	return nil
+}
+
+// Comment returns the comment of the queries made with the context, empty if none
+// of the fields are set. The values are sanitized, so the comment is a single line
+// which can't end the comment early or break the query.
+func (c QueryCommentConfig) Comment(ctx context.Context) string {
+	attribution := QueryAttributionFromContext(ctx)
+	var b strings.Builder
+	for _, field := range c.Fields {
+		var value string
+		switch field {
+		case QueryCommentUser:
+			value = TenantFromContext(ctx)
+		case QueryCommentRequestID:
+			value = CorrelationIDFromContext(ctx)
+		default:
+			value = attribution[field]
+		}
+		if value = sanitizeQueryCommentValue(value); value == "" {
+			continue
+		}
+		if b.Len() == 0 {
+			b.WriteString(QueryCommentPrefix)
+		}
+		fmt.Fprintf(&b, " %s=%s", field, value)
+	}
+	return b.String()
+}
+
+// sanitizeQueryCommentValue replaces the characters of the value which aren't
+// safe in a comment (e.g. newlines and spaces) and truncates it
+func sanitizeQueryCommentValue(v string) string {
+	if len(v) > maxQueryCommentValue {
+		v = v[:maxQueryCommentValue]
+	}
+	return queryCommentUnsafeRegex.ReplaceAllString(v, "_")
+}
+
+type queryAttributionKey struct{}
+
+// WithQueryAttribution returns a context whose forwarded queries are attributed to
+// the fields (e.g. the dashboard), in addition to those of the parent context
+func WithQueryAttribution(ctx context.Context, fields map[string]string) context.Context {
+	merged := make(map[string]string)
+	for k, v := range QueryAttributionFromContext(ctx) {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, queryAttributionKey{}, merged)
+}
+
+// QueryAttributionFromContext returns the query attribution of the context (nil
+// if there is none)
+func QueryAttributionFromContext(ctx context.Context) map[string]string {
+	fields, _ := ctx.Value(queryAttributionKey{}).(map[string]string)
+	return fields
+}
+
+// QueryCommentRoundTripper appends the comment of the QueryCommentConfig to the
+// query param of the query and query_range requests. As this works on the requests
+// the queries built from matchers (see PromAPIV1.GetValue) are covered as well.
+// Prometheus ignores the comment, but logs the full query in its query log.
+type QueryCommentRoundTripper struct {
+	Config       QueryCommentConfig
+	RoundTripper http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction, with the comment appended to the query
+func (q *QueryCommentRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch path.Base(req.URL.Path) {
+	case "query", "query_range":
+	default:
+		return q.RoundTripper.RoundTrip(req)
+	}
+	comment := q.Config.Comment(req.Context())
+	if comment == "" {
+		return q.RoundTripper.RoundTrip(req)
+	}
+	// The comment is on a line of its own, so it can't comment out any of the query
+	addComment := func(values url.Values) {
+		if query := values.Get("query"); query != "" {
+			values.Set("query", query+"\n"+comment)
+		}
+	}
+
+	// RoundTrippers must not modify the request, so the query is set on a copy
+	reqCopy := new(http.Request)
+	*reqCopy = *req
+	u := *req.URL
+	values := u.Query()
+	addComment(values)
+	u.RawQuery = values.Encode()
+	reqCopy.URL = &u
+
+	if req.Body != nil && req.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		b, err := ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		values, err := url.ParseQuery(string(b))
+		if err != nil {
+			return nil, err
+		}
+		addComment(values)
+		body := values.Encode()
+		reqCopy.Body = ioutil.NopCloser(bytes.NewBufferString(body))
+		reqCopy.ContentLength = int64(len(body))
+		reqCopy.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewBufferString(body)), nil
+		}
+	}
+	return q.RoundTripper.RoundTrip(reqCopy)
+}
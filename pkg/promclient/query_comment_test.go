@@ -0,0 +1,93 @@
+package promclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql"
+)
+
+func TestQueryCommentRoundTripper(t *testing.T) {
+	cfg := QueryCommentConfig{Fields: []string{QueryCommentUser, "dashboard"}}
+
+	tests := []struct {
+		name        string
+		tenant      string
+		attribution map[string]string
+		post        bool
+		expected    string
+	}{
+		// Without anything to attribute the query is left alone
+		{
+			name:     "no context",
+			expected: `sum(rate(http_requests_total[5m]))`,
+		},
+		{
+			name:        "attributed",
+			tenant:      "alice",
+			attribution: map[string]string{"dashboard": "foo"},
+			expected:    "sum(rate(http_requests_total[5m]))\n# promproxy user=alice dashboard=foo",
+		},
+		{
+			name:        "post",
+			tenant:      "alice",
+			attribution: map[string]string{"dashboard": "foo"},
+			post:        true,
+			expected:    "sum(rate(http_requests_total[5m]))\n# promproxy user=alice dashboard=foo",
+		},
+		// A value can't end the comment and inject anything into the query
+		{
+			name:        "escaped",
+			attribution: map[string]string{"dashboard": "foo\n) or vector(1) # x=y"},
+			expected:    "sum(rate(http_requests_total[5m]))\n# promproxy dashboard=foo__or_vector_1____x_y",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var query string
+			rt := &QueryCommentRoundTripper{Config: cfg, RoundTripper: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				if err := req.ParseForm(); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				query = req.Form.Get("query")
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			})}
+
+			ctx := context.TODO()
+			if test.tenant != "" {
+				ctx = WithTenant(ctx, test.tenant)
+			}
+			if test.attribution != nil {
+				ctx = WithQueryAttribution(ctx, test.attribution)
+			}
+
+			values := url.Values{"query": []string{"sum(rate(http_requests_total[5m]))"}, "time": []string{"1000"}}
+			var req *http.Request
+			if test.post {
+				req, _ = http.NewRequest(http.MethodPost, "http://prom:9090/api/v1/query", strings.NewReader(values.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req, _ = http.NewRequest(http.MethodGet, "http://prom:9090/api/v1/query?"+values.Encode(), nil)
+			}
+			if _, err := rt.RoundTrip(req.WithContext(ctx)); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if query != test.expected {
+				t.Fatalf("mismatch in query expected=%q actual=%q", test.expected, query)
+			}
+			expr, err := promql.ParseExpr(query)
+			if err != nil {
+				t.Fatalf("query with comment doesn't parse: %v", err)
+			}
+			if expr.String() != `sum(rate(http_requests_total[5m]))` {
+				t.Fatalf("mismatch in parsed query expected=%s actual=%s", `sum(rate(http_requests_total[5m]))`, expr)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The sources of the samples of a QueryCost
+const (
+	// QueryCostSourceStats are the samples the backend reported it scanned
+	QueryCostSourceStats = "stats"
+	// QueryCostSourceEstimate are the samples the backend returned, for backends
+	// which don't report stats (before prometheus 2.35)
+	QueryCostSourceEstimate = "estimate"
+)
+
+// QueryCost is the cost of a call borne by a backend
+type QueryCost struct {
+	Duration time.Duration
+	Samples  int64
+	// Source is where the Samples are from (QueryCostSourceStats or
+	// QueryCostSourceEstimate)
+	Source string
+}
+
+// QueryCostFunc records the cost of a call of the method with a query of the shape
+type QueryCostFunc func(method, shape string, cost QueryCost)
+
+// QueryShape returns the shape of the query: the operation of its outermost
+// expression (e.g. "sum" or "rate"), so queries can be grouped by it with a bounded
+// number of groups
+func QueryShape(query string) string {
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return "invalid"
+	}
+	return exprShape(e)
+}
+
+func exprShape(e promql.Expr) string {
+	switch typed := e.(type) {
+	case *promql.AggregateExpr:
+		return typed.Op.String()
+	case *promql.Call:
+		return typed.Func.Name
+	case *promql.BinaryExpr:
+		return "binary"
+	case *promql.ParenExpr:
+		return exprShape(typed.Expr)
+	case *promql.UnaryExpr:
+		return exprShape(typed.Expr)
+	case *promql.SubqueryExpr:
+		return "subquery"
+	case *promql.MatrixSelector:
+		return "range_selector"
+	case *promql.VectorSelector:
+		return "selector"
+	case *promql.NumberLiteral, *promql.StringLiteral:
+		return "literal"
+	default:
+		return "other"
+	}
+}
+
+// queryStats are the stats reported by the backend for the requests of a call
+type queryStats struct {
+	mu      sync.Mutex
+	samples int64
+	ok      bool
+}
+
+func (s *queryStats) add(samples int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.samples += samples
+	s.ok = true
+}
+
+func (s *queryStats) get() (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.samples, s.ok
+}
+
+type queryStatsKey struct{}
+
+func withQueryStats(ctx context.Context) (context.Context, *queryStats) {
+	s := &queryStats{}
+	return context.WithValue(ctx, queryStatsKey{}, s), s
+}
+
+// QueryStatsRoundTripper requests the stats of the query and query_range calls of
+// a QueryCostAPI (with stats=all), capturing the samples the backend scanned.
+// Backends which don't report stats ignore the param.
+type QueryStatsRoundTripper struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (q *QueryStatsRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	stats, ok := req.Context().Value(queryStatsKey{}).(*queryStats)
+	if !ok {
+		return q.RoundTripper.RoundTrip(req)
+	}
+	switch path.Base(req.URL.Path) {
+	case "query", "query_range":
+	default:
+		return q.RoundTripper.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request, so the param is set on a copy
+	reqCopy := new(http.Request)
+	*reqCopy = *req
+	u := *req.URL
+	values := u.Query()
+	values.Set("stats", "all")
+	u.RawQuery = values.Encode()
+	reqCopy.URL = &u
+
+	resp, err := q.RoundTripper.RoundTrip(reqCopy)
+	if err != nil || resp.StatusCode != http.StatusOK {
+		return resp, err
+	}
+	// The client reads the whole body anyway, so it is read here and replaced
+	b, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(b))
+
+	var r struct {
+		Data struct {
+			Stats *struct {
+				Samples *struct {
+					TotalQueryableSamples int64 `json:"totalQueryableSamples"`
+				} `json:"samples"`
+			} `json:"stats"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(b, &r); err == nil && r.Data.Stats != nil && r.Data.Stats.Samples != nil {
+		stats.add(r.Data.Stats.Samples.TotalQueryableSamples)
+	}
+	return resp, nil
+}
+
+// QueryCostAPI records the cost of the data calls (queries and selects) to the
+// wrapped backend with CostFunc. The samples are those the backend reported it
+// scanned, which is captured by the QueryStatsRoundTripper, so that has to be part
+// of the transport of the wrapped API. Otherwise the samples are estimated as
+// those returned (which undercounts e.g. aggregations), and the duration is
+// recorded either way.
+type QueryCostAPI struct {
+	API
+	CostFunc QueryCostFunc
+}
+
+// record records the cost of the call
+func (q *QueryCostAPI) record(ctx context.Context, method, shape string, fn func(context.Context) (model.Value, error)) {
+	callCtx, stats := withQueryStats(ctx)
+	start := time.Now()
+	v, err := fn(callCtx)
+	cost := QueryCost{Duration: time.Since(start)}
+	if samples, ok := stats.get(); ok {
+		cost.Samples, cost.Source = samples, QueryCostSourceStats
+	} else if err == nil {
+		cost.Samples, cost.Source = valueSamples(v), QueryCostSourceEstimate
+	}
+	q.CostFunc(method, shape, cost)
+}
+
+// valueSamples returns the number of samples of the value
+func valueSamples(v model.Value) int64 {
+	switch typed := v.(type) {
+	case model.Matrix:
+		var samples int64
+		for _, stream := range typed {
+			samples += int64(len(stream.Values))
+		}
+		return samples
+	case model.Vector:
+		return int64(len(typed))
+	case *model.Scalar:
+		if typed == nil {
+			return 0
+		}
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Query performs a query for the given time.
+func (q *QueryCostAPI) Query(ctx context.Context, query string, ts time.Time) (v model.Value, w api.Warnings, err error) {
+	q.record(ctx, "query", QueryShape(query), func(ctx context.Context) (model.Value, error) {
+		v, w, err = q.API.Query(ctx, query, ts)
+		return v, err
+	})
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (q *QueryCostAPI) QueryRange(ctx context.Context, query string, r v1.Range) (v model.Value, w api.Warnings, err error) {
+	q.record(ctx, "query_range", QueryShape(query), func(ctx context.Context) (model.Value, error) {
+		v, w, err = q.API.QueryRange(ctx, query, r)
+		return v, err
+	})
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (q *QueryCostAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (v model.Value, w api.Warnings, err error) {
+	q.record(ctx, "get_value", "selector", func(ctx context.Context) (model.Value, error) {
+		v, w, err = q.API.GetValue(ctx, start, end, matchers)
+		return v, err
+	})
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (q *QueryCostAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, q.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (q *QueryCostAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, q.API, startTime, endTime)
+}
@@ -0,0 +1,108 @@
+package promclient
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+func TestQueryShape(t *testing.T) {
+	tests := []struct {
+		query string
+		shape string
+	}{
+		{query: `up`, shape: "selector"},
+		{query: `up[5m]`, shape: "range_selector"},
+		{query: `sum(rate(http_requests_total[5m]))`, shape: "sum"},
+		{query: `topk by (job) (5, up)`, shape: "topk"},
+		{query: `rate(http_requests_total[5m])`, shape: "rate"},
+		{query: `(sum(up))`, shape: "sum"},
+		{query: `-up`, shape: "selector"},
+		{query: `up / up`, shape: "binary"},
+		{query: `max_over_time(up[1h:5m])`, shape: "max_over_time"},
+		{query: `up[1h:5m]`, shape: "subquery"},
+		{query: `1`, shape: "literal"},
+		{query: `sum(`, shape: "invalid"},
+	}
+
+	for _, test := range tests {
+		if shape := QueryShape(test.query); shape != test.shape {
+			t.Fatalf("mismatch in shape of %s expected=%v actual=%v", test.query, test.shape, shape)
+		}
+	}
+}
+
+// TestQueryCostAPI fans a query out to a backend which reports stats and one
+// which doesn't, recording the cost of each
+func TestQueryCostAPI(t *testing.T) {
+	backend := func(body string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			if r.URL.Query().Get("stats") != "all" {
+				http.Error(w, "missing stats param", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(body))
+		}))
+	}
+	withStats := backend(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"a"},"values":[[100,"1"],[160,"2"]]}],"stats":{"timings":{"evalTotalTime":0.01},"samples":{"totalQueryableSamples":1234}}}}`)
+	defer withStats.Close()
+	withoutStats := backend(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"b"},"values":[[100,"1"],[160,"2"]]}]}}`)
+	defer withoutStats.Close()
+
+	type costKey struct{ backend, method, shape string }
+	var (
+		mu    sync.Mutex
+		costs = make(map[costKey]QueryCost)
+	)
+	apis := make([]API, 0, 2)
+	for _, srv := range []*httptest.Server{withStats, withoutStats} {
+		client, err := api.NewClient(api.Config{
+			Address:      srv.URL,
+			RoundTripper: &QueryStatsRoundTripper{http.DefaultTransport},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		name := srv.URL
+		apis = append(apis, &QueryCostAPI{
+			API: &PromAPIV1{v1.NewAPI(client)},
+			CostFunc: func(method, shape string, cost QueryCost) {
+				mu.Lock()
+				defer mu.Unlock()
+				costs[costKey{name, method, shape}] = cost
+			},
+		})
+	}
+
+	multi := NewMultiAPI(apis, 0, nil, 1)
+	if _, _, err := multi.QueryRange(context.TODO(), `sum(rate(up[5m]))`, v1.Range{Start: time.Unix(100, 0), End: time.Unix(160, 0), Step: time.Minute}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[costKey]QueryCost{
+		{withStats.URL, "query_range", "sum"}:    {Samples: 1234, Source: QueryCostSourceStats},
+		{withoutStats.URL, "query_range", "sum"}: {Samples: 2, Source: QueryCostSourceEstimate},
+	}
+	if len(costs) != len(expected) {
+		t.Fatalf("mismatch in costs expected=%v actual=%v", expected, costs)
+	}
+	for k, e := range expected {
+		cost, ok := costs[k]
+		if !ok {
+			t.Fatalf("missing cost of %v", k)
+		}
+		if cost.Samples != e.Samples || cost.Source != e.Source {
+			t.Fatalf("mismatch in cost of %v expected=%v actual=%v", k, e, cost)
+		}
+		if cost.Duration <= 0 {
+			t.Fatalf("mismatch in duration of %v expected=>0 actual=%v", k, cost.Duration)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package promclient
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// queryLimitVersion is the first prometheus version whose query and query_range
+// APIs support the limit param. Older versions ignore the param, so sending it to
+// them only loses the benefit of the host stopping at the limit.
+var queryLimitVersion = [3]int{3, 6, 0}
+
+type queryLimitKey struct{}
+
+// WithQueryLimit returns a context carrying the max number of series the result
+// of a query or query_range call should have. This is only meant for the calls
+// sending the whole query of the request to the downstreams, not for those the
+// engine pushes down (whose results are further evaluated), so the NodeReplacer
+// of the ProxyStorage drops it from their context. The limit is sent to the hosts
+// which support it (see QueryLimitRoundTripper), the merged result is still
+// truncated by the caller.
+func WithQueryLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, queryLimitKey{}, limit)
+}
+
+// QueryLimitFromContext returns the query limit of the context (0 if there is
+// none)
+func QueryLimitFromContext(ctx context.Context) int {
+	limit, _ := ctx.Value(queryLimitKey{}).(int)
+	return limit
+}
+
+// QueryLimitRoundTripper sets the limit param of the query and query_range calls
+// to the query limit of their context (see WithQueryLimit), for the hosts which
+// support it. Each host stops at the limit, the result merged from the hosts can
+// still have more series than the limit.
+type QueryLimitRoundTripper struct {
+	http.RoundTripper
+	// Support defines whether the limit param is sent (SeriesLimitAuto if unset)
+	Support SeriesLimitSupport
+	// Capabilities detects the support of the hosts (by host) for SeriesLimitAuto,
+	// the param isn't sent to the hosts without a probe
+	Capabilities *CapabilityProbes
+}
+
+// supportsQueryLimit returns whether the limit param is sent to the host
+func (q *QueryLimitRoundTripper) supportsQueryLimit(ctx context.Context, host string) bool {
+	switch q.Support {
+	case SeriesLimitEnabled:
+		return true
+	case SeriesLimitDisabled:
+		return false
+	default:
+		if q.Capabilities == nil {
+			return false
+		}
+		p := q.Capabilities.Lookup(host)
+		return p != nil && p.QueryLimit(ctx)
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (q *QueryLimitRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch path.Base(req.URL.Path) {
+	case "query", "query_range":
+	default:
+		return q.RoundTripper.RoundTrip(req)
+	}
+	limit := QueryLimitFromContext(req.Context())
+	if limit <= 0 || !q.supportsQueryLimit(req.Context(), req.URL.Host) {
+		return q.RoundTripper.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request, so the param is set on a copy
+	reqCopy := new(http.Request)
+	*reqCopy = *req
+	u := *req.URL
+	values := u.Query()
+	values.Set("limit", strconv.Itoa(limit))
+	u.RawQuery = values.Encode()
+	reqCopy.URL = &u
+	return q.RoundTripper.RoundTrip(reqCopy)
+}
@@ -0,0 +1,77 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestQueryLimitRoundTripper(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		version string // empty means no build info API
+		support SeriesLimitSupport
+		limit   int
+		// limitParam is whether the limit param is expected to be sent
+		limitParam bool
+	}{
+		{name: "capable", path: "/api/v1/query", version: "3.6.0", limit: 3, limitParam: true},
+		{name: "capable range", path: "/api/v1/query_range", version: "v3.7.1", limit: 3, limitParam: true},
+		{name: "too old", path: "/api/v1/query", version: "2.55.0", limit: 3},
+		{name: "no build info", path: "/api/v1/query", limit: 3},
+		{name: "no limit", path: "/api/v1/query", version: "3.6.0"},
+		{name: "series", path: "/api/v1/series", version: "3.6.0", limit: 3},
+		{name: "enabled", path: "/api/v1/query", support: SeriesLimitEnabled, limit: 3, limitParam: true},
+		{name: "disabled", path: "/api/v1/query", version: "3.6.0", support: SeriesLimitDisabled, limit: 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var limitParam string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/api/v1/status/buildinfo" {
+					if test.version == "" {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintf(w, `{"status":"success","data":{"version":%q}}`, test.version)
+					return
+				}
+				limitParam = r.FormValue("limit")
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			probes := NewCapabilityProbes()
+			probes.Get(u.Host, srv.Client(), u)
+			client := &http.Client{Transport: &QueryLimitRoundTripper{
+				RoundTripper: http.DefaultTransport,
+				Support:      test.support,
+				Capabilities: probes,
+			}}
+
+			ctx := context.TODO()
+			if test.limit > 0 {
+				ctx = WithQueryLimit(ctx, test.limit)
+			}
+			req, _ := http.NewRequest("GET", srv.URL+test.path+"?query=up", nil)
+			resp, err := client.Do(req.WithContext(ctx))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			resp.Body.Close()
+
+			expectedParam := ""
+			if test.limitParam {
+				expectedParam = "3"
+			}
+			if limitParam != expectedParam {
+				t.Fatalf("mismatch in limit param expected=%q actual=%q", expectedParam, limitParam)
+			}
+		})
+	}
+}
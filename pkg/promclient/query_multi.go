@@ -0,0 +1,90 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// DefaultQueryMultiConcurrency is the number of instant queries a MultiTimeAPI
+// will have outstanding at once if no Concurrency is set
+const DefaultQueryMultiConcurrency = 4
+
+// MultiTimeAPI evaluates the same instant query at a set of timestamps
+type MultiTimeAPI struct {
+	API
+	// Concurrency is the max number of instant queries outstanding at once
+	Concurrency int
+	// WorkerPool runs the instant queries (DefaultWorkerPool if nil)
+	WorkerPool *WorkerPool
+}
+
+func (m *MultiTimeAPI) pool() *WorkerPool {
+	if m.WorkerPool != nil {
+		return m.WorkerPool
+	}
+	return DefaultWorkerPool
+}
+
+// QueryMulti evaluates the query at each of the timestamps. The returned values
+// are in the same order as the timestamps. If any of the queries fails the
+// outstanding ones are canceled and the error is returned
+func (m *MultiTimeAPI) QueryMulti(ctx context.Context, query string, timestamps []time.Time) ([]model.Value, api.Warnings, error) {
+	childContext, childContextCancel := context.WithCancel(ctx)
+	defer childContextCancel()
+
+	concurrency := m.Concurrency
+	if concurrency <= 0 {
+		concurrency = DefaultQueryMultiConcurrency
+	}
+
+	type chanResult struct {
+		i        int
+		v        model.Value
+		warnings api.Warnings
+		err      error
+	}
+
+	sem := make(chan struct{}, concurrency)
+	resultChan := make(chan chanResult, len(timestamps))
+	go func() {
+		for i, ts := range timestamps {
+			select {
+			case sem <- struct{}{}:
+			case <-childContext.Done():
+				return
+			}
+			i, ts := i, ts
+			if err := m.pool().Go(childContext, func(ctx context.Context) {
+				defer func() { <-sem }()
+				v, w, err := m.API.Query(ctx, query, ts)
+				resultChan <- chanResult{i: i, v: v, warnings: w, err: err}
+			}); err != nil {
+				resultChan <- chanResult{i: i, err: err}
+				return
+			}
+		}
+	}()
+
+	results := make([]model.Value, len(timestamps))
+	warnings := make(promutil.WarningSet)
+	for range timestamps {
+		select {
+		case <-ctx.Done():
+			return nil, warnings.Warnings(), ctx.Err()
+
+		case ret := <-resultChan:
+			warnings.AddWarnings(ret.warnings)
+			if ret.err != nil {
+				return nil, warnings.Warnings(), ret.err
+			}
+			results[ret.i] = ret.v
+		}
+	}
+
+	return results, warnings.Warnings(), nil
+}
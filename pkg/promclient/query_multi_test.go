@@ -0,0 +1,83 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// timestampAPI returns a scalar of the query timestamp, with later timestamps
+// returning faster so that results complete out of order
+type timestampAPI struct {
+	API
+	last time.Time
+
+	l           sync.Mutex
+	current     int
+	maxObserved int
+}
+
+func (s *timestampAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	s.l.Lock()
+	s.current++
+	if s.current > s.maxObserved {
+		s.maxObserved = s.current
+	}
+	s.l.Unlock()
+
+	time.Sleep(s.last.Sub(ts) / time.Second * time.Millisecond)
+
+	s.l.Lock()
+	s.current--
+	s.l.Unlock()
+	return &model.Scalar{Value: model.SampleValue(ts.Unix()), Timestamp: model.TimeFromUnixNano(ts.UnixNano())}, nil, nil
+}
+
+func TestQueryMulti(t *testing.T) {
+	timestamps := make([]time.Time, 20)
+	for i := range timestamps {
+		timestamps[i] = time.Unix(int64(i), 0)
+	}
+
+	for _, concurrency := range []int{1, 3, 20} {
+		t.Run(fmt.Sprintf("concurrency=%d", concurrency), func(t *testing.T) {
+			stub := &timestampAPI{last: timestamps[len(timestamps)-1]}
+			m := &MultiTimeAPI{API: stub, Concurrency: concurrency}
+
+			results, _, err := m.QueryMulti(context.TODO(), "time()", timestamps)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(results) != len(timestamps) {
+				t.Fatalf("mismatch in number of results expected=%d actual=%d", len(timestamps), len(results))
+			}
+			for i, ts := range timestamps {
+				scalar, ok := results[i].(*model.Scalar)
+				if !ok {
+					t.Fatalf("unexpected result type: %v", results[i])
+				}
+				if int64(scalar.Value) != ts.Unix() {
+					t.Fatalf("result %d not aligned to its timestamp expected=%d actual=%v", i, ts.Unix(), scalar.Value)
+				}
+			}
+
+			if stub.maxObserved > concurrency {
+				t.Fatalf("too many concurrent queries expected<=%d actual=%d", concurrency, stub.maxObserved)
+			}
+		})
+	}
+}
+
+func TestQueryMultiError(t *testing.T) {
+	stub := &errorAPI{&timestampAPI{}, fmt.Errorf("some error")}
+	m := &MultiTimeAPI{API: stub}
+
+	if _, _, err := m.QueryMulti(context.TODO(), "time()", []time.Time{time.Unix(1, 0), time.Unix(2, 0)}); err == nil {
+		t.Fatalf("expected error")
+	}
+}
@@ -0,0 +1,54 @@
+package promclient
+
+import (
+	"context"
+	"sync"
+)
+
+// QueryStats are the stats of the evaluation of a query within promproxy, which
+// are returned with the result if the client asked for the stats of the query
+type QueryStats struct {
+	// Selects is the number of Selects the query made
+	Selects int `json:"selects"`
+	// MaxConcurrentSelects is the largest number of Selects in flight at once
+	MaxConcurrentSelects int `json:"maxConcurrentSelects"`
+}
+
+type queryStatsRecorderKey struct{}
+
+// WithQueryStatsRecorder returns a context in which the queriers of the query
+// record their stats in the returned QueryStatsRecorder
+func WithQueryStatsRecorder(ctx context.Context) (context.Context, *QueryStatsRecorder) {
+	r := &QueryStatsRecorder{}
+	return context.WithValue(ctx, queryStatsRecorderKey{}, r), r
+}
+
+// QueryStatsRecorderFromContext returns the QueryStatsRecorder of the context (nil
+// if there is none)
+func QueryStatsRecorderFromContext(ctx context.Context) *QueryStatsRecorder {
+	r, _ := ctx.Value(queryStatsRecorderKey{}).(*QueryStatsRecorder)
+	return r
+}
+
+// QueryStatsRecorder collects the QueryStats of a single query
+type QueryStatsRecorder struct {
+	l     sync.Mutex
+	stats QueryStats
+}
+
+// RecordSelects records the Selects of a querier of the query
+func (r *QueryStatsRecorder) RecordSelects(total, maxConcurrent int) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	r.stats.Selects += total
+	if maxConcurrent > r.stats.MaxConcurrentSelects {
+		r.stats.MaxConcurrentSelects = maxConcurrent
+	}
+}
+
+// Stats returns the stats recorded so far
+func (r *QueryStatsRecorder) Stats() QueryStats {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.stats
+}
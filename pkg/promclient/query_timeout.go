@@ -0,0 +1,44 @@
+package promclient
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+)
+
+// QueryTimeoutRoundTripper sets the timeout param of the query and query_range
+// calls to the time left until the deadline of their context, so the hosts stop
+// evaluating a query once the proxy no longer waits for it (rather than at their
+// own query timeout). Every prometheus 2.x supports the param, other hosts ignore
+// it. Calls without a deadline are sent as they are.
+type QueryTimeoutRoundTripper struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (q *QueryTimeoutRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch path.Base(req.URL.Path) {
+	case "query", "query_range":
+	default:
+		return q.RoundTripper.RoundTrip(req)
+	}
+	deadline, ok := req.Context().Deadline()
+	if !ok {
+		return q.RoundTripper.RoundTrip(req)
+	}
+	timeout := time.Until(deadline)
+	if timeout <= 0 {
+		return q.RoundTripper.RoundTrip(req)
+	}
+
+	// RoundTrippers must not modify the request, so the param is set on a copy
+	reqCopy := new(http.Request)
+	*reqCopy = *req
+	u := *req.URL
+	values := u.Query()
+	values.Set("timeout", strconv.FormatFloat(timeout.Seconds(), 'f', 3, 64))
+	u.RawQuery = values.Encode()
+	reqCopy.URL = &u
+	return q.RoundTripper.RoundTrip(reqCopy)
+}
@@ -0,0 +1,65 @@
+package promclient
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestQueryTimeoutRoundTripper(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		timeout time.Duration
+		// sent is whether the timeout param is expected
+		sent bool
+	}{
+		{name: "query", path: "/api/v1/query", timeout: time.Minute, sent: true},
+		{name: "query_range", path: "/prefix/api/v1/query_range", timeout: time.Minute, sent: true},
+		{name: "no deadline", path: "/api/v1/query"},
+		{name: "series", path: "/api/v1/series", timeout: time.Minute},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var timeout string
+			rt := &QueryTimeoutRoundTripper{RoundTripper: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				timeout = req.URL.Query().Get("timeout")
+				return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+			})}
+
+			ctx := context.Background()
+			if test.timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, test.timeout)
+				defer cancel()
+			}
+			req := httptest.NewRequest("GET", test.path+"?query=up&timeout=1h", nil).WithContext(ctx)
+			if _, err := rt.RoundTrip(req); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if req.URL.Query().Get("timeout") != "1h" {
+				t.Fatalf("the request was modified: %v", req.URL)
+			}
+
+			if !test.sent {
+				if timeout != "1h" {
+					t.Fatalf("mismatch in timeout expected=1h actual=%q", timeout)
+				}
+				return
+			}
+			seconds, err := strconv.ParseFloat(timeout, 64)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if seconds <= 0 || seconds > test.timeout.Seconds() {
+				t.Fatalf("mismatch in timeout expected<=%v actual=%vs", test.timeout, seconds)
+			}
+		})
+	}
+}
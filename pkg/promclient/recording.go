@@ -0,0 +1,270 @@
+package promclient
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// recordedArgs are the arguments of a recorded call, only those of the method are set
+type recordedArgs struct {
+	Label    string     `json:"label,omitempty"`
+	Query    string     `json:"query,omitempty"`
+	Time     *time.Time `json:"time,omitempty"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	Step     string     `json:"step,omitempty"`
+	Matches  []string   `json:"matches,omitempty"`
+	Matchers []string   `json:"matchers,omitempty"`
+}
+
+// recordedCall is a line of a recording
+type recordedCall struct {
+	Timestamp time.Time       `json:"timestamp"`
+	Method    string          `json:"method"`
+	Args      recordedArgs    `json:"args"`
+	ValueType model.ValueType `json:"value_type,omitempty"`
+	Response  json.RawMessage `json:"response,omitempty"`
+	Warnings  api.Warnings    `json:"warnings,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// NewRecordingAPI returns a RecordingAPI writing to w
+func NewRecordingAPI(a API, w io.Writer) *RecordingAPI {
+	return &RecordingAPI{API: a, enc: json.NewEncoder(w)}
+}
+
+// RecordingAPI writes every call to the wrapped API (method, arguments, response,
+// error and timestamp) as a line of JSON, so the traffic can be replayed with a
+// ReplayAPI. Series are recorded from the buffered Series call, so this doesn't
+// stream them.
+type RecordingAPI struct {
+	API
+	l   sync.Mutex
+	enc *json.Encoder
+}
+
+func (r *RecordingAPI) record(method string, args recordedArgs, v interface{}, w api.Warnings, err error) {
+	call := &recordedCall{
+		Timestamp: time.Now(),
+		Method:    method,
+		Args:      args,
+		Warnings:  w,
+	}
+	if err != nil {
+		call.Error = err.Error()
+	}
+	if value, ok := v.(model.Value); ok && value != nil {
+		call.ValueType = value.Type()
+	}
+	if v != nil {
+		b, marshalErr := json.Marshal(v)
+		if marshalErr != nil {
+			logger.Errorf("Error recording %s call: %v", method, marshalErr)
+			return
+		}
+		call.Response = b
+	}
+
+	r.l.Lock()
+	defer r.l.Unlock()
+	if encodeErr := r.enc.Encode(call); encodeErr != nil {
+		logger.Errorf("Error recording %s call: %v", method, encodeErr)
+	}
+}
+
+func matchersToStrings(matchers []*labels.Matcher) []string {
+	s := make([]string, len(matchers))
+	for i, m := range matchers {
+		s[i] = m.String()
+	}
+	return s
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (r *RecordingAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	v, w, err := r.API.LabelNames(ctx)
+	r.record("LabelNames", recordedArgs{}, v, w, err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (r *RecordingAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	v, w, err := r.API.LabelValues(ctx, label)
+	r.record("LabelValues", recordedArgs{Label: label}, v, w, err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (r *RecordingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.Query(ctx, query, ts)
+	r.record("Query", recordedArgs{Query: query, Time: &ts}, v, w, err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (r *RecordingAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.QueryRange(ctx, query, rng)
+	r.record("QueryRange", recordedArgs{Query: query, Start: &rng.Start, End: &rng.End, Step: rng.Step.String()}, v, w, err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (r *RecordingAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	v, w, err := r.API.Series(ctx, matches, startTime, endTime)
+	r.record("Series", recordedArgs{Matches: matches, Start: &startTime, End: &endTime}, v, w, err)
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *RecordingAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	v, w, err := r.API.GetValue(ctx, start, end, matchers)
+	r.record("GetValue", recordedArgs{Start: &start, End: &end, Matchers: matchersToStrings(matchers)}, v, w, err)
+	return v, w, err
+}
+
+// NewReplayAPI returns a ReplayAPI for the recording read from r
+func NewReplayAPI(r io.Reader) (*ReplayAPI, error) {
+	var calls []*recordedCall
+	scanner := bufio.NewScanner(r)
+	// Responses can be large
+	scanner.Buffer(make([]byte, 64*1024), 1<<30)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		call := &recordedCall{}
+		if err := json.Unmarshal(scanner.Bytes(), call); err != nil {
+			return nil, fmt.Errorf("invalid recorded call %d: %v", len(calls)+1, err)
+		}
+		calls = append(calls, call)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return &ReplayAPI{calls: calls}, nil
+}
+
+// ReplayAPI responds to calls with the responses of a recording (see RecordingAPI)
+// in sequence. The arguments of the calls aren't checked, but the method has to
+// match that of the next recorded call. Recorded errors are returned with their
+// message only.
+type ReplayAPI struct {
+	l     sync.Mutex
+	calls []*recordedCall
+	next  int
+}
+
+// Remaining returns the number of recorded calls which haven't been replayed
+func (r *ReplayAPI) Remaining() int {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return len(r.calls) - r.next
+}
+
+// replay decodes the response of the next call into v (if it has one)
+func (r *ReplayAPI) replay(method string, v interface{}) (api.Warnings, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	return r.replayLocked(method, v)
+}
+
+// replayLocked is replay, with the lock already held
+func (r *ReplayAPI) replayLocked(method string, v interface{}) (api.Warnings, error) {
+	if r.next >= len(r.calls) {
+		return nil, fmt.Errorf("replay of %s: no recorded calls left", method)
+	}
+	call := r.calls[r.next]
+	if call.Method != method {
+		return nil, fmt.Errorf("replay of %s: next recorded call is %s", method, call.Method)
+	}
+	r.next++
+
+	if len(call.Response) > 0 && string(call.Response) != "null" {
+		if err := json.Unmarshal(call.Response, v); err != nil {
+			return nil, fmt.Errorf("replay of %s: invalid response: %v", method, err)
+		}
+	}
+	if call.Error != "" {
+		return call.Warnings, errors.New(call.Error)
+	}
+	return call.Warnings, nil
+}
+
+// replayValue replays a call returning a model.Value. The lock is held from
+// reading the type of the value of the next call until it was replayed, so a
+// concurrent call can't replay it in between.
+func (r *ReplayAPI) replayValue(method string) (model.Value, api.Warnings, error) {
+	r.l.Lock()
+	defer r.l.Unlock()
+	var valueType model.ValueType
+	if r.next < len(r.calls) {
+		valueType = r.calls[r.next].ValueType
+	}
+
+	var v model.Value
+	switch valueType {
+	case model.ValMatrix:
+		v = &model.Matrix{}
+	case model.ValVector:
+		v = &model.Vector{}
+	case model.ValScalar:
+		v = &model.Scalar{}
+	case model.ValString:
+		v = &model.String{}
+	}
+	w, err := r.replayLocked(method, v)
+	switch typed := v.(type) {
+	case *model.Matrix:
+		v = *typed
+	case *model.Vector:
+		v = *typed
+	}
+	return v, w, err
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (r *ReplayAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	var v []string
+	w, err := r.replay("LabelNames", &v)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (r *ReplayAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	var v model.LabelValues
+	w, err := r.replay("LabelValues", &v)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (r *ReplayAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	return r.replayValue("Query")
+}
+
+// QueryRange performs a query for the given range.
+func (r *ReplayAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	return r.replayValue("QueryRange")
+}
+
+// Series finds series by label matchers.
+func (r *ReplayAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	var v []model.LabelSet
+	w, err := r.replay("Series", &v)
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *ReplayAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return r.replayValue("GetValue")
+}
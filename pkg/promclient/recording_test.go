@@ -0,0 +1,202 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// recordedAPI returns canned responses for all methods
+type recordedAPI struct{}
+
+func (r *recordedAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	return []string{"__name__", "job"}, nil, nil
+}
+
+func (r *recordedAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if label == "missing" {
+		return nil, nil, errors.New("no such label")
+	}
+	return model.LabelValues{"a", "b"}, api.Warnings{"partial"}, nil
+}
+
+func (r *recordedAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	switch query {
+	case "scalar":
+		return &model.Scalar{Value: 1.5, Timestamp: model.TimeFromUnix(ts.Unix())}, nil, nil
+	case "string":
+		return &model.String{Value: "foo", Timestamp: model.TimeFromUnix(ts.Unix())}, nil, nil
+	}
+	return model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "a"}, Value: 1, Timestamp: model.TimeFromUnix(ts.Unix())},
+		{Metric: model.Metric{"__name__": "up", "job": "b"}, Value: 0.1, Timestamp: model.TimeFromUnix(ts.Unix())},
+	}, nil, nil
+}
+
+func (r *recordedAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	return model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "up", "job": "a"},
+			Values: []model.SamplePair{
+				{Timestamp: model.TimeFromUnix(rng.Start.Unix()), Value: 1},
+				{Timestamp: model.TimeFromUnix(rng.End.Unix()), Value: 1.0 / 3},
+			},
+		},
+	}, nil, nil
+}
+
+func (r *recordedAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	return []model.LabelSet{{"__name__": "up", "job": "a"}}, nil, nil
+}
+
+func (r *recordedAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "up"},
+			Values: []model.SamplePair{{Timestamp: model.TimeFromUnix(start.Unix()), Value: 2}},
+		},
+	}, nil, nil
+}
+
+type recordedResult struct {
+	v   interface{}
+	w   api.Warnings
+	err string
+}
+
+func TestRecordingReplay(t *testing.T) {
+	start := time.Unix(1560000000, 0)
+	end := start.Add(time.Hour)
+	matcher := labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")
+
+	calls := []func(a API) recordedResult{
+		func(a API) recordedResult {
+			v, w, err := a.LabelNames(context.TODO())
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.LabelValues(context.TODO(), "job")
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.LabelValues(context.TODO(), "missing")
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.Query(context.TODO(), "up", end)
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.Query(context.TODO(), "scalar", end)
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.Query(context.TODO(), "string", end)
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.QueryRange(context.TODO(), "up", v1.Range{Start: start, End: end, Step: time.Minute})
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.Series(context.TODO(), []string{"up"}, start, end)
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.GetValue(context.TODO(), start, end, []*labels.Matcher{matcher})
+			return recordedResult{v, w, errString(err)}
+		},
+		func(a API) recordedResult {
+			v, w, err := a.LabelNames(context.TODO())
+			return recordedResult{v, w, errString(err)}
+		},
+	}
+
+	buf := &bytes.Buffer{}
+	recording := NewRecordingAPI(&recordedAPI{}, buf)
+	recorded := make([]recordedResult, len(calls))
+	for i, call := range calls {
+		recorded[i] = call(recording)
+	}
+
+	replay, err := NewReplayAPI(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("error loading recording: %v", err)
+	}
+	if replay.Remaining() != len(calls) {
+		t.Fatalf("mismatch in recorded calls expected=%d actual=%d", len(calls), replay.Remaining())
+	}
+	for i, call := range calls {
+		replayed := call(replay)
+		if !reflect.DeepEqual(replayed, recorded[i]) {
+			t.Fatalf("mismatch in call %d expected=%#v actual=%#v", i, recorded[i], replayed)
+		}
+	}
+
+	// The recording is exhausted
+	if _, _, err := replay.LabelNames(context.TODO()); err == nil {
+		t.Fatalf("expected error once the recording is exhausted")
+	}
+}
+
+func TestReplayMethodMismatch(t *testing.T) {
+	buf := &bytes.Buffer{}
+	NewRecordingAPI(&recordedAPI{}, buf).LabelNames(context.TODO())
+
+	replay, err := NewReplayAPI(buf)
+	if err != nil {
+		t.Fatalf("error loading recording: %v", err)
+	}
+	if _, _, err := replay.Query(context.TODO(), "up", time.Now()); err == nil {
+		t.Fatalf("expected error for a call out of sequence")
+	}
+	if replay.Remaining() != 1 {
+		t.Fatalf("mismatch in remaining calls expected=%d actual=%d", 1, replay.Remaining())
+	}
+}
+
+// TestReplayConcurrent replays queries of different value types concurrently,
+// each has to be decoded as the type of the call it replays
+func TestReplayConcurrent(t *testing.T) {
+	buf := &bytes.Buffer{}
+	recording := NewRecordingAPI(&recordedAPI{}, buf)
+	for i := 0; i < 50; i++ {
+		recording.Query(context.TODO(), "up", time.Unix(1, 0))
+		recording.Query(context.TODO(), "scalar", time.Unix(1, 0))
+	}
+
+	replay, err := NewReplayAPI(buf)
+	if err != nil {
+		t.Fatalf("error loading recording: %v", err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := replay.Query(context.TODO(), "", time.Time{}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if replay.Remaining() != 0 {
+		t.Fatalf("mismatch in remaining calls expected=%d actual=%d", 0, replay.Remaining())
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
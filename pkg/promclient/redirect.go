@@ -0,0 +1,140 @@
+package promclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+)
+
+// DefaultMaxRedirects is the max number of redirects followed if not configured,
+// as in net/http
+const DefaultMaxRedirects = 10
+
+// sensitiveHeaders are the headers which aren't sent on to other hosts
+var sensitiveHeaders = []string{"Authorization", "Www-Authenticate", "Cookie", "Cookie2"}
+
+// ErrRedirectRefused is returned for the redirects which aren't followed
+type ErrRedirectRefused struct {
+	From   string
+	To     string
+	Reason string
+}
+
+func (e *ErrRedirectRefused) Error() string {
+	return fmt.Sprintf("refused redirect from %s to %s: %s", e.From, e.To, e.Reason)
+}
+
+// RedirectRoundTripper follows the redirects of the responses (e.g. of a load
+// balancer sending the request to the leader) up to MaxRedirects
+// (DefaultMaxRedirects if 0, none if negative). It has to be the innermost of the
+// RoundTrippers, so the redirected requests keep all the headers set by the
+// others, including those for auth and tenancy. Redirects to other hosts are
+// refused unless CrossHost is set, in which case the sensitive headers (e.g.
+// Authorization) are dropped as they would be by net/http.
+//
+// As the redirects are followed here, the http.Client never sees them (the API
+// clients use their own http.Client with just the transport).
+type RedirectRoundTripper struct {
+	http.RoundTripper
+	MaxRedirects int
+	CrossHost    bool
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *RedirectRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	maxRedirects := r.MaxRedirects
+	if maxRedirects == 0 {
+		maxRedirects = DefaultMaxRedirects
+	}
+
+	for redirects := 0; ; redirects++ {
+		resp, err := r.RoundTripper.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		location := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || location == "" {
+			return resp, nil
+		}
+		// Drain the body, so the connection is reused
+		io.Copy(ioutil.Discard, io.LimitReader(resp.Body, 4<<10))
+		resp.Body.Close()
+
+		target, err := req.URL.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redirect location %q: %v", location, err)
+		}
+		refused := func(reason string) error {
+			return &ErrRedirectRefused{From: req.URL.String(), To: target.String(), Reason: reason}
+		}
+		if maxRedirects < 0 {
+			return nil, refused("redirects are disabled")
+		}
+		if redirects >= maxRedirects {
+			return nil, refused(fmt.Sprintf("stopped after %d redirects", maxRedirects))
+		}
+		crossHost := target.Scheme != req.URL.Scheme || target.Host != req.URL.Host
+		if crossHost && !r.CrossHost {
+			return nil, refused("cross-host redirects are disabled")
+		}
+
+		if req, err = redirectRequest(req, resp.StatusCode, target.String(), crossHost); err != nil {
+			return nil, refused(err.Error())
+		}
+	}
+}
+
+func isRedirect(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	default:
+		return false
+	}
+}
+
+// redirectRequest returns the request following the redirect of req to target, as
+// net/http does: 307 and 308 repeat the request with its body, the others change
+// it to a GET without one
+func redirectRequest(req *http.Request, code int, target string, crossHost bool) (*http.Request, error) {
+	method := req.Method
+	var body io.ReadCloser
+	keepBody := code == http.StatusTemporaryRedirect || code == http.StatusPermanentRedirect
+	if keepBody {
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, fmt.Errorf("the request body can't be sent again")
+			}
+			var err error
+			if body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+	} else if method != http.MethodGet && method != http.MethodHead {
+		method = http.MethodGet
+	}
+
+	redirected, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	redirected = redirected.WithContext(req.Context())
+	if keepBody {
+		redirected.ContentLength = req.ContentLength
+		redirected.GetBody = req.GetBody
+	}
+	for k, v := range req.Header {
+		redirected.Header[k] = v
+	}
+	if !keepBody {
+		redirected.Header.Del("Content-Type")
+		redirected.Header.Del("Content-Length")
+	}
+	if crossHost {
+		for _, k := range sensitiveHeaders {
+			redirected.Header.Del(k)
+		}
+	}
+	return redirected, nil
+}
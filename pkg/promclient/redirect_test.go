@@ -0,0 +1,103 @@
+package promclient
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRoundTripper(t *testing.T) {
+	// The leader echoes the headers and body of the request it got
+	leaderHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("X-Method", r.Method)
+		w.Header().Set("X-Authorization", r.Header.Get("Authorization"))
+		w.Header().Set("X-Tenant", r.Header.Get("X-Scope-OrgID"))
+		w.Write(body)
+	})
+	other := httptest.NewServer(leaderHandler)
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.Handle("/leader/api/v1/query", leaderHandler)
+	redirectTo := func(code int, location string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, location, code)
+		}
+	}
+	mux.Handle("/temporary", redirectTo(http.StatusTemporaryRedirect, "/leader/api/v1/query"))
+	mux.Handle("/found", redirectTo(http.StatusFound, "/leader/api/v1/query"))
+	mux.Handle("/loop", redirectTo(http.StatusTemporaryRedirect, "/loop"))
+	mux.Handle("/other", redirectTo(http.StatusTemporaryRedirect, other.URL+"/api/v1/query"))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		name         string
+		path         string
+		maxRedirects int
+		crossHost    bool
+		// err is whether the redirect is refused
+		err    bool
+		method string
+		auth   string
+		body   string
+	}{
+		{name: "same host", path: "/temporary", method: "POST", auth: "Bearer secret", body: "query=up"},
+		{name: "same host found", path: "/found", method: "GET", auth: "Bearer secret"},
+		{name: "disabled", path: "/temporary", maxRedirects: -1, err: true},
+		{name: "loop", path: "/loop", err: true},
+		{name: "loop limit", path: "/loop", maxRedirects: 3, err: true},
+		{name: "cross host refused", path: "/other", err: true},
+		{name: "cross host", path: "/other", crossHost: true, method: "POST", body: "query=up"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: &RedirectRoundTripper{
+				RoundTripper: http.DefaultTransport,
+				MaxRedirects: test.maxRedirects,
+				CrossHost:    test.crossHost,
+			}}
+			req, err := http.NewRequest("POST", srv.URL+test.path, strings.NewReader("query=up"))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.Header.Set("Authorization", "Bearer secret")
+			req.Header.Set("X-Scope-OrgID", "team-a")
+
+			resp, err := client.Do(req)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in err expected=%v actual=%v", test.err, err)
+			}
+			if err != nil {
+				if _, ok := err.(*url.Error).Err.(*ErrRedirectRefused); !ok {
+					t.Fatalf("mismatch in error type expected=*ErrRedirectRefused actual=%T", err.(*url.Error).Err)
+				}
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("mismatch in status expected=%d actual=%d", http.StatusOK, resp.StatusCode)
+			}
+			if method := resp.Header.Get("X-Method"); method != test.method {
+				t.Fatalf("mismatch in method expected=%v actual=%v", test.method, method)
+			}
+			if auth := resp.Header.Get("X-Authorization"); auth != test.auth {
+				t.Fatalf("mismatch in auth header expected=%q actual=%q", test.auth, auth)
+			}
+			if tenant := resp.Header.Get("X-Tenant"); tenant != "team-a" {
+				t.Fatalf("mismatch in tenant header expected=%q actual=%q", "team-a", tenant)
+			}
+			if string(body) != test.body {
+				t.Fatalf("mismatch in body expected=%q actual=%q", test.body, body)
+			}
+		})
+	}
+}
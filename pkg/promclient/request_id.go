@@ -0,0 +1,64 @@
+package promclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+)
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context carrying the ID of the request which all
+// backend calls made with it are on behalf of
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationIDFromContext returns the correlation ID of the context (if there is one)
+func CorrelationIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// NewRequestID returns a new random request ID
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// RequestIDRoundTripper sets a unique ID in `Header` on every request it sends,
+// logging it alongside the correlation ID of the request context. This way a
+// sub-query can be found in the backend's own logs.
+type RequestIDRoundTripper struct {
+	Header       string
+	RoundTripper http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction with a new request ID
+func (r *RequestIDRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := NewRequestID()
+
+	// RoundTrippers must not modify the request, so we set the header on a copy
+	reqCopy := new(http.Request)
+	*reqCopy = *req
+	reqCopy.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		reqCopy.Header[k] = v
+	}
+	reqCopy.Header.Set(r.Header, id)
+
+	logger.WithFields(logrus.Fields{
+		"correlation_id": CorrelationIDFromContext(req.Context()),
+		"request_id":     id,
+		"backend":        req.URL.Host,
+		"path":           req.URL.Path,
+	}).Debug("Backend request")
+
+	return r.RoundTripper.RoundTrip(reqCopy)
+}
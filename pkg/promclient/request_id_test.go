@@ -0,0 +1,80 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+)
+
+func TestRequestIDRoundTripper(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	logrus.SetLevel(logrus.DebugLevel)
+	defer logrus.SetLevel(logrus.InfoLevel)
+
+	var (
+		l   sync.Mutex
+		ids []string
+	)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l.Lock()
+		ids = append(ids, r.Header.Get("X-Request-ID"))
+		l.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	})
+
+	rt := &RequestIDRoundTripper{Header: "X-Request-ID", RoundTripper: http.DefaultTransport}
+	apis := make([]API, 3)
+	for i := range apis {
+		srv := httptest.NewServer(handler)
+		defer srv.Close()
+		client, err := api.NewClient(api.Config{Address: srv.URL, RoundTripper: rt})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		apis[i] = &PromAPIV1{v1.NewAPI(client)}
+	}
+
+	multi := NewMultiAPI(apis, model.Time(0), nil, 1)
+	ctx := WithCorrelationID(context.TODO(), "query-1234")
+	if _, _, err := multi.Query(ctx, "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ids) != len(apis) {
+		t.Fatalf("mismatch in number of backend requests expected=%d actual=%d", len(apis), len(ids))
+	}
+	seen := make(map[string]struct{})
+	for _, id := range ids {
+		if id == "" {
+			t.Fatalf("backend request missing request ID")
+		}
+		if _, ok := seen[id]; ok {
+			t.Fatalf("request ID %s sent to multiple backends", id)
+		}
+		seen[id] = struct{}{}
+
+		// Each ID is logged along with the correlation ID
+		found := false
+		for _, line := range strings.Split(buf.String(), "\n") {
+			if strings.Contains(line, "request_id="+id) && strings.Contains(line, "correlation_id=query-1234") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("mapping for request ID %s not logged: %s", id, buf.String())
+		}
+	}
+}
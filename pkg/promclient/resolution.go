@@ -0,0 +1,109 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ResolutionAPI handles the stitching of downstreams which store data at different
+// resolutions -- such as raw recent data and downsampled long-term storage, split
+// by time range. When a query spans past the edge of the downstream's window
+// (meaning some other tier serves the rest) a warning describing the resolution
+// is added, and the data is optionally resampled to a coarser resolution.
+type ResolutionAPI struct {
+	API
+	// Resolution is the interval between samples in the downstream. If set, the
+	// fetch of a query starting before the window (served by another tier) starts
+	// this much before the edge of the window, so that range-vector functions at the
+	// boundary have the sample before it
+	Resolution time.Duration
+	// Resample is the resolution to downsample the data to when the query spans
+	// other tiers
+	Resample time.Duration
+	// Window returns the time range the downstream serves, zero times are unbounded
+	Window func() (time.Time, time.Time)
+}
+
+// spansTiers returns whether the given range extends past the window of this downstream
+func (r *ResolutionAPI) spansTiers(start, end time.Time) bool {
+	if r.Window == nil {
+		return false
+	}
+	wStart, wEnd := r.Window()
+	return (!wStart.IsZero() && start.Before(wStart)) || (!wEnd.IsZero() && end.After(wEnd))
+}
+
+// fetchStart returns the start of the fetch for a query starting at start. The
+// part of the range before the window is served by another tier, so only the
+// Resolution before the edge of the window is fetched from this downstream.
+func (r *ResolutionAPI) fetchStart(start time.Time) time.Time {
+	if r.Window == nil || r.Resolution <= 0 {
+		return start
+	}
+	wStart, _ := r.Window()
+	if boundary := wStart.Add(-r.Resolution); !wStart.IsZero() && boundary.After(start) {
+		return boundary
+	}
+	return start
+}
+
+// warning returns the warning describing the resolution of this downstream
+func (r *ResolutionAPI) warning() string {
+	if r.Resample > 0 {
+		return fmt.Sprintf("mixed resolution: data resampled to %s to match other tiers", model.Duration(r.Resample))
+	}
+	return fmt.Sprintf("mixed resolution: part of the query range is served at %s resolution", model.Duration(r.Resolution))
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *ResolutionAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	val, warnings, err := r.API.GetValue(ctx, r.fetchStart(start), end, matchers)
+	if err != nil || val == nil || !r.spansTiers(start, end) {
+		return val, warnings, err
+	}
+
+	if r.Resample > 0 {
+		if matrix, ok := val.(model.Matrix); ok {
+			val = ResampleMatrix(matrix, r.Resample)
+		}
+	}
+
+	return val, append(warnings, r.warning()), nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (r *ResolutionAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, r.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (r *ResolutionAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, r.API, startTime, endTime)
+}
+
+// ResampleMatrix downsamples the matrix to (at most) one sample per step, keeping the
+// last sample within each step-aligned bucket
+func ResampleMatrix(matrix model.Matrix, step time.Duration) model.Matrix {
+	stepMs := model.Time(step / time.Millisecond)
+	if stepMs <= 0 {
+		return matrix
+	}
+
+	for _, stream := range matrix {
+		values := make([]model.SamplePair, 0, len(stream.Values))
+		for _, v := range stream.Values {
+			if len(values) > 0 && values[len(values)-1].Timestamp/stepMs == v.Timestamp/stepMs {
+				values[len(values)-1] = v
+			} else {
+				values = append(values, v)
+			}
+		}
+		stream.Values = values
+	}
+	return matrix
+}
@@ -0,0 +1,111 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// rawAPI returns a single series with a sample every 15s in the requested range
+type rawAPI struct {
+	API
+	start time.Time
+}
+
+func (r *rawAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	r.start = start
+	stream := &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "up"}}
+	for t := model.TimeFromUnixNano(start.UnixNano()); !t.After(model.TimeFromUnixNano(end.UnixNano())); t = t.Add(15 * time.Second) {
+		stream.Values = append(stream.Values, model.SamplePair{Timestamp: t, Value: 1})
+	}
+	return model.Matrix{stream}, nil, nil
+}
+
+func TestResolutionAPI(t *testing.T) {
+	boundary := time.Unix(3600, 0)
+	hotWindow := func() (time.Time, time.Time) { return boundary, time.Time{} }
+
+	// Query within the hot tier, nothing changes
+	raw := &rawAPI{}
+	hot := &ResolutionAPI{API: raw, Resample: 5 * time.Minute, Window: hotWindow}
+	v, warnings, err := hot.GetValue(context.TODO(), boundary, boundary.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("unexpected warnings: %v", warnings)
+	}
+	if l := len(v.(model.Matrix)[0].Values); l != 241 {
+		t.Fatalf("mismatch in number of samples expected=%d actual=%d", 241, l)
+	}
+
+	// Query spanning tiers is resampled to the coarser resolution
+	v, warnings, err = hot.GetValue(context.TODO(), boundary.Add(-time.Hour), boundary.Add(time.Hour), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("missing mixed resolution warning: %v", warnings)
+	}
+	if l := len(v.(model.Matrix)[0].Values); l != 25 {
+		t.Fatalf("mismatch in number of samples expected=%d actual=%d", 25, l)
+	}
+
+	// A query spanning the boundary (through the time filter of the tier) is fetched
+	// from at most the resolution before the boundary, and a query within the tier
+	// isn't extended
+	filtered := &AbsoluteTimeFilter{
+		API:   &ResolutionAPI{API: raw, Resolution: 5 * time.Minute, Window: hotWindow},
+		Start: boundary,
+	}
+	tests := []struct {
+		start, end time.Time
+		fetchStart time.Time
+		samples    int
+	}{
+		{
+			start:      boundary.Add(-time.Hour),
+			end:        boundary.Add(time.Hour),
+			fetchStart: boundary.Add(-5 * time.Minute),
+			samples:    261,
+		},
+		{
+			start:      boundary.Add(-2 * time.Minute),
+			end:        boundary.Add(time.Hour),
+			fetchStart: boundary.Add(-2 * time.Minute),
+			samples:    249,
+		},
+		{
+			start:      boundary.Add(time.Minute),
+			end:        boundary.Add(time.Hour),
+			fetchStart: boundary.Add(time.Minute),
+			samples:    237,
+		},
+	}
+	for _, test := range tests {
+		v, _, err := filtered.GetValue(context.TODO(), test.start, test.end, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !raw.start.Equal(test.fetchStart) {
+			t.Fatalf("mismatch in fetch start expected=%v actual=%v", test.fetchStart, raw.start)
+		}
+		if l := len(v.(model.Matrix)[0].Values); l != test.samples {
+			t.Fatalf("mismatch in number of samples expected=%d actual=%d", test.samples, l)
+		}
+	}
+
+	// A query before the boundary is left to the other tier
+	raw.start = time.Time{}
+	v, _, err = filtered.GetValue(context.TODO(), boundary.Add(-2*time.Hour), boundary.Add(-time.Hour), nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v != nil || !raw.start.IsZero() {
+		t.Fatalf("expected the query to be filtered, got: %v", v)
+	}
+}
@@ -0,0 +1,386 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/cache"
+)
+
+// The reasons a result is degraded, in which case it isn't cached
+const (
+	// DegradedDeadline is a result produced once the deadline (or latency budget)
+	// of the call had fired or was within the DeadlineMargin
+	DegradedDeadline = "deadline"
+	// DegradedIncomplete is a result which not all the expected backends
+	// contributed to (see Completeness)
+	DegradedIncomplete = "incomplete"
+	// DegradedBackendError is a result with the warning of a backend which failed
+	// (see BackendWarning)
+	DegradedBackendError = "backend_error"
+	// DegradedTruncated is a result truncated by a limit (see SeriesLimitWarning)
+	DegradedTruncated = "truncated"
+	// DegradedWarnings is a result with any other warning
+	DegradedWarnings = "warnings"
+)
+
+// ResultCacheConfig configures a ResultCacheAPI
+type ResultCacheConfig struct {
+	// TTL is how long a result is served from the cache
+	TTL time.Duration `yaml:"ttl"`
+	// DeadlineMargin is the time left until the deadline of a call under which its
+	// result is considered degraded, as the downstreams may have cut it short
+	DeadlineMargin time.Duration `yaml:"deadline_margin"`
+	// Memory configures the in-memory store of the results
+	Memory cache.MemoryConfig `yaml:"memory"`
+}
+
+// DefaultResultCacheConfig is the ResultCacheConfig used for unset fields
+var DefaultResultCacheConfig = ResultCacheConfig{
+	TTL:            time.Minute,
+	DeadlineMargin: time.Second,
+	Memory:         cache.DefaultMemoryConfig,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ResultCacheConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultResultCacheConfig
+	type plain ResultCacheConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c ResultCacheConfig) Validate() error {
+	if c.TTL <= 0 {
+		return fmt.Errorf("result cache ttl must be positive")
+	}
+	if c.DeadlineMargin < 0 {
+		return fmt.Errorf("result cache deadline_margin must not be negative")
+	}
+	return c.Memory.Validate()
+}
+
+// ResultDegraded returns why the result of a call made with ctx, which returned
+// the warnings w and had the completeness c (if recorded), is degraded. It is
+// empty if the result is complete.
+func ResultDegraded(ctx context.Context, margin time.Duration, w api.Warnings, c *Completeness) string {
+	if ctx.Err() != nil {
+		return DegradedDeadline
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < margin {
+		return DegradedDeadline
+	}
+	if budget := LatencyBudgetFromContext(ctx); budget != nil && budget.Remaining() < margin {
+		return DegradedDeadline
+	}
+	if c != nil {
+		if score, ok := c.Score(); ok && score < 1 {
+			return DegradedIncomplete
+		}
+	}
+	reason := ""
+	for _, warning := range w {
+		if strings.Contains(warning, SeriesLimitWarning) {
+			return DegradedTruncated
+		}
+		if _, ok := ParseBackendWarning(warning); ok {
+			reason = DegradedBackendError
+		} else if reason == "" {
+			reason = DegradedWarnings
+		}
+	}
+	return reason
+}
+
+// NewResultCacheAPI returns a ResultCacheAPI storing the results in an empty
+// MemoryCache of the config
+func NewResultCacheAPI(a API, cfg ResultCacheConfig) *ResultCacheAPI {
+	return &ResultCacheAPI{
+		API:   a,
+		cfg:   cfg,
+		cache: cache.NewMemoryCache(cfg.Memory),
+		stats: DefaultResultCacheStats,
+	}
+}
+
+// ResultCacheAPI serves the results of Query, QueryRange and Series calls from a
+// cache for the TTL of the config. Only complete results are cached: a result
+// which is degraded (see ResultDegraded), e.g. because a backend failed and the
+// partial response was accepted, or the deadline cut the call short, is returned
+// as it is but not cached, so the next call asks the downstreams again rather
+// than serving the degraded result for the TTL. Calls which fail aren't cached
+// either.
+//
+// The results are cached by the tenant and the virtual proxy of the call, as
+// well as its params.
+type ResultCacheAPI struct {
+	API
+	cfg   ResultCacheConfig
+	cache cache.CacheBackend
+	stats *ResultCacheStats
+}
+
+// resultCacheEntry is the encoding of a cached model.Value
+type resultCacheEntry struct {
+	Type  model.ValueType `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// cacheKey returns the cache key of a call made with ctx
+func (c *ResultCacheAPI) cacheKey(ctx context.Context, method string, params ...string) string {
+	parts := append([]string{method, TenantFromContext(ctx), VirtualProxyFromContext(ctx)}, params...)
+	return strings.Join(parts, "\x00")
+}
+
+// call serves the result of the key from the cache (decoding it with decode), or
+// makes the call with fn and caches its result (encoded with encode) if it isn't
+// degraded. The completeness of the call is recorded on its own, so earlier calls
+// of the request don't count against it, and then added to the completeness of
+// ctx.
+func (c *ResultCacheAPI) call(ctx context.Context, method, key string, decode func([]byte) error, encode func() ([]byte, error), fn func(context.Context) (api.Warnings, error)) (api.Warnings, error) {
+	if b, ok := c.cache.Get(key); ok {
+		if err := decode(b); err == nil {
+			c.stats.hit(method)
+			return nil, nil
+		}
+		c.cache.Delete(key)
+	}
+	c.stats.miss(method)
+
+	callCtx, completeness := WithCompleteness(ctx)
+	w, err := fn(callCtx)
+	if parent := CompletenessFromContext(ctx); parent != nil {
+		completeness.mu.Lock()
+		expected, responded := completeness.expected, completeness.responded
+		completeness.mu.Unlock()
+		parent.add(expected, responded)
+	}
+	if err != nil {
+		return w, err
+	}
+
+	if reason := ResultDegraded(ctx, c.cfg.DeadlineMargin, w, completeness); reason != "" {
+		c.stats.skip(method, reason)
+		return w, nil
+	}
+	b, err := encode()
+	if err != nil {
+		logger.WithField("error", err).Warn("Error encoding a result for the result cache")
+		return w, nil
+	}
+	c.cache.Set(key, b, c.cfg.TTL)
+	return w, nil
+}
+
+// value makes a call returning a model.Value through the cache
+func (c *ResultCacheAPI) value(ctx context.Context, method, key string, fn func(context.Context) (model.Value, api.Warnings, error)) (model.Value, api.Warnings, error) {
+	var v model.Value
+	w, err := c.call(ctx, method, key,
+		func(b []byte) (err error) {
+			v, err = decodeResultCacheEntry(b)
+			return err
+		},
+		func() ([]byte, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			return json.Marshal(resultCacheEntry{Type: v.Type(), Value: b})
+		},
+		func(ctx context.Context) (w api.Warnings, err error) {
+			v, w, err = fn(ctx)
+			return w, err
+		},
+	)
+	return v, w, err
+}
+
+// decodeResultCacheEntry decodes a model.Value cached by value
+func decodeResultCacheEntry(b []byte) (model.Value, error) {
+	var entry resultCacheEntry
+	if err := json.Unmarshal(b, &entry); err != nil {
+		return nil, err
+	}
+	var v model.Value
+	switch entry.Type {
+	case model.ValVector:
+		v = &model.Vector{}
+	case model.ValMatrix:
+		v = &model.Matrix{}
+	case model.ValScalar:
+		v = &model.Scalar{}
+	case model.ValString:
+		v = &model.String{}
+	default:
+		return nil, fmt.Errorf("unknown cached value type %q", entry.Type)
+	}
+	if err := json.Unmarshal(entry.Value, v); err != nil {
+		return nil, err
+	}
+	switch typed := v.(type) {
+	case *model.Vector:
+		return *typed, nil
+	case *model.Matrix:
+		return *typed, nil
+	}
+	return v, nil
+}
+
+// Query performs a query for the given time.
+func (c *ResultCacheAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	key := c.cacheKey(ctx, "query", query,
+		strconv.FormatInt(ts.UnixNano(), 10),
+		strconv.Itoa(QueryLimitFromContext(ctx)),
+	)
+	return c.value(ctx, "query", key, func(ctx context.Context) (model.Value, api.Warnings, error) {
+		return c.API.Query(ctx, query, ts)
+	})
+}
+
+// QueryRange performs a query for the given range.
+func (c *ResultCacheAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	key := c.cacheKey(ctx, "query_range", query,
+		strconv.FormatInt(r.Start.UnixNano(), 10),
+		strconv.FormatInt(r.End.UnixNano(), 10),
+		strconv.FormatInt(int64(r.Step), 10),
+		strconv.Itoa(QueryLimitFromContext(ctx)),
+	)
+	return c.value(ctx, "query_range", key, func(ctx context.Context) (model.Value, api.Warnings, error) {
+		return c.API.QueryRange(ctx, query, r)
+	})
+}
+
+// Series finds series by label matchers.
+func (c *ResultCacheAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	params := append([]string{
+		strconv.FormatInt(startTime.UnixNano(), 10),
+		strconv.FormatInt(endTime.UnixNano(), 10),
+		strconv.Itoa(SeriesLimitFromContext(ctx)),
+	}, matches...)
+	key := c.cacheKey(ctx, "series", params...)
+
+	var series []model.LabelSet
+	w, err := c.call(ctx, "series", key,
+		func(b []byte) error {
+			return json.Unmarshal(b, &series)
+		},
+		func() ([]byte, error) {
+			return json.Marshal(series)
+		},
+		func(ctx context.Context) (w api.Warnings, err error) {
+			series, w, err = c.API.Series(ctx, matches, startTime, endTime)
+			return w, err
+		},
+	)
+	return series, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset. The
+// streamed series aren't cached.
+func (c *ResultCacheAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, c.API, matches, startTime, endTime, fn)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (c *ResultCacheAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	return StreamGetValue(ctx, c.API, start, end, matchers)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (c *ResultCacheAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, c.API, startTime, endTime)
+}
+
+// DefaultResultCacheStats are the ResultCacheStats of the ResultCacheAPIs
+var DefaultResultCacheStats = NewResultCacheStats()
+
+// NewResultCacheStats returns empty ResultCacheStats
+func NewResultCacheStats() *ResultCacheStats {
+	return &ResultCacheStats{
+		hits:    make(map[string]uint64),
+		misses:  make(map[string]uint64),
+		skipped: make(map[[2]string]uint64),
+	}
+}
+
+// ResultCacheStats counts the hits and misses of the ResultCacheAPIs, and the
+// results which weren't cached as they were degraded
+type ResultCacheStats struct {
+	mu      sync.Mutex
+	hits    map[string]uint64
+	misses  map[string]uint64
+	skipped map[[2]string]uint64
+}
+
+func (s *ResultCacheStats) hit(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hits[method]++
+}
+
+func (s *ResultCacheStats) miss(method string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.misses[method]++
+}
+
+func (s *ResultCacheStats) skip(method, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.skipped[[2]string{method, reason}]++
+}
+
+var (
+	resultCacheHitsDesc = prometheus.NewDesc(
+		"promproxy_result_cache_hits_total",
+		"Number of calls served from the result cache",
+		[]string{"method"}, nil,
+	)
+	resultCacheMissesDesc = prometheus.NewDesc(
+		"promproxy_result_cache_misses_total",
+		"Number of calls not found in the result cache",
+		[]string{"method"}, nil,
+	)
+	resultCacheSkippedDesc = prometheus.NewDesc(
+		"promproxy_result_cache_skipped_writes_total",
+		"Number of results not cached as they were degraded, by why",
+		[]string{"method", "reason"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (s *ResultCacheStats) Describe(ch chan<- *prometheus.Desc) {
+	ch <- resultCacheHitsDesc
+	ch <- resultCacheMissesDesc
+	ch <- resultCacheSkippedDesc
+}
+
+// Collect implements prometheus.Collector
+func (s *ResultCacheStats) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for method, count := range s.hits {
+		ch <- prometheus.MustNewConstMetric(resultCacheHitsDesc, prometheus.CounterValue, float64(count), method)
+	}
+	for method, count := range s.misses {
+		ch <- prometheus.MustNewConstMetric(resultCacheMissesDesc, prometheus.CounterValue, float64(count), method)
+	}
+	for k, count := range s.skipped {
+		ch <- prometheus.MustNewConstMetric(resultCacheSkippedDesc, prometheus.CounterValue, float64(count), k[0], k[1])
+	}
+}
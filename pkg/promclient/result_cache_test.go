@@ -0,0 +1,177 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/cache"
+)
+
+func TestResultDegraded(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	nearDeadline, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	farDeadline, cancel := context.WithTimeout(context.Background(), time.Hour)
+	defer cancel()
+	exhausted := NewLatencyBudget(0)
+
+	incomplete := &Completeness{}
+	incomplete.add(2, 1)
+	complete := &Completeness{}
+	complete.add(2, 2)
+
+	backendWarning := NewBackendWarning("a", fmt.Errorf("connection refused")).String()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		warnings     api.Warnings
+		completeness *Completeness
+		reason       string
+	}{
+		{name: "complete", ctx: farDeadline, completeness: complete},
+		{name: "no deadline", ctx: context.Background()},
+		{name: "fired deadline", ctx: canceled, reason: DegradedDeadline},
+		{name: "near deadline", ctx: nearDeadline, reason: DegradedDeadline},
+		{name: "exhausted budget", ctx: WithLatencyBudget(context.Background(), exhausted), reason: DegradedDeadline},
+		{name: "incomplete", ctx: context.Background(), completeness: incomplete, reason: DegradedIncomplete},
+		{name: "backend error", ctx: context.Background(), warnings: api.Warnings{"other", backendWarning}, reason: DegradedBackendError},
+		{name: "truncated", ctx: context.Background(), warnings: api.Warnings{backendWarning, SeriesLimitWarning}, reason: DegradedTruncated},
+		{name: "warnings", ctx: context.Background(), warnings: api.Warnings{DryRunWarning}, reason: DegradedWarnings},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reason := ResultDegraded(test.ctx, time.Second, test.warnings, test.completeness)
+			if reason != test.reason {
+				t.Fatalf("mismatch in reason expected=%q actual=%q", test.reason, reason)
+			}
+		})
+	}
+}
+
+// failingCountAPI counts its Query calls, failing them while err is set
+type failingCountAPI struct {
+	API
+	calls int32
+	err   atomic.Value
+}
+
+func (c *failingCountAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	atomic.AddInt32(&c.calls, 1)
+	if err, _ := c.err.Load().(error); err != nil {
+		return nil, nil, err
+	}
+	return c.API.Query(ctx, query, ts)
+}
+
+func newTestResultCacheAPI(a API) *ResultCacheAPI {
+	c := NewResultCacheAPI(a, ResultCacheConfig{
+		TTL:            time.Minute,
+		DeadlineMargin: time.Second,
+		Memory:         cache.MemoryConfig{MaxEntries: 16, Shards: 1},
+	})
+	c.stats = NewResultCacheStats()
+	return c
+}
+
+// TestResultCachePartialResponse checks that the partial results the MultiAPI
+// accepts when a backend fails are returned but not cached
+func TestResultCachePartialResponse(t *testing.T) {
+	vector := model.Vector{{Metric: model.Metric{"job": "a"}, Value: 1, Timestamp: 1000}}
+	stub := &stubAPI{query: func() model.Value { return vector }}
+	healthy := &failingCountAPI{API: stub}
+	flaky := &failingCountAPI{API: stub}
+	flaky.err.Store(fmt.Errorf("connection refused"))
+
+	// One of the two backends is enough
+	c := newTestResultCacheAPI(NewMultiAPI([]API{healthy, flaky}, 0, nil, 1))
+	query := func() (model.Value, api.Warnings, *Completeness) {
+		ctx, completeness := WithCompleteness(context.Background())
+		v, w, err := c.Query(ctx, "up", time.Unix(1, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return v, w, completeness
+	}
+
+	for i := 0; i < 2; i++ {
+		v, w, completeness := query()
+		if v.String() != vector.String() {
+			t.Fatalf("mismatch in partial value expected=%v actual=%v", vector, v)
+		}
+		if len(w) != 1 {
+			t.Fatalf("mismatch in partial warnings expected=1 actual=%v", w)
+		}
+		if score, _ := completeness.Score(); score != 0.5 {
+			t.Fatalf("mismatch in partial completeness expected=0.5 actual=%v", score)
+		}
+		if calls := atomic.LoadInt32(&healthy.calls); calls != int32(i+1) {
+			t.Fatalf("mismatch in calls expected=%d actual=%d", i+1, calls)
+		}
+	}
+	if skipped := c.stats.skipped[[2]string{"query", DegradedIncomplete}]; skipped != 2 {
+		t.Fatalf("mismatch in skipped writes expected=2 actual=%d", skipped)
+	}
+
+	// Once the backend is back the complete result is cached
+	flaky.err.Store(error(nil))
+	for i := 0; i < 2; i++ {
+		v, w, _ := query()
+		if v.String() != vector.String() {
+			t.Fatalf("mismatch in value expected=%v actual=%v", vector, v)
+		}
+		if len(w) != 0 {
+			t.Fatalf("mismatch in warnings expected=none actual=%v", w)
+		}
+	}
+	if calls := atomic.LoadInt32(&healthy.calls); calls != 3 {
+		t.Fatalf("mismatch in calls expected=3 actual=%d", calls)
+	}
+	if hits := c.stats.hits["query"]; hits != 1 {
+		t.Fatalf("mismatch in hits expected=1 actual=%d", hits)
+	}
+}
+
+// TestResultCacheDeadline checks that a result produced close to the deadline of
+// the call isn't cached
+func TestResultCacheDeadline(t *testing.T) {
+	matrix := model.Matrix{{Metric: model.Metric{"job": "a"}, Values: []model.SamplePair{{Timestamp: 1000, Value: 1}}}}
+	var calls int32
+	stub := &stubAPI{query: func() model.Value {
+		atomic.AddInt32(&calls, 1)
+		return matrix
+	}}
+	c := newTestResultCacheAPI(stub)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	for i := 0; i < 2; i++ {
+		if _, _, err := c.Query(ctx, "up[1m]", time.Unix(60, 0)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if calls != 2 {
+		t.Fatalf("mismatch in calls near the deadline expected=2 actual=%d", calls)
+	}
+
+	for i := 0; i < 2; i++ {
+		v, _, err := c.Query(context.Background(), "up[1m]", time.Unix(60, 0))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if _, ok := v.(model.Matrix); !ok || v.String() != matrix.String() {
+			t.Fatalf("mismatch in value expected=%v actual=%#v", matrix, v)
+		}
+	}
+	if calls != 3 {
+		t.Fatalf("mismatch in calls expected=3 actual=%d", calls)
+	}
+}
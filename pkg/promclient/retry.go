@@ -0,0 +1,483 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// RetryConfig configures the retries of requests to the hosts of a servergroup
+type RetryConfig struct {
+	// MaxRetries is the maximum number of retries of a failed request
+	MaxRetries int `yaml:"max_retries"`
+	// Backoff is the wait before the first retry, which doubles for every retry
+	Backoff time.Duration `yaml:"backoff"`
+	// Budget limits the retries to a host across all requests
+	Budget RetryBudgetConfig `yaml:"budget"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, defaulting the budget
+func (c *RetryConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = RetryConfig{Budget: DefaultRetryBudgetConfig}
+	type plain RetryConfig
+	return unmarshal((*plain)(c))
+}
+
+// Validate returns an error if the config isn't valid
+func (c RetryConfig) Validate() error {
+	if c.MaxRetries < 0 {
+		return fmt.Errorf("retry max_retries must not be negative")
+	}
+	if c.Backoff < 0 {
+		return fmt.Errorf("retry backoff must not be negative")
+	}
+	return c.Budget.Validate()
+}
+
+// RetryBudgetConfig configures a RetryBudget
+type RetryBudgetConfig struct {
+	// Ratio is the fraction of successful requests which may be retried
+	Ratio float64 `yaml:"ratio"`
+	// RefillPerSecond is the number of retries added to the budget every second,
+	// regardless of the number of requests
+	RefillPerSecond float64 `yaml:"refill_per_second"`
+	// Max is the maximum number of retries the budget holds
+	Max float64 `yaml:"max"`
+	// Fraction (if set) caps the retries to this fraction of all the requests
+	// (including the retries) to the backend within the sliding Window. Note that a
+	// backend needs some traffic within the window for any retry to be allowed.
+	Fraction float64 `yaml:"fraction"`
+	// Window is the sliding window of the Fraction (DefaultRetryBudgetWindow if 0)
+	Window time.Duration `yaml:"window"`
+}
+
+// DefaultRetryBudgetWindow is the sliding window of the retry budget fraction if
+// none is configured
+const DefaultRetryBudgetWindow = 10 * time.Second
+
+// DefaultRetryBudgetConfig is the budget used if none is configured
+var DefaultRetryBudgetConfig = RetryBudgetConfig{
+	Ratio:           0.1,
+	RefillPerSecond: 1,
+	Max:             10,
+}
+
+// Validate returns an error if the config isn't valid
+func (c RetryBudgetConfig) Validate() error {
+	if c.Ratio < 0 || c.RefillPerSecond < 0 || c.Max < 0 {
+		return fmt.Errorf("retry budget ratio, refill_per_second and max must not be negative")
+	}
+	if c.Fraction < 0 || c.Fraction > 1 {
+		return fmt.Errorf("retry budget fraction must be between 0 and 1")
+	}
+	if c.Window < 0 {
+		return fmt.Errorf("retry budget window must not be negative")
+	}
+	return nil
+}
+
+// NewRetryBudget returns a full RetryBudget
+func NewRetryBudget(cfg RetryBudgetConfig) *RetryBudget {
+	window := cfg.Window
+	if window == 0 {
+		window = DefaultRetryBudgetWindow
+	}
+	return &RetryBudget{
+		cfg:    cfg,
+		tokens: cfg.Max,
+		window: retryWindow{width: window / retryWindowBuckets},
+		last:   time.Now(),
+		now:    time.Now,
+	}
+}
+
+// retryWindowBuckets is the number of buckets the sliding window of a RetryBudget
+// is split into, so requests drop out of the window in steps of a tenth of it
+const retryWindowBuckets = 10
+
+// retryWindow counts the requests and retries within a sliding window
+type retryWindow struct {
+	width    time.Duration
+	start    time.Time // start of the current bucket
+	current  int
+	requests [retryWindowBuckets]int
+	retries  [retryWindowBuckets]int
+}
+
+// advance moves the window to now, dropping the buckets which fell out of it
+func (w *retryWindow) advance(now time.Time) {
+	if w.start.IsZero() {
+		w.start = now
+	}
+	for n := 0; n < retryWindowBuckets && now.Sub(w.start) >= w.width; n++ {
+		w.start = w.start.Add(w.width)
+		w.current = (w.current + 1) % retryWindowBuckets
+		w.requests[w.current] = 0
+		w.retries[w.current] = 0
+	}
+	// Idle for longer than the window, so all the buckets are empty
+	if now.Sub(w.start) >= w.width {
+		w.start = now
+	}
+}
+
+// totals returns the requests and retries within the window
+func (w *retryWindow) totals() (requests, retries int) {
+	for i := range w.requests {
+		requests += w.requests[i]
+		retries += w.retries[i]
+	}
+	return requests, retries
+}
+
+// RetryBudget is a token bucket limiting the retries to a backend (like the retry
+// throttling of gRPC). Every successful request deposits Ratio tokens and the
+// bucket refills by RefillPerSecond, while every retry withdraws a token. So
+// during a brownout (when every request fails) the retries are capped to the
+// refill instead of multiplying the load on the backend. If a Fraction is set,
+// retries are additionally capped to that fraction of the recent requests.
+type RetryBudget struct {
+	mu     sync.Mutex
+	cfg    RetryBudgetConfig
+	tokens float64
+	denied uint64
+	window retryWindow
+	last   time.Time
+	now    func() time.Time
+}
+
+// refill adds the tokens for the time since the last refill, must be called with
+// the lock held
+func (b *RetryBudget) refill() {
+	now := b.now()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(b.cfg.Max, b.tokens+elapsed*b.cfg.RefillPerSecond)
+	}
+	b.last = now
+}
+
+// Success records a successful request, depositing its share of a retry
+func (b *RetryBudget) Success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	b.tokens = math.Min(b.cfg.Max, b.tokens+b.cfg.Ratio)
+}
+
+// Request records a request (or retry) sent to the backend
+func (b *RetryBudget) Request() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.window.advance(b.now())
+	b.window.requests[b.window.current]++
+}
+
+// Retry withdraws a retry from the budget, returning false if it is exhausted
+func (b *RetryBudget) Retry() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	if b.tokens < 1 || !b.fractionAllows() {
+		b.denied++
+		return false
+	}
+	b.tokens--
+	b.window.retries[b.window.current]++
+	return true
+}
+
+// fractionAllows returns whether another retry stays within the Fraction of the
+// requests in the window, must be called with the lock held. The retry is itself a
+// request, so it counts towards both.
+func (b *RetryBudget) fractionAllows() bool {
+	if b.cfg.Fraction == 0 {
+		return true
+	}
+	b.window.advance(b.now())
+	requests, retries := b.window.totals()
+	return float64(retries+1) <= b.cfg.Fraction*float64(requests+1)
+}
+
+// DefaultRetryBudgets are the RetryBudgets used by the servergroups
+var DefaultRetryBudgets = NewRetryBudgets()
+
+// NewRetryBudgets returns an empty RetryBudgets
+func NewRetryBudgets() *RetryBudgets {
+	return &RetryBudgets{budgets: make(map[string]*RetryBudget)}
+}
+
+// RetryBudgets holds the RetryBudget of each backend by name, so the budget is
+// shared across the RetryAPIs of the same backend
+type RetryBudgets struct {
+	mu      sync.Mutex
+	budgets map[string]*RetryBudget
+}
+
+// Get returns the budget of the named backend, which is replaced if the config changed
+func (r *RetryBudgets) Get(name string, cfg RetryBudgetConfig) *RetryBudget {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.budgets[name]; ok && b.cfg == cfg {
+		return b
+	}
+	b := NewRetryBudget(cfg)
+	// Keep counting the denied retries of the backend
+	if previous, ok := r.budgets[name]; ok {
+		previous.mu.Lock()
+		b.denied = previous.denied
+		previous.mu.Unlock()
+	}
+	r.budgets[name] = b
+	return b
+}
+
+// RetryBudgetStatus is the current state of the RetryBudget of a backend
+type RetryBudgetStatus struct {
+	Upstream string  `json:"upstream"`
+	Tokens   float64 `json:"tokens"`
+	Max      float64 `json:"max"`
+	Denied   uint64  `json:"denied"`
+}
+
+// status returns the current state of the budget
+func (b *RetryBudget) status(name string) RetryBudgetStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill()
+	return RetryBudgetStatus{
+		Upstream: name,
+		Tokens:   b.tokens,
+		Max:      b.cfg.Max,
+		Denied:   b.denied,
+	}
+}
+
+// Status returns the current state of the budgets, sorted by backend
+func (r *RetryBudgets) Status() []RetryBudgetStatus {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status := make([]RetryBudgetStatus, 0, len(r.budgets))
+	for name, b := range r.budgets {
+		status = append(status, b.status(name))
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Upstream < status[j].Upstream })
+	return status
+}
+
+// Reset fills the budget of the named backend up again (e.g. once the backend is
+// known to have recovered), returning its new state. The denied retries are kept
+// as they are a counter.
+func (r *RetryBudgets) Reset(name string) (RetryBudgetStatus, bool) {
+	r.mu.Lock()
+	b, ok := r.budgets[name]
+	r.mu.Unlock()
+	if !ok {
+		return RetryBudgetStatus{}, false
+	}
+	b.mu.Lock()
+	b.tokens = b.cfg.Max
+	b.last = b.now()
+	b.window = retryWindow{width: b.window.width}
+	b.mu.Unlock()
+	return b.status(name), true
+}
+
+// RetryAPI retries requests which failed due to the backend (unavailable, server
+// errors or bad responses) with an exponential backoff. If the backend asked to retry
+// after some time (see RetryAfterError) that is waited for instead, unless it would
+// exceed the deadline of the context. Retries are only made while the Budget (if
+// set) has any left, otherwise the error is returned immediately.
+type RetryAPI struct {
+	API
+	MaxRetries int
+	Backoff    time.Duration
+	Budget     *RetryBudget
+}
+
+func retryable(err error) bool {
+	switch CategorizeError(err) {
+	case ErrorCategoryServer, ErrorCategoryBadResponse, ErrorCategoryUnavailable, ErrorCategoryThrottled:
+		return true
+	default:
+		return false
+	}
+}
+
+// do calls fn until it succeeds, fails with an error that isn't retryable, or
+// there are no retries left
+func (r *RetryAPI) do(ctx context.Context, fn func() error) error {
+	// Dry runs don't fail, and must not use up the budget of real requests
+	if DryRunFromContext(ctx) != nil {
+		return fn()
+	}
+
+	backoff := r.Backoff
+	for attempt := 0; ; attempt++ {
+		if r.Budget != nil {
+			r.Budget.Request()
+		}
+		err := fn()
+		if err == nil && r.Budget != nil {
+			r.Budget.Success()
+		}
+		if err == nil || attempt >= r.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		// A downstream which is shedding load is left alone for as long as it asked,
+		// if that isn't possible within the deadline there is no point in retrying
+		wait := backoff
+		if after, ok := RetryAfter(err); ok && after > wait {
+			wait = after
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return err
+		}
+
+		if r.Budget != nil && !r.Budget.Retry() {
+			logger.Debugf("Retry budget exhausted, not retrying: %v", err)
+			return err
+		}
+
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(wait):
+			}
+		} else if ctx.Err() != nil {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (r *RetryAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	var v []string
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.LabelNames(ctx)
+		return err
+	})
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (r *RetryAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	var v []string
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = LabelNamesInRange(ctx, r.API, startTime, endTime)
+		return err
+	})
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (r *RetryAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	var v model.LabelValues
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.LabelValues(ctx, label)
+		return err
+	})
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (r *RetryAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.Query(ctx, query, ts)
+		return err
+	})
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (r *RetryAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.QueryRange(ctx, query, rng)
+		return err
+	})
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (r *RetryAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	var v []model.LabelSet
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.Series(ctx, matches, startTime, endTime)
+		return err
+	})
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset. As the
+// labelsets are passed on while they are read this isn't retried.
+func (r *RetryAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, r.API, matches, startTime, endTime, fn)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *RetryAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	var v model.Value
+	var w api.Warnings
+	err := r.do(ctx, func() (err error) {
+		v, w, err = r.API.GetValue(ctx, start, end, matchers)
+		return err
+	})
+	return v, w, err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// streaming the series. Only opening the stream is retried, as the series are
+// passed on while they are read.
+func (r *RetryAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	var ch <-chan StreamedSeries
+	err := r.do(ctx, func() (err error) {
+		ch, err = StreamGetValue(ctx, r.API, start, end, matchers)
+		return err
+	})
+	return ch, err
+}
+
+var retryBudgetTokensDesc = prometheus.NewDesc(
+	"promproxy_retry_budget_tokens",
+	"The number of retries left in the retry budget of the backend",
+	[]string{"upstream"}, nil,
+)
+
+var retryBudgetDeniedDesc = prometheus.NewDesc(
+	"promproxy_retry_budget_denied_total",
+	"The number of retries to the backend which were skipped as the retry budget was exhausted",
+	[]string{"upstream"}, nil,
+)
+
+// Describe implements prometheus.Collector
+func (r *RetryBudgets) Describe(ch chan<- *prometheus.Desc) {
+	ch <- retryBudgetTokensDesc
+	ch <- retryBudgetDeniedDesc
+}
+
+// Collect implements prometheus.Collector
+func (r *RetryBudgets) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range r.Status() {
+		ch <- prometheus.MustNewConstMetric(retryBudgetTokensDesc, prometheus.GaugeValue, status.Tokens, status.Upstream)
+		ch <- prometheus.MustNewConstMetric(retryBudgetDeniedDesc, prometheus.CounterValue, float64(status.Denied), status.Upstream)
+	}
+}
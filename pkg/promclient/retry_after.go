@@ -0,0 +1,128 @@
+package promclient
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// RetryAfterError is returned when a downstream sheds load, responding with a 429
+// or 503 and a Retry-After header
+type RetryAfterError struct {
+	StatusCode int
+	After      time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return fmt.Sprintf("server returned HTTP status %d %s, retry after %v", e.StatusCode, http.StatusText(e.StatusCode), e.After)
+}
+
+// AsRetryAfterError returns the RetryAfterError of err (if it is one). The error of
+// a RoundTripper is wrapped in a url.Error by the http.Client, so that is unwrapped
+// too
+func AsRetryAfterError(err error) (*RetryAfterError, bool) {
+	cause := errors.Cause(err)
+	if urlErr, ok := cause.(*url.Error); ok {
+		cause = errors.Cause(urlErr.Err)
+	}
+	retryErr, ok := cause.(*RetryAfterError)
+	return retryErr, ok
+}
+
+// RetryAfter returns how long the downstream asked to be left alone for, if the
+// error is the result of it shedding load
+func RetryAfter(err error) (time.Duration, bool) {
+	if retryErr, ok := AsRetryAfterError(err); ok {
+		return retryErr.After, true
+	}
+	return 0, false
+}
+
+// ParseRetryAfter parses the value of a Retry-After header, which is either a
+// number of seconds or an HTTP date
+func ParseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	v = strings.TrimSpace(v)
+	if seconds, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if after := t.Sub(now); after > 0 {
+			return after, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// RetryAfterRoundTripper turns 429 and 503 responses with a (valid) Retry-After
+// header into a RetryAfterError, so the wrappers of the client can back off for
+// as long as the downstream asked for. Other responses are returned as-is.
+type RetryAfterRoundTripper struct {
+	http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *RetryAfterRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.RoundTripper.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return resp, nil
+	}
+	after, ok := ParseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return resp, nil
+	}
+
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	return nil, &RetryAfterError{StatusCode: resp.StatusCode, After: after}
+}
+
+// backendErrors collects the errors of the apis of a MultiAPI for a single request
+type backendErrors struct {
+	last     error
+	shedding *RetryAfterError
+	// all is whether all the errors were RetryAfterErrors
+	all bool
+}
+
+func (b *backendErrors) add(err error) {
+	if b.last == nil {
+		b.all = true
+	}
+	b.last = err
+
+	retryErr, ok := AsRetryAfterError(err)
+	if !ok {
+		b.all = false
+		return
+	}
+	if b.shedding == nil {
+		b.shedding = &RetryAfterError{StatusCode: retryErr.StatusCode}
+	}
+	// The request can only succeed once all of the downstreams are back
+	if retryErr.After > b.shedding.After {
+		b.shedding.After = retryErr.After
+	}
+	if retryErr.StatusCode != http.StatusTooManyRequests {
+		b.shedding.StatusCode = http.StatusServiceUnavailable
+	}
+}
+
+// err returns the error for the request. If all the failed apis are shedding load
+// that is a RetryAfterError for the longest Retry-After, otherwise the last error
+func (b *backendErrors) err() error {
+	if b.all && b.shedding != nil {
+		return b.shedding
+	}
+	return b.last
+}
@@ -0,0 +1,204 @@
+package promclient
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2019, 7, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		after time.Duration
+		ok    bool
+	}{
+		{value: "120", after: 2 * time.Minute, ok: true},
+		{value: " 0 ", after: 0, ok: true},
+		{value: "Mon, 01 Jul 2019 12:00:30 GMT", after: 30 * time.Second, ok: true},
+		// A date in the past means retry now
+		{value: "Mon, 01 Jul 2019 11:00:00 GMT", after: 0, ok: true},
+		{value: "", ok: false},
+		{value: "-5", ok: false},
+		{value: "soon", ok: false},
+	}
+
+	for _, test := range tests {
+		after, ok := ParseRetryAfter(test.value, now)
+		if ok != test.ok || after != test.after {
+			t.Fatalf("mismatch in %q expected=%v,%v actual=%v,%v", test.value, test.after, test.ok, after, ok)
+		}
+	}
+}
+
+func TestRetryAfterRoundTripper(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		code       int
+		retryAfter string
+		shedding   bool
+	}{
+		{code: http.StatusTooManyRequests, retryAfter: "30", shedding: true},
+		{code: http.StatusServiceUnavailable, retryAfter: date, shedding: true},
+		// Without (a valid) Retry-After the response is returned as-is
+		{code: http.StatusServiceUnavailable, retryAfter: "", shedding: false},
+		{code: http.StatusTooManyRequests, retryAfter: "soon", shedding: false},
+		{code: http.StatusInternalServerError, retryAfter: "30", shedding: false},
+	}
+
+	for _, test := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if test.retryAfter != "" {
+				w.Header().Set("Retry-After", test.retryAfter)
+			}
+			w.WriteHeader(test.code)
+		}))
+		client := &http.Client{Transport: &RetryAfterRoundTripper{http.DefaultTransport}}
+		resp, err := client.Get(srv.URL)
+		srv.Close()
+
+		retryErr, shedding := AsRetryAfterError(err)
+		if shedding != test.shedding {
+			t.Fatalf("mismatch in shedding for %d %q expected=%v actual=%v (%v)", test.code, test.retryAfter, test.shedding, shedding, err)
+		}
+		if !shedding {
+			if err != nil || resp.StatusCode != test.code {
+				t.Fatalf("expected the response to be returned as-is, err=%v", err)
+			}
+			resp.Body.Close()
+			continue
+		}
+		if retryErr.StatusCode != test.code || retryErr.After <= 0 {
+			t.Fatalf("mismatch in error for %d %q: %v", test.code, test.retryAfter, retryErr)
+		}
+		if CategorizeError(err) != ErrorCategoryThrottled {
+			t.Fatalf("mismatch in category expected=%s actual=%s", ErrorCategoryThrottled, CategorizeError(err))
+		}
+	}
+}
+
+// sheddingAPI fails every query with the configured error
+type sheddingAPI struct {
+	API
+	err   error
+	calls int
+}
+
+func (s *sheddingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	s.calls++
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestMultiAPIRetryAfter(t *testing.T) {
+	shed := func(code int, after time.Duration) API {
+		return &sheddingAPI{err: &RetryAfterError{StatusCode: code, After: after}}
+	}
+
+	tests := []struct {
+		apis     []API
+		shedding bool
+		code     int
+		after    time.Duration
+	}{
+		// All downstreams are shedding, the longest Retry-After is returned
+		{
+			apis:     []API{shed(429, time.Second), shed(429, time.Minute)},
+			shedding: true,
+			code:     429,
+			after:    time.Minute,
+		},
+		{
+			apis:     []API{shed(429, time.Second), shed(503, time.Second)},
+			shedding: true,
+			code:     503,
+			after:    time.Second,
+		},
+		// Another error means not all are shedding
+		{
+			apis:     []API{shed(429, time.Second), &sheddingAPI{err: errors.New("boom")}},
+			shedding: false,
+		},
+	}
+
+	for i, test := range tests {
+		_, _, err := NewMultiAPI(test.apis, 0, nil, 1).Query(context.TODO(), "up", time.Now())
+		if err == nil {
+			t.Fatalf("%d: expected error", i)
+		}
+		retryErr, shedding := AsRetryAfterError(err)
+		if shedding != test.shedding {
+			t.Fatalf("%d: mismatch in shedding expected=%v actual=%v (%v)", i, test.shedding, shedding, err)
+		}
+		if shedding && (retryErr.StatusCode != test.code || retryErr.After != test.after) {
+			t.Fatalf("%d: mismatch in error expected=%d,%v actual=%d,%v", i, test.code, test.after, retryErr.StatusCode, retryErr.After)
+		}
+	}
+}
+
+func TestRetryAPIRetryAfter(t *testing.T) {
+	stub := &sheddingAPI{err: &RetryAfterError{StatusCode: 429, After: time.Minute}}
+	r := &RetryAPI{API: stub, MaxRetries: 3}
+
+	// The Retry-After is beyond the deadline, so the error is returned immediately
+	ctx, cancel := context.WithTimeout(context.TODO(), time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := r.Query(ctx, "up", time.Now()); err == nil {
+		t.Fatalf("expected error")
+	}
+	if stub.calls != 1 {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", 1, stub.calls)
+	}
+	if took := time.Since(start); took > 100*time.Millisecond {
+		t.Fatalf("expected an immediate error, took %v", took)
+	}
+
+	// Within the deadline the Retry-After is waited for
+	stub.err = &RetryAfterError{StatusCode: 429, After: 20 * time.Millisecond}
+	stub.calls = 0
+	start = time.Now()
+	r.MaxRetries = 1
+	r.Query(ctx, "up", time.Now())
+	if stub.calls != 2 {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", 2, stub.calls)
+	}
+	if took := time.Since(start); took < 20*time.Millisecond {
+		t.Fatalf("retried before the Retry-After, took %v", took)
+	}
+}
+
+// TestLoadBalancedRetryAfter verifies that requests aren't sent to a backend which
+// asked to be left alone, the circuit breaker fails them fast so they go to the
+// other backend
+func TestLoadBalancedRetryAfter(t *testing.T) {
+	breakers := NewCircuitBreakers()
+	cfg := CircuitBreakerConfig{FailureThreshold: 5, OpenDuration: time.Minute}
+	a := &sheddingAPI{err: &RetryAfterError{StatusCode: 503, After: time.Minute}}
+	b := &sheddingAPI{}
+	lb := NewLoadBalancedAPI([]API{breakers.Wrap("a", a, cfg), breakers.Wrap("b", b, cfg)})
+
+	ctx := WithUpstreamHint(context.TODO(), PreferConsistent)
+	for i := 0; i < 3; i++ {
+		if _, _, err := lb.Query(ctx, "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if a.calls != 1 || b.calls != 3 {
+		t.Fatalf("mismatch in calls expected=1,3 actual=%d,%d", a.calls, b.calls)
+	}
+
+	// Shedding isn't a failure of the backend
+	cb, _ := breakers.Get("a")
+	if state := cb.State(); state != CircuitClosed {
+		t.Fatalf("mismatch in state expected=%s actual=%s", CircuitClosed, state)
+	}
+}
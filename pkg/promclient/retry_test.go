@@ -0,0 +1,183 @@
+package promclient
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// brownoutAPI fails every query as unavailable while down is set
+type brownoutAPI struct {
+	API
+	down  bool
+	calls int
+}
+
+func (b *brownoutAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	b.calls++
+	if b.down {
+		return nil, nil, &url.Error{Op: "Get", URL: "http://a:9090/api/v1/query", Err: errors.New("connection refused")}
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestRetryAPIBudget(t *testing.T) {
+	now := time.Unix(0, 0)
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 0.1, RefillPerSecond: 1, Max: 5})
+	budget.now = func() time.Time { return now }
+	budget.last = now
+
+	stub := &brownoutAPI{down: true}
+	// Two RetryAPIs of the same backend share the budget
+	apis := []*RetryAPI{
+		{API: stub, MaxRetries: 3, Budget: budget},
+		{API: stub, MaxRetries: 3, Budget: budget},
+	}
+
+	query := func(a *RetryAPI) int {
+		before := stub.calls
+		if _, _, err := a.Query(context.TODO(), "up", now); err == nil {
+			t.Fatalf("expected error during brownout")
+		}
+		return stub.calls - before
+	}
+
+	// The full budget allows the first requests to be retried
+	if calls := query(apis[0]); calls != 4 {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", 4, calls)
+	}
+
+	// Once the budget is empty requests fail fast, retries are limited to ~ratio
+	const requests = 100
+	total, failedFast := 0, 0
+	for i := 0; i < requests; i++ {
+		calls := query(apis[i%2])
+		total += calls
+		if calls == 1 {
+			failedFast++
+		}
+	}
+	if max := requests + 2 + int(requests*0.1); total > max {
+		t.Fatalf("retries not throttled, %d calls for %d requests (max %d)", total, requests, max)
+	}
+	if failedFast < requests*8/10 {
+		t.Fatalf("expected most requests to fail fast, only %d of %d did", failedFast, requests)
+	}
+
+	// The budget refills over time
+	now = now.Add(10 * time.Second)
+	if calls := query(apis[1]); calls != 4 {
+		t.Fatalf("mismatch in calls after refill expected=%d actual=%d", 4, calls)
+	}
+
+	// Once the backend recovers requests succeed without retries
+	stub.down = false
+	before := stub.calls
+	if _, _, err := apis[0].Query(context.TODO(), "up", now); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls := stub.calls - before; calls != 1 {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", 1, calls)
+	}
+}
+
+// flakyAPI fails every other query as unavailable
+type flakyAPI struct {
+	API
+	calls int
+}
+
+func (f *flakyAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	f.calls++
+	if f.calls%2 == 0 {
+		return nil, nil, &url.Error{Op: "Get", URL: "http://a:9090/api/v1/query", Err: errors.New("connection refused")}
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestRetryAPIBudgetFraction(t *testing.T) {
+	now := time.Unix(0, 0)
+	// The token bucket holds plenty of retries, so only the fraction limits them
+	budget := NewRetryBudget(RetryBudgetConfig{Ratio: 1, Max: 1000, Fraction: 0.2, Window: 10 * time.Second})
+	budget.now = func() time.Time { return now }
+	budget.last = now
+
+	stub := &flakyAPI{}
+	r := &RetryAPI{API: stub, MaxRetries: 3, Budget: budget}
+
+	const requests = 1000
+	for i := 0; i < requests; i++ {
+		now = now.Add(10 * time.Millisecond)
+		r.Query(context.TODO(), "up", now)
+	}
+
+	retries := stub.calls - requests
+	if retries == 0 {
+		t.Fatalf("expected failed requests to be retried")
+	}
+	if float64(retries) >= 0.2*float64(stub.calls) {
+		t.Fatalf("retries not limited to the fraction, %d retries of %d calls", retries, stub.calls)
+	}
+}
+
+func TestRetryBudgetsStatus(t *testing.T) {
+	budgets := NewRetryBudgets()
+	cfg := RetryBudgetConfig{Ratio: 0.5, Max: 2}
+	b := budgets.Get("a:9090", cfg)
+
+	// Retries beyond the budget are denied
+	for i, expected := range []bool{true, true, false} {
+		if ok := b.Retry(); ok != expected {
+			t.Fatalf("%d: mismatch in retry expected=%v actual=%v", i, expected, ok)
+		}
+	}
+	// Successful requests refill the budget
+	b.Success()
+	b.Success()
+
+	expected := []RetryBudgetStatus{{Upstream: "a:9090", Tokens: 1, Max: 2, Denied: 1}}
+	if status := budgets.Status(); !reflect.DeepEqual(status, expected) {
+		t.Fatalf("mismatch in status expected=%v actual=%v", expected, status)
+	}
+
+	// A changed config replaces the budget, but the denied retries are kept
+	cfg.Max = 4
+	budgets.Get("a:9090", cfg)
+	budgets.Get("b:9090", cfg)
+	expected = []RetryBudgetStatus{
+		{Upstream: "a:9090", Tokens: 4, Max: 4, Denied: 1},
+		{Upstream: "b:9090", Tokens: 4, Max: 4},
+	}
+	if status := budgets.Status(); !reflect.DeepEqual(status, expected) {
+		t.Fatalf("mismatch in status expected=%v actual=%v", expected, status)
+	}
+}
+
+func TestRetryAPINotRetryable(t *testing.T) {
+	stub := &stubErrorAPI{err: errors.New("parse error")}
+	r := &RetryAPI{API: stub, MaxRetries: 3}
+	if _, _, err := r.Query(context.TODO(), "up(", time.Now()); err == nil {
+		t.Fatalf("expected error")
+	}
+	if stub.calls != 1 {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", 1, stub.calls)
+	}
+}
+
+// stubErrorAPI returns the error for every query
+type stubErrorAPI struct {
+	API
+	err   error
+	calls int
+}
+
+func (s *stubErrorAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	s.calls++
+	return nil, nil, s.err
+}
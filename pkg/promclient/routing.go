@@ -0,0 +1,84 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// RoutingLabeler is implemented by the APIs which are routed to by their labels
+// (e.g. servergroups), so the routing of a call can be explained
+type RoutingLabeler interface {
+	// RoutingLabels returns the labels which the queries are routed by
+	RoutingLabels() model.LabelSet
+}
+
+// routingLabels returns the labels the i-th api is routed by (nil if it isn't)
+func (m *MultiAPI) routingLabels(i int) model.LabelSet {
+	switch typed := m.apis[i].(type) {
+	case RoutingLabeler:
+		return typed.RoutingLabels()
+	case APILabels:
+		return typed.Key()
+	default:
+		return nil
+	}
+}
+
+// finish finishes the fanout of a call, explaining its routing in a warning if
+// ExplainRouting is set and the call was routed away from any of the apis
+func (m *MultiAPI) finish(ctx context.Context, fan *fanout, warnings promutil.WarningSet) {
+	fan.finish(ctx)
+	if !m.ExplainRouting || !fan.outermost {
+		return
+	}
+	if w, ok := m.routingWarning(fan); ok {
+		warnings.AddWarning(w)
+	}
+}
+
+// routingWarning returns the explanation of the routing of the fanout (e.g.
+// `routed to [0] based on {region="eu"}; 2 backends excluded`), false if the call
+// wasn't routed away from any of the apis. The labels are those which all the
+// apis routed to share.
+func (m *MultiAPI) routingWarning(fan *fanout) (string, bool) {
+	var (
+		routed   []string
+		shared   model.LabelSet
+		excluded int
+	)
+	for i := range m.apis {
+		if fan.isRoutedAway(i) {
+			excluded++
+			continue
+		}
+		routed = append(routed, m.apiNames[i])
+		labels := m.routingLabels(i)
+		if shared == nil {
+			shared = labels.Clone()
+			continue
+		}
+		for k, v := range shared {
+			if labels[k] != v {
+				delete(shared, k)
+			}
+		}
+	}
+	if excluded == 0 {
+		return "", false
+	}
+
+	noun := "backends"
+	if excluded == 1 {
+		noun = "backend"
+	}
+	w := "routed to [" + strings.Join(routed, " ") + "]"
+	if len(shared) > 0 {
+		w += " based on " + shared.String()
+	}
+	return fmt.Sprintf("%s; %d %s excluded", w, excluded, noun), true
+}
@@ -0,0 +1,77 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestRoutingWarning(t *testing.T) {
+	region := func(name string) API {
+		return &AddLabelClient{
+			API:    &stubAPI{query: func() model.Value { return model.Vector{} }},
+			Labels: model.LabelSet{"region": model.LabelValue(name), "env": "prod"},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		apis     []API
+		query    string
+		explain  bool
+		warnings []string
+	}{
+		{
+			name:     "narrowed",
+			apis:     []API{region("eu"), region("us"), region("ap")},
+			query:    `up{region="eu"}`,
+			explain:  true,
+			warnings: []string{`routed to [0] based on {env="prod", region="eu"}; 2 backends excluded`},
+		},
+		{
+			name:     "narrowed to several",
+			apis:     []API{region("eu"), region("us"), region("ap")},
+			query:    `up{region=~"eu|us"}`,
+			explain:  true,
+			warnings: []string{`routed to [0 1] based on {env="prod"}; 1 backend excluded`},
+		},
+		{
+			name:     "nested",
+			apis:     []API{NewMultiAPI([]API{region("eu")}, 0, nil, 1), NewMultiAPI([]API{region("us")}, 0, nil, 1)},
+			query:    `up{region="us"}`,
+			explain:  true,
+			warnings: []string{`routed to [1]; 1 backend excluded`},
+		},
+		{
+			name:    "full fan-out",
+			apis:    []API{region("eu"), region("us"), region("ap")},
+			query:   `up`,
+			explain: true,
+		},
+		{
+			name:  "not explained",
+			apis:  []API{region("eu"), region("us"), region("ap")},
+			query: `up{region="eu"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			multi := NewMultiAPI(test.apis, 0, nil, 1)
+			multi.ExplainRouting = test.explain
+			_, warnings, err := multi.Query(context.TODO(), test.query, time.Unix(100, 0))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(warnings) == 0 {
+				warnings = nil
+			}
+			if !reflect.DeepEqual([]string(warnings), test.warnings) {
+				t.Fatalf("mismatch in warnings expected=%v actual=%v", test.warnings, warnings)
+			}
+		})
+	}
+}
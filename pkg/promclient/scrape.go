@@ -0,0 +1,361 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// The defaults of a ScrapeConfig
+const (
+	DefaultScrapePath     = "/metrics"
+	DefaultScrapeCacheTTL = time.Second
+	DefaultScrapeLookback = 5 * time.Minute
+)
+
+// ScrapeParseError is returned when the exposition of a scraped endpoint can't be parsed
+type ScrapeParseError struct {
+	URL string
+	Err error
+}
+
+func (e *ScrapeParseError) Error() string {
+	return fmt.Sprintf("error parsing the metrics scraped from %s: %v", e.URL, e.Err)
+}
+
+// ScrapeQueryError is returned for queries which can't be answered from the scraped
+// samples, which is anything but a plain selector
+type ScrapeQueryError string
+
+func (e ScrapeQueryError) Error() string {
+	return fmt.Sprintf("only selectors can be queried from a scraped endpoint, not %q", string(e))
+}
+
+// ScrapeConfig configures a servergroup whose hosts aren't Prometheus servers but
+// only expose their metrics (in the text exposition format)
+type ScrapeConfig struct {
+	// Path is the path the metrics are exposed at (DefaultScrapePath if empty)
+	Path string `yaml:"path"`
+	// CacheTTL is how long a scrape is reused for (DefaultScrapeCacheTTL if 0)
+	CacheTTL time.Duration `yaml:"cache_ttl"`
+	// Lookback is how far back from now the scraped values are returned for
+	// (DefaultScrapeLookback if 0)
+	Lookback time.Duration `yaml:"lookback"`
+	// Labels are added to all the scraped series
+	Labels model.LabelSet `yaml:"labels"`
+}
+
+// Validate returns an error if the config isn't valid
+func (c ScrapeConfig) Validate() error {
+	if c.CacheTTL < 0 {
+		return fmt.Errorf("scrape cache_ttl must not be negative")
+	}
+	if c.Lookback < 0 {
+		return fmt.Errorf("scrape lookback must not be negative")
+	}
+	return c.Labels.Validate()
+}
+
+// ScrapeAPI serves the current values of an endpoint exposing metrics (such as an
+// exporter) as if it was a Prometheus server. The endpoint is scraped on demand,
+// and the scrape is reused for CacheTTL.
+//
+// There is no history, so only times within Lookback of now have data. For older
+// times the results are empty and have a warning. Queries are limited to plain
+// selectors, since the samples aren't evaluated by a PromQL engine.
+type ScrapeAPI struct {
+	URL    string
+	Client *http.Client
+	Config ScrapeConfig
+
+	mu        sync.Mutex
+	samples   model.Vector
+	scrapedAt time.Time
+}
+
+func (s *ScrapeAPI) cacheTTL() time.Duration {
+	if s.Config.CacheTTL > 0 {
+		return s.Config.CacheTTL
+	}
+	return DefaultScrapeCacheTTL
+}
+
+func (s *ScrapeAPI) lookback() time.Duration {
+	if s.Config.Lookback > 0 {
+		return s.Config.Lookback
+	}
+	return DefaultScrapeLookback
+}
+
+// scrape returns the samples of the endpoint (scraping it unless the last scrape is
+// still fresh) and the time of the scrape
+func (s *ScrapeAPI) scrape(ctx context.Context) (model.Vector, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.scrapedAt.IsZero() && time.Since(s.scrapedAt) < s.cacheTTL() {
+		return s.samples, s.scrapedAt, nil
+	}
+
+	req, err := http.NewRequest(http.MethodGet, s.URL, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	req.Header.Set("Accept", string(expfmt.FmtText))
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		errType := v1.ErrServer
+		if resp.StatusCode/100 == 4 {
+			errType = v1.ErrClient
+		}
+		return nil, time.Time{}, &v1.Error{Type: errType, Msg: fmt.Sprintf("server returned HTTP status %s", resp.Status)}
+	}
+
+	now := time.Now()
+	var families []*dto.MetricFamily
+	decoder := expfmt.NewDecoder(resp.Body, expfmt.ResponseFormat(resp.Header))
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, time.Time{}, &ScrapeParseError{URL: s.URL, Err: err}
+		}
+		families = append(families, family)
+	}
+	samples, err := expfmt.ExtractSamples(&expfmt.DecodeOptions{Timestamp: model.TimeFromUnixNano(now.UnixNano())}, families...)
+	if err != nil {
+		return nil, time.Time{}, &ScrapeParseError{URL: s.URL, Err: err}
+	}
+	for _, sample := range samples {
+		for k, v := range s.Config.Labels {
+			sample.Metric[k] = v
+		}
+	}
+
+	// The (empty) result of a dry run isn't kept
+	if DryRunFromContext(ctx) != nil {
+		return samples, now, nil
+	}
+	s.samples = samples
+	s.scrapedAt = now
+	return samples, now, nil
+}
+
+// current returns whether there is data at t
+func (s *ScrapeAPI) current(t time.Time) bool {
+	return time.Since(t) <= s.lookback()
+}
+
+func (s *ScrapeAPI) historyWarning() api.Warnings {
+	return api.Warnings{fmt.Sprintf("%s only has its current values, none are returned for times older than %v", s.URL, s.lookback())}
+}
+
+// selectSamples returns the samples matching the matchers. The samples are those
+// of the cached scrape, so their metrics must be cloned before they are returned
+func selectSamples(samples model.Vector, matchers []*labels.Matcher) model.Vector {
+	var ret model.Vector
+SAMPLES:
+	for _, sample := range samples {
+		for _, m := range matchers {
+			if !m.Matches(string(sample.Metric[model.LabelName(m.Name)])) {
+				continue SAMPLES
+			}
+		}
+		ret = append(ret, sample)
+	}
+	return ret
+}
+
+// selectorMatchers returns the matchers of the query, which must be a selector
+func selectorMatchers(query string) ([]*labels.Matcher, error) {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return nil, err
+	}
+	selector, ok := expr.(*promql.VectorSelector)
+	if !ok || selector.Offset != 0 {
+		return nil, ScrapeQueryError(query)
+	}
+	return selector.LabelMatchers, nil
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (s *ScrapeAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	samples, _, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	names := make(map[string]struct{})
+	for _, sample := range samples {
+		for k := range sample.Metric {
+			names[string(k)] = struct{}{}
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (s *ScrapeAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	samples, _, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make(map[model.LabelValue]struct{})
+	for _, sample := range samples {
+		if v, ok := sample.Metric[model.LabelName(label)]; ok {
+			values[v] = struct{}{}
+		}
+	}
+	ret := make(model.LabelValues, 0, len(values))
+	for v := range values {
+		ret = append(ret, v)
+	}
+	sort.Sort(ret)
+	return ret, nil, nil
+}
+
+// Query performs a query for the given time.
+func (s *ScrapeAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	matchers, err := selectorMatchers(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.current(ts) {
+		return model.Vector{}, s.historyWarning(), nil
+	}
+	samples, _, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	selected := selectSamples(samples, matchers)
+	ret := make(model.Vector, len(selected))
+	for i, sample := range selected {
+		ret[i] = &model.Sample{Metric: sample.Metric.Clone(), Value: sample.Value, Timestamp: model.TimeFromUnixNano(ts.UnixNano())}
+	}
+	return ret, nil, nil
+}
+
+// QueryRange performs a query for the given range.
+func (s *ScrapeAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	matchers, err := selectorMatchers(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !s.current(r.End) || r.Step <= 0 {
+		return model.Matrix{}, s.historyWarning(), nil
+	}
+	samples, _, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The current values are returned for the steps within the lookback
+	var warnings api.Warnings
+	var steps []model.Time
+	for ts := r.Start; !ts.After(r.End); ts = ts.Add(r.Step) {
+		if s.current(ts) {
+			steps = append(steps, model.TimeFromUnixNano(ts.UnixNano()))
+		}
+	}
+	if !s.current(r.Start) {
+		warnings = s.historyWarning()
+	}
+
+	selected := selectSamples(samples, matchers)
+	ret := make(model.Matrix, len(selected))
+	for i, sample := range selected {
+		values := make([]model.SamplePair, len(steps))
+		for j, ts := range steps {
+			values[j] = model.SamplePair{Timestamp: ts, Value: sample.Value}
+		}
+		ret[i] = &model.SampleStream{Metric: sample.Metric.Clone(), Values: values}
+	}
+	return ret, warnings, nil
+}
+
+// Series finds series by label matchers.
+func (s *ScrapeAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	matcherSets := make([][]*labels.Matcher, len(matches))
+	for i, match := range matches {
+		matchers, err := promql.ParseMetricSelector(match)
+		if err != nil {
+			return nil, nil, err
+		}
+		matcherSets[i] = matchers
+	}
+	if !s.current(endTime) {
+		return nil, s.historyWarning(), nil
+	}
+	samples, _, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[model.Fingerprint]struct{})
+	var ret []model.LabelSet
+	for _, matchers := range matcherSets {
+		for _, sample := range selectSamples(samples, matchers) {
+			fp := sample.Metric.Fingerprint()
+			if _, ok := seen[fp]; ok {
+				continue
+			}
+			seen[fp] = struct{}{}
+			ret = append(ret, model.LabelSet(sample.Metric.Clone()))
+		}
+	}
+	return ret, nil, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (s *ScrapeAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if !s.current(end) {
+		return model.Matrix{}, s.historyWarning(), nil
+	}
+	samples, scrapedAt, err := s.scrape(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The scrape is the value at the end of the range, even if it happened after
+	// the query was started
+	ts := model.TimeFromUnixNano(scrapedAt.UnixNano())
+	if scrapedAt.After(end) {
+		ts = model.TimeFromUnixNano(end.UnixNano())
+	}
+	selected := selectSamples(samples, matchers)
+	ret := make(model.Matrix, len(selected))
+	for i, sample := range selected {
+		ret[i] = &model.SampleStream{
+			Metric: sample.Metric.Clone(),
+			Values: []model.SamplePair{{Timestamp: ts, Value: sample.Value}},
+		}
+	}
+	return ret, nil, nil
+}
@@ -0,0 +1,102 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+const scrapeExposition = `# TYPE up gauge
+up{job="a"} 1
+up{job="b"} 0
+# TYPE requests_total counter
+requests_total 42
+`
+
+func newScrapeServer(body string) (*httptest.Server, *int64) {
+	var scrapes int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&scrapes, 1)
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		fmt.Fprint(w, body)
+	}))
+	return srv, &scrapes
+}
+
+func TestScrapeAPI(t *testing.T) {
+	srv, scrapes := newScrapeServer(scrapeExposition)
+	defer srv.Close()
+	s := &ScrapeAPI{
+		URL:    srv.URL,
+		Config: ScrapeConfig{CacheTTL: time.Minute, Labels: model.LabelSet{"instance": "edge"}},
+	}
+
+	now := time.Now()
+	v, w, err := s.Query(context.TODO(), `up{job="a"}`, now)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := model.Vector{
+		{Metric: model.Metric{"__name__": "up", "job": "a", "instance": "edge"}, Value: 1, Timestamp: model.TimeFromUnixNano(now.UnixNano())},
+	}
+	if len(w) > 0 || !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch in query expected=%v actual=%v (%v)", expected, v, w)
+	}
+
+	// The scrape is cached
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, model.MetricNameLabel, "requests_total")}
+	v, _, err = s.GetValue(context.TODO(), now.Add(-time.Minute), now, matchers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if matrix := v.(model.Matrix); len(matrix) != 1 || len(matrix[0].Values) != 1 || matrix[0].Values[0].Value != 42 {
+		t.Fatalf("mismatch in get value: %v", v)
+	}
+	values, _, err := s.LabelValues(context.TODO(), "job")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(values, model.LabelValues{"a", "b"}) {
+		t.Fatalf("mismatch in label values: %v", values)
+	}
+	if n := atomic.LoadInt64(scrapes); n != 1 {
+		t.Fatalf("mismatch in scrapes expected=%d actual=%d", 1, n)
+	}
+
+	// There is no history
+	v, w, err = s.GetValue(context.TODO(), now.Add(-2*time.Hour), now.Add(-time.Hour), matchers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v.(model.Matrix)) != 0 || len(w) != 1 {
+		t.Fatalf("expected an empty result with a warning, got %v (%v)", v, w)
+	}
+
+	// Only selectors can be queried
+	_, _, err = s.Query(context.TODO(), `sum(up)`, now)
+	if _, ok := err.(ScrapeQueryError); !ok || CategorizeError(err) != ErrorCategoryBadData {
+		t.Fatalf("mismatch in error: %v", err)
+	}
+}
+
+func TestScrapeAPIParseError(t *testing.T) {
+	srv, _ := newScrapeServer("up{job=\"a\" 1\n")
+	defer srv.Close()
+	s := &ScrapeAPI{URL: srv.URL}
+
+	_, _, err := s.Query(context.TODO(), "up", time.Now())
+	if _, ok := err.(*ScrapeParseError); !ok {
+		t.Fatalf("mismatch in error type: %T %v", err, err)
+	}
+	if category := CategorizeError(err); category != ErrorCategoryBadResponse {
+		t.Fatalf("mismatch in category expected=%s actual=%s", ErrorCategoryBadResponse, category)
+	}
+}
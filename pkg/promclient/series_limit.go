@@ -0,0 +1,115 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// SeriesLimitWarning is the warning of a Series result which was truncated to the
+// limit, the same as prometheus returns
+const SeriesLimitWarning = "results truncated due to limit"
+
+// seriesLimitVersion is the first prometheus version whose Series API supports
+// the limit param
+var seriesLimitVersion = [3]int{2, 51, 0}
+
+type seriesLimitKey struct{}
+
+// WithSeriesLimit returns a context carrying the max number of series a Series
+// call should return. Results beyond the limit are dropped (with a
+// SeriesLimitWarning), by the downstream if it supports the limit param.
+func WithSeriesLimit(ctx context.Context, limit int) context.Context {
+	return context.WithValue(ctx, seriesLimitKey{}, limit)
+}
+
+// SeriesLimitFromContext returns the series limit of the context (0 if there is
+// none)
+func SeriesLimitFromContext(ctx context.Context) int {
+	limit, _ := ctx.Value(seriesLimitKey{}).(int)
+	return limit
+}
+
+// SeriesLimitSupport defines whether the limit param is sent with Series calls
+type SeriesLimitSupport string
+
+// The series limit supports
+const (
+	// SeriesLimitAuto detects the support from the version of the downstream (the
+	// default)
+	SeriesLimitAuto SeriesLimitSupport = "auto"
+	// SeriesLimitEnabled always sends the limit param
+	SeriesLimitEnabled SeriesLimitSupport = "enabled"
+	// SeriesLimitDisabled never sends the limit param, the results are truncated
+	// by promproxy instead
+	SeriesLimitDisabled SeriesLimitSupport = "disabled"
+)
+
+// Validate returns an error if the support isn't known
+func (s SeriesLimitSupport) Validate() error {
+	switch s {
+	case "", SeriesLimitAuto, SeriesLimitEnabled, SeriesLimitDisabled:
+		return nil
+	default:
+		return fmt.Errorf("unknown series limit support %q", s)
+	}
+}
+
+// errSeriesLimit stops a StreamSeries call at the limit
+var errSeriesLimit = fmt.Errorf("series limit reached")
+
+// seriesLimiter truncates a stream of series to the limit
+type seriesLimiter struct {
+	limit int
+	count int
+	// truncated is set once this limiter stopped the stream, as errSeriesLimit
+	// may also come from a limiter further up the stream
+	truncated bool
+}
+
+// wrap returns the SeriesFunc passing the first limit labelsets on to fn
+func (s *seriesLimiter) wrap(fn SeriesFunc) SeriesFunc {
+	return func(ls model.LabelSet) error {
+		if s.count >= s.limit {
+			s.truncated = true
+			return errSeriesLimit
+		}
+		s.count++
+		return fn(ls)
+	}
+}
+
+// result returns the result of a stream wrapped by the limiter
+func (s *seriesLimiter) result(w api.Warnings, err error) (api.Warnings, error) {
+	if s.truncated && err == errSeriesLimit {
+		return append(w, SeriesLimitWarning), nil
+	}
+	return w, err
+}
+
+// versionAtLeast returns whether the semver version (e.g. "2.51.0-rc.0") is at
+// least min, versions which can't be parsed aren't
+func versionAtLeast(version string, min [3]int) bool {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return false
+		}
+		if n != min[i] {
+			return n > min[i]
+		}
+	}
+	return true
+}
@@ -0,0 +1,202 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+func TestSeriesStreamClientLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string // empty means no build info API
+		support SeriesLimitSupport
+		// limitParam is whether the limit param is expected to be sent
+		limitParam bool
+	}{
+		{name: "capable", version: "2.51.0", limitParam: true},
+		{name: "capable newer", version: "v3.0.1", limitParam: true},
+		{name: "too old", version: "2.50.1-rc.0"},
+		{name: "no build info"},
+		{name: "enabled", support: SeriesLimitEnabled, limitParam: true},
+		{name: "disabled", version: "2.51.0", support: SeriesLimitDisabled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var limitParams []string
+			buildInfoCalls := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/api/v1/status/buildinfo":
+					buildInfoCalls++
+					if test.version == "" {
+						http.NotFound(w, r)
+						return
+					}
+					fmt.Fprintf(w, `{"status":"success","data":{"version":%q}}`, test.version)
+				case "/api/v1/series":
+					limitParams = append(limitParams, r.FormValue("limit"))
+					// A capable backend stops at the limit, others return everything
+					n := 10
+					if limit, _ := strconv.Atoi(r.FormValue("limit")); limit > 0 && limit < n {
+						w.Write([]byte(`{"status":"success","data":[{"__name__":"up","job":"0"},{"__name__":"up","job":"1"},{"__name__":"up","job":"2"}],"warnings":["results truncated due to limit"]}`))
+						return
+					}
+					w.Write(seriesResponse(n))
+				default:
+					http.NotFound(w, r)
+				}
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			client := &SeriesStreamClient{
+				Client:       srv.Client(),
+				URL:          u,
+				SeriesLimit:  test.support,
+				Capabilities: NewCapabilityProbe(u.Host, srv.Client(), u),
+			}
+
+			ctx := WithSeriesLimit(context.TODO(), 3)
+			for i := 0; i < 2; i++ {
+				count := 0
+				w, err := client.StreamSeries(ctx, []string{"up"}, time.Unix(0, 0), time.Unix(100, 0), func(ls model.LabelSet) error {
+					count++
+					return nil
+				})
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if count != 3 {
+					t.Fatalf("mismatch in series count expected=%d actual=%d", 3, count)
+				}
+				if !reflect.DeepEqual(w, api.Warnings{SeriesLimitWarning}) {
+					t.Fatalf("mismatch in warnings expected=%v actual=%v", api.Warnings{SeriesLimitWarning}, w)
+				}
+			}
+
+			expectedParam := ""
+			if test.limitParam {
+				expectedParam = "3"
+			}
+			for _, param := range limitParams {
+				if param != expectedParam {
+					t.Fatalf("mismatch in limit param expected=%q actual=%q", expectedParam, param)
+				}
+			}
+			// The support is only detected once
+			if test.support == "" && buildInfoCalls != 1 {
+				t.Fatalf("mismatch in build info calls expected=%d actual=%d", 1, buildInfoCalls)
+			}
+			if test.support != "" && buildInfoCalls != 0 {
+				t.Fatalf("mismatch in build info calls expected=%d actual=%d", 0, buildInfoCalls)
+			}
+
+			// Without a limit nothing is truncated
+			count := 0
+			if _, err := client.StreamSeries(context.TODO(), []string{"up"}, time.Unix(0, 0), time.Unix(100, 0), func(ls model.LabelSet) error {
+				count++
+				return nil
+			}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count != 10 || limitParams[len(limitParams)-1] != "" {
+				t.Fatalf("mismatch in unlimited series expected=%d actual=%d (limit %q)", 10, count, limitParams[len(limitParams)-1])
+			}
+		})
+	}
+}
+
+func TestMultiAPISeriesLimit(t *testing.T) {
+	series := func(jobs ...string) func() []model.LabelSet {
+		return func() []model.LabelSet {
+			ret := make([]model.LabelSet, len(jobs))
+			for i, job := range jobs {
+				ret[i] = model.LabelSet{model.MetricNameLabel: "up", "job": model.LabelValue(job)}
+			}
+			return ret
+		}
+	}
+
+	// Each api is within the limit, their union isn't
+	multi := NewMultiAPI([]API{
+		&stubAPI{series: series("a", "b")},
+		&stubAPI{series: series("b", "c")},
+	}, model.Time(0), nil, 1)
+
+	tests := []struct {
+		limit     int
+		count     int
+		truncated bool
+	}{
+		{limit: 0, count: 3},
+		{limit: 3, count: 3},
+		{limit: 2, count: 2, truncated: true},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.Itoa(test.limit), func(t *testing.T) {
+			ctx := WithSeriesLimit(context.TODO(), test.limit)
+
+			v, w, err := multi.Series(ctx, []string{"up"}, time.Unix(0, 0), time.Unix(100, 0))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(v) != test.count {
+				t.Fatalf("mismatch in series count expected=%d actual=%d", test.count, len(v))
+			}
+			if truncated := len(w) == 1 && w[0] == SeriesLimitWarning; truncated != test.truncated {
+				t.Fatalf("mismatch in truncated expected=%v actual=%v (%v)", test.truncated, truncated, w)
+			}
+
+			count := 0
+			w, err = multi.StreamSeries(ctx, []string{"up"}, time.Unix(0, 0), time.Unix(100, 0), func(ls model.LabelSet) error {
+				count++
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if count != test.count {
+				t.Fatalf("mismatch in streamed series count expected=%d actual=%d", test.count, count)
+			}
+			if truncated := len(w) == 1 && w[0] == SeriesLimitWarning; truncated != test.truncated {
+				t.Fatalf("mismatch in streamed truncated expected=%v actual=%v (%v)", test.truncated, truncated, w)
+			}
+		})
+	}
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		ok      bool
+	}{
+		{"2.51.0", true},
+		{"v2.51.0", true},
+		{"2.51.2+dedupelabels", true},
+		{"2.100.0", true},
+		{"3.0.0", true},
+		{"2.51.0-rc.1", true},
+		{"2.50.9", false},
+		{"1.8.2", false},
+		{"2.51", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		if ok := versionAtLeast(test.version, seriesLimitVersion); ok != test.ok {
+			t.Fatalf("mismatch in versionAtLeast(%q) expected=%v actual=%v", test.version, test.ok, ok)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// SeriesFunc is called for each labelset of a streamed Series result, returning
+// an error stops the stream
+type SeriesFunc func(model.LabelSet) error
+
+// SeriesStreamer is implemented by APIs which can stream the result of a Series
+// call instead of returning it all at once. This bounds the memory used for
+// broad matchers, which can match millions of series.
+type SeriesStreamer interface {
+	// StreamSeries finds series by label matchers, calling fn for each labelset
+	StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error)
+}
+
+// StreamSeries streams the Series result of the client, if the client isn't a
+// SeriesStreamer the full result is loaded and then streamed to fn
+func StreamSeries(ctx context.Context, client API, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if streamer, ok := client.(SeriesStreamer); ok {
+		return streamer.StreamSeries(ctx, matches, startTime, endTime, fn)
+	}
+
+	v, w, err := client.Series(ctx, matches, startTime, endTime)
+	if err != nil {
+		return w, err
+	}
+	for _, ls := range v {
+		if err := fn(ls); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
+}
+
+// DecodeSeriesStream decodes a Series response from the prometheus HTTP API,
+// calling fn for each labelset as it is decoded (instead of decoding the whole
+// response into memory)
+func DecodeSeriesStream(r io.Reader, fn SeriesFunc) (api.Warnings, error) {
+	return decodeResponseStream(r, func(dec *json.Decoder) error {
+		return decodeSeriesData(dec, fn)
+	})
+}
+
+// decodeResponseStream decodes a response from the prometheus HTTP API, calling
+// decodeData to decode its data section from the decoder
+func decodeResponseStream(r io.Reader, decodeData func(*json.Decoder) error) (api.Warnings, error) {
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		return nil, err
+	}
+
+	var (
+		status    string
+		errorType string
+		errorMsg  string
+		warnings  api.Warnings
+	)
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return warnings, err
+		}
+
+		switch key {
+		case "status":
+			err = dec.Decode(&status)
+		case "errorType":
+			err = dec.Decode(&errorType)
+		case "error":
+			err = dec.Decode(&errorMsg)
+		case "warnings":
+			err = dec.Decode(&warnings)
+		case "data":
+			err = decodeData(dec)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return warnings, err
+		}
+	}
+
+	if status != string(promutil.StatusSuccess) {
+		return warnings, &v1.Error{Type: v1.ErrorType(errorType), Msg: errorMsg}
+	}
+	return warnings, nil
+}
+
+// decodeSeriesData decodes the data section of a Series response
+func decodeSeriesData(dec *json.Decoder, fn SeriesFunc) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	// data is null on errors
+	if t == nil {
+		return nil
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '[' {
+		return fmt.Errorf("unexpected token in series data: %v", t)
+	}
+
+	for dec.More() {
+		var ls model.LabelSet
+		if err := dec.Decode(&ls); err != nil {
+			return err
+		}
+		if err := fn(ls); err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, ']')
+}
+
+func expectDelim(dec *json.Decoder, expected json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != expected {
+		return fmt.Errorf("unexpected token %v expected %v", t, expected)
+	}
+	return nil
+}
+
+// SeriesStreamClient implements SeriesStreamer (and StreamingAPI) by calling the
+// Series (and query) endpoint of the prometheus HTTP API directly and decoding
+// the response as it is read. It implements LabelNamesInRanger through the labels
+// endpoint as well, which v1.API can't restrict to a time range
+type SeriesStreamClient struct {
+	API
+	Client *http.Client
+	URL    *url.URL
+	// SeriesLimit defines whether the series limit of the context (see
+	// WithSeriesLimit) is sent as the limit param, or the response is truncated
+	// as it is read instead (SeriesLimitAuto if unset)
+	SeriesLimit SeriesLimitSupport
+	// Capabilities detects the support of the limit param for SeriesLimitAuto,
+	// without it the param isn't sent
+	Capabilities *CapabilityProbe
+}
+
+// supportsSeriesLimit returns whether the limit param is sent with Series calls
+func (c *SeriesStreamClient) supportsSeriesLimit(ctx context.Context) bool {
+	switch c.SeriesLimit {
+	case SeriesLimitEnabled:
+		return true
+	case SeriesLimitDisabled:
+		return false
+	default:
+		return c.Capabilities != nil && c.Capabilities.SeriesLimit(ctx)
+	}
+}
+
+// Series finds series by label matchers. With a series limit in the context the
+// series are streamed, so the limit is applied the same way as for StreamSeries
+func (c *SeriesStreamClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if SeriesLimitFromContext(ctx) <= 0 {
+		return c.API.Series(ctx, matches, startTime, endTime)
+	}
+	var result []model.LabelSet
+	w, err := c.StreamSeries(ctx, matches, startTime, endTime, func(ls model.LabelSet) error {
+		result = append(result, ls)
+		return nil
+	})
+	if err != nil {
+		return nil, w, err
+	}
+	return result, w, nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset. A
+// series limit in the context is sent to a downstream which supports it, for
+// other downstreams the response is truncated to the limit as it is read.
+func (c *SeriesStreamClient) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	u := *c.URL
+	u.Path = path.Join(u.Path, "api/v1/series")
+	q := u.Query()
+	for _, m := range matches {
+		q.Add("match[]", m)
+	}
+	q.Set("start", promutil.FormatTimestamp(startTime))
+	q.Set("end", promutil.FormatTimestamp(endTime))
+	if limit := SeriesLimitFromContext(ctx); limit > 0 {
+		if c.supportsSeriesLimit(ctx) {
+			q.Set("limit", strconv.Itoa(limit))
+		} else {
+			limiter := &seriesLimiter{limit: limit}
+			return limiter.result(c.streamSeries(ctx, u, q, limiter.wrap(fn)))
+		}
+	}
+	return c.streamSeries(ctx, u, q, fn)
+}
+
+func (c *SeriesStreamClient) streamSeries(ctx context.Context, u url.URL, q url.Values, fn SeriesFunc) (api.Warnings, error) {
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// Errors from fn are returned as-is, anything else is a problem with the response
+	var fnErr error
+	w, err := DecodeSeriesStream(resp.Body, func(ls model.LabelSet) error {
+		fnErr = fn(ls)
+		return fnErr
+	})
+	if err != nil && err != fnErr {
+		if _, ok := err.(*v1.Error); !ok {
+			err = &v1.Error{Type: v1.ErrBadResponse, Msg: errors.Wrapf(err, "error decoding series response (status %d)", resp.StatusCode).Error()}
+		}
+	}
+	return w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range
+// in sorted order, through the start and end params of the labels endpoint
+func (c *SeriesStreamClient) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	u := *c.URL
+	u.Path = path.Join(u.Path, "api/v1/labels")
+	q := u.Query()
+	q.Set("start", promutil.FormatTimestamp(startTime))
+	q.Set("end", promutil.FormatTimestamp(endTime))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	var names []string
+	w, err := decodeResponseStream(resp.Body, func(dec *json.Decoder) error {
+		return dec.Decode(&names)
+	})
+	if err != nil {
+		if _, ok := err.(*v1.Error); !ok {
+			err = &v1.Error{Type: v1.ErrBadResponse, Msg: errors.Wrapf(err, "error decoding labels response (status %d)", resp.StatusCode).Error()}
+		}
+		return nil, w, err
+	}
+	return names, w, nil
+}
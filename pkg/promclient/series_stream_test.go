@@ -0,0 +1,227 @@
+package promclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+func TestDecodeSeriesStream(t *testing.T) {
+	tests := []struct {
+		body     string
+		count    int
+		warnings int
+		err      bool
+	}{
+		{
+			body:  `{"status":"success","data":[{"__name__":"up","job":"a"},{"__name__":"up","job":"b"}]}`,
+			count: 2,
+		},
+		{
+			body:     `{"status":"success","data":[],"warnings":["partial response"]}`,
+			warnings: 1,
+		},
+		// Data before status, and unknown keys are skipped
+		{
+			body:  `{"data":[{"__name__":"up"}],"other":{"a":[1,2]},"status":"success"}`,
+			count: 1,
+		},
+		{
+			body: `{"status":"error","errorType":"bad_data","error":"parse error","data":null}`,
+			err:  true,
+		},
+		{
+			body: `{"status":"success","data":[{"__name__":"up"}`,
+			err:  true,
+		},
+		{
+			body: `<html>bad gateway</html>`,
+			err:  true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			count := 0
+			w, err := DecodeSeriesStream(strings.NewReader(test.body), func(ls model.LabelSet) error {
+				count++
+				return nil
+			})
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if !test.err && count != test.count {
+				t.Fatalf("mismatch in count expected=%d actual=%d", test.count, count)
+			}
+			if len(w) != test.warnings {
+				t.Fatalf("mismatch in warnings expected=%d actual=%v", test.warnings, w)
+			}
+		})
+	}
+
+	// An error from fn stops the stream
+	stopErr := fmt.Errorf("stop")
+	_, err := DecodeSeriesStream(strings.NewReader(tests[0].body), func(ls model.LabelSet) error {
+		return stopErr
+	})
+	if err != stopErr {
+		t.Fatalf("mismatch in error expected=%v actual=%v", stopErr, err)
+	}
+}
+
+func TestMultiAPIStreamSeries(t *testing.T) {
+	series := func(jobs ...string) func() []model.LabelSet {
+		return func() []model.LabelSet {
+			ret := make([]model.LabelSet, len(jobs))
+			for i, job := range jobs {
+				ret[i] = model.LabelSet{model.MetricNameLabel: "up", "job": model.LabelValue(job)}
+			}
+			return ret
+		}
+	}
+
+	multi := NewMultiAPI([]API{
+		&stubAPI{series: series("a", "b")},
+		&stubAPI{series: series("b", "c")},
+	}, model.Time(0), nil, 1)
+
+	seen := make(map[model.LabelValue]int)
+	if _, err := multi.StreamSeries(context.TODO(), []string{"up"}, time.Unix(0, 0), time.Unix(100, 0), func(ls model.LabelSet) error {
+		seen[ls["job"]]++
+		return nil
+	}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(seen) != 3 {
+		t.Fatalf("mismatch in series expected=3 actual=%v", seen)
+	}
+	for job, count := range seen {
+		if count != 1 {
+			t.Fatalf("series for job %s not deduplicated: %d", job, count)
+		}
+	}
+}
+
+func TestLabelNamesInRange(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/labels" || r.FormValue("start") != "0" || r.FormValue("end") != "100" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":["__name__","job"],"warnings":["partial response"]}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	// The range goes through the wrappers down to the host, clients which can't
+	// restrict the label names to it return all of them
+	multi := NewMultiAPI([]API{
+		&AddLabelClient{API: &SeriesStreamClient{Client: srv.Client(), URL: u}, Labels: model.LabelSet{"az": "a"}},
+		&stubAPI{labelNames: func() []string { return []string{"instance"} }},
+	}, model.Time(0), nil, 1)
+
+	names, w, err := LabelNamesInRange(context.TODO(), &TracingAPI{API: multi}, time.Unix(0, 0), time.Unix(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"__name__", "az", "instance", "job"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("mismatch in label names expected=%v actual=%v", expected, names)
+	}
+	if len(w) != 1 {
+		t.Fatalf("mismatch in warnings expected=%d actual=%v", 1, w)
+	}
+}
+
+// seriesResponse returns a Series response body with n labelsets
+func seriesResponse(n int) []byte {
+	data := make([]model.LabelSet, n)
+	for i := range data {
+		data[i] = model.LabelSet{
+			model.MetricNameLabel: "some_metric_name",
+			"instance":            model.LabelValue(fmt.Sprintf("host-%d.example.com:9100", i)),
+			"job":                 "node",
+		}
+	}
+	b, err := json.Marshal(map[string]interface{}{"status": "success", "data": data})
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// heapInUse returns the current size of the heap after a GC
+func heapInUse() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// heapGrowth returns how much the heap has grown since base
+func heapGrowth(base uint64) uint64 {
+	if current := heapInUse(); current > base {
+		return current - base
+	}
+	return 0
+}
+
+func BenchmarkSeriesDecode(b *testing.B) {
+	body := seriesResponse(100000)
+
+	b.Run("buffered", func(b *testing.B) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			base := heapInUse()
+			var resp struct {
+				Status   string           `json:"status"`
+				Data     []model.LabelSet `json:"data"`
+				Warnings api.Warnings     `json:"warnings"`
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				b.Fatal(err)
+			}
+			if used := heapGrowth(base); used > peak {
+				peak = used
+			}
+			runtime.KeepAlive(resp)
+		}
+		b.ReportMetric(float64(peak), "peak-heap-bytes")
+	})
+
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		var peak uint64
+		for i := 0; i < b.N; i++ {
+			base := heapInUse()
+			count := 0
+			if _, err := DecodeSeriesStream(bytes.NewReader(body), func(ls model.LabelSet) error {
+				count++
+				// Sample the heap throughout the stream
+				if count%10000 == 0 {
+					if used := heapGrowth(base); used > peak {
+						peak = used
+					}
+				}
+				return nil
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.ReportMetric(float64(peak), "peak-heap-bytes")
+	})
+}
@@ -0,0 +1,82 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// TestSpecialSampleValues decodes the values prometheus encodes as strings, through
+// the client and the merge of a MultiAPI
+func TestSpecialSampleValues(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected float64
+	}{
+		{json: "NaN", expected: math.NaN()},
+		{json: "+Inf", expected: math.Inf(1)},
+		{json: "-Inf", expected: math.Inf(-1)},
+		{json: "1.5", expected: 1.5},
+		{json: "0", expected: 0},
+	}
+
+	same := func(v model.SampleValue, expected float64) bool {
+		if math.IsNaN(expected) {
+			return math.IsNaN(float64(v))
+		}
+		return float64(v) == expected
+	}
+
+	for _, test := range tests {
+		t.Run(test.json, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/api/v1/query":
+					fmt.Fprintf(w, `{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"a"},"value":[100,"%s"]}]}}`, test.json)
+				case "/api/v1/query_range":
+					fmt.Fprintf(w, `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"job":"a"},"values":[[100,"%s"],[160,"%s"]]}]}}`, test.json, test.json)
+				}
+			}))
+			defer srv.Close()
+
+			client, err := api.NewClient(api.Config{Address: srv.URL})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			// Two hosts with the same series, so the values are merged as well
+			multi := NewMultiAPI([]API{&PromAPIV1{v1.NewAPI(client)}, &PromAPIV1{v1.NewAPI(client)}}, 0, nil, 1)
+
+			v, _, err := multi.Query(context.TODO(), "up", time.Unix(100, 0))
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			vector := v.(model.Vector)
+			if len(vector) != 1 || !same(vector[0].Value, test.expected) {
+				t.Fatalf("mismatch in query value expected=%v actual=%v", test.expected, vector)
+			}
+
+			v, _, err = multi.QueryRange(context.TODO(), "up", v1.Range{Start: time.Unix(100, 0), End: time.Unix(160, 0), Step: time.Minute})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			matrix := v.(model.Matrix)
+			if len(matrix) != 1 || len(matrix[0].Values) != 2 {
+				t.Fatalf("mismatch in query_range values expected=%v actual=%v", test.expected, matrix)
+			}
+			for _, pair := range matrix[0].Values {
+				if !same(pair.Value, test.expected) {
+					t.Fatalf("mismatch in query_range value expected=%v actual=%v", test.expected, matrix)
+				}
+			}
+		})
+	}
+}
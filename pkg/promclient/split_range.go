@@ -0,0 +1,156 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// SplitRangeAPI splits range queries which are longer than Interval into multiple
+// sequential sub-queries, stitching the results back together. This bounds the
+// size of each response from the downstream.
+//
+// Range-vector functions (such as `rate()`) need the data from before the start of
+// their window, so the start of each sub-query (after the first) is extended back by
+// the largest range (and offset) in the query and the overlapping output is trimmed
+// after stitching.
+// This way a downstream which only considers the data within the query range (such
+// as one with a time range filter) returns the same result as the unsplit query.
+type SplitRangeAPI struct {
+	API
+	Interval time.Duration
+}
+
+// queryLookback returns how far before the evaluation time the query selects data
+func queryLookback(query string) (time.Duration, error) {
+	e, err := promql.ParseExpr(query)
+	if err != nil {
+		return 0, err
+	}
+
+	var lookback time.Duration
+	var maxLookback func(promql.Node, time.Duration)
+	maxLookback = func(node promql.Node, parent time.Duration) {
+		switch n := node.(type) {
+		case *promql.MatrixSelector:
+			if l := parent + n.Range + n.Offset; l > lookback {
+				lookback = l
+			}
+			return
+		case *promql.VectorSelector:
+			if l := parent + n.Offset; l > lookback {
+				lookback = l
+			}
+			return
+		case *promql.SubqueryExpr:
+			// The selectors within a subquery are evaluated over the subquery's range
+			parent += n.Range + n.Offset
+		}
+		for _, child := range promql.Children(node) {
+			maxLookback(child, parent)
+		}
+	}
+	maxLookback(e, 0)
+
+	return lookback, nil
+}
+
+// QueryRange performs a query for the given range.
+func (s *SplitRangeAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if s.Interval <= 0 || r.Step <= 0 || r.End.Sub(r.Start) <= s.Interval {
+		return s.API.QueryRange(ctx, query, r)
+	}
+
+	lookback, err := queryLookback(query)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Keep the extended start on the step grid so the overlap lines up
+	lookbackSteps := (lookback + r.Step - 1) / r.Step
+	stepsPerSplit := s.Interval / r.Step
+	if stepsPerSplit < 1 {
+		stepsPerSplit = 1
+	}
+
+	streams := make(map[model.Fingerprint]*model.SampleStream)
+	var order []model.Fingerprint
+	warnings := make(promutil.WarningSet)
+
+	for start := r.Start; !start.After(r.End); {
+		end := start.Add((stepsPerSplit - 1) * r.Step)
+		if end.After(r.End) {
+			end = r.End
+		}
+
+		sub := v1.Range{Start: start, End: end, Step: r.Step}
+		// The first sub-query has the same data as the unsplit query would
+		if start.After(r.Start) {
+			sub.Start = start.Add(-lookbackSteps * r.Step)
+		}
+		v, w, err := s.API.QueryRange(ctx, query, sub)
+		warnings.AddWarnings(w)
+		if err != nil {
+			return nil, warnings.Warnings(), err
+		}
+
+		if v != nil {
+			matrix, ok := v.(model.Matrix)
+			if !ok {
+				return nil, warnings.Warnings(), fmt.Errorf("unexpected %s result for range query", v.Type())
+			}
+
+			// Trim the overlap and append to the streams from the previous sub-queries
+			startTs := model.TimeFromUnixNano(start.UnixNano())
+			for _, stream := range matrix {
+				i := 0
+				for i < len(stream.Values) && stream.Values[i].Timestamp.Before(startTs) {
+					i++
+				}
+				if i == len(stream.Values) {
+					continue
+				}
+
+				fp := stream.Metric.Fingerprint()
+				if existing, ok := streams[fp]; ok {
+					existing.Values = append(existing.Values, stream.Values[i:]...)
+				} else {
+					stream.Values = stream.Values[i:]
+					streams[fp] = stream
+					order = append(order, fp)
+				}
+			}
+		}
+
+		start = end.Add(r.Step)
+	}
+
+	result := make(model.Matrix, len(order))
+	for i, fp := range order {
+		result[i] = streams[fp]
+	}
+	return result, warnings.Warnings(), nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (s *SplitRangeAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, s.API, matches, startTime, endTime, fn)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (s *SplitRangeAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	return StreamGetValue(ctx, s.API, start, end, matchers)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (s *SplitRangeAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, s.API, startTime, endTime)
+}
@@ -0,0 +1,106 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// windowedRateAPI evaluates a `rate()` over a 5m window of a counter scraped every
+// 15s. Like a downstream with a time range filter it only considers the samples
+// within the query range, so the first points of a range lack data
+type windowedRateAPI struct {
+	API
+	queries int
+}
+
+func (w *windowedRateAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	w.queries++
+	const window = 5 * time.Minute
+	counter := func(t time.Time) float64 {
+		s := float64(t.Unix())
+		return s * s / 100
+	}
+
+	stream := &model.SampleStream{Metric: model.Metric{"job": "a"}}
+	for ts := r.Start; !ts.After(r.End); ts = ts.Add(r.Step) {
+		// The samples within (ts-window, ts] which are in the query range
+		var first, last time.Time
+		for sample := time.Unix(0, 0); !sample.After(ts); sample = sample.Add(15 * time.Second) {
+			if !sample.After(ts.Add(-window)) || sample.Before(r.Start) {
+				continue
+			}
+			if first.IsZero() {
+				first = sample
+			}
+			last = sample
+		}
+		if first.IsZero() || !last.After(first) {
+			continue
+		}
+		stream.Values = append(stream.Values, model.SamplePair{
+			Timestamp: model.TimeFromUnixNano(ts.UnixNano()),
+			Value:     model.SampleValue((counter(last) - counter(first)) / last.Sub(first).Seconds()),
+		})
+	}
+	return model.Matrix{stream}, nil, nil
+}
+
+func TestSplitRangeAPIRate(t *testing.T) {
+	r := v1.Range{Start: time.Unix(3600, 0), End: time.Unix(3*3600, 0), Step: time.Minute}
+
+	baseline, _, err := (&windowedRateAPI{}).QueryRange(context.TODO(), `rate(x[5m])`, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stub := &windowedRateAPI{}
+	split := &SplitRangeAPI{API: stub, Interval: 30 * time.Minute}
+	v, _, err := split.QueryRange(context.TODO(), `rate(x[5m])`, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stub.queries != 5 {
+		t.Fatalf("mismatch in number of sub-queries expected=%d actual=%d", 5, stub.queries)
+	}
+	if !reflect.DeepEqual(v, baseline) {
+		t.Fatalf("split rate() doesn't match the unsplit baseline\nexpected=%v\nactual=%v", baseline, v)
+	}
+
+	// Without the lookback (the query has no range) the boundaries of the sub-queries
+	// lack data, so the stub is actually sensitive to the split
+	v, _, err = split.QueryRange(context.TODO(), `x`, r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reflect.DeepEqual(v, baseline) {
+		t.Fatalf("expected the split without lookback to differ from the baseline")
+	}
+}
+
+func TestQueryLookback(t *testing.T) {
+	tests := []struct {
+		query    string
+		lookback time.Duration
+	}{
+		{query: `up`, lookback: 0},
+		{query: `rate(x[5m])`, lookback: 5 * time.Minute},
+		{query: `rate(x[5m] offset 1h) / rate(y[10m])`, lookback: time.Hour + 5*time.Minute},
+		{query: `max_over_time(rate(x[5m])[1h:1m])`, lookback: time.Hour + 5*time.Minute},
+	}
+
+	for _, test := range tests {
+		lookback, err := queryLookback(test.query)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if lookback != test.lookback {
+			t.Fatalf("mismatch in lookback for %s expected=%v actual=%v", test.query, test.lookback, lookback)
+		}
+	}
+}
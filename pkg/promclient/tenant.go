@@ -0,0 +1,31 @@
+package promclient
+
+import "context"
+
+type tenantKey struct{}
+
+// WithTenant returns a context carrying the tenant which the request is made for
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant of the context (empty if there is none)
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+type virtualProxyKey struct{}
+
+// WithVirtualProxy returns a context carrying the name of the virtual proxy which
+// serves the request
+func WithVirtualProxy(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, virtualProxyKey{}, name)
+}
+
+// VirtualProxyFromContext returns the name of the virtual proxy of the context
+// (empty for the requests served by the root proxy)
+func VirtualProxyFromContext(ctx context.Context) string {
+	name, _ := ctx.Value(virtualProxyKey{}).(string)
+	return name
+}
@@ -0,0 +1,174 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrRateLimited is returned for calls rejected by a ThrottleAPI, After is how long
+// until the limiter has a token again
+type ErrRateLimited struct {
+	After time.Duration
+}
+
+func (e *ErrRateLimited) Error() string {
+	return fmt.Sprintf("rate limit exceeded, retry after %v", e.After)
+}
+
+// RetryAfter returns how long the caller should wait before retrying
+func (e *ErrRateLimited) RetryAfter() time.Duration {
+	return e.After
+}
+
+// ThrottleConfig configures a ThrottleAPI
+type ThrottleConfig struct {
+	// RequestsPerSecond is the rate at which calls are admitted
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst is the number of calls which may be admitted at once (1 if 0)
+	Burst int `yaml:"burst"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ThrottleConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = ThrottleConfig{}
+	type plain ThrottleConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c ThrottleConfig) Validate() error {
+	if c.RequestsPerSecond <= 0 {
+		return fmt.Errorf("throttle requests_per_second must be positive")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("throttle burst must not be negative")
+	}
+	return nil
+}
+
+func (c ThrottleConfig) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return 1
+}
+
+// NewThrottleAPI returns a ThrottleAPI with a full bucket
+func NewThrottleAPI(a API, cfg ThrottleConfig) *ThrottleAPI {
+	return &ThrottleAPI{API: a, cfg: cfg, tokens: cfg.burst(), last: time.Now(), now: time.Now}
+}
+
+// ThrottleAPI rate limits the calls to the wrapped API with a token bucket. Calls
+// made while the bucket is empty aren't queued but fail with an ErrRateLimited,
+// which carries the time until the next token is added so the client can retry
+// then.
+type ThrottleAPI struct {
+	API
+	mu     sync.Mutex
+	cfg    ThrottleConfig
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// take withdraws a token from the bucket, or returns an ErrRateLimited if it is empty
+func (t *ThrottleAPI) take() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if elapsed := now.Sub(t.last).Seconds(); elapsed > 0 {
+		t.tokens = math.Min(t.cfg.burst(), t.tokens+elapsed*t.cfg.RequestsPerSecond)
+	}
+	t.last = now
+
+	if t.tokens < 1 {
+		missing := 1 - t.tokens
+		return &ErrRateLimited{After: time.Duration(missing / t.cfg.RequestsPerSecond * float64(time.Second))}
+	}
+	t.tokens--
+	return nil
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (t *ThrottleAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.LabelNames(ctx)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (t *ThrottleAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return LabelNamesInRange(ctx, t.API, startTime, endTime)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (t *ThrottleAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.LabelValues(ctx, label)
+}
+
+// Query performs a query for the given time.
+func (t *ThrottleAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (t *ThrottleAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (t *ThrottleAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.Series(ctx, matches, startTime, endTime)
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (t *ThrottleAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, err
+	}
+	return StreamSeries(ctx, t.API, matches, startTime, endTime, fn)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *ThrottleAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	if err := t.take(); err != nil {
+		return nil, nil, err
+	}
+	return t.API.GetValue(ctx, start, end, matchers)
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (t *ThrottleAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if err := t.take(); err != nil {
+		return nil, err
+	}
+	return StreamGetValue(ctx, t.API, start, end, matchers)
+}
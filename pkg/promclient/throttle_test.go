@@ -0,0 +1,73 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+type countingAPI struct {
+	API
+	calls int
+}
+
+func (c *countingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	c.calls++
+	return model.Vector{}, nil, nil
+}
+
+func TestThrottleAPI(t *testing.T) {
+	stub := &countingAPI{}
+	now := time.Unix(0, 0)
+	a := NewThrottleAPI(stub, ThrottleConfig{RequestsPerSecond: 4, Burst: 2})
+	a.last = now
+	a.now = func() time.Time { return now }
+
+	tests := []struct {
+		advance time.Duration
+		after   time.Duration // 0 if the call is admitted
+	}{
+		// The burst is admitted at once
+		{advance: 0},
+		{advance: 0},
+		// Then the next token is a full interval away
+		{advance: 0, after: 250 * time.Millisecond},
+		// Partially refilled, the retry-after is the remainder
+		{advance: 125 * time.Millisecond, after: 125 * time.Millisecond},
+		{advance: 125 * time.Millisecond},
+		{advance: 0, after: 250 * time.Millisecond},
+		// The bucket doesn't fill beyond the burst
+		{advance: time.Minute},
+		{advance: 0},
+		{advance: 0, after: 250 * time.Millisecond},
+	}
+
+	admitted := 0
+	for i, test := range tests {
+		now = now.Add(test.advance)
+		_, _, err := a.Query(context.TODO(), "up", now)
+		if test.after == 0 {
+			if err != nil {
+				t.Fatalf("%d: unexpected error: %v", i, err)
+			}
+			admitted++
+			continue
+		}
+
+		rateErr, ok := err.(*ErrRateLimited)
+		if !ok {
+			t.Fatalf("%d: expected ErrRateLimited, got: %v", i, err)
+		}
+		// Allow for the rounding of the float tokens
+		if diff := rateErr.RetryAfter() - test.after; diff < -time.Microsecond || diff > time.Microsecond {
+			t.Fatalf("%d: mismatch in retry after expected=%v actual=%v", i, test.after, rateErr.RetryAfter())
+		}
+	}
+
+	if stub.calls != admitted {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", admitted, stub.calls)
+	}
+}
@@ -0,0 +1,155 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// TimeRangePolicy defines how calls whose start is after their end are handled. A
+// buggy client (or an edge case of clamping) can send such a range, which the
+// downstreams answer with confusing errors.
+type TimeRangePolicy string
+
+// The time range policies
+const (
+	// TimeRangeReject fails the call with an ErrInvalidTimeRange (the default)
+	TimeRangeReject TimeRangePolicy = "reject"
+	// TimeRangeSwap swaps the start and end with a warning
+	TimeRangeSwap TimeRangePolicy = "swap"
+)
+
+// ErrInvalidTimeRange is returned for calls rejected as their start is after their end
+type ErrInvalidTimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+func (e *ErrInvalidTimeRange) Error() string {
+	return fmt.Sprintf("invalid time range: start %s is after end %s", formatTime(e.Start), formatTime(e.End))
+}
+
+// TimeRangeConfig configures the handling of calls whose start is after their end
+type TimeRangeConfig struct {
+	// Policy is the TimeRangePolicy (TimeRangeReject if unset)
+	Policy TimeRangePolicy `yaml:"policy"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *TimeRangeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = TimeRangeConfig{}
+	type plain TimeRangeConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c TimeRangeConfig) Validate() error {
+	switch c.Policy {
+	case "", TimeRangeReject, TimeRangeSwap:
+		return nil
+	default:
+		return fmt.Errorf("unknown time range policy %q", c.Policy)
+	}
+}
+
+// Normalize applies the policy to the range, returning the range to use and the
+// warning if it was swapped
+func (c TimeRangeConfig) Normalize(start, end time.Time) (time.Time, time.Time, api.Warnings, error) {
+	if !start.After(end) {
+		return start, end, nil, nil
+	}
+	if c.Policy != TimeRangeSwap {
+		return start, end, nil, &ErrInvalidTimeRange{Start: start, End: end}
+	}
+	return end, start, api.Warnings{fmt.Sprintf("start %s is after end %s, swapped them", formatTime(start), formatTime(end))}, nil
+}
+
+// NewTimeRangeAPI returns a TimeRangeAPI
+func NewTimeRangeAPI(a API, cfg TimeRangeConfig) *TimeRangeAPI {
+	return &TimeRangeAPI{API: a, cfg: cfg}
+}
+
+// TimeRangeAPI applies the TimeRangePolicy to the calls to the wrapped API, so an
+// inverted range either fails clearly or is swapped before it is sent downstream
+type TimeRangeAPI struct {
+	API
+	cfg TimeRangeConfig
+}
+
+// QueryRange performs a query for the given range.
+func (t *TimeRangeAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	var (
+		warnings api.Warnings
+		err      error
+	)
+	r.Start, r.End, warnings, err = t.cfg.Normalize(r.Start, r.End)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := t.API.QueryRange(ctx, query, r)
+	return v, append(warnings, w...), err
+}
+
+// Series finds series by label matchers.
+func (t *TimeRangeAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	startTime, endTime, warnings, err := t.cfg.Normalize(startTime, endTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := t.API.Series(ctx, matches, startTime, endTime)
+	return v, append(warnings, w...), err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (t *TimeRangeAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	startTime, endTime, warnings, err := t.cfg.Normalize(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	w, err := StreamSeries(ctx, t.API, matches, startTime, endTime, fn)
+	return append(warnings, w...), err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (t *TimeRangeAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	startTime, endTime, warnings, err := t.cfg.Normalize(startTime, endTime)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := LabelNamesInRange(ctx, t.API, startTime, endTime)
+	return v, append(warnings, w...), err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *TimeRangeAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	start, end, warnings, err := t.cfg.Normalize(start, end)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := t.API.GetValue(ctx, start, end, matchers)
+	return v, append(warnings, w...), err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (t *TimeRangeAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	start, end, warnings, err := t.cfg.Normalize(start, end)
+	if err != nil {
+		return nil, err
+	}
+	ch, err := StreamGetValue(ctx, t.API, start, end, matchers)
+	if err != nil || len(warnings) == 0 {
+		return ch, err
+	}
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		return append(warnings, w...), err
+	}), nil
+}
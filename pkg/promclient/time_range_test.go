@@ -0,0 +1,72 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// rangeRecordingAPI records the range of the QueryRange calls sent to it
+type rangeRecordingAPI struct {
+	API
+	r     v1.Range
+	calls int
+}
+
+func (r *rangeRecordingAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, api.Warnings, error) {
+	r.calls++
+	r.r = rng
+	return model.Matrix{}, nil, nil
+}
+
+func TestTimeRangeAPI(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := time.Unix(2000, 0)
+	inverted := v1.Range{Start: end, End: start, Step: time.Minute}
+
+	tests := []struct {
+		policy TimeRangePolicy
+		err    bool
+	}{
+		// Erroring is the default, so bugs aren't masked
+		{policy: "", err: true},
+		{policy: TimeRangeReject, err: true},
+		{policy: TimeRangeSwap},
+	}
+
+	for _, test := range tests {
+		stub := &rangeRecordingAPI{}
+		a := NewTimeRangeAPI(stub, TimeRangeConfig{Policy: test.policy})
+		_, w, err := a.QueryRange(context.TODO(), "up", inverted)
+		if test.err {
+			if _, ok := err.(*ErrInvalidTimeRange); !ok {
+				t.Fatalf("mismatch in error of policy %q expected=%T actual=%v", test.policy, &ErrInvalidTimeRange{}, err)
+			}
+			if stub.calls != 0 {
+				t.Fatalf("rejected call of policy %q was sent downstream", test.policy)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !stub.r.Start.Equal(start) || !stub.r.End.Equal(end) || stub.r.Step != time.Minute {
+			t.Fatalf("mismatch in downstream range expected=%v-%v actual=%v-%v", start, end, stub.r.Start, stub.r.End)
+		}
+		if len(w) != 1 {
+			t.Fatalf("mismatch in warnings expected=%d actual=%v", 1, w)
+		}
+	}
+
+	// Ranges in order are passed on as is
+	stub := &rangeRecordingAPI{}
+	_, w, err := NewTimeRangeAPI(stub, TimeRangeConfig{}).QueryRange(context.TODO(), "up", v1.Range{Start: start, End: end, Step: time.Minute})
+	if err != nil || len(w) != 0 || !stub.r.Start.Equal(start) {
+		t.Fatalf("mismatch in call of a valid range err=%v warnings=%v range=%v", err, w, stub.r)
+	}
+}
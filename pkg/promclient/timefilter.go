@@ -42,6 +42,22 @@ func (tf *AbsoluteTimeFilter) Series(ctx context.Context, matches []string, star
 	return tf.API.Series(ctx, matches, startTime, endTime)
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (tf *AbsoluteTimeFilter) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	if (!tf.Start.IsZero() && endTime.Before(tf.Start)) || (!tf.End.IsZero() && startTime.After(tf.End)) {
+		return nil, nil
+	}
+	return StreamSeries(ctx, tf.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (tf *AbsoluteTimeFilter) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	if (!tf.Start.IsZero() && endTime.Before(tf.Start)) || (!tf.End.IsZero() && startTime.After(tf.End)) {
+		return nil, nil, nil
+	}
+	return LabelNamesInRange(ctx, tf.API, startTime, endTime)
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (tf *AbsoluteTimeFilter) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	if (!tf.Start.IsZero() && end.Before(tf.Start)) || (!tf.End.IsZero() && start.After(tf.End)) {
@@ -51,6 +67,15 @@ func (tf *AbsoluteTimeFilter) GetValue(ctx context.Context, start, end time.Time
 	return tf.API.GetValue(ctx, start, end, matchers)
 }
 
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (tf *AbsoluteTimeFilter) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if (!tf.Start.IsZero() && end.Before(tf.Start)) || (!tf.End.IsZero() && start.After(tf.End)) {
+		return emptyValueStream(), nil
+	}
+	return StreamGetValue(ctx, tf.API, start, end, matchers)
+}
+
 // RelativeTimeFilter will filter queries out (return nil,nil) for all queries outside the given durations relative to time.Now()
 type RelativeTimeFilter struct {
 	API
@@ -100,6 +125,24 @@ func (tf *RelativeTimeFilter) Series(ctx context.Context, matches []string, star
 	return tf.API.Series(ctx, matches, startTime, endTime)
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (tf *RelativeTimeFilter) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	tfStart, tfEnd := tf.window()
+	if (!tfStart.IsZero() && endTime.Before(tfStart)) || (!tfEnd.IsZero() && startTime.After(tfEnd)) {
+		return nil, nil
+	}
+	return StreamSeries(ctx, tf.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (tf *RelativeTimeFilter) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	tfStart, tfEnd := tf.window()
+	if (!tfStart.IsZero() && endTime.Before(tfStart)) || (!tfEnd.IsZero() && startTime.After(tfEnd)) {
+		return nil, nil, nil
+	}
+	return LabelNamesInRange(ctx, tf.API, startTime, endTime)
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (tf *RelativeTimeFilter) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	tfStart, tfEnd := tf.window()
@@ -109,3 +152,13 @@ func (tf *RelativeTimeFilter) GetValue(ctx context.Context, start, end time.Time
 
 	return tf.API.GetValue(ctx, start, end, matchers)
 }
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (tf *RelativeTimeFilter) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	tfStart, tfEnd := tf.window()
+	if (!tfStart.IsZero() && end.Before(tfStart)) || (!tfEnd.IsZero() && start.After(tfEnd)) {
+		return emptyValueStream(), nil
+	}
+	return StreamGetValue(ctx, tf.API, start, end, matchers)
+}
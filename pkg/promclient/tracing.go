@@ -0,0 +1,123 @@
+package promclient
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/tracing"
+)
+
+// TracingAPI records a span (see tracing.StartSpan) for each call to the upstream
+// with the given name. Calls without a trace in their context aren't traced.
+type TracingAPI struct {
+	API
+	Name string
+}
+
+func (t *TracingAPI) startSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.StartSpan(ctx, "promclient."+method)
+	span.SetAttribute("upstream", t.Name)
+	return ctx, span
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (t *TracingAPI) Key() model.LabelSet {
+	return apiKey(t.API)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (t *TracingAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "LabelNames")
+	v, w, err := t.API.LabelNames(ctx)
+	span.Finish(err)
+	return v, w, err
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (t *TracingAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "LabelNamesInRange")
+	v, w, err := LabelNamesInRange(ctx, t.API, startTime, endTime)
+	span.Finish(err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (t *TracingAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "LabelValues")
+	span.SetAttribute("label", label)
+	v, w, err := t.API.LabelValues(ctx, label)
+	span.Finish(err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (t *TracingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "Query")
+	span.SetAttribute("query", query)
+	v, w, err := t.API.Query(ctx, query, ts)
+	span.Finish(err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (t *TracingAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "QueryRange")
+	span.SetAttribute("query", query)
+	span.SetAttribute("step", r.Step.String())
+	v, w, err := t.API.QueryRange(ctx, query, r)
+	span.Finish(err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (t *TracingAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "Series")
+	span.SetAttribute("matches", strings.Join(matches, ","))
+	v, w, err := t.API.Series(ctx, matches, startTime, endTime)
+	span.Finish(err)
+	return v, w, err
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (t *TracingAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "StreamSeries")
+	span.SetAttribute("matches", strings.Join(matches, ","))
+	w, err := StreamSeries(ctx, t.API, matches, startTime, endTime, fn)
+	span.Finish(err)
+	return w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *TracingAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	ctx, span := t.startSpan(ctx, "GetValue")
+	strs := make([]string, len(matchers))
+	for i, m := range matchers {
+		strs[i] = m.String()
+	}
+	span.SetAttribute("matchers", strings.Join(strs, ","))
+	v, w, err := t.API.GetValue(ctx, start, end, matchers)
+	span.Finish(err)
+	return v, w, err
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (t *TracingAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	ctx, span := t.startSpan(ctx, "StreamGetValue")
+	ch, err := StreamGetValue(ctx, t.API, start, end, matchers)
+	if err != nil {
+		span.Finish(err)
+		return nil, err
+	}
+	// The span lasts until the stream ends
+	return filterValueStream(ctx, ch, nil, func(w api.Warnings, err error) (api.Warnings, error) {
+		span.Finish(err)
+		return w, err
+	}), nil
+}
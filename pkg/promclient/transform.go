@@ -0,0 +1,303 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+)
+
+// SampleTransform is a hook which may modify the labels and samples of a series
+// (e.g. converting units or clamping bogus values). Returning no samples drops the
+// series. The given metric and samples must not be modified in place, as they may
+// be shared with the caller; a transform returns copies of what it changes.
+type SampleTransform func(model.Metric, []model.SamplePair) (model.Metric, []model.SamplePair)
+
+// Transform is a named SampleTransform, the name is used in the warnings
+type Transform struct {
+	Name string
+	Func SampleTransform
+	// Matchers restrict which series are transformed, all series are if empty
+	Matchers []*labels.Matcher
+}
+
+// matches returns whether the transform applies to the series
+func (t Transform) matches(metric model.Metric) bool {
+	for _, matcher := range t.Matchers {
+		if !matcher.Matches(string(metric[model.LabelName(matcher.Name)])) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	registeredTransformsLock sync.RWMutex
+	registeredTransforms     = make(map[string][]Transform)
+)
+
+// RegisterTransform registers a transform for the given downstream (the host:port
+// of the target). This is applied in addition to the transforms from the config
+// of its servergroup
+func RegisterTransform(downstream, name string, fn SampleTransform) {
+	registeredTransformsLock.Lock()
+	defer registeredTransformsLock.Unlock()
+	registeredTransforms[downstream] = append(registeredTransforms[downstream], Transform{Name: name, Func: fn})
+}
+
+// RegisteredTransforms returns the transforms registered for the downstream
+func RegisteredTransforms(downstream string) []Transform {
+	registeredTransformsLock.RLock()
+	defer registeredTransformsLock.RUnlock()
+	return append([]Transform(nil), registeredTransforms[downstream]...)
+}
+
+// TransformType is the type of a config-driven transform
+type TransformType string
+
+// The transform types
+const (
+	// TransformScale multiplies the values by the factor
+	TransformScale TransformType = "scale"
+	// TransformClampMin raises values below the value to the value
+	TransformClampMin TransformType = "clamp_min"
+	// TransformClampMax lowers values above the value to the value
+	TransformClampMax TransformType = "clamp_max"
+)
+
+// TransformConfig is the config of a built-in transform
+type TransformConfig struct {
+	Type TransformType `yaml:"type"`
+	// Match is a series selector (e.g. `{__name__=~".*_bits"}`) restricting which
+	// series are transformed, all series are if unset
+	Match string `yaml:"match,omitempty"`
+	// Factor is what values are multiplied by for scale, it must be positive so
+	// counters stay monotonic
+	Factor float64 `yaml:"factor,omitempty"`
+	// Value is the bound for clamp_min and clamp_max
+	Value float64 `yaml:"value,omitempty"`
+}
+
+// Transform returns the Transform for the config
+func (c TransformConfig) Transform() (Transform, error) {
+	var matchers []*labels.Matcher
+	if c.Match != "" {
+		var err error
+		if matchers, err = promql.ParseMetricSelector(c.Match); err != nil {
+			return Transform{}, fmt.Errorf("invalid %s transform match %q: %v", c.Type, c.Match, err)
+		}
+	}
+
+	var apply func(float64) float64
+	switch c.Type {
+	case TransformScale:
+		// A factor which isn't positive would turn counters into decreasing (or
+		// constant) series, which rate and increase take for resets
+		if math.IsNaN(c.Factor) || math.IsInf(c.Factor, 0) || c.Factor <= 0 {
+			return Transform{}, fmt.Errorf("scale transform factor must be positive and finite, got %v", c.Factor)
+		}
+		apply = func(v float64) float64 { return v * c.Factor }
+	case TransformClampMin:
+		apply = func(v float64) float64 { return math.Max(v, c.Value) }
+	case TransformClampMax:
+		apply = func(v float64) float64 { return math.Min(v, c.Value) }
+	default:
+		return Transform{}, fmt.Errorf("unknown transform type %q", c.Type)
+	}
+
+	return Transform{
+		Name:     string(c.Type),
+		Matchers: matchers,
+		Func: func(metric model.Metric, samples []model.SamplePair) (model.Metric, []model.SamplePair) {
+			// The samples are only copied once a value changes
+			ret, copied := samples, false
+			for i, sample := range samples {
+				v := model.SampleValue(apply(float64(sample.Value)))
+				if math.Float64bits(float64(v)) == math.Float64bits(float64(sample.Value)) {
+					continue
+				}
+				if !copied {
+					ret, copied = append([]model.SamplePair(nil), samples...), true
+				}
+				ret[i].Value = v
+			}
+			return metric, ret
+		},
+	}, nil
+}
+
+// Validate returns an error if the config isn't valid
+func (c TransformConfig) Validate() error {
+	_, err := c.Transform()
+	return err
+}
+
+// TransformAPI applies the transforms to the series returned by the wrapped API. As
+// this is meant for correcting the data of a single downstream it should wrap the
+// client of that downstream, so the transforms are applied before the results are
+// merged. If a transform modifies any series a warning is added. Only raw values
+// are transformed: the results of queries other than selectors (e.g. a pushed
+// down aggregation) are computed from the untransformed samples, so they are
+// returned as-is like the results of Series and LabelValues.
+type TransformAPI struct {
+	API
+	Transforms []Transform
+}
+
+func samplesEqual(a, b []model.SamplePair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		// Compare the bits so NaN values are equal
+		if a[i].Timestamp != b[i].Timestamp || math.Float64bits(float64(a[i].Value)) != math.Float64bits(float64(b[i].Value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// transformSeries applies the transforms matching a series to it, counting the
+// series each transform modified
+func (t *TransformAPI) transformSeries(metric model.Metric, samples []model.SamplePair, modified []int) (model.Metric, []model.SamplePair) {
+	for i, transform := range t.Transforms {
+		if !transform.matches(metric) {
+			continue
+		}
+		newMetric, newSamples := transform.Func(metric, samples)
+		if !newMetric.Equal(metric) || !samplesEqual(newSamples, samples) {
+			modified[i]++
+		}
+		metric, samples = newMetric, newSamples
+		if len(samples) == 0 {
+			break
+		}
+	}
+	return metric, samples
+}
+
+// isSelector returns whether the query is a plain selector, whose result is raw
+// samples
+func isSelector(query string) bool {
+	expr, err := promql.ParseExpr(query)
+	if err != nil {
+		return false
+	}
+	switch expr.(type) {
+	case *promql.VectorSelector, *promql.MatrixSelector:
+		return true
+	default:
+		return false
+	}
+}
+
+// transformValue applies the transforms to the vector or matrix, returning the
+// warnings for the transforms which modified it
+func (t *TransformAPI) transformValue(v model.Value) (model.Value, api.Warnings) {
+	modified := make([]int, len(t.Transforms))
+
+	switch typed := v.(type) {
+	case model.Vector:
+		ret := make(model.Vector, 0, len(typed))
+		for _, sample := range typed {
+			metric, samples := t.transformSeries(sample.Metric, []model.SamplePair{{Timestamp: sample.Timestamp, Value: sample.Value}}, modified)
+			if len(samples) == 0 {
+				continue
+			}
+			ret = append(ret, &model.Sample{Metric: metric, Timestamp: samples[0].Timestamp, Value: samples[0].Value})
+		}
+		v = ret
+	case model.Matrix:
+		ret := make(model.Matrix, 0, len(typed))
+		for _, stream := range typed {
+			metric, samples := t.transformSeries(stream.Metric, stream.Values, modified)
+			if len(samples) == 0 {
+				continue
+			}
+			ret = append(ret, &model.SampleStream{Metric: metric, Values: samples})
+		}
+		v = ret
+	}
+
+	return v, t.transformWarnings(modified)
+}
+
+// transformWarnings returns the warnings for the transforms which modified any
+// series, given the counts of transformSeries
+func (t *TransformAPI) transformWarnings(modified []int) api.Warnings {
+	var warnings api.Warnings
+	for i, count := range modified {
+		if count > 0 {
+			warnings = append(warnings, fmt.Sprintf("sample transform %q modified %d series", t.Transforms[i].Name, count))
+		}
+	}
+	return warnings
+}
+
+// Query performs a query for the given time.
+func (t *TransformAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	v, w, err := t.API.Query(ctx, query, ts)
+	if err != nil || !isSelector(query) {
+		return v, w, err
+	}
+	v, transformWarnings := t.transformValue(v)
+	return v, append(w, transformWarnings...), nil
+}
+
+// QueryRange performs a query for the given range.
+func (t *TransformAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	v, w, err := t.API.QueryRange(ctx, query, r)
+	if err != nil || !isSelector(query) {
+		return v, w, err
+	}
+	v, transformWarnings := t.transformValue(v)
+	return v, append(w, transformWarnings...), nil
+}
+
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (t *TransformAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn SeriesFunc) (api.Warnings, error) {
+	return StreamSeries(ctx, t.API, matches, startTime, endTime, fn)
+}
+
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (t *TransformAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return LabelNamesInRange(ctx, t.API, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *TransformAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	v, w, err := t.API.GetValue(ctx, start, end, matchers)
+	if err != nil {
+		return v, w, err
+	}
+	v, transformWarnings := t.transformValue(v)
+	return v, append(w, transformWarnings...), nil
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// applying the transforms to each series of the stream. The warnings for the
+// transforms which modified any series are added once the stream ends.
+func (t *TransformAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	ch, err := StreamGetValue(ctx, t.API, start, end, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	modified := make([]int, len(t.Transforms))
+	return filterValueStream(ctx, ch, func(s *model.SampleStream) (*model.SampleStream, error) {
+		metric, samples := t.transformSeries(s.Metric, s.Values, modified)
+		if len(samples) == 0 {
+			return nil, nil
+		}
+		return &model.SampleStream{Metric: metric, Values: samples}, nil
+	}, func(w api.Warnings, err error) (api.Warnings, error) {
+		return append(w, t.transformWarnings(modified)...), err
+	}), nil
+}
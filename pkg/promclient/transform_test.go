@@ -0,0 +1,247 @@
+package promclient
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// valueAPI returns the configured value (and a warning) for every query
+type valueAPI struct {
+	API
+	v model.Value
+}
+
+func (s *valueAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	return s.v, api.Warnings{"upstream"}, nil
+}
+
+func (s *valueAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	return s.v, api.Warnings{"upstream"}, nil
+}
+
+func mustTransform(t *testing.T, c TransformConfig) Transform {
+	transform, err := c.Transform()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return transform
+}
+
+func TestTransformAPI(t *testing.T) {
+	bits := model.Metric{model.MetricNameLabel: "rx_bits", "job": "a"}
+	bytes := model.Metric{model.MetricNameLabel: "rx_bytes", "job": "a"}
+
+	scale := mustTransform(t, TransformConfig{Type: TransformScale, Match: `{__name__=~".*_bits"}`, Factor: 0.125})
+	clampMin := mustTransform(t, TransformConfig{Type: TransformClampMin, Value: 0})
+	clampMax := mustTransform(t, TransformConfig{Type: TransformClampMax, Match: `rx_bytes`, Value: 100})
+	drop := Transform{Name: "drop", Func: func(m model.Metric, s []model.SamplePair) (model.Metric, []model.SamplePair) {
+		if m["job"] == "broken" {
+			return m, nil
+		}
+		return m, s
+	}}
+
+	tests := []struct {
+		transforms []Transform
+		in         model.Value
+		out        model.Value
+		warnings   api.Warnings
+	}{
+		// Vector
+		{
+			transforms: []Transform{scale},
+			in:         model.Vector{{Metric: bits, Value: 80, Timestamp: 1}, {Metric: bytes, Value: 80, Timestamp: 1}},
+			out:        model.Vector{{Metric: bits, Value: 10, Timestamp: 1}, {Metric: bytes, Value: 80, Timestamp: 1}},
+			warnings:   api.Warnings{"upstream", `sample transform "scale" modified 1 series`},
+		},
+		{
+			transforms: []Transform{clampMin, clampMax},
+			in:         model.Vector{{Metric: bits, Value: -5, Timestamp: 1}, {Metric: bytes, Value: 500, Timestamp: 1}},
+			out:        model.Vector{{Metric: bits, Value: 0, Timestamp: 1}, {Metric: bytes, Value: 100, Timestamp: 1}},
+			warnings:   api.Warnings{"upstream", `sample transform "clamp_min" modified 1 series`, `sample transform "clamp_max" modified 1 series`},
+		},
+		// Nothing to modify, so no warning
+		{
+			transforms: []Transform{scale, clampMin},
+			in:         model.Vector{{Metric: bytes, Value: 5, Timestamp: 1}},
+			out:        model.Vector{{Metric: bytes, Value: 5, Timestamp: 1}},
+			warnings:   api.Warnings{"upstream"},
+		},
+		// Matrix
+		{
+			transforms: []Transform{scale, clampMin},
+			in: model.Matrix{
+				{Metric: bits, Values: []model.SamplePair{{Timestamp: 1, Value: 8}, {Timestamp: 2, Value: -16}}},
+				{Metric: bytes, Values: []model.SamplePair{{Timestamp: 1, Value: 8}, {Timestamp: 2, Value: 16}}},
+			},
+			out: model.Matrix{
+				{Metric: bits, Values: []model.SamplePair{{Timestamp: 1, Value: 1}, {Timestamp: 2, Value: 0}}},
+				{Metric: bytes, Values: []model.SamplePair{{Timestamp: 1, Value: 8}, {Timestamp: 2, Value: 16}}},
+			},
+			warnings: api.Warnings{"upstream", `sample transform "scale" modified 1 series`, `sample transform "clamp_min" modified 1 series`},
+		},
+		{
+			transforms: []Transform{drop},
+			in: model.Matrix{
+				{Metric: model.Metric{"job": "broken"}, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+				{Metric: bytes, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+			},
+			out: model.Matrix{
+				{Metric: bytes, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+			},
+			warnings: api.Warnings{"upstream", `sample transform "drop" modified 1 series`},
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			a := &TransformAPI{API: &valueAPI{v: test.in}, Transforms: test.transforms}
+
+			var v model.Value
+			var w api.Warnings
+			var err error
+			if _, ok := test.in.(model.Vector); ok {
+				v, w, err = a.Query(context.TODO(), "x", time.Unix(1, 0))
+			} else {
+				v, w, err = a.QueryRange(context.TODO(), "x", v1.Range{Start: time.Unix(1, 0), End: time.Unix(2, 0), Step: time.Second})
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(v, test.out) {
+				t.Fatalf("mismatch in value expected=%v actual=%v", test.out, v)
+			}
+			if !reflect.DeepEqual(w, test.warnings) {
+				t.Fatalf("mismatch in warnings expected=%v actual=%v", test.warnings, w)
+			}
+		})
+	}
+}
+
+// TestTransformAPIUnitAlignment merges a counter from two backends, one of which
+// reports it in kilobytes rather than bytes and is scaled to align with the other
+func TestTransformAPIUnitAlignment(t *testing.T) {
+	// matrix returns the counter, whose values are divided by unit, and a metric
+	// which isn't scaled
+	matrix := func(unit model.SampleValue) model.Matrix {
+		return model.Matrix{
+			{
+				Metric: model.Metric{model.MetricNameLabel: "rx_bytes_total", "job": "a"},
+				Values: []model.SamplePair{{Timestamp: 1000, Value: 1024 / unit}, {Timestamp: 2000, Value: 3072 / unit}, {Timestamp: 3000, Value: 5120 / unit}},
+			},
+			{
+				Metric: model.Metric{model.MetricNameLabel: "tx_packets_total", "job": "a"},
+				Values: []model.SamplePair{{Timestamp: 1000, Value: 4}, {Timestamp: 2000, Value: 5}, {Timestamp: 3000, Value: 6}},
+			},
+		}
+	}
+	bytesBackend := &valueAPI{v: matrix(1)}
+	kilobytesBackend := &TransformAPI{
+		API:        &valueAPI{v: matrix(1024)},
+		Transforms: []Transform{mustTransform(t, TransformConfig{Type: TransformScale, Match: `{__name__=~".*_bytes_total"}`, Factor: 1024})},
+	}
+
+	multi := NewMultiAPI([]API{bytesBackend, kilobytesBackend}, 0, nil, 1)
+	merged, _, err := multi.QueryRange(context.TODO(), "x", v1.Range{Start: time.Unix(1, 0), End: time.Unix(3, 0), Step: time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mergedMatrix, ok := merged.(model.Matrix)
+	if !ok || len(mergedMatrix) != 2 {
+		t.Fatalf("mismatch in merged value expected=2 series actual=%v", merged)
+	}
+	expected := make(map[model.LabelValue][]model.SamplePair)
+	for _, stream := range matrix(1) {
+		expected[stream.Metric[model.MetricNameLabel]] = stream.Values
+	}
+	for _, stream := range mergedMatrix {
+		if e := expected[stream.Metric[model.MetricNameLabel]]; !reflect.DeepEqual(stream.Values, e) {
+			t.Fatalf("mismatch in merged values of %v expected=%v actual=%v", stream.Metric, e, stream.Values)
+		}
+		for i := 1; i < len(stream.Values); i++ {
+			if stream.Values[i].Value < stream.Values[i-1].Value {
+				t.Fatalf("counter %v isn't monotonic: %v", stream.Metric, stream.Values)
+			}
+		}
+	}
+}
+
+// TestTransformAPIRawValues checks that only the raw values are transformed, and
+// that the series of the downstream aren't modified
+func TestTransformAPIRawValues(t *testing.T) {
+	in := model.Matrix{
+		{Metric: model.Metric{model.MetricNameLabel: "rx_bits"}, Values: []model.SamplePair{{Timestamp: 1, Value: -8}}},
+		{Metric: model.Metric{model.MetricNameLabel: "rx_bytes"}, Values: []model.SamplePair{{Timestamp: 1, Value: -8}}},
+	}
+	a := &TransformAPI{
+		API:        &valueAPI{v: in},
+		Transforms: []Transform{mustTransform(t, TransformConfig{Type: TransformClampMin, Match: `rx_bits`, Value: 0})},
+	}
+	r := v1.Range{Start: time.Unix(1, 0), End: time.Unix(2, 0), Step: time.Second}
+
+	// The result of a pushed down aggregation is computed from the raw values
+	v, _, err := a.QueryRange(context.TODO(), "sum(rx_bits)", r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(v, in) {
+		t.Fatalf("mismatch in aggregated value expected=%v actual=%v", in, v)
+	}
+
+	v, _, err = a.QueryRange(context.TODO(), "rx_bits", r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value := v.(model.Matrix)[0].Values[0].Value; value != 0 {
+		t.Fatalf("mismatch in transformed value expected=0 actual=%v", value)
+	}
+	if value := in[0].Values[0].Value; value != -8 {
+		t.Fatalf("downstream value was modified expected=-8 actual=%v", value)
+	}
+	// The series which aren't transformed aren't copied
+	if &v.(model.Matrix)[1].Values[0] != &in[1].Values[0] {
+		t.Fatalf("untransformed series was copied")
+	}
+}
+
+func TestTransformConfigValidate(t *testing.T) {
+	tests := []struct {
+		cfg   TransformConfig
+		valid bool
+	}{
+		{cfg: TransformConfig{Type: TransformScale, Factor: 8}, valid: true},
+		{cfg: TransformConfig{Type: TransformClampMax, Match: `{job="a"}`, Value: 1}, valid: true},
+		{cfg: TransformConfig{Type: "log2"}, valid: false},
+		{cfg: TransformConfig{Type: TransformScale}, valid: false},
+		{cfg: TransformConfig{Type: TransformScale, Factor: -1}, valid: false},
+		{cfg: TransformConfig{Type: TransformScale, Factor: math.Inf(1)}, valid: false},
+		{cfg: TransformConfig{Type: TransformScale, Factor: math.NaN()}, valid: false},
+		{cfg: TransformConfig{Type: TransformClampMin, Match: `{job=}`}, valid: false},
+	}
+
+	for i, test := range tests {
+		if err := test.cfg.Validate(); (err == nil) != test.valid {
+			t.Fatalf("mismatch in valid for %d expected=%v actual=%v", i, test.valid, err)
+		}
+	}
+}
+
+func TestRegisterTransform(t *testing.T) {
+	RegisterTransform("transform-test:9090", "noop", func(m model.Metric, s []model.SamplePair) (model.Metric, []model.SamplePair) {
+		return m, s
+	})
+	if transforms := RegisteredTransforms("transform-test:9090"); len(transforms) != 1 || transforms[0].Name != "noop" {
+		t.Fatalf("mismatch in registered transforms: %v", transforms)
+	}
+	if transforms := RegisteredTransforms("other:9090"); len(transforms) != 0 {
+		t.Fatalf("unexpected transforms for other downstream: %v", transforms)
+	}
+}
@@ -0,0 +1,218 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/client_golang/api"
+)
+
+// TSDBStatTopN is the length of the top lists of a TSDBStatus, as in prometheus
+const TSDBStatTopN = 10
+
+// TSDBHeadStats are the stats of the head block of a TSDBStatus
+type TSDBHeadStats struct {
+	NumSeries  uint64 `json:"numSeries"`
+	ChunkCount int64  `json:"chunkCount"`
+	MinTime    int64  `json:"minTime"`
+	MaxTime    int64  `json:"maxTime"`
+}
+
+// TSDBStat is an entry of the top lists of a TSDBStatus
+type TSDBStat struct {
+	Name  string `json:"name"`
+	Value uint64 `json:"value"`
+}
+
+// TSDBStatus is the data of /api/v1/status/tsdb. The status of promproxy is
+// synthesized from the downstreams, whose statuses are in Downstreams.
+type TSDBStatus struct {
+	// HeadStats is nil for downstreams which don't report it (before prometheus 2.23)
+	HeadStats                   *TSDBHeadStats `json:"headStats,omitempty"`
+	SeriesCountByMetricName     []TSDBStat     `json:"seriesCountByMetricName"`
+	LabelValueCountByLabelName  []TSDBStat     `json:"labelValueCountByLabelName"`
+	MemoryInBytesByLabelName    []TSDBStat     `json:"memoryInBytesByLabelName"`
+	SeriesCountByLabelValuePair []TSDBStat     `json:"seriesCountByLabelValuePair"`
+	// Downstreams are the statuses of the downstreams by their name
+	Downstreams map[string]*TSDBStatus `json:"promproxyDownstreams,omitempty"`
+}
+
+// TSDBStatuser is implemented by APIs which can return the TSDBStatus of their
+// downstreams
+type TSDBStatuser interface {
+	TSDBStatus(ctx context.Context) (*TSDBStatus, api.Warnings, error)
+}
+
+// ErrTSDBStatusUnsupported is returned for downstreams which lack the TSDB status
+// endpoint
+type ErrTSDBStatusUnsupported struct {
+	Downstream string
+}
+
+func (e *ErrTSDBStatusUnsupported) Error() string {
+	return fmt.Sprintf("downstream %s doesn't support the tsdb status", e.Downstream)
+}
+
+// TSDBStatusClient fetches the TSDBStatus of the host at URL
+type TSDBStatusClient struct {
+	Client *http.Client
+	URL    *url.URL
+}
+
+// BackendName returns the host of the client
+func (c *TSDBStatusClient) BackendName() string {
+	return c.URL.Host
+}
+
+// TSDBStatus returns the status of the host, its Downstreams is the host itself
+func (c *TSDBStatusClient) TSDBStatus(ctx context.Context) (*TSDBStatus, api.Warnings, error) {
+	u := *c.URL
+	u.Path = path.Join(u.Path, "api/v1/status/tsdb")
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, &ErrTSDBStatusUnsupported{Downstream: c.URL.Host}
+	}
+
+	var r struct {
+		Status   string       `json:"status"`
+		Data     *TSDBStatus  `json:"data"`
+		Error    string       `json:"error"`
+		Warnings api.Warnings `json:"warnings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, nil, fmt.Errorf("error decoding tsdb status of %s (status %d): %v", c.URL.Host, resp.StatusCode, err)
+	}
+	if r.Status != "success" || r.Data == nil {
+		return nil, r.Warnings, fmt.Errorf("error fetching tsdb status of %s: %s", c.URL.Host, r.Error)
+	}
+	status := *r.Data
+	status.Downstreams = map[string]*TSDBStatus{c.URL.Host: r.Data}
+	return &status, r.Warnings, nil
+}
+
+// MultiTSDBStatus fetches the TSDBStatus of all its TSDBStatusers concurrently,
+// merging them with MergeTSDBStatus. TSDBStatusers which fail (e.g. as they lack
+// the endpoint) are skipped with a warning, the call only fails if all of them do.
+type MultiTSDBStatus []TSDBStatuser
+
+// TSDBStatus returns the merged status of the TSDBStatusers
+func (m MultiTSDBStatus) TSDBStatus(ctx context.Context) (*TSDBStatus, api.Warnings, error) {
+	type result struct {
+		status   *TSDBStatus
+		warnings api.Warnings
+		err      error
+	}
+	results := make([]chan result, len(m))
+	for i, s := range m {
+		results[i] = make(chan result, 1)
+		go func(s TSDBStatuser, ch chan<- result) {
+			status, w, err := s.TSDBStatus(ctx)
+			ch <- result{status, w, err}
+		}(s, results[i])
+	}
+
+	var (
+		statuses []*TSDBStatus
+		warnings api.Warnings
+		lastErr  error
+	)
+	for i, ch := range results {
+		r := <-ch
+		warnings = append(warnings, r.warnings...)
+		if r.err != nil {
+			lastErr = r.err
+			name := strconv.Itoa(i)
+			if namer, ok := m[i].(BackendNamer); ok {
+				name = namer.BackendName()
+			}
+			warnings = append(warnings, NewBackendWarning(name, r.err).String())
+			continue
+		}
+		statuses = append(statuses, r.status)
+	}
+	if len(statuses) == 0 && lastErr != nil {
+		return nil, warnings, lastErr
+	}
+	return MergeTSDBStatus(statuses...), warnings, nil
+}
+
+// MergeTSDBStatus merges the statuses conservatively: the series and chunks of
+// the head stats are summed and their time range spans all of them. The counts of
+// the top lists are summed by name, so a series on several downstreams is counted
+// once for each. The Downstreams of the statuses are combined.
+func MergeTSDBStatus(statuses ...*TSDBStatus) *TSDBStatus {
+	merged := &TSDBStatus{Downstreams: make(map[string]*TSDBStatus)}
+	var (
+		seriesCountByMetricName     = make(map[string]uint64)
+		labelValueCountByLabelName  = make(map[string]uint64)
+		memoryInBytesByLabelName    = make(map[string]uint64)
+		seriesCountByLabelValuePair = make(map[string]uint64)
+	)
+	for _, status := range statuses {
+		if h := status.HeadStats; h != nil {
+			if merged.HeadStats == nil {
+				headStats := *h
+				merged.HeadStats = &headStats
+			} else {
+				merged.HeadStats.NumSeries += h.NumSeries
+				merged.HeadStats.ChunkCount += h.ChunkCount
+				if h.MinTime < merged.HeadStats.MinTime {
+					merged.HeadStats.MinTime = h.MinTime
+				}
+				if h.MaxTime > merged.HeadStats.MaxTime {
+					merged.HeadStats.MaxTime = h.MaxTime
+				}
+			}
+		}
+		sumTSDBStats(seriesCountByMetricName, status.SeriesCountByMetricName)
+		sumTSDBStats(labelValueCountByLabelName, status.LabelValueCountByLabelName)
+		sumTSDBStats(memoryInBytesByLabelName, status.MemoryInBytesByLabelName)
+		sumTSDBStats(seriesCountByLabelValuePair, status.SeriesCountByLabelValuePair)
+		for name, downstream := range status.Downstreams {
+			merged.Downstreams[name] = downstream
+		}
+	}
+	merged.SeriesCountByMetricName = topTSDBStats(seriesCountByMetricName)
+	merged.LabelValueCountByLabelName = topTSDBStats(labelValueCountByLabelName)
+	merged.MemoryInBytesByLabelName = topTSDBStats(memoryInBytesByLabelName)
+	merged.SeriesCountByLabelValuePair = topTSDBStats(seriesCountByLabelValuePair)
+	return merged
+}
+
+func sumTSDBStats(sums map[string]uint64, stats []TSDBStat) {
+	for _, stat := range stats {
+		sums[stat.Name] += stat.Value
+	}
+}
+
+// topTSDBStats returns the TSDBStatTopN largest of the stats, largest first
+func topTSDBStats(sums map[string]uint64) []TSDBStat {
+	stats := make([]TSDBStat, 0, len(sums))
+	for name, value := range sums {
+		stats = append(stats, TSDBStat{Name: name, Value: value})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Value != stats[j].Value {
+			return stats[i].Value > stats[j].Value
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	if len(stats) > TSDBStatTopN {
+		stats = stats[:TSDBStatTopN]
+	}
+	return stats
+}
@@ -0,0 +1,80 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// tsdbStatusServer serves the body as the tsdb status (a 404 if empty)
+func tsdbStatusServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if body == "" || r.URL.Path != "/api/v1/status/tsdb" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+}
+
+func TestMultiTSDBStatus(t *testing.T) {
+	servers := []*httptest.Server{
+		tsdbStatusServer(`{"status":"success","data":{"headStats":{"numSeries":100,"chunkCount":200,"minTime":1000,"maxTime":5000},"seriesCountByMetricName":[{"name":"up","value":10},{"name":"http_requests_total","value":90}]}}`),
+		tsdbStatusServer(`{"status":"success","data":{"headStats":{"numSeries":50,"chunkCount":60,"minTime":500,"maxTime":4000},"seriesCountByMetricName":[{"name":"up","value":5},{"name":"node_cpu_seconds_total","value":45}]}}`),
+		// Lacks the endpoint
+		tsdbStatusServer(""),
+	}
+	statusers := make(MultiTSDBStatus, len(servers))
+	for i, srv := range servers {
+		defer srv.Close()
+		u, _ := url.Parse(srv.URL)
+		statusers[i] = &TSDBStatusClient{Client: http.DefaultClient, URL: u}
+	}
+
+	status, w, err := statusers.TSDBStatus(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(w) != 1 {
+		t.Fatalf("mismatch in warnings expected=1 actual=%v", w)
+	}
+
+	expectedHead := &TSDBHeadStats{NumSeries: 150, ChunkCount: 260, MinTime: 500, MaxTime: 5000}
+	if !reflect.DeepEqual(status.HeadStats, expectedHead) {
+		t.Fatalf("mismatch in head stats expected=%v actual=%v", expectedHead, status.HeadStats)
+	}
+	expectedTop := []TSDBStat{{"http_requests_total", 90}, {"node_cpu_seconds_total", 45}, {"up", 15}}
+	if !reflect.DeepEqual(status.SeriesCountByMetricName, expectedTop) {
+		t.Fatalf("mismatch in series count by metric name expected=%v actual=%v", expectedTop, status.SeriesCountByMetricName)
+	}
+	if len(status.Downstreams) != 2 {
+		t.Fatalf("mismatch in downstreams expected=2 actual=%v", status.Downstreams)
+	}
+	first, _ := url.Parse(servers[0].URL)
+	if d := status.Downstreams[first.Host]; d == nil || d.HeadStats.NumSeries != 100 {
+		t.Fatalf("mismatch in status of %s expected=%d series actual=%v", first.Host, 100, d)
+	}
+
+	// Without any downstream supporting it the call fails
+	if _, _, err := statusers[2:].TSDBStatus(context.TODO()); err == nil {
+		t.Fatalf("missing error")
+	}
+}
+
+func TestTopTSDBStats(t *testing.T) {
+	sums := make(map[string]uint64)
+	for i := 0; i < 2*TSDBStatTopN; i++ {
+		sums[fmt.Sprintf("m%02d", i)] = uint64(i % 5)
+	}
+	top := topTSDBStats(sums)
+	if len(top) != TSDBStatTopN {
+		t.Fatalf("mismatch in length expected=%d actual=%d", TSDBStatTopN, len(top))
+	}
+	if top[0] != (TSDBStat{"m04", 4}) || top[len(top)-1] != (TSDBStat{"m07", 2}) {
+		t.Fatalf("mismatch in top stats actual=%v", top)
+	}
+}
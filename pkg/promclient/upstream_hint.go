@@ -0,0 +1,60 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+)
+
+// UpstreamHint tells APIs that choose between equivalent upstreams which one the
+// caller would prefer. Different callers (e.g. recording rules vs dashboards) care
+// about different properties of the upstream they are routed to.
+type UpstreamHint int
+
+const (
+	// NoUpstreamHint leaves the upstream selection to the API
+	NoUpstreamHint UpstreamHint = iota
+	// PreferFast prefers the upstream with the lowest observed latency
+	PreferFast
+	// PreferFresh prefers the upstream that most recently responded successfully
+	PreferFresh
+	// PreferConsistent prefers the same upstream for every request, so repeated
+	// queries see the same data
+	PreferConsistent
+)
+
+func (h UpstreamHint) String() string {
+	switch h {
+	case PreferFast:
+		return "fast"
+	case PreferFresh:
+		return "fresh"
+	case PreferConsistent:
+		return "consistent"
+	default:
+		return "none"
+	}
+}
+
+// ParseUpstreamHint returns the UpstreamHint of the name (as in its String)
+func ParseUpstreamHint(name string) (UpstreamHint, error) {
+	for _, hint := range []UpstreamHint{NoUpstreamHint, PreferFast, PreferFresh, PreferConsistent} {
+		if hint.String() == name {
+			return hint, nil
+		}
+	}
+	return NoUpstreamHint, fmt.Errorf("unknown upstream hint %q", name)
+}
+
+type upstreamHintKey struct{}
+
+// WithUpstreamHint returns a context carrying the given UpstreamHint
+func WithUpstreamHint(ctx context.Context, hint UpstreamHint) context.Context {
+	return context.WithValue(ctx, upstreamHintKey{}, hint)
+}
+
+// UpstreamHintFromContext returns the UpstreamHint of the context (NoUpstreamHint
+// if there is none)
+func UpstreamHintFromContext(ctx context.Context) UpstreamHint {
+	hint, _ := ctx.Value(upstreamHintKey{}).(UpstreamHint)
+	return hint
+}
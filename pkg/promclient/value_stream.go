@@ -0,0 +1,293 @@
+package promclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// valueStreamBuffer is the number of series buffered between a streamed GetValue
+// call and its consumer. Once the buffer is full the stream blocks until the
+// consumer catches up, so at most this many decoded series are held at once
+const valueStreamBuffer = 16
+
+// SampleStreamFunc is called for each series of a streamed GetValue result,
+// returning an error stops the stream
+type SampleStreamFunc func(*model.SampleStream) error
+
+// StreamedSeries is an element of a streamed GetValue result. The last element of
+// a stream carries its warnings and its error (if any) instead of a series
+type StreamedSeries struct {
+	Series   *model.SampleStream
+	Warnings api.Warnings
+	Err      error
+}
+
+// StreamingAPI is implemented by APIs which can stream the result of a GetValue
+// call one series at a time instead of returning it all at once. This bounds the
+// memory used for selects matching many series.
+type StreamingAPI interface {
+	// StreamGetValue loads the raw data for a given set of matchers in the time
+	// range, sending the series on the returned channel as they are read. The
+	// channel is closed once the stream completes, or once ctx is done (without an
+	// error element, as the consumer is gone)
+	StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error)
+}
+
+// StreamGetValue streams the GetValue result of the client, if the client isn't
+// a StreamingAPI the full result is loaded and then streamed. The wrappers of this
+// package pass the stream through where they can process it series by series,
+// the others (e.g. those merging or resampling the series) load the full result.
+func StreamGetValue(ctx context.Context, client API, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	if streamer, ok := client.(StreamingAPI); ok {
+		return streamer.StreamGetValue(ctx, start, end, matchers)
+	}
+
+	v, w, err := client.GetValue(ctx, start, end, matchers)
+	if err != nil {
+		return nil, err
+	}
+	return streamValue(ctx, v, w)
+}
+
+// streamValue returns the stream of a GetValue result
+func streamValue(ctx context.Context, v model.Value, w api.Warnings) (<-chan StreamedSeries, error) {
+	var streams []*model.SampleStream
+	switch typed := v.(type) {
+	case model.Matrix:
+		streams = typed
+	case model.Vector:
+		streams = make([]*model.SampleStream, len(typed))
+		for i, sample := range typed {
+			streams[i] = &model.SampleStream{
+				Metric: sample.Metric,
+				Values: []model.SamplePair{{Timestamp: sample.Timestamp, Value: sample.Value}},
+			}
+		}
+	case nil:
+	default:
+		return nil, fmt.Errorf("unexpected value type %T for GetValue", v)
+	}
+
+	return newValueStream(ctx, func(fn SampleStreamFunc) (api.Warnings, error) {
+		for _, stream := range streams {
+			if err := fn(stream); err != nil {
+				return w, err
+			}
+		}
+		return w, nil
+	}), nil
+}
+
+// emptyValueStream returns a stream without series
+func emptyValueStream() <-chan StreamedSeries {
+	ch := make(chan StreamedSeries)
+	close(ch)
+	return ch
+}
+
+// filterValueStream returns the stream of ch with series (if set) applied to each
+// of its series, and end (if set) to the warnings and error the stream ends with.
+// Series for which series returns nil are dropped, and an error from it ends the
+// stream. end is called once the stream ends, including when ctx is done, so it
+// can release what is held for the stream.
+func filterValueStream(ctx context.Context, ch <-chan StreamedSeries, series func(*model.SampleStream) (*model.SampleStream, error), end func(api.Warnings, error) (api.Warnings, error)) <-chan StreamedSeries {
+	out := make(chan StreamedSeries)
+	go func() {
+		defer close(out)
+		var (
+			w   api.Warnings
+			err error
+		)
+	STREAM:
+		for streamed := range ch {
+			if streamed.Series == nil {
+				w = append(w, streamed.Warnings...)
+				if streamed.Err != nil {
+					err = streamed.Err
+				}
+				continue
+			}
+			s := streamed.Series
+			if series != nil {
+				if s, err = series(s); err != nil {
+					// The rest of the stream is dropped, without blocking its sender
+					go func() {
+						for range ch {
+						}
+					}()
+					break STREAM
+				}
+				if s == nil {
+					continue
+				}
+			}
+			select {
+			case out <- StreamedSeries{Series: s}:
+			case <-ctx.Done():
+				err = ctx.Err()
+				break STREAM
+			}
+		}
+		if end != nil {
+			w, err = end(w, err)
+		}
+		if ctx.Err() != nil || (len(w) == 0 && err == nil) {
+			return
+		}
+		select {
+		case out <- StreamedSeries{Warnings: w, Err: err}:
+		case <-ctx.Done():
+		}
+	}()
+	return out
+}
+
+// newValueStream returns the channel of the series of stream, which runs in its
+// own goroutine until it completes or ctx is done
+func newValueStream(ctx context.Context, stream func(SampleStreamFunc) (api.Warnings, error)) <-chan StreamedSeries {
+	ch := make(chan StreamedSeries, valueStreamBuffer)
+	go func() {
+		defer close(ch)
+		w, err := stream(func(s *model.SampleStream) error {
+			select {
+			case ch <- StreamedSeries{Series: s}:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+		if ctx.Err() != nil || (len(w) == 0 && err == nil) {
+			return
+		}
+		select {
+		case ch <- StreamedSeries{Warnings: w, Err: err}:
+		case <-ctx.Done():
+		}
+	}()
+	return ch
+}
+
+// DecodeMatrixStream decodes a query response with a matrix result from the
+// prometheus HTTP API, calling fn for each series as it is decoded (instead of
+// decoding the whole response into memory)
+func DecodeMatrixStream(r io.Reader, fn SampleStreamFunc) (api.Warnings, error) {
+	return decodeResponseStream(r, func(dec *json.Decoder) error {
+		return decodeMatrixData(dec, fn)
+	})
+}
+
+// decodeMatrixData decodes the data section of a query response with a matrix
+// result. Prometheus writes the resultType before the result, which is required
+// here as the result can't be decoded without it
+func decodeMatrixData(dec *json.Decoder, fn SampleStreamFunc) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	// data is null on errors
+	if t == nil {
+		return nil
+	}
+	if delim, ok := t.(json.Delim); !ok || delim != '{' {
+		return fmt.Errorf("unexpected token in query data: %v", t)
+	}
+
+	var resultType string
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch key {
+		case "resultType":
+			err = dec.Decode(&resultType)
+		case "result":
+			if resultType != model.ValMatrix.String() {
+				return fmt.Errorf("unexpected result type %q expected %q", resultType, model.ValMatrix.String())
+			}
+			err = decodeMatrixResult(dec, fn)
+		default:
+			var skip json.RawMessage
+			err = dec.Decode(&skip)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return expectDelim(dec, '}')
+}
+
+// decodeMatrixResult decodes the series of a matrix result
+func decodeMatrixResult(dec *json.Decoder, fn SampleStreamFunc) error {
+	if err := expectDelim(dec, '['); err != nil {
+		return err
+	}
+	for dec.More() {
+		stream := &model.SampleStream{}
+		if err := dec.Decode(stream); err != nil {
+			return err
+		}
+		if err := fn(stream); err != nil {
+			return err
+		}
+	}
+	return expectDelim(dec, ']')
+}
+
+// StreamGetValue loads the raw data for a given set of matchers in the time range
+// through the query endpoint (as PromAPIV1 does), sending the series on the
+// channel as the response is decoded
+func (c *SeriesStreamClient) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	query, err := rawDataQuery(start, end, matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	u := *c.URL
+	u.Path = path.Join(u.Path, "api/v1/query")
+	q := u.Query()
+	q.Set("query", query)
+	q.Set("time", promutil.FormatTimestamp(end))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	return newValueStream(ctx, func(fn SampleStreamFunc) (api.Warnings, error) {
+		defer resp.Body.Close()
+
+		// Errors from fn are returned as-is, anything else is a problem with the response
+		var fnErr error
+		w, err := DecodeMatrixStream(resp.Body, func(s *model.SampleStream) error {
+			fnErr = fn(s)
+			return fnErr
+		})
+		if err != nil && err != fnErr {
+			if _, ok := err.(*v1.Error); !ok {
+				err = &v1.Error{Type: v1.ErrBadResponse, Msg: errors.Wrapf(err, "error decoding query response (status %d)", resp.StatusCode).Error()}
+			}
+		}
+		return w, err
+	}), nil
+}
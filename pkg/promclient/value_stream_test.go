@@ -0,0 +1,159 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestDecodeMatrixStream(t *testing.T) {
+	tests := []struct {
+		body     string
+		count    int
+		warnings int
+		err      bool
+	}{
+		{
+			body:  `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"a"},"values":[[1,"1"],[2,"1"]]},{"metric":{"__name__":"up","job":"b"},"values":[[1,"0"]]}]}}`,
+			count: 2,
+		},
+		{
+			body:     `{"status":"success","data":{"resultType":"matrix","result":[]},"warnings":["partial response"]}`,
+			warnings: 1,
+		},
+		// Unknown keys are skipped
+		{
+			body:  `{"data":{"resultType":"matrix","other":[1],"result":[{"metric":{},"values":[]}]},"status":"success"}`,
+			count: 1,
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"vector","result":[{"metric":{},"value":[1,"1"]}]}}`,
+			err:  true,
+		},
+		{
+			body: `{"status":"error","errorType":"bad_data","error":"parse error","data":null}`,
+			err:  true,
+		},
+		{
+			body: `{"status":"success","data":{"resultType":"matrix","result":[{"metric":{}`,
+			err:  true,
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			count := 0
+			w, err := DecodeMatrixStream(strings.NewReader(test.body), func(s *model.SampleStream) error {
+				count++
+				return nil
+			})
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if !test.err && count != test.count {
+				t.Fatalf("mismatch in count expected=%d actual=%d", test.count, count)
+			}
+			if len(w) != test.warnings {
+				t.Fatalf("mismatch in warnings expected=%d actual=%v", test.warnings, w)
+			}
+		})
+	}
+}
+
+func TestSeriesStreamClientStreamGetValue(t *testing.T) {
+	// The server sends the first series, and only finishes the response once the
+	// client got it
+	firstSeries := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" || r.FormValue("query") != `{job="a"}[101s]` {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[{"metric":{"__name__":"up","job":"a"},"values":[[1,"1"]]}`))
+		w.(http.Flusher).Flush()
+		select {
+		case <-firstSeries:
+		case <-time.After(5 * time.Second):
+			// The response is cut short, failing the stream
+			return
+		}
+		w.Write([]byte(`,{"metric":{"__name__":"up","job":"a","instance":"b"},"values":[[1,"1"]]}]},"warnings":["partial response"]}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	client := &SeriesStreamClient{Client: srv.Client(), URL: u}
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")}
+	ch, err := client.StreamGetValue(context.TODO(), time.Unix(0, 0), time.Unix(100, 0), matchers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var streamed []StreamedSeries
+	for s := range ch {
+		if len(streamed) == 0 {
+			close(firstSeries)
+		}
+		streamed = append(streamed, s)
+	}
+	if len(streamed) != 3 {
+		t.Fatalf("mismatch in streamed expected=%d actual=%d", 3, len(streamed))
+	}
+	last := streamed[2]
+	if last.Series != nil || last.Err != nil || len(last.Warnings) != 1 {
+		t.Fatalf("expected the warnings last, got: %+v", last)
+	}
+}
+
+// valueStreamer streams the series sent on ch
+type valueStreamer struct {
+	API
+	ch chan StreamedSeries
+}
+
+func (v *valueStreamer) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan StreamedSeries, error) {
+	return v.ch, nil
+}
+
+func TestStreamGetValueWrapped(t *testing.T) {
+	streamer := &valueStreamer{ch: make(chan StreamedSeries)}
+	var client API = &RetryAPI{API: &TransformAPI{API: streamer}, MaxRetries: 1}
+	client = NewLoadBalancedAPI([]API{client})
+	client = &TracingAPI{API: &AddLabelClient{API: client, Labels: model.LabelSet{"az": "a"}}, Name: "a"}
+
+	matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "az", "a")}
+	ch, err := StreamGetValue(context.TODO(), client, time.Unix(0, 0), time.Unix(100, 0), matchers)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The series go through the wrappers as they are streamed, before the stream ends
+	go func() {
+		streamer.ch <- StreamedSeries{Series: &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "up"}}}
+	}()
+	select {
+	case s := <-ch:
+		if s.Series == nil || s.Series.Metric["az"] != "a" {
+			t.Fatalf("mismatch in series expected=az=a actual=%+v", s)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("series not streamed through the wrappers")
+	}
+
+	streamer.ch <- StreamedSeries{Warnings: []string{"partial response"}}
+	close(streamer.ch)
+	last, ok := <-ch
+	if !ok || len(last.Warnings) != 1 {
+		t.Fatalf("mismatch in warnings expected=[partial response] actual=%+v", last)
+	}
+	if _, ok := <-ch; ok {
+		t.Fatalf("expected the stream to end")
+	}
+}
@@ -0,0 +1,301 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/sirupsen/logrus"
+)
+
+// The results of a query verification
+const (
+	VerificationMatch    = "match"
+	VerificationMismatch = "mismatch"
+	VerificationError    = "error"
+	// VerificationSkipped is the result of the sampled queries which weren't
+	// verified as MaxConcurrency verifications were running already
+	VerificationSkipped = "skipped"
+)
+
+// QueryVerificationConfig configures the self-verification of range queries: a
+// sample of the range queries which are routed to a single servergroup are sent
+// to that servergroup as is as well, and the result it evaluated is compared to
+// the served one.
+type QueryVerificationConfig struct {
+	// SampleRate is the fraction (0 to 1) of the range queries which are verified
+	SampleRate float64 `yaml:"sample_rate"`
+	// MaxConcurrency is the max number of verifications running at once, sampled
+	// queries beyond that aren't verified
+	MaxConcurrency int `yaml:"max_concurrency"`
+	// Tolerance is the max relative difference of two values which still match
+	Tolerance float64 `yaml:"tolerance"`
+	// Timeout is the timeout of the downstream query of a verification
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// DefaultQueryVerificationConfig is the QueryVerificationConfig used for unset fields
+var DefaultQueryVerificationConfig = QueryVerificationConfig{
+	MaxConcurrency: 4,
+	Tolerance:      1e-9,
+	Timeout:        30 * time.Second,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *QueryVerificationConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultQueryVerificationConfig
+	type plain QueryVerificationConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c QueryVerificationConfig) Validate() error {
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		return fmt.Errorf("query verification sample_rate must be between 0 and 1")
+	}
+	if c.MaxConcurrency <= 0 {
+		return fmt.Errorf("query verification max_concurrency must be positive")
+	}
+	if c.Tolerance < 0 {
+		return fmt.Errorf("query verification tolerance must not be negative")
+	}
+	if c.Timeout <= 0 {
+		return fmt.Errorf("query verification timeout must be positive")
+	}
+	return nil
+}
+
+// VerificationGroup is a servergroup queries can be verified against
+type VerificationGroup struct {
+	Name string
+	// Labels are the labels the queries are routed to the group by (its labels and
+	// external labels)
+	Labels model.LabelSet
+	API    API
+}
+
+// DefaultQueryVerifier is the QueryVerifier of the ProxyStorage
+var DefaultQueryVerifier = NewQueryVerifier()
+
+// NewQueryVerifier returns a QueryVerifier which doesn't verify anything until it
+// is configured
+func NewQueryVerifier() *QueryVerifier {
+	return &QueryVerifier{
+		results: make(map[string]uint64),
+		rand:    rand.Float64,
+	}
+}
+
+// QueryVerifier verifies a sample of the served range queries against the
+// servergroup they were routed to. The verifications run in the background after
+// the result was served and never change it. Mismatches are logged with the query
+// and the first difference found, and all results are counted.
+type QueryVerifier struct {
+	// inflight is accessed atomically
+	inflight int64
+
+	mu      sync.Mutex
+	cfg     *QueryVerificationConfig
+	groups  []VerificationGroup
+	results map[string]uint64
+	rand    func() float64
+	// wg tracks the running verifications (for tests)
+	wg sync.WaitGroup
+}
+
+// ApplyConfig replaces the config and groups of the QueryVerifier, a nil config
+// disables the verification
+func (v *QueryVerifier) ApplyConfig(cfg *QueryVerificationConfig, groups []VerificationGroup) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.cfg = cfg
+	v.groups = groups
+}
+
+// Verify starts the verification of the served result of the range query if it is
+// sampled, returning immediately. The served result must not be modified after.
+func (v *QueryVerifier) Verify(ctx context.Context, query string, r v1.Range, served model.Value) {
+	v.mu.Lock()
+	cfg, groups := v.cfg, v.groups
+	sampled := cfg != nil && cfg.SampleRate > 0 && v.rand() < cfg.SampleRate
+	v.mu.Unlock()
+	if !sampled {
+		return
+	}
+
+	group, ok := routedGroup(ctx, query, groups)
+	if !ok {
+		return
+	}
+	if atomic.AddInt64(&v.inflight, 1) > int64(cfg.MaxConcurrency) {
+		atomic.AddInt64(&v.inflight, -1)
+		v.record(VerificationSkipped)
+		return
+	}
+
+	// The verification outlives the request, so it only keeps what identifies it
+	verifyCtx := WithCorrelationID(WithTenant(context.Background(), TenantFromContext(ctx)), CorrelationIDFromContext(ctx))
+	v.wg.Add(1)
+	go func() {
+		defer v.wg.Done()
+		defer atomic.AddInt64(&v.inflight, -1)
+		verifyCtx, cancel := context.WithTimeout(verifyCtx, cfg.Timeout)
+		defer cancel()
+		v.verify(verifyCtx, cfg, group, query, r, served)
+	}()
+}
+
+func (v *QueryVerifier) verify(ctx context.Context, cfg *QueryVerificationConfig, group VerificationGroup, query string, r v1.Range, served model.Value) {
+	fields := logrus.Fields{
+		"group":      group.Name,
+		"query":      query,
+		"start":      r.Start,
+		"end":        r.End,
+		"step":       r.Step,
+		"request_id": CorrelationIDFromContext(ctx),
+	}
+	downstream, _, err := group.API.QueryRange(ctx, query, r)
+	if err != nil {
+		v.record(VerificationError)
+		logger.WithFields(fields).Debugf("Unable to verify the query: %v", err)
+		return
+	}
+	if diff := diffValues(served, downstream, cfg.Tolerance); diff != "" {
+		v.record(VerificationMismatch)
+		logger.WithFields(fields).Warnf("Query result differs from the downstream evaluation: %s", diff)
+		return
+	}
+	v.record(VerificationMatch)
+}
+
+func (v *QueryVerifier) record(result string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.results[result]++
+}
+
+// routedGroup returns the only group the query is routed to, false if it is routed
+// to none or several of them
+func routedGroup(ctx context.Context, query string, groups []VerificationGroup) (VerificationGroup, bool) {
+	var routed []VerificationGroup
+	for _, group := range groups {
+		// The visitor strips the matchers it checked, so each group parses anew
+		e, err := promql.ParseExpr(query)
+		if err != nil {
+			return VerificationGroup{}, false
+		}
+		filterVisitor := &LabelFilterVisitor{group.Labels, true}
+		if _, err := promql.Walk(ctx, filterVisitor, &promql.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+			return VerificationGroup{}, false
+		}
+		if filterVisitor.filterMatch {
+			routed = append(routed, group)
+		}
+	}
+	if len(routed) != 1 {
+		return VerificationGroup{}, false
+	}
+	return routed[0], true
+}
+
+// diffValues returns the first difference of the matrices, empty if they match
+// within the relative tolerance
+func diffValues(served, downstream model.Value, tolerance float64) string {
+	servedMatrix, ok := served.(model.Matrix)
+	if !ok && served != nil {
+		return fmt.Sprintf("unexpected served %s result", served.Type())
+	}
+	downstreamMatrix, ok := downstream.(model.Matrix)
+	if !ok && downstream != nil {
+		return fmt.Sprintf("unexpected downstream %s result", downstream.Type())
+	}
+
+	downstreamSeries := make(map[model.Fingerprint]*model.SampleStream, len(downstreamMatrix))
+	for _, stream := range downstreamMatrix {
+		downstreamSeries[stream.Metric.Fingerprint()] = stream
+	}
+	for _, stream := range servedMatrix {
+		other, ok := downstreamSeries[stream.Metric.Fingerprint()]
+		if !ok {
+			return fmt.Sprintf("series %s missing from the downstream result", stream.Metric)
+		}
+		delete(downstreamSeries, stream.Metric.Fingerprint())
+		if len(stream.Values) != len(other.Values) {
+			return fmt.Sprintf("series %s has %d samples, the downstream %d", stream.Metric, len(stream.Values), len(other.Values))
+		}
+		for i, sample := range stream.Values {
+			otherSample := other.Values[i]
+			if sample.Timestamp != otherSample.Timestamp {
+				return fmt.Sprintf("series %s has a sample at %v, the downstream at %v", stream.Metric, sample.Timestamp, otherSample.Timestamp)
+			}
+			if !withinTolerance(float64(sample.Value), float64(otherSample.Value), tolerance) {
+				return fmt.Sprintf("series %s is %v at %v, the downstream %v", stream.Metric, sample.Value, sample.Timestamp, otherSample.Value)
+			}
+		}
+	}
+	for _, stream := range downstreamSeries {
+		return fmt.Sprintf("series %s missing from the served result", stream.Metric)
+	}
+	return ""
+}
+
+// withinTolerance returns whether the values match within the relative tolerance,
+// NaNs match each other and infinities only match themselves
+func withinTolerance(a, b, tolerance float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	if a == b {
+		return true
+	}
+	if math.IsInf(a, 0) || math.IsInf(b, 0) {
+		return false
+	}
+	return math.Abs(a-b) <= tolerance*math.Max(math.Abs(a), math.Abs(b))
+}
+
+var queryVerificationsDesc = prometheus.NewDesc(
+	"promproxy_query_verifications_total",
+	"Number of sampled range queries verified against the evaluation of their servergroup by result",
+	[]string{"result"}, nil,
+)
+
+// Describe implements prometheus.Collector
+func (v *QueryVerifier) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queryVerificationsDesc
+}
+
+// Collect implements prometheus.Collector
+func (v *QueryVerifier) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for result, count := range v.results {
+		ch <- prometheus.MustNewConstMetric(queryVerificationsDesc, prometheus.CounterValue, float64(count), result)
+	}
+}
+
+type queryVerifierKey struct{}
+
+// WithQueryVerifier returns a context whose range queries are verified by the
+// QueryVerifier
+func WithQueryVerifier(ctx context.Context, v *QueryVerifier) context.Context {
+	return context.WithValue(ctx, queryVerifierKey{}, v)
+}
+
+// QueryVerifierFromContext returns the QueryVerifier of the context (nil if there
+// is none)
+func QueryVerifierFromContext(ctx context.Context) *QueryVerifier {
+	v, _ := ctx.Value(queryVerifierKey{}).(*QueryVerifier)
+	return v
+}
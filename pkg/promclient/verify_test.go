@@ -0,0 +1,129 @@
+package promclient
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+func verifyMatrix(values ...float64) model.Matrix {
+	stream := &model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "up", "job": "a"}}
+	for i, v := range values {
+		stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.Time(i * 1000), Value: model.SampleValue(v)})
+	}
+	return model.Matrix{stream}
+}
+
+func TestDiffValues(t *testing.T) {
+	tests := []struct {
+		served     model.Value
+		downstream model.Value
+		match      bool
+	}{
+		{served: verifyMatrix(1, 2), downstream: verifyMatrix(1, 2), match: true},
+		{served: verifyMatrix(1, 2), downstream: verifyMatrix(1, 2+1e-12), match: true},
+		{served: verifyMatrix(1, math.NaN()), downstream: verifyMatrix(1, math.NaN()), match: true},
+		{served: verifyMatrix(math.Inf(1)), downstream: verifyMatrix(math.Inf(1)), match: true},
+		{served: model.Matrix{}, downstream: nil, match: true},
+		{served: verifyMatrix(1, 2), downstream: verifyMatrix(1, 3)},
+		{served: verifyMatrix(1, 2), downstream: verifyMatrix(1)},
+		{served: verifyMatrix(1), downstream: verifyMatrix(math.NaN())},
+		{served: verifyMatrix(math.Inf(1)), downstream: verifyMatrix(math.Inf(-1))},
+		{served: verifyMatrix(1), downstream: model.Matrix{}},
+		{served: model.Matrix{}, downstream: verifyMatrix(1)},
+		{served: verifyMatrix(1), downstream: model.Vector{}},
+	}
+
+	for i, test := range tests {
+		diff := diffValues(test.served, test.downstream, DefaultQueryVerificationConfig.Tolerance)
+		if (diff == "") != test.match {
+			t.Fatalf("%d: mismatch in match expected=%v actual=%v (%s)", i, test.match, diff == "", diff)
+		}
+	}
+}
+
+func TestRoutedGroup(t *testing.T) {
+	groups := []VerificationGroup{
+		{Name: "eu", Labels: model.LabelSet{"region": "eu"}},
+		{Name: "us", Labels: model.LabelSet{"region": "us"}},
+	}
+
+	tests := []struct {
+		query  string
+		group  string
+		routed bool
+	}{
+		{query: `up{region="eu"}`, group: "eu", routed: true},
+		{query: `sum(rate(http_requests_total{region="us"}[5m]))`, group: "us", routed: true},
+		{query: `up`},
+		{query: `up{region=~"eu|us"}`},
+		{query: `up{region="ap"}`},
+		{query: `up{region="eu"} / on() up{region="us"}`},
+	}
+
+	for i, test := range tests {
+		group, ok := routedGroup(context.TODO(), test.query, groups)
+		if ok != test.routed {
+			t.Fatalf("%d: mismatch in routed expected=%v actual=%v", i, test.routed, ok)
+		}
+		if group.Name != test.group {
+			t.Fatalf("%d: mismatch in group expected=%v actual=%v", i, test.group, group.Name)
+		}
+	}
+}
+
+// blockingAPI blocks the range queries until release is closed
+type blockingAPI struct {
+	API
+	release chan struct{}
+}
+
+func (b *blockingAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	<-b.release
+	return b.API.QueryRange(ctx, query, r)
+}
+
+func TestQueryVerifierVerify(t *testing.T) {
+	downstream := &blockingAPI{
+		API:     &stubAPI{queryRange: func() model.Value { return verifyMatrix(1, 2) }},
+		release: make(chan struct{}),
+	}
+	cfg := DefaultQueryVerificationConfig
+	cfg.SampleRate = 1
+	cfg.MaxConcurrency = 1
+
+	v := NewQueryVerifier()
+	v.ApplyConfig(&cfg, []VerificationGroup{{Name: "eu", Labels: model.LabelSet{"region": "eu"}, API: downstream}})
+
+	r := v1.Range{Start: time.Unix(0, 0), End: time.Unix(1, 0), Step: time.Second}
+	served := verifyMatrix(1, 2)
+	v.Verify(context.TODO(), `up{region="eu"}`, r, served)
+	// Over the max concurrency, so this isn't verified
+	v.Verify(context.TODO(), `up{region="eu"}`, r, verifyMatrix(1, 3))
+	// Not routed to the group, so this isn't verified
+	v.Verify(context.TODO(), `up{region="us"}`, r, served)
+	close(downstream.release)
+	v.wg.Wait()
+	v.Verify(context.TODO(), `up{region="eu"}`, r, verifyMatrix(1, 3))
+	v.wg.Wait()
+
+	expected := map[string]uint64{VerificationMatch: 1, VerificationMismatch: 1, VerificationSkipped: 1}
+	for result, count := range expected {
+		if v.results[result] != count {
+			t.Fatalf("mismatch in %s verifications expected=%v actual=%v", result, count, v.results[result])
+		}
+	}
+	if len(v.results) != len(expected) {
+		t.Fatalf("mismatch in results expected=%v actual=%v", expected, v.results)
+	}
+	// The served result is never changed
+	if !reflect.DeepEqual(served, verifyMatrix(1, 2)) {
+		t.Fatalf("mismatch in served result expected=%v actual=%v", verifyMatrix(1, 2), served)
+	}
+}
@@ -0,0 +1,255 @@
+package promclient
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultWorkerPoolSize is the size of the DefaultWorkerPool if none is configured
+const DefaultWorkerPoolSize = 1024
+
+// DefaultWorkerPool is the WorkerPool used by the fanouts (MultiAPI, MultiTimeAPI)
+// which don't have their own
+var DefaultWorkerPool = NewWorkerPool(DefaultWorkerPoolSize)
+
+type workerPoolTaskKey struct{}
+
+// NewWorkerPool returns a WorkerPool running at most size tasks at once, a size
+// <= 0 doesn't limit the number of tasks
+func NewWorkerPool(size int) *WorkerPool {
+	return &WorkerPool{size: size}
+}
+
+// WorkerPool bounds the number of goroutines of the downstream fanouts. Stacked
+// fanouts (servergroups × targets × queries) each spawning a goroutine per task
+// easily add up to tens of thousands of goroutines, so instead the tasks share the
+// slots of a single pool.
+//
+// A task submitted while the pool is saturated is queued until a slot is free (or
+// the context of the task is done). Tasks submitted from within a task (a nested
+// fanout) are run inline by the submitting task instead, so a nested fanout never
+// waits on a slot while holding one and the pool cannot deadlock.
+type WorkerPool struct {
+	mu      sync.Mutex
+	size    int
+	running int
+	waiters []chan struct{}
+
+	// Counters of the tasks by how they were run, for the metrics
+	pooled int
+	inline int
+}
+
+// SetSize changes the number of tasks the pool runs at once, a size <= 0 doesn't
+// limit the number of tasks. Running tasks aren't affected, queued tasks are
+// started if the pool grew.
+func (p *WorkerPool) SetSize(size int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.size = size
+	for len(p.waiters) > 0 && p.hasSlot() {
+		p.running++
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+	}
+}
+
+// hasSlot returns whether a task may be started, p.mu must be held
+func (p *WorkerPool) hasSlot() bool {
+	return p.size <= 0 || p.running < p.size
+}
+
+// acquire takes a slot, queueing for it unless inline is set. It returns whether
+// a slot was taken
+func (p *WorkerPool) acquire(ctx context.Context, inline bool) (bool, error) {
+	p.mu.Lock()
+	if p.hasSlot() && len(p.waiters) == 0 {
+		p.running++
+		p.pooled++
+		p.mu.Unlock()
+		return true, nil
+	}
+	if inline {
+		p.inline++
+		p.mu.Unlock()
+		return false, nil
+	}
+	ch := make(chan struct{})
+	p.waiters = append(p.waiters, ch)
+	p.mu.Unlock()
+
+	select {
+	case <-ch:
+		p.mu.Lock()
+		p.pooled++
+		p.mu.Unlock()
+		return true, nil
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for i, waiter := range p.waiters {
+			if waiter == ch {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				return false, ctx.Err()
+			}
+		}
+		// The slot was handed over while the context was done, so pass it on
+		p.releaseLocked()
+		return false, ctx.Err()
+	}
+}
+
+// release frees the slot of a finished task
+func (p *WorkerPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.releaseLocked()
+}
+
+// releaseLocked frees a slot, handing it to the first queued task if the pool
+// isn't over its size. p.mu must be held
+func (p *WorkerPool) releaseLocked() {
+	p.running--
+	if len(p.waiters) > 0 && p.hasSlot() {
+		p.running++
+		close(p.waiters[0])
+		p.waiters = p.waiters[1:]
+	}
+}
+
+// Go runs fn in a goroutine of the pool, with a context derived from ctx. If the
+// pool is saturated the task is queued until a slot is free, an error is returned
+// (without calling fn) if ctx is done first. If Go is called from within a task of
+// the pool (determined by ctx) and the pool is saturated, fn is run inline before
+// Go returns.
+func (p *WorkerPool) Go(ctx context.Context, fn func(context.Context)) error {
+	stats := TaskStatsFromContext(ctx)
+	queueStart := time.Now()
+	nested := ctx.Value(workerPoolTaskKey{}) == p
+	pooled, err := p.acquire(ctx, nested)
+	if err != nil {
+		return err
+	}
+	if stats != nil {
+		stats.record(pooled, time.Since(queueStart))
+	}
+
+	taskCtx := context.WithValue(ctx, workerPoolTaskKey{}, p)
+	if !pooled {
+		fn(taskCtx)
+		return nil
+	}
+	go func() {
+		defer p.release()
+		fn(taskCtx)
+	}()
+	return nil
+}
+
+var (
+	workerPoolSizeDesc = prometheus.NewDesc(
+		"promproxy_worker_pool_size",
+		"The number of tasks the worker pool runs at once (0 for no limit)",
+		nil, nil,
+	)
+	workerPoolRunningDesc = prometheus.NewDesc(
+		"promproxy_worker_pool_running",
+		"The number of tasks running in the worker pool",
+		nil, nil,
+	)
+	workerPoolQueueLengthDesc = prometheus.NewDesc(
+		"promproxy_worker_pool_queue_length",
+		"The number of tasks queued for a slot of the worker pool",
+		nil, nil,
+	)
+	workerPoolUtilizationDesc = prometheus.NewDesc(
+		"promproxy_worker_pool_utilization",
+		"The ratio of the slots of the worker pool in use",
+		nil, nil,
+	)
+	workerPoolTasksDesc = prometheus.NewDesc(
+		"promproxy_worker_pool_tasks_total",
+		"The number of tasks submitted to the worker pool by how they were run (pooled or inline as the pool was saturated)",
+		[]string{"mode"}, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (p *WorkerPool) Describe(ch chan<- *prometheus.Desc) {
+	ch <- workerPoolSizeDesc
+	ch <- workerPoolRunningDesc
+	ch <- workerPoolQueueLengthDesc
+	ch <- workerPoolUtilizationDesc
+	ch <- workerPoolTasksDesc
+}
+
+// Collect implements prometheus.Collector
+func (p *WorkerPool) Collect(ch chan<- prometheus.Metric) {
+	p.mu.Lock()
+	size, running, queued, pooled, inline := p.size, p.running, len(p.waiters), p.pooled, p.inline
+	p.mu.Unlock()
+
+	if size < 0 {
+		size = 0
+	}
+	utilization := 0.0
+	if size > 0 {
+		utilization = float64(running) / float64(size)
+	}
+	ch <- prometheus.MustNewConstMetric(workerPoolSizeDesc, prometheus.GaugeValue, float64(size))
+	ch <- prometheus.MustNewConstMetric(workerPoolRunningDesc, prometheus.GaugeValue, float64(running))
+	ch <- prometheus.MustNewConstMetric(workerPoolQueueLengthDesc, prometheus.GaugeValue, float64(queued))
+	ch <- prometheus.MustNewConstMetric(workerPoolUtilizationDesc, prometheus.GaugeValue, utilization)
+	ch <- prometheus.MustNewConstMetric(workerPoolTasksDesc, prometheus.CounterValue, float64(pooled), "pooled")
+	ch <- prometheus.MustNewConstMetric(workerPoolTasksDesc, prometheus.CounterValue, float64(inline), "inline")
+}
+
+type taskStatsKey struct{}
+
+// WithTaskStats returns a context which accounts the downstream tasks of the
+// request in the given TaskCounter
+func WithTaskStats(ctx context.Context, c *TaskCounter) context.Context {
+	return context.WithValue(ctx, taskStatsKey{}, c)
+}
+
+// TaskStatsFromContext returns the TaskCounter of the context (if there is one)
+func TaskStatsFromContext(ctx context.Context) *TaskCounter {
+	c, _ := ctx.Value(taskStatsKey{}).(*TaskCounter)
+	return c
+}
+
+// TaskStats are the stats of the downstream tasks generated by a single request
+type TaskStats struct {
+	// Total is the number of tasks submitted to the WorkerPool
+	Total int
+	// Inline is the number of tasks run inline as the pool was saturated
+	Inline int
+	// QueueTime is the total time the tasks waited for a slot
+	QueueTime time.Duration
+}
+
+// TaskCounter accounts the downstream tasks of a single request
+type TaskCounter struct {
+	l     sync.Mutex
+	stats TaskStats
+}
+
+func (c *TaskCounter) record(pooled bool, queueTime time.Duration) {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.stats.Total++
+	if !pooled {
+		c.stats.Inline++
+	}
+	c.stats.QueueTime += queueTime
+}
+
+// Stats returns the stats of the tasks accounted so far
+func (c *TaskCounter) Stats() TaskStats {
+	c.l.Lock()
+	defer c.l.Unlock()
+	return c.stats
+}
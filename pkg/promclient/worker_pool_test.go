@@ -0,0 +1,168 @@
+package promclient
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+func TestWorkerPoolQueueing(t *testing.T) {
+	p := NewWorkerPool(1)
+
+	release := make(chan struct{})
+	if err := p.Go(context.TODO(), func(context.Context) { <-release }); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The pool is saturated, so the task is queued until its deadline
+	ctx, cancel := context.WithTimeout(context.TODO(), 20*time.Millisecond)
+	defer cancel()
+	ran := false
+	if err := p.Go(ctx, func(context.Context) { ran = true }); err != context.DeadlineExceeded {
+		t.Fatalf("mismatch in error expected=%v actual=%v", context.DeadlineExceeded, err)
+	}
+	if ran {
+		t.Fatalf("task ran after its deadline")
+	}
+
+	// A queued task starts once the slot is free
+	done := make(chan struct{})
+	go func() {
+		p.Go(context.TODO(), func(context.Context) { close(done) })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("queued task didn't run")
+	}
+}
+
+func TestWorkerPoolNested(t *testing.T) {
+	p := NewWorkerPool(1)
+	stats := &TaskCounter{}
+	ctx := WithTaskStats(context.TODO(), stats)
+
+	// The nested task can't get a slot while the outer one holds the only one, so
+	// it is run inline instead of deadlocking
+	done := make(chan struct{})
+	p.Go(ctx, func(ctx context.Context) {
+		nestedRan := false
+		p.Go(ctx, func(context.Context) { nestedRan = true })
+		if !nestedRan {
+			t.Errorf("nested task wasn't run inline")
+		}
+		close(done)
+	})
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("nested task deadlocked")
+	}
+
+	if s := stats.Stats(); s.Total != 2 || s.Inline != 1 {
+		t.Fatalf("mismatch in stats expected=2,1 actual=%d,%d", s.Total, s.Inline)
+	}
+}
+
+// sleepAPI answers queries after the given latency
+type sleepAPI struct {
+	API
+	latency time.Duration
+	calls   int64
+}
+
+func (s *sleepAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	atomic.AddInt64(&s.calls, 1)
+	select {
+	case <-time.After(s.latency):
+		return model.Vector{}, nil, nil
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// TestWorkerPoolGoroutineCeiling runs concurrent queries through stacked fanouts
+// (servergroups × targets) and verifies the number of goroutines stays within the
+// size of the pool
+func TestWorkerPoolGoroutineCeiling(t *testing.T) {
+	const (
+		poolSize     = 8
+		queries      = 20
+		servergroups = 10
+		targets      = 10
+	)
+	p := NewWorkerPool(poolSize)
+	leaf := &sleepAPI{latency: time.Millisecond}
+
+	groups := make([]API, servergroups)
+	for i := range groups {
+		apis := make([]API, targets)
+		for j := range apis {
+			apis[j] = leaf
+		}
+		group := NewMultiAPI(apis, 0, nil, 1)
+		group.WorkerPool = p
+		groups[i] = group
+	}
+	top := NewMultiAPI(groups, 0, nil, 1)
+	top.WorkerPool = p
+
+	baseline := runtime.NumGoroutine()
+
+	var maxGoroutines int64
+	stop := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		for {
+			if n := int64(runtime.NumGoroutine()); n > maxGoroutines {
+				maxGoroutines = n
+			}
+			select {
+			case <-stop:
+				return
+			case <-time.After(100 * time.Microsecond):
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	counters := make([]*TaskCounter, queries)
+	for i := 0; i < queries; i++ {
+		counters[i] = &TaskCounter{}
+		wg.Add(1)
+		go func(c *TaskCounter) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(WithTaskStats(context.TODO(), c), 30*time.Second)
+			defer cancel()
+			if _, _, err := top.Query(ctx, "up", time.Now()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(counters[i])
+	}
+	wg.Wait()
+	close(stop)
+	<-sampled
+
+	// The callers, the sampler and the pool's goroutines (plus some slack for the runtime)
+	ceiling := int64(baseline + queries + 1 + poolSize + 5)
+	if maxGoroutines > ceiling {
+		t.Fatalf("too many goroutines expected<=%d actual=%d", ceiling, maxGoroutines)
+	}
+	if calls := atomic.LoadInt64(&leaf.calls); calls != queries*servergroups*targets {
+		t.Fatalf("mismatch in calls expected=%d actual=%d", queries*servergroups*targets, calls)
+	}
+	for i, c := range counters {
+		if total := c.Stats().Total; total != servergroups+servergroups*targets {
+			t.Fatalf("mismatch in tasks of query %d expected=%d actual=%d", i, servergroups+servergroups*targets, total)
+		}
+	}
+}
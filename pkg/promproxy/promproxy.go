@@ -0,0 +1,203 @@
+// Package promproxy is the library interface of promproxy: the merged query layer
+// (fan-out to the servergroups, merging and deduplication) without the HTTP
+// server, for embedding in other services.
+package promproxy
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/storage"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/proxystorage"
+)
+
+const (
+	// DefaultMaxConcurrentQueries is the default of Config.MaxConcurrentQueries
+	DefaultMaxConcurrentQueries = 20
+	// DefaultMaxSamples is the default of Config.MaxSamples
+	DefaultMaxSamples = 50000000
+	// DefaultQueryTimeout is the default of Config.QueryTimeout
+	DefaultQueryTimeout = 2 * time.Minute
+)
+
+// Config is the config of a Proxy, as under the promxy key of the config file
+// (see ConfigFromFile). The zero Config is the DefaultPromxyConfig, without
+// servergroups.
+type Config struct {
+	proxyconfig.PromxyConfig
+
+	// MaxConcurrentQueries is the max number of queries evaluated at once
+	// (DefaultMaxConcurrentQueries if 0)
+	MaxConcurrentQueries int
+	// MaxSamples is the max number of samples a query can load into memory
+	// (DefaultMaxSamples if 0)
+	MaxSamples int
+	// QueryTimeout is the max time a query can take (DefaultQueryTimeout if 0)
+	QueryTimeout time.Duration
+}
+
+// ConfigFromFile loads the Config from the promxy section of the config file at
+// path
+func ConfigFromFile(path string) (Config, error) {
+	cfg, err := proxyconfig.ConfigFromFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+	return Config{PromxyConfig: cfg.PromxyConfig}, nil
+}
+
+// Proxy is the merged query layer over the servergroups of its config. Its
+// process wide settings (e.g. the worker pool, load shedding and tracing) apply to
+// the whole process, so there should be one Proxy per process.
+type Proxy struct {
+	storage *proxystorage.ProxyStorage
+	engine  *promql.Engine
+}
+
+// New returns the Proxy of the config, once its servergroups are ready
+func New(cfg Config) (*Proxy, error) {
+	if reflect.DeepEqual(cfg.PromxyConfig, proxyconfig.PromxyConfig{}) {
+		cfg.PromxyConfig = proxyconfig.DefaultPromxyConfig
+	}
+	if cfg.MaxConcurrentQueries == 0 {
+		cfg.MaxConcurrentQueries = DefaultMaxConcurrentQueries
+	}
+	if cfg.MaxSamples == 0 {
+		cfg.MaxSamples = DefaultMaxSamples
+	}
+	if cfg.QueryTimeout == 0 {
+		cfg.QueryTimeout = DefaultQueryTimeout
+	}
+
+	ps, err := proxystorage.NewProxyStorage()
+	if err != nil {
+		return nil, err
+	}
+	// Remote write is only done by the server
+	if err := ps.ApplyConfig(&proxyconfig.Config{
+		PromConfig:   config.DefaultConfig,
+		PromxyConfig: cfg.PromxyConfig,
+	}); err != nil {
+		return nil, err
+	}
+
+	// Queries are evaluated as the server does, pushing down what the
+	// servergroups can answer on their own
+	engine := promql.NewEngine(promql.EngineOpts{
+		MaxConcurrent: cfg.MaxConcurrentQueries,
+		MaxSamples:    cfg.MaxSamples,
+		Timeout:       cfg.QueryTimeout,
+	})
+	engine.NodeReplacer = ps.NodeReplacer
+	return &Proxy{storage: ps, engine: engine}, nil
+}
+
+// Query performs a query for the given time.
+func (p *Proxy) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	q, err := p.engine.NewInstantQuery(p.storage, query, ts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return exec(ctx, q)
+}
+
+// QueryRange performs a query for the given range.
+func (p *Proxy) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	q, err := p.engine.NewRangeQuery(p.storage, query, r.Start, r.End, r.Step)
+	if err != nil {
+		return nil, nil, err
+	}
+	return exec(ctx, q)
+}
+
+// exec evaluates the query, returning its result as the prometheus API would
+func exec(ctx context.Context, q promql.Query) (model.Value, api.Warnings, error) {
+	defer q.Close()
+	res := q.Exec(ctx)
+	warnings := make(api.Warnings, len(res.Warnings))
+	for i, w := range res.Warnings {
+		warnings[i] = w.Error()
+	}
+	if res.Err != nil {
+		return nil, warnings, res.Err
+	}
+	v, err := modelValue(res.Value)
+	return v, warnings, err
+}
+
+// modelValue converts the result of a promql query to a model.Value
+func modelValue(v promql.Value) (model.Value, error) {
+	switch typed := v.(type) {
+	case promql.Scalar:
+		return &model.Scalar{Timestamp: model.Time(typed.T), Value: model.SampleValue(typed.V)}, nil
+	case promql.String:
+		return &model.String{Timestamp: model.Time(typed.T), Value: typed.V}, nil
+	case promql.Vector:
+		vector := make(model.Vector, len(typed))
+		for i, sample := range typed {
+			vector[i] = &model.Sample{
+				Metric:    modelMetric(sample.Metric),
+				Timestamp: model.Time(sample.T),
+				Value:     model.SampleValue(sample.V),
+			}
+		}
+		return vector, nil
+	case promql.Matrix:
+		matrix := make(model.Matrix, len(typed))
+		for i, series := range typed {
+			values := make([]model.SamplePair, len(series.Points))
+			for j, point := range series.Points {
+				values[j] = model.SamplePair{Timestamp: model.Time(point.T), Value: model.SampleValue(point.V)}
+			}
+			matrix[i] = &model.SampleStream{Metric: modelMetric(series.Metric), Values: values}
+		}
+		return matrix, nil
+	default:
+		return nil, fmt.Errorf("unexpected value type %T for query", v)
+	}
+}
+
+func modelMetric(lset labels.Labels) model.Metric {
+	metric := make(model.Metric, len(lset))
+	for _, l := range lset {
+		metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return metric
+}
+
+// Series finds series by label matchers.
+func (p *Proxy) Series(ctx context.Context, matches []string, startTime, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	return p.storage.Client().Series(ctx, matches, startTime, endTime)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (p *Proxy) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	return p.storage.Client().LabelNames(ctx)
+}
+
+// LabelValues performs a query for the values of the given label.
+func (p *Proxy) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	return p.storage.Client().LabelValues(ctx, label)
+}
+
+// Queryable returns the Queryable of the Proxy, for evaluating queries with a
+// promql.Engine
+func (p *Proxy) Queryable() storage.Queryable {
+	return p.storage
+}
+
+// Close stops the servergroups and background workers of the Proxy, which can't
+// be used after
+func (p *Proxy) Close() error {
+	return p.storage.Close()
+}
@@ -0,0 +1,133 @@
+package promproxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	yaml "gopkg.in/yaml.v2"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/promclient"
+)
+
+func TestProxyWithoutServerGroups(t *testing.T) {
+	p, err := New(Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	names, _, err := p.LabelNames(context.TODO())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("mismatch in label names expected=[] actual=%v", names)
+	}
+	if p.Queryable() == nil {
+		t.Fatalf("mismatch in queryable expected=non-nil actual=nil")
+	}
+}
+
+func TestProxyQuery(t *testing.T) {
+	p, err := New(Config{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	// Queries are evaluated by the proxy, not sent as-is to the servergroups
+	v, _, err := p.Query(context.TODO(), "1 + 1", time.Unix(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &model.Scalar{Timestamp: model.TimeFromUnix(100), Value: 2}
+	if scalar, ok := v.(*model.Scalar); !ok || *scalar != *expected {
+		t.Fatalf("mismatch in result expected=%v actual=%v", expected, v)
+	}
+
+	v, _, err = p.QueryRange(context.TODO(), "vector(1)", v1.Range{Start: time.Unix(0, 0), End: time.Unix(60, 0), Step: 30 * time.Second})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	matrix, ok := v.(model.Matrix)
+	if !ok || len(matrix) != 1 || len(matrix[0].Values) != 3 {
+		t.Fatalf("mismatch in result expected=1 series of 3 points actual=%v", v)
+	}
+
+	if _, _, err := p.Query(context.TODO(), "sum(", time.Unix(100, 0)); err == nil {
+		t.Fatalf("expected a parse error")
+	}
+}
+
+func TestProxyQueryLimitNotPushedDown(t *testing.T) {
+	var (
+		l      sync.Mutex
+		limits []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" {
+			http.NotFound(w, r)
+			return
+		}
+		l.Lock()
+		limits = append(limits, r.FormValue("limit"))
+		l.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[` +
+			`{"metric":{"pod":"a"},"value":[100,"2"]},` +
+			`{"metric":{"pod":"b"},"value":[100,"4"]}]}}`))
+	}))
+	defer srv.Close()
+
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var cfg Config
+	cfg.PromxyConfig = proxyconfig.DefaultPromxyConfig
+	sgConfig := fmt.Sprintf(`
+server_groups:
+  - static_configs:
+      - targets: [%q]
+    query_limit: enabled
+`, u.Host)
+	if err := yaml.Unmarshal([]byte(sgConfig), &cfg.PromxyConfig); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	p, err := New(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	// Both sums are pushed down, truncating either of them would drop series
+	// (or match the wrong ones) from the result of the division
+	ctx := promclient.WithQueryLimit(context.TODO(), 1)
+	v, _, err := p.Query(ctx, "sum by(pod) (a) / sum by(pod) (b)", time.Unix(100, 0))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if vector, ok := v.(model.Vector); !ok || len(vector) != 2 {
+		t.Fatalf("mismatch in result expected=2 series actual=%v", v)
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	if len(limits) != 2 {
+		t.Fatalf("mismatch in pushed down queries expected=%d actual=%d", 2, len(limits))
+	}
+	for _, limit := range limits {
+		if limit != "" {
+			t.Fatalf("mismatch in limit of pushed down query expected=%q actual=%q", "", limit)
+		}
+	}
+}
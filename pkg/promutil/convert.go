@@ -0,0 +1,28 @@
+package promutil
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ModelMetricToLabels returns the labels of the metric, sorted by name as
+// prometheus expects them
+func ModelMetricToLabels(m model.Metric) labels.Labels {
+	ls := make(labels.Labels, 0, len(m))
+	for k, v := range m {
+		ls = append(ls, labels.Label{Name: string(k), Value: string(v)})
+	}
+	sort.Sort(ls)
+	return ls
+}
+
+// LabelsToModelMetric returns the metric of the labels
+func LabelsToModelMetric(ls labels.Labels) model.Metric {
+	m := make(model.Metric, len(ls))
+	for _, l := range ls {
+		m[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return m
+}
@@ -0,0 +1,49 @@
+package promutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestConvertLabels(t *testing.T) {
+	tests := []struct {
+		metric model.Metric
+		labels labels.Labels
+	}{
+		{
+			metric: model.Metric{},
+			labels: labels.Labels{},
+		},
+		{
+			metric: model.Metric{model.MetricNameLabel: "up", "job": "api", "instance": "10.0.0.1:9090"},
+			labels: labels.FromStrings(model.MetricNameLabel, "up", "instance", "10.0.0.1:9090", "job", "api"),
+		},
+		{
+			metric: model.Metric{
+				model.MetricNameLabel: "http_requests_total",
+				"path":                `/api/v1/query?query=up{job="a"}`,
+				"message":             "line one\nline two\t\\ ünïcødé ✓",
+				"__meta_special":      "",
+			},
+			labels: labels.FromStrings(
+				model.MetricNameLabel, "http_requests_total",
+				"path", `/api/v1/query?query=up{job="a"}`,
+				"message", "line one\nline two\t\\ ünïcødé ✓",
+				"__meta_special", "",
+			),
+		},
+	}
+
+	for i, test := range tests {
+		ls := ModelMetricToLabels(test.metric)
+		if !reflect.DeepEqual(ls, test.labels) {
+			t.Fatalf("%d: mismatch in labels expected=%v actual=%v", i, test.labels, ls)
+		}
+		if m := LabelsToModelMetric(ls); !m.Equal(test.metric) {
+			t.Fatalf("%d: mismatch in round-tripped metric expected=%v actual=%v", i, test.metric, m)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package promutil
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// durationUnits are the units of a prometheus duration, in the order they must appear
+var durationUnits = []struct {
+	unit string
+	d    time.Duration
+}{
+	{"y", time.Hour * 24 * 365},
+	{"w", time.Hour * 24 * 7},
+	{"d", time.Hour * 24},
+	{"h", time.Hour},
+	{"m", time.Minute},
+	{"s", time.Second},
+	{"ms", time.Millisecond},
+}
+
+// ParsePromDuration parses a duration in the prometheus format (e.g. 1d2h3m), which
+// unlike time.ParseDuration supports the y, w and d units. Each unit may appear at
+// most once and units must be ordered from largest to smallest
+func ParsePromDuration(s string) (time.Duration, error) {
+	switch s {
+	case "":
+		return 0, fmt.Errorf("empty duration string")
+	case "0":
+		// Allow 0 without a unit, as prometheus does
+		return 0, nil
+	}
+
+	orig := s
+	var total time.Duration
+	next := 0 // index of the smallest unit allowed next
+	for s != "" {
+		// Leading number
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("not a valid duration string: %q", orig)
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("not a valid duration string: %q", orig)
+		}
+		s = s[i:]
+
+		// Unit, "ms" has to be checked before "m"
+		unitIdx := -1
+		for j := len(durationUnits) - 1; j >= next; j-- {
+			if strings.HasPrefix(s, durationUnits[j].unit) {
+				if unitIdx == -1 || len(durationUnits[j].unit) > len(durationUnits[unitIdx].unit) {
+					unitIdx = j
+				}
+			}
+		}
+		if unitIdx == -1 {
+			return 0, fmt.Errorf("not a valid duration string: %q", orig)
+		}
+		unit := durationUnits[unitIdx]
+		s = s[len(unit.unit):]
+		next = unitIdx + 1
+
+		if n > int64(math.MaxInt64/unit.d) {
+			return 0, fmt.Errorf("duration out of range: %q", orig)
+		}
+		v := time.Duration(n) * unit.d
+		if total > math.MaxInt64-v {
+			return 0, fmt.Errorf("duration out of range: %q", orig)
+		}
+		total += v
+	}
+
+	return total, nil
+}
+
+// FormatPromDuration formats the duration in the prometheus format, the inverse of
+// ParsePromDuration. The duration is truncated to millisecond precision
+func FormatPromDuration(d time.Duration) string {
+	if d <= -time.Millisecond {
+		return "-" + FormatPromDuration(-(d / time.Millisecond * time.Millisecond))
+	}
+	if d < time.Millisecond {
+		return "0s"
+	}
+
+	var b strings.Builder
+	for _, unit := range durationUnits {
+		if n := d / unit.d; n > 0 {
+			b.WriteString(strconv.FormatInt(int64(n), 10))
+			b.WriteString(unit.unit)
+			d -= n * unit.d
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,130 @@
+package promutil
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestParsePromDuration(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		input  string
+		result time.Duration
+		err    bool
+	}{
+		// Single units
+		{input: "0s", result: 0},
+		{input: "0", result: 0},
+		{input: "1ms", result: time.Millisecond},
+		{input: "1s", result: time.Second},
+		{input: "1m", result: time.Minute},
+		{input: "1h", result: time.Hour},
+		{input: "1d", result: day},
+		{input: "1w", result: 7 * day},
+		{input: "1y", result: 365 * day},
+		{input: "90s", result: 90 * time.Second},
+		{input: "120m", result: 2 * time.Hour},
+		{input: "500ms", result: 500 * time.Millisecond},
+		{input: "0ms", result: 0},
+
+		// Multiple units
+		{input: "1d2h3m", result: day + 2*time.Hour + 3*time.Minute},
+		{input: "1h30m", result: 90 * time.Minute},
+		{input: "1m30s", result: 90 * time.Second},
+		{input: "1s500ms", result: 1500 * time.Millisecond},
+		{input: "1y1w1d1h1m1s1ms", result: 365*day + 7*day + day + time.Hour + time.Minute + time.Second + time.Millisecond},
+		{input: "2w3d", result: 17 * day},
+		{input: "1h0m", result: time.Hour},
+		{input: "5m1ms", result: 5*time.Minute + time.Millisecond},
+
+		// Units out of order or repeated
+		{input: "1m1h", err: true},
+		{input: "1s1m", err: true},
+		{input: "1h1h", err: true},
+		{input: "1ms1s", err: true},
+
+		// Negative
+		{input: "-1s", err: true},
+		{input: "-1d2h", err: true},
+		{input: "1h-1m", err: true},
+
+		// Invalid syntax
+		{input: "", err: true},
+		{input: "s", err: true},
+		{input: "1", err: true},
+		{input: "1.5h", err: true},
+		{input: "1x", err: true},
+		{input: "1 h", err: true},
+		{input: " 1h", err: true},
+		{input: "1hh", err: true},
+		{input: "1H", err: true},
+		{input: "1us", err: true},
+
+		// Very large values
+		{input: "292y", result: 292 * 365 * day},
+		{input: "106751d", result: 106751 * day},
+		{input: "9223372036854ms", result: 9223372036854 * time.Millisecond},
+		{input: "293y", err: true},
+		{input: "292y52w", err: true},
+		{input: "9223372036855s", err: true},
+		{input: "99999999999999999999s", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			result, err := ParsePromDuration(test.input)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if result != test.result {
+				t.Fatalf("mismatch in result expected=%v actual=%v", test.result, result)
+			}
+		})
+	}
+}
+
+func TestFormatPromDuration(t *testing.T) {
+	day := 24 * time.Hour
+
+	tests := []struct {
+		input  time.Duration
+		result string
+	}{
+		{input: 0, result: "0s"},
+		{input: time.Microsecond, result: "0s"},
+		{input: time.Millisecond, result: "1ms"},
+		{input: 1500 * time.Millisecond, result: "1s500ms"},
+		{input: 90 * time.Second, result: "1m30s"},
+		{input: time.Hour, result: "1h"},
+		{input: day + 2*time.Hour + 3*time.Minute, result: "1d2h3m"},
+		{input: 17 * day, result: "2w3d"},
+		{input: 365 * day, result: "1y"},
+		{input: 400 * day, result: "1y5w"},
+		{input: -90 * time.Second, result: "-1m30s"},
+		{input: time.Duration(math.MaxInt64), result: "292y24w3d23h47m16s854ms"},
+		{input: time.Duration(math.MinInt64), result: "-292y24w3d23h47m16s854ms"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.result, func(t *testing.T) {
+			result := FormatPromDuration(test.input)
+			if result != test.result {
+				t.Fatalf("mismatch in result expected=%s actual=%s", test.result, result)
+			}
+
+			// Non-negative durations must round trip
+			if test.input < 0 {
+				return
+			}
+			parsed, err := ParsePromDuration(result)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if parsed != test.input.Truncate(time.Millisecond) {
+				t.Fatalf("mismatch in round trip expected=%v actual=%v", test.input.Truncate(time.Millisecond), parsed)
+			}
+		})
+	}
+}
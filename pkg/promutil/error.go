@@ -0,0 +1,91 @@
+package promutil
+
+import "strings"
+
+// The typed errors of promproxy carry the upstream (if any) and the method of the
+// call which failed, and wrap its cause. They unwrap to the cause both for
+// errors.As/errors.Is and for errors.Cause (of github.com/pkg/errors), so the
+// checks of the cause's type work through them.
+
+// formatError formats a typed error as `<kind>[ <upstream>]: <method>: <cause>`
+func formatError(kind, upstream, method string, err error) string {
+	var b strings.Builder
+	b.WriteString(kind)
+	if upstream != "" {
+		b.WriteString(" ")
+		b.WriteString(upstream)
+	}
+	if method != "" {
+		b.WriteString(": ")
+		b.WriteString(method)
+	}
+	if err != nil {
+		b.WriteString(": ")
+		b.WriteString(err.Error())
+	}
+	return b.String()
+}
+
+// ProxyError is an error of promproxy itself (e.g. a limit of the proxy), rather
+// than of one of its upstreams
+type ProxyError struct {
+	Upstream string
+	Method   string
+	Err      error
+}
+
+func (e *ProxyError) Error() string { return formatError("proxy", e.Upstream, e.Method, e.Err) }
+
+// Unwrap returns the cause of the error
+func (e *ProxyError) Unwrap() error { return e.Err }
+
+// Cause returns the cause of the error
+func (e *ProxyError) Cause() error { return e.Err }
+
+// UpstreamError is an error returned by an upstream (Upstream is empty if the
+// call went to several of them, e.g. all the servergroups)
+type UpstreamError struct {
+	Upstream string
+	Method   string
+	Err      error
+}
+
+func (e *UpstreamError) Error() string { return formatError("upstream", e.Upstream, e.Method, e.Err) }
+
+// Unwrap returns the cause of the error
+func (e *UpstreamError) Unwrap() error { return e.Err }
+
+// Cause returns the cause of the error
+func (e *UpstreamError) Cause() error { return e.Err }
+
+// ConfigError is an error in the config, e.g. of an upstream which couldn't be
+// configured
+type ConfigError struct {
+	Upstream string
+	Method   string
+	Err      error
+}
+
+func (e *ConfigError) Error() string { return formatError("config", e.Upstream, e.Method, e.Err) }
+
+// Unwrap returns the cause of the error
+func (e *ConfigError) Unwrap() error { return e.Err }
+
+// Cause returns the cause of the error
+func (e *ConfigError) Cause() error { return e.Err }
+
+// ValidationError is an invalid request, which is rejected before it is sent to
+// any upstream
+type ValidationError struct {
+	Upstream string
+	Method   string
+	Err      error
+}
+
+func (e *ValidationError) Error() string { return formatError("invalid", e.Upstream, e.Method, e.Err) }
+
+// Unwrap returns the cause of the error
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Cause returns the cause of the error
+func (e *ValidationError) Cause() error { return e.Err }
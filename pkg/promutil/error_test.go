@@ -0,0 +1,73 @@
+package promutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func TestErrorString(t *testing.T) {
+	cause := errors.New("connection refused")
+	tests := []struct {
+		err      error
+		expected string
+	}{
+		{err: &UpstreamError{Upstream: "prom-eu", Method: "query", Err: cause}, expected: "upstream prom-eu: query: connection refused"},
+		{err: &UpstreamError{Method: "series", Err: cause}, expected: "upstream: series: connection refused"},
+		{err: &ProxyError{Method: "query", Err: cause}, expected: "proxy: query: connection refused"},
+		{err: &ConfigError{Upstream: "prom-eu", Err: cause}, expected: "config prom-eu: connection refused"},
+		{err: &ValidationError{Method: "label_values", Err: cause}, expected: "invalid: label_values: connection refused"},
+	}
+
+	for i, test := range tests {
+		if s := test.err.Error(); s != test.expected {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.expected, s)
+		}
+	}
+}
+
+func TestErrorsAs(t *testing.T) {
+	upstream := &UpstreamError{Upstream: "prom-eu", Method: "query", Err: context.DeadlineExceeded}
+	validation := &ValidationError{Method: "label_values", Err: errors.New("invalid label name")}
+
+	tests := []struct {
+		name string
+		err  error
+		// upstream and validation are the errors expected to be found in err
+		upstream   *UpstreamError
+		validation *ValidationError
+		// cause is the root cause of err
+		cause error
+	}{
+		{name: "direct", err: upstream, upstream: upstream, cause: context.DeadlineExceeded},
+		{name: "fmt", err: fmt.Errorf("select: %w", upstream), upstream: upstream},
+		{name: "fmt twice", err: fmt.Errorf("eval: %w", fmt.Errorf("select: %w", upstream)), upstream: upstream},
+		{name: "pkg errors", err: pkgerrors.Wrap(pkgerrors.Wrap(upstream, "select"), "eval"), upstream: upstream, cause: context.DeadlineExceeded},
+		{name: "in proxy error", err: &ProxyError{Method: "query", Err: pkgerrors.Wrap(upstream, "select")}, upstream: upstream, cause: context.DeadlineExceeded},
+		{name: "validation", err: fmt.Errorf("select: %w", validation), validation: validation},
+		{name: "local", err: pkgerrors.Wrap(context.Canceled, "select"), cause: context.Canceled},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var upstreamErr *UpstreamError
+			if found := errors.As(test.err, &upstreamErr); found != (test.upstream != nil) || upstreamErr != test.upstream {
+				t.Fatalf("mismatch in upstream error expected=%v actual=%v", test.upstream, upstreamErr)
+			}
+			var validationErr *ValidationError
+			if found := errors.As(test.err, &validationErr); found != (test.validation != nil) || validationErr != test.validation {
+				t.Fatalf("mismatch in validation error expected=%v actual=%v", test.validation, validationErr)
+			}
+			if test.upstream != nil && !errors.Is(test.err, test.upstream.Err) {
+				t.Fatalf("mismatch in errors.Is of %v expected=true actual=false", test.upstream.Err)
+			}
+			// errors.Cause only unwraps the errors with a Cause method
+			if test.cause != nil && pkgerrors.Cause(test.err) != test.cause {
+				t.Fatalf("mismatch in cause expected=%v actual=%v", test.cause, pkgerrors.Cause(test.err))
+			}
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package promutil
+
+import (
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// FilterLabelSets returns the label sets which match all the matchers (e.g. to
+// filter the result of a Series call for matchers the upstream doesn't support).
+// As in prometheus a missing label matches as the empty value. The sets aren't
+// modified.
+func FilterLabelSets(sets []model.LabelSet, matchers []*labels.Matcher) []model.LabelSet {
+	filtered := make([]model.LabelSet, 0, len(sets))
+	for _, set := range sets {
+		if matchLabelSet(set, matchers) {
+			filtered = append(filtered, set)
+		}
+	}
+	return filtered
+}
+
+func matchLabelSet(set model.LabelSet, matchers []*labels.Matcher) bool {
+	for _, matcher := range matchers {
+		if !matcher.Matches(string(set[model.LabelName(matcher.Name)])) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,217 @@
+package promutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestFilterLabelSets(t *testing.T) {
+	sets := []model.LabelSet{
+		{"__name__": "up", "job": "api", "instance": "a:9090"},
+		{"__name__": "up", "job": "db", "instance": "b:9090"},
+		{"__name__": "http_requests_total", "job": "api", "code": "200"},
+		{"__name__": "http_requests_total", "job": "api", "code": "500"},
+		{"__name__": "node_cpu", "job": "node"},
+	}
+
+	matcher := func(t labels.MatchType, name, value string) *labels.Matcher {
+		m, err := labels.NewMatcher(t, name, value)
+		if err != nil {
+			panic(err)
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		sets     []model.LabelSet
+		matchers []*labels.Matcher
+		expected []int
+	}{
+		{
+			name:     "no matchers",
+			sets:     sets,
+			expected: []int{0, 1, 2, 3, 4},
+		},
+		{
+			name:     "no sets",
+			matchers: []*labels.Matcher{matcher(labels.MatchEqual, "job", "api")},
+			expected: []int{},
+		},
+		{
+			name:     "equal",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchEqual, "job", "api")},
+			expected: []int{0, 2, 3},
+		},
+		{
+			name:     "equal name",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchEqual, "__name__", "up")},
+			expected: []int{0, 1},
+		},
+		{
+			name:     "equal no match",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchEqual, "job", "web")},
+			expected: []int{},
+		},
+		{
+			name:     "equal empty matches missing label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchEqual, "code", "")},
+			expected: []int{0, 1, 4},
+		},
+		{
+			name:     "not equal",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotEqual, "job", "api")},
+			expected: []int{1, 4},
+		},
+		{
+			name:     "not equal missing label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotEqual, "code", "200")},
+			expected: []int{0, 1, 3, 4},
+		},
+		{
+			name:     "not equal empty requires label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotEqual, "instance", "")},
+			expected: []int{0, 1},
+		},
+		{
+			name:     "regex",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "job", "api|db")},
+			expected: []int{0, 1, 2, 3},
+		},
+		{
+			name:     "regex is anchored",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "job", "ap")},
+			expected: []int{},
+		},
+		{
+			name:     "regex prefix",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "__name__", "http_.*")},
+			expected: []int{2, 3},
+		},
+		{
+			name:     "regex character class",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "code", "5..")},
+			expected: []int{3},
+		},
+		{
+			name:     "regex matching empty matches missing label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "code", "2..|")},
+			expected: []int{0, 1, 2, 4},
+		},
+		{
+			name:     "regex any requires label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "code", ".+")},
+			expected: []int{2, 3},
+		},
+		{
+			name:     "not regex",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotRegexp, "job", "api|db")},
+			expected: []int{4},
+		},
+		{
+			name:     "not regex missing label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotRegexp, "code", "5..")},
+			expected: []int{0, 1, 2, 4},
+		},
+		{
+			name:     "not regex empty requires label",
+			sets:     sets,
+			matchers: []*labels.Matcher{matcher(labels.MatchNotRegexp, "instance", "")},
+			expected: []int{0, 1},
+		},
+		{
+			name: "and of equal",
+			sets: sets,
+			matchers: []*labels.Matcher{
+				matcher(labels.MatchEqual, "__name__", "http_requests_total"),
+				matcher(labels.MatchEqual, "code", "500"),
+			},
+			expected: []int{3},
+		},
+		{
+			name: "and of equal and negation",
+			sets: sets,
+			matchers: []*labels.Matcher{
+				matcher(labels.MatchEqual, "job", "api"),
+				matcher(labels.MatchNotEqual, "code", "500"),
+			},
+			expected: []int{0, 2},
+		},
+		{
+			name: "and of regex and not regex",
+			sets: sets,
+			matchers: []*labels.Matcher{
+				matcher(labels.MatchRegexp, "__name__", ".+"),
+				matcher(labels.MatchNotRegexp, "__name__", "up|node_.*"),
+			},
+			expected: []int{2, 3},
+		},
+		{
+			name: "contradicting matchers",
+			sets: sets,
+			matchers: []*labels.Matcher{
+				matcher(labels.MatchEqual, "job", "api"),
+				matcher(labels.MatchNotEqual, "job", "api"),
+			},
+			expected: []int{},
+		},
+		{
+			name: "same label twice",
+			sets: sets,
+			matchers: []*labels.Matcher{
+				matcher(labels.MatchRegexp, "job", "api|db|node"),
+				matcher(labels.MatchNotRegexp, "job", "db"),
+			},
+			expected: []int{0, 2, 3, 4},
+		},
+		{
+			name: "special characters",
+			sets: []model.LabelSet{
+				{"__name__": "up", "path": "/api/v1/query?x=\"y\""},
+				{"__name__": "up", "path": "/metrics"},
+			},
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "path", `/api/.*\?x=".*"`)},
+			expected: []int{0},
+		},
+		{
+			name: "unicode",
+			sets: []model.LabelSet{
+				{"__name__": "up", "city": "zürich"},
+				{"__name__": "up", "city": "zurich"},
+			},
+			matchers: []*labels.Matcher{matcher(labels.MatchRegexp, "city", "z.rich")},
+			expected: []int{0, 1},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			expected := make([]model.LabelSet, 0, len(test.expected))
+			for _, i := range test.expected {
+				expected = append(expected, test.sets[i])
+			}
+			filtered := FilterLabelSets(test.sets, test.matchers)
+			if !reflect.DeepEqual(filtered, expected) {
+				t.Fatalf("mismatch in label sets expected=%v actual=%v", expected, filtered)
+			}
+		})
+	}
+}
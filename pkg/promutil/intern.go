@@ -0,0 +1,84 @@
+package promutil
+
+import (
+	"sync"
+
+	"github.com/prometheus/common/model"
+)
+
+// NewLabelInterner returns an empty LabelInterner, which should be released once
+// the query is done with it
+func NewLabelInterner() *LabelInterner {
+	return &LabelInterner{strings: make(map[string]string)}
+}
+
+// LabelInterner makes the identical label names and values of the series of a
+// query share their backing storage. The series of a large result repeat the same
+// strings (e.g. the namespace and pod) thousands of times, each decoded into its
+// own copy. It is safe for concurrent use.
+type LabelInterner struct {
+	mu      sync.Mutex
+	strings map[string]string
+}
+
+// Intern returns the interned copy of s
+func (i *LabelInterner) Intern(s string) string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.intern(s)
+}
+
+// intern returns the interned copy of s, i.mu must be held
+func (i *LabelInterner) intern(s string) string {
+	// A released interner is a no-op, for the series created after the release
+	if i.strings == nil {
+		return s
+	}
+	if interned, ok := i.strings[s]; ok {
+		return interned
+	}
+	i.strings[s] = s
+	return s
+}
+
+// Metric returns a copy of the metric with its names and values interned
+func (i *LabelInterner) Metric(m model.Metric) model.Metric {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	ret := make(model.Metric, len(m))
+	for k, v := range m {
+		ret[model.LabelName(i.intern(string(k)))] = model.LabelValue(i.intern(string(v)))
+	}
+	return ret
+}
+
+// Value interns the metrics of the series of the value (in place), so the copies
+// of the strings it was decoded with can be freed
+func (i *LabelInterner) Value(v model.Value) {
+	switch value := v.(type) {
+	case model.Vector:
+		for _, sample := range value {
+			sample.Metric = i.Metric(sample.Metric)
+		}
+	case model.Matrix:
+		for _, stream := range value {
+			stream.Metric = i.Metric(stream.Metric)
+		}
+	}
+}
+
+// Len returns the number of interned strings
+func (i *LabelInterner) Len() int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return len(i.strings)
+}
+
+// Release drops the strings of the interner, so their memory is freed as soon as
+// the series using them are. The interned strings stay valid, Intern returns its
+// argument afterwards.
+func (i *LabelInterner) Release() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.strings = nil
+}
@@ -0,0 +1,88 @@
+package promutil
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestLabelInterner(t *testing.T) {
+	i := NewLabelInterner()
+	// The values are built at runtime, so they don't share storage to begin with
+	value := func(s string) model.LabelValue {
+		return model.LabelValue(strings.Repeat(s, 2))
+	}
+	matrix := model.Matrix{
+		{Metric: model.Metric{"namespace": value("prod"), "pod": value("a")}},
+		{Metric: model.Metric{"namespace": value("prod"), "pod": value("b")}},
+		{Metric: model.Metric{"namespace": value("dev"), "pod": value("a")}},
+	}
+	i.Value(matrix)
+
+	// namespace, pod, prodprod, devdev, aa, bb
+	if l := i.Len(); l != 6 {
+		t.Fatalf("mismatch in interned strings expected=%d actual=%d", 6, l)
+	}
+	expected := model.Metric{"namespace": "prodprod", "pod": "bb"}
+	if !matrix[1].Metric.Equal(expected) {
+		t.Fatalf("mismatch in metric expected=%v actual=%v", expected, matrix[1].Metric)
+	}
+
+	i.Release()
+	if l := i.Len(); l != 0 {
+		t.Fatalf("mismatch in interned strings after release expected=%d actual=%d", 0, l)
+	}
+	if s := i.Intern("x"); s != "x" {
+		t.Fatalf("mismatch in intern after release expected=%s actual=%s", "x", s)
+	}
+}
+
+// benchmarkMatrix returns a matrix of n series whose labels repeat, as in the
+// results of a large fanout
+func benchmarkMatrix(n int) model.Matrix {
+	matrix := make(model.Matrix, n)
+	for j := range matrix {
+		matrix[j] = &model.SampleStream{Metric: model.Metric{
+			model.MetricNameLabel: model.LabelValue("container_memory_working_set_bytes"),
+			"namespace":           model.LabelValue("namespace-" + strconv.Itoa(j%50)),
+			"pod":                 model.LabelValue("deployment-7f9c8d6b5-" + strconv.Itoa(j%5000)),
+			"container":           model.LabelValue("container-" + strconv.Itoa(j%10)),
+			"image":               model.LabelValue("registry.example.com/team/service:v1.2." + strconv.Itoa(j%20)),
+		}}
+	}
+	return matrix
+}
+
+// BenchmarkLabelInterner interns a 100k series result. The heap retained by the
+// result, with and without interning, is logged.
+func BenchmarkLabelInterner(b *testing.B) {
+	retained := func(intern bool) uint64 {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		matrix := benchmarkMatrix(100000)
+		if intern {
+			i := NewLabelInterner()
+			i.Value(matrix)
+			i.Release()
+		}
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		runtime.KeepAlive(matrix)
+		return after.HeapAlloc - before.HeapAlloc
+	}
+	b.Logf("retained heap of 100k series: %d bytes, %d bytes interned", retained(false), retained(true))
+
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		b.StopTimer()
+		matrix := benchmarkMatrix(100000)
+		b.StartTimer()
+		i := NewLabelInterner()
+		i.Value(matrix)
+		i.Release()
+	}
+}
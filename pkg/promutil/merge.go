@@ -2,6 +2,7 @@ package promutil
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 
 	"github.com/pkg/errors"
@@ -65,9 +66,30 @@ func ValueAddLabelSet(a model.Value, l model.LabelSet) error {
 	return nil
 }
 
+// MetricType is the type of a metric, as reported by the metadata API
+type MetricType string
+
+// The metric types which change how series are merged
+const (
+	MetricTypeUnknown MetricType = "unknown"
+	MetricTypeCounter MetricType = "counter"
+	MetricTypeGauge   MetricType = "gauge"
+)
+
+// MetricTypeFunc returns the type of the named metric (MetricTypeUnknown if it
+// isn't known)
+type MetricTypeFunc func(name string) MetricType
+
 // MergeValues merges values `a` and `b` with the given antiAffinityBuffer
 // TODO: always make copies? Now we sometimes return one, or make a copy, or do nothing
 func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value, error) {
+	return MergeValuesTyped(antiAffinityBuffer, a, b, nil)
+}
+
+// MergeValuesTyped is MergeValues, merging the series of counters (as returned by
+// types) with MergeCounterSampleStream. The series of other (or unknown) types are
+// merged as usual.
+func MergeValuesTyped(antiAffinityBuffer model.Time, a, b model.Value, types MetricTypeFunc) (model.Value, error) {
 	if a == nil {
 		return b, nil
 	}
@@ -108,7 +130,7 @@ func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value,
 		fingerPrintMap := make(map[model.Fingerprint]int)
 
 		addItem := func(item *model.Sample) {
-			finger := item.Metric.Fingerprint()
+			finger := NormalizedFingerprint(item.Metric)
 
 			// If we've seen this fingerPrint before, lets make sure that a value exists
 			if index, ok := fingerPrintMap[finger]; ok {
@@ -138,12 +160,16 @@ func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value,
 		fingerPrintMap := make(map[model.Fingerprint]int)
 
 		addStream := func(stream *model.SampleStream) {
-			finger := stream.Metric.Fingerprint()
+			finger := NormalizedFingerprint(stream.Metric)
 
 			// If we've seen this fingerPrint before, lets make sure that a value exists
 			if index, ok := fingerPrintMap[finger]; ok {
 				// TODO: check this error? For now the only one is sig collision, which we check
-				newValue[index], _ = MergeSampleStream(antiAffinityBuffer, newValue[index], stream)
+				if types != nil && types(string(stream.Metric[model.MetricNameLabel])) == MetricTypeCounter {
+					newValue[index], _ = MergeCounterSampleStream(antiAffinityBuffer, newValue[index], stream)
+				} else {
+					newValue[index], _ = MergeSampleStream(antiAffinityBuffer, newValue[index], stream)
+				}
 			} else {
 				newValue = append(newValue, stream)
 				fingerPrintMap[finger] = len(newValue) - 1
@@ -163,6 +189,31 @@ func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value,
 	return nil, fmt.Errorf("Unknown type! %v", reflect.TypeOf(a))
 }
 
+// ConcatValues appends the series of `b` to those of `a` without checking for
+// series which exist in both. This is only correct if `a` and `b` are known to
+// have disjoint series
+func ConcatValues(a, b model.Value) (model.Value, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	if a.Type() != b.Type() {
+		return nil, fmt.Errorf("mismatched value types %s and %s", a.Type(), b.Type())
+	}
+
+	switch aTyped := a.(type) {
+	case model.Vector:
+		return append(aTyped, b.(model.Vector)...), nil
+	case model.Matrix:
+		return append(aTyped, b.(model.Matrix)...), nil
+	}
+
+	// Scalars and strings are a single value, so there is nothing to concat
+	return MergeValues(0, a, b)
+}
+
 // MergeSampleStream merges SampleStreams `a` and `b` with the given antiAffinityBuffer
 // When combining series from 2 different prometheus hosts we can run into some problems
 // with clock skew (from a variety of sources). The primary one I've run into is issues
@@ -173,7 +224,7 @@ func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value,
 // we have. This means we can tolerate antiAffinityBuffer/2 on either side (which can be used by either
 // clock skew or from this scrape skew).
 func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream) (*model.SampleStream, error) {
-	if a.Metric.Fingerprint() != b.Metric.Fingerprint() {
+	if NormalizedFingerprint(a.Metric) != NormalizedFingerprint(b.Metric) {
 		return nil, fmt.Errorf("Cannot merge mismatch fingerprints")
 	}
 
@@ -235,3 +286,48 @@ func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream)
 		Values: newValues,
 	}, nil
 }
+
+// MergeCounterSampleStream is MergeSampleStream for the series of a counter. The
+// counters of different hosts generally don't have the same value (e.g. as they
+// were reset at different times), so filling the gaps of `a` with the points of
+// `b` would add resets which didn't happen. Points of `b` are only kept if they
+// don't decrease from the point before them, or exceed the next point of `a`.
+// NaN points (e.g. staleness markers) are kept, but never compared with.
+func MergeCounterSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream) (*model.SampleStream, error) {
+	merged, err := MergeSampleStream(antiAffinityBuffer, a, b)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make([]model.SamplePair, 0, len(merged.Values))
+	last := math.NaN() // the last kept value which isn't NaN
+	nextA := 0         // index of the next point of `a`
+	for _, v := range merged.Values {
+		if nextA < len(a.Values) && sameSamplePair(a.Values[nextA], v) {
+			values = append(values, v)
+			if !math.IsNaN(float64(v.Value)) {
+				last = float64(v.Value)
+			}
+			nextA++
+			continue
+		}
+		if !math.IsNaN(float64(v.Value)) {
+			if float64(v.Value) < last {
+				continue
+			}
+			if nextA < len(a.Values) && v.Value > a.Values[nextA].Value {
+				continue
+			}
+			last = float64(v.Value)
+		}
+		values = append(values, v)
+	}
+	merged.Values = values
+	return merged, nil
+}
+
+// sameSamplePair returns whether the points are the same, unlike == this holds for
+// NaN points
+func sameSamplePair(a, b model.SamplePair) bool {
+	return a.Timestamp == b.Timestamp && math.Float64bits(float64(a.Value)) == math.Float64bits(float64(b.Value))
+}
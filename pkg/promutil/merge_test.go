@@ -1,6 +1,7 @@
 package promutil
 
 import (
+	"math"
 	"reflect"
 	"testing"
 
@@ -522,3 +523,97 @@ func TestMergeValues(t *testing.T) {
 	}
 
 }
+
+func TestMergeValuesTyped(t *testing.T) {
+	types := func(name string) MetricType {
+		if name == "requests_total" {
+			return MetricTypeCounter
+		}
+		return MetricTypeUnknown
+	}
+	points := func(values ...int) []model.SamplePair {
+		pairs := make([]model.SamplePair, 0, len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			pairs = append(pairs, model.SamplePair{Timestamp: model.Time(values[i]), Value: model.SampleValue(values[i+1])})
+		}
+		return pairs
+	}
+
+	tests := []struct {
+		name string
+		// a has a gap which is filled from b
+		a model.SampleStream
+		b model.SampleStream
+		r []model.SamplePair
+	}{
+		// The counter of b is ahead of a, so its points would add a reset after the gap
+		{
+			name: "counter ahead",
+			a:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(0, 10, 60, 20, 240, 50, 300, 60)},
+			b:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(120, 1005, 180, 1010)},
+			r:    points(0, 10, 60, 20, 240, 50, 300, 60),
+		},
+		// The counter of b was reset, so its points would add a reset before the gap
+		{
+			name: "counter behind",
+			a:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(0, 10, 60, 20, 240, 50, 300, 60)},
+			b:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(120, 2, 180, 3)},
+			r:    points(0, 10, 60, 20, 240, 50, 300, 60),
+		},
+		// Points of b which fit the counter of a fill the gap
+		{
+			name: "counter consistent",
+			a:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(0, 10, 60, 20, 240, 50, 300, 60)},
+			b:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "requests_total"}, Values: points(120, 30, 180, 40)},
+			r:    points(0, 10, 60, 20, 120, 30, 180, 40, 240, 50, 300, 60),
+		},
+		// Metrics of unknown type are merged as usual
+		{
+			name: "unknown",
+			a:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "temperature"}, Values: points(0, 10, 60, 20, 240, 50, 300, 60)},
+			b:    model.SampleStream{Metric: model.Metric{model.MetricNameLabel: "temperature"}, Values: points(120, 1005, 180, 1010)},
+			r:    points(0, 10, 60, 20, 120, 1005, 180, 1010, 240, 50, 300, 60),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a, b := test.a, test.b
+			result, err := MergeValuesTyped(model.Time(10), model.Matrix{&a}, model.Matrix{&b}, types)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			matrix := result.(model.Matrix)
+			if len(matrix) != 1 || !reflect.DeepEqual(matrix[0].Values, test.r) {
+				t.Fatalf("mismatch in merged values expected=%v actual=%v", test.r, matrix)
+			}
+		})
+	}
+}
+
+func TestMergeCounterSampleStreamNaN(t *testing.T) {
+	points := func(values ...float64) []model.SamplePair {
+		pairs := make([]model.SamplePair, 0, len(values)/2)
+		for i := 0; i < len(values); i += 2 {
+			pairs = append(pairs, model.SamplePair{Timestamp: model.Time(values[i]), Value: model.SampleValue(values[i+1])})
+		}
+		return pairs
+	}
+	a := &model.SampleStream{Values: points(0, 10, 60, math.NaN(), 240, 50, 300, 60)}
+	// b is ahead of a, it must still be compared with the point of a after the NaN
+	b := &model.SampleStream{Values: points(120, 1005, 180, 1010)}
+	expected := points(0, 10, 60, math.NaN(), 240, 50, 300, 60)
+
+	merged, err := MergeCounterSampleStream(model.Time(10), a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(merged.Values) != len(expected) {
+		t.Fatalf("mismatch in merged values expected=%v actual=%v", expected, merged.Values)
+	}
+	for i, v := range merged.Values {
+		if !sameSamplePair(v, expected[i]) {
+			t.Fatalf("mismatch in merged values expected=%v actual=%v", expected, merged.Values)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package promutil
+
+import (
+	"github.com/prometheus/common/model"
+	"golang.org/x/text/unicode/norm"
+)
+
+// NormalizedFingerprint returns the fingerprint identifying the series of the metric
+// when merging the results of different backends. Backends may encode the same
+// series differently, so the fingerprint is that of the canonical form of the
+// metric: labels with an empty value are dropped (Prometheus treats them as
+// absent) and the values are NFC normalized. The order of the labels doesn't matter
+// as the fingerprint is computed over the sorted label names.
+//
+// The metric itself isn't modified, so the results keep the labels as returned.
+func NormalizedFingerprint(m model.Metric) model.Fingerprint {
+	for _, v := range m {
+		if v == "" || !norm.NFC.IsNormalString(string(v)) {
+			return normalizeMetric(m).Fingerprint()
+		}
+	}
+	// Most metrics are canonical already, so those aren't copied
+	return m.Fingerprint()
+}
+
+// normalizeMetric returns the canonical copy of the metric
+func normalizeMetric(m model.Metric) model.Metric {
+	normalized := make(model.Metric, len(m))
+	for k, v := range m {
+		if v == "" {
+			continue
+		}
+		normalized[k] = model.LabelValue(norm.NFC.String(string(v)))
+	}
+	return normalized
+}
@@ -0,0 +1,54 @@
+package promutil
+
+import (
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestNormalizedFingerprint(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     model.Metric
+		b     model.Metric
+		equal bool
+	}{
+		{
+			name:  "identical",
+			a:     model.Metric{"__name__": "up", "job": "a"},
+			b:     model.Metric{"job": "a", "__name__": "up"},
+			equal: true,
+		},
+		{
+			name:  "empty label",
+			a:     model.Metric{"__name__": "up", "job": "a", "shard": ""},
+			b:     model.Metric{"__name__": "up", "job": "a"},
+			equal: true,
+		},
+		{
+			name:  "unicode normalization",
+			a:     model.Metric{"__name__": "up", "city": "caf\u00e9"},
+			b:     model.Metric{"__name__": "up", "city": "cafe\u0301"},
+			equal: true,
+		},
+		{
+			name:  "different values",
+			a:     model.Metric{"__name__": "up", "job": "a"},
+			b:     model.Metric{"__name__": "up", "job": "b"},
+			equal: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			before := test.b.Clone()
+			if equal := NormalizedFingerprint(test.a) == NormalizedFingerprint(test.b); equal != test.equal {
+				t.Fatalf("mismatch in equal expected=%v actual=%v", test.equal, equal)
+			}
+			// The metric itself is left as is
+			if !before.Equal(test.b) {
+				t.Fatalf("mismatch in metric expected=%v actual=%v", before, test.b)
+			}
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package promutil
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// SortLabelNames sorts the label names in place, returning them
+func SortLabelNames(names []string) []string {
+	sort.Strings(names)
+	return names
+}
+
+// SortLabelSets sorts the label sets in place by their string representation
+// (which has the labels sorted by name), returning them
+func SortLabelSets(sets []model.LabelSet) []model.LabelSet {
+	sort.Sort(labelSetsByString{sets, labelSetStrings(sets)})
+	return sets
+}
+
+func labelSetStrings(sets []model.LabelSet) []string {
+	strs := make([]string, len(sets))
+	for i, set := range sets {
+		strs[i] = set.String()
+	}
+	return strs
+}
+
+// labelSetsByString sorts label sets by their string representation, which is
+// computed once per set
+type labelSetsByString struct {
+	sets []model.LabelSet
+	strs []string
+}
+
+func (l labelSetsByString) Len() int           { return len(l.sets) }
+func (l labelSetsByString) Less(i, j int) bool { return l.strs[i] < l.strs[j] }
+func (l labelSetsByString) Swap(i, j int) {
+	l.sets[i], l.sets[j] = l.sets[j], l.sets[i]
+	l.strs[i], l.strs[j] = l.strs[j], l.strs[i]
+}
@@ -0,0 +1,90 @@
+package promutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestSortLabelNames(t *testing.T) {
+	tests := []struct {
+		names    []string
+		expected []string
+	}{
+		{names: nil, expected: nil},
+		{names: []string{"job"}, expected: []string{"job"}},
+		{names: []string{"job", "__name__", "instance"}, expected: []string{"__name__", "instance", "job"}},
+		{names: []string{"b", "B", "a", "_a"}, expected: []string{"B", "_a", "a", "b"}},
+		{names: []string{"zürich", "zurich", "ärger", "arg"}, expected: []string{"arg", "zurich", "zürich", "ärger"}},
+	}
+
+	for i, test := range tests {
+		sorted := SortLabelNames(append([]string(nil), test.names...))
+		if !reflect.DeepEqual(sorted, test.expected) {
+			t.Fatalf("%d: mismatch in names expected=%v actual=%v", i, test.expected, sorted)
+		}
+		if again := SortLabelNames(append([]string(nil), sorted...)); !reflect.DeepEqual(again, sorted) {
+			t.Fatalf("%d: mismatch in names sorted again expected=%v actual=%v", i, sorted, again)
+		}
+	}
+}
+
+func TestSortLabelSets(t *testing.T) {
+	tests := []struct {
+		sets     []model.LabelSet
+		expected []model.LabelSet
+	}{
+		{sets: nil, expected: nil},
+		{
+			sets: []model.LabelSet{
+				{"__name__": "up", "job": "b"},
+				{"__name__": "up", "job": "a"},
+				{"__name__": "node_cpu"},
+			},
+			expected: []model.LabelSet{
+				{"__name__": "node_cpu"},
+				{"__name__": "up", "job": "a"},
+				{"__name__": "up", "job": "b"},
+			},
+		},
+		{
+			// The labels of a set are compared in the order of their names
+			sets: []model.LabelSet{
+				{"job": "a", "instance": "2"},
+				{"job": "b", "instance": "1"},
+				{"job": "a"},
+			},
+			expected: []model.LabelSet{
+				{"job": "b", "instance": "1"},
+				{"job": "a", "instance": "2"},
+				{"job": "a"},
+			},
+		},
+		{
+			sets: []model.LabelSet{
+				{"städt": "zürich"},
+				{"city": "zürich"},
+				{"city": "zurich"},
+				{"city": "ärhus"},
+			},
+			expected: []model.LabelSet{
+				{"city": "zurich"},
+				{"city": "zürich"},
+				{"city": "ärhus"},
+				{"städt": "zürich"},
+			},
+		},
+	}
+
+	for i, test := range tests {
+		sets := append([]model.LabelSet(nil), test.sets...)
+		sorted := SortLabelSets(sets)
+		if !reflect.DeepEqual(sorted, test.expected) {
+			t.Fatalf("%d: mismatch in label sets expected=%v actual=%v", i, test.expected, sorted)
+		}
+		if again := SortLabelSets(append([]model.LabelSet(nil), sorted...)); !reflect.DeepEqual(again, sorted) {
+			t.Fatalf("%d: mismatch in label sets sorted again expected=%v actual=%v", i, sorted, again)
+		}
+	}
+}
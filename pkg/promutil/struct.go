@@ -18,4 +18,12 @@ const (
 	ErrorExec               = "execution"
 	ErrorBadData            = "bad_data"
 	ErrorInternal           = "internal"
+	ErrorProxy              = "proxy_error"
+	ErrorUnauthorized       = "unauthorized"
+	ErrorForbidden          = "forbidden"
+	ErrorNotFound           = "not_found"
+	ErrorThrottled          = "throttled"
+	ErrorUnavailable        = "unavailable"
+	ErrorNotAllowed         = "not_allowed"
+	ErrorTooLarge           = "too_large"
 )
\ No newline at end of file
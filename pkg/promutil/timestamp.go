@@ -0,0 +1,58 @@
+package promutil
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+var (
+	// MinTime and MaxTime are the bounds the prometheus API uses when a caller
+	// doesn't specify a time range
+	MinTime = time.Unix(math.MinInt64/1000+62135596801, 0).UTC()
+	MaxTime = time.Unix(math.MaxInt64/1000-62135596801, 999999999).UTC()
+
+	// The formatted bounds can't be parsed by time.Parse (the years are out of
+	// range) so they are matched as-is
+	minTimeFormatted = MinTime.Format(time.RFC3339Nano)
+	maxTimeFormatted = MaxTime.Format(time.RFC3339Nano)
+)
+
+// nowTimestamp is the token which is parsed as the current time
+const nowTimestamp = "now"
+
+// ParseTimestamp parses a timestamp the same way the prometheus HTTP API does,
+// accepting unix seconds (as a float), RFC3339 and "now"
+func ParseTimestamp(s string) (time.Time, error) {
+	if s == nowTimestamp {
+		return time.Now(), nil
+	}
+	if t, err := strconv.ParseFloat(s, 64); err == nil {
+		s, ns := math.Modf(t)
+		ns = math.Round(ns*1000) / 1000
+		return time.Unix(int64(s), int64(ns*float64(time.Second))), nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+
+	switch s {
+	case minTimeFormatted:
+		return MinTime, nil
+	case maxTimeFormatted:
+		return MaxTime, nil
+	}
+	return time.Time{}, fmt.Errorf("cannot parse %q to a valid timestamp", s)
+}
+
+// FormatTimestamp formats the time as unix seconds with millisecond precision,
+// the inverse of ParseTimestamp
+func FormatTimestamp(t time.Time) string {
+	secs, ms := t.Unix(), int64(t.Nanosecond()/int(time.Millisecond))
+	if secs < 0 && ms > 0 {
+		// e.g. -2s + 500ms is formatted as -1.500
+		return fmt.Sprintf("-%d.%03d", -secs-1, 1000-ms)
+	}
+	return fmt.Sprintf("%d.%03d", secs, ms)
+}
@@ -0,0 +1,92 @@
+package promutil
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		input  string
+		result time.Time
+		err    bool
+	}{
+		// Unix seconds
+		{input: "0", result: time.Unix(0, 0)},
+		{input: "1565000000", result: time.Unix(1565000000, 0)},
+		{input: "1565000000.123", result: time.Unix(1565000000, 123*int64(time.Millisecond))},
+		// Sub-millisecond precision is rounded
+		{input: "1565000000.1234", result: time.Unix(1565000000, 123*int64(time.Millisecond))},
+		// Negative unix times
+		{input: "-1", result: time.Unix(-1, 0)},
+		{input: "-1.5", result: time.Unix(-2, 500*int64(time.Millisecond))},
+		// RFC3339
+		{input: "2019-08-05T10:13:20Z", result: time.Unix(1565000000, 0)},
+		{input: "2019-08-05T10:13:20.123Z", result: time.Unix(1565000000, 123*int64(time.Millisecond))},
+		{input: "2019-08-05T12:13:20+02:00", result: time.Unix(1565000000, 0)},
+		// Boundaries
+		{input: MinTime.Format(time.RFC3339Nano), result: MinTime},
+		{input: MaxTime.Format(time.RFC3339Nano), result: MaxTime},
+		// Invalid
+		{input: "", err: true},
+		{input: "abc", err: true},
+		{input: "2019-08-05", err: true},
+		{input: "Now", err: true},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result, err := ParseTimestamp(test.input)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if err == nil && !result.Equal(test.result) {
+				t.Fatalf("mismatch in result expected=%v actual=%v", test.result, result)
+			}
+		})
+	}
+}
+
+func TestParseTimestampNow(t *testing.T) {
+	before := time.Now()
+	result, err := ParseTimestamp("now")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Before(before) || result.After(time.Now()) {
+		t.Fatalf("now not parsed as the current time: %v", result)
+	}
+}
+
+func TestFormatTimestamp(t *testing.T) {
+	tests := []struct {
+		input  time.Time
+		result string
+	}{
+		{input: time.Unix(0, 0), result: "0.000"},
+		{input: time.Unix(1565000000, 0), result: "1565000000.000"},
+		{input: time.Unix(1565000000, 123456789), result: "1565000000.123"},
+		{input: time.Unix(-1, 0), result: "-1.000"},
+		{input: time.Unix(-2, 500*int64(time.Millisecond)), result: "-1.500"},
+		{input: time.Unix(-1, 250*int64(time.Millisecond)), result: "-0.750"},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			result := FormatTimestamp(test.input)
+			if result != test.result {
+				t.Fatalf("mismatch in result expected=%s actual=%s", test.result, result)
+			}
+
+			// Formatted timestamps must round trip
+			parsed, err := ParseTimestamp(result)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !parsed.Equal(test.input.Truncate(time.Millisecond)) {
+				t.Fatalf("mismatch in round trip expected=%v actual=%v", test.input.Truncate(time.Millisecond), parsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+package promutil
+
+import (
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// UnionLabelNames returns the sorted union of the label names, without duplicates.
+// Neither of the inputs is modified.
+func UnionLabelNames(a, b []string) []string {
+	names := make([]string, 0, len(a)+len(b))
+	names = append(append(names, a...), b...)
+	sort.Strings(names)
+
+	union := names[:0]
+	for i, name := range names {
+		if i == 0 || name != names[i-1] {
+			union = append(union, name)
+		}
+	}
+	return union
+}
+
+// UnionLabelSets returns the union of the label sets, sorted as by SortLabelSets.
+// Label sets are duplicates if they have the same NormalizedFingerprint, of which
+// the first in the sort order is kept, so the union doesn't depend on the order of
+// the inputs. Neither of the inputs is modified.
+func UnionLabelSets(a, b []model.LabelSet) []model.LabelSet {
+	sets := make([]model.LabelSet, 0, len(a)+len(b))
+	sets = SortLabelSets(append(append(sets, a...), b...))
+
+	added := make(map[model.Fingerprint]struct{}, len(sets))
+	union := sets[:0]
+	for _, set := range sets {
+		fp := NormalizedFingerprint(model.Metric(set))
+		if _, ok := added[fp]; ok {
+			continue
+		}
+		added[fp] = struct{}{}
+		union = append(union, set)
+	}
+	return union
+}
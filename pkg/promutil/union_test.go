@@ -0,0 +1,101 @@
+package promutil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"pgregory.net/rapid"
+)
+
+// The names and values are drawn from small sets, so the inputs overlap. The
+// values include the empty one and both normal forms of é, whose label sets are
+// duplicates of others.
+var (
+	unionNames  = rapid.SampledFrom([]string{"__name__", "a", "b", "instance", "job"})
+	unionValues = rapid.SampledFrom([]string{"", "1", "2", "\u00e9", "e\u0301"})
+)
+
+func labelNamesGen() *rapid.Generator[[]string] {
+	return rapid.SliceOf(unionNames)
+}
+
+func labelSetsGen() *rapid.Generator[[]model.LabelSet] {
+	return rapid.SliceOf(rapid.Custom(func(t *rapid.T) model.LabelSet {
+		set := make(model.LabelSet)
+		for _, name := range rapid.SliceOfDistinct(unionNames, rapid.ID[string]).Draw(t, "names") {
+			set[model.LabelName(name)] = model.LabelValue(unionValues.Draw(t, "value"))
+		}
+		return set
+	}))
+}
+
+func TestUnionLabelNames(t *testing.T) {
+	t.Run("commutative", rapid.MakeCheck(func(t *rapid.T) {
+		a, b := labelNamesGen().Draw(t, "a"), labelNamesGen().Draw(t, "b")
+		if ab, ba := UnionLabelNames(a, b), UnionLabelNames(b, a); !reflect.DeepEqual(ab, ba) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", ab, ba)
+		}
+	}))
+	t.Run("idempotent", rapid.MakeCheck(func(t *rapid.T) {
+		a := UnionLabelNames(labelNamesGen().Draw(t, "a"), nil)
+		if aa := UnionLabelNames(a, a); !reflect.DeepEqual(aa, a) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", a, aa)
+		}
+	}))
+	t.Run("associative", rapid.MakeCheck(func(t *rapid.T) {
+		a, b, c := labelNamesGen().Draw(t, "a"), labelNamesGen().Draw(t, "b"), labelNamesGen().Draw(t, "c")
+		left := UnionLabelNames(UnionLabelNames(a, b), c)
+		right := UnionLabelNames(a, UnionLabelNames(b, c))
+		if !reflect.DeepEqual(left, right) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", left, right)
+		}
+	}))
+	t.Run("complete", rapid.MakeCheck(func(t *rapid.T) {
+		a, b := labelNamesGen().Draw(t, "a"), labelNamesGen().Draw(t, "b")
+		union := make(map[string]int)
+		for _, name := range UnionLabelNames(a, b) {
+			union[name]++
+		}
+		for _, name := range append(append([]string(nil), a...), b...) {
+			if union[name] != 1 {
+				t.Fatalf("mismatch in count of %s expected=1 actual=%d", name, union[name])
+			}
+		}
+	}))
+}
+
+func TestUnionLabelSets(t *testing.T) {
+	t.Run("commutative", rapid.MakeCheck(func(t *rapid.T) {
+		a, b := labelSetsGen().Draw(t, "a"), labelSetsGen().Draw(t, "b")
+		if ab, ba := UnionLabelSets(a, b), UnionLabelSets(b, a); !reflect.DeepEqual(ab, ba) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", ab, ba)
+		}
+	}))
+	t.Run("idempotent", rapid.MakeCheck(func(t *rapid.T) {
+		a := UnionLabelSets(labelSetsGen().Draw(t, "a"), nil)
+		if aa := UnionLabelSets(a, a); !reflect.DeepEqual(aa, a) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", a, aa)
+		}
+	}))
+	t.Run("associative", rapid.MakeCheck(func(t *rapid.T) {
+		a, b, c := labelSetsGen().Draw(t, "a"), labelSetsGen().Draw(t, "b"), labelSetsGen().Draw(t, "c")
+		left := UnionLabelSets(UnionLabelSets(a, b), c)
+		right := UnionLabelSets(a, UnionLabelSets(b, c))
+		if !reflect.DeepEqual(left, right) {
+			t.Fatalf("mismatch in union expected=%v actual=%v", left, right)
+		}
+	}))
+	t.Run("complete", rapid.MakeCheck(func(t *rapid.T) {
+		a, b := labelSetsGen().Draw(t, "a"), labelSetsGen().Draw(t, "b")
+		union := make(map[model.Fingerprint]int)
+		for _, set := range UnionLabelSets(a, b) {
+			union[NormalizedFingerprint(model.Metric(set))]++
+		}
+		for _, set := range append(append([]model.LabelSet(nil), a...), b...) {
+			if fp := NormalizedFingerprint(model.Metric(set)); union[fp] != 1 {
+				t.Fatalf("mismatch in count of %v expected=1 actual=%d", set, union[fp])
+			}
+		}
+	}))
+}
@@ -0,0 +1,45 @@
+package proxyquerier
+
+import (
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/sirupsen/logrus"
+)
+
+// SelectHints mirrors the storage.SelectHints which newer versions of the
+// Prometheus storage API pass to Select (in place of the SelectParams), with the
+// optimization context of the Select. The times are in milliseconds.
+type SelectHints struct {
+	Start int64
+	End   int64
+
+	Step     int64
+	Func     string
+	Grouping []string
+	By       bool
+	Range    int64
+}
+
+// SelectHintsToGetValue returns the time range of the GetValue call for a Select.
+// The hints (if any) are preferred over the params, a disagreement between them is
+// logged.
+func SelectHintsToGetValue(params *storage.SelectParams, hints *SelectHints) (time.Time, time.Time) {
+	switch {
+	case hints == nil && params == nil:
+		return time.Time{}, time.Time{}
+	case hints == nil:
+		return timestamp.Time(params.Start).UTC(), timestamp.Time(params.End).UTC()
+	}
+
+	if params != nil && (params.Start != hints.Start || params.End != hints.End) {
+		logger.WithFields(logrus.Fields{
+			"paramsStart": params.Start,
+			"paramsEnd":   params.End,
+			"hintsStart":  hints.Start,
+			"hintsEnd":    hints.End,
+		}).Debug("Select hints disagree with the select params, using the hints")
+	}
+	return timestamp.Time(hints.Start).UTC(), timestamp.Time(hints.End).UTC()
+}
@@ -0,0 +1,57 @@
+package proxyquerier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/storage"
+)
+
+func TestSelectHintsToGetValue(t *testing.T) {
+	ms := func(v int64) time.Time {
+		return time.Unix(0, v*int64(time.Millisecond)).UTC()
+	}
+
+	tests := []struct {
+		params *storage.SelectParams
+		hints  *SelectHints
+		start  time.Time
+		end    time.Time
+	}{
+		// Without hints the params are used
+		{
+			params: &storage.SelectParams{Start: 1000, End: 2000},
+			start:  ms(1000),
+			end:    ms(2000),
+		},
+		// Hints which agree with the params
+		{
+			params: &storage.SelectParams{Start: 1000, End: 2000},
+			hints:  &SelectHints{Start: 1000, End: 2000, Step: 100, Func: "rate"},
+			start:  ms(1000),
+			end:    ms(2000),
+		},
+		// The hints are preferred over the params
+		{
+			params: &storage.SelectParams{Start: 1000, End: 2000},
+			hints:  &SelectHints{Start: 1500, End: 1800},
+			start:  ms(1500),
+			end:    ms(1800),
+		},
+		// Hints without params
+		{
+			hints: &SelectHints{Start: 1500, End: 1800},
+			start: ms(1500),
+			end:   ms(1800),
+		},
+		// Neither
+		{},
+	}
+
+	for i, test := range tests {
+		start, end := SelectHintsToGetValue(test.params, test.hints)
+		if !start.Equal(test.start) || !end.Equal(test.end) {
+			t.Fatalf("%d: mismatch in range expected=%v-%v actual=%v-%v", i, test.start, test.end, start, end)
+		}
+	}
+}
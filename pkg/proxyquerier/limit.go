@@ -0,0 +1,159 @@
+package proxyquerier
+
+import (
+	"context"
+	"sync"
+)
+
+type selectLimiterKey struct{}
+
+// WithSelectLimiter returns a context which limits Selects with the given limiter
+func WithSelectLimiter(ctx context.Context, l *SelectLimiter) context.Context {
+	return context.WithValue(ctx, selectLimiterKey{}, l)
+}
+
+// SelectLimiterFromContext returns the SelectLimiter of the context (if there is one)
+func SelectLimiterFromContext(ctx context.Context) *SelectLimiter {
+	l, _ := ctx.Value(selectLimiterKey{}).(*SelectLimiter)
+	return l
+}
+
+// SelectStats are the stats of Selects made within a single query
+type SelectStats struct {
+	// Total is the number of Selects made
+	Total int
+	// MaxConcurrent is the largest number of Selects that were in flight at once
+	MaxConcurrent int
+}
+
+// SelectLimiter limits the number of concurrent Selects within a single query.
+// A single PromQL expression with many selectors (such as a large `or` chain)
+// results in a Select per selector, each of which fans out to all downstreams.
+//
+// A slot is held for the duration of a single Select call, and Select never calls
+// back into the engine. Since the engine populates the series of all selectors
+// (including those within subqueries) before evaluation, a nested evaluation
+// never waits on a slot while holding one -- so any limit >= 1 cannot deadlock.
+//
+// Streamed Selects (see StreamingProxyQuerier and NewStreamSeriesSet) hold their
+// slot until the stream is consumed, which only happens once the query is evaluated. So rather than
+// waiting on those, a Select finding all the slots taken buffers the oldest stream
+// into memory, freeing its slot.
+type SelectLimiter struct {
+	sem chan struct{}
+	// streamed is signaled when a slot is handed over to a stream
+	streamed chan struct{}
+
+	l       sync.Mutex
+	current int
+	stats   SelectStats
+	streams []streamBuffer
+}
+
+// streamBuffer is a stream holding a slot of a SelectLimiter
+type streamBuffer interface {
+	// buffer reads the rest of the stream into memory and releases its slot
+	buffer()
+}
+
+// NewSelectLimiter returns a SelectLimiter allowing `limit` concurrent Selects.
+// A limit <= 0 doesn't limit concurrency but still records stats
+func NewSelectLimiter(limit int) *SelectLimiter {
+	l := &SelectLimiter{}
+	if limit > 0 {
+		l.sem = make(chan struct{}, limit)
+		l.streamed = make(chan struct{}, 1)
+	}
+	return l
+}
+
+// Acquire blocks until a Select is allowed to run (or the context is done)
+func (l *SelectLimiter) Acquire(ctx context.Context) error {
+	for acquired := l.sem == nil; !acquired; {
+		select {
+		case l.sem <- struct{}{}:
+			acquired = true
+			continue
+		default:
+		}
+
+		if s := l.oldestStream(); s != nil {
+			s.buffer()
+			continue
+		}
+
+		select {
+		case l.sem <- struct{}{}:
+			acquired = true
+		case <-l.streamed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	l.l.Lock()
+	l.current++
+	l.stats.Total++
+	if l.current > l.stats.MaxConcurrent {
+		l.stats.MaxConcurrent = l.current
+	}
+	l.l.Unlock()
+	return nil
+}
+
+// Release frees the slot taken by Acquire
+func (l *SelectLimiter) Release() {
+	l.l.Lock()
+	l.current--
+	l.l.Unlock()
+
+	if l.sem != nil {
+		<-l.sem
+	}
+}
+
+// holdForStream hands the slot taken by Acquire over to a stream, which holds it
+// until it calls releaseStream
+func (l *SelectLimiter) holdForStream(s streamBuffer) {
+	l.l.Lock()
+	l.streams = append(l.streams, s)
+	l.l.Unlock()
+
+	if l.streamed != nil {
+		select {
+		case l.streamed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// releaseStream frees the slot held by a stream
+func (l *SelectLimiter) releaseStream(s streamBuffer) {
+	l.l.Lock()
+	for i, held := range l.streams {
+		if held == s {
+			l.streams = append(l.streams[:i], l.streams[i+1:]...)
+			break
+		}
+	}
+	l.l.Unlock()
+
+	l.Release()
+}
+
+// oldestStream returns the stream which has held its slot for the longest
+func (l *SelectLimiter) oldestStream() streamBuffer {
+	l.l.Lock()
+	defer l.l.Unlock()
+	if len(l.streams) == 0 {
+		return nil
+	}
+	return l.streams[0]
+}
+
+// Stats returns the stats of the Selects made through this limiter
+func (l *SelectLimiter) Stats() SelectStats {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.stats
+}
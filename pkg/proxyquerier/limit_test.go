@@ -0,0 +1,139 @@
+package proxyquerier
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// blockingAPI blocks all GetValue calls until unblock is closed, recording the
+// max number of concurrent calls
+type blockingAPI struct {
+	promclient.API
+	unblock chan struct{}
+
+	l           sync.Mutex
+	current     int
+	maxObserved int
+}
+
+func (b *blockingAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	b.l.Lock()
+	b.current++
+	if b.current > b.maxObserved {
+		b.maxObserved = b.current
+	}
+	b.l.Unlock()
+
+	<-b.unblock
+
+	b.l.Lock()
+	b.current--
+	b.l.Unlock()
+	return model.Matrix{}, nil, nil
+}
+
+func TestSelectLimiterConcurrency(t *testing.T) {
+	stub := &blockingAPI{unblock: make(chan struct{})}
+	limiter := NewSelectLimiter(2)
+	ctx, recorder := promclient.WithQueryStatsRecorder(context.Background())
+	q := &ProxyQuerier{
+		Ctx:    WithSelectLimiter(ctx, limiter),
+		Client: stub,
+	}
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000}); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+
+	// Give all the selects a chance to start before letting them finish
+	time.Sleep(50 * time.Millisecond)
+	close(stub.unblock)
+	wg.Wait()
+
+	if stub.maxObserved > 2 {
+		t.Fatalf("too many concurrent selects expected<=%d actual=%d", 2, stub.maxObserved)
+	}
+	stats := limiter.Stats()
+	if stats.Total != 10 {
+		t.Fatalf("mismatch in total selects expected=%d actual=%d", 10, stats.Total)
+	}
+	if stats.MaxConcurrent != 2 {
+		t.Fatalf("mismatch in max concurrent selects expected=%d actual=%d", 2, stats.MaxConcurrent)
+	}
+
+	// The stats are reported in the stats of the query once the querier is closed
+	if err := q.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := promclient.QueryStats{Selects: 10, MaxConcurrentSelects: 2}
+	if queryStats := recorder.Stats(); queryStats != expected {
+		t.Fatalf("mismatch in query stats expected=%v actual=%v", expected, queryStats)
+	}
+}
+
+// The engine populates the series for all selectors (including those in
+// subqueries) one after another before evaluating. Ensure that a limit of 1
+// doesn't deadlock that pattern
+func TestSelectLimiterSubquery(t *testing.T) {
+	stub := &blockingAPI{unblock: make(chan struct{})}
+	close(stub.unblock)
+	limiter := NewSelectLimiter(1)
+	q := &ProxyQuerier{
+		Ctx:    WithSelectLimiter(context.Background(), limiter),
+		Client: stub,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// e.g. `a or max_over_time(b[5m:1m])`
+		for _, params := range []*storage.SelectParams{
+			{Start: 0, End: 1000},
+			{Start: -300000, End: 1000, Step: 60000},
+		} {
+			if _, _, err := q.Select(params); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("selects deadlocked")
+	}
+
+	if stats := limiter.Stats(); stats.Total != 2 || stats.MaxConcurrent != 1 {
+		t.Fatalf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestSelectLimiterContextDone(t *testing.T) {
+	limiter := NewSelectLimiter(1)
+	if err := limiter.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer limiter.Release()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Acquire(ctx); err == nil {
+		t.Fatalf("expected error acquiring a full limiter")
+	}
+}
@@ -8,15 +8,18 @@ import (
 	"github.com/prometheus/client_golang/api"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
-	"github.com/prometheus/prometheus/pkg/timestamp"
 	"github.com/prometheus/prometheus/storage"
 	"github.com/sirupsen/logrus"
 
 	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/logging"
 	"github.com/promproxy/pkg/promclient"
 	"github.com/promproxy/pkg/promutil"
 )
 
+var logger = logging.Component(logging.ComponentProxyQuerier)
+
 // ProxyQuerier Implements prometheus' Querier interface
 type ProxyQuerier struct {
 	Ctx    context.Context
@@ -25,23 +28,47 @@ type ProxyQuerier struct {
 	Client promclient.API
 
 	Cfg *proxyconfig.PromxyConfig
+	// Shedder (if set) rejects Selects while promproxy is overloaded
+	Shedder *loadshed.Shedder
+	// Interner (if set) interns the labels of the series of the Selects, it is
+	// released when the querier is closed
+	Interner *promutil.LabelInterner
 }
 
 // Select returns a set of series that matches the given label matchers.
 func (h *ProxyQuerier) Select(selectParams *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
 	start := time.Now()
 	defer func() {
-		logrus.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"selectParams": selectParams,
 			"matchers":     matchers,
 			"took":         time.Now().Sub(start),
 		}).Debug("Select")
 	}()
 
+	rangeStart, rangeEnd, rangeWarnings, err := h.selectRange(selectParams, start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	l := SelectLimiterFromContext(h.Ctx)
+	if l != nil {
+		if err := l.Acquire(h.Ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+	// The slot is released once the Select returns, unless it was handed over to
+	// a stream outliving the Select
+	streaming := false
+	defer func() {
+		if l != nil && !streaming {
+			l.Release()
+		}
+	}()
+
 	var result model.Value
 	// TODO: get warnings from lower layers
 	var warnings storage.Warnings
-	var err error
 	// Select() is a combined API call for query/query_range/series.
 	// as of right now there is no great way of differentiating between a
 	// data call (query/query_range) and a metadata call (series). For now
@@ -50,12 +77,52 @@ func (h *ProxyQuerier) Select(selectParams *storage.SelectParams, matchers ...*l
 	if selectParams == nil {
 		matcherString, err := promutil.MatcherToString(matchers)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, &promutil.ValidationError{Method: "series", Err: err}
 		}
-		labelsets, w, err := h.Client.Series(h.Ctx, []string{matcherString}, h.Start, h.End)
-		warnings = promutil.WarningsConvert(w)
+
+		// Downstreams return at most one series past the max, which is enough to
+		// tell that the max was exceeded. With a fair budget the series are
+		// truncated to the max instead.
+		ctx := h.Ctx
+		maxSeries := h.maxSeries()
+		if maxSeries > 0 && h.Cfg.FairSeriesBudget {
+			ctx = promclient.WithSeriesLimit(ctx, maxSeries)
+			maxSeries = 0
+		} else if maxSeries > 0 {
+			ctx = promclient.WithSeriesLimit(ctx, maxSeries+1)
+		}
+
+		// If the client can stream the series we feed them to the SeriesSet as
+		// they arrive instead of loading them all into memory. Note that the
+		// warnings of a stream aren't known until it completes, so they are
+		// returned by the SeriesSet (see WarningsSeriesSet)
+		if _, ok := h.Client.(promclient.SeriesStreamer); ok {
+			streaming = true
+			return NewStreamSeriesSet(h.Ctx, maxSeries, l, func(fn promclient.SeriesFunc) (storage.Warnings, error) {
+				// The errors of fn are those of the SeriesSet, not of the upstreams
+				var fnErr error
+				w, err := promclient.StreamSeries(ctx, h.Client, []string{matcherString}, rangeStart, rangeEnd, h.internSeries(func(ls model.LabelSet) error {
+					if err := fn(ls); err != nil {
+						fnErr = err
+						return err
+					}
+					return nil
+				}))
+				warnings := promutil.WarningsConvert(w)
+				if fnErr != nil && errors.Cause(err) == fnErr {
+					return warnings, fnErr
+				}
+				return warnings, h.upstreamError("series", err)
+			}), rangeWarnings, nil
+		}
+
+		labelsets, w, err := h.Client.Series(ctx, []string{matcherString}, rangeStart, rangeEnd)
+		warnings = append(rangeWarnings, promutil.WarningsConvert(w)...)
 		if err != nil {
-			return nil, warnings, errors.Cause(err)
+			return nil, warnings, h.upstreamError("series", err)
+		}
+		if maxSeries > 0 && len(labelsets) > maxSeries {
+			return nil, warnings, ErrMaxSeries(maxSeries)
 		}
 		// Convert labelsets to vectors
 		// convert to vector (there aren't points, but this way we don't have to make more merging functions)
@@ -68,37 +135,140 @@ func (h *ProxyQuerier) Select(selectParams *storage.SelectParams, matchers ...*l
 		result = retVector
 	} else {
 		var w api.Warnings
-		result, w, err = h.Client.GetValue(h.Ctx, timestamp.Time(selectParams.Start), timestamp.Time(selectParams.End), matchers)
-		warnings = promutil.WarningsConvert(w)
+		result, w, err = h.Client.GetValue(h.Ctx, rangeStart, rangeEnd, matchers)
+		warnings = append(rangeWarnings, promutil.WarningsConvert(w)...)
 	}
 	if err != nil {
-		return nil, warnings, errors.Cause(err)
+		return nil, warnings, h.upstreamError("get_value", err)
+	}
+
+	// Some downstreams return a nil value (without an error) when there is no
+	// data, this is simply an empty SeriesSet. This only applies when err is nil
+	// so real errors are never masked
+	if result == nil {
+		return NewSeriesSet(nil), warnings, nil
+	}
+
+	// Series may end up without samples (e.g. all of their points were out of
+	// range), which are only meaningful for metadata
+	if h.Cfg != nil && h.Cfg.EmptySeriesPolicy.DropEmptySeries(selectParams == nil) {
+		result = dropEmptySeries(result)
 	}
 
+	// The labels are interned before the series are built, so the series share
+	// them and the strings they were decoded with are freed
+	if h.Interner != nil {
+		h.Interner.Value(result)
+	}
 	iterators := promclient.IteratorsForValue(result)
 
 	series := make([]storage.Series, len(iterators))
 	for i, iterator := range iterators {
-		series[i] = &Series{iterator}
+		series[i] = NewSeries(iterator)
 	}
 
 	return NewSeriesSet(series), warnings, nil
 }
 
+// selectRange returns the time range of a Select (with the warnings of its
+// normalization), once it is checked and the Select is admitted by the shedder.
+// The range is checked before anything is sent downstream. The storage API of
+// this Prometheus version has no hints yet
+func (h *ProxyQuerier) selectRange(selectParams *storage.SelectParams, now time.Time) (time.Time, time.Time, storage.Warnings, error) {
+	rangeStart, rangeEnd := h.Start, h.End
+	if selectParams != nil {
+		rangeStart, rangeEnd = SelectHintsToGetValue(selectParams, nil)
+	}
+	// An inverted range fails (or is swapped) before it is checked any further
+	rangeStart, rangeEnd, swapped, err := h.timeRange().Normalize(rangeStart, rangeEnd)
+	if err != nil {
+		return rangeStart, rangeEnd, nil, &promutil.ValidationError{Method: "select", Err: err}
+	}
+	if err := h.checkRange(rangeStart, rangeEnd, now); err != nil {
+		return rangeStart, rangeEnd, nil, err
+	}
+
+	if h.Shedder != nil {
+		if err := h.Shedder.Admit(selectParams == nil); err != nil {
+			return rangeStart, rangeEnd, nil, err
+		}
+	}
+	return rangeStart, rangeEnd, promutil.WarningsConvert(swapped), nil
+}
+
+// upstreamError wraps an error of a call to the client in an UpstreamError (nil if
+// there is no error)
+func (h *ProxyQuerier) upstreamError(method string, err error) error {
+	if err == nil {
+		return nil
+	}
+	upstreamErr := &promutil.UpstreamError{Method: method, Err: err}
+	if namer, ok := h.Client.(promclient.BackendNamer); ok {
+		upstreamErr.Upstream = namer.BackendName()
+	}
+	return upstreamErr
+}
+
+// internSeries returns fn with the labels of the series interned (if the querier
+// has an Interner)
+func (h *ProxyQuerier) internSeries(fn promclient.SeriesFunc) promclient.SeriesFunc {
+	if h.Interner == nil {
+		return fn
+	}
+	return func(ls model.LabelSet) error {
+		return fn(model.LabelSet(h.Interner.Metric(model.Metric(ls))))
+	}
+}
+
+// dropEmptySeries removes the series without any samples from a matrix
+func dropEmptySeries(v model.Value) model.Value {
+	matrix, ok := v.(model.Matrix)
+	if !ok {
+		return v
+	}
+	ret := make(model.Matrix, 0, len(matrix))
+	for _, stream := range matrix {
+		if len(stream.Values) > 0 {
+			ret = append(ret, stream)
+		}
+	}
+	return ret
+}
+
+// timeRange returns the config of the handling of inverted time ranges
+func (h *ProxyQuerier) timeRange() promclient.TimeRangeConfig {
+	if h.Cfg == nil {
+		return promclient.TimeRangeConfig{}
+	}
+	return h.Cfg.InvertedTimeRange
+}
+
+// maxSeries returns the max number of series a Series call may return (0 is unlimited)
+func (h *ProxyQuerier) maxSeries() int {
+	if h.Cfg == nil {
+		return 0
+	}
+	return h.Cfg.MaxSeries
+}
+
 // LabelValues returns all potential values for a label name.
 func (h *ProxyQuerier) LabelValues(name string) ([]string, storage.Warnings, error) {
 	start := time.Now()
 	defer func() {
-		logrus.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"name": name,
 			"took": time.Now().Sub(start),
 		}).Debug("LabelValues")
 	}()
 
+	if err := promclient.ValidateLabelName(name); err != nil {
+		return nil, nil, &promutil.ValidationError{Method: "label_values", Err: err}
+	}
+
 	result, w, err := h.Client.LabelValues(h.Ctx, name)
 	warnings := promutil.WarningsConvert(w)
 	if err != nil {
-		return nil, warnings, errors.Cause(err)
+		return nil, warnings, h.upstreamError("label_values", err)
 	}
 
 	ret := make([]string, len(result))
@@ -113,15 +283,39 @@ func (h *ProxyQuerier) LabelValues(name string) ([]string, storage.Warnings, err
 func (h *ProxyQuerier) LabelNames() ([]string, storage.Warnings, error) {
 	start := time.Now()
 	defer func() {
-		logrus.WithFields(logrus.Fields{
+		logger.WithFields(logrus.Fields{
 			"took": time.Now().Sub(start),
 		}).Debug("LabelNames")
 	}()
 
 	v, w, err := h.Client.LabelNames(h.Ctx)
-	return v, promutil.WarningsConvert(w), err
+	return v, promutil.WarningsConvert(w), h.upstreamError("label_names", err)
 }
 
 // Close closes the querier. Behavior for subsequent calls to Querier methods
 // is undefined.
-func (h *ProxyQuerier) Close() error { return nil }
+func (h *ProxyQuerier) Close() error {
+	if l := SelectLimiterFromContext(h.Ctx); l != nil {
+		stats := l.Stats()
+		logger.WithFields(logrus.Fields{
+			"selects":                stats.Total,
+			"max_concurrent_selects": stats.MaxConcurrent,
+		}).Debug("Select stats")
+		// Report the stats of the query (if the client asked for them)
+		if r := promclient.QueryStatsRecorderFromContext(h.Ctx); r != nil {
+			r.RecordSelects(stats.Total, stats.MaxConcurrent)
+		}
+	}
+	if c := promclient.TaskStatsFromContext(h.Ctx); c != nil {
+		stats := c.Stats()
+		logger.WithFields(logrus.Fields{
+			"downstream_tasks":        stats.Total,
+			"inline_downstream_tasks": stats.Inline,
+			"task_queue_time":         stats.QueueTime,
+		}).Debug("Downstream task stats")
+	}
+	if h.Interner != nil {
+		h.Interner.Release()
+	}
+	return nil
+}
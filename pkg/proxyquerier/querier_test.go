@@ -0,0 +1,283 @@
+package proxyquerier
+
+import (
+	"context"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// nilAPI returns a nil value and no error, as some downstreams do when empty
+type nilAPI struct {
+	promclient.API
+	v model.Value
+}
+
+func (n *nilAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return n.v, nil, nil
+}
+
+func TestSelectNilValue(t *testing.T) {
+	for _, v := range []model.Value{nil, (*model.Scalar)(nil), model.Matrix(nil), model.Vector(nil)} {
+		q := &ProxyQuerier{Ctx: context.Background(), Client: &nilAPI{v: v}}
+
+		seriesSet, warnings, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(warnings) != 0 {
+			t.Fatalf("unexpected warnings: %v", warnings)
+		}
+		if seriesSet == nil {
+			t.Fatalf("expected an empty SeriesSet not nil")
+		}
+		if seriesSet.Next() {
+			t.Fatalf("expected an empty SeriesSet for %#v", v)
+		}
+	}
+}
+
+// streamAPI streams n series, recording how many have been sent
+type streamAPI struct {
+	promclient.API
+	n    int
+	sent int32
+	// warnings are returned once all the series are sent
+	warnings api.Warnings
+}
+
+func (s *streamAPI) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn promclient.SeriesFunc) (api.Warnings, error) {
+	for i := 0; i < s.n; i++ {
+		if err := fn(model.LabelSet{model.MetricNameLabel: "up", "i": model.LabelValue(strconv.Itoa(i))}); err != nil {
+			return nil, err
+		}
+		atomic.AddInt32(&s.sent, 1)
+	}
+	return s.warnings, nil
+}
+
+func TestSelectStreamSeries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stub := &streamAPI{n: seriesStreamBuffer * 4, warnings: api.Warnings{"partial response"}}
+	q := &ProxyQuerier{Ctx: ctx, Client: stub, Cfg: &proxyconfig.PromxyConfig{}}
+	seriesSet, _, err := q.Select(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Until the SeriesSet is consumed the stream is blocked on the buffer
+	time.Sleep(50 * time.Millisecond)
+	if sent := atomic.LoadInt32(&stub.sent); int(sent) > seriesStreamBuffer+1 {
+		t.Fatalf("stream not blocked by the consumer: sent=%d", sent)
+	}
+
+	count := 0
+	for seriesSet.Next() {
+		count++
+	}
+	if err := seriesSet.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != stub.n {
+		t.Fatalf("mismatch in series expected=%d actual=%d", stub.n, count)
+	}
+
+	// The warnings of the stream come with the SeriesSet, once consumed
+	warnings := seriesSet.(WarningsSeriesSet).Warnings()
+	if len(warnings) != 1 || warnings[0].Error() != "partial response" {
+		t.Fatalf("mismatch in warnings expected=[partial response] actual=%v", warnings)
+	}
+}
+
+func TestSelectStreamSeriesMaxSeries(t *testing.T) {
+	stub := &streamAPI{n: 100}
+	q := &ProxyQuerier{Ctx: context.Background(), Client: stub, Cfg: &proxyconfig.PromxyConfig{MaxSeries: 10}}
+	seriesSet, _, err := q.Select(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	count := 0
+	for seriesSet.Next() {
+		count++
+	}
+	if count != 10 {
+		t.Fatalf("mismatch in series expected=%d actual=%d", 10, count)
+	}
+	if _, ok := seriesSet.Err().(ErrMaxSeries); !ok {
+		t.Fatalf("expected ErrMaxSeries, got: %v", seriesSet.Err())
+	}
+}
+
+func TestSelectStreamSeriesLimiter(t *testing.T) {
+	limiter := NewSelectLimiter(1)
+	ctx := WithSelectLimiter(context.Background(), limiter)
+	q := &ProxyQuerier{Ctx: ctx, Client: &streamAPI{n: seriesStreamBuffer * 2}, Cfg: &proxyconfig.PromxyConfig{}}
+
+	first, _, err := q.Select(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The stream is blocked on the buffer, holding the slot
+	if limiter.current != 1 {
+		t.Fatalf("mismatch in held slots expected=1 actual=%d", limiter.current)
+	}
+
+	// A Select which finds the slot held by the stream buffers it
+	done := make(chan struct{})
+	var second storage.SeriesSet
+	go func() {
+		defer close(done)
+		second, _, err = q.Select(nil)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Select blocked on a slot held by a stream")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, seriesSet := range []storage.SeriesSet{first, second} {
+		count := 0
+		for seriesSet.Next() {
+			count++
+		}
+		if err := seriesSet.Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != seriesStreamBuffer*2 {
+			t.Fatalf("mismatch in series expected=%d actual=%d", seriesStreamBuffer*2, count)
+		}
+	}
+	if stats := limiter.Stats(); limiter.current != 0 || stats.Total != 2 || stats.MaxConcurrent != 1 {
+		t.Fatalf("mismatch in limiter expected=current:0 total:2 max:1 actual=current:%d %+v", limiter.current, stats)
+	}
+}
+
+// emptySeriesAPI returns a series without samples along with a regular one
+type emptySeriesAPI struct {
+	promclient.API
+}
+
+func (e *emptySeriesAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return model.Matrix{
+		{Metric: model.Metric{model.MetricNameLabel: "up", "job": "empty"}},
+		{Metric: model.Metric{model.MetricNameLabel: "up", "job": "a"}, Values: []model.SamplePair{{Timestamp: 1, Value: 1}}},
+	}, nil, nil
+}
+
+func (e *emptySeriesAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	return []model.LabelSet{
+		{model.MetricNameLabel: "up", "job": "empty"},
+		{model.MetricNameLabel: "up", "job": "a"},
+	}, nil, nil
+}
+
+func TestSelectEmptySeries(t *testing.T) {
+	tests := []struct {
+		policy   proxyconfig.EmptySeriesPolicy
+		data     int
+		metadata int
+	}{
+		{policy: "", data: 1, metadata: 2},
+		{policy: proxyconfig.EmptySeriesAuto, data: 1, metadata: 2},
+		{policy: proxyconfig.EmptySeriesDrop, data: 1, metadata: 2},
+		{policy: proxyconfig.EmptySeriesKeep, data: 2, metadata: 2},
+	}
+
+	count := func(seriesSet storage.SeriesSet) int {
+		n := 0
+		for seriesSet.Next() {
+			n++
+		}
+		return n
+	}
+
+	for _, test := range tests {
+		t.Run(string(test.policy), func(t *testing.T) {
+			q := &ProxyQuerier{
+				Ctx:    context.Background(),
+				Client: &emptySeriesAPI{},
+				Cfg:    &proxyconfig.PromxyConfig{EmptySeriesPolicy: test.policy},
+			}
+
+			seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := count(seriesSet); n != test.data {
+				t.Fatalf("mismatch in data series expected=%d actual=%d", test.data, n)
+			}
+
+			// Metadata series never have samples, so they are never dropped
+			seriesSet, _, err = q.Select(nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if n := count(seriesSet); n != test.metadata {
+				t.Fatalf("mismatch in metadata series expected=%d actual=%d", test.metadata, n)
+			}
+		})
+	}
+}
+
+func TestSelectInternLabels(t *testing.T) {
+	q := &ProxyQuerier{
+		Ctx:      context.Background(),
+		Client:   &emptySeriesAPI{},
+		Cfg:      &proxyconfig.PromxyConfig{EmptySeriesPolicy: proxyconfig.EmptySeriesKeep},
+		Interner: promutil.NewLabelInterner(),
+	}
+
+	seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var series []labels.Labels
+	for seriesSet.Next() {
+		series = append(series, seriesSet.At().Labels())
+	}
+	expected := []labels.Labels{
+		labels.FromStrings(model.MetricNameLabel, "up", "job", "empty"),
+		labels.FromStrings(model.MetricNameLabel, "up", "job", "a"),
+	}
+	if !reflect.DeepEqual(series, expected) {
+		t.Fatalf("mismatch in series expected=%v actual=%v", expected, series)
+	}
+	// __name__, up, job, empty and a
+	if l := q.Interner.Len(); l != 5 {
+		t.Fatalf("mismatch in interned strings expected=%d actual=%d", 5, l)
+	}
+
+	if err := q.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if l := q.Interner.Len(); l != 0 {
+		t.Fatalf("mismatch in interned strings after close expected=%d actual=%d", 0, l)
+	}
+}
+
+func TestLabelValuesInvalidName(t *testing.T) {
+	// The client must not be called, so there is none
+	q := &ProxyQuerier{Ctx: context.Background()}
+	for _, name := range []string{"", "0abc", "a-b"} {
+		if _, _, err := q.LabelValues(name); err == nil {
+			t.Fatalf("mismatch in error of %q expected=%v actual=%v", name, &promclient.ErrInvalidLabelName{Label: name}, err)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package proxyquerier
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ErrRangeTooLong is returned for Selects spanning more than the configured
+// max query range
+type ErrRangeTooLong struct {
+	Range time.Duration
+	Max   time.Duration
+}
+
+func (e *ErrRangeTooLong) Error() string {
+	return fmt.Sprintf("query range of %s exceeds the max of %s", e.Range, e.Max)
+}
+
+type maxQueryRangeBypassKey struct{}
+
+// WithMaxQueryRangeBypass returns a context whose Selects aren't limited by the
+// max query range. This is meant for admins, e.g. to backfill from long-term stores.
+func WithMaxQueryRangeBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, maxQueryRangeBypassKey{}, true)
+}
+
+// MaxQueryRangeBypassFromContext returns whether the max query range is bypassed
+// for the context
+func MaxQueryRangeBypassFromContext(ctx context.Context) bool {
+	bypass, _ := ctx.Value(maxQueryRangeBypassKey{}).(bool)
+	return bypass
+}
+
+// checkRange returns an ErrRangeTooLong if the range of a Select exceeds the max
+// query range. The end is clamped as the FutureTimeAPI does, so a range which only
+// exceeds the max because its end is in the future is allowed.
+func (h *ProxyQuerier) checkRange(start, end time.Time, now time.Time) error {
+	if h.Cfg == nil || h.Cfg.MaxQueryRange <= 0 || MaxQueryRangeBypassFromContext(h.Ctx) {
+		return nil
+	}
+	if limit, ok := h.Cfg.FutureTime.Limit(now); ok && end.After(limit) {
+		end = limit
+	}
+	if r := end.Sub(start); r > h.Cfg.MaxQueryRange {
+		return &ErrRangeTooLong{Range: r, Max: h.Cfg.MaxQueryRange}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package proxyquerier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// countAPI counts the GetValue calls sent to it
+type countAPI struct {
+	promclient.API
+	calls int
+}
+
+func (c *countAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	c.calls++
+	return model.Matrix{}, nil, nil
+}
+
+func TestSelectMaxQueryRange(t *testing.T) {
+	const day = 24 * time.Hour
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		start  time.Time
+		end    time.Time
+		bypass bool
+		err    bool
+	}{
+		{
+			name:  "within limit",
+			start: now.Add(-30 * day),
+			end:   now,
+		},
+		{
+			name:  "over limit",
+			start: now.Add(-32 * day),
+			end:   now,
+			err:   true,
+		},
+		{
+			name:   "bypass",
+			start:  now.Add(-365 * day),
+			end:    now,
+			bypass: true,
+		},
+		// The end is clamped to now before the range is checked
+		{
+			name:  "end in the future",
+			start: now.Add(-30 * day),
+			end:   now.Add(10 * day),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.bypass {
+				ctx = WithMaxQueryRangeBypass(ctx)
+			}
+			client := &countAPI{}
+			q := &ProxyQuerier{
+				Ctx:    ctx,
+				Client: client,
+				Cfg:    &proxyconfig.PromxyConfig{MaxQueryRange: 31 * day},
+			}
+
+			_, _, err := q.Select(&storage.SelectParams{Start: timestamp.FromTime(test.start), End: timestamp.FromTime(test.end)})
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if test.err {
+				if _, ok := err.(*ErrRangeTooLong); !ok {
+					t.Fatalf("mismatch in error type expected=%T actual=%T", &ErrRangeTooLong{}, err)
+				}
+				if client.calls != 0 {
+					t.Fatalf("rejected select was sent downstream")
+				}
+			} else if client.calls != 1 {
+				t.Fatalf("mismatch in downstream calls expected=%d actual=%d", 1, client.calls)
+			}
+		})
+	}
+}
+
+// rangeAPI records the range of the GetValue calls sent to it
+type rangeAPI struct {
+	promclient.API
+	start, end time.Time
+	calls      int
+}
+
+func (r *rangeAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	r.calls++
+	r.start, r.end = start, end
+	return model.Matrix{}, nil, nil
+}
+
+func TestSelectInvertedTimeRange(t *testing.T) {
+	start := time.Unix(1000, 0).UTC()
+	end := time.Unix(2000, 0).UTC()
+	params := &storage.SelectParams{Start: timestamp.FromTime(end), End: timestamp.FromTime(start)}
+
+	// By default the inverted range fails before anything is sent downstream
+	client := &rangeAPI{}
+	q := &ProxyQuerier{Ctx: context.Background(), Client: client, Cfg: &proxyconfig.PromxyConfig{}}
+	_, _, err := q.Select(params)
+	validationErr, ok := err.(*promutil.ValidationError)
+	if !ok {
+		t.Fatalf("mismatch in error type expected=%T actual=%T", &promutil.ValidationError{}, err)
+	}
+	if _, ok := validationErr.Err.(*promclient.ErrInvalidTimeRange); !ok {
+		t.Fatalf("mismatch in error cause expected=%T actual=%T", &promclient.ErrInvalidTimeRange{}, validationErr.Err)
+	}
+	if client.calls != 0 {
+		t.Fatalf("rejected select was sent downstream")
+	}
+
+	// With the swap policy the range is swapped, with a warning
+	q.Cfg.InvertedTimeRange = promclient.TimeRangeConfig{Policy: promclient.TimeRangeSwap}
+	_, warnings, err := q.Select(params)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !client.start.Equal(start) || !client.end.Equal(end) {
+		t.Fatalf("mismatch in downstream range expected=%v-%v actual=%v-%v", start, end, client.start, client.end)
+	}
+	if len(warnings) != 1 {
+		t.Fatalf("mismatch in warnings expected=%d actual=%v", 1, warnings)
+	}
+}
@@ -7,14 +7,25 @@ import (
 	"github.com/promproxy/pkg/promclient"
 )
 
+// NewSeries returns the Series of the iterator, with its labels built once
+func NewSeries(it *promclient.SeriesIterator) *Series {
+	return &Series{It: it, labels: it.Labels()}
+}
+
 // Series implements prometheus' Series interface
 type Series struct {
 	It *promclient.SeriesIterator
+	// labels are the labels of It (if built already), as the engine asks for them
+	// repeatedly
+	labels labels.Labels
 }
 
 // Labels for this seris
 func (s *Series) Labels() labels.Labels {
-	return s.It.Labels()
+	if s.labels == nil {
+		return s.It.Labels()
+	}
+	return s.labels
 }
 
 // Iterator returns an iterator over the series
@@ -0,0 +1,128 @@
+package proxyquerier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// seriesStreamBuffer is the number of labelsets buffered between the stream and
+// the consumer of the SeriesSet. Once the buffer is full the stream blocks until
+// the consumer catches up
+const seriesStreamBuffer = 1024
+
+// ErrMaxSeries is returned when a query matches more than the configured max series
+type ErrMaxSeries int
+
+func (e ErrMaxSeries) Error() string {
+	return fmt.Sprintf("query matched more than the max of %d series", int(e))
+}
+
+// WarningsSeriesSet is a SeriesSet whose warnings are only known once it is
+// consumed, such as those at the end of a stream. These can't be returned by the
+// Select which returned the SeriesSet, so its consumer gets them from the SeriesSet.
+type WarningsSeriesSet interface {
+	storage.SeriesSet
+	// Warnings returns the warnings of the SeriesSet, these are only set once
+	// Next() has returned false
+	Warnings() storage.Warnings
+}
+
+// NewStreamSeriesSet returns a SeriesSet which lazily consumes the labelsets from
+// the stream. If maxSeries > 0 the stream is stopped (and the SeriesSet returns an
+// error) once it exceeds maxSeries. The slot of the limiter (if set) is held until
+// the stream ends, as the downstream calls last that long.
+func NewStreamSeriesSet(ctx context.Context, maxSeries int, limiter *SelectLimiter, stream func(promclient.SeriesFunc) (storage.Warnings, error)) *StreamSeriesSet {
+	ch := make(chan model.LabelSet, seriesStreamBuffer)
+	s := &StreamSeriesSet{ch: ch}
+	if limiter != nil {
+		limiter.holdForStream(s)
+	}
+
+	go func() {
+		defer close(ch)
+		// The slot is released before ch is closed, so it is free once buffer()
+		// returns
+		if limiter != nil {
+			defer limiter.releaseStream(s)
+		}
+		count := 0
+		s.warnings, s.err = stream(func(ls model.LabelSet) error {
+			count++
+			if maxSeries > 0 && count > maxSeries {
+				return ErrMaxSeries(maxSeries)
+			}
+			select {
+			case ch <- ls:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	return s
+}
+
+// StreamSeriesSet implements prometheus' SeriesSet interface (and
+// WarningsSeriesSet) over a streamed Series call
+type StreamSeriesSet struct {
+	// l guards ch, as the stream may be buffered by another Select
+	l   sync.Mutex
+	ch  <-chan model.LabelSet
+	cur storage.Series
+	// err and warnings are set by the stream before ch is closed
+	err      error
+	warnings storage.Warnings
+}
+
+// Next will attempt to move the iterator up
+func (s *StreamSeriesSet) Next() bool {
+	s.l.Lock()
+	ls, ok := <-s.ch
+	s.l.Unlock()
+	if !ok {
+		return false
+	}
+	s.cur = NewSeries(promclient.NewSeriesIterator(&model.Sample{Metric: model.Metric(ls)}))
+	return true
+}
+
+// buffer reads the rest of the stream into memory, which ends it and so releases
+// the select limiter for other Selects of the query
+func (s *StreamSeriesSet) buffer() {
+	s.l.Lock()
+	defer s.l.Unlock()
+	var buffered []model.LabelSet
+	for ls := range s.ch {
+		buffered = append(buffered, ls)
+	}
+	ch := make(chan model.LabelSet, len(buffered))
+	for _, ls := range buffered {
+		ch <- ls
+	}
+	close(ch)
+	s.ch = ch
+}
+
+// At returns the current Series for this iterator
+func (s *StreamSeriesSet) At() storage.Series {
+	return s.cur
+}
+
+// Err returns any error found in this iterator, this is only set once Next()
+// has returned false
+func (s *StreamSeriesSet) Err() error {
+	return s.err
+}
+
+// Warnings returns the warnings of the stream, these are only set once Next()
+// has returned false
+func (s *StreamSeriesSet) Warnings() storage.Warnings {
+	return s.warnings
+}
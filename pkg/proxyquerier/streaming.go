@@ -0,0 +1,172 @@
+package proxyquerier
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// StreamingProxyQuerier is a ProxyQuerier whose data Selects yield the series one
+// at a time as the client streams them (see promclient.StreamingAPI), instead of
+// loading the whole result into memory first. The result of a client which can't
+// stream is loaded with GetValue as before, and Series Selects are those of the
+// ProxyQuerier.
+//
+// The series of a stream are never merged, so this is meant for clients which
+// don't merge the results of several downstreams (e.g. a single host).
+type StreamingProxyQuerier struct {
+	*ProxyQuerier
+}
+
+// Select returns a set of series that matches the given label matchers.
+func (h *StreamingProxyQuerier) Select(selectParams *storage.SelectParams, matchers ...*labels.Matcher) (storage.SeriesSet, storage.Warnings, error) {
+	if selectParams == nil {
+		return h.ProxyQuerier.Select(selectParams, matchers...)
+	}
+
+	start := time.Now()
+	defer func() {
+		logger.WithFields(logrus.Fields{
+			"selectParams": selectParams,
+			"matchers":     matchers,
+			"took":         time.Now().Sub(start),
+		}).Debug("StreamingSelect")
+	}()
+
+	rangeStart, rangeEnd, warnings, err := h.selectRange(selectParams, start)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The select limiter bounds the downstream calls, which last until the stream
+	// ends as the response is read while the SeriesSet is consumed
+	l := SelectLimiterFromContext(h.Ctx)
+	if l != nil {
+		if err := l.Acquire(h.Ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	ch, err := promclient.StreamGetValue(h.Ctx, h.Client, rangeStart, rangeEnd, matchers)
+	if err != nil {
+		if l != nil {
+			l.Release()
+		}
+		return nil, warnings, h.upstreamError("get_value", err)
+	}
+	set := &ValueStreamSeriesSet{h: h.ProxyQuerier, ch: ch, limiter: l}
+	if l != nil {
+		l.holdForStream(set)
+	}
+	return set, warnings, nil
+}
+
+// ValueStreamSeriesSet implements prometheus' SeriesSet interface (and
+// WarningsSeriesSet) over a streamed GetValue call. The stream is stopped once the
+// context of the querier is done, so a SeriesSet which isn't consumed entirely
+// doesn't leak it.
+type ValueStreamSeriesSet struct {
+	h *ProxyQuerier
+	// l guards ch and limiter, as the stream may be buffered by another Select
+	l        sync.Mutex
+	ch       <-chan promclient.StreamedSeries
+	cur      storage.Series
+	err      error
+	warnings storage.Warnings
+	// limiter (if set) is released once the stream ends or is buffered
+	limiter *SelectLimiter
+}
+
+// Next will attempt to move the iterator up
+func (s *ValueStreamSeriesSet) Next() bool {
+	for {
+		s.l.Lock()
+		streamed, ok := <-s.ch
+		s.l.Unlock()
+		if !ok {
+			// A stream stopped by the context ends without an error of its own
+			if s.err == nil {
+				s.err = s.h.Ctx.Err()
+			}
+			s.release()
+			return false
+		}
+		// Warnings only come at the end of a stream, long after the Select
+		// returned, so they are returned by the SeriesSet
+		s.warnings = append(s.warnings, promutil.WarningsConvert(streamed.Warnings)...)
+		if streamed.Err != nil {
+			s.err = s.h.upstreamError("get_value", streamed.Err)
+			s.release()
+			return false
+		}
+
+		stream := streamed.Series
+		if stream == nil {
+			continue
+		}
+		// Series may end up without samples (e.g. all of their points were out of
+		// range), which are only meaningful for metadata
+		if s.h.Cfg != nil && s.h.Cfg.EmptySeriesPolicy.DropEmptySeries(false) && len(stream.Values) == 0 {
+			continue
+		}
+		if s.h.Interner != nil {
+			stream.Metric = s.h.Interner.Metric(stream.Metric)
+		}
+		s.cur = NewSeries(promclient.NewSeriesIterator(stream))
+		return true
+	}
+}
+
+// release releases the select limiter (once)
+func (s *ValueStreamSeriesSet) release() {
+	s.l.Lock()
+	l := s.limiter
+	s.limiter = nil
+	s.l.Unlock()
+
+	if l != nil {
+		l.releaseStream(s)
+	}
+}
+
+// buffer reads the rest of the stream into memory, releasing the select limiter
+// for other Selects of the query
+func (s *ValueStreamSeriesSet) buffer() {
+	s.l.Lock()
+	var buffered []promclient.StreamedSeries
+	for streamed := range s.ch {
+		buffered = append(buffered, streamed)
+	}
+	ch := make(chan promclient.StreamedSeries, len(buffered))
+	for _, streamed := range buffered {
+		ch <- streamed
+	}
+	close(ch)
+	s.ch = ch
+	s.l.Unlock()
+
+	s.release()
+}
+
+// At returns the current Series for this iterator
+func (s *ValueStreamSeriesSet) At() storage.Series {
+	return s.cur
+}
+
+// Err returns any error found in this iterator, this is only set once Next()
+// has returned false
+func (s *ValueStreamSeriesSet) Err() error {
+	return s.err
+}
+
+// Warnings returns the warnings of the stream, these are only set once Next()
+// has returned false
+func (s *ValueStreamSeriesSet) Warnings() storage.Warnings {
+	return s.warnings
+}
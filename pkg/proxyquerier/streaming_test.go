@@ -0,0 +1,238 @@
+package proxyquerier
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// valueStreamAPI streams n series, building each one as it is sent and recording
+// how many have been built
+type valueStreamAPI struct {
+	promclient.API
+	n int
+	// err and warnings (if set) end the stream once all the series are sent
+	err      error
+	warnings api.Warnings
+	built    int32
+}
+
+func (s *valueStreamAPI) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan promclient.StreamedSeries, error) {
+	ch := make(chan promclient.StreamedSeries)
+	go func() {
+		defer close(ch)
+		for i := 0; i < s.n; i++ {
+			atomic.AddInt32(&s.built, 1)
+			stream := &model.SampleStream{
+				Metric: model.Metric{model.MetricNameLabel: "up", "i": model.LabelValue(strconv.Itoa(i))},
+				Values: []model.SamplePair{{Timestamp: model.Time(i), Value: model.SampleValue(i)}},
+			}
+			select {
+			case ch <- promclient.StreamedSeries{Series: stream}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if s.err != nil || len(s.warnings) > 0 {
+			ch <- promclient.StreamedSeries{Warnings: s.warnings, Err: s.err}
+		}
+	}()
+	return ch, nil
+}
+
+func TestStreamingSelectLazy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stub := &valueStreamAPI{n: 100000}
+	q := &StreamingProxyQuerier{&ProxyQuerier{Ctx: ctx, Client: stub, Cfg: &proxyconfig.PromxyConfig{}}}
+	seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Until the SeriesSet is consumed at most one series is built ahead of it
+	time.Sleep(50 * time.Millisecond)
+	if built := atomic.LoadInt32(&stub.built); built > 1 {
+		t.Fatalf("stream not blocked by the consumer: built=%d", built)
+	}
+
+	count := 0
+	for seriesSet.Next() {
+		count++
+		if built := int(atomic.LoadInt32(&stub.built)); built > count+1 {
+			t.Fatalf("series built ahead of the consumer: built=%d consumed=%d", built, count)
+		}
+
+		series := seriesSet.At()
+		expected := strconv.Itoa(count - 1)
+		if v := series.Labels().Get("i"); v != expected {
+			t.Fatalf("mismatch in series expected=%s actual=%s", expected, v)
+		}
+		it := series.Iterator()
+		if !it.Next() {
+			t.Fatalf("missing sample in series %s", expected)
+		}
+		if _, v := it.At(); v != float64(count-1) {
+			t.Fatalf("mismatch in value expected=%d actual=%v", count-1, v)
+		}
+	}
+	if err := seriesSet.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != stub.n {
+		t.Fatalf("mismatch in series expected=%d actual=%d", stub.n, count)
+	}
+}
+
+func TestStreamingSelect(t *testing.T) {
+	tests := []struct {
+		name   string
+		client promclient.API
+		policy proxyconfig.EmptySeriesPolicy
+		series int
+		err    bool
+	}{
+		{name: "stream", client: &valueStreamAPI{n: 10}, series: 10},
+		{name: "stream error", client: &valueStreamAPI{n: 10, err: fmt.Errorf("connection reset")}, series: 10, err: true},
+		// Clients which can't stream are loaded first
+		{name: "fallback", client: &emptySeriesAPI{}, series: 1},
+		{name: "fallback keep empty", client: &emptySeriesAPI{}, policy: proxyconfig.EmptySeriesKeep, series: 2},
+		{name: "fallback nil", client: &nilAPI{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			q := &StreamingProxyQuerier{&ProxyQuerier{
+				Ctx:      context.Background(),
+				Client:   test.client,
+				Cfg:      &proxyconfig.PromxyConfig{EmptySeriesPolicy: test.policy},
+				Interner: promutil.NewLabelInterner(),
+			}}
+			seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			count := 0
+			for seriesSet.Next() {
+				count++
+			}
+			if count != test.series {
+				t.Fatalf("mismatch in series expected=%d actual=%d", test.series, count)
+			}
+			if (seriesSet.Err() != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, seriesSet.Err())
+			}
+			if test.err {
+				if _, ok := seriesSet.Err().(*promutil.UpstreamError); !ok {
+					t.Fatalf("expected an UpstreamError, got: %v", seriesSet.Err())
+				}
+			}
+		})
+	}
+}
+
+func TestStreamingSelectWarnings(t *testing.T) {
+	stub := &valueStreamAPI{n: 10, warnings: api.Warnings{"partial response"}}
+	q := &StreamingProxyQuerier{&ProxyQuerier{Ctx: context.Background(), Client: stub, Cfg: &proxyconfig.PromxyConfig{}}}
+	seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for seriesSet.Next() {
+	}
+	if err := seriesSet.Err(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// The warnings of the stream come with the SeriesSet, once consumed
+	warnings := seriesSet.(WarningsSeriesSet).Warnings()
+	if len(warnings) != 1 || warnings[0].Error() != "partial response" {
+		t.Fatalf("mismatch in warnings expected=[partial response] actual=%v", warnings)
+	}
+}
+
+func TestStreamingSelectContextDone(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	stub := &valueStreamAPI{n: 100}
+	q := &StreamingProxyQuerier{&ProxyQuerier{Ctx: ctx, Client: stub, Cfg: &proxyconfig.PromxyConfig{}}}
+	seriesSet, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !seriesSet.Next() {
+		t.Fatalf("expected a series")
+	}
+
+	// The stream stops with the querier, and the SeriesSet ends with its error
+	cancel()
+	for seriesSet.Next() {
+	}
+	if err := seriesSet.Err(); err != context.Canceled {
+		t.Fatalf("mismatch in error expected=%v actual=%v", context.Canceled, err)
+	}
+	if built := atomic.LoadInt32(&stub.built); int(built) == stub.n {
+		t.Fatalf("stream not stopped by the context")
+	}
+}
+
+func TestStreamingSelectLimiter(t *testing.T) {
+	limiter := NewSelectLimiter(1)
+	ctx := WithSelectLimiter(context.Background(), limiter)
+	q := &StreamingProxyQuerier{&ProxyQuerier{Ctx: ctx, Client: &valueStreamAPI{n: 10}, Cfg: &proxyconfig.PromxyConfig{}}}
+
+	first, _, err := q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// The slot is held by the stream until it is consumed
+	if limiter.current != 1 {
+		t.Fatalf("mismatch in held slots expected=1 actual=%d", limiter.current)
+	}
+
+	// The engine Selects all the series before consuming any, so a Select which
+	// finds the slots held by streams buffers them instead of waiting
+	done := make(chan struct{})
+	var second storage.SeriesSet
+	go func() {
+		defer close(done)
+		second, _, err = q.Select(&storage.SelectParams{Start: 0, End: 1000})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Select blocked on a slot held by a stream")
+	}
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, seriesSet := range []storage.SeriesSet{first, second} {
+		count := 0
+		for seriesSet.Next() {
+			count++
+		}
+		if err := seriesSet.Err(); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if count != 10 {
+			t.Fatalf("mismatch in series expected=%d actual=%d", 10, count)
+		}
+	}
+	if stats := limiter.Stats(); limiter.current != 0 || stats.Total != 2 || stats.MaxConcurrent != 1 {
+		t.Fatalf("mismatch in limiter expected=current:0 total:2 max:1 actual=current:%d %+v", limiter.current, stats)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -16,8 +17,12 @@ import (
 	"github.com/prometheus/prometheus/storage"
 	"github.com/sirupsen/logrus"
 
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/metrics"
 	"github.com/promproxy/pkg/promutil"
 	"github.com/promproxy/pkg/remote"
+	"github.com/promproxy/pkg/scheduler"
+	"github.com/promproxy/pkg/tracing"
 
 	proxyconfig "github.com/promproxy/pkg/config"
 	"github.com/jacksontj/promxy/pkg/promclient"
@@ -25,6 +30,10 @@ import (
 	"github.com/jacksontj/promxy/pkg/servergroup"
 )
 
+func init() {
+	metrics.MustRegister(promclient.DefaultResultCacheStats)
+}
+
 type proxyStorageState struct {
 	sgs            []*servergroup.ServerGroup
 	client         promclient.API
@@ -32,6 +41,11 @@ type proxyStorageState struct {
 	remoteStorage  *remote.Storage
 	appender       storage.Appender
 	appenderCloser func() error
+	// stopLabelCache stops refreshing the label cache (if configured)
+	stopLabelCache func()
+	// scraped is set if any servergroup is scraped, which can only answer selectors
+	// so nothing is pushed down to the servergroups (see NodeReplacer)
+	scraped bool
 }
 
 // Ready blocks until all servergroups are ready
@@ -48,6 +62,9 @@ func (p *proxyStorageState) Cancel(n *proxyStorageState) {
 			sg.Cancel()
 		}
 	}
+	if p.stopLabelCache != nil {
+		p.stopLabelCache()
+	}
 	// We call close if the new one is nil, or if the appanders don't match
 	if n == nil || p.appender != n.appender {
 		if p.appenderCloser != nil {
@@ -64,6 +81,9 @@ func NewProxyStorage() (*ProxyStorage, error) {
 // ProxyStorage implements prometheus' Storage interface
 type ProxyStorage struct {
 	state atomic.Value
+	// name is the name of the virtual proxy the storage serves (empty for the
+	// root proxy), which leaves the process wide settings to the root proxy
+	name string
 }
 
 // GetState returns the current state of the ProxyStorage
@@ -90,16 +110,97 @@ func (p *ProxyStorage) ApplyConfig(c *proxyconfig.Config) error {
 		tmp := servergroup.New()
 		if err := tmp.ApplyConfig(sgCfg); err != nil {
 			failed = true
-			logrus.Errorf("Error applying config to server group: %s", err)
+			logrus.WithField("virtual_proxy", p.name).Errorf("Error applying config to server group: %s", err)
 		}
 		newState.sgs[i] = tmp
 		apis[i] = tmp
+		if sgCfg.Scrape != nil {
+			newState.scraped = true
+		}
+	}
+	multiAPI := promclient.NewMultiAPI(apis, model.TimeFromUnix(0), nil, len(apis))
+	multiAPI.FairSeriesLimit = c.FairSeriesBudget
+	multiAPI.ExplainRouting = c.ExplainRouting
+	newState.client = multiAPI
+
+	// The aliases are resolved right on top of the merge, so the results seen by
+	// everything else are under the canonical names
+	if c.MetricAliases != nil {
+		newState.client = promclient.NewMetricAliasAPI(newState.client, *c.MetricAliases)
+	}
+
+	if len(c.MetricAllowlist) > 0 {
+		allowlistAPI, err := promclient.NewAllowlistAPI(newState.client, c.MetricAllowlist)
+		if err != nil {
+			newState.Cancel(nil)
+			return &promutil.ConfigError{Method: "apply_config", Err: errors.Wrap(err, "invalid metric_allowlist")}
+		}
+		newState.client = allowlistAPI
+	}
+
+	if c.Throttle != nil {
+		newState.client = promclient.NewThrottleAPI(newState.client, *c.Throttle)
+	}
+
+	if c.MaxConcurrency != nil {
+		newState.client = promclient.NewLimitConcurrencyAPI(newState.client, *c.MaxConcurrency)
+	}
+
+	if c.QueryLatencyBudget > 0 {
+		newState.client = &promclient.LatencyBudgetAPI{newState.client}
+	}
+
+	// The label cache wraps the limits, so cached calls don't count against them
+	if c.LabelCache != nil {
+		labelCache := promclient.NewLabelCacheAPI(newState.client, *c.LabelCache)
+		stop, err := scheduler.DefaultScheduler.Register(labelCache.Job())
+		if err != nil {
+			newState.Cancel(nil)
+			return errors.Wrap(err, "error scheduling the label cache")
+		}
+		newState.stopLabelCache = stop
+		newState.client = labelCache
+	}
+
+	// The result cache wraps the limits as well, and only sees the calls once
+	// their times are normalized
+	if c.ResultCache != nil {
+		newState.client = promclient.NewResultCacheAPI(newState.client, *c.ResultCache)
+	}
+
+	// Times in the future are handled before anything else sees them, so nothing
+	// is cached for the original times of a clamped call
+	newState.client = promclient.NewFutureTimeAPI(newState.client, c.FutureTime)
+	// Inverted ranges are handled as the caller sent them
+	newState.client = promclient.NewTimeRangeAPI(newState.client, c.InvertedTimeRange)
+
+	// The process wide settings are those of the root proxy
+	if p.name == "" {
+		workerPoolSize := c.WorkerPoolSize
+		if workerPoolSize == 0 {
+			workerPoolSize = promclient.DefaultWorkerPoolSize
+		}
+		promclient.DefaultWorkerPool.SetSize(workerPoolSize)
+		loadshed.DefaultShedder.ApplyConfig(c.LoadShedding, nil)
+		tracing.DefaultTracer.ApplyConfig(c.Tracing)
 	}
-	newState.client = promclient.NewMultiAPI(apis, model.TimeFromUnix(0), nil, len(apis))
 
 	if failed {
 		newState.Cancel(nil)
-		return fmt.Errorf("Error Applying Config to one or more server group(s)")
+		return &promutil.ConfigError{Method: "apply_config", Err: fmt.Errorf("Error Applying Config to one or more server group(s)")}
+	}
+
+	// Queries are routed to the servergroups by their labels and external labels
+	if p.name == "" {
+		verificationGroups := make([]promclient.VerificationGroup, len(c.ServerGroups))
+		for i, sgCfg := range c.ServerGroups {
+			verificationGroups[i] = promclient.VerificationGroup{
+				Name:   strconv.Itoa(i),
+				Labels: sgCfg.Labels.Merge(sgCfg.ExternalLabels),
+				API:    newState.sgs[i],
+			}
+		}
+		promclient.DefaultQueryVerifier.ApplyConfig(c.QueryVerification, verificationGroups)
 	}
 
 	// Check for remote_write (for appender)
@@ -132,6 +233,10 @@ func (p *ProxyStorage) ApplyConfig(c *proxyconfig.Config) error {
 	if oldState != nil && oldState.appender != newState.appender {
 		oldState.Cancel(newState) // Cancel the old one
 	}
+	// The old label cache is replaced by the new one, even if the old state isn't cancelled
+	if oldState != nil && oldState.stopLabelCache != nil {
+		oldState.stopLabelCache()
+	}
 
 	return nil
 }
@@ -139,14 +244,43 @@ func (p *ProxyStorage) ApplyConfig(c *proxyconfig.Config) error {
 // Querier returns a new Querier on the storage.
 func (p *ProxyStorage) Querier(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
 	state := p.GetState()
-	return &proxyquerier.ProxyQuerier{
+
+	// Each query gets its own select limiter (with the limit of its tenant), unless
+	// the caller already attached one
+	if proxyquerier.SelectLimiterFromContext(ctx) == nil && state.cfg != nil {
+		limit := state.cfg.SelectLimit(promclient.TenantFromContext(ctx))
+		ctx = proxyquerier.WithSelectLimiter(ctx, proxyquerier.NewSelectLimiter(limit))
+	}
+
+	// Each query gets its own latency budget
+	if promclient.LatencyBudgetFromContext(ctx) == nil && state.cfg != nil && state.cfg.QueryLatencyBudget > 0 {
+		ctx = promclient.WithLatencyBudget(ctx, promclient.NewLatencyBudget(state.cfg.QueryLatencyBudget))
+	}
+
+	// Each query accounts the downstream tasks it generated
+	if promclient.TaskStatsFromContext(ctx) == nil {
+		ctx = promclient.WithTaskStats(ctx, &promclient.TaskCounter{})
+	}
+
+	q := &proxyquerier.ProxyQuerier{
 		ctx,
 		timestamp.Time(mint).UTC(),
 		timestamp.Time(maxt).UTC(),
 		state.client,
 
 		state.cfg,
-	}, nil
+		loadshed.DefaultShedder,
+		promutil.NewLabelInterner(),
+	}
+	if state.cfg != nil && state.cfg.StreamSelects {
+		return &proxyquerier.StreamingProxyQuerier{q}, nil
+	}
+	return q, nil
+}
+
+// Client returns the client of the servergroups of the current state
+func (p *ProxyStorage) Client() promclient.API {
+	return p.GetState().client
 }
 
 // StartTime returns the oldest timestamp stored in the storage.
@@ -160,8 +294,55 @@ func (p *ProxyStorage) Appender() (storage.Appender, error) {
 	return state.appender, nil
 }
 
-// Close releases the resources of the Querier.
-func (p *ProxyStorage) Close() error { return nil }
+// DeleteSeries deletes the data of the series matching any of the matchers in the
+// time range from all the servergroups, implementing promclient.AdminAPI
+func (p *ProxyStorage) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	state := p.GetState()
+	admins := make(promclient.MultiAdminAPI, len(state.sgs))
+	for i, sg := range state.sgs {
+		admins[i] = sg
+	}
+	return admins.DeleteSeries(ctx, matches, startTime, endTime)
+}
+
+// Snapshot creates a snapshot of the data of all the servergroups, implementing
+// promclient.AdminAPI
+func (p *ProxyStorage) Snapshot(ctx context.Context, skipHead bool) (*promclient.SnapshotResult, error) {
+	state := p.GetState()
+	admins := make(promclient.MultiAdminAPI, len(state.sgs))
+	for i, sg := range state.sgs {
+		admins[i] = sg
+	}
+	return admins.Snapshot(ctx, skipHead)
+}
+
+// TSDBStatus returns the TSDB status synthesized from all the servergroups,
+// implementing promclient.TSDBStatuser
+func (p *ProxyStorage) TSDBStatus(ctx context.Context) (*promclient.TSDBStatus, api.Warnings, error) {
+	state := p.GetState()
+	statusers := make(promclient.MultiTSDBStatus, len(state.sgs))
+	for i, sg := range state.sgs {
+		statusers[i] = sg
+	}
+	return statusers.TSDBStatus(ctx)
+}
+
+// Close stops the servergroups and background jobs (e.g. the label cache) of the
+// current state
+func (p *ProxyStorage) Close() error {
+	p.GetState().Cancel(nil)
+	return nil
+}
+
+// queryError wraps the error of a query of the statement to the servergroups in an
+// UpstreamError
+func queryError(s *promql.EvalStmt, err error) error {
+	method := "query"
+	if s.Interval > 0 {
+		method = "query_range"
+	}
+	return &promutil.UpstreamError{Method: method, Err: err}
+}
 
 // NodeReplacer replaces promql Nodes with more efficient-to-fetch ones. This works by taking lower-layer
 // chunks of the query, farming them out to prometheus hosts, then stitching the results back together.
@@ -171,6 +352,23 @@ func (p *ProxyStorage) Close() error { return nil }
 //      - offsets within the subtree must match: if they don't then we'll get mismatched data, so we wait until we are far enough down the tree that they converge
 //      - Don't reduce accuracy/granularity: the intention of this is to get the correct data faster, meaning correctness overrules speed.
 func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, node promql.Node) (promql.Node, error) {
+	state := p.GetState()
+	// The query limit of the request only applies to its final result. The
+	// results of the nodes pushed down are evaluated further (e.g. as a side of a
+	// binary expression), so the hosts must not truncate them.
+	ctx = promclient.WithQueryLimit(ctx, 0)
+	// Scraped servergroups only answer selectors, so the query is evaluated here
+	// from their Selects
+	if state.scraped {
+		return nil, nil
+	}
+	// Transforms only apply to the raw values, so nothing is pushed down to
+	// servergroups whose values are transformed
+	for _, sg := range state.sgs {
+		if sg.Transformed() {
+			return nil, nil
+		}
+	}
 
 	isAgg := func(node promql.Node) bool {
 		_, ok := node.(*promql.AggregateExpr)
@@ -216,7 +414,6 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 		return err
 	}
 
-	state := p.GetState()
 	switch n := node.(type) {
 	// Some AggregateExprs can be composed (meaning they are "reentrant". If the aggregation op
 	// is reentrant/composable then we'll do so, otherwise we let it fall through to normal query mechanisms
@@ -244,7 +441,7 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 			}
 
 			if err != nil {
-				return nil, errors.Cause(err)
+				return nil, queryError(s, err)
 			}
 
 		// Convert avg into sum() / count()
@@ -285,7 +482,7 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 			}
 
 			if err != nil {
-				return nil, errors.Cause(err)
+				return nil, queryError(s, err)
 			}
 			n.Op = promql.ItemSum
 
@@ -304,7 +501,7 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 			}
 
 			if err != nil {
-				return nil, errors.Cause(err)
+				return nil, queryError(s, err)
 			}
 
 			iterators := promclient.IteratorsForValue(result)
@@ -374,7 +571,7 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 		}
 
 		if err != nil {
-			return nil, errors.Cause(err)
+			return nil, queryError(s, err)
 		}
 		iterators := promclient.IteratorsForValue(result)
 		series := make([]storage.Series, len(iterators))
@@ -408,7 +605,7 @@ func (p *ProxyStorage) NodeReplacer(ctx context.Context, s *promql.EvalStmt, nod
 		}
 
 		if err != nil {
-			return nil, errors.Cause(err)
+			return nil, queryError(s, err)
 		}
 
 		iterators := promclient.IteratorsForValue(result)
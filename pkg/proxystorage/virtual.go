@@ -0,0 +1,150 @@
+package proxystorage
+
+import (
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/config"
+	"github.com/sirupsen/logrus"
+
+	proxyconfig "github.com/promproxy/pkg/config"
+	"github.com/promproxy/pkg/server"
+)
+
+// VirtualProxyHandlerFunc returns the handler of the requests of the named virtual
+// proxy, which are served from its storage
+type VirtualProxyHandlerFunc func(name string, cfg *proxyconfig.PromxyConfig, storage *ProxyStorage) http.Handler
+
+type virtualProxiesState struct {
+	storages map[string]*ProxyStorage
+	handlers map[string]http.Handler
+	tenants  map[string]string
+	// tenanted are the names of the virtual proxies with tenants
+	tenanted map[string]bool
+}
+
+// cancel cancels the storages of the state
+func (s *virtualProxiesState) cancel() {
+	for _, storage := range s.storages {
+		storage.Close()
+	}
+}
+
+// NewVirtualProxies returns the VirtualProxies whose requests are served by the
+// handlers returned by handler
+func NewVirtualProxies(handler VirtualProxyHandlerFunc) *VirtualProxies {
+	return &VirtualProxies{handler: handler}
+}
+
+// VirtualProxies are the virtual proxies of the config, which are served from the
+// same process as the root proxy (see server.VirtualProxyMiddleware). Each one has
+// a ProxyStorage of its own, so nothing (servergroups, clients or caches) is
+// shared between them or with the root proxy. The process wide settings (e.g. the
+// worker pool and tracing) are those of the root proxy.
+//
+// A config is applied by building all the virtual proxies anew, which replace the
+// previous ones at once, so a reload adds and removes virtual proxies atomically.
+// If any of them fails the previous ones are kept.
+type VirtualProxies struct {
+	handler VirtualProxyHandlerFunc
+	state   atomic.Value
+}
+
+func (v *VirtualProxies) getState() *virtualProxiesState {
+	if s, ok := v.state.Load().(*virtualProxiesState); ok {
+		return s
+	}
+	return &virtualProxiesState{}
+}
+
+// ApplyConfig replaces the virtual proxies with those of the config
+func (v *VirtualProxies) ApplyConfig(c *proxyconfig.Config) error {
+	if err := c.ValidateVirtualProxies(); err != nil {
+		return err
+	}
+
+	newState := &virtualProxiesState{
+		storages: make(map[string]*ProxyStorage, len(c.VirtualProxies)),
+		handlers: make(map[string]http.Handler, len(c.VirtualProxies)),
+		tenants:  c.VirtualProxyTenants(),
+		tenanted: make(map[string]bool, len(c.VirtualProxies)),
+	}
+	for _, name := range newState.tenants {
+		newState.tenanted[name] = true
+	}
+	names := make([]string, 0, len(c.VirtualProxies))
+	for name, vpCfg := range c.VirtualProxies {
+		names = append(names, name)
+		handler, err := v.build(name, vpCfg, newState)
+		if err != nil {
+			newState.cancel()
+			return errors.Wrapf(err, "error applying config of virtual proxy %q", name)
+		}
+		newState.handlers[name] = handler
+	}
+	sort.Strings(names)
+
+	oldState := v.getState()
+	v.state.Store(newState)
+	oldState.cancel()
+	logrus.WithField("virtual_proxies", names).Info("Applied config of virtual proxies")
+	return nil
+}
+
+// build builds the storage of the virtual proxy (adding it to the state) and
+// returns its handler, with its own auth and limits
+func (v *VirtualProxies) build(name string, vpCfg *proxyconfig.VirtualProxyConfig, state *virtualProxiesState) (http.Handler, error) {
+	storage := &ProxyStorage{name: name}
+	// Remote write is only done by the root proxy
+	if err := storage.ApplyConfig(&proxyconfig.Config{
+		PromConfig:   config.DefaultConfig,
+		PromxyConfig: vpCfg.PromxyConfig,
+	}); err != nil {
+		return nil, err
+	}
+	state.storages[name] = storage
+
+	auth, err := server.AuthMiddleware(vpCfg.Auth)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid auth")
+	}
+	chain := server.NewChain(vpCfg.Middleware).Use(auth, server.TenancyMiddleware, server.UpstreamHintMiddleware)
+	if vpCfg.RateLimit != nil {
+		chain.Use(server.RateLimitMiddleware(server.NewRateLimiter(*vpCfg.RateLimit)))
+	}
+	if vpCfg.MaxResponseSize > 0 {
+		chain.Use(server.ResponseSizeLimitMiddleware(vpCfg.MaxResponseSize))
+	}
+	if vpCfg.MaxQueryTimeout > 0 {
+		chain.Use(server.QueryTimeoutLimitMiddleware(vpCfg.MaxQueryTimeout))
+	}
+	return chain.Then(v.handler(name, &vpCfg.PromxyConfig, storage)), nil
+}
+
+// Storage returns the storage of the named virtual proxy (nil if there is none)
+func (v *VirtualProxies) Storage(name string) *ProxyStorage {
+	return v.getState().storages[name]
+}
+
+// VirtualProxy returns the handler of the named virtual proxy (nil if there is
+// none), implementing server.VirtualProxyRouter
+func (v *VirtualProxies) VirtualProxy(name string) http.Handler {
+	return v.getState().handlers[name]
+}
+
+// TenantVirtualProxy returns the name of the virtual proxy of the tenant (empty if
+// there is none), implementing server.VirtualProxyRouter
+func (v *VirtualProxies) TenantVirtualProxy(tenant string) string {
+	if tenant == "" {
+		return ""
+	}
+	return v.getState().tenants[tenant]
+}
+
+// HasTenants returns whether any tenant belongs to the named virtual proxy,
+// implementing server.VirtualProxyRouter
+func (v *VirtualProxies) HasTenants(name string) bool {
+	return v.getState().tenanted[name]
+}
@@ -0,0 +1,320 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// The streamed remote read (prometheus 2.13+) came after the prompb we build
+// against, so its messages are declared here. They are wire compatible with those
+// of the remote.proto of prometheus.
+
+// The response types of a read request
+const (
+	responseTypeSamples           = 0
+	responseTypeStreamedXORChunks = 1
+)
+
+// chunkEncodingXOR is the encoding of the chunks of a streamed response
+const chunkEncodingXOR = 1
+
+// streamedContentType is the content type of streamed responses, endpoints which
+// don't stream respond with the samples as application/x-protobuf
+const streamedContentType = "application/x-streamed-protobuf"
+
+// maxFrameSize is the max size of a frame of a streamed response. Prometheus sends
+// frames of about 1MB, so this only guards against a corrupted size.
+const maxFrameSize = 50 * 1024 * 1024
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// readRequest is a prompb.ReadRequest with the response types the client accepts
+type readRequest struct {
+	Queries               []*prompb.Query `protobuf:"bytes,1,rep,name=queries,proto3"`
+	AcceptedResponseTypes []int32         `protobuf:"varint,2,rep,packed,name=accepted_response_types,proto3"`
+}
+
+func (m *readRequest) Reset()         { *m = readRequest{} }
+func (m *readRequest) String() string { return proto.CompactTextString(m) }
+func (*readRequest) ProtoMessage()    {}
+
+// chunkedReadResponse is a frame of a streamed response
+type chunkedReadResponse struct {
+	ChunkedSeries []*chunkedSeries `protobuf:"bytes,1,rep,name=chunked_series,proto3"`
+	QueryIndex    int64            `protobuf:"varint,2,opt,name=query_index,proto3"`
+}
+
+func (m *chunkedReadResponse) Reset()         { *m = chunkedReadResponse{} }
+func (m *chunkedReadResponse) String() string { return proto.CompactTextString(m) }
+func (*chunkedReadResponse) ProtoMessage()    {}
+
+// chunkedSeries is a series of a streamed response, a series too large for a
+// single frame continues in the next frames
+type chunkedSeries struct {
+	Labels []*prompb.Label `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Chunks []*chunk        `protobuf:"bytes,2,rep,name=chunks,proto3"`
+}
+
+func (m *chunkedSeries) Reset()         { *m = chunkedSeries{} }
+func (m *chunkedSeries) String() string { return proto.CompactTextString(m) }
+func (*chunkedSeries) ProtoMessage()    {}
+
+// chunk is an encoded chunk of the samples of a series
+type chunk struct {
+	MinTimeMs int64  `protobuf:"varint,1,opt,name=min_time_ms,proto3"`
+	MaxTimeMs int64  `protobuf:"varint,2,opt,name=max_time_ms,proto3"`
+	Type      int32  `protobuf:"varint,3,opt,name=type,proto3"`
+	Data      []byte `protobuf:"bytes,4,opt,name=data,proto3"`
+}
+
+func (m *chunk) Reset()         { *m = chunk{} }
+func (m *chunk) String() string { return proto.CompactTextString(m) }
+func (*chunk) ProtoMessage()    {}
+
+// ReadStream reads from a remote endpoint, asking for a streamed response whose
+// series are read one at a time (see SeriesStream). Endpoints which don't stream
+// respond with the whole result, whose series the SeriesStream returns all the
+// same. The SeriesStream must be closed.
+func (c *Client) ReadStream(ctx context.Context, query *prompb.Query) (*SeriesStream, error) {
+	req := &readRequest{
+		Queries:               []*prompb.Query{query},
+		AcceptedResponseTypes: []int32{responseTypeStreamedXORChunks, responseTypeSamples},
+	}
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to marshal read request: %v", err)
+	}
+
+	compressed := snappy.Encode(nil, data)
+	httpReq, err := http.NewRequest("POST", c.url.String(), bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create request: %v", err)
+	}
+	httpReq.Header.Add("Content-Encoding", "snappy")
+	httpReq.Header.Add("Accept-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("User-Agent", userAgent)
+	httpReq.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+
+	// The timeout covers reading the stream, so it is only canceled once the
+	// stream is closed
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	httpResp, err := c.client.Do(httpReq.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("error sending request: %v", err)
+	}
+	s := &SeriesStream{
+		body:   httpResp.Body,
+		cancel: cancel,
+		start:  query.StartTimestampMs,
+		end:    query.EndTimestampMs,
+	}
+	if httpResp.StatusCode/100 != 2 {
+		s.Close()
+		return nil, fmt.Errorf("server returned HTTP status %s", httpResp.Status)
+	}
+
+	if strings.HasPrefix(httpResp.Header.Get("Content-Type"), streamedContentType) {
+		s.r = bufio.NewReader(httpResp.Body)
+		return s, nil
+	}
+
+	defer s.Close()
+	result, err := readResponse(httpResp.Body, len(req.Queries))
+	if err != nil {
+		return nil, err
+	}
+	return &SeriesStream{pending: result.Timeseries, cancel: func() {}}, nil
+}
+
+// readResponse reads the (not streamed) response of a read request
+func readResponse(body io.Reader, queries int) (*prompb.QueryResult, error) {
+	compressed, err := ioutil.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	uncompressed, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, fmt.Errorf("error reading response: %v", err)
+	}
+
+	var resp prompb.ReadResponse
+	err = proto.Unmarshal(uncompressed, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal response body: %v", err)
+	}
+
+	if len(resp.Results) != queries {
+		return nil, fmt.Errorf("responses: want %d, got %d", queries, len(resp.Results))
+	}
+
+	return resp.Results[0], nil
+}
+
+// SeriesStream is the response of a read, whose series are decoded one frame of
+// the response at a time
+type SeriesStream struct {
+	body   io.ReadCloser
+	cancel context.CancelFunc
+	// r reads the frames of a streamed response, it is nil once they are all read
+	r *bufio.Reader
+	// start and end bound the samples of the chunks, which are sent whole
+	start, end int64
+	// pending are the series decoded but not returned yet
+	pending []*prompb.TimeSeries
+}
+
+// Next returns the next series of the stream, io.EOF once there are none left
+func (s *SeriesStream) Next() (*prompb.TimeSeries, error) {
+	if err := s.fill(); err != nil {
+		return nil, err
+	}
+	ts := s.pending[0]
+	s.pending = s.pending[1:]
+
+	// A series too large for a single frame continues in the next frames
+	for {
+		if err := s.fill(); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if !labelsEqual(s.pending[0].Labels, ts.Labels) {
+			break
+		}
+		ts.Samples = append(ts.Samples, s.pending[0].Samples...)
+		s.pending = s.pending[1:]
+	}
+	return ts, nil
+}
+
+// Close closes the response
+func (s *SeriesStream) Close() error {
+	s.cancel()
+	if s.body != nil {
+		return s.body.Close()
+	}
+	return nil
+}
+
+// fill decodes frames until there is a pending series, io.EOF if the stream has
+// none left
+func (s *SeriesStream) fill() error {
+	for len(s.pending) == 0 {
+		if s.r == nil {
+			return io.EOF
+		}
+		data, err := readFrame(s.r)
+		if err == io.EOF {
+			s.r = nil
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error reading response: %v", err)
+		}
+
+		var resp chunkedReadResponse
+		if err := proto.Unmarshal(data, &resp); err != nil {
+			return fmt.Errorf("unable to unmarshal response frame: %v", err)
+		}
+		for _, series := range resp.ChunkedSeries {
+			ts, err := s.timeSeries(series)
+			if err != nil {
+				return err
+			}
+			s.pending = append(s.pending, ts)
+		}
+	}
+	return nil
+}
+
+// timeSeries decodes the chunks of the series
+func (s *SeriesStream) timeSeries(series *chunkedSeries) (*prompb.TimeSeries, error) {
+	ts := &prompb.TimeSeries{Labels: make([]prompb.Label, len(series.Labels))}
+	for i, l := range series.Labels {
+		ts.Labels[i] = *l
+	}
+	for _, c := range series.Chunks {
+		if c.Type != chunkEncodingXOR {
+			return nil, fmt.Errorf("unsupported chunk encoding %d", c.Type)
+		}
+		chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+		if err != nil {
+			return nil, fmt.Errorf("error decoding chunk: %v", err)
+		}
+		it := chk.Iterator()
+		for it.Next() {
+			t, v := it.At()
+			if t < s.start || t > s.end {
+				continue
+			}
+			ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+		}
+		if err := it.Err(); err != nil {
+			return nil, fmt.Errorf("error decoding chunk: %v", err)
+		}
+	}
+	return ts, nil
+}
+
+// readFrame reads the next frame of a streamed response: the uvarint size of its
+// data, the big endian CRC32 (Castagnoli) of its data and its data. io.EOF is
+// returned once there are no frames left.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	size, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if size > maxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the max of %d", size, maxFrameSize)
+	}
+
+	var crc [4]byte
+	if _, err := io.ReadFull(r, crc[:]); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	if crc32.Checksum(data, castagnoliTable) != binary.BigEndian.Uint32(crc[:]) {
+		return nil, fmt.Errorf("frame checksum mismatch")
+	}
+	return data, nil
+}
+
+// unexpectedEOF returns io.ErrUnexpectedEOF for an io.EOF within a frame
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// labelsEqual returns whether the labels are the same
+func labelsEqual(a, b []prompb.Label) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Name != b[i].Name || a[i].Value != b[i].Value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	config_util "github.com/prometheus/common/config"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/tsdb/chunkenc"
+)
+
+// writeFrame writes the chunked series as a frame of a streamed response
+func writeFrame(t *testing.T, w io.Writer, series ...*chunkedSeries) {
+	data, err := proto.Marshal(&chunkedReadResponse{ChunkedSeries: series})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var header [binary.MaxVarintLen32 + 4]byte
+	n := binary.PutUvarint(header[:], uint64(len(data)))
+	binary.BigEndian.PutUint32(header[n:], crc32.Checksum(data, castagnoliTable))
+	w.Write(header[:n+4])
+	w.Write(data)
+}
+
+// xorChunk returns the chunk of the samples
+func xorChunk(t *testing.T, samples ...prompb.Sample) *chunk {
+	c := chunkenc.NewXORChunk()
+	app, err := c.Appender()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, s := range samples {
+		app.Append(s.Timestamp, s.Value)
+	}
+	return &chunk{
+		MinTimeMs: samples[0].Timestamp,
+		MaxTimeMs: samples[len(samples)-1].Timestamp,
+		Type:      chunkEncodingXOR,
+		Data:      c.Bytes(),
+	}
+}
+
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, func()) {
+	srv := httptest.NewServer(handler)
+	u, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err := NewClient(0, &ClientConfig{URL: &config_util.URL{u}, Timeout: model.Duration(time.Minute)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return c, srv.Close
+}
+
+// readAll returns the series of the stream
+func readAll(t *testing.T, s *SeriesStream) []*prompb.TimeSeries {
+	defer s.Close()
+	var series []*prompb.TimeSeries
+	for {
+		ts, err := s.Next()
+		if err == io.EOF {
+			return series
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		series = append(series, ts)
+	}
+}
+
+func TestReadStream(t *testing.T) {
+	a := []prompb.Label{{Name: "job", Value: "a"}}
+	b := []prompb.Label{{Name: "job", Value: "b"}}
+
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		compressed, _ := ioutil.ReadAll(r.Body)
+		data, _ := snappy.Decode(nil, compressed)
+		var req readRequest
+		if err := proto.Unmarshal(data, &req); err != nil || len(req.AcceptedResponseTypes) == 0 || req.AcceptedResponseTypes[0] != responseTypeStreamedXORChunks {
+			http.Error(w, "streamed response not accepted", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", streamedContentType+"; proto=prometheus.ChunkedReadResponse")
+		// The series of a are split across two frames, and their first sample is
+		// before the start of the query
+		writeFrame(t, w, &chunkedSeries{
+			Labels: []*prompb.Label{{Name: "job", Value: "a"}},
+			Chunks: []*chunk{xorChunk(t, prompb.Sample{Timestamp: 500, Value: 1}, prompb.Sample{Timestamp: 1000, Value: 2})},
+		})
+		writeFrame(t, w, &chunkedSeries{
+			Labels: []*prompb.Label{{Name: "job", Value: "a"}},
+			Chunks: []*chunk{xorChunk(t, prompb.Sample{Timestamp: 2000, Value: 3})},
+		}, &chunkedSeries{
+			Labels: []*prompb.Label{{Name: "job", Value: "b"}},
+			Chunks: []*chunk{xorChunk(t, prompb.Sample{Timestamp: 1000, Value: 4})},
+		})
+	})
+	defer done()
+
+	s, err := c.ReadStream(context.TODO(), &prompb.Query{StartTimestampMs: 1000, EndTimestampMs: 2000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []*prompb.TimeSeries{
+		{Labels: a, Samples: []prompb.Sample{{Timestamp: 1000, Value: 2}, {Timestamp: 2000, Value: 3}}},
+		{Labels: b, Samples: []prompb.Sample{{Timestamp: 1000, Value: 4}}},
+	}
+	if actual := readAll(t, s); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("mismatch in series expected=%v actual=%v", expected, actual)
+	}
+}
+
+func TestReadStreamSamples(t *testing.T) {
+	// Endpoints which don't stream respond with all the samples at once
+	expected := []*prompb.TimeSeries{
+		{Labels: []prompb.Label{{Name: "job", Value: "a"}}, Samples: []prompb.Sample{{Timestamp: 1000, Value: 1}}},
+	}
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := proto.Marshal(&prompb.ReadResponse{Results: []*prompb.QueryResult{{Timeseries: expected}}})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(snappy.Encode(nil, data))
+	})
+	defer done()
+
+	s, err := c.ReadStream(context.TODO(), &prompb.Query{StartTimestampMs: 1000, EndTimestampMs: 2000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual := readAll(t, s); !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("mismatch in series expected=%v actual=%v", expected, actual)
+	}
+}
+
+func TestReadStreamCorruptFrame(t *testing.T) {
+	c, done := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", streamedContentType)
+		// A frame whose checksum doesn't match its data
+		w.Write([]byte{3, 0, 0, 0, 0, 1, 2, 3})
+	})
+	defer done()
+
+	s, err := c.ReadStream(context.TODO(), &prompb.Query{StartTimestampMs: 1000, EndTimestampMs: 2000})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer s.Close()
+	if _, err := s.Next(); err == nil || err == io.EOF {
+		t.Fatalf("mismatch in error expected=checksum mismatch actual=%v", err)
+	}
+}
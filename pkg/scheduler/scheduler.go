@@ -0,0 +1,157 @@
+// Package scheduler runs the periodic background jobs of promproxy, e.g. cache
+// refreshes. Bare tickers started together (as after a restart) fire in lockstep,
+// so the jobs of all downstreams hit them at once. The scheduler instead spreads
+// the jobs over their interval by a jitter derived from the name of their
+// downstream. The jitter is deterministic, so a job fires at the same point of its
+// interval across restarts and replicas.
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/promproxy/pkg/metrics"
+)
+
+var scheduleSkew = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: metrics.Namespace,
+	Name:      "scheduler_skew_seconds",
+	Help:      "Delay of the execution of background jobs after their scheduled time",
+	Buckets:   []float64{.001, .01, .1, 1, 10, 60},
+}, []string{"job"})
+
+func init() {
+	metrics.MustRegister(scheduleSkew)
+}
+
+// Job is a periodic background job
+type Job struct {
+	// Name identifies the job, e.g. in the metrics
+	Name string
+	// Downstream is the name of the downstream the job talks to, its jitter is
+	// derived from it
+	Downstream string
+	// Interval is how often the job runs
+	Interval time.Duration
+	// Offset shifts the schedule of the job, e.g. to align it after another job
+	Offset time.Duration
+	// RunAtStart runs the job once it's registered, before its first scheduled time
+	RunAtStart bool
+	// Run runs the job, its context is done once the job is stopped
+	Run func(ctx context.Context)
+}
+
+// Validate returns an error if the job can't be scheduled
+func (j Job) Validate() error {
+	if j.Interval <= 0 {
+		return fmt.Errorf("interval of job %q must be positive", j.Name)
+	}
+	if j.Run == nil {
+		return fmt.Errorf("job %q has nothing to run", j.Name)
+	}
+	return nil
+}
+
+// Jitter returns the deterministic jitter of the downstream within the interval
+func Jitter(downstream string, interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(downstream))
+	return time.Duration(h.Sum64() % uint64(interval))
+}
+
+// next returns the first scheduled time of the job after now. The scheduled times
+// are aligned to the interval (since the epoch), shifted by the offset and jitter.
+func (j Job) next(now time.Time) time.Time {
+	phase := (j.Offset + Jitter(j.Downstream, j.Interval)) % j.Interval
+	wait := (phase - time.Duration(now.UnixNano())%j.Interval) % j.Interval
+	if wait <= 0 {
+		wait += j.Interval
+	}
+	return now.Add(wait)
+}
+
+// New returns a Scheduler
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{ctx: ctx, cancel: cancel, now: time.Now}
+}
+
+// DefaultScheduler is the Scheduler of the background jobs of promproxy
+var DefaultScheduler = New()
+
+// Scheduler runs the registered jobs on their schedule until they are stopped, or
+// the Scheduler is
+type Scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	now    func() time.Time
+
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// Register runs the job on its schedule, until the returned func is called (which
+// waits for a running execution of the job to return) or the Scheduler is stopped
+func (s *Scheduler) Register(job Job) (func(), error) {
+	if err := job.Validate(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopped {
+		return func() {}, nil
+	}
+
+	ctx, cancel := context.WithCancel(s.ctx)
+	done := make(chan struct{})
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer close(done)
+		s.run(ctx, job)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}, nil
+}
+
+// run runs the job on its schedule until the context is done
+func (s *Scheduler) run(ctx context.Context, job Job) {
+	if job.RunAtStart {
+		job.Run(ctx)
+	}
+	for {
+		now := s.now()
+		scheduled := job.next(now)
+		timer := time.NewTimer(scheduled.Sub(now))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		scheduleSkew.WithLabelValues(job.Name).Observe(s.now().Sub(scheduled).Seconds())
+		job.Run(ctx)
+	}
+}
+
+// Stop stops all jobs, waiting for their running executions to return. Jobs
+// registered afterwards aren't run.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.cancel()
+	s.wg.Wait()
+}
@@ -0,0 +1,112 @@
+package scheduler
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJobNext(t *testing.T) {
+	interval := time.Minute
+	jitter := Jitter("prom-a", interval)
+
+	tests := []struct {
+		name     string
+		job      Job
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "aligned",
+			job:      Job{Interval: interval},
+			now:      time.Unix(90, 0),
+			expected: time.Unix(120, 0),
+		},
+		// A job scheduled right now runs in the next interval
+		{
+			name:     "on schedule",
+			job:      Job{Interval: interval},
+			now:      time.Unix(120, 0),
+			expected: time.Unix(180, 0),
+		},
+		{
+			name:     "offset",
+			job:      Job{Interval: interval, Offset: 15 * time.Second},
+			now:      time.Unix(90, 0),
+			expected: time.Unix(135, 0),
+		},
+		{
+			name:     "negative offset",
+			job:      Job{Interval: interval, Offset: -15 * time.Second},
+			now:      time.Unix(90, 0),
+			expected: time.Unix(105, 0),
+		},
+		{
+			name:     "jitter",
+			job:      Job{Interval: interval, Downstream: "prom-a"},
+			now:      time.Unix(120, 0),
+			expected: time.Unix(120, 0).Add(jitter),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if next := test.job.next(test.now); !next.Equal(test.expected) {
+				t.Fatalf("mismatch in next expected=%v actual=%v", test.expected, next)
+			}
+		})
+	}
+}
+
+func TestJitter(t *testing.T) {
+	interval := time.Minute
+	if a, b := Jitter("prom-a", interval), Jitter("prom-a", interval); a != b {
+		t.Fatalf("mismatch in jitter expected=%v actual=%v", a, b)
+	}
+	if a, b := Jitter("prom-a", interval), Jitter("prom-b", interval); a == b {
+		t.Fatalf("downstreams have the same jitter %v", a)
+	}
+	if j := Jitter("prom-a", interval); j < 0 || j >= interval {
+		t.Fatalf("jitter %v is outside of the interval", j)
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	s := New()
+
+	var runs int32
+	job := Job{
+		Name:       "test",
+		Interval:   time.Millisecond,
+		RunAtStart: true,
+		Run:        func(ctx context.Context) { atomic.AddInt32(&runs, 1) },
+	}
+	stop, err := s.Register(job)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := s.Register(Job{Name: "invalid", Run: job.Run}); err == nil {
+		t.Fatalf("missing error for a job without interval")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&runs) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("job didn't run on its schedule")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Stopping the job and the scheduler waits for the running executions
+	stop()
+	s.Stop()
+	stopped := atomic.LoadInt32(&runs)
+	if _, err := s.Register(job); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if r := atomic.LoadInt32(&runs); r != stopped {
+		t.Fatalf("mismatch in runs after stop expected=%d actual=%d", stopped, r)
+	}
+}
@@ -0,0 +1,377 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/logging"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// LogLevelHandler serves the runtime log level endpoint. A GET returns the current
+// effective levels of all components. A POST sets the `level` of a `component`
+// (or the base level if no component is given), this is reverted after `duration`
+// which defaults to `defaultDuration` (0 meaning the change is permanent)
+func LogLevelHandler(defaultDuration time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			respond(w, logging.Levels(), nil)
+			return
+		case http.MethodPost, http.MethodPut:
+		default:
+			respondMethodNotAllowed(w, r, http.MethodGet, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		levelStr, apiErr := requiredParam(r, "level")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		level, err := logrus.ParseLevel(levelStr)
+		if err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+
+		d := defaultDuration
+		if durationStr := r.FormValue("duration"); durationStr != "" {
+			d, err = parseDuration(durationStr)
+			if err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"duration\"")), nil)
+				return
+			}
+		}
+
+		component := r.FormValue("component")
+		if err := logging.SetLevel(component, level, d); err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"target":   component,
+			"level":    level,
+			"duration": d,
+		}).Info("Log level changed")
+
+		respond(w, logging.Levels(), nil)
+	}
+}
+
+// ConfigReloader reloads the config, returning the diff from the previous config
+type ConfigReloader interface {
+	Reload() ([]string, error)
+}
+
+// reloadData is the data section of a reload response
+type reloadData struct {
+	Diff []string `json:"diff"`
+}
+
+// ReloadHandler serves the config reload endpoint, returning the diff of the
+// config. If the new config is invalid the errors are returned and the current
+// config is left in place. As this changes the running config it should only be
+// exposed at one of the admin paths (see AuthMiddleware)
+func ReloadHandler(reloader ConfigReloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		diff, err := reloader.Reload()
+		if err != nil {
+			logger.Errorf("Error reloading config: %v", err)
+			if diff == nil {
+				respondError(w, badData(errors.Wrap(err, "invalid config")), nil)
+			} else {
+				respondError(w, &apiError{promutil.ErrorInternal, err}, nil)
+			}
+			return
+		}
+		logger.WithField("changes", len(diff)).Info("Reloaded config")
+		respond(w, &reloadData{Diff: diff}, nil)
+	}
+}
+
+// upstreamData is the data section of an upstream health response
+type upstreamData struct {
+	Disabled []string `json:"disabled"`
+}
+
+// upstreamName returns the {name} of an /admin/upstream/{name}/{action} path
+func upstreamName(path, action string) (string, *apiError) {
+	const prefix = "/admin/upstream/"
+	suffix := "/" + action
+	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, suffix) || len(path) <= len(prefix)+len(suffix) {
+		return "", badData(fmt.Errorf("invalid path %q, expected %s{name}%s", path, prefix, suffix))
+	}
+	return path[len(prefix) : len(path)-len(suffix)], nil
+}
+
+// upstreamHealthHandler serves /admin/upstream/{name}/{action}, calling set with the
+// name of the upstream
+func upstreamHealthHandler(monitor *promclient.HealthMonitor, action string, set func(string) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		name, apiErr := upstreamName(r.URL.Path, action)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		if err := set(name); err != nil {
+			if _, ok := err.(promclient.ErrUnknownUpstream); ok {
+				respondError(w, &apiError{promutil.ErrorNotFound, err}, nil)
+			} else {
+				respondError(w, &apiError{promutil.ErrorInternal, err}, nil)
+			}
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"upstream": name,
+			"action":   action,
+		}).Warn("Upstream health changed")
+
+		respond(w, &upstreamData{Disabled: monitor.Disabled()}, nil)
+	}
+}
+
+// DisableUpstreamHandler serves /admin/upstream/{name}/disable, forcing the upstream
+// into the unhealthy state so requests are served by the remaining upstreams. This
+// lasts until the upstream is enabled again (or promxy is restarted)
+func DisableUpstreamHandler(monitor *promclient.HealthMonitor) http.HandlerFunc {
+	return upstreamHealthHandler(monitor, "disable", monitor.Disable)
+}
+
+// EnableUpstreamHandler serves /admin/upstream/{name}/enable, returning a disabled
+// upstream to the healthy state
+func EnableUpstreamHandler(monitor *promclient.HealthMonitor) http.HandlerFunc {
+	return upstreamHealthHandler(monitor, "enable", monitor.Enable)
+}
+
+// circuitBreakerData is the data section of a circuit breaker reset response
+type circuitBreakerData struct {
+	Upstream string                  `json:"upstream"`
+	State    promclient.CircuitState `json:"state"`
+}
+
+// ResetCircuitBreakerHandler serves /admin/upstream/{name}/circuit_breaker/reset,
+// closing the circuit breaker of the upstream without waiting for it to be half-open
+// (e.g. once the upstream is known to have recovered)
+func ResetCircuitBreakerHandler(breakers *promclient.CircuitBreakers) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		name, apiErr := upstreamName(r.URL.Path, "circuit_breaker/reset")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		cb, ok := breakers.Get(name)
+		if !ok {
+			respondError(w, &apiError{promutil.ErrorNotFound, fmt.Errorf("no circuit breaker for upstream %q", name)}, nil)
+			return
+		}
+		previous := cb.State()
+		cb.ForceClose()
+		logger.WithFields(logrus.Fields{
+			"upstream": name,
+			"previous": previous,
+		}).Warn("Circuit breaker reset")
+
+		respond(w, &circuitBreakerData{Upstream: name, State: cb.State()}, nil)
+	}
+}
+
+// RetryBudgetsHandler serves the status of the retry budgets of the upstreams
+// (e.g. at /status/retry_budgets), i.e. how many retries each has left and how many
+// were skipped as its budget was exhausted
+func RetryBudgetsHandler(budgets *promclient.RetryBudgets) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondMethodNotAllowed(w, r, http.MethodGet)
+			return
+		}
+		respond(w, budgets.Status(), nil)
+	}
+}
+
+// auditLog returns the logger recording an admin action, with the client which
+// made the request
+func auditLog(r *http.Request, action string) *logrus.Entry {
+	fields := logrus.Fields{
+		"audit":       true,
+		"action":      action,
+		"path":        r.URL.Path,
+		"remote_addr": r.RemoteAddr,
+	}
+	if id := IdentityFromContext(r.Context()); id != nil {
+		fields["user"] = id.Name
+		fields["auth_method"] = id.Method
+	}
+	return logger.WithFields(fields)
+}
+
+// reprobeResult is the state of an upstream after it was re-probed
+type reprobeResult struct {
+	Upstream     string                      `json:"upstream"`
+	Capabilities promclient.CapabilityStatus `json:"capabilities"`
+	// Healthy is whether the probe succeeded and the upstream isn't disabled
+	Healthy        bool                          `json:"healthy"`
+	CircuitBreaker promclient.CircuitState       `json:"circuit_breaker,omitempty"`
+	RetryBudget    *promclient.RetryBudgetStatus `json:"retry_budget,omitempty"`
+}
+
+// ReprobeUpstreamsHandler serves /admin/upstream/{name}/reprobe for a single upstream
+// and /admin/upstreams/reprobe for all of them. The capabilities of the upstreams are
+// probed again and their circuit breakers and retry budgets are reset, rather than
+// waiting for the state of the previous version to expire after an upstream was
+// upgraded in place. The fresh state is returned once all probes are done, repeating
+// the request is harmless.
+func ReprobeUpstreamsHandler(probes *promclient.CapabilityProbes, monitor *promclient.HealthMonitor, breakers *promclient.CircuitBreakers, budgets *promclient.RetryBudgets) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		var names []string
+		if r.URL.Path == "/admin/upstreams/reprobe" {
+			names = probes.Names()
+		} else {
+			name, apiErr := upstreamName(r.URL.Path, "reprobe")
+			if apiErr != nil {
+				respondError(w, apiErr, nil)
+				return
+			}
+			names = []string{name}
+		}
+
+		results := make([]reprobeResult, 0, len(names))
+		for _, name := range names {
+			status, err := probes.Probe(r.Context(), name)
+			if err != nil {
+				respondError(w, &apiError{promutil.ErrorNotFound, err}, nil)
+				return
+			}
+			result := reprobeResult{
+				Upstream:     name,
+				Capabilities: status,
+				Healthy:      status.Error == "" && monitor.Healthy(name),
+			}
+			if cb, ok := breakers.Get(name); ok {
+				cb.ForceClose()
+				result.CircuitBreaker = cb.State()
+			}
+			if budget, ok := budgets.Reset(name); ok {
+				result.RetryBudget = &budget
+			}
+			results = append(results, result)
+
+			auditLog(r, "reprobe").WithFields(logrus.Fields{
+				"upstream": name,
+				"version":  status.Version,
+				"healthy":  result.Healthy,
+			}).Warn("Upstream re-probed")
+		}
+
+		respond(w, results, nil)
+	}
+}
+
+// DefaultFaultDuration is how long a fault set through FaultInjectionHandler lasts
+// if no duration is given
+const DefaultFaultDuration = 5 * time.Minute
+
+// FaultInjectionHandler serves the fault injection endpoint (e.g. at
+// /admin/fault_injection) for resilience drills. A GET returns the active faults. A
+// POST sets the fault of an `upstream` and `method` (empty matching all), with the
+// `latency`, `error_rate`, `truncate_rate` and `warning` to inject, which expires
+// after `duration` (DefaultFaultDuration if unset, at most
+// promclient.MaxFaultDuration). A DELETE clears the fault of the `upstream` and
+// `method`. Faults are only injected into servergroups with fault_injection set.
+func FaultInjectionHandler(injector *promclient.FaultInjector) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			respond(w, injector.Faults(), nil)
+			return
+		case http.MethodDelete:
+			upstream, method := r.FormValue("upstream"), r.FormValue("method")
+			injector.Clear(upstream, method)
+			auditLog(r, "fault_injection").WithFields(logrus.Fields{
+				"upstream": upstream,
+				"method":   method,
+			}).Warn("Fault cleared")
+			respond(w, injector.Faults(), nil)
+			return
+		case http.MethodPost, http.MethodPut:
+		default:
+			respondMethodNotAllowed(w, r, http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete)
+			return
+		}
+
+		fault := promclient.Fault{
+			Upstream: r.FormValue("upstream"),
+			Method:   r.FormValue("method"),
+			Warning:  r.FormValue("warning"),
+		}
+		var err error
+		if v := r.FormValue("latency"); v != "" {
+			if fault.Latency, err = parseDuration(v); err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"latency\"")), nil)
+				return
+			}
+		}
+		if v := r.FormValue("error_rate"); v != "" {
+			if fault.ErrorRate, err = strconv.ParseFloat(v, 64); err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"error_rate\"")), nil)
+				return
+			}
+		}
+		if v := r.FormValue("truncate_rate"); v != "" {
+			if fault.TruncateRate, err = strconv.ParseFloat(v, 64); err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"truncate_rate\"")), nil)
+				return
+			}
+		}
+		d := DefaultFaultDuration
+		if v := r.FormValue("duration"); v != "" {
+			if d, err = parseDuration(v); err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"duration\"")), nil)
+				return
+			}
+		}
+
+		fault, err = injector.Set(fault, d)
+		if err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+		auditLog(r, "fault_injection").WithFields(logrus.Fields{
+			"upstream":      fault.Upstream,
+			"method":        fault.Method,
+			"latency":       fault.Latency,
+			"error_rate":    fault.ErrorRate,
+			"truncate_rate": fault.TruncateRate,
+			"expires":       fault.Expires,
+		}).Warn("Fault set")
+
+		respond(w, injector.Faults(), nil)
+	}
+}
@@ -0,0 +1,271 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// countingAPI counts the queries it receives
+type countingAPI struct {
+	stubAPI
+	queries int32
+}
+
+func (c *countingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	atomic.AddInt32(&c.queries, 1)
+	return model.Vector{}, nil, nil
+}
+
+func TestDisableUpstreamHandler(t *testing.T) {
+	monitor := promclient.NewHealthMonitor()
+	upstreams := map[string]*countingAPI{"a:9090": {}, "b:9090": {}, "c:9090": {}}
+	apis := make([]promclient.API, 0, len(upstreams))
+	for _, name := range []string{"a:9090", "b:9090", "c:9090"} {
+		apis = append(apis, monitor.Wrap(name, upstreams[name]))
+	}
+	multi := promclient.NewMultiAPI(apis, 0, nil, 1)
+
+	query := func() {
+		if _, _, err := multi.Query(context.TODO(), "up", time.Now()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	expectQueries := func(expected map[string]int32) {
+		for name, count := range expected {
+			if actual := atomic.LoadInt32(&upstreams[name].queries); actual != count {
+				t.Fatalf("mismatch in queries to %s expected=%d actual=%d", name, count, actual)
+			}
+		}
+	}
+	do := func(h http.Handler, method, path string) int {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+
+	query()
+	expectQueries(map[string]int32{"a:9090": 1, "b:9090": 1, "c:9090": 1})
+
+	disable := DisableUpstreamHandler(monitor)
+	if code := do(disable, "POST", "/admin/upstream/b:9090/disable"); code != http.StatusOK {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusOK, code)
+	}
+	query()
+	expectQueries(map[string]int32{"a:9090": 2, "b:9090": 1, "c:9090": 2})
+
+	if code := do(EnableUpstreamHandler(monitor), "POST", "/admin/upstream/b:9090/enable"); code != http.StatusOK {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusOK, code)
+	}
+	query()
+	expectQueries(map[string]int32{"a:9090": 3, "b:9090": 2, "c:9090": 3})
+
+	// Invalid requests
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/admin/upstream/b:9090/disable", http.StatusMethodNotAllowed},
+		{"POST", "/admin/upstream/unknown:9090/disable", http.StatusNotFound},
+		{"POST", "/admin/upstream//disable", http.StatusBadRequest},
+		{"POST", "/admin/upstream/b:9090/enable", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		if code := do(disable, test.method, test.path); code != test.code {
+			t.Fatalf("mismatch in code for %s %s expected=%d actual=%d", test.method, test.path, test.code, code)
+		}
+	}
+	if disabled := monitor.Disabled(); len(disabled) != 0 {
+		t.Fatalf("unexpected disabled upstreams: %v", disabled)
+	}
+}
+
+// failingAPI fails queries with an unavailable error while failing is set
+type failingAPI struct {
+	stubAPI
+	failing bool
+}
+
+func (f *failingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if f.failing {
+		return nil, nil, &url.Error{Op: "Get", URL: "http://a:9090/api/v1/query", Err: errors.New("connection refused")}
+	}
+	return model.Vector{}, nil, nil
+}
+
+func TestResetCircuitBreakerHandler(t *testing.T) {
+	breakers := promclient.NewCircuitBreakers()
+	mock := &failingAPI{}
+	cb := breakers.Wrap("a:9090", mock, promclient.CircuitBreakerConfig{FailureThreshold: 3, OpenDuration: time.Hour})
+
+	// Trip the breaker while the upstream is down
+	mock.failing = true
+	for i := 0; i < 3; i++ {
+		cb.Query(context.TODO(), "up", time.Now())
+	}
+	if state := cb.State(); state != promclient.CircuitOpen {
+		t.Fatalf("mismatch in state expected=%s actual=%s", promclient.CircuitOpen, state)
+	}
+
+	// The upstream recovered, but the open circuit still rejects queries
+	mock.failing = false
+	if _, _, err := cb.Query(context.TODO(), "up", time.Now()); err == nil {
+		t.Fatalf("expected error from open circuit")
+	}
+
+	h := ResetCircuitBreakerHandler(breakers)
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/admin/upstream/a:9090/circuit_breaker/reset", http.StatusMethodNotAllowed},
+		{"POST", "/admin/upstream/b:9090/circuit_breaker/reset", http.StatusNotFound},
+		{"POST", "/admin/upstream/a:9090/circuit_breaker/reset", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.code {
+			t.Fatalf("mismatch in code for %s %s expected=%d actual=%d", test.method, test.path, test.code, w.Code)
+		}
+	}
+
+	if state := cb.State(); state != promclient.CircuitClosed {
+		t.Fatalf("mismatch in state expected=%s actual=%s", promclient.CircuitClosed, state)
+	}
+	if _, _, err := cb.Query(context.TODO(), "up", time.Now()); err != nil {
+		t.Fatalf("unexpected error after reset: %v", err)
+	}
+}
+
+func TestRetryBudgetsHandler(t *testing.T) {
+	budgets := promclient.NewRetryBudgets()
+	b := budgets.Get("a:9090", promclient.RetryBudgetConfig{Max: 1})
+	b.Retry()
+	b.Retry()
+
+	h := RetryBudgetsHandler(budgets)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/status/retry_budgets", nil))
+	if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET" {
+		t.Fatalf("mismatch in code expected=%d (Allow: GET) actual=%d (Allow: %s)", http.StatusMethodNotAllowed, w.Code, w.Header().Get("Allow"))
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/status/retry_budgets", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusOK, w.Code)
+	}
+	var resp struct {
+		Data []promclient.RetryBudgetStatus `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error unmarshaling response: %v", err)
+	}
+	expected := []promclient.RetryBudgetStatus{{Upstream: "a:9090", Tokens: 0, Max: 1, Denied: 1}}
+	if !reflect.DeepEqual(resp.Data, expected) {
+		t.Fatalf("mismatch in status expected=%v actual=%v", expected, resp.Data)
+	}
+}
+
+func TestReprobeUpstreamsHandler(t *testing.T) {
+	version := "2.40.0"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"status":"success","data":{"version":%q}}`, version)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	probes := promclient.NewCapabilityProbes()
+	probe := probes.Get("a:9090", srv.Client(), u)
+	monitor := promclient.NewHealthMonitor()
+	monitor.Wrap("a:9090", &stubAPI{})
+	breakers := promclient.NewCircuitBreakers()
+	cb := breakers.Wrap("a:9090", &failingAPI{}, promclient.CircuitBreakerConfig{FailureThreshold: 1, OpenDuration: time.Hour})
+	cb.ForceOpen()
+	budgets := promclient.NewRetryBudgets()
+	budgets.Get("a:9090", promclient.RetryBudgetConfig{Max: 2}).Retry()
+
+	// The capabilities of the old version are kept until the upstream is re-probed
+	if probe.SeriesLimit(context.TODO()) {
+		t.Fatalf("unexpected series limit support of %s", version)
+	}
+	version = "2.51.0"
+	if probe.SeriesLimit(context.TODO()) {
+		t.Fatalf("series limit support changed without a re-probe")
+	}
+
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	h := ReprobeUpstreamsHandler(probes, monitor, breakers, budgets)
+	tests := []struct {
+		method string
+		path   string
+		code   int
+	}{
+		{"GET", "/admin/upstream/a:9090/reprobe", http.StatusMethodNotAllowed},
+		{"POST", "/admin/upstream/b:9090/reprobe", http.StatusNotFound},
+		{"POST", "/admin/upstream/a:9090/reprobe", http.StatusOK},
+		// Repeating the re-probe is harmless
+		{"POST", "/admin/upstream/a:9090/reprobe", http.StatusOK},
+		{"POST", "/admin/upstreams/reprobe", http.StatusOK},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+		if w.Code != test.code {
+			t.Fatalf("mismatch in code for %s %s expected=%d actual=%d", test.method, test.path, test.code, w.Code)
+		}
+		if w.Code != http.StatusOK {
+			continue
+		}
+
+		var resp struct {
+			Data []reprobeResult `json:"data"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+			t.Fatalf("error unmarshaling response: %v", err)
+		}
+		if len(resp.Data) != 1 {
+			t.Fatalf("mismatch in results expected=%d actual=%d", 1, len(resp.Data))
+		}
+		result := resp.Data[0]
+		if result.Upstream != "a:9090" || result.Capabilities.Version != "2.51.0" || !result.Capabilities.SeriesLimit || !result.Healthy {
+			t.Fatalf("mismatch in re-probed upstream: %+v", result)
+		}
+		if result.CircuitBreaker != promclient.CircuitClosed {
+			t.Fatalf("mismatch in circuit breaker expected=%s actual=%s", promclient.CircuitClosed, result.CircuitBreaker)
+		}
+		if result.RetryBudget == nil || result.RetryBudget.Tokens != 2 {
+			t.Fatalf("mismatch in retry budget expected=%v actual=%+v", 2, result.RetryBudget)
+		}
+	}
+
+	if !probe.SeriesLimit(context.TODO()) {
+		t.Fatalf("missing series limit support after the re-probe")
+	}
+	if !strings.Contains(buf.String(), "Upstream re-probed") || !strings.Contains(buf.String(), "audit=true") {
+		t.Fatalf("missing audit log of the re-probe: %s", buf.String())
+	}
+}
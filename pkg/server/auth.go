@@ -0,0 +1,279 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	config_util "github.com/prometheus/common/config"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// AuthConfig configures how requests are authenticated. With no options set
+// requests are not authenticated at all.
+type AuthConfig struct {
+	// BasicAuthUsers maps usernames to the bcrypt hash of their password
+	BasicAuthUsers map[string]config_util.Secret `yaml:"basic_auth_users"`
+	// BearerTokens maps static bearer tokens to the identity they authenticate as
+	BearerTokens BearerTokens `yaml:"bearer_tokens"`
+	// JWKSURL (if set) allows bearer tokens which are RS256 JWTs signed by one of
+	// the keys from this URL, authenticating as the subject of the token. The
+	// tokens must be issued by JWTIssuer for JWTAudience (both required with a
+	// JWKSURL) and expire
+	JWKSURL     string `yaml:"jwks_url"`
+	JWTAudience string `yaml:"jwt_audience"`
+	JWTIssuer   string `yaml:"jwt_issuer"`
+	// RequireClientCert requires a TLS client certificate, whose subject common
+	// name must match one of the AllowedSubjects (if there are any)
+	RequireClientCert bool     `yaml:"require_client_cert"`
+	AllowedSubjects   []string `yaml:"allowed_subjects"`
+}
+
+// Enabled returns whether the config authenticates requests at all
+func (c *AuthConfig) Enabled() bool {
+	return len(c.BasicAuthUsers) > 0 || len(c.BearerTokens) > 0 || c.JWKSURL != "" || c.RequireClientCert
+}
+
+// BearerTokens maps static bearer tokens to the identity they authenticate as
+type BearerTokens map[string]string
+
+// MarshalYAML implements the yaml.Marshaler interface for BearerTokens. The tokens
+// are secrets (see config_util.Secret), so only the identities are marshaled.
+func (b BearerTokens) MarshalYAML() (interface{}, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+	names := make([]string, 0, len(b))
+	for _, name := range b {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	redacted := make(map[string]string, len(b))
+	for i, name := range names {
+		redacted[fmt.Sprintf("<secret %d>", i)] = name
+	}
+	return redacted, nil
+}
+
+// ServerAuthConfig configures the authentication of the proxy's own endpoints
+type ServerAuthConfig struct {
+	AuthConfig `yaml:",inline"`
+	// Admin (if set) replaces the requirements for the admin endpoints
+	// (/admin/*, /api/v1/admin/* and /-/reload), which generally should be stricter
+	Admin *AuthConfig `yaml:"admin"`
+}
+
+// Identity is an authenticated client
+type Identity struct {
+	// Name is the user, token identity, token subject or certificate common name
+	Name string
+	// Method is how the client was authenticated
+	Method string
+}
+
+type identityKey struct{}
+
+// IdentityFromContext returns the authenticated Identity of the request (if there is one)
+func IdentityFromContext(ctx context.Context) *Identity {
+	id, _ := ctx.Value(identityKey{}).(*Identity)
+	return id
+}
+
+// authenticator authenticates requests against a single AuthConfig
+type authenticator struct {
+	cfg             AuthConfig
+	allowedSubjects []*regexp.Regexp
+	jwks            *jwksVerifier
+}
+
+func newAuthenticator(cfg AuthConfig) (*authenticator, error) {
+	a := &authenticator{cfg: cfg}
+	for _, subject := range cfg.AllowedSubjects {
+		re, err := regexp.Compile("^(?:" + subject + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowed subject %q: %v", subject, err)
+		}
+		a.allowedSubjects = append(a.allowedSubjects, re)
+	}
+	if cfg.JWKSURL != "" {
+		// Without them any token signed by the IdP would do, whoever it was for
+		if cfg.JWTAudience == "" || cfg.JWTIssuer == "" {
+			return nil, fmt.Errorf("jwt_audience and jwt_issuer are required with a jwks_url")
+		}
+		a.jwks = newJWKSVerifier(cfg.JWKSURL, cfg.JWTAudience, cfg.JWTIssuer)
+	}
+	return a, nil
+}
+
+// credentialsRequired returns whether the request must carry basic auth or a bearer token
+func (a *authenticator) credentialsRequired() bool {
+	return len(a.cfg.BasicAuthUsers) > 0 || len(a.cfg.BearerTokens) > 0 || a.jwks != nil
+}
+
+// authenticate returns the identity of the request, nil if authentication isn't
+// required and there is no identity
+func (a *authenticator) authenticate(r *http.Request) (*Identity, *apiError) {
+	var certIdentity *Identity
+	if a.cfg.RequireClientCert {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return nil, &apiError{promutil.ErrorUnauthorized, fmt.Errorf("client certificate required")}
+		}
+		subject := r.TLS.PeerCertificates[0].Subject.CommonName
+		if !a.subjectAllowed(subject) {
+			return nil, &apiError{promutil.ErrorForbidden, fmt.Errorf("client certificate subject %q not allowed", subject)}
+		}
+		certIdentity = &Identity{Name: subject, Method: "client_cert"}
+	}
+
+	if !a.credentialsRequired() {
+		return certIdentity, nil
+	}
+
+	if user, password, ok := r.BasicAuth(); ok {
+		hash, ok := a.cfg.BasicAuthUsers[user]
+		if !ok || bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) != nil {
+			return nil, &apiError{promutil.ErrorUnauthorized, fmt.Errorf("invalid username or password")}
+		}
+		return &Identity{Name: user, Method: "basic_auth"}, nil
+	}
+
+	if token := bearerToken(r); token != "" {
+		for staticToken, name := range a.cfg.BearerTokens {
+			if subtle.ConstantTimeCompare([]byte(staticToken), []byte(token)) == 1 {
+				return &Identity{Name: name, Method: "bearer_token"}, nil
+			}
+		}
+		if a.jwks != nil {
+			subject, err := a.jwks.Verify(token)
+			if err != nil {
+				return nil, &apiError{promutil.ErrorUnauthorized, fmt.Errorf("invalid bearer token: %v", err)}
+			}
+			return &Identity{Name: subject, Method: "jwt"}, nil
+		}
+		return nil, &apiError{promutil.ErrorUnauthorized, fmt.Errorf("invalid bearer token")}
+	}
+
+	return nil, &apiError{promutil.ErrorUnauthorized, fmt.Errorf("authentication required")}
+}
+
+func (a *authenticator) subjectAllowed(subject string) bool {
+	if len(a.allowedSubjects) == 0 {
+		return true
+	}
+	for _, re := range a.allowedSubjects {
+		if re.MatchString(subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the bearer token of the request (if there is one)
+func bearerToken(r *http.Request) string {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) > len(prefix) && strings.EqualFold(h[:len(prefix)], prefix) {
+		return strings.TrimSpace(h[len(prefix):])
+	}
+	return ""
+}
+
+// isAdminPath returns whether the path is one of the admin endpoints
+func isAdminPath(path string) bool {
+	return strings.HasPrefix(path, "/api/v1/admin/") || strings.HasPrefix(path, "/admin/") || path == "/-/reload"
+}
+
+// AuthMiddleware returns the Middleware which authenticates requests with the
+// given config. Requests for the admin endpoints and dry runs are authenticated
+// with the admin config (if set). The identity of authenticated requests is stored in the context,
+// see IdentityFromContext
+func AuthMiddleware(cfg ServerAuthConfig) (Middleware, error) {
+	auth, err := newAuthenticator(cfg.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	adminAuth := auth
+	if cfg.Admin != nil {
+		if adminAuth, err = newAuthenticator(*cfg.Admin); err != nil {
+			return nil, err
+		}
+	}
+
+	return MiddlewareFunc{S: StageAuth, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			a := auth
+			if isAdminPath(r.URL.Path) || dryRunRequested(r) {
+				a = adminAuth
+			}
+
+			id, apiErr := a.authenticate(r)
+			if apiErr != nil {
+				logger.WithField("path", r.URL.Path).Debugf("Rejected request: %v", apiErr.err)
+				if apiErr.typ == promutil.ErrorUnauthorized && a.credentialsRequired() {
+					w.Header().Set("WWW-Authenticate", `Basic realm="promxy"`)
+				}
+				respondError(w, apiErr, nil)
+				return
+			}
+			if id != nil {
+				r = r.WithContext(context.WithValue(r.Context(), identityKey{}, id))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}}, nil
+}
+
+// Authenticator authenticates the calls of the APIs served besides HTTP (e.g.
+// gRPC) like the AuthMiddleware does the requests
+type Authenticator struct {
+	auth *authenticator
+}
+
+// NewAuthenticator returns the Authenticator of the given config. Only the query
+// API is served besides HTTP, so the admin config doesn't apply.
+func NewAuthenticator(cfg ServerAuthConfig) (*Authenticator, error) {
+	auth, err := newAuthenticator(cfg.AuthConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &Authenticator{auth: auth}, nil
+}
+
+// Authenticate authenticates a call with the given Authorization header value and
+// TLS connection state (nil if the call isn't over TLS), returning its context
+// with the identity of the call (see IdentityFromContext). The error has the
+// errorType it should be reported as (see ErrorType).
+func (a *Authenticator) Authenticate(ctx context.Context, authorization string, state *tls.ConnectionState) (context.Context, error) {
+	r := &http.Request{Header: make(http.Header), TLS: state}
+	if authorization != "" {
+		r.Header.Set("Authorization", authorization)
+	}
+	id, apiErr := a.auth.authenticate(r)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	if id != nil {
+		ctx = context.WithValue(ctx, identityKey{}, id)
+	}
+	return ctx, nil
+}
+
+// TenancyMiddleware is the Middleware which sets the tenant of authenticated
+// requests to their identity, which is used for tenant specific limits
+var TenancyMiddleware Middleware = MiddlewareFunc{S: StageTenancy, F: func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if id := IdentityFromContext(r.Context()); id != nil {
+			r = r.WithContext(promclient.WithTenant(r.Context(), id.Name))
+		}
+		next.ServeHTTP(w, r)
+	})
+}}
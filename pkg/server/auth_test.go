@@ -0,0 +1,303 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	config_util "github.com/prometheus/common/config"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// signJWT returns an RS256 JWT with the given claims
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": kid}) + "." + encode(claims)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// jwtClaims returns valid claims for the JWTs of TestAuthMiddleware, with the
+// overrides applied (nil removes a claim)
+func jwtClaims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"sub": "carol",
+		"iss": "https://idp.example.com",
+		"aud": "promproxy",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": "key1",
+				"kty": "RSA",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	}))
+	defer jwks.Close()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mw, err := AuthMiddleware(ServerAuthConfig{
+		AuthConfig: AuthConfig{
+			BasicAuthUsers: map[string]config_util.Secret{"alice": config_util.Secret(hash)},
+			BearerTokens:   map[string]string{"token-b": "bob"},
+			JWKSURL:        jwks.URL,
+			JWTAudience:    "promproxy",
+			JWTIssuer:      "https://idp.example.com",
+		},
+		Admin: &AuthConfig{
+			BearerTokens:      map[string]string{"token-admin": "admin"},
+			RequireClientCert: true,
+			AllowedSubjects:   []string{"ops-.*"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var tenant string
+	h := NewChain(MiddlewareConfig{}).Use(mw, TenancyMiddleware).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenant = promclient.TenantFromContext(r.Context())
+	})
+
+	withCert := func(cn string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: cn}}}}
+		}
+	}
+	withBearer := func(token string) func(r *http.Request) {
+		return func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		setup     []func(r *http.Request)
+		code      int
+		errorType promutil.ErrorType
+		tenant    string
+	}{
+		{
+			name:      "no credentials",
+			path:      "/api/v1/query",
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:   "basic auth",
+			path:   "/api/v1/query",
+			setup:  []func(r *http.Request){func(r *http.Request) { r.SetBasicAuth("alice", "hunter2") }},
+			code:   http.StatusOK,
+			tenant: "alice",
+		},
+		{
+			name:      "basic auth wrong password",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){func(r *http.Request) { r.SetBasicAuth("alice", "hunter3") }},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:   "static bearer token",
+			path:   "/api/v1/query",
+			setup:  []func(r *http.Request){withBearer("token-b")},
+			code:   http.StatusOK,
+			tenant: "bob",
+		},
+		{
+			name:   "jwt",
+			path:   "/api/v1/query",
+			setup:  []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(nil)))},
+			code:   http.StatusOK,
+			tenant: "carol",
+		},
+		{
+			name:   "jwt with several audiences",
+			path:   "/api/v1/query",
+			setup:  []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"aud": []string{"other", "promproxy"}})))},
+			code:   http.StatusOK,
+			tenant: "carol",
+		},
+		{
+			name:      "expired jwt",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})))},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "jwt without expiry",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"exp": nil})))},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "jwt for another audience",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"aud": "other"})))},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "jwt without audience",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"aud": nil})))},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "jwt from another issuer",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer(signJWT(t, key, "key1", jwtClaims(map[string]interface{}{"iss": "https://other.example.com"})))},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "unknown bearer token",
+			path:      "/api/v1/query",
+			setup:     []func(r *http.Request){withBearer("nope")},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		// The admin endpoints have their own requirements
+		{
+			name:      "admin with regular token",
+			path:      "/-/reload",
+			setup:     []func(r *http.Request){withBearer("token-b"), withCert("ops-1")},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "admin without cert",
+			path:      "/api/v1/admin/tsdb/snapshot",
+			setup:     []func(r *http.Request){withBearer("token-admin")},
+			code:      http.StatusUnauthorized,
+			errorType: promutil.ErrorUnauthorized,
+		},
+		{
+			name:      "admin with disallowed cert",
+			path:      "/api/v1/admin/tsdb/snapshot",
+			setup:     []func(r *http.Request){withBearer("token-admin"), withCert("dev-1")},
+			code:      http.StatusForbidden,
+			errorType: promutil.ErrorForbidden,
+		},
+		{
+			name:   "admin",
+			path:   "/-/reload",
+			setup:  []func(r *http.Request){withBearer("token-admin"), withCert("ops-1")},
+			code:   http.StatusOK,
+			tenant: "admin",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tenant = ""
+			r := httptest.NewRequest("GET", test.path, nil)
+			for _, setup := range test.setup {
+				setup(r)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d: %s", test.code, w.Code, w.Body.String())
+			}
+			if test.code != http.StatusOK {
+				var resp response
+				if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+					t.Fatalf("error response isn't JSON: %v", err)
+				}
+				if resp.Status != promutil.StatusError || resp.ErrorType != test.errorType {
+					t.Fatalf("mismatch in errorType expected=%s actual=%s", test.errorType, resp.ErrorType)
+				}
+				return
+			}
+			if tenant != test.tenant {
+				t.Fatalf("mismatch in tenant expected=%s actual=%s", test.tenant, tenant)
+			}
+		})
+	}
+}
+
+// TestJWKSFetchBackoff checks that tokens of unknown keys don't each fetch the
+// keys again while the JWKS URL is down
+func TestJWKSFetchBackoff(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fetches := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		http.Error(w, "down", http.StatusServiceUnavailable)
+	}))
+	defer jwks.Close()
+
+	verifier := newJWKSVerifier(jwks.URL, "promproxy", "https://idp.example.com")
+	for _, kid := range []string{"key1", "key2", "key3"} {
+		if _, err := verifier.Verify(signJWT(t, key, kid, jwtClaims(nil))); err == nil {
+			t.Fatalf("expected error verifying token of unknown key %s", kid)
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("mismatch in fetches expected=%d actual=%d", 1, fetches)
+	}
+}
+
+func TestAuthMiddlewareJWKSRequiresAudience(t *testing.T) {
+	for _, cfg := range []AuthConfig{
+		{JWKSURL: "http://idp.example.com/jwks", JWTIssuer: "https://idp.example.com"},
+		{JWKSURL: "http://idp.example.com/jwks", JWTAudience: "promproxy"},
+	} {
+		if _, err := AuthMiddleware(ServerAuthConfig{AuthConfig: cfg}); err == nil {
+			t.Fatalf("expected an error for %+v", cfg)
+		}
+	}
+}
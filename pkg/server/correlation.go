@@ -0,0 +1,53 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/server/middleware"
+)
+
+// CorrelationIDHeader is the header a request's correlation ID is read from (if
+// the client set one) and returned in
+const CorrelationIDHeader = "X-Correlation-ID"
+
+// CorrelationIDHandler attaches a correlation ID to the context of each request,
+// so the backend calls made on behalf of the request can be tied back to it
+func CorrelationIDHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(CorrelationIDHeader)
+		if id == "" {
+			id = promclient.NewRequestID()
+		}
+		w.Header().Set(CorrelationIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(promclient.WithCorrelationID(r.Context(), id)))
+	})
+}
+
+// CorrelationIDMiddleware is the CorrelationIDHandler as a Middleware for a Chain
+var CorrelationIDMiddleware Middleware = MiddlewareFunc{S: StageCorrelation, F: CorrelationIDHandler}
+
+// RequestIDMiddleware is middleware.RequestIDMiddleware as a Middleware for a
+// Chain, it sets the correlation ID of the request from its request ID
+var RequestIDMiddleware Middleware = MiddlewareFunc{S: StageCorrelation, F: middleware.RequestIDMiddleware}
+
+// QueryAttributionMiddleware returns the Middleware which attributes the queries
+// of a request to the values of its headers (e.g. the dashboard of a Grafana
+// request), see promclient.WithQueryAttribution. The headers are mapped to the
+// field they set.
+func QueryAttributionMiddleware(headers map[string]string) Middleware {
+	return MiddlewareFunc{S: StageCorrelation, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fields := make(map[string]string)
+			for header, field := range headers {
+				if v := r.Header.Get(header); v != "" {
+					fields[field] = v
+				}
+			}
+			if len(fields) > 0 {
+				r = r.WithContext(promclient.WithQueryAttribution(r.Context(), fields))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
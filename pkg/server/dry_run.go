@@ -0,0 +1,63 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// The per-request dry run flag (see DryRunMiddleware)
+const (
+	DryRunParam  = "dry_run"
+	DryRunHeader = "X-Promproxy-Dry-Run"
+)
+
+// dryRunRequested returns whether the request asks for a dry run. As a dry run
+// shows the downstreams and how they are called, these requests are authenticated
+// like the admin endpoints.
+func dryRunRequested(r *http.Request) bool {
+	for _, v := range []string{r.Header.Get(DryRunHeader), r.FormValue(DryRunParam)} {
+		if dryRun, err := strconv.ParseBool(v); err == nil && dryRun {
+			return true
+		}
+	}
+	return false
+}
+
+// DryRunMiddleware returns the Middleware which makes requests dry runs (see
+// promclient.WithDryRun) if they ask for it, or all of them if global is set.
+// Dry runs respond with an empty result and the downstream requests which weren't
+// sent, which are logged as well.
+func DryRunMiddleware(global bool) Middleware {
+	return MiddlewareFunc{S: StageDryRun, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if !global && !dryRunRequested(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, dryRun := promclient.WithDryRun(r.Context())
+			next.ServeHTTP(w, r.WithContext(ctx))
+
+			requests := dryRun.Requests()
+			entry := logger.WithFields(logrus.Fields{
+				"path":           r.URL.Path,
+				"query":          r.FormValue("query"),
+				"correlation_id": promclient.CorrelationIDFromContext(ctx),
+				"requests":       len(requests),
+			})
+			entry.Info("Dry run")
+			for _, req := range requests {
+				entry.WithFields(logrus.Fields{
+					"method": req.Method,
+					"url":    req.URL,
+					"header": req.Header,
+					"body":   req.Body,
+				}).Info("Dry run downstream request")
+			}
+		})
+	}}
+}
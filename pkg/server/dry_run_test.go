@@ -0,0 +1,98 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// refusedTransport fails all requests, counting them
+type refusedTransport struct {
+	sent int
+}
+
+func (r *refusedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.sent++
+	return nil, &url.Error{Op: req.Method, URL: req.URL.String(), Err: syscall.ECONNREFUSED}
+}
+
+func TestDryRun(t *testing.T) {
+	transport := &refusedTransport{}
+	// upstream returns the client of the upstream with the given region
+	upstream := func(host, region string) promclient.API {
+		client, err := api.NewClient(api.Config{
+			Address:      "http://" + host,
+			RoundTripper: &promclient.DryRunRoundTripper{RoundTripper: transport},
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return &promclient.AddLabelClient{
+			API:    &promclient.PromAPIV1{v1.NewAPI(client)},
+			Labels: model.LabelSet{"region": model.LabelValue(region)},
+		}
+	}
+	multi := promclient.NewMultiAPI([]promclient.API{upstream("eu:9090", "eu"), upstream("us:9090", "us")}, 0, nil, 1)
+
+	auth, err := AuthMiddleware(ServerAuthConfig{
+		AuthConfig: AuthConfig{BearerTokens: map[string]string{"user-token": "user"}},
+		Admin:      &AuthConfig{BearerTokens: map[string]string{"admin-token": "admin"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	h := NewChain(MiddlewareConfig{}).Use(auth, DryRunMiddleware(false)).Then(InstantQueryHandler(multi))
+
+	do := func(token string, dryRun bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/api/v1/query?"+url.Values{"query": {`up{region="us"}`}}.Encode(), nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		if dryRun {
+			req.Header.Set(DryRunHeader, "true")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Dry runs require the admin credentials
+	if w := do("user-token", true); w.Code != http.StatusUnauthorized {
+		t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusUnauthorized, w.Code)
+	}
+
+	w := do("admin-token", true)
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatch in status code expected=%d actual=%d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+	if transport.sent != 0 {
+		t.Fatalf("mismatch in requests sent downstream expected=0 actual=%d", transport.sent)
+	}
+	resp := &response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("error unmarshaling response: %v", err)
+	}
+	if len(resp.Warnings) != 1 || resp.Warnings[0] != promclient.DryRunWarning {
+		t.Fatalf("mismatch in warnings expected=%v actual=%v", []string{promclient.DryRunWarning}, resp.Warnings)
+	}
+	// Only the upstream with matching labels would have been called
+	if resp.Debug == nil || len(resp.Debug.DryRun) != 1 {
+		t.Fatalf("mismatch in dry run requests expected=1 actual=%+v", resp.Debug)
+	}
+	if r := resp.Debug.DryRun[0]; !strings.Contains(r.URL, "us:9090") {
+		t.Fatalf("mismatch in dry run request expected=us:9090 actual=%+v", r)
+	}
+
+	// Without the flag the request is sent (and fails)
+	if w := do("user-token", false); w.Code == http.StatusOK || transport.sent == 0 {
+		t.Fatalf("request without dry run not sent: %d", w.Code)
+	}
+}
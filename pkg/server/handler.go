@@ -0,0 +1,708 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/logging"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+	"github.com/promproxy/pkg/server/middleware"
+)
+
+var logger = logging.Component(logging.ComponentServer)
+
+// WarningsHeader is the header used to forward warnings to HTTP clients, it is
+// repeated once per warning
+const WarningsHeader = "X-Prometheus-Warnings"
+
+// response is the envelope that the prometheus HTTP API returns
+type response struct {
+	Status    promutil.Status    `json:"status"`
+	Data      interface{}        `json:"data,omitempty"`
+	ErrorType promutil.ErrorType `json:"errorType,omitempty"`
+	Error     string             `json:"error,omitempty"`
+	Warnings  []string           `json:"warnings,omitempty"`
+	Debug     *debugData         `json:"debug,omitempty"`
+	// Metadata is only set for the clients which negotiated the extended schema
+	Metadata *responseMetadata `json:"metadata,omitempty"`
+}
+
+// debugData is the debug section of a response
+type debugData struct {
+	// DryRun are the downstream requests a dry run didn't send
+	DryRun []promclient.DryRunRequest `json:"dryRun"`
+}
+
+// queryData is the data section of a query/query_range response
+type queryData struct {
+	ResultType model.ValueType `json:"resultType"`
+	Result     model.Value     `json:"result"`
+	// Stats are only set if the stats param was given
+	Stats *promclient.QueryStats `json:"stats,omitempty"`
+}
+
+// withQueryStats returns the context recording the stats of the query if the
+// stats param was given (as with prometheus, any value asks for the stats)
+func withQueryStats(ctx context.Context, r *http.Request) (context.Context, *promclient.QueryStatsRecorder) {
+	if r.FormValue("stats") == "" {
+		return ctx, nil
+	}
+	return promclient.WithQueryStatsRecorder(ctx)
+}
+
+// queryStats returns the stats of the query recorded by the recorder (if any)
+func queryStats(recorder *promclient.QueryStatsRecorder) *promclient.QueryStats {
+	if recorder == nil {
+		return nil
+	}
+	stats := recorder.Stats()
+	return &stats
+}
+
+// apiError is an error with the prometheus errorType it should be reported as
+type apiError struct {
+	typ promutil.ErrorType
+	err error
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%s: %s", e.typ, e.err)
+}
+
+// badData returns an apiError for invalid input from the client
+func badData(err error) *apiError {
+	return &apiError{promutil.ErrorBadData, err}
+}
+
+// upstreamError converts an error returned from the promclient.API into an
+// apiError. Timeouts and cancellations are reported as such, everything else
+// is considered a failure of the downstream servers
+func upstreamError(err error) *apiError {
+	// The downstreams are shedding load
+	if retryErr, ok := promclient.AsRetryAfterError(err); ok {
+		if retryErr.StatusCode == http.StatusTooManyRequests {
+			return &apiError{promutil.ErrorThrottled, err}
+		}
+		return &apiError{promutil.ErrorUnavailable, err}
+	}
+
+	// Requests which were found invalid before being sent anywhere are bad data,
+	// whatever the cause
+	var validationErr *promutil.ValidationError
+	if errors.As(err, &validationErr) {
+		return &apiError{promutil.ErrorBadData, err}
+	}
+
+	switch cause := errors.Cause(err); cause.(type) {
+	case promql.ErrQueryTimeout:
+		return &apiError{promutil.ErrorTimeout, err}
+	case promql.ErrQueryCanceled:
+		return &apiError{promutil.ErrorCanceled, err}
+	case promclient.ErrMetricNotAllowed:
+		return &apiError{promutil.ErrorForbidden, err}
+	case promclient.ErrLatencyBudgetExhausted:
+		return &apiError{promutil.ErrorTimeout, err}
+	case *promclient.ErrRateLimited:
+		return &apiError{promutil.ErrorThrottled, err}
+	case *promclient.ErrFutureTime:
+		return &apiError{promutil.ErrorBadData, err}
+	case *loadshed.ErrOverloaded:
+		return &apiError{promutil.ErrorUnavailable, err}
+	default:
+		switch cause {
+		case context.DeadlineExceeded:
+			return &apiError{promutil.ErrorTimeout, err}
+		case context.Canceled:
+			return &apiError{promutil.ErrorCanceled, err}
+		}
+	}
+	return &apiError{promutil.ErrorProxy, err}
+}
+
+// ErrorType returns the errorType an error returned from the promclient.API (or
+// the Authenticator) is reported as (see upstreamError), for the APIs served
+// besides HTTP
+func ErrorType(err error) promutil.ErrorType {
+	if apiErr, ok := err.(*apiError); ok {
+		return apiErr.typ
+	}
+	return upstreamError(err).typ
+}
+
+// respondMethodNotAllowed rejects a request whose method the handler doesn't serve,
+// with the allowed methods in the Allow header
+func respondMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	respondError(w, &apiError{promutil.ErrorNotAllowed, fmt.Errorf("method %s not allowed", r.Method)}, nil)
+}
+
+// statusCode returns the HTTP status code for the given errorType
+func statusCode(typ promutil.ErrorType) int {
+	switch typ {
+	case promutil.ErrorBadData:
+		return http.StatusBadRequest
+	case promutil.ErrorExec:
+		return 422
+	case promutil.ErrorCanceled, promutil.ErrorTimeout:
+		return http.StatusServiceUnavailable
+	case promutil.ErrorProxy:
+		return http.StatusBadGateway
+	case promutil.ErrorUnauthorized:
+		return http.StatusUnauthorized
+	case promutil.ErrorForbidden:
+		return http.StatusForbidden
+	case promutil.ErrorNotFound:
+		return http.StatusNotFound
+	case promutil.ErrorThrottled:
+		return http.StatusTooManyRequests
+	case promutil.ErrorUnavailable:
+		return http.StatusServiceUnavailable
+	case promutil.ErrorNotAllowed:
+		return http.StatusMethodNotAllowed
+	case promutil.ErrorTooLarge:
+		return http.StatusRequestEntityTooLarge
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func respond(w http.ResponseWriter, data interface{}, warnings api.Warnings) {
+	writeResponse(w, http.StatusOK, &response{
+		Status:   promutil.StatusSuccess,
+		Data:     data,
+		Warnings: warnings,
+	})
+}
+
+// respondResult responds with the result of the calls made for the request, in the
+// shape of the schema version negotiated with the client (see shapeResponse). The
+// (empty) results of dry runs carry the promclient.DryRunWarning, with the
+// requests which weren't sent in the debug section.
+func respondResult(w http.ResponseWriter, r *http.Request, data interface{}, warnings api.Warnings) {
+	resp := &response{
+		Status:   promutil.StatusSuccess,
+		Data:     data,
+		Warnings: warnings,
+	}
+	if dryRun := promclient.DryRunFromContext(r.Context()); dryRun != nil {
+		resp.Warnings = append(warnings, promclient.DryRunWarning)
+		resp.Debug = &debugData{DryRun: dryRun.Requests()}
+	}
+	shapeResponse(w, r, resp)
+	writeResponse(w, http.StatusOK, resp)
+}
+
+// retryAfterHint is implemented by the errors of promproxy's own limiters (e.g.
+// promclient.ErrRateLimited), which know when a retry may succeed
+type retryAfterHint interface {
+	RetryAfter() time.Duration
+}
+
+// retryAfter returns how long the client should wait before retrying the request
+// which failed with err
+func retryAfter(err error) (time.Duration, bool) {
+	// How long the downstreams asked to be left alone for
+	if after, ok := promclient.RetryAfter(err); ok {
+		return after, true
+	}
+	if hint, ok := errors.Cause(err).(retryAfterHint); ok {
+		return hint.RetryAfter(), true
+	}
+	return 0, false
+}
+
+func respondError(w http.ResponseWriter, apiErr *apiError, warnings api.Warnings) {
+	// Rounded up to whole seconds, so the client doesn't retry too early
+	if after, ok := retryAfter(apiErr.err); ok {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(after.Seconds())), 10))
+	}
+	writeResponse(w, statusCode(apiErr.typ), &response{
+		Status:    promutil.StatusError,
+		ErrorType: apiErr.typ,
+		Error:     apiErr.err.Error(),
+		Warnings:  warnings,
+	})
+}
+
+// StatusClientClosedRequest is the (non-standard) status of requests which the
+// client canceled before the response was written, as used by nginx. The client
+// never sees it, but it sets these requests apart from failed ones.
+const StatusClientClosedRequest = 499
+
+// clientCanceled returns whether the client of the request went away (e.g.
+// Grafana cancels the requests of panels which were scrolled out of view)
+func clientCanceled(r *http.Request) bool {
+	return r.Context().Err() == context.Canceled
+}
+
+// respondRequestError responds with the error of the request, unless the client
+// canceled the request, which is the cause of the error then. That is part of
+// normal use, so it is only logged at debug level rather than reported as an error.
+func respondRequestError(w http.ResponseWriter, r *http.Request, apiErr *apiError, warnings api.Warnings) {
+	if !clientCanceled(r) {
+		respondError(w, apiErr, warnings)
+		return
+	}
+	logger.WithFields(logrus.Fields{
+		"path":  r.URL.Path,
+		"error": apiErr.err,
+	}).Debug("Client canceled request")
+	writeResponse(w, StatusClientClosedRequest, &response{
+		Status:    promutil.StatusError,
+		ErrorType: promutil.ErrorCanceled,
+		Error:     "client canceled request",
+	})
+}
+
+func writeResponse(w http.ResponseWriter, code int, resp *response) {
+	b, err := json.Marshal(resp)
+	if err != nil {
+		logger.Errorf("error marshaling json response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	for _, warning := range resp.Warnings {
+		w.Header().Add(WarningsHeader, warning)
+	}
+	w.WriteHeader(code)
+	if _, err := w.Write(b); err != nil {
+		if middleware.ClientGone(err) {
+			logger.Debugf("Client went away before the response was written: %v", err)
+		} else {
+			logger.Errorf("error writing response: %v", err)
+		}
+	}
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if d, err := strconv.ParseFloat(s, 64); err == nil {
+		ts := d * float64(time.Second)
+		if ts > float64(math.MaxInt64) || ts < float64(math.MinInt64) {
+			return 0, fmt.Errorf("cannot parse %q to a valid duration. It overflows int64", s)
+		}
+		return time.Duration(ts), nil
+	}
+	if d, err := promutil.ParsePromDuration(s); err == nil {
+		return d, nil
+	}
+	return 0, fmt.Errorf("cannot parse %q to a valid duration", s)
+}
+
+// requiredParam returns the value of the form parameter `name`, or an error if
+// it wasn't set
+func requiredParam(r *http.Request, name string) (string, *apiError) {
+	v := r.FormValue(name)
+	if v == "" {
+		return "", badData(fmt.Errorf("missing required parameter %q", name))
+	}
+	return v, nil
+}
+
+// timeParam parses the form parameter `name` as a timestamp, returning
+// `defaultValue` if it wasn't set
+func timeParam(r *http.Request, name string, defaultValue time.Time) (time.Time, *apiError) {
+	v := r.FormValue(name)
+	if v == "" {
+		return defaultValue, nil
+	}
+	t, err := promutil.ParseTimestamp(v)
+	if err != nil {
+		return time.Time{}, badData(errors.Wrapf(err, "invalid parameter %q", name))
+	}
+	return t, nil
+}
+
+// limitParam parses the form parameter `name` as a limit, 0 (the default) is
+// no limit
+func limitParam(r *http.Request, name string) (int, *apiError) {
+	v := r.FormValue(name)
+	if v == "" {
+		return 0, nil
+	}
+	limit, err := strconv.Atoi(v)
+	if err != nil || limit < 0 {
+		return 0, badData(fmt.Errorf("invalid parameter %q: cannot parse %q to a non-negative integer", name, v))
+	}
+	return limit, nil
+}
+
+// CompletenessHeader is the response header with the fraction of the backends
+// expected to serve the request which responded (e.g. 0.5 if one of two failed),
+// so frontends can show that the data is partial
+const CompletenessHeader = "X-Promproxy-Completeness"
+
+// setCompletenessHeader sets the CompletenessHeader, unless no backend was
+// expected to serve the request
+func setCompletenessHeader(w http.ResponseWriter, c *promclient.Completeness) {
+	if score, ok := c.Score(); ok {
+		w.Header().Set(CompletenessHeader, strconv.FormatFloat(score, 'f', 2, 64))
+	}
+}
+
+// InstantQueryHandler serves /api/v1/query using the given API. The timeout param
+// is the deadline of the query (see withQueryTimeout). The limit param is sent to
+// the downstreams which support it (see promclient.WithQueryLimit), and truncates
+// the merged result to that many series. If the stats param is given the stats of
+// the evaluation (see promclient.QueryStats) are returned with the result.
+func InstantQueryHandler(client promclient.API) http.HandlerFunc {
+	return limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+		query, apiErr := requiredParam(r, "query")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		ts, apiErr := timeParam(r, "time", time.Now())
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		if _, err := promql.ParseExpr(query); err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+		limit, apiErr := limitParam(r, "limit")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		ctx, cancel, apiErr := withQueryTimeout(r)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		defer cancel()
+
+		// The limit is sent to the downstreams which support it, the merged result
+		// is still truncated here
+		if limit > 0 {
+			ctx = promclient.WithQueryLimit(ctx, limit)
+		}
+		ctx, recorder := withQueryStats(ctx, r)
+		ctx, completeness := promclient.WithCompleteness(ctx)
+		v, warnings, err := client.Query(ctx, query, ts)
+		setCompletenessHeader(w, completeness)
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), warnings)
+			return
+		}
+		if v == nil {
+			v = model.Vector{}
+		}
+		v, warnings = limitValue(v, limit, warnings)
+		respondResult(w, r.WithContext(ctx), &queryData{ResultType: v.Type(), Result: v, Stats: queryStats(recorder)}, warnings)
+	})
+}
+
+// RangeQueryHandler serves /api/v1/query_range using the given API, with the
+// timeout, limit and stats params of InstantQueryHandler
+func RangeQueryHandler(client promclient.API) http.HandlerFunc {
+	return limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+		query, apiErr := requiredParam(r, "query")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		for _, name := range []string{"start", "end", "step"} {
+			if _, apiErr := requiredParam(r, name); apiErr != nil {
+				respondError(w, apiErr, nil)
+				return
+			}
+		}
+		start, apiErr := timeParam(r, "start", time.Time{})
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		end, apiErr := timeParam(r, "end", time.Time{})
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		if end.Before(start) {
+			respondError(w, badData(fmt.Errorf("end timestamp must not be before start time")), nil)
+			return
+		}
+		step, err := parseDuration(r.FormValue("step"))
+		if err != nil {
+			respondError(w, badData(errors.Wrap(err, "invalid parameter \"step\"")), nil)
+			return
+		}
+		if step <= 0 {
+			respondError(w, badData(fmt.Errorf("zero or negative query resolution step widths are not accepted. Try a positive integer")), nil)
+			return
+		}
+		if _, err := promql.ParseExpr(query); err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+		limit, apiErr := limitParam(r, "limit")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		ctx, cancel, apiErr := withQueryTimeout(r)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		defer cancel()
+
+		if limit > 0 {
+			ctx = promclient.WithQueryLimit(ctx, limit)
+		}
+		ctx, recorder := withQueryStats(ctx, r)
+		ctx, completeness := promclient.WithCompleteness(ctx)
+		rng := v1.Range{Start: start, End: end, Step: step}
+		v, warnings, err := client.QueryRange(ctx, query, rng)
+		setCompletenessHeader(w, completeness)
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), warnings)
+			return
+		}
+		if v == nil {
+			v = model.Matrix{}
+		}
+		served, warnings := limitValue(v, limit, warnings)
+		respondResult(w, r.WithContext(ctx), &queryData{ResultType: served.Type(), Result: served, Stats: queryStats(recorder)}, warnings)
+
+		// The served result is verified (if sampled) once it was written, before
+		// it was limited, as the result it is verified against isn't
+		if verifier := promclient.QueryVerifierFromContext(r.Context()); verifier != nil {
+			verifier.Verify(r.Context(), query, rng, v)
+		}
+	})
+}
+
+// SeriesHandler serves /api/v1/series using the given API. The limit param is
+// passed on to the API as the series limit (see promclient.WithSeriesLimit), the
+// timeout param is the deadline of the call (see withQueryTimeout).
+func SeriesHandler(client promclient.API) http.HandlerFunc {
+	return limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			respondError(w, badData(errors.Wrap(err, "error parsing form values")), nil)
+			return
+		}
+		matches := r.Form["match[]"]
+		if len(matches) == 0 {
+			respondError(w, badData(fmt.Errorf("no match[] parameter provided")), nil)
+			return
+		}
+		for _, match := range matches {
+			if _, err := promql.ParseMetricSelector(match); err != nil {
+				respondError(w, badData(err), nil)
+				return
+			}
+		}
+		start, apiErr := timeParam(r, "start", promutil.MinTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		end, apiErr := timeParam(r, "end", promutil.MaxTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+
+		limit, apiErr := limitParam(r, "limit")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+
+		ctx, cancel, apiErr := withQueryTimeout(r)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		defer cancel()
+
+		ctx, completeness := promclient.WithCompleteness(ctx)
+		if limit > 0 {
+			ctx = promclient.WithSeriesLimit(ctx, limit)
+		}
+		v, warnings, err := client.Series(ctx, matches, start, end)
+		setCompletenessHeader(w, completeness)
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), warnings)
+			return
+		}
+		if v == nil {
+			v = []model.LabelSet{}
+		}
+		respondResult(w, r.WithContext(ctx), v, warnings)
+	})
+}
+
+// DefaultDeleteSeriesRateLimit is the rate limit of DeleteSeriesHandler if none is given
+var DefaultDeleteSeriesRateLimit = RateLimitConfig{RequestsPerSecond: 1}
+
+// DeleteSeriesHandler serves /api/v1/admin/tsdb/delete_series, deleting the data of
+// the series matching any of the match[] params in the (optional) time range from
+// the downstreams. As deletes are expensive for the downstreams they are rate
+// limited by the limiter (DefaultDeleteSeriesRateLimit if nil). If readOnly is set
+// all requests are rejected with a 405.
+func DeleteSeriesHandler(admin promclient.AdminAPI, readOnly bool, limiter *RateLimiter) http.HandlerFunc {
+	if limiter == nil {
+		limiter = NewRateLimiter(DefaultDeleteSeriesRateLimit)
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if readOnly {
+			respondError(w, &apiError{promutil.ErrorNotAllowed, fmt.Errorf("deleting series is not allowed, the proxy is read-only")}, nil)
+			return
+		}
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		if err := r.ParseForm(); err != nil {
+			respondError(w, badData(errors.Wrap(err, "error parsing form values")), nil)
+			return
+		}
+		matches := r.Form["match[]"]
+		if len(matches) == 0 {
+			respondError(w, badData(fmt.Errorf("no match[] parameter provided")), nil)
+			return
+		}
+		for _, match := range matches {
+			if _, err := promql.ParseMetricSelector(match); err != nil {
+				respondError(w, badData(err), nil)
+				return
+			}
+		}
+		start, apiErr := timeParam(r, "start", promutil.MinTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		end, apiErr := timeParam(r, "end", promutil.MaxTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+
+		// Only valid requests count against the limit
+		if after, ok := limiter.Allow(); !ok {
+			respondError(w, &apiError{promutil.ErrorThrottled, &promclient.ErrRateLimited{After: after}}, nil)
+			return
+		}
+
+		if err := admin.DeleteSeries(r.Context(), matches, start, end); err != nil {
+			respondRequestError(w, r, upstreamError(err), nil)
+			return
+		}
+		logger.WithFields(logrus.Fields{
+			"match[]": matches,
+			"start":   start,
+			"end":     end,
+		}).Warn("Deleted series")
+
+		// As prometheus does, a successful delete has no content
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// SnapshotHandler serves /api/v1/admin/tsdb/snapshot, creating a snapshot of the
+// data of the downstreams. The skip_head param skips the head block, as in
+// prometheus. As it is one of the admin paths it is protected by the admin auth
+// (see AuthMiddleware).
+func SnapshotHandler(admin promclient.AdminAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost && r.Method != http.MethodPut {
+			respondMethodNotAllowed(w, r, http.MethodPost, http.MethodPut)
+			return
+		}
+
+		var skipHead bool
+		if v := r.FormValue("skip_head"); v != "" {
+			var err error
+			if skipHead, err = strconv.ParseBool(v); err != nil {
+				respondError(w, badData(errors.Wrap(err, "invalid parameter \"skip_head\"")), nil)
+				return
+			}
+		}
+
+		result, err := admin.Snapshot(r.Context(), skipHead)
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), nil)
+			return
+		}
+		logger.WithField("name", result.Name).Info("Created snapshot")
+		respond(w, result, nil)
+	}
+}
+
+// TSDBStatusHandler serves /api/v1/status/tsdb with the status synthesized from
+// the downstreams (see promclient.MergeTSDBStatus), whose own statuses are under
+// promproxyDownstreams. Downstreams lacking the endpoint are skipped with a warning.
+func TSDBStatusHandler(client promclient.TSDBStatuser) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status, warnings, err := client.TSDBStatus(r.Context())
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), warnings)
+			return
+		}
+		respond(w, status, warnings)
+	}
+}
+
+// LabelsHandler serves /api/v1/labels using the given API. If a time range is
+// given and the API implements promclient.LabelNamesInRanger the label names
+// are restricted to that range
+func LabelsHandler(client promclient.API) http.HandlerFunc {
+	return limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+		start, apiErr := timeParam(r, "start", promutil.MinTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		end, apiErr := timeParam(r, "end", promutil.MaxTime)
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		if end.Before(start) {
+			respondError(w, badData(fmt.Errorf("end timestamp must not be before start time")), nil)
+			return
+		}
+
+		var (
+			names    []string
+			warnings api.Warnings
+			err      error
+		)
+		if r.FormValue("start") != "" || r.FormValue("end") != "" {
+			names, warnings, err = promclient.LabelNamesInRange(r.Context(), client, start, end)
+		} else {
+			names, warnings, err = client.LabelNames(r.Context())
+		}
+		if err != nil {
+			respondRequestError(w, r, upstreamError(err), warnings)
+			return
+		}
+
+		sorted := make([]string, len(names))
+		copy(sorted, names)
+		sort.Strings(sorted)
+		respondResult(w, r, sorted, warnings)
+	})
+}
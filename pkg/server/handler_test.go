@@ -0,0 +1,776 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/promproxy/pkg/loadshed"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// stubAPI returns the configured value, warnings and error for every call
+type stubAPI struct {
+	v          model.Value
+	series     []model.LabelSet
+	labelNames []string
+	warnings   api.Warnings
+	err        error
+}
+
+func (s *stubAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	return s.labelNames, s.warnings, s.err
+}
+
+func (s *stubAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
+	return nil, s.warnings, s.err
+}
+
+func (s *stubAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	return s.v, s.warnings, s.err
+}
+
+func (s *stubAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	return s.v, s.warnings, s.err
+}
+
+func (s *stubAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
+	return s.series, s.warnings, s.err
+}
+
+func (s *stubAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
+	return s.v, s.warnings, s.err
+}
+
+// doRequest runs the handler against a request with the given form values
+func doRequest(h http.Handler, path string, values url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path+"?"+values.Encode(), nil)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+type handlerErrorTest struct {
+	name      string
+	handler   string
+	params    url.Values
+	err       error
+	code      int
+	errorType promutil.ErrorType
+	// retryAfter is the expected Retry-After header, if any
+	retryAfter string
+}
+
+func TestHandlerErrors(t *testing.T) {
+	handlers := map[string]func(*stubAPI) http.Handler{
+		"query":       func(s *stubAPI) http.Handler { return InstantQueryHandler(s) },
+		"query_range": func(s *stubAPI) http.Handler { return RangeQueryHandler(s) },
+		"series":      func(s *stubAPI) http.Handler { return SeriesHandler(s) },
+		"labels":      func(s *stubAPI) http.Handler { return LabelsHandler(s) },
+		"query_diff":  func(s *stubAPI) http.Handler { return QueryDiffHandler(s) },
+	}
+
+	// validParams are a set of parameters for each handler that is accepted
+	validParams := map[string]url.Values{
+		"query":       {"query": {"up"}, "time": {"100"}},
+		"query_range": {"query": {"up"}, "start": {"100"}, "end": {"200"}, "step": {"10"}},
+		"series":      {"match[]": {"up"}, "start": {"100"}, "end": {"200"}},
+		"labels":      {"start": {"100"}, "end": {"200"}},
+		"query_diff":  {"query": {"up"}, "time": {"100"}, "compare_offset": {"7d"}},
+	}
+
+	tests := []handlerErrorTest{
+		// Missing required parameters
+		{
+			name:      "query missing query",
+			handler:   "query",
+			params:    url.Values{"time": {"100"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range missing query",
+			handler:   "query_range",
+			params:    url.Values{"start": {"100"}, "end": {"200"}, "step": {"10"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range missing start",
+			handler:   "query_range",
+			params:    url.Values{"query": {"up"}, "end": {"200"}, "step": {"10"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range missing end",
+			handler:   "query_range",
+			params:    url.Values{"query": {"up"}, "start": {"100"}, "step": {"10"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range missing step",
+			handler:   "query_range",
+			params:    url.Values{"query": {"up"}, "start": {"100"}, "end": {"200"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_diff missing compare_offset",
+			handler:   "query_diff",
+			params:    url.Values{"query": {"up"}, "time": {"100"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_diff invalid compare_offset",
+			handler:   "query_diff",
+			params:    url.Values{"query": {"up"}, "compare_offset": {"7x"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "series missing match",
+			handler:   "series",
+			params:    url.Values{"start": {"100"}, "end": {"200"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+
+		// Parse errors
+		{
+			name:      "query invalid promql",
+			handler:   "query",
+			params:    url.Values{"query": {"sum(up"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query invalid time",
+			handler:   "query",
+			params:    url.Values{"query": {"up"}, "time": {"notatime"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range invalid promql",
+			handler:   "query_range",
+			params:    url.Values{"query": {"sum(up"}, "start": {"100"}, "end": {"200"}, "step": {"10"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range invalid step",
+			handler:   "query_range",
+			params:    url.Values{"query": {"up"}, "start": {"100"}, "end": {"200"}, "step": {"abc"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "query_range end before start",
+			handler:   "query_range",
+			params:    url.Values{"query": {"up"}, "start": {"200"}, "end": {"100"}, "step": {"10"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "series invalid match",
+			handler:   "series",
+			params:    url.Values{"match[]": {"sum(up)"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "labels invalid start",
+			handler:   "labels",
+			params:    url.Values{"start": {"notatime"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+		{
+			name:      "labels end before start",
+			handler:   "labels",
+			params:    url.Values{"start": {"200"}, "end": {"100"}},
+			code:      http.StatusBadRequest,
+			errorType: promutil.ErrorBadData,
+		},
+	}
+
+	// Upstream errors and timeouts apply to all handlers
+	for name := range handlers {
+		tests = append(tests,
+			handlerErrorTest{
+				name:      name + " upstream error",
+				handler:   name,
+				params:    validParams[name],
+				err:       errors.Wrap(fmt.Errorf("connection refused"), "Unable to fetch from downstream servers"),
+				code:      http.StatusBadGateway,
+				errorType: promutil.ErrorProxy,
+			},
+			handlerErrorTest{
+				name:      name + " deadline exceeded",
+				handler:   name,
+				params:    validParams[name],
+				err:       errors.Wrap(context.DeadlineExceeded, "Unable to fetch from downstream servers"),
+				code:      http.StatusServiceUnavailable,
+				errorType: promutil.ErrorTimeout,
+			},
+			handlerErrorTest{
+				name:      name + " downstreams throttling",
+				handler:   name,
+				params:    validParams[name],
+				err:       errors.Wrap(&promclient.RetryAfterError{StatusCode: 429, After: 90 * time.Second}, "Unable to fetch from downstream servers"),
+				code:      http.StatusTooManyRequests,
+				errorType: promutil.ErrorThrottled,
+			},
+			handlerErrorTest{
+				name:      name + " downstreams unavailable",
+				handler:   name,
+				params:    validParams[name],
+				err:       errors.Wrap(&promclient.RetryAfterError{StatusCode: 503, After: 1500 * time.Millisecond}, "Unable to fetch from downstream servers"),
+				code:      http.StatusServiceUnavailable,
+				errorType: promutil.ErrorUnavailable,
+			},
+			handlerErrorTest{
+				name:       name + " rate limited",
+				handler:    name,
+				params:     validParams[name],
+				err:        &promclient.ErrRateLimited{After: 250 * time.Millisecond},
+				code:       http.StatusTooManyRequests,
+				errorType:  promutil.ErrorThrottled,
+				retryAfter: "1",
+			},
+			handlerErrorTest{
+				name:       name + " overloaded",
+				handler:    name,
+				params:     validParams[name],
+				err:        &loadshed.ErrOverloaded{Usage: 100, After: 2100 * time.Millisecond},
+				code:       http.StatusServiceUnavailable,
+				errorType:  promutil.ErrorUnavailable,
+				retryAfter: "3",
+			},
+		)
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := handlers[test.handler](&stubAPI{err: test.err})
+			w := doRequest(h, "/api/v1/"+test.handler, test.params)
+
+			if w.Code != test.code {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", test.code, w.Code)
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Fatalf("mismatch in content-type expected=%s actual=%s", "application/json", ct)
+			}
+
+			resp := &response{}
+			if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+				t.Fatalf("error unmarshaling response: %v", err)
+			}
+			if resp.Status != promutil.StatusError {
+				t.Fatalf("mismatch in status expected=%s actual=%s", promutil.StatusError, resp.Status)
+			}
+			if resp.ErrorType != test.errorType {
+				t.Fatalf("mismatch in errorType expected=%s actual=%s", test.errorType, resp.ErrorType)
+			}
+			if resp.Error == "" {
+				t.Fatalf("missing error message")
+			}
+			if after, ok := promclient.RetryAfter(test.err); ok {
+				expected := strconv.Itoa(int(math.Ceil(after.Seconds())))
+				if actual := w.Header().Get("Retry-After"); actual != expected {
+					t.Fatalf("mismatch in Retry-After expected=%s actual=%s", expected, actual)
+				}
+			}
+			if test.retryAfter != "" {
+				if actual := w.Header().Get("Retry-After"); actual != test.retryAfter {
+					t.Fatalf("mismatch in Retry-After expected=%s actual=%s", test.retryAfter, actual)
+				}
+			}
+		})
+	}
+}
+
+func TestHandlerSuccess(t *testing.T) {
+	stub := &stubAPI{
+		v: model.Vector{
+			{
+				Metric:    model.Metric{model.MetricNameLabel: "up"},
+				Value:     1,
+				Timestamp: 100,
+			},
+		},
+		series: []model.LabelSet{{model.MetricNameLabel: "up"}},
+	}
+
+	w := doRequest(InstantQueryHandler(stub), "/api/v1/query", url.Values{"query": {"up"}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("mismatch in content-type expected=%s actual=%s", "application/json", ct)
+	}
+
+	resp := &response{}
+	if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+		t.Fatalf("error unmarshaling response: %v", err)
+	}
+	if resp.Status != promutil.StatusSuccess {
+		t.Fatalf("mismatch in status expected=%s actual=%s", promutil.StatusSuccess, resp.Status)
+	}
+}
+
+func TestWarningHeaders(t *testing.T) {
+	tests := []struct {
+		name     string
+		handler  func(*stubAPI) http.Handler
+		params   url.Values
+		warnings api.Warnings
+	}{
+		{
+			name:     "query single warning",
+			handler:  func(s *stubAPI) http.Handler { return InstantQueryHandler(s) },
+			params:   url.Values{"query": {"up"}},
+			warnings: api.Warnings{"partial response"},
+		},
+		{
+			name:     "query_range multiple warnings",
+			handler:  func(s *stubAPI) http.Handler { return RangeQueryHandler(s) },
+			params:   url.Values{"query": {"up"}, "start": {"100"}, "end": {"200"}, "step": {"10"}},
+			warnings: api.Warnings{"partial response", "other warning"},
+		},
+		{
+			name:     "series multiple warnings",
+			handler:  func(s *stubAPI) http.Handler { return SeriesHandler(s) },
+			params:   url.Values{"match[]": {"up"}},
+			warnings: api.Warnings{"partial response", "other warning"},
+		},
+		{
+			name:    "query no warnings",
+			handler: func(s *stubAPI) http.Handler { return InstantQueryHandler(s) },
+			params:  url.Values{"query": {"up"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &stubAPI{
+				v:        model.Vector{},
+				series:   []model.LabelSet{},
+				warnings: test.warnings,
+			}
+			w := doRequest(test.handler(stub), "/api/v1/", test.params)
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+			}
+
+			headers := w.Header()[WarningsHeader]
+			if len(headers) != len(test.warnings) {
+				t.Fatalf("mismatch in warning headers expected=%v actual=%v", test.warnings, headers)
+			}
+			for i, warning := range test.warnings {
+				if headers[i] != warning {
+					t.Fatalf("mismatch in warning headers expected=%v actual=%v", test.warnings, headers)
+				}
+			}
+		})
+	}
+
+	// Warnings must also be forwarded on errors
+	stub := &stubAPI{
+		err:      fmt.Errorf("some error"),
+		warnings: api.Warnings{"partial response"},
+	}
+	w := doRequest(InstantQueryHandler(stub), "/api/v1/query", url.Values{"query": {"up"}})
+	if headers := w.Header()[WarningsHeader]; len(headers) != 1 || headers[0] != "partial response" {
+		t.Fatalf("mismatch in warning headers expected=%v actual=%v", stub.warnings, headers)
+	}
+}
+
+func TestCompletenessHeader(t *testing.T) {
+	tests := []struct {
+		name   string
+		apis   []promclient.API
+		header string
+	}{
+		{
+			name:   "complete",
+			apis:   []promclient.API{&stubAPI{v: model.Vector{}}, &stubAPI{v: model.Vector{}}},
+			header: "1.00",
+		},
+		{
+			name:   "partial",
+			apis:   []promclient.API{&stubAPI{v: model.Vector{}}, &stubAPI{err: fmt.Errorf("connection refused")}, &stubAPI{v: model.Vector{}}},
+			header: "0.67",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			multi := promclient.NewMultiAPI(test.apis, 0, nil, 1)
+			w := doRequest(InstantQueryHandler(multi), "/api/v1/query", url.Values{"query": {"up"}})
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+			}
+			if header := w.Header().Get(CompletenessHeader); header != test.header {
+				t.Fatalf("mismatch in completeness header expected=%q actual=%q", test.header, header)
+			}
+		})
+	}
+
+	// Without a fan-out there is nothing to score
+	w := doRequest(InstantQueryHandler(&stubAPI{v: model.Vector{}}), "/api/v1/query", url.Values{"query": {"up"}})
+	if header := w.Header().Get(CompletenessHeader); header != "" {
+		t.Fatalf("mismatch in completeness header expected=%q actual=%q", "", header)
+	}
+}
+
+// selectsAPI records Selects in the query stats of the context, as the queriers
+// of the engine do
+type selectsAPI struct {
+	stubAPI
+}
+
+func (s *selectsAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	if r := promclient.QueryStatsRecorderFromContext(ctx); r != nil {
+		r.RecordSelects(3, 2)
+	}
+	return s.stubAPI.Query(ctx, query, ts)
+}
+
+func TestQueryStats(t *testing.T) {
+	stub := &selectsAPI{stubAPI{v: model.Vector{}}}
+	for _, stats := range []string{"", "all"} {
+		t.Run(stats, func(t *testing.T) {
+			w := doRequest(InstantQueryHandler(stub), "/api/v1/query", url.Values{"query": {"up"}, "stats": {stats}})
+			var resp struct {
+				Data struct {
+					Stats *promclient.QueryStats `json:"stats"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var expected *promclient.QueryStats
+			if stats != "" {
+				expected = &promclient.QueryStats{Selects: 3, MaxConcurrentSelects: 2}
+			}
+			if (resp.Data.Stats == nil) != (expected == nil) || (expected != nil && *resp.Data.Stats != *expected) {
+				t.Fatalf("mismatch in stats expected=%v actual=%v", expected, resp.Data.Stats)
+			}
+		})
+	}
+}
+
+// rangeLabelsAPI records which of the LabelNames methods were called
+type rangeLabelsAPI struct {
+	*stubAPI
+	basicCalls int
+	rangeCalls int
+	start, end time.Time
+}
+
+func (s *rangeLabelsAPI) LabelNames(ctx context.Context) ([]string, api.Warnings, error) {
+	s.basicCalls++
+	return s.stubAPI.LabelNames(ctx)
+}
+
+func (s *rangeLabelsAPI) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	s.rangeCalls++
+	s.start, s.end = startTime, endTime
+	return s.stubAPI.LabelNames(ctx)
+}
+
+func TestLabelsHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		params     url.Values
+		labelNames []string
+		basicCalls int
+		rangeCalls int
+		expected   []string
+	}{
+		{
+			name:       "no time range",
+			params:     url.Values{},
+			labelNames: []string{"job", "__name__", "instance"},
+			basicCalls: 1,
+			expected:   []string{"__name__", "instance", "job"},
+		},
+		{
+			name:       "time range",
+			params:     url.Values{"start": {"100"}, "end": {"200"}},
+			labelNames: []string{"job", "__name__"},
+			rangeCalls: 1,
+			expected:   []string{"__name__", "job"},
+		},
+		{
+			name:       "start only",
+			params:     url.Values{"start": {"100"}},
+			labelNames: []string{"job"},
+			rangeCalls: 1,
+			expected:   []string{"job"},
+		},
+		{
+			name:       "empty result",
+			params:     url.Values{},
+			basicCalls: 1,
+			expected:   []string{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stub := &rangeLabelsAPI{stubAPI: &stubAPI{labelNames: test.labelNames}}
+			w := doRequest(LabelsHandler(stub), "/api/v1/labels", test.params)
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+			}
+			if stub.basicCalls != test.basicCalls || stub.rangeCalls != test.rangeCalls {
+				t.Fatalf("mismatch in calls expected=(%d, %d) actual=(%d, %d)", test.basicCalls, test.rangeCalls, stub.basicCalls, stub.rangeCalls)
+			}
+
+			resp := &struct {
+				Status promutil.Status `json:"status"`
+				Data   []string        `json:"data"`
+			}{}
+			if err := json.Unmarshal(w.Body.Bytes(), resp); err != nil {
+				t.Fatalf("error unmarshaling response: %v", err)
+			}
+			if resp.Data == nil {
+				t.Fatalf("expected an empty array not null: %s", w.Body.String())
+			}
+			if len(resp.Data) != len(test.expected) {
+				t.Fatalf("mismatch in label names expected=%v actual=%v", test.expected, resp.Data)
+			}
+			for i, name := range test.expected {
+				if resp.Data[i] != name {
+					t.Fatalf("mismatch in label names expected=%v actual=%v", test.expected, resp.Data)
+				}
+			}
+		})
+	}
+
+	// The start parameter must be passed through to the ranged call
+	stub := &rangeLabelsAPI{stubAPI: &stubAPI{}}
+	doRequest(LabelsHandler(stub), "/api/v1/labels", url.Values{"start": {"100"}, "end": {"200"}})
+	if !stub.start.Equal(time.Unix(100, 0)) || !stub.end.Equal(time.Unix(200, 0)) {
+		t.Fatalf("mismatch in time range expected=(%v, %v) actual=(%v, %v)", time.Unix(100, 0), time.Unix(200, 0), stub.start, stub.end)
+	}
+}
+
+// blockingAPI blocks queries until they are canceled
+type blockingAPI struct {
+	stubAPI
+	started  chan struct{}
+	canceled chan struct{}
+}
+
+func (b *blockingAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	close(b.started)
+	<-ctx.Done()
+	close(b.canceled)
+	return nil, nil, &url.Error{Op: "Post", URL: "http://a:9090/api/v1/query", Err: ctx.Err()}
+}
+
+// statusRecorder records the status code written to the ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	code int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.code = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func TestClientCanceled(t *testing.T) {
+	stub := &blockingAPI{started: make(chan struct{}), canceled: make(chan struct{})}
+	statuses := make(chan string, 1)
+	client := promclient.NewMultiAPI([]promclient.API{stub}, 0, func(i int, api, status string, took float64) {
+		statuses <- status
+	}, 1)
+
+	handled := make(chan int, 1)
+	h := InstantQueryHandler(client)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(rec, r)
+		handled <- rec.code
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stub.started
+		cancel()
+	}()
+	req, err := http.NewRequest("GET", srv.URL+"/api/v1/query?query=up", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := http.DefaultClient.Do(req.WithContext(ctx)); err == nil {
+		t.Fatalf("expected error from canceled request")
+	}
+
+	timeout := time.After(5 * time.Second)
+	// The downstream call is aborted
+	select {
+	case <-stub.canceled:
+	case <-timeout:
+		t.Fatalf("downstream call wasn't canceled")
+	}
+	// It's reported as canceled, not as an error
+	select {
+	case status := <-statuses:
+		if status != promclient.CallStatusCanceled {
+			t.Fatalf("mismatch in status expected=%s actual=%s", promclient.CallStatusCanceled, status)
+		}
+	case <-timeout:
+		t.Fatalf("downstream call wasn't recorded")
+	}
+	select {
+	case code := <-handled:
+		if code != StatusClientClosedRequest {
+			t.Fatalf("mismatch in status code expected=%d actual=%d", StatusClientClosedRequest, code)
+		}
+	case <-timeout:
+		t.Fatalf("request wasn't handled")
+	}
+}
+
+// deleteAPI records the deletes it receives
+type deleteAPI struct {
+	matches [][]string
+}
+
+func (d *deleteAPI) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	d.matches = append(d.matches, matches)
+	return nil
+}
+
+func (d *deleteAPI) Snapshot(ctx context.Context, skipHead bool) (*promclient.SnapshotResult, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestDeleteSeriesHandler(t *testing.T) {
+	limiter, clock := newTestRateLimiter(DefaultDeleteSeriesRateLimit)
+
+	tests := []struct {
+		name     string
+		readOnly bool
+		method   string
+		query    string
+		advance  time.Duration
+		code     int
+	}{
+		{name: "read only", readOnly: true, method: http.MethodPost, query: "match[]=up", code: http.StatusMethodNotAllowed},
+		{name: "delete", method: http.MethodPost, query: "match[]=up&start=0&end=100", code: http.StatusNoContent},
+		{name: "rate limited", method: http.MethodPost, query: "match[]=up", code: http.StatusTooManyRequests},
+		// Invalid requests are rejected before they count against the limit
+		{name: "missing match", method: http.MethodPost, advance: time.Second, code: http.StatusBadRequest},
+		{name: "invalid method", method: http.MethodGet, query: "match[]=up", code: http.StatusMethodNotAllowed},
+		{name: "delete after wait", method: http.MethodPut, query: "match[]=up&match[]=down", code: http.StatusNoContent},
+	}
+
+	admin := &deleteAPI{}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock.Advance(test.advance)
+			h := DeleteSeriesHandler(admin, test.readOnly, limiter)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(test.method, "/api/v1/admin/tsdb/delete_series?"+test.query, nil))
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d (%s)", test.code, w.Code, w.Body.String())
+			}
+		})
+	}
+
+	if expected := [][]string{{"up"}, {"up", "down"}}; fmt.Sprint(admin.matches) != fmt.Sprint(expected) {
+		t.Fatalf("mismatch in deletes expected=%v actual=%v", expected, admin.matches)
+	}
+}
+
+// snapshotAPI creates snapshots with a fake name
+type snapshotAPI struct {
+	deleteAPI
+	skipHead []bool
+}
+
+func (s *snapshotAPI) Snapshot(ctx context.Context, skipHead bool) (*promclient.SnapshotResult, error) {
+	s.skipHead = append(s.skipHead, skipHead)
+	return &promclient.SnapshotResult{Name: "20200101T000000Z-fake"}, nil
+}
+
+func TestSnapshotHandler(t *testing.T) {
+	mw, err := AuthMiddleware(ServerAuthConfig{
+		AuthConfig: AuthConfig{BearerTokens: map[string]string{"token-b": "bob"}},
+		Admin:      &AuthConfig{BearerTokens: map[string]string{"token-admin": "admin"}},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	admin := &snapshotAPI{}
+	h := NewChain(MiddlewareConfig{}).Use(mw).Then(SnapshotHandler(admin))
+
+	tests := []struct {
+		name   string
+		token  string
+		method string
+		query  string
+		code   int
+	}{
+		// The admin auth is required
+		{name: "user", token: "token-b", method: http.MethodPost, code: http.StatusUnauthorized},
+		{name: "invalid method", token: "token-admin", method: http.MethodGet, code: http.StatusMethodNotAllowed},
+		{name: "invalid skip_head", token: "token-admin", method: http.MethodPost, query: "skip_head=maybe", code: http.StatusBadRequest},
+		{name: "snapshot", token: "token-admin", method: http.MethodPost, code: http.StatusOK},
+		{name: "skip head", token: "token-admin", method: http.MethodPost, query: "skip_head=true", code: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "/api/v1/admin/tsdb/snapshot?"+test.query, nil)
+			r.Header.Set("Authorization", "Bearer "+test.token)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d (%s)", test.code, w.Code, w.Body.String())
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			var resp struct {
+				Data promclient.SnapshotResult `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Data.Name != "20200101T000000Z-fake" {
+				t.Fatalf("mismatch in name expected=%s actual=%s", "20200101T000000Z-fake", resp.Data.Name)
+			}
+		})
+	}
+
+	if expected := []bool{false, true}; fmt.Sprint(admin.skipHead) != fmt.Sprint(expected) {
+		t.Fatalf("mismatch in snapshots expected=%v actual=%v", expected, admin.skipHead)
+	}
+}
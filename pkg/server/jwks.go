@@ -0,0 +1,277 @@
+package server
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jwksRefreshInterval is how often the keys are refetched, keys for an unknown
+// key ID are fetched at most once per jwksMinRefreshInterval
+const (
+	jwksRefreshInterval    = time.Hour
+	jwksMinRefreshInterval = time.Minute
+)
+
+// jwksVerifier verifies RS256 JWTs for an audience and issuer against the keys
+// of a JWKS URL
+type jwksVerifier struct {
+	url      string
+	audience string
+	issuer   string
+	client   *http.Client
+
+	// fetchL serializes the fetches, so the keys are only locked to swap them
+	fetchL sync.Mutex
+
+	l          sync.Mutex
+	keys       map[string]*rsa.PublicKey
+	fetched    time.Time
+	failed     time.Time
+	refreshing bool
+}
+
+func newJWKSVerifier(url, audience, issuer string) *jwksVerifier {
+	return &jwksVerifier{
+		url:      url,
+		audience: audience,
+		issuer:   issuer,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// cached returns the key with the given ID (if we have it) and the time since
+// the keys were fetched
+func (j *jwksVerifier) cached(kid string) (*rsa.PublicKey, bool, time.Duration) {
+	j.l.Lock()
+	defer j.l.Unlock()
+	key, ok := j.keys[kid]
+	return key, ok, time.Since(j.fetched)
+}
+
+// key returns the key with the given ID, refetching the keys if needed. Known
+// keys are used while they are refreshed in the background, so only the tokens
+// of unknown keys wait for a fetch
+func (j *jwksVerifier) key(kid string) (*rsa.PublicKey, error) {
+	key, ok, sinceFetch := j.cached(kid)
+	if ok {
+		if sinceFetch >= jwksRefreshInterval {
+			j.refreshInBackground()
+		}
+		return key, nil
+	}
+	if sinceFetch < jwksMinRefreshInterval || j.backingOff() {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+
+	if err := j.refresh(jwksMinRefreshInterval); err != nil {
+		return nil, err
+	}
+	if key, ok, _ = j.cached(kid); !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// backingOff returns whether the last fetch failed less than
+// jwksMinRefreshInterval ago, in which case the keys aren't fetched again
+func (j *jwksVerifier) backingOff() bool {
+	j.l.Lock()
+	defer j.l.Unlock()
+	return time.Since(j.failed) < jwksMinRefreshInterval
+}
+
+// refreshInBackground refreshes the keys unless a refresh is running already,
+// or the last one failed less than jwksMinRefreshInterval ago
+func (j *jwksVerifier) refreshInBackground() {
+	j.l.Lock()
+	defer j.l.Unlock()
+	if j.refreshing || time.Since(j.failed) < jwksMinRefreshInterval {
+		return
+	}
+	j.refreshing = true
+
+	go func() {
+		err := j.refresh(jwksRefreshInterval)
+		j.l.Lock()
+		defer j.l.Unlock()
+		j.refreshing = false
+		if err != nil {
+			// Keep using the keys we have until the JWKS URL recovers
+			logger.WithField("url", j.url).Warnf("Error refreshing JWKS: %v", err)
+		}
+	}()
+}
+
+// refresh fetches the keys, unless they were fetched less than maxAge ago (e.g.
+// by a concurrent refresh). A failed fetch isn't retried for
+// jwksMinRefreshInterval, so the requests waiting on it don't each fetch again.
+func (j *jwksVerifier) refresh(maxAge time.Duration) error {
+	j.fetchL.Lock()
+	defer j.fetchL.Unlock()
+	if _, _, sinceFetch := j.cached(""); sinceFetch < maxAge {
+		return nil
+	}
+	if j.backingOff() {
+		return fmt.Errorf("error fetching JWKS: retrying after a failed fetch")
+	}
+
+	keys, err := j.fetch()
+	if err != nil {
+		j.l.Lock()
+		j.failed = time.Now()
+		j.l.Unlock()
+		return err
+	}
+	j.l.Lock()
+	j.keys = keys
+	j.fetched = time.Now()
+	j.l.Unlock()
+	return nil
+}
+
+// fetch loads the RSA keys from the JWKS URL
+func (j *jwksVerifier) fetch() (map[string]*rsa.PublicKey, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching JWKS: %s", resp.Status)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, fmt.Errorf("error decoding JWKS: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(jwks.Keys))
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus for key %q: %v", k.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exponent for key %q: %v", k.Kid, err)
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+// Verify verifies the signature, audience, issuer and validity period of the
+// token, returning its subject. Tokens without an expiry are rejected
+func (j *jwksVerifier) Verify(token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "RS256" {
+		return "", fmt.Errorf("unsupported token algorithm %q", header.Alg)
+	}
+
+	key, err := j.key(header.Kid)
+	if err != nil {
+		return "", err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("malformed token signature: %v", err)
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return "", fmt.Errorf("invalid token signature")
+	}
+
+	var claims struct {
+		Subject   string          `json:"sub"`
+		Issuer    string          `json:"iss"`
+		Audience  json.RawMessage `json:"aud"`
+		ExpiresAt *int64          `json:"exp"`
+		NotBefore *int64          `json:"nbf"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.Issuer != j.issuer {
+		return "", fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if !audienceContains(claims.Audience, j.audience) {
+		return "", fmt.Errorf("token not issued for audience %q", j.audience)
+	}
+	now := time.Now().Unix()
+	if claims.ExpiresAt == nil {
+		return "", fmt.Errorf("token has no expiry")
+	}
+	if now >= *claims.ExpiresAt {
+		return "", fmt.Errorf("token expired")
+	}
+	if claims.NotBefore != nil && now < *claims.NotBefore {
+		return "", fmt.Errorf("token not yet valid")
+	}
+	if claims.Subject == "" {
+		return "", fmt.Errorf("token has no subject")
+	}
+	return claims.Subject, nil
+}
+
+// audienceContains returns whether the aud claim, which is either a string or
+// an array of strings, contains the audience
+func audienceContains(aud json.RawMessage, audience string) bool {
+	var single string
+	if err := json.Unmarshal(aud, &single); err == nil {
+		return single == audience
+	}
+	var multiple []string
+	if err := json.Unmarshal(aud, &multiple); err != nil {
+		return false
+	}
+	for _, a := range multiple {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed token: %v", err)
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("malformed token: %v", err)
+	}
+	return nil
+}
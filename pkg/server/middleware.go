@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/promproxy/pkg/server/middleware"
+)
+
+// Stage is a named position in a middleware Chain. Requests pass through the
+// stages in the order they are declared here, before reaching the handler.
+type Stage int
+
+const (
+	// StageCorrelation attaches the IDs used to trace a request
+	StageCorrelation Stage = iota
+	// StageCORS answers CORS preflight requests (which carry no credentials) and
+	// sets the CORS headers of responses
+	StageCORS
+	// StageAuth authenticates the request
+	StageAuth
+	// StageTenancy determines the tenant of the (authenticated) request
+	StageTenancy
+	// StageDryRun makes the request a dry run (if requested)
+	StageDryRun
+	// StageLimits applies rate and concurrency limits
+	StageLimits
+	// StageStats records stats about the request
+	StageStats
+	// StageCompression compresses the response
+	StageCompression
+
+	numStages
+)
+
+var stageNames = [numStages]string{
+	StageCorrelation: "correlation",
+	StageCORS:        "cors",
+	StageAuth:        "auth",
+	StageTenancy:     "tenancy",
+	StageDryRun:      "dry_run",
+	StageLimits:      "limits",
+	StageStats:       "stats",
+	StageCompression: "compression",
+}
+
+func (s Stage) String() string {
+	if s >= 0 && s < numStages {
+		return stageNames[s]
+	}
+	return fmt.Sprintf("stage(%d)", int(s))
+}
+
+// ParseStage returns the Stage with the given name
+func ParseStage(name string) (Stage, error) {
+	for s, stageName := range stageNames {
+		if stageName == name {
+			return Stage(s), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown middleware stage %q", name)
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (s *Stage) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var name string
+	if err := unmarshal(&name); err != nil {
+		return err
+	}
+	stage, err := ParseStage(name)
+	if err != nil {
+		return err
+	}
+	*s = stage
+	return nil
+}
+
+// Middleware wraps a handler at a specific Stage of a Chain
+type Middleware interface {
+	// Stage returns the stage of the chain this middleware belongs in
+	Stage() Stage
+	// Wrap returns a handler which calls `next` (if the request should proceed)
+	Wrap(next http.Handler) http.Handler
+}
+
+// MiddlewareFunc adapts a plain handler wrapping func into a Middleware
+type MiddlewareFunc struct {
+	S Stage
+	F func(http.Handler) http.Handler
+}
+
+// Stage returns the stage of the chain this middleware belongs in
+func (m MiddlewareFunc) Stage() Stage { return m.S }
+
+// Wrap returns a handler which calls `next` (if the request should proceed)
+func (m MiddlewareFunc) Wrap(next http.Handler) http.Handler { return m.F(next) }
+
+// MiddlewareConfig configures which stages of the middleware Chain are enabled
+type MiddlewareConfig struct {
+	// Disabled is the list of stages whose middleware is skipped
+	Disabled []Stage `yaml:"disabled"`
+}
+
+// Chain builds the handler for a request out of middleware in stage order,
+// regardless of the order the middleware was added in. Within a stage middleware
+// runs in the order it was added.
+type Chain struct {
+	stages   [numStages][]Middleware
+	disabled [numStages]bool
+}
+
+// NewChain returns an empty Chain with the stages disabled by the config
+func NewChain(cfg MiddlewareConfig) *Chain {
+	c := &Chain{}
+	for _, s := range cfg.Disabled {
+		if s >= 0 && s < numStages {
+			c.disabled[s] = true
+		}
+	}
+	return c
+}
+
+// Use adds the middleware to its stage of the chain
+func (c *Chain) Use(middleware ...Middleware) *Chain {
+	for _, m := range middleware {
+		s := m.Stage()
+		if s < 0 || s >= numStages {
+			panic(fmt.Sprintf("middleware for unknown %v", s))
+		}
+		c.stages[s] = append(c.stages[s], m)
+	}
+	return c
+}
+
+// Then returns `h` wrapped in all of the enabled middleware of the chain
+func (c *Chain) Then(h http.Handler) http.Handler {
+	// Wrap from the innermost (last) middleware outwards
+	for s := numStages - 1; s >= 0; s-- {
+		if c.disabled[s] {
+			continue
+		}
+		for i := len(c.stages[s]) - 1; i >= 0; i-- {
+			h = c.stages[s][i].Wrap(h)
+		}
+	}
+	return h
+}
+
+// ThenFunc is Then for a HandlerFunc
+func (c *Chain) ThenFunc(h http.HandlerFunc) http.Handler {
+	return c.Then(h)
+}
+
+// CORSMiddleware returns middleware.CORSMiddleware as a Middleware for a Chain
+func CORSMiddleware(cfg middleware.CORSConfig) Middleware {
+	return MiddlewareFunc{S: StageCORS, F: middleware.CORSMiddleware(cfg)}
+}
+
+// CompressMiddleware returns middleware.CompressMiddleware as a Middleware for a
+// Chain
+func CompressMiddleware(cfg middleware.CompressConfig) Middleware {
+	return MiddlewareFunc{S: StageCompression, F: middleware.CompressMiddleware(cfg)}
+}
@@ -0,0 +1,212 @@
+package middleware
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// CompressConfig configures the compression of responses
+type CompressConfig struct {
+	// Level is the gzip compression level (gzip.DefaultCompression if 0)
+	Level int `yaml:"level"`
+	// MinSize is the size (in bytes) below which responses aren't compressed, as
+	// the overhead isn't worth it
+	MinSize int `yaml:"min_size"`
+}
+
+// DefaultCompressConfig is the CompressConfig used for unset fields
+var DefaultCompressConfig = CompressConfig{
+	MinSize: 1024,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CompressConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultCompressConfig
+	type plain CompressConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c CompressConfig) Validate() error {
+	if c.Level < gzip.HuffmanOnly || c.Level > gzip.BestCompression {
+		return fmt.Errorf("compression level must be between %d and %d", gzip.HuffmanOnly, gzip.BestCompression)
+	}
+	if c.MinSize < 0 {
+		return fmt.Errorf("compression min_size must not be negative")
+	}
+	return nil
+}
+
+func (c CompressConfig) level() int {
+	if c.Level == 0 {
+		return gzip.DefaultCompression
+	}
+	return c.Level
+}
+
+// compressibleTypes are the prefixes of the content types which are compressed,
+// other types (e.g. images or snappy encoded remote read) are compressed already
+var compressibleTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/openmetrics-text",
+}
+
+func compressible(contentType string) bool {
+	for _, prefix := range compressibleTypes {
+		if strings.HasPrefix(contentType, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip returns whether the client accepts gzip encoded responses
+func acceptsGzip(r *http.Request) bool {
+	for _, encoding := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		encoding = strings.TrimSpace(encoding)
+		if i := strings.Index(encoding, ";"); i >= 0 {
+			if strings.TrimSpace(encoding[i+1:]) == "q=0" {
+				continue
+			}
+			encoding = strings.TrimSpace(encoding[:i])
+		}
+		if encoding == "gzip" || encoding == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter holds back the response until MinSize bytes were written, then
+// decides whether to compress it based on its content type
+type compressWriter struct {
+	http.ResponseWriter
+	cfg CompressConfig
+
+	code    int
+	buf     []byte
+	started bool
+	gz      *gzip.Writer
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.code == 0 {
+		c.code = code
+	}
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if c.code == 0 {
+		c.code = http.StatusOK
+	}
+	if c.started {
+		if c.gz != nil {
+			return c.gz.Write(b)
+		}
+		return c.ResponseWriter.Write(b)
+	}
+
+	c.buf = append(c.buf, b...)
+	if len(c.buf) >= c.cfg.MinSize {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// start writes the header and the held back response, compressed if requested
+// and its content type is compressible
+func (c *compressWriter) start(compress bool) error {
+	c.started = true
+	h := c.Header()
+	// The content type must be detected before the body is compressed
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	if compress && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		c.ResponseWriter.WriteHeader(c.code)
+		gz, err := gzip.NewWriterLevel(c.ResponseWriter, c.cfg.level())
+		if err != nil {
+			return err
+		}
+		c.gz = gz
+		_, err = c.gz.Write(c.buf)
+		c.buf = nil
+		return err
+	}
+
+	c.ResponseWriter.WriteHeader(c.code)
+	_, err := c.ResponseWriter.Write(c.buf)
+	c.buf = nil
+	return err
+}
+
+// close writes what is left of the response
+func (c *compressWriter) close() error {
+	if !c.started {
+		// Nothing was written at all, which is left to the server
+		if c.code == 0 {
+			return nil
+		}
+		// Responses below MinSize are written as is
+		return c.start(false)
+	}
+	if c.gz != nil {
+		return c.gz.Close()
+	}
+	return nil
+}
+
+// CompressMiddleware returns the middleware which gzip compresses the responses
+// for clients which accept it. Responses below MinSize and content types which
+// don't compress well (see compressibleTypes) are sent uncompressed.
+func CompressMiddleware(cfg CompressConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, cfg: cfg}
+			next.ServeHTTP(cw, r)
+			if err := cw.close(); err != nil {
+				if ClientGone(err) {
+					logger.Debugf("Client went away before the response was written: %v", err)
+				} else {
+					logger.Errorf("error writing compressed response: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// ClientGone returns whether a write failed as the client closed the connection
+func ClientGone(err error) bool {
+	cause := errors.Cause(err)
+	if opErr, ok := cause.(*net.OpError); ok {
+		cause = opErr.Err
+	}
+	if sysErr, ok := cause.(*os.SyscallError); ok {
+		cause = sysErr.Err
+	}
+	return cause == context.Canceled || cause == syscall.EPIPE || cause == syscall.ECONNRESET
+}
@@ -0,0 +1,169 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompressMiddleware(t *testing.T) {
+	large := `{"status":"success","data":[` + strings.Repeat(`"up",`, 500) + `"up"]}`
+	small := `{"status":"success","data":[]}`
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		body           string
+		compressed     bool
+	}{
+		{
+			name:           "gzip",
+			acceptEncoding: "gzip, deflate",
+			contentType:    "application/json",
+			body:           large,
+			compressed:     true,
+		},
+		{
+			name:        "no accept-encoding",
+			contentType: "application/json",
+			body:        large,
+		},
+		{
+			name:           "gzip not acceptable",
+			acceptEncoding: "gzip;q=0, deflate",
+			contentType:    "application/json",
+			body:           large,
+		},
+		{
+			name:           "below min size",
+			acceptEncoding: "gzip",
+			contentType:    "application/json",
+			body:           small,
+		},
+		{
+			name:           "non-text type",
+			acceptEncoding: "gzip",
+			contentType:    "image/jpeg",
+			body:           large,
+		},
+		// The content type is detected before the body is compressed
+		{
+			name:           "detected type",
+			acceptEncoding: "gzip",
+			body:           "<html>" + large + "</html>",
+			compressed:     true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := CompressMiddleware(CompressConfig{MinSize: 1024})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.contentType != "" {
+					w.Header().Set("Content-Type", test.contentType)
+				}
+				w.WriteHeader(http.StatusOK)
+				// Written in pieces, across the min size
+				for i := 0; i < len(test.body); i += 100 {
+					end := i + 100
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+					w.Write([]byte(test.body[i:end]))
+				}
+			}))
+
+			r := httptest.NewRequest("GET", "/api/v1/labels", nil)
+			if test.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Fatalf("mismatch in Vary expected=%q actual=%q", "Accept-Encoding", vary)
+			}
+			compressed := w.Header().Get("Content-Encoding") == "gzip"
+			if compressed != test.compressed {
+				t.Fatalf("mismatch in compressed expected=%v actual=%v", test.compressed, compressed)
+			}
+
+			body := w.Body.Bytes()
+			if compressed {
+				gz, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					t.Fatalf("invalid gzip response: %v", err)
+				}
+				if body, err = ioutil.ReadAll(gz); err != nil {
+					t.Fatalf("invalid gzip response: %v", err)
+				}
+			}
+			if string(body) != test.body {
+				t.Fatalf("mismatch in body expected=%q actual=%q", test.body, body)
+			}
+		})
+	}
+}
+
+func TestCompressMiddlewareEmpty(t *testing.T) {
+	h := CompressMiddleware(DefaultCompressConfig)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r := httptest.NewRequest("OPTIONS", "/api/v1/query", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusNoContent, w.Code)
+	}
+	if encoding := w.Header().Get("Content-Encoding"); encoding != "" {
+		t.Fatalf("mismatch in Content-Encoding expected=%q actual=%q", "", encoding)
+	}
+}
+
+// discardWriter is a ResponseWriter which discards the response
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkCompressMiddleware(b *testing.B) {
+	// ~1MB of series JSON
+	var buf bytes.Buffer
+	buf.WriteString(`{"status":"success","data":[`)
+	for i := 0; buf.Len() < 1<<20; i++ {
+		fmt.Fprintf(&buf, `{"__name__":"http_requests_total","instance":"host-%d:9090","job":"api","code":"%d"},`, i%1000, 200+i%5)
+	}
+	buf.WriteString(`{}]}`)
+	payload := buf.Bytes()
+
+	for _, level := range []int{1, 6} {
+		b.Run(fmt.Sprintf("level=%d", level), func(b *testing.B) {
+			h := CompressMiddleware(CompressConfig{Level: level, MinSize: 1024})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(payload)
+			}))
+			r := httptest.NewRequest("GET", "/api/v1/series", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+
+			// Reported as the MB/s of uncompressed input
+			b.SetBytes(int64(len(payload)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				h.ServeHTTP(&discardWriter{header: make(http.Header)}, r)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures the CORS headers which allow browser apps served from
+// other origins (e.g. a dashboard) to call the API
+type CORSConfig struct {
+	// AllowedOrigins are the origins which may call the API, "*" allows any origin
+	AllowedOrigins []string `yaml:"allowed_origins"`
+	// AllowedMethods are the methods which may be used
+	AllowedMethods []string `yaml:"allowed_methods"`
+	// AllowedHeaders are the request headers which may be sent
+	AllowedHeaders []string `yaml:"allowed_headers"`
+	// AllowCredentials allows requests with credentials (cookies or an
+	// Authorization header)
+	AllowCredentials bool `yaml:"allow_credentials"`
+	// MaxAge is how long browsers may cache the result of a preflight request
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// DefaultCORSConfig is the CORSConfig used for unset fields
+var DefaultCORSConfig = CORSConfig{
+	AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodOptions},
+	AllowedHeaders: []string{"Content-Type", "Authorization"},
+	MaxAge:         10 * time.Minute,
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *CORSConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultCORSConfig
+	type plain CORSConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c CORSConfig) Validate() error {
+	if len(c.AllowedOrigins) == 0 {
+		return fmt.Errorf("cors allowed_origins must not be empty")
+	}
+	if c.MaxAge < 0 {
+		return fmt.Errorf("cors max_age must not be negative")
+	}
+	return nil
+}
+
+// allowsAnyOrigin returns whether the wildcard origin is allowed
+func (c CORSConfig) allowsAnyOrigin() bool {
+	for _, origin := range c.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsOrigin returns whether the origin may call the API
+func (c CORSConfig) allowsOrigin(origin string) bool {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(allowed, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CORSMiddleware returns the middleware which sets the CORS headers for requests
+// from the allowed origins and answers their preflight requests. Requests from
+// other origins are passed on without CORS headers, so browsers block them.
+//
+// With credentials allowed the origin of the request is returned rather than the
+// wildcard (which browsers reject for requests with credentials), so any site may
+// make requests with the credentials of its user. This is logged as a warning.
+func CORSMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	anyOrigin := cfg.allowsAnyOrigin()
+	if anyOrigin {
+		if cfg.AllowCredentials {
+			logger.Warn("CORS allows requests with credentials from any origin, any website can call the API on behalf of its users")
+		} else {
+			logger.Warn("CORS allows requests from any origin")
+		}
+	}
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// The response depends on the origin, so it must not be cached for others
+			w.Header().Add("Vary", "Origin")
+			origin := r.Header.Get("Origin")
+			if origin == "" || !cfg.allowsOrigin(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if anyOrigin && !cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			if cfg.AllowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Preflight requests are answered here, they don't carry credentials
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,259 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestCORSMiddleware(t *testing.T) {
+	allowed := DefaultCORSConfig
+	allowed.AllowedOrigins = []string{"https://grafana.example.com"}
+	allowed.MaxAge = 5 * time.Minute
+
+	credentials := allowed
+	credentials.AllowCredentials = true
+
+	wildcard := DefaultCORSConfig
+	wildcard.AllowedOrigins = []string{"*"}
+
+	tests := []struct {
+		name      string
+		cfg       CORSConfig
+		method    string
+		origin    string
+		preflight bool
+		// headers are the expected response headers, empty values must be unset
+		headers map[string]string
+		// handled is whether the request reached the handler
+		handled bool
+	}{
+		{
+			name:      "preflight",
+			cfg:       allowed,
+			method:    "OPTIONS",
+			origin:    "https://grafana.example.com",
+			preflight: true,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://grafana.example.com",
+				"Access-Control-Allow-Methods":     "GET, POST, OPTIONS",
+				"Access-Control-Allow-Headers":     "Content-Type, Authorization",
+				"Access-Control-Max-Age":           "300",
+				"Access-Control-Allow-Credentials": "",
+			},
+		},
+		{
+			name:   "allowed origin",
+			cfg:    allowed,
+			method: "GET",
+			origin: "https://grafana.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "https://grafana.example.com",
+				"Access-Control-Allow-Methods": "",
+				"Access-Control-Max-Age":       "",
+			},
+			handled: true,
+		},
+		{
+			name:   "disallowed origin",
+			cfg:    allowed,
+			method: "GET",
+			origin: "https://evil.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin": "",
+			},
+			handled: true,
+		},
+		{
+			name:      "disallowed origin preflight",
+			cfg:       allowed,
+			method:    "OPTIONS",
+			origin:    "https://evil.example.com",
+			preflight: true,
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":  "",
+				"Access-Control-Allow-Methods": "",
+			},
+			handled: true,
+		},
+		{
+			name:    "no origin",
+			cfg:     allowed,
+			method:  "GET",
+			headers: map[string]string{"Access-Control-Allow-Origin": ""},
+			handled: true,
+		},
+		// OPTIONS without Access-Control-Request-Method isn't a preflight
+		{
+			name:    "plain options",
+			cfg:     allowed,
+			method:  "OPTIONS",
+			origin:  "https://grafana.example.com",
+			headers: map[string]string{"Access-Control-Max-Age": ""},
+			handled: true,
+		},
+		{
+			name:   "credentials",
+			cfg:    credentials,
+			method: "GET",
+			origin: "https://grafana.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "https://grafana.example.com",
+				"Access-Control-Allow-Credentials": "true",
+			},
+			handled: true,
+		},
+		{
+			name:   "wildcard",
+			cfg:    wildcard,
+			method: "GET",
+			origin: "https://anything.example.com",
+			headers: map[string]string{
+				"Access-Control-Allow-Origin":      "*",
+				"Access-Control-Allow-Credentials": "",
+			},
+			handled: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handled := false
+			h := CORSMiddleware(test.cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				handled = true
+			}))
+
+			r := httptest.NewRequest(test.method, "/api/v1/query", nil)
+			if test.origin != "" {
+				r.Header.Set("Origin", test.origin)
+			}
+			if test.preflight {
+				r.Header.Set("Access-Control-Request-Method", "POST")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if handled != test.handled {
+				t.Fatalf("mismatch in handled expected=%v actual=%v", test.handled, handled)
+			}
+			if !test.handled && w.Code != http.StatusNoContent {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusNoContent, w.Code)
+			}
+			for k, v := range test.headers {
+				if actual := w.Header().Get(k); actual != v {
+					t.Fatalf("mismatch in %s expected=%q actual=%q", k, v, actual)
+				}
+			}
+			if vary := w.Header().Get("Vary"); vary != "Origin" {
+				t.Fatalf("mismatch in Vary expected=%q actual=%q", "Origin", vary)
+			}
+		})
+	}
+}
+
+func TestCORSMiddlewareWildcardWarning(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	tests := []struct {
+		origins     []string
+		credentials bool
+		warning     string
+		// origin is the expected Access-Control-Allow-Origin for a request from
+		// https://grafana.example.com
+		origin string
+	}{
+		{
+			origins: []string{"https://grafana.example.com"},
+			origin:  "https://grafana.example.com",
+		},
+		{
+			origins: []string{"*"},
+			warning: "CORS allows requests from any origin",
+			origin:  "*",
+		},
+		// Browsers reject the wildcard for requests with credentials
+		{
+			origins:     []string{"*"},
+			credentials: true,
+			warning:     "CORS allows requests with credentials from any origin",
+			origin:      "https://grafana.example.com",
+		},
+	}
+
+	for i, test := range tests {
+		buf.Reset()
+		cfg := DefaultCORSConfig
+		cfg.AllowedOrigins = test.origins
+		cfg.AllowCredentials = test.credentials
+		h := CORSMiddleware(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		if test.warning == "" {
+			if buf.Len() > 0 {
+				t.Fatalf("%d: unexpected log: %s", i, buf.String())
+			}
+		} else if !strings.Contains(buf.String(), test.warning) {
+			t.Fatalf("%d: missing warning %q in log: %s", i, test.warning, buf.String())
+		}
+
+		r := httptest.NewRequest("GET", "/api/v1/query", nil)
+		r.Header.Set("Origin", "https://grafana.example.com")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if origin := w.Header().Get("Access-Control-Allow-Origin"); origin != test.origin {
+			t.Fatalf("%d: mismatch in origin expected=%q actual=%q", i, test.origin, origin)
+		}
+	}
+}
+
+func TestCORSConfig(t *testing.T) {
+	tests := []struct {
+		yaml  string
+		cfg   CORSConfig
+		valid bool
+	}{
+		{
+			yaml: "allowed_origins: [https://grafana.example.com]",
+			cfg: CORSConfig{
+				AllowedOrigins: []string{"https://grafana.example.com"},
+				AllowedMethods: DefaultCORSConfig.AllowedMethods,
+				AllowedHeaders: DefaultCORSConfig.AllowedHeaders,
+				MaxAge:         DefaultCORSConfig.MaxAge,
+			},
+			valid: true,
+		},
+		{
+			yaml: "{allowed_origins: ['*'], allow_credentials: true, max_age: 1h}",
+			cfg: CORSConfig{
+				AllowedOrigins:   []string{"*"},
+				AllowedMethods:   DefaultCORSConfig.AllowedMethods,
+				AllowedHeaders:   DefaultCORSConfig.AllowedHeaders,
+				AllowCredentials: true,
+				MaxAge:           time.Hour,
+			},
+			valid: true,
+		},
+		{yaml: "allow_credentials: true"},
+		{yaml: "{allowed_origins: ['*'], max_age: -1s}"},
+	}
+
+	for i, test := range tests {
+		cfg := CORSConfig{}
+		err := yaml.Unmarshal([]byte(test.yaml), &cfg)
+		if valid := err == nil; valid != test.valid {
+			t.Fatalf("%d: mismatch in valid expected=%v actual=%v (%v)", i, test.valid, valid, err)
+		}
+		if test.valid && !reflect.DeepEqual(cfg, test.cfg) {
+			t.Fatalf("%d: mismatch in config expected=%+v actual=%+v", i, test.cfg, cfg)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import "net/http"
+
+// WarningsHeader is the response header carrying the warnings of a response, one
+// value each
+const WarningsHeader = "X-Prometheus-Warnings"
+
+// WarningDeduplicationMiddleware removes the repeated values of the WarningsHeader
+// of the responses, e.g. the same warning added for each of the upstreams it came
+// from. The values the handlers add are collected until the status code is
+// written, then written once each, in the order they were first added.
+func WarningDeduplicationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dw := &warningDeduplicationWriter{ResponseWriter: w}
+		next.ServeHTTP(dw, r)
+		if !dw.wroteHeader {
+			dedupWarnings(w.Header())
+		}
+	})
+}
+
+// warningDeduplicationWriter deduplicates the WarningsHeader right before the
+// status code is written
+type warningDeduplicationWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+// WriteHeader deduplicates the warnings before writing the status code
+func (d *warningDeduplicationWriter) WriteHeader(code int) {
+	if !d.wroteHeader {
+		d.wroteHeader = true
+		dedupWarnings(d.Header())
+	}
+	d.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the data, writing a 200 first if no status code was written
+func (d *warningDeduplicationWriter) Write(b []byte) (int, error) {
+	if !d.wroteHeader {
+		d.WriteHeader(http.StatusOK)
+	}
+	return d.ResponseWriter.Write(b)
+}
+
+// dedupWarnings removes the repeated values of the WarningsHeader of h
+func dedupWarnings(h http.Header) {
+	values := h[WarningsHeader]
+	if len(values) < 2 {
+		return
+	}
+	seen := make(map[string]struct{}, len(values))
+	deduped := make([]string, 0, len(values))
+	for _, v := range values {
+		if _, ok := seen[v]; ok {
+			continue
+		}
+		seen[v] = struct{}{}
+		deduped = append(deduped, v)
+	}
+	h[WarningsHeader] = deduped
+}
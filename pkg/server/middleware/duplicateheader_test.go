@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestWarningDeduplicationMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		warnings []string
+		// write is whether the handler writes a body
+		write    bool
+		expected []string
+	}{
+		{
+			name:     "identical",
+			warnings: []string{"backend a is down", "backend a is down", "backend a is down"},
+			write:    true,
+			expected: []string{"backend a is down"},
+		},
+		{
+			name:     "order kept",
+			warnings: []string{"b", "a", "b", "c", "a"},
+			write:    true,
+			expected: []string{"b", "a", "c"},
+		},
+		{
+			name:     "no body",
+			warnings: []string{"a", "a"},
+			expected: []string{"a"},
+		},
+		{
+			name: "none",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := WarningDeduplicationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, warning := range test.warnings {
+					w.Header().Add(WarningsHeader, warning)
+				}
+				if test.write {
+					w.Write([]byte("{}"))
+				}
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/v1/query", nil))
+
+			if warnings := rec.Result().Header[WarningsHeader]; !reflect.DeepEqual(warnings, test.expected) {
+				t.Fatalf("mismatch in warnings expected=%q actual=%q", test.expected, warnings)
+			}
+		})
+	}
+}
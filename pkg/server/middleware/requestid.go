@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+
+	"github.com/promproxy/pkg/logging"
+	"github.com/promproxy/pkg/promclient"
+)
+
+var logger = logging.Component(logging.ComponentServer)
+
+// RequestIDHeader is the header a request's ID is read from (if the client set a
+// valid one) and returned in
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID returns whether id is an RFC 4122 version 4 UUID
+func validRequestID(id string) bool {
+	parsed, err := uuid.Parse(id)
+	if err != nil {
+		return false
+	}
+	return parsed.Version() == 4 && parsed.Variant() == uuid.RFC4122
+}
+
+// RequestIDMiddleware makes sure each request has an ID (a UUID v4) in its
+// RequestIDHeader. A valid ID set by the client is kept, otherwise a new one is
+// generated. The ID is returned in the response and attached to the context as
+// its correlation ID (see promclient.WithCorrelationID), so the backend calls
+// made on behalf of the request are logged with it.
+func RequestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			if id != "" {
+				logger.Warnf("Replacing invalid request ID %q, it must be a UUID v4", id)
+			}
+			id = uuid.New().String()
+			// Handlers must not modify the request, so the header is set on a copy
+			header := make(http.Header, len(r.Header)+1)
+			for k, v := range r.Header {
+				header[k] = v
+			}
+			header.Set(RequestIDHeader, id)
+			r = r.WithContext(r.Context())
+			r.Header = header
+		}
+		w.Header().Set(RequestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(promclient.WithCorrelationID(r.Context(), id)))
+	})
+}
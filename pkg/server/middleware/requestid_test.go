@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logrus.SetOutput(buf)
+	defer logrus.SetOutput(os.Stderr)
+
+	var forwarded, fromContext string
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(RequestIDHeader)
+		fromContext = promclient.CorrelationIDFromContext(r.Context())
+	}))
+
+	valid := uuid.New().String()
+	tests := []struct {
+		name string
+		id   string
+		// kept is whether the ID of the request is kept
+		kept bool
+		warn bool
+	}{
+		{name: "missing"},
+		{name: "valid", id: valid, kept: true},
+		{name: "not a uuid", id: "abc123", warn: true},
+		// A valid UUID, but of version 1
+		{name: "not v4", id: "6ba7b810-9dad-11d1-80b4-00c04fd430c8", warn: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/query", nil)
+			if test.id != "" {
+				req.Header.Set(RequestIDHeader, test.id)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if test.kept && forwarded != test.id {
+				t.Fatalf("mismatch in forwarded ID expected=%s actual=%s", test.id, forwarded)
+			}
+			if !test.kept {
+				if forwarded == test.id {
+					t.Fatalf("expected a new ID, got %s", forwarded)
+				}
+				if !validRequestID(forwarded) {
+					t.Fatalf("generated ID isn't a UUID v4: %s", forwarded)
+				}
+				// The request of the client isn't modified
+				if actual := req.Header.Get(RequestIDHeader); actual != test.id {
+					t.Fatalf("mismatch in client request ID expected=%s actual=%s", test.id, actual)
+				}
+			}
+			if actual := w.Header().Get(RequestIDHeader); actual != forwarded {
+				t.Fatalf("mismatch in response ID expected=%s actual=%s", forwarded, actual)
+			}
+			if fromContext != forwarded {
+				t.Fatalf("mismatch in context ID expected=%s actual=%s", forwarded, fromContext)
+			}
+			if warned := strings.Contains(buf.String(), "invalid request ID"); warned != test.warn {
+				t.Fatalf("mismatch in warning expected=%v actual=%v: %s", test.warn, warned, buf.String())
+			}
+		})
+	}
+}
+
+func TestRequestIDUniqueness(t *testing.T) {
+	h := RequestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	seen := make(map[string]struct{}, 10000)
+	for i := 0; i < 10000; i++ {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/query", nil))
+		id := w.Header().Get(RequestIDHeader)
+		if _, ok := seen[id]; ok {
+			t.Fatalf("duplicate request ID after %d requests: %s", i, id)
+		}
+		seen[id] = struct{}{}
+	}
+}
@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// MaxQueryLength is the max length in bytes of the URL query and of each
+// parameter of a request
+const MaxQueryLength = 10 << 10
+
+// timeParams are the parameters which are timestamps, wherever they are used
+var timeParams = []string{"time", "start", "end"}
+
+// ParameterError is a parameter of a request which isn't valid
+type ParameterError struct {
+	Param  string
+	Reason string
+}
+
+func (e *ParameterError) Error() string {
+	if e.Param == "" {
+		return fmt.Sprintf("invalid request: %s", e.Reason)
+	}
+	return fmt.Sprintf("invalid parameter %q: %s", e.Param, e.Reason)
+}
+
+// ValidateParameters checks the parameters of the request which are the same for
+// every endpoint: the length of the query and the parameters, that they are UTF-8
+// and that the timestamps can be parsed
+func ValidateParameters(r *http.Request) error {
+	if len(r.URL.RawQuery) > MaxQueryLength {
+		return &ParameterError{Reason: fmt.Sprintf("query string is longer than %d bytes", MaxQueryLength)}
+	}
+	if err := r.ParseForm(); err != nil {
+		return &ParameterError{Reason: fmt.Sprintf("error parsing form values: %v", err)}
+	}
+
+	for name, values := range r.Form {
+		if !utf8.ValidString(name) {
+			return &ParameterError{Param: name, Reason: "name is not valid UTF-8"}
+		}
+		for _, v := range values {
+			if len(v) > MaxQueryLength {
+				return &ParameterError{Param: name, Reason: fmt.Sprintf("value is longer than %d bytes", MaxQueryLength)}
+			}
+			if !utf8.ValidString(v) {
+				return &ParameterError{Param: name, Reason: "value is not valid UTF-8"}
+			}
+		}
+	}
+
+	for _, name := range timeParams {
+		for _, v := range r.Form[name] {
+			if _, err := promutil.ParseTimestamp(v); err != nil {
+				return &ParameterError{Param: name, Reason: err.Error()}
+			}
+		}
+	}
+	return nil
+}
+
+// QueryParameterValidationMiddleware rejects the requests whose parameters aren't
+// valid (see ValidateParameters) with a bad_data error, before they reach any
+// handler. The form is parsed here, so handlers read the parsed values.
+func QueryParameterValidationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := ValidateParameters(r); err != nil {
+			writeBadData(w, err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeBadData responds with the error, in the shape of the prometheus HTTP API
+func writeBadData(w http.ResponseWriter, err error) {
+	b, _ := json.Marshal(struct {
+		Status    promutil.Status    `json:"status"`
+		ErrorType promutil.ErrorType `json:"errorType"`
+		Error     string             `json:"error"`
+	}{promutil.StatusError, promutil.ErrorBadData, err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusBadRequest)
+	w.Write(b)
+}
@@ -0,0 +1,100 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+func TestQueryParameterValidationMiddleware(t *testing.T) {
+	tests := []struct {
+		name string
+		// rawQuery is the URL query of the request
+		rawQuery string
+		// body is the form of a POST request (if set)
+		body string
+		ok   bool
+	}{
+		{
+			name:     "valid",
+			rawQuery: url.Values{"query": {`sum(rate(http_requests_total{path="/"}[5m]))`}, "start": {"1500000000"}, "end": {"2017-07-14T02:40:00Z"}}.Encode(),
+			ok:       true,
+		},
+		{
+			name:     "now",
+			rawQuery: url.Values{"query": {"up"}, "time": {"now"}}.Encode(),
+			ok:       true,
+		},
+		{
+			name:     "long query string",
+			rawQuery: url.Values{"query": {strings.Repeat("a", MaxQueryLength)}}.Encode(),
+		},
+		{
+			name: "long body parameter",
+			body: url.Values{"query": {strings.Repeat("a", MaxQueryLength+1)}}.Encode(),
+		},
+		{
+			name:     "non-UTF-8 value",
+			rawQuery: "query=up%7Bjob%3D%22%ff%22%7D",
+		},
+		{
+			name:     "non-UTF-8 name",
+			rawQuery: "%ff=1",
+		},
+		{
+			name:     "invalid time",
+			rawQuery: url.Values{"query": {"up"}, "time": {"yesterday"}}.Encode(),
+		},
+		{
+			name:     "invalid start",
+			rawQuery: url.Values{"query": {"up"}, "start": {"2017-07-14 02:40"}}.Encode(),
+		},
+		{
+			name: "invalid end in the body",
+			body: url.Values{"query": {"up"}, "end": {"1e"}}.Encode(),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var reached bool
+			h := QueryParameterValidationMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				reached = true
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/query?"+test.rawQuery, nil)
+			if test.body != "" {
+				r = httptest.NewRequest(http.MethodPost, "/api/v1/query", strings.NewReader(test.body))
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if reached != test.ok {
+				t.Fatalf("mismatch in reaching the handler expected=%v actual=%v", test.ok, reached)
+			}
+			if test.ok {
+				return
+			}
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusBadRequest, w.Code)
+			}
+			var resp struct {
+				Status    promutil.Status    `json:"status"`
+				ErrorType promutil.ErrorType `json:"errorType"`
+				Error     string             `json:"error"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.Status != promutil.StatusError || resp.ErrorType != promutil.ErrorBadData || resp.Error == "" {
+				t.Fatalf("mismatch in response expected=%v actual=%+v", promutil.ErrorBadData, resp)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+// Package middleware has the building blocks of the middlewares of the server
+// which don't depend on the server itself.
+package middleware
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// DefaultTenantHeader is the header HeaderExtractor reads if not configured, as
+// used by Cortex, Loki and Mimir
+const DefaultTenantHeader = "X-Scope-OrgID"
+
+// NoTenantError is returned by a TenantExtractor for requests which don't carry a
+// tenant in the way it expects
+type NoTenantError struct {
+	Source string
+	Reason string
+}
+
+func (e *NoTenantError) Error() string {
+	return fmt.Sprintf("no tenant in %s: %s", e.Source, e.Reason)
+}
+
+// TenantExtractor returns the tenant ID of a request. Different clients send it in
+// different ways (e.g. a header, a claim of their token or the URL path).
+type TenantExtractor interface {
+	ExtractTenant(r *http.Request) (string, error)
+}
+
+// HeaderExtractor reads the tenant from a request header
+type HeaderExtractor struct {
+	// Header is the header of the tenant (DefaultTenantHeader if empty)
+	Header string
+}
+
+// ExtractTenant implements TenantExtractor
+func (h HeaderExtractor) ExtractTenant(r *http.Request) (string, error) {
+	header := h.Header
+	if header == "" {
+		header = DefaultTenantHeader
+	}
+	tenant := strings.TrimSpace(r.Header.Get(header))
+	if tenant == "" {
+		return "", &NoTenantError{Source: "header " + header, Reason: "header not set"}
+	}
+	return tenant, nil
+}
+
+// JWTExtractor reads the tenant from the "sub" claim of the bearer token of the
+// request. The token isn't verified here, so it must only be used behind the auth
+// middleware having verified it (see server.AuthConfig.JWKSURL).
+type JWTExtractor struct{}
+
+// ExtractTenant implements TenantExtractor
+func (JWTExtractor) ExtractTenant(r *http.Request) (string, error) {
+	noTenant := func(reason string) error {
+		return &NoTenantError{Source: "JWT", Reason: reason}
+	}
+
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if len(h) <= len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", noTenant("no bearer token")
+	}
+	parts := strings.Split(strings.TrimSpace(h[len(prefix):]), ".")
+	if len(parts) != 3 {
+		return "", noTenant("malformed token")
+	}
+	b, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", noTenant(fmt.Sprintf("malformed token: %v", err))
+	}
+	var claims struct {
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return "", noTenant(fmt.Sprintf("malformed token: %v", err))
+	}
+	if claims.Sub == "" {
+		return "", noTenant("token has no sub claim")
+	}
+	return claims.Sub, nil
+}
+
+// URLPrefixExtractor reads the tenant from the URL path, which has it right after
+// the API prefix: /api/v1/{tenantID}/query. Only the tenant is read, the handler
+// serving such paths has to strip it.
+type URLPrefixExtractor struct {
+	// Prefix is the path before the tenant ("/api/v1/" if empty)
+	Prefix string
+}
+
+// ExtractTenant implements TenantExtractor
+func (u URLPrefixExtractor) ExtractTenant(r *http.Request) (string, error) {
+	prefix := u.Prefix
+	if prefix == "" {
+		prefix = "/api/v1/"
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	if !strings.HasPrefix(r.URL.Path, prefix) {
+		return "", &NoTenantError{Source: "URL path", Reason: fmt.Sprintf("path doesn't start with %s", prefix)}
+	}
+	rest := r.URL.Path[len(prefix):]
+	i := strings.Index(rest, "/")
+	// The tenant must be followed by the endpoint
+	if i <= 0 {
+		return "", &NoTenantError{Source: "URL path", Reason: fmt.Sprintf("path has no tenant after %s", prefix)}
+	}
+	return rest[:i], nil
+}
+
+// StaticExtractor always returns the same tenant, e.g. as the last of a
+// ChainTenantExtractor for the requests which don't carry one
+type StaticExtractor struct {
+	Tenant string
+}
+
+// ExtractTenant implements TenantExtractor
+func (s StaticExtractor) ExtractTenant(r *http.Request) (string, error) {
+	if s.Tenant == "" {
+		return "", &NoTenantError{Source: "static config", Reason: "tenant not set"}
+	}
+	return s.Tenant, nil
+}
+
+// ChainTenantExtractor tries each of the extractors in order, returning the tenant
+// of the first which succeeds
+type ChainTenantExtractor []TenantExtractor
+
+// ExtractTenant implements TenantExtractor
+func (c ChainTenantExtractor) ExtractTenant(r *http.Request) (string, error) {
+	if len(c) == 0 {
+		return "", &NoTenantError{Source: "chain", Reason: "no extractors"}
+	}
+	reasons := make([]string, 0, len(c))
+	for _, e := range c {
+		tenant, err := e.ExtractTenant(r)
+		if err == nil {
+			return tenant, nil
+		}
+		reasons = append(reasons, err.Error())
+	}
+	return "", &NoTenantError{Source: "chain", Reason: strings.Join(reasons, "; ")}
+}
@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// jwt returns an (unsigned) token with the given claims
+func jwt(claims string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"RS256","kid":"test"}`)) + "." + enc([]byte(claims)) + ".c2ln"
+}
+
+func TestTenantExtractors(t *testing.T) {
+	tests := []struct {
+		name      string
+		extractor TenantExtractor
+		path      string
+		headers   map[string]string
+		tenant    string
+		err       bool
+	}{
+		{
+			name:      "header",
+			extractor: HeaderExtractor{},
+			headers:   map[string]string{"X-Scope-OrgID": "team-a"},
+			tenant:    "team-a",
+		},
+		{
+			name:      "custom header",
+			extractor: HeaderExtractor{Header: "X-Tenant"},
+			headers:   map[string]string{"X-Tenant": "team-a", "X-Scope-OrgID": "team-b"},
+			tenant:    "team-a",
+		},
+		{
+			name:      "missing header",
+			extractor: HeaderExtractor{},
+			err:       true,
+		},
+		{
+			name:      "jwt",
+			extractor: JWTExtractor{},
+			headers:   map[string]string{"Authorization": "Bearer " + jwt(`{"sub":"team-a","exp":1}`)},
+			tenant:    "team-a",
+		},
+		{
+			name:      "jwt without sub",
+			extractor: JWTExtractor{},
+			headers:   map[string]string{"Authorization": "Bearer " + jwt(`{"exp":1}`)},
+			err:       true,
+		},
+		{
+			name:      "malformed jwt",
+			extractor: JWTExtractor{},
+			headers:   map[string]string{"Authorization": "Bearer abc"},
+			err:       true,
+		},
+		{
+			name:      "basic auth isn't a jwt",
+			extractor: JWTExtractor{},
+			headers:   map[string]string{"Authorization": "Basic dTpw"},
+			err:       true,
+		},
+		{
+			name:      "url prefix",
+			extractor: URLPrefixExtractor{},
+			path:      "/api/v1/team-a/query",
+			tenant:    "team-a",
+		},
+		{
+			name:      "custom url prefix",
+			extractor: URLPrefixExtractor{Prefix: "/t"},
+			path:      "/t/team-a/api/v1/query",
+			tenant:    "team-a",
+		},
+		{
+			name:      "url without tenant",
+			extractor: URLPrefixExtractor{},
+			path:      "/api/v1/query",
+			err:       true,
+		},
+		{
+			name:      "url with other prefix",
+			extractor: URLPrefixExtractor{},
+			path:      "/federate",
+			err:       true,
+		},
+		{
+			name:      "static",
+			extractor: StaticExtractor{Tenant: "default"},
+			tenant:    "default",
+		},
+		{
+			name:      "empty static",
+			extractor: StaticExtractor{},
+			err:       true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := test.path
+			if path == "" {
+				path = "/api/v1/query"
+			}
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			tenant, err := test.extractor.ExtractTenant(r)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if err != nil {
+				if _, ok := err.(*NoTenantError); !ok {
+					t.Fatalf("mismatch in error type expected=%T actual=%T", &NoTenantError{}, err)
+				}
+			}
+			if tenant != test.tenant {
+				t.Fatalf("mismatch in tenant expected=%v actual=%v", test.tenant, tenant)
+			}
+		})
+	}
+}
+
+func TestChainTenantExtractor(t *testing.T) {
+	chain := ChainTenantExtractor{
+		HeaderExtractor{},
+		JWTExtractor{},
+		URLPrefixExtractor{},
+		StaticExtractor{Tenant: "default"},
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		headers map[string]string
+		tenant  string
+	}{
+		{
+			name:    "header first",
+			path:    "/api/v1/team-c/query",
+			headers: map[string]string{"X-Scope-OrgID": "team-a", "Authorization": "Bearer " + jwt(`{"sub":"team-b"}`)},
+			tenant:  "team-a",
+		},
+		{
+			name:    "jwt without header",
+			path:    "/api/v1/team-c/query",
+			headers: map[string]string{"Authorization": "Bearer " + jwt(`{"sub":"team-b"}`)},
+			tenant:  "team-b",
+		},
+		{
+			name:   "url without header or jwt",
+			path:   "/api/v1/team-c/query",
+			tenant: "team-c",
+		},
+		{
+			name:   "static fallback",
+			path:   "/federate",
+			tenant: "default",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			tenant, err := chain.ExtractTenant(r)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tenant != test.tenant {
+				t.Fatalf("mismatch in tenant expected=%v actual=%v", test.tenant, tenant)
+			}
+		})
+	}
+
+	// Without a fallback the chain fails with the reasons of each extractor
+	r := httptest.NewRequest(http.MethodGet, "/federate", nil)
+	if _, err := chain[:3].ExtractTenant(r); err == nil {
+		t.Fatalf("expected an error without a tenant")
+	}
+	if _, err := (ChainTenantExtractor{}).ExtractTenant(r); err == nil {
+		t.Fatalf("expected an error for an empty chain")
+	}
+}
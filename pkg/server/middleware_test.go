@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+type testTenantKey struct{}
+
+// recordingChain returns a chain with a middleware in every stage which records
+// the order they ran in. The stats middleware records the tenant it observed
+func recordingChain(cfg MiddlewareConfig, order *[]string, tenant *string) *Chain {
+	record := func(s Stage, fn func(r *http.Request) *http.Request) Middleware {
+		return MiddlewareFunc{S: s, F: func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				*order = append(*order, s.String())
+				if fn != nil {
+					r = fn(r)
+				}
+				next.ServeHTTP(w, r)
+			})
+		}}
+	}
+
+	c := NewChain(cfg)
+	// Added in reverse, the chain must still run them in stage order
+	c.Use(
+		record(StageCompression, nil),
+		record(StageStats, func(r *http.Request) *http.Request {
+			*tenant, _ = r.Context().Value(testTenantKey{}).(string)
+			return r
+		}),
+		record(StageLimits, nil),
+		record(StageTenancy, func(r *http.Request) *http.Request {
+			return r.WithContext(context.WithValue(r.Context(), testTenantKey{}, r.Header.Get("X-User")))
+		}),
+		record(StageAuth, nil),
+		CorrelationIDMiddleware,
+	)
+	return c
+}
+
+func TestChainOrdering(t *testing.T) {
+	var (
+		order  []string
+		tenant string
+	)
+	h := recordingChain(MiddlewareConfig{}, &order, &tenant).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+
+	r := httptest.NewRequest("GET", "/api/v1/query", nil)
+	r.Header.Set("X-User", "team-a")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	expected := []string{"auth", "tenancy", "limits", "stats", "compression", "handler"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("mismatch in order expected=%v actual=%v", expected, order)
+	}
+	if tenant != "team-a" {
+		t.Fatalf("stats didn't observe the post-auth tenant: %q", tenant)
+	}
+	if w.Header().Get(CorrelationIDHeader) == "" {
+		t.Fatalf("missing correlation ID")
+	}
+}
+
+func TestChainDisabled(t *testing.T) {
+	cfg := MiddlewareConfig{}
+	if err := yaml.Unmarshal([]byte("disabled: [limits, compression]"), &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := yaml.Unmarshal([]byte("disabled: [notastage]"), &MiddlewareConfig{}); err == nil {
+		t.Fatalf("expected error for unknown stage")
+	}
+
+	var (
+		order  []string
+		tenant string
+	)
+	h := recordingChain(cfg, &order, &tenant).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		order = append(order, "handler")
+	})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	expected := []string{"auth", "tenancy", "stats", "handler"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Fatalf("mismatch in order expected=%v actual=%v", expected, order)
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/promql"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// queryDiffData is the data section of a query_diff response
+type queryDiffData struct {
+	ResultType string            `json:"resultType"`
+	Result     []*queryDiffEntry `json:"result"`
+}
+
+// queryDiffEntry is the value of a series at both evaluation times. Series which
+// only exist in one of the evaluations have a null value (and changes) for the
+// other. Numbers are strings (as in the prometheus API) so NaN and Inf are
+// represented, and the percent change is null if the compared value is 0.
+type queryDiffEntry struct {
+	Metric         model.Metric      `json:"metric"`
+	Value          *model.SamplePair `json:"value"`
+	CompareValue   *model.SamplePair `json:"compare_value"`
+	AbsoluteChange *string           `json:"absolute_change"`
+	PercentChange  *string           `json:"percent_change"`
+}
+
+func formatDiffFloat(f float64) *string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	return &s
+}
+
+// instantSamples returns the samples of an instant query result, a scalar is a
+// single sample without labels
+func instantSamples(v model.Value) (model.Vector, error) {
+	switch typed := v.(type) {
+	case nil:
+		return nil, nil
+	case model.Vector:
+		return typed, nil
+	case *model.Scalar:
+		if typed == nil {
+			return nil, nil
+		}
+		return model.Vector{{Metric: model.Metric{}, Value: typed.Value, Timestamp: typed.Timestamp}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported %s result, query_diff requires an instant vector or scalar", v.Type())
+	}
+}
+
+// QueryDiffHandler serves /api/v1/query_diff using the given API. The instant query
+// is evaluated at `time` and `time - compare_offset`, returning the value of each
+// series at both times along with the absolute and percent change
+func QueryDiffHandler(client promclient.API) http.HandlerFunc {
+	return limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+		query, apiErr := requiredParam(r, "query")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		offsetStr, apiErr := requiredParam(r, "compare_offset")
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		offset, err := parseDuration(offsetStr)
+		if err != nil {
+			respondError(w, badData(errors.Wrap(err, "invalid parameter \"compare_offset\"")), nil)
+			return
+		}
+		if offset == 0 {
+			respondError(w, badData(fmt.Errorf("compare_offset must not be zero")), nil)
+			return
+		}
+		ts, apiErr := timeParam(r, "time", time.Now())
+		if apiErr != nil {
+			respondError(w, apiErr, nil)
+			return
+		}
+		if _, err := promql.ParseExpr(query); err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+
+		warnings := make(promutil.WarningSet)
+		eval := func(t time.Time) (model.Vector, *apiError) {
+			v, w, err := client.Query(r.Context(), query, t)
+			warnings.AddWarnings(w)
+			if err != nil {
+				return nil, upstreamError(err)
+			}
+			samples, err := instantSamples(v)
+			if err != nil {
+				return nil, badData(err)
+			}
+			return samples, nil
+		}
+
+		current, apiErr := eval(ts)
+		if apiErr != nil {
+			respondRequestError(w, r, apiErr, warnings.Warnings())
+			return
+		}
+		compare, apiErr := eval(ts.Add(-offset))
+		if apiErr != nil {
+			respondRequestError(w, r, apiErr, warnings.Warnings())
+			return
+		}
+
+		respondResult(w, r, &queryDiffData{ResultType: "diff", Result: diffVectors(current, compare)}, warnings.Warnings())
+	})
+}
+
+// diffVectors joins the samples of the vectors by their labels
+func diffVectors(current, compare model.Vector) []*queryDiffEntry {
+	entries := make(map[model.Fingerprint]*queryDiffEntry, len(current))
+	entry := func(m model.Metric) *queryDiffEntry {
+		fp := m.Fingerprint()
+		e, ok := entries[fp]
+		if !ok {
+			e = &queryDiffEntry{Metric: m}
+			entries[fp] = e
+		}
+		return e
+	}
+
+	for _, sample := range current {
+		entry(sample.Metric).Value = &model.SamplePair{Timestamp: sample.Timestamp, Value: sample.Value}
+	}
+	for _, sample := range compare {
+		entry(sample.Metric).CompareValue = &model.SamplePair{Timestamp: sample.Timestamp, Value: sample.Value}
+	}
+
+	result := make([]*queryDiffEntry, 0, len(entries))
+	for _, e := range entries {
+		if e.Value != nil && e.CompareValue != nil {
+			cur, cmp := float64(e.Value.Value), float64(e.CompareValue.Value)
+			e.AbsoluteChange = formatDiffFloat(cur - cmp)
+			if cmp != 0 {
+				e.PercentChange = formatDiffFloat((cur - cmp) / math.Abs(cmp) * 100)
+			}
+		}
+		result = append(result, e)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Metric.String() < result[j].Metric.String()
+	})
+	return result
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+)
+
+// timeQueryAPI returns the configured vector for the time of the query
+type timeQueryAPI struct {
+	stubAPI
+	values map[int64]model.Vector
+}
+
+func (s *timeQueryAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	return s.values[ts.Unix()], nil, nil
+}
+
+func TestQueryDiffHandler(t *testing.T) {
+	sample := func(instance string, v float64) *model.Sample {
+		return &model.Sample{Metric: model.Metric{"instance": model.LabelValue(instance)}, Value: model.SampleValue(v)}
+	}
+
+	week := int64(7 * 24 * 3600)
+	stub := &timeQueryAPI{values: map[int64]model.Vector{
+		week: {sample("a", 150), sample("b", 5), sample("c", 1), sample("nan", math.NaN()), sample("new", 1)},
+		0:    {sample("a", 100), sample("b", 10), sample("c", 0), sample("nan", 1), sample("gone", 1)},
+	}}
+
+	w := doRequest(QueryDiffHandler(stub), "/api/v1/query_diff", url.Values{
+		"query":          {"up"},
+		"time":           {"604800"},
+		"compare_offset": {"7d"},
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("mismatch in status code expected=%d actual=%d: %s", http.StatusOK, w.Code, w.Body.String())
+	}
+
+	var resp struct {
+		Data struct {
+			Result []struct {
+				Metric         model.Metric      `json:"metric"`
+				Value          *model.SamplePair `json:"value"`
+				CompareValue   *model.SamplePair `json:"compare_value"`
+				AbsoluteChange *string           `json:"absolute_change"`
+				PercentChange  *string           `json:"percent_change"`
+			} `json:"result"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("error unmarshaling response: %v", err)
+	}
+
+	str := func(s *string) string {
+		if s == nil {
+			return "null"
+		}
+		return *s
+	}
+
+	expected := map[string]struct {
+		hasValue, hasCompare bool
+		absolute, percent    string
+	}{
+		"a":    {true, true, "50", "50"},
+		"b":    {true, true, "-5", "-50"},
+		"c":    {true, true, "1", "null"},
+		"nan":  {true, true, "NaN", "NaN"},
+		"new":  {true, false, "null", "null"},
+		"gone": {false, true, "null", "null"},
+	}
+	if len(resp.Data.Result) != len(expected) {
+		t.Fatalf("mismatch in number of series expected=%d actual=%d", len(expected), len(resp.Data.Result))
+	}
+	for _, entry := range resp.Data.Result {
+		instance := string(entry.Metric["instance"])
+		e, ok := expected[instance]
+		if !ok {
+			t.Fatalf("unexpected series %v", entry.Metric)
+		}
+		if (entry.Value != nil) != e.hasValue || (entry.CompareValue != nil) != e.hasCompare {
+			t.Fatalf("mismatch in values for %s: value=%v compare_value=%v", instance, entry.Value, entry.CompareValue)
+		}
+		if str(entry.AbsoluteChange) != e.absolute || str(entry.PercentChange) != e.percent {
+			t.Fatalf("mismatch in changes for %s expected=%s/%s actual=%s/%s", instance, e.absolute, e.percent, str(entry.AbsoluteChange), str(entry.PercentChange))
+		}
+	}
+}
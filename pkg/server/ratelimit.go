@@ -0,0 +1,96 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// RateLimitConfig configures the rate limit of the requests to the proxy
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate at which requests are admitted
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// Burst is the number of requests which may be admitted at once (1 if 0)
+	Burst int `yaml:"burst"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *RateLimitConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = RateLimitConfig{}
+	type plain RateLimitConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c RateLimitConfig) Validate() error {
+	if c.RequestsPerSecond <= 0 {
+		return fmt.Errorf("rate limit requests_per_second must be positive")
+	}
+	if c.Burst < 0 {
+		return fmt.Errorf("rate limit burst must not be negative")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) burst() float64 {
+	if c.Burst > 0 {
+		return float64(c.Burst)
+	}
+	return 1
+}
+
+// NewRateLimiter returns a RateLimiter with a full bucket
+func NewRateLimiter(cfg RateLimitConfig) *RateLimiter {
+	return &RateLimiter{cfg: cfg, tokens: cfg.burst(), last: time.Now(), now: time.Now}
+}
+
+// RateLimiter is a token bucket shared by all the requests it admits
+type RateLimiter struct {
+	mu     sync.Mutex
+	cfg    RateLimitConfig
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+// Allow withdraws a token from the bucket, if it is empty the time until the next
+// token is added is returned instead
+func (l *RateLimiter) Allow() (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if elapsed := now.Sub(l.last).Seconds(); elapsed > 0 {
+		l.tokens = math.Min(l.cfg.burst(), l.tokens+elapsed*l.cfg.RequestsPerSecond)
+	}
+	l.last = now
+
+	if l.tokens < 1 {
+		missing := 1 - l.tokens
+		return time.Duration(missing / l.cfg.RequestsPerSecond * float64(time.Second)), false
+	}
+	l.tokens--
+	return 0, true
+}
+
+// RateLimitMiddleware returns the Middleware which rejects the requests over the
+// limit of the RateLimiter with a 429, whose Retry-After is when the limiter
+// admits a request again
+func RateLimitMiddleware(limiter *RateLimiter) Middleware {
+	return MiddlewareFunc{S: StageLimits, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if after, ok := limiter.Allow(); !ok {
+				respondError(w, &apiError{promutil.ErrorThrottled, &promclient.ErrRateLimited{After: after}}, nil)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}}
+}
@@ -0,0 +1,144 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock which only moves when advanced
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func newTestRateLimiter(cfg RateLimitConfig) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := NewRateLimiter(cfg)
+	limiter.now = clock.Now
+	limiter.last = clock.Now()
+	return limiter, clock
+}
+
+func TestRateLimiterConcurrent(t *testing.T) {
+	const (
+		goroutines = 10
+		perSecond  = 100 // requests per second of each goroutine
+		limit      = 500
+		seconds    = 5
+	)
+	limiter, clock := newTestRateLimiter(RateLimitConfig{RequestsPerSecond: limit, Burst: 50})
+
+	// Every step each goroutine sends a request, so the goroutines together send
+	// goroutines*perSecond requests per second
+	step := time.Second / perSecond
+	allowed := make([]int64, seconds)
+	for s := 0; s < seconds; s++ {
+		for i := 0; i < perSecond; i++ {
+			clock.Advance(step)
+			var wg sync.WaitGroup
+			for g := 0; g < goroutines; g++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if _, ok := limiter.Allow(); ok {
+						atomic.AddInt64(&allowed[s], 1)
+					}
+				}()
+			}
+			wg.Wait()
+		}
+	}
+
+	for s, count := range allowed {
+		// The first second also spends the burst
+		expected := float64(limit)
+		if s == 0 {
+			expected += 50
+		}
+		if float64(count) < expected*0.9 || float64(count) > expected*1.1 {
+			t.Fatalf("mismatch in allowed requests in second %d expected=%v±10%% actual=%d", s, expected, count)
+		}
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limiter, clock := newTestRateLimiter(RateLimitConfig{RequestsPerSecond: 50, Burst: 50})
+	h := RateLimitMiddleware(limiter).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	// send sends n requests, returning how many were allowed. The first rejected
+	// request is returned as well
+	send := func(n int) (int, *httptest.ResponseRecorder) {
+		count := 0
+		var rejected *httptest.ResponseRecorder
+		for i := 0; i < n; i++ {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", "/api/v1/query", nil))
+			if w.Code == http.StatusOK {
+				count++
+			} else if rejected == nil {
+				rejected = w
+			}
+		}
+		return count, rejected
+	}
+
+	// The burst is allowed at once, after that requests are rejected
+	count, rejected := send(60)
+	if count != 50 {
+		t.Fatalf("mismatch in allowed burst expected=%d actual=%d", 50, count)
+	}
+	if rejected == nil || rejected.Code != http.StatusTooManyRequests {
+		t.Fatalf("mismatch in status code of rejected request expected=%d actual=%v", http.StatusTooManyRequests, rejected)
+	}
+	if after := rejected.Header().Get("Retry-After"); after != "1" {
+		t.Fatalf("mismatch in Retry-After expected=%q actual=%q", "1", after)
+	}
+
+	// A second later the bucket is refilled
+	clock.Advance(time.Second)
+	if count, _ := send(60); count != 50 {
+		t.Fatalf("mismatch in allowed requests after refill expected=%d actual=%d", 50, count)
+	}
+}
+
+// discardWriter is a ResponseWriter which discards the response
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header         { return d.header }
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (d *discardWriter) WriteHeader(int)             {}
+
+func BenchmarkRateLimitMiddleware(b *testing.B) {
+	limiter := NewRateLimiter(RateLimitConfig{RequestsPerSecond: 100000, Burst: 1000})
+	h := RateLimitMiddleware(limiter).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	r := httptest.NewRequest("GET", "/api/v1/query", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		w := &discardWriter{header: make(http.Header)}
+		for pb.Next() {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
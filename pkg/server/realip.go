@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type clientIPKey struct{}
+
+// ClientIPFromContext returns the client IP that RealIPMiddleware stored in the
+// context (nil if there is none)
+func ClientIPFromContext(ctx context.Context) net.IP {
+	ip, _ := ctx.Value(clientIPKey{}).(net.IP)
+	return ip
+}
+
+// RealIPMiddleware stores the IP of the client in the request context. For requests
+// from one of the trustedProxies the client IP is taken from the X-Forwarded-For
+// (or X-Real-IP) header, otherwise the headers are ignored and the RemoteAddr is used.
+func RealIPMiddleware(trustedProxies []net.IPNet) func(http.Handler) http.Handler {
+	trusted := func(ip net.IP) bool {
+		for _, n := range trustedProxies {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			ip := net.ParseIP(host)
+
+			if ip != nil && trusted(ip) {
+				if forwardedIP := forwardedClientIP(r.Header, trusted); forwardedIP != nil {
+					ip = forwardedIP
+				}
+			}
+
+			if ip != nil {
+				r = r.WithContext(context.WithValue(r.Context(), clientIPKey{}, ip))
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClientIP returns the client IP from the forwarding headers of a request
+// sent by a trusted proxy. Each proxy appends the address it received the request
+// from to X-Forwarded-For, so the client is the right-most address which isn't one
+// of our trusted proxies -- anything left of that may have been set by the client.
+func forwardedClientIP(h http.Header, trusted func(net.IP) bool) net.IP {
+	var hops []string
+	for _, v := range h["X-Forwarded-For"] {
+		hops = append(hops, strings.Split(v, ",")...)
+	}
+
+	var ip net.IP
+	for i := len(hops) - 1; i >= 0; i-- {
+		hopIP := net.ParseIP(strings.TrimSpace(hops[i]))
+		if hopIP == nil {
+			break
+		}
+		ip = hopIP
+		if !trusted(hopIP) {
+			break
+		}
+	}
+	if ip != nil {
+		return ip
+	}
+
+	return net.ParseIP(strings.TrimSpace(h.Get("X-Real-IP")))
+}
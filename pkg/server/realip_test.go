@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestRealIPMiddleware(t *testing.T) {
+	_, lb, _ := net.ParseCIDR("10.0.0.0/8")
+	mw := RealIPMiddleware([]net.IPNet{*lb})
+
+	tests := []struct {
+		remoteAddr string
+		headers    map[string]string
+		ip         string
+	}{
+		// No headers
+		{
+			remoteAddr: "10.1.1.1:1234",
+			ip:         "10.1.1.1",
+		},
+		{
+			remoteAddr: "10.1.1.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			ip:         "1.2.3.4",
+		},
+		// The right-most untrusted address is the client, as the client may
+		// have set the rest
+		{
+			remoteAddr: "10.1.1.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.2.2.2"},
+			ip:         "1.2.3.4",
+		},
+		{
+			remoteAddr: "10.1.1.1:1234",
+			headers:    map[string]string{"X-Real-IP": "1.2.3.4"},
+			ip:         "1.2.3.4",
+		},
+		{
+			remoteAddr: "10.1.1.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "5.6.7.8"},
+			ip:         "1.2.3.4",
+		},
+		{
+			remoteAddr: "[::1]:1234",
+			ip:         "::1",
+		},
+		// Headers from untrusted addresses are ignored
+		{
+			remoteAddr: "192.168.1.1:1234",
+			headers:    map[string]string{"X-Forwarded-For": "1.2.3.4"},
+			ip:         "192.168.1.1",
+		},
+		{
+			remoteAddr: "192.168.1.1:1234",
+			headers:    map[string]string{"X-Real-IP": "1.2.3.4"},
+			ip:         "192.168.1.1",
+		},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			var ip net.IP
+			h := mw(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ip = ClientIPFromContext(r.Context())
+			}))
+
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), r)
+
+			if ip.String() != test.ip {
+				t.Fatalf("mismatch in ip expected=%s actual=%s", test.ip, ip)
+			}
+		})
+	}
+}
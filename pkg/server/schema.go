@@ -0,0 +1,71 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// SchemaVersionHeader is the request header a client opts in to the extended
+// response schema with, the negotiated version is returned in the same response
+// header
+const SchemaVersionHeader = "X-Promproxy-Schema-Version"
+
+// The versions of the response schema
+const (
+	// SchemaVersionClassic is the shape of the prometheus HTTP API, as served to
+	// clients which don't ask for a version (e.g. Grafana)
+	SchemaVersionClassic = 1
+	// SchemaVersionExtended adds the metadata section to the responses of the
+	// query endpoints
+	SchemaVersionExtended = 2
+
+	// LatestSchemaVersion is the latest version, clients asking for a later one
+	// get this one
+	LatestSchemaVersion = SchemaVersionExtended
+)
+
+// responseMetadata is the metadata section of a response, which promproxy adds
+// to the prometheus API
+type responseMetadata struct {
+	SchemaVersion int `json:"schemaVersion"`
+	// Completeness is the fraction of the backends expected to serve the request
+	// which responded (see promclient.Completeness)
+	Completeness *float64 `json:"completeness,omitempty"`
+}
+
+// negotiateSchemaVersion returns the schema version of the response to the
+// request: the version the client asked for, capped at the LatestSchemaVersion.
+// Clients which don't ask for one (or ask for an invalid one) get the classic shape.
+func negotiateSchemaVersion(r *http.Request) int {
+	version, err := strconv.Atoi(r.Header.Get(SchemaVersionHeader))
+	if err != nil || version < SchemaVersionClassic {
+		return SchemaVersionClassic
+	}
+	if version > LatestSchemaVersion {
+		return LatestSchemaVersion
+	}
+	return version
+}
+
+// shapeResponse shapes the response to the request for the negotiated schema
+// version, only adding the fields which aren't part of the prometheus API for the
+// clients which asked for them
+func shapeResponse(w http.ResponseWriter, r *http.Request, resp *response) {
+	if r.Header.Get(SchemaVersionHeader) == "" {
+		return
+	}
+	version := negotiateSchemaVersion(r)
+	w.Header().Set(SchemaVersionHeader, strconv.Itoa(version))
+	if version < SchemaVersionExtended {
+		return
+	}
+
+	resp.Metadata = &responseMetadata{SchemaVersion: version}
+	if completeness := promclient.CompletenessFromContext(r.Context()); completeness != nil {
+		if score, ok := completeness.Score(); ok {
+			resp.Metadata.Completeness = &score
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+func TestSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		// header is the SchemaVersionHeader of the request (if any)
+		header string
+		// keys are the expected keys of the response
+		keys string
+		// version is the expected SchemaVersionHeader of the response
+		version string
+	}{
+		{
+			name: "classic by default",
+			keys: "data,status,warnings",
+		},
+		{
+			name:    "classic",
+			header:  "1",
+			keys:    "data,status,warnings",
+			version: "1",
+		},
+		{
+			name:    "invalid",
+			header:  "latest",
+			keys:    "data,status,warnings",
+			version: "1",
+		},
+		{
+			name:    "extended",
+			header:  "2",
+			keys:    "data,metadata,status,warnings",
+			version: "2",
+		},
+		{
+			name:    "later than the latest",
+			header:  "99",
+			keys:    "data,metadata,status,warnings",
+			version: "2",
+		},
+	}
+
+	apis := []promclient.API{&stubAPI{v: model.Vector{}, warnings: api.Warnings{"deprecated metric"}}, &stubAPI{err: fmt.Errorf("connection refused")}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			multi := promclient.NewMultiAPI(apis, 0, nil, 1)
+			req := httptest.NewRequest("GET", "/api/v1/query?"+url.Values{"query": {"up"}}.Encode(), nil)
+			if test.header != "" {
+				req.Header.Set(SchemaVersionHeader, test.header)
+			}
+			w := httptest.NewRecorder()
+			InstantQueryHandler(multi).ServeHTTP(w, req)
+			if w.Code != http.StatusOK {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", http.StatusOK, w.Code)
+			}
+			if version := w.Header().Get(SchemaVersionHeader); version != test.version {
+				t.Fatalf("mismatch in schema version expected=%q actual=%q", test.version, version)
+			}
+
+			var resp map[string]json.RawMessage
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			keys := make([]string, 0, len(resp))
+			for k := range resp {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			if actual := strings.Join(keys, ","); actual != test.keys {
+				t.Fatalf("mismatch in response keys expected=%v actual=%v", test.keys, actual)
+			}
+
+			if _, ok := resp["metadata"]; !ok {
+				return
+			}
+			var metadata responseMetadata
+			if err := json.Unmarshal(resp["metadata"], &metadata); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if metadata.SchemaVersion != LatestSchemaVersion {
+				t.Fatalf("mismatch in metadata schema version expected=%d actual=%d", LatestSchemaVersion, metadata.SchemaVersion)
+			}
+			if metadata.Completeness == nil || *metadata.Completeness != 0.5 {
+				t.Fatalf("mismatch in metadata completeness expected=%v actual=%v", 0.5, metadata.Completeness)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+// ErrResponseTooLarge is returned by a SizeLimitedResponseWriter once the response
+// exceeded its limit
+type ErrResponseTooLarge struct {
+	Limit int64
+}
+
+func (e *ErrResponseTooLarge) Error() string {
+	return fmt.Sprintf("response exceeds the limit of %d bytes, narrow the query (e.g. its matchers or time range)", e.Limit)
+}
+
+// SizeLimitedResponseWriter tracks the bytes written to the response, failing it
+// once they exceed the Limit. The status code is held back until the first write,
+// so if none of the response was sent yet it is replaced by a 413. Otherwise the
+// connection is aborted (see http.ErrAbortHandler), as the client must not take
+// the truncated response as complete.
+type SizeLimitedResponseWriter struct {
+	http.ResponseWriter
+	Limit int64
+
+	code     int
+	written  int64
+	started  bool
+	exceeded bool
+}
+
+// WriteHeader holds back the status code until the first write
+func (s *SizeLimitedResponseWriter) WriteHeader(code int) {
+	if s.code == 0 {
+		s.code = code
+	}
+}
+
+// Write writes the bytes to the response if it stays within the limit
+func (s *SizeLimitedResponseWriter) Write(b []byte) (int, error) {
+	err := &ErrResponseTooLarge{Limit: s.Limit}
+	if s.exceeded {
+		return 0, err
+	}
+	if s.code == 0 {
+		s.code = http.StatusOK
+	}
+	if s.written+int64(len(b)) > s.Limit {
+		s.exceeded = true
+		if s.started {
+			logger.Warnf("Aborting response: %v", err)
+			panic(http.ErrAbortHandler)
+		}
+		// The warnings were for the response which is dropped
+		s.Header().Del(WarningsHeader)
+		respondError(s.ResponseWriter, &apiError{promutil.ErrorTooLarge, err}, nil)
+		return 0, err
+	}
+	s.start()
+	n, werr := s.ResponseWriter.Write(b)
+	s.written += int64(n)
+	return n, werr
+}
+
+// start writes the held back status code
+func (s *SizeLimitedResponseWriter) start() {
+	if !s.started {
+		s.started = true
+		s.ResponseWriter.WriteHeader(s.code)
+	}
+}
+
+// close writes the status code of responses without a body
+func (s *SizeLimitedResponseWriter) close() {
+	if s.code != 0 && !s.exceeded {
+		s.start()
+	}
+}
+
+type responseSizeLimitKey struct{}
+
+// WithResponseSizeLimit returns a context whose responses of the query handlers are
+// limited to the given size in bytes (see SizeLimitedResponseWriter)
+func WithResponseSizeLimit(ctx context.Context, limit int64) context.Context {
+	return context.WithValue(ctx, responseSizeLimitKey{}, limit)
+}
+
+// ResponseSizeLimitFromContext returns the response size limit of the context, 0
+// if there is none
+func ResponseSizeLimitFromContext(ctx context.Context) int64 {
+	limit, _ := ctx.Value(responseSizeLimitKey{}).(int64)
+	return limit
+}
+
+// ResponseSizeLimitMiddleware returns the Middleware which limits the responses of
+// the query handlers to limit bytes (no limit if 0). The limit applies to the
+// uncompressed response, as that is what the proxy holds in memory.
+func ResponseSizeLimitMiddleware(limit int64) Middleware {
+	return MiddlewareFunc{S: StageLimits, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if limit <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithResponseSizeLimit(r.Context(), limit)))
+		})
+	}}
+}
+
+// limitResponseSize wraps the response of the handler in a SizeLimitedResponseWriter
+// if the request has a response size limit. This is done in the handler rather
+// than the middleware, so the limit is checked before the response is compressed.
+func limitResponseSize(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := ResponseSizeLimitFromContext(r.Context())
+		if limit <= 0 {
+			h(w, r)
+			return
+		}
+		sw := &SizeLimitedResponseWriter{ResponseWriter: w, Limit: limit}
+		h(sw, r)
+		sw.close()
+	}
+}
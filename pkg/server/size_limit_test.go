@@ -0,0 +1,73 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promutil"
+)
+
+func TestSizeLimitedResponseWriter(t *testing.T) {
+	// 1 MB of JSON
+	payload := `{"data":"` + strings.Repeat("x", 1<<20-11) + `"}`
+
+	tests := []struct {
+		limit int64
+		code  int
+	}{
+		{limit: 0, code: http.StatusOK},
+		{limit: 512 << 10, code: http.StatusRequestEntityTooLarge},
+		{limit: 2 << 20, code: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(strconv.FormatInt(test.limit, 10), func(t *testing.T) {
+			h := NewChain(MiddlewareConfig{}).Use(ResponseSizeLimitMiddleware(test.limit)).ThenFunc(limitResponseSize(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte(payload))
+			}))
+			w := doRequest(h, "/api/v1/query", nil)
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d", test.code, w.Code)
+			}
+			if test.code == http.StatusOK {
+				if w.Body.Len() != len(payload) {
+					t.Fatalf("mismatch in body size expected=%d actual=%d", len(payload), w.Body.Len())
+				}
+				return
+			}
+			var resp response
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.ErrorType != promutil.ErrorTooLarge {
+				t.Fatalf("mismatch in error type expected=%v actual=%v", promutil.ErrorTooLarge, resp.ErrorType)
+			}
+		})
+	}
+}
+
+func TestSizeLimitedQueryHandler(t *testing.T) {
+	vector := make(model.Vector, 0, 1000)
+	for i := 0; i < 1000; i++ {
+		vector = append(vector, &model.Sample{Metric: model.Metric{"instance": model.LabelValue(strconv.Itoa(i))}, Value: 1})
+	}
+	stub := &stubAPI{v: vector, warnings: api.Warnings{"dropped"}}
+
+	h := NewChain(MiddlewareConfig{}).Use(ResponseSizeLimitMiddleware(1024)).Then(InstantQueryHandler(stub))
+	w := doRequest(h, "/api/v1/query", url.Values{"query": {"up"}})
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("mismatch in code expected=%d actual=%d", http.StatusRequestEntityTooLarge, w.Code)
+	}
+	if warnings := w.Header()[WarningsHeader]; len(warnings) != 0 {
+		t.Fatalf("mismatch in warnings expected=%v actual=%v", nil, warnings)
+	}
+}
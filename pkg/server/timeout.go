@@ -0,0 +1,91 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+type maxQueryTimeoutKey struct{}
+
+// WithMaxQueryTimeout returns a context whose requests to the query handlers time
+// out after the given duration at most, whatever their timeout param
+func WithMaxQueryTimeout(ctx context.Context, max time.Duration) context.Context {
+	return context.WithValue(ctx, maxQueryTimeoutKey{}, max)
+}
+
+// MaxQueryTimeoutFromContext returns the max query timeout of the context, 0 if
+// there is none
+func MaxQueryTimeoutFromContext(ctx context.Context) time.Duration {
+	max, _ := ctx.Value(maxQueryTimeoutKey{}).(time.Duration)
+	return max
+}
+
+// QueryTimeoutLimitMiddleware returns the Middleware which bounds the timeout of
+// the requests to the query handlers to max (no bound if 0). Requests without a
+// timeout param time out after max.
+func QueryTimeoutLimitMiddleware(max time.Duration) Middleware {
+	return MiddlewareFunc{S: StageLimits, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if max <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(WithMaxQueryTimeout(r.Context(), max)))
+		})
+	}}
+}
+
+// withQueryTimeout returns the context of the request with the deadline of its
+// timeout param, as prometheus does, bounded by the max query timeout of the
+// context. The deadline is passed on to the downstreams with the context (see
+// promclient.QueryTimeoutRoundTripper).
+func withQueryTimeout(r *http.Request) (context.Context, context.CancelFunc, *apiError) {
+	timeout := MaxQueryTimeoutFromContext(r.Context())
+	if v := r.FormValue("timeout"); v != "" {
+		d, err := parseDuration(v)
+		if err != nil {
+			return nil, nil, badData(errors.Wrap(err, "invalid parameter \"timeout\""))
+		}
+		if d <= 0 {
+			return nil, nil, badData(fmt.Errorf("invalid parameter \"timeout\": must be positive"))
+		}
+		if timeout <= 0 || d < timeout {
+			timeout = d
+		}
+	}
+	if timeout <= 0 {
+		ctx, cancel := context.WithCancel(r.Context())
+		return ctx, cancel, nil
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	return ctx, cancel, nil
+}
+
+// limitValue truncates the vector or matrix to the first limit series (none if
+// 0), adding a promclient.SeriesLimitWarning if it did. This is the backstop for
+// the downstreams which don't support the limit param, and for the merge of
+// several downstreams, which may have up to limit series each.
+func limitValue(v model.Value, limit int, w api.Warnings) (model.Value, api.Warnings) {
+	if limit <= 0 {
+		return v, w
+	}
+	switch typed := v.(type) {
+	case model.Vector:
+		if len(typed) > limit {
+			return typed[:limit], append(w, promclient.SeriesLimitWarning)
+		}
+	case model.Matrix:
+		if len(typed) > limit {
+			return typed[:limit], append(w, promclient.SeriesLimitWarning)
+		}
+	}
+	return v, w
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/api"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// deadlineAPI records the time left until the deadline of its calls
+type deadlineAPI struct {
+	stubAPI
+	timeout  time.Duration
+	deadline bool
+}
+
+func (d *deadlineAPI) record(ctx context.Context) {
+	var deadline time.Time
+	deadline, d.deadline = ctx.Deadline()
+	d.timeout = time.Until(deadline)
+}
+
+func (d *deadlineAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
+	d.record(ctx)
+	return d.stubAPI.Query(ctx, query, ts)
+}
+
+func (d *deadlineAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	d.record(ctx)
+	return d.stubAPI.QueryRange(ctx, query, r)
+}
+
+func TestQueryTimeout(t *testing.T) {
+	tests := []struct {
+		name    string
+		timeout string
+		max     time.Duration
+		// expected is the expected timeout of the call (none if 0)
+		expected time.Duration
+		code     int
+	}{
+		{name: "none", code: http.StatusOK},
+		{name: "param", timeout: "30s", expected: 30 * time.Second, code: http.StatusOK},
+		{name: "float param", timeout: "1.5", expected: 1500 * time.Millisecond, code: http.StatusOK},
+		{name: "param within max", timeout: "30s", max: time.Minute, expected: 30 * time.Second, code: http.StatusOK},
+		{name: "param over max", timeout: "2m", max: time.Minute, expected: time.Minute, code: http.StatusOK},
+		{name: "max", max: time.Minute, expected: time.Minute, code: http.StatusOK},
+		{name: "invalid", timeout: "soon", code: http.StatusBadRequest},
+		{name: "zero", timeout: "0s", code: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		for _, endpoint := range []string{"query", "query_range"} {
+			t.Run(test.name+"/"+endpoint, func(t *testing.T) {
+				stub := &deadlineAPI{stubAPI: stubAPI{v: model.Matrix{}}}
+				params := url.Values{"query": {"up"}}
+				handler := InstantQueryHandler(stub)
+				if endpoint == "query_range" {
+					params.Set("start", "0")
+					params.Set("end", "60")
+					params.Set("step", "15")
+					handler = RangeQueryHandler(stub)
+				}
+				if test.timeout != "" {
+					params.Set("timeout", test.timeout)
+				}
+
+				h := NewChain(MiddlewareConfig{}).Use(QueryTimeoutLimitMiddleware(test.max)).Then(handler)
+				w := doRequest(h, "/api/v1/"+endpoint, params)
+				if w.Code != test.code {
+					t.Fatalf("mismatch in code expected=%d actual=%d", test.code, w.Code)
+				}
+				if test.code != http.StatusOK {
+					return
+				}
+
+				if stub.deadline != (test.expected > 0) {
+					t.Fatalf("mismatch in deadline expected=%v actual=%v", test.expected > 0, stub.deadline)
+				}
+				if test.expected > 0 && (stub.timeout > test.expected || stub.timeout < test.expected-time.Second) {
+					t.Fatalf("mismatch in timeout expected=%v actual=%v", test.expected, stub.timeout)
+				}
+			})
+		}
+	}
+}
+
+// limitAPI records the query limit of its QueryRange calls
+type limitAPI struct {
+	stubAPI
+	limit int
+}
+
+func (l *limitAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, api.Warnings, error) {
+	l.limit = promclient.QueryLimitFromContext(ctx)
+	return l.stubAPI.QueryRange(ctx, query, r)
+}
+
+func TestQueryLimit(t *testing.T) {
+	matrix := make(model.Matrix, 0, 5)
+	for i := 0; i < 5; i++ {
+		matrix = append(matrix, &model.SampleStream{
+			Metric: model.Metric{"instance": model.LabelValue(strconv.Itoa(i))},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 1}},
+		})
+	}
+
+	tests := []struct {
+		limit    string
+		series   int
+		warnings []string
+		code     int
+		// forwarded is the limit the API is expected to be called with
+		forwarded int
+	}{
+		{series: 5, code: http.StatusOK},
+		{limit: "0", series: 5, code: http.StatusOK},
+		{limit: "5", series: 5, code: http.StatusOK, forwarded: 5},
+		// The downstreams may not support the limit, so the result is truncated too
+		{limit: "2", series: 2, warnings: []string{promclient.SeriesLimitWarning}, code: http.StatusOK, forwarded: 2},
+		{limit: "-1", code: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.limit, func(t *testing.T) {
+			stub := &limitAPI{stubAPI: stubAPI{v: append(model.Matrix(nil), matrix...)}}
+			params := url.Values{"query": {"up"}, "start": {"0"}, "end": {"60"}, "step": {"15"}}
+			if test.limit != "" {
+				params.Set("limit", test.limit)
+			}
+			w := doRequest(RangeQueryHandler(stub), "/api/v1/query_range", params)
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d", test.code, w.Code)
+			}
+			if test.code != http.StatusOK {
+				return
+			}
+			if stub.limit != test.forwarded {
+				t.Fatalf("mismatch in forwarded limit expected=%d actual=%d", test.forwarded, stub.limit)
+			}
+
+			var resp struct {
+				Data struct {
+					Result []json.RawMessage `json:"result"`
+				} `json:"data"`
+				Warnings []string `json:"warnings"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(resp.Data.Result) != test.series {
+				t.Fatalf("mismatch in series expected=%d actual=%d", test.series, len(resp.Data.Result))
+			}
+			if len(resp.Warnings) != len(test.warnings) || (len(test.warnings) > 0 && resp.Warnings[0] != test.warnings[0]) {
+				t.Fatalf("mismatch in warnings expected=%v actual=%v", test.warnings, resp.Warnings)
+			}
+		})
+	}
+}
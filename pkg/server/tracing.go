@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/tracing"
+)
+
+// TracingMiddleware traces the requests with the Tracer (see tracing.Tracer). The
+// spans of every request are recorded, but the trace is only exported if the
+// request took at least the latency threshold, failed or carried the force header.
+// The decision is made once the response is about to be written (by which time the
+// queries are done), so the ID of an exported trace can be returned in the
+// response header of the config. Exported traces are logged in the query log with
+// their ID.
+func TracingMiddleware(t *tracing.Tracer) Middleware {
+	return MiddlewareFunc{S: StageCorrelation, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cfg := t.Config()
+			if cfg == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, trace, span := tracing.Start(r.Context(), r.URL.Path)
+			span.SetAttribute("method", r.Method)
+			forced := cfg.ForceHeader != "" && r.Header.Get(cfg.ForceHeader) != ""
+			tw := &tracingResponseWriter{
+				ResponseWriter: w,
+				decide: func(code int) bool {
+					span.SetAttribute("status", strconv.Itoa(code))
+					_, record := t.Decide(cfg, time.Since(span.Start), code >= http.StatusInternalServerError, forced)
+					if record && cfg.ResponseHeader != "" {
+						w.Header().Set(cfg.ResponseHeader, trace.ID)
+					}
+					return record
+				},
+			}
+			r = r.WithContext(ctx)
+			next.ServeHTTP(tw, r)
+			if !tw.decided {
+				tw.WriteHeader(http.StatusOK)
+			}
+			span.Finish(nil)
+			if !tw.record {
+				return
+			}
+
+			t.Export(trace)
+			logger.WithFields(logrus.Fields{
+				"query_log":  true,
+				"trace_id":   trace.ID,
+				"path":       r.URL.Path,
+				"query":      r.FormValue("query"),
+				"status":     tw.code,
+				"took":       span.End.Sub(span.Start),
+				"request_id": promclient.CorrelationIDFromContext(ctx),
+			}).Info("Traced request")
+		})
+	}}
+}
+
+// tracingResponseWriter decides whether the trace of the request is exported right
+// before the status code is written
+type tracingResponseWriter struct {
+	http.ResponseWriter
+	decide func(code int) bool
+
+	code    int
+	decided bool
+	record  bool
+}
+
+// WriteHeader decides whether the trace is exported before writing the status code
+func (t *tracingResponseWriter) WriteHeader(code int) {
+	if !t.decided {
+		t.decided = true
+		t.code = code
+		t.record = t.decide(code)
+	}
+	t.ResponseWriter.WriteHeader(code)
+}
+
+// Write writes the data, deciding with a 200 if no status code was written
+func (t *tracingResponseWriter) Write(b []byte) (int, error) {
+	if !t.decided {
+		t.WriteHeader(http.StatusOK)
+	}
+	return t.ResponseWriter.Write(b)
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/promproxy/pkg/tracing"
+)
+
+// recordingExporter records the exported traces
+type recordingExporter struct {
+	mu     sync.Mutex
+	traces []*tracing.Trace
+}
+
+func (r *recordingExporter) Export(t *tracing.Trace) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.traces = append(r.traces, t)
+	return nil
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold time.Duration
+		code      int
+		force     bool
+		exported  bool
+	}{
+		{name: "fast", threshold: time.Hour, code: http.StatusOK},
+		{name: "slow", threshold: 0, code: http.StatusOK, exported: true},
+		{name: "error", threshold: time.Hour, code: http.StatusServiceUnavailable, exported: true},
+		{name: "bad request", threshold: time.Hour, code: http.StatusBadRequest},
+		{name: "forced", threshold: time.Hour, code: http.StatusOK, force: true, exported: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			exporter := &recordingExporter{}
+			tracer := tracing.NewTracer(exporter)
+			cfg := tracing.DefaultConfig
+			cfg.LatencyThreshold = test.threshold
+			cfg.ResponseHeader = "X-Trace-ID"
+			tracer.ApplyConfig(&cfg)
+
+			var traceID string
+			h := NewChain(MiddlewareConfig{}).Use(TracingMiddleware(tracer)).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctx, span := tracing.StartSpan(r.Context(), "downstream")
+				traceID = tracing.TraceFromContext(ctx).ID
+				span.Finish(nil)
+				w.WriteHeader(test.code)
+			})
+			req := httptest.NewRequest("GET", "/api/v1/query?query=up", nil)
+			if test.force {
+				req.Header.Set(cfg.ForceHeader, "1")
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%d actual=%d", test.code, w.Code)
+			}
+			if exported := len(exporter.traces) == 1; exported != test.exported {
+				t.Fatalf("mismatch in exported expected=%v actual=%v", test.exported, exported)
+			}
+			expectedHeader := ""
+			if test.exported {
+				expectedHeader = traceID
+				spans, _ := exporter.traces[0].Spans()
+				if len(spans) != 2 || spans[1].ParentID != spans[0].SpanID {
+					t.Fatalf("mismatch in spans expected=2 (root and child) actual=%v", spans)
+				}
+			}
+			if header := w.Header().Get("X-Trace-ID"); header != expectedHeader {
+				t.Fatalf("mismatch in trace header expected=%q actual=%q", expectedHeader, header)
+			}
+		})
+	}
+}
+
+// TestTracingMiddlewareReload changes the config of the Tracer between requests
+func TestTracingMiddlewareReload(t *testing.T) {
+	exporter := &recordingExporter{}
+	tracer := tracing.NewTracer(exporter)
+	h := NewChain(MiddlewareConfig{}).Use(TracingMiddleware(tracer)).ThenFunc(func(w http.ResponseWriter, r *http.Request) {
+		if tracing.TraceFromContext(r.Context()) == nil && tracer.Config() != nil {
+			t.Fatalf("missing trace")
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	cfgs := []*tracing.Config{
+		nil,
+		{LatencyThreshold: time.Hour},
+		{LatencyThreshold: 0},
+		nil,
+	}
+	expected := []int{0, 0, 1, 1}
+	for i, cfg := range cfgs {
+		tracer.ApplyConfig(cfg)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/v1/query", nil).WithContext(context.TODO()))
+		if len(exporter.traces) != expected[i] {
+			t.Fatalf("%d: mismatch in exported traces expected=%d actual=%d", i, expected[i], len(exporter.traces))
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// UpstreamHintHeader is the header a request's promclient.UpstreamHint is read
+// from (e.g. "consistent" for recording rules, "fast" for dashboards)
+const UpstreamHintHeader = "X-Promproxy-Upstream-Hint"
+
+// UpstreamHintMiddleware attaches the UpstreamHint of the UpstreamHintHeader (if
+// set) to the context of the request, so the load balanced servergroups route the
+// request as it prefers. Requests with an unknown hint are rejected.
+var UpstreamHintMiddleware Middleware = MiddlewareFunc{S: StageCorrelation, F: func(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.Header.Get(UpstreamHintHeader)
+		if name == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		hint, err := promclient.ParseUpstreamHint(name)
+		if err != nil {
+			respondError(w, badData(err), nil)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(promclient.WithUpstreamHint(r.Context(), hint)))
+	})
+}}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+func TestUpstreamHintMiddleware(t *testing.T) {
+	tests := []struct {
+		header string
+		hint   promclient.UpstreamHint
+		code   int
+	}{
+		{header: "", hint: promclient.NoUpstreamHint, code: http.StatusOK},
+		{header: "fast", hint: promclient.PreferFast, code: http.StatusOK},
+		{header: "consistent", hint: promclient.PreferConsistent, code: http.StatusOK},
+		{header: "cheap", code: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		t.Run(test.header, func(t *testing.T) {
+			var hint promclient.UpstreamHint
+			h := NewChain(MiddlewareConfig{}).Use(UpstreamHintMiddleware).Then(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				hint = promclient.UpstreamHintFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/api/v1/query", nil)
+			if test.header != "" {
+				req.Header.Set(UpstreamHintHeader, test.header)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+			if w.Code != test.code {
+				t.Fatalf("mismatch in status code expected=%d actual=%d", test.code, w.Code)
+			}
+			if hint != test.hint {
+				t.Fatalf("mismatch in hint expected=%v actual=%v", test.hint, hint)
+			}
+		})
+	}
+}
@@ -0,0 +1,17 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+// QueryVerificationMiddleware verifies a sample of the range queries with the
+// QueryVerifier (see promclient.QueryVerifier), after they were served
+func QueryVerificationMiddleware(v *promclient.QueryVerifier) Middleware {
+	return MiddlewareFunc{S: StageStats, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(promclient.WithQueryVerifier(r.Context(), v)))
+		})
+	}}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/metrics"
+	"github.com/promproxy/pkg/promclient"
+	"github.com/promproxy/pkg/promutil"
+)
+
+// VirtualProxyPrefix is the URL prefix of the requests for a virtual proxy, which
+// is followed by its name (e.g. /t/team-a/api/v1/query)
+const VirtualProxyPrefix = "/t/"
+
+var virtualProxyRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: metrics.Namespace,
+	Name:      "virtual_proxy_requests_total",
+	Help:      "Number of requests served by each virtual proxy",
+}, []string{"virtual_proxy"})
+
+func init() {
+	metrics.MustRegister(virtualProxyRequestsTotal)
+}
+
+// VirtualProxyRouter looks up the virtual proxies served besides the root proxy
+type VirtualProxyRouter interface {
+	// VirtualProxy returns the handler of the named virtual proxy (nil if there
+	// is none)
+	VirtualProxy(name string) http.Handler
+	// TenantVirtualProxy returns the name of the virtual proxy of the tenant
+	// (empty if the tenant is served by the root proxy)
+	TenantVirtualProxy(tenant string) string
+	// HasTenants returns whether any tenant belongs to the named virtual proxy
+	HasTenants(name string) bool
+}
+
+// virtualProxyPath splits a path with the VirtualProxyPrefix into the name of the
+// virtual proxy and the path within it
+func virtualProxyPath(path string) (string, string, bool) {
+	if !strings.HasPrefix(path, VirtualProxyPrefix) {
+		return "", "", false
+	}
+	rest := path[len(VirtualProxyPrefix):]
+	name := rest
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		name, rest = rest[:i], rest[i:]
+	} else {
+		rest = "/"
+	}
+	return name, rest, name != ""
+}
+
+// VirtualProxyMiddleware returns the Middleware which hands the requests of the
+// virtual proxies to their handlers, the others proceed to the root proxy. The
+// virtual proxy of a request is the one named by its URL prefix (which is
+// stripped), or otherwise the one of its tenant. The prefix of a virtual proxy
+// with tenants is only available to its tenants. The prefix of a virtual proxy
+// without tenants is available to the requests without a virtual proxy of their
+// own, which its own auth (required for such virtual proxies, see
+// proxyconfig.PromxyConfig.ValidateVirtualProxies) then authenticates. As it runs
+// after the TenancyMiddleware the handlers of the virtual proxies authenticate
+// their requests in addition to the root auth.
+func VirtualProxyMiddleware(router VirtualProxyRouter) Middleware {
+	return MiddlewareFunc{S: StageTenancy, F: func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tenantProxy := router.TenantVirtualProxy(promclient.TenantFromContext(r.Context()))
+			name, path, prefixed := virtualProxyPath(r.URL.Path)
+			if !prefixed {
+				name = tenantProxy
+			}
+			if name == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if tenantProxy != name && (tenantProxy != "" || router.HasTenants(name)) {
+				respondError(w, &apiError{promutil.ErrorForbidden, fmt.Errorf("virtual proxy %q is not available to the tenant", name)}, nil)
+				return
+			}
+			handler := router.VirtualProxy(name)
+			if handler == nil {
+				respondError(w, &apiError{promutil.ErrorNotFound, fmt.Errorf("unknown virtual proxy %q", name)}, nil)
+				return
+			}
+
+			// WithContext copies the request, so its URL can be replaced
+			r = r.WithContext(promclient.WithVirtualProxy(r.Context(), name))
+			if prefixed {
+				u := *r.URL
+				u.Path, u.RawPath = path, ""
+				r.URL = &u
+			}
+			virtualProxyRequestsTotal.WithLabelValues(name).Inc()
+			logger.WithFields(logrus.Fields{
+				"virtual_proxy": name,
+				"path":          r.URL.Path,
+			}).Debug("Serving request of virtual proxy")
+			handler.ServeHTTP(w, r)
+		})
+	}}
+}
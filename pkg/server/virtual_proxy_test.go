@@ -0,0 +1,85 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/promproxy/pkg/promclient"
+)
+
+type testVirtualProxyRouter struct {
+	handlers map[string]http.Handler
+	tenants  map[string]string
+}
+
+func (r *testVirtualProxyRouter) VirtualProxy(name string) http.Handler {
+	return r.handlers[name]
+}
+
+func (r *testVirtualProxyRouter) TenantVirtualProxy(tenant string) string {
+	return r.tenants[tenant]
+}
+
+func (r *testVirtualProxyRouter) HasTenants(name string) bool {
+	for _, vp := range r.tenants {
+		if vp == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestVirtualProxyMiddleware(t *testing.T) {
+	served := func(name string) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if vp := promclient.VirtualProxyFromContext(r.Context()); vp != name {
+				t.Fatalf("mismatch in virtual proxy of the context expected=%v actual=%v", name, vp)
+			}
+			w.Write([]byte(name + " " + r.URL.Path))
+		})
+	}
+	router := &testVirtualProxyRouter{
+		handlers: map[string]http.Handler{"a": served("a"), "b": served("b")},
+		tenants:  map[string]string{"alice": "a"},
+	}
+	handler := NewChain(MiddlewareConfig{}).Use(VirtualProxyMiddleware(router)).Then(served(""))
+
+	tests := []struct {
+		path   string
+		tenant string
+		code   int
+		body   string
+	}{
+		{path: "/api/v1/query", code: http.StatusOK, body: " /api/v1/query"},
+		{path: "/api/v1/query", tenant: "alice", code: http.StatusOK, body: "a /api/v1/query"},
+		{path: "/t/a/api/v1/query", tenant: "alice", code: http.StatusOK, body: "a /api/v1/query"},
+		{path: "/api/v1/query", tenant: "bob", code: http.StatusOK, body: " /api/v1/query"},
+		// Only the tenants of a virtual proxy can use its prefix
+		{path: "/t/a/api/v1/query", code: http.StatusForbidden},
+		{path: "/t/a/api/v1/query", tenant: "bob", code: http.StatusForbidden},
+		// A virtual proxy without tenants is left to its own auth, unless the
+		// tenant belongs to another one
+		{path: "/t/b", code: http.StatusOK, body: "b /"},
+		{path: "/t/b/api/v1/query", tenant: "bob", code: http.StatusOK, body: "b /api/v1/query"},
+		{path: "/t/b/api/v1/query", tenant: "alice", code: http.StatusForbidden},
+		{path: "/t/c/api/v1/query", code: http.StatusNotFound},
+	}
+
+	for _, test := range tests {
+		t.Run(test.tenant+test.path, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			if test.tenant != "" {
+				r = r.WithContext(promclient.WithTenant(r.Context(), test.tenant))
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.code {
+				t.Fatalf("mismatch in code expected=%v actual=%v", test.code, w.Code)
+			}
+			if test.body != "" && w.Body.String() != test.body {
+				t.Fatalf("mismatch in body expected=%v actual=%v", test.body, w.Body.String())
+			}
+		})
+	}
+}
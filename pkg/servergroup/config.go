@@ -2,12 +2,16 @@ package servergroup
 
 import (
 	"fmt"
+	"regexp"
 	"time"
 
 	config_util "github.com/prometheus/common/config"
 	"github.com/prometheus/common/model"
 	sd_config "github.com/prometheus/prometheus/discovery/config"
 	"github.com/prometheus/prometheus/pkg/relabel"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/promproxy/pkg/consulsd"
 )
 
 var (
@@ -18,6 +22,16 @@ var (
 		HTTPConfig: HTTPClientConfig{
 			DialTimeout: time.Millisecond * 2000, // Default dial timeout of 200ms
 		},
+		LabelValidation:    promclient.LabelValidationSanitize,
+		EmptyMatchers:      promclient.EmptyMatchersError,
+		EmptyMatchersLimit: 1000,
+		SeriesLimit:        promclient.SeriesLimitAuto,
+		QueryLimit:         promclient.SeriesLimitAuto,
+		MergeMode:          promclient.MergeModeDedupe,
+		ConcatDuplicateCheck: promclient.DuplicateCheck{
+			SampleEvery: 16,
+			Policy:      promclient.DuplicatePolicyWarn,
+		},
 	}
 )
 
@@ -51,6 +65,13 @@ type Config struct {
 	// Labels is a set of labels that will be added to all metrics retrieved
 	// from this server group
 	Labels model.LabelSet `json:"labels"`
+	// ExternalLabels is a set of labels which identify this server group for routing.
+	// Unlike Labels these are not added to the results, instead queries with matchers
+	// on these labels are only sent to server groups whose ExternalLabels match, and
+	// the matchers are stripped before the query is sent downstream. This is useful
+	// when the downstream prometheus hosts are tagged with external labels (such as
+	// `prometheus="eu"`) which don't exist on the series themselves.
+	ExternalLabels model.LabelSet `yaml:"external_labels"`
 	// RelabelConfigs are similar in function and identical in configuration as prometheus'
 	// relabel config for scrape jobs. The difference here being that the source labels
 	// you can pull from are from the downstream servergroup target and the labels you are
@@ -81,6 +102,11 @@ type Config struct {
 	// Hosts is a set of ServiceDiscoveryConfig options that allow promxy to discover
 	// all hosts in the server_group
 	Hosts sd_config.ServiceDiscoveryConfig `yaml:",inline"`
+	// ConsulWatchConfigs discover hosts from Consul services, in addition to the
+	// Hosts. Unlike the consul_sd_configs of the Hosts these refresh the ACL token
+	// from a file, debounce flapping instances and keep the last known hosts while
+	// Consul can't be reached.
+	ConsulWatchConfigs []*consulsd.SDConfig `yaml:"consul_watch_configs,omitempty"`
 	// PathPrefix to prepend to all queries to hosts in this servergroup
 	PathPrefix string `yaml:"path_prefix"`
 	// TODO cache this as a model.Time after unmarshal
@@ -100,6 +126,36 @@ type Config struct {
 	// Note: this allows you to make the tradeoff between availability of queries and consistency of results
 	IgnoreError bool `yaml:"ignore_error"`
 
+	// LabelValidation defines what to do with series returned from this servergroup
+	// that have invalid label sets (empty or invalid label names, or values which
+	// aren't valid UTF-8). Options are "sanitize" (the default) which drops the
+	// series with a warning, "reject" which fails the request and "none" which
+	// skips the validation for trusted servergroups.
+	LabelValidation promclient.LabelValidationMode `yaml:"label_validation"`
+
+	// MergeMode defines how the results from the hosts in this servergroup are
+	// combined. "dedupe" (the default) merges series which exist on multiple hosts,
+	// "concat" assumes the hosts have disjoint series (e.g. shards) and simply appends
+	// the results -- which is much faster but doubles up any series that do overlap.
+	MergeMode promclient.MergeMode `yaml:"merge_mode"`
+	// ConcatDuplicateCheck configures the sampled detection of duplicate series in
+	// concat mode, which warns (or errors) if the hosts aren't actually disjoint.
+	ConcatDuplicateCheck promclient.DuplicateCheck `yaml:"concat_duplicate_check"`
+	// LoadBalance, if set, means the hosts of this servergroup are equivalent
+	// replicas. Rather than sending each request to all of the hosts and merging the
+	// results, it is sent to a single host (failing over to the others) picked as
+	// configured, or as the UpstreamHint of the request prefers (see
+	// server.UpstreamHintMiddleware).
+	LoadBalance *promclient.LoadBalancerConfig `yaml:"load_balance,omitempty"`
+
+	// MetricAllowlist and MetricDenylist restrict which metrics are exposed from this
+	// servergroup, each is a list of (fully anchored) metric name regexes. If an
+	// allowlist is set only matching metrics are exposed, and metrics matching the
+	// denylist are never exposed. This is enforced on the selectors sent to the
+	// servergroup as well as on the series returned from it.
+	MetricAllowlist []string `yaml:"metric_allowlist,omitempty"`
+	MetricDenylist  []string `yaml:"metric_denylist,omitempty"`
+
 	// RelativeTimeRangeConfig defines a relative time range that this servergroup will respond to
 	// An example use-case would be if a specific servergroup was long-term storage, it might only
 	// have data 3d old and retain 90d of data.
@@ -109,6 +165,95 @@ type Config struct {
 	// An example use-case would be if a specific servergroup was was "deprecated" and wasn't getting
 	// any new data after a specific given point in time
 	*AbsoluteTimeRangeConfig `yaml:"absolute_time_range"`
+
+	// Resolution is the interval between samples stored in this servergroup. This is
+	// used when stitching tiered servergroups (e.g. downsampled long-term storage behind
+	// raw recent data) so that range-vector functions at the boundary have enough data,
+	// and so that users are warned when a query mixes resolutions.
+	Resolution time.Duration `yaml:"resolution,omitempty"`
+
+	// ResampleResolution, if set, downsamples the data from this servergroup to the
+	// given resolution when a query spans past its time range. This is useful for
+	// visual consistency when the other tiers have a coarser resolution.
+	ResampleResolution time.Duration `yaml:"resample_resolution,omitempty"`
+
+	// QuerySplitInterval, if set, splits range queries to this servergroup which are
+	// longer than the interval into multiple sequential sub-queries.
+	QuerySplitInterval time.Duration `yaml:"query_split_interval,omitempty"`
+
+	// Transforms are applied (in order) to the values returned from each host in
+	// this servergroup before they are merged, e.g. to convert the units of a
+	// misbehaving exporter. Each is reported in the warnings when it modifies data.
+	Transforms []promclient.TransformConfig `yaml:"transforms,omitempty"`
+
+	// CircuitBreaker, if set, stops sending requests to a host of this servergroup
+	// after consecutive failures, until the host is given another try.
+	CircuitBreaker *promclient.CircuitBreakerConfig `yaml:"circuit_breaker,omitempty"`
+
+	// RedactLabels are the labels whose values (e.g. user IDs) are redacted from the
+	// errors and warnings of the hosts of this servergroup, and the calls logged
+	RedactLabels []string `yaml:"redact_labels,omitempty"`
+
+	// FaultInjection allows the faults set at runtime through the fault injection
+	// admin endpoint to be injected into the requests to the hosts of this
+	// servergroup, for resilience drills. The hosts are left alone if unset.
+	FaultInjection bool `yaml:"fault_injection,omitempty"`
+
+	// CostAccounting records the cost (duration and samples scanned) of the queries
+	// to each host of this servergroup by query shape, for capacity planning. The
+	// samples are those reported with stats=all, or estimated from the samples
+	// returned by hosts which don't report stats.
+	CostAccounting bool `yaml:"cost_accounting,omitempty"`
+
+	// Retry, if set, retries requests which failed due to the host. The retries to
+	// each host are limited by a budget, so they don't amplify the load of a host
+	// which is already struggling.
+	Retry *promclient.RetryConfig `yaml:"retry,omitempty"`
+
+	// MetricMetadata, if set, consults the metadata API of the hosts for the types
+	// of the metrics, so the series of counters are merged without adding resets
+	// where the hosts' counters differ. The types are cached, so this doesn't add
+	// a round-trip to queries.
+	MetricMetadata *promclient.MetadataConfig `yaml:"metric_metadata,omitempty"`
+
+	// MaxAge, if set, requests responses again whose Cache-Control max-age is too
+	// low, for hosts behind a CDN which caches at the HTTP layer
+	MaxAge *promclient.MaxAgeConfig `yaml:"max_age,omitempty"`
+
+	// QueryComment, if set, appends a comment attributing the query (e.g. to the
+	// user) to the queries forwarded to the hosts, which shows up in their query log
+	QueryComment *promclient.QueryCommentConfig `yaml:"query_comment,omitempty"`
+
+	// EmptyMatchers defines how Series calls are handled whose selector has no
+	// matchers left once the matchers on the Labels or ExternalLabels of this
+	// servergroup are stripped (e.g. `{region="eu"}`), which prometheus rejects.
+	// By default these fail, with "catch_all" all series are selected instead, of
+	// which at most EmptyMatchersLimit are returned.
+	EmptyMatchers      promclient.EmptyMatchersPolicy `yaml:"empty_matchers,omitempty"`
+	EmptyMatchersLimit int                            `yaml:"empty_matchers_limit,omitempty"`
+
+	// SeriesLimit defines whether the series limit of a Series call (e.g. the
+	// max_series of promproxy) is sent to the hosts as the limit param, so they
+	// stop at the limit. By default this is detected from the prometheus version
+	// of each host, for hosts which don't support the param the responses are
+	// truncated by promproxy instead.
+	SeriesLimit promclient.SeriesLimitSupport `yaml:"series_limit,omitempty"`
+	// QueryLimit is the same as SeriesLimit for the limit param of the query and
+	// query_range calls (see promclient.QueryLimitRoundTripper)
+	QueryLimit promclient.SeriesLimitSupport `yaml:"query_limit,omitempty"`
+
+	// Scrape, if set, means the hosts of this servergroup aren't Prometheus servers
+	// but only expose their metrics (e.g. an exporter). The hosts are scraped on
+	// demand and only their current values can be queried.
+	Scrape *promclient.ScrapeConfig `yaml:"scrape,omitempty"`
+}
+
+// emptyMatchers returns the EmptyMatchersConfig of the clients stripping matchers
+func (c *Config) emptyMatchers() promclient.EmptyMatchersConfig {
+	return promclient.EmptyMatchersConfig{
+		Policy: c.EmptyMatchers,
+		Limit:  c.EmptyMatchersLimit,
+	}
 }
 
 // GetScheme returns the scheme for this servergroup
@@ -121,6 +266,30 @@ func (c *Config) GetAntiAffinity() model.Time {
 	return model.TimeFromUnix(int64((c.AntiAffinity).Seconds()))
 }
 
+// TimeWindow returns the time range this servergroup serves, zero times are unbounded
+func (c *Config) TimeWindow() (time.Time, time.Time) {
+	var start, end time.Time
+	if c.AbsoluteTimeRangeConfig != nil {
+		start, end = c.AbsoluteTimeRangeConfig.Start, c.AbsoluteTimeRangeConfig.End
+	}
+
+	if c.RelativeTimeRangeConfig != nil {
+		now := time.Now()
+		if c.RelativeTimeRangeConfig.Start != nil {
+			if relStart := now.Add(*c.RelativeTimeRangeConfig.Start); start.IsZero() || relStart.After(start) {
+				start = relStart
+			}
+		}
+		if c.RelativeTimeRangeConfig.End != nil {
+			if relEnd := now.Add(*c.RelativeTimeRangeConfig.End); end.IsZero() || relEnd.Before(end) {
+				end = relEnd
+			}
+		}
+	}
+
+	return start, end
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultConfig
@@ -128,13 +297,108 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	// To make unmarshal fill the plain data struct rather than calling UnmarshalYAML
 	// again, we have to hide it using a type indirection.
 	type plain Config
-	return unmarshal((*plain)(c))
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c *Config) Validate() error {
+	for _, pattern := range append(append([]string{}, c.MetricAllowlist...), c.MetricDenylist...) {
+		if _, err := regexp.Compile("^(?:" + pattern + ")$"); err != nil {
+			return fmt.Errorf("invalid metric name regex %q: %v", pattern, err)
+		}
+	}
+
+	if err := c.MergeMode.Validate(); err != nil {
+		return err
+	}
+	if err := c.ConcatDuplicateCheck.Validate(); err != nil {
+		return err
+	}
+	if c.Retry != nil {
+		if err := c.Retry.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.MetricMetadata != nil {
+		if err := c.MetricMetadata.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.MaxAge != nil {
+		if err := c.MaxAge.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, name := range c.RedactLabels {
+		if !model.LabelName(name).IsValid() {
+			return fmt.Errorf("invalid redact_labels label name %q", name)
+		}
+	}
+	if c.QueryComment != nil {
+		if err := c.QueryComment.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.CircuitBreaker != nil {
+		if err := c.CircuitBreaker.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.Scrape != nil {
+		if err := c.Scrape.Validate(); err != nil {
+			return err
+		}
+	}
+	if c.LoadBalance != nil {
+		if err := c.LoadBalance.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, consulCfg := range c.ConsulWatchConfigs {
+		if err := consulCfg.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, transform := range c.Transforms {
+		if err := transform.Validate(); err != nil {
+			return err
+		}
+	}
+	if err := c.EmptyMatchers.Validate(); err != nil {
+		return err
+	}
+	if c.EmptyMatchers == promclient.EmptyMatchersCatchAll && c.EmptyMatchersLimit <= 0 {
+		return fmt.Errorf("empty_matchers_limit must be positive for the %q empty matchers policy", c.EmptyMatchers)
+	}
+
+	if err := c.SeriesLimit.Validate(); err != nil {
+		return err
+	}
+	if err := c.QueryLimit.Validate(); err != nil {
+		return err
+	}
+
+	return c.LabelValidation.Validate()
 }
 
 // HTTPClientConfig extends prometheus' HTTPClientConfig
 type HTTPClientConfig struct {
-	DialTimeout time.Duration                `yaml:"dial_timeout"`
-	HTTPConfig  config_util.HTTPClientConfig `yaml:",inline"`
+	DialTimeout time.Duration `yaml:"dial_timeout"`
+	// RequestIDHeader (if set) is the header in which a unique ID is sent with each
+	// request to the servergroup. The ID is logged (at debug level) along with the
+	// correlation ID of the query, so the sub-query can be found in the logs of the
+	// downstream.
+	RequestIDHeader string `yaml:"request_id_header"`
+	// MaxRedirects is the max number of redirects followed (10 if 0, none if
+	// negative). Redirects to the same host keep all the headers of the request.
+	MaxRedirects int `yaml:"max_redirects"`
+	// CrossHostRedirects allows redirects to other hosts, which don't keep the
+	// auth headers of the request
+	CrossHostRedirects bool                         `yaml:"cross_host_redirects"`
+	HTTPConfig         config_util.HTTPClientConfig `yaml:",inline"`
 }
 
 // RelativeTimeRangeConfig configures durations relative from "now" to define
@@ -2,6 +2,7 @@ package servergroup
 
 import (
 	"context"
+	"fmt"
 	"net"
 	"net/http"
 	"net/url"
@@ -20,10 +21,13 @@ import (
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/relabel"
-	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/sirupsen/logrus"
 
 	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/promproxy/pkg/consulsd"
+	"github.com/promproxy/pkg/metrics"
+	"github.com/promproxy/pkg/promutil"
+	"github.com/promproxy/pkg/remote"
 
 	sd_config "github.com/prometheus/prometheus/discovery/config"
 )
@@ -34,10 +38,56 @@ var (
 		Name: "server_group_request_duration_seconds",
 		Help: "Summary of calls to servergroup instances",
 	}, []string{"host", "call", "status"})
+
+	invalidLabelSetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "server_group_invalid_labelsets_total",
+		Help:      "Number of series with invalid label sets returned by servergroup instances",
+	}, []string{"host"})
+
+	deniedSeriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "server_group_denied_series_total",
+		Help:      "Number of series dropped from servergroup instances by the metric allowlist/denylist",
+	}, []string{"host"})
+
+	queryCostSecondsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "server_group_query_cost_seconds_total",
+		Help:      "Total duration of the queries to servergroup instances by query shape",
+	}, []string{"host", "call", "shape"})
+
+	queryCostQueriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "server_group_query_cost_queries_total",
+		Help:      "Number of queries to servergroup instances by query shape",
+	}, []string{"host", "call", "shape"})
+
+	queryCostSamplesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "server_group_query_cost_samples_total",
+		Help:      "Number of samples scanned by servergroup instances by query shape, as reported (stats) or estimated from the samples returned (estimate)",
+	}, []string{"host", "call", "shape", "source"})
 )
 
 func init() {
+	// The request duration predates the registry of promproxy's own metrics, so it
+	// stays on the default registry under its original name
 	prometheus.MustRegister(serverGroupSummary)
+	metrics.MustRegister(
+		invalidLabelSetsTotal,
+		deniedSeriesTotal,
+		queryCostSecondsTotal,
+		queryCostQueriesTotal,
+		queryCostSamplesTotal,
+		promclient.DefaultHealthMonitor,
+		promclient.DefaultCircuitBreakers,
+		promclient.DefaultRetryBudgets,
+		promclient.DefaultWorkerPool,
+		promclient.DefaultFaultInjector,
+		promclient.DefaultQueryVerifier,
+		promclient.DefaultLatencyTrackers,
+	)
 }
 
 // New creates a new servergroup
@@ -69,8 +119,12 @@ func New() *ServerGroup {
 // ServerGroupState encapsulates the state of a serverGroup from service discovery
 type ServerGroupState struct {
 	// Targets is the list of target URLs for this discovery round
-	Targets   []string
-	apiClient promclient.API
+	Targets     []string
+	apiClient   promclient.API
+	adminClient promclient.AdminAPI
+	tsdbStatus  promclient.TSDBStatuser
+	// transformed is set if the values of any host are transformed
+	transformed bool
 }
 
 // ServerGroup encapsulates a set of prometheus downstreams to query/aggregate
@@ -85,15 +139,31 @@ type ServerGroup struct {
 	Cfg           *Config
 	Client        *http.Client
 	targetManager *discovery.Manager
+	// metadata (if configured) caches the metric types of the hosts
+	metadata *promclient.MetadataCache
 
 	OriginalURLs []string
 
 	state atomic.Value
 }
 
+// RoutingLabels returns the labels the queries are routed to the servergroup by,
+// implementing promclient.RoutingLabeler
+func (s *ServerGroup) RoutingLabels() model.LabelSet {
+	return s.Cfg.Labels.Merge(s.Cfg.ExternalLabels)
+}
+
+// Transformed returns whether the values of any host are transformed, in which
+// case only the raw values are correct (see promclient.TransformAPI)
+func (s *ServerGroup) Transformed() bool {
+	state := s.State()
+	return state != nil && state.transformed
+}
+
 // Cancel stops backround processes (e.g. discovery manager)
 func (s *ServerGroup) Cancel() {
 	s.ctxCancel()
+	promclient.DefaultHealthMonitor.SetUpstreams(s, nil)
 }
 
 // Sync updates the targets from our discovery manager
@@ -104,24 +174,17 @@ SYNC_LOOP:
 	for targetGroupMap := range syncCh {
 		logrus.Debug("Updating targets from discovery manager")
 		targets := make([]string, 0)
+		targetURLs := make([]*url.URL, 0)
 		apiClients := make([]promclient.API, 0)
+		adminClients := make(promclient.MultiAdminAPI, 0)
+		tsdbStatusClients := make(promclient.MultiTSDBStatus, 0)
+		transformed := false
 
 		for _, targetGroupList := range targetGroupMap {
 			for _, targetGroup := range targetGroupList {
 				for _, target := range targetGroup.Targets {
-					lbls := make([]labels.Label, 0, len(target)+len(targetGroup.Labels))
-
-					for ln, lv := range target {
-						lbls = append(lbls, labels.Label{Name: string(ln), Value: string(lv)})
-					}
-
-					for ln, lv := range targetGroup.Labels {
-						if _, ok := target[ln]; !ok {
-							lbls = append(lbls, labels.Label{Name: string(ln), Value: string(lv)})
-						}
-					}
-
-					lset := labels.New(lbls...)
+					// The labels of the target take precedence over those of its group
+					lset := promutil.ModelMetricToLabels(model.Metric(targetGroup.Labels.Merge(target)))
 					logrus.Tracef("Potential target pre-relabel: %v", lset)
 					lset = relabel.Process(lset, s.Cfg.RelabelConfigs...)
 					logrus.Tracef("Potential target post-relabel: %v", lset)
@@ -142,6 +205,8 @@ SYNC_LOOP:
 						Path:   s.Cfg.PathPrefix,
 					}
 					targets = append(targets, u.Host)
+					targetURL := *u
+					targetURLs = append(targetURLs, &targetURL)
 
 					client, err := api.NewClient(api.Config{Address: u.String(), RoundTripper: s.Client.Transport})
 					if err != nil {
@@ -150,8 +215,33 @@ SYNC_LOOP:
 
 					var apiClient promclient.API
 					apiClient = &promclient.PromAPIV1{v1.NewAPI(client)}
+					tsdbStatusURL := *u
+					tsdbStatusClients = append(tsdbStatusClients, &promclient.TSDBStatusClient{Client: s.Client, URL: &tsdbStatusURL})
+
+					// Series results are decoded as they are read, to bound the memory of broad matchers
+					seriesURL := *u
+					apiClient = &promclient.SeriesStreamClient{
+						API:          apiClient,
+						Client:       s.Client,
+						URL:          &seriesURL,
+						SeriesLimit:  s.Cfg.SeriesLimit,
+						Capabilities: promclient.DefaultCapabilityProbes.Get(u.Host, s.Client, &seriesURL),
+					}
 
-					if s.Cfg.RemoteRead {
+					// Hosts which only expose their metrics are scraped instead
+					if s.Cfg.Scrape != nil {
+						scrapePath := s.Cfg.Scrape.Path
+						if scrapePath == "" {
+							scrapePath = promclient.DefaultScrapePath
+						}
+						scrapeURL := *u
+						scrapeURL.Path = path.Join(scrapeURL.Path, scrapePath)
+						apiClient = &promclient.ScrapeAPI{
+							URL:    scrapeURL.String(),
+							Client: s.Client,
+							Config: *s.Cfg.Scrape,
+						}
+					} else if s.Cfg.RemoteRead {
 						u.Path = path.Join(u.Path, "api/v1/read")
 						cfg := &remote.ClientConfig{
 							URL: &config_util.URL{u},
@@ -163,7 +253,95 @@ SYNC_LOOP:
 							panic(err)
 						}
 
-						apiClient = &promclient.PromAPIRemoteRead{apiClient, remoteStorageClient}
+						apiClient = &promclient.PromAPIRemoteRead{apiClient, remoteStorageClient, u.String()}
+					}
+
+					// Account the cost of each call to the host (if configured)
+					if s.Cfg.CostAccounting {
+						host := u.Host
+						apiClient = &promclient.QueryCostAPI{
+							API: apiClient,
+							CostFunc: func(method, shape string, cost promclient.QueryCost) {
+								queryCostSecondsTotal.WithLabelValues(host, method, shape).Add(cost.Duration.Seconds())
+								queryCostQueriesTotal.WithLabelValues(host, method, shape).Inc()
+								if cost.Source != "" {
+									queryCostSamplesTotal.WithLabelValues(host, method, shape, cost.Source).Add(float64(cost.Samples))
+								}
+							},
+						}
+					}
+
+					// Request stale responses again (if configured)
+					if s.Cfg.MaxAge != nil {
+						apiClient = &promclient.MaxAgeEnforcerAPI{
+							API:                 apiClient,
+							MinAcceptableMaxAge: s.Cfg.MaxAge.MinAcceptableMaxAge,
+							BackoffDuration:     s.Cfg.MaxAge.BackoffDuration,
+							MaxRetries:          s.Cfg.MaxAge.MaxRetries,
+						}
+					}
+
+					// Retry requests which failed due to the host (if configured)
+					if s.Cfg.Retry != nil {
+						apiClient = &promclient.RetryAPI{
+							API:        apiClient,
+							MaxRetries: s.Cfg.Retry.MaxRetries,
+							Backoff:    s.Cfg.Retry.Backoff,
+							Budget:     promclient.DefaultRetryBudgets.Get(u.Host, s.Cfg.Retry.Budget),
+						}
+					}
+
+					// Validate the label sets as they come off the wire
+					if s.Cfg.LabelValidation != promclient.LabelValidationNone {
+						host := u.Host
+						apiClient = &promclient.LabelValidationAPI{
+							API:  apiClient,
+							Mode: s.Cfg.LabelValidation,
+							InvalidFunc: func(count int) {
+								invalidLabelSetsTotal.WithLabelValues(host).Add(float64(count))
+							},
+						}
+					}
+
+					// Correct the values of this host (if configured or registered)
+					transforms := promclient.RegisteredTransforms(u.Host)
+					for _, transformCfg := range s.Cfg.Transforms {
+						transform, err := transformCfg.Transform()
+						if err != nil {
+							logrus.Errorf("Invalid transform: %v", err)
+							continue SYNC_LOOP
+						}
+						transforms = append(transforms, transform)
+					}
+					if len(transforms) > 0 {
+						transformed = true
+						apiClient = &promclient.TransformAPI{
+							API:        apiClient,
+							Transforms: transforms,
+						}
+					}
+
+					// Restrict the metrics exposed from this servergroup
+					if len(s.Cfg.MetricAllowlist) > 0 || len(s.Cfg.MetricDenylist) > 0 {
+						host := u.Host
+						apiClient, err = promclient.NewMetricFilterAPI(apiClient, s.Cfg.MetricAllowlist, s.Cfg.MetricDenylist, func(count int) {
+							deniedSeriesTotal.WithLabelValues(host).Add(float64(count))
+						})
+						if err != nil {
+							logrus.Errorf("Invalid metric allowlist/denylist: %v", err)
+							continue SYNC_LOOP
+						}
+					}
+
+					// Stitch tiers of differing resolution (if configured), within the
+					// time filters so only the queries routed to this tier are extended
+					if s.Cfg.Resolution > 0 || s.Cfg.ResampleResolution > 0 {
+						apiClient = &promclient.ResolutionAPI{
+							API:        apiClient,
+							Resolution: s.Cfg.Resolution,
+							Resample:   s.Cfg.ResampleResolution,
+							Window:     s.Cfg.TimeWindow,
+						}
 					}
 
 					// Optionally add time range layers
@@ -183,6 +361,23 @@ SYNC_LOOP:
 						}
 					}
 
+					// Split long range queries (if configured)
+					if s.Cfg.QuerySplitInterval > 0 {
+						apiClient = &promclient.SplitRangeAPI{
+							API:      apiClient,
+							Interval: s.Cfg.QuerySplitInterval,
+						}
+					}
+
+					// Route based on external labels (if configured)
+					if len(s.Cfg.ExternalLabels) > 0 {
+						apiClient = &promclient.ExternalLabelClient{
+							API:           apiClient,
+							Labels:        s.Cfg.ExternalLabels,
+							EmptyMatchers: s.Cfg.emptyMatchers(),
+						}
+					}
+
 					// We remove all private labels after we set the target entry
 					modelLabelSet := make(model.LabelSet, len(lset))
 					for _, lbl := range lset {
@@ -192,14 +387,51 @@ SYNC_LOOP:
 					}
 
 					// Add labels
-					apiClient = &promclient.AddLabelClient{apiClient, modelLabelSet.Merge(s.Cfg.Labels)}
+					apiClient = &promclient.AddLabelClient{
+						API:           apiClient,
+						Labels:        modelLabelSet.Merge(s.Cfg.Labels),
+						EmptyMatchers: s.Cfg.emptyMatchers(),
+					}
+
+					// The admin calls go straight to the host, routed on the same labels
+					// as the queries
+					adminClients = append(adminClients, &promclient.AddLabelAdminAPI{
+						AdminAPI: &promclient.PromAdminAPI{API: v1.NewAPI(client), Host: u.Host},
+						Labels:   modelLabelSet.Merge(s.Cfg.Labels).Merge(s.Cfg.ExternalLabels),
+					})
+
+					// Inject the faults of resilience drills (if enabled), before the
+					// circuit breaker so the drills exercise it
+					if s.Cfg.FaultInjection {
+						apiClient = promclient.DefaultFaultInjector.Wrap(u.Host, apiClient)
+					}
+
+					// Stop sending requests to a failing upstream (if configured)
+					if s.Cfg.CircuitBreaker != nil {
+						apiClient = promclient.DefaultCircuitBreakers.Wrap(u.Host, apiClient, *s.Cfg.CircuitBreaker)
+					}
+
+					// Allow the upstream to be taken offline at runtime
+					apiClient = promclient.DefaultHealthMonitor.Wrap(u.Host, apiClient)
 
-					// If debug logging is enabled, wrap the client with a debugAPI client
+					// Redact the sensitive label values from the errors (if configured),
+					// before they are logged by the debugAPI client
+					var redact func(string) string
+					if len(s.Cfg.RedactLabels) > 0 {
+						redaction := promclient.NewLabelRedactionAPI(apiClient, s.Cfg.RedactLabels, nil)
+						redact = redaction.Redact
+						apiClient = redaction
+					}
+
+					// Record the calls in the trace of the request (if any)
+					apiClient = &promclient.TracingAPI{API: apiClient, Name: u.Host}
+
+					// Wrap the client with a debugAPI client. This is done regardless of the
+					// current log level as the level of the promclient component can be
+					// changed at runtime.
 					// Since these are called in the reverse order of what we add, we want
 					// to make sure that this is the last wrap of the client
-					if logrus.GetLevel() >= logrus.DebugLevel {
-						apiClient = &promclient.DebugAPI{apiClient, u.String()}
-					}
+					apiClient = &promclient.DebugAPI{API: apiClient, PrefixMessage: u.String(), Redact: redact}
 
 					apiClients = append(apiClients, apiClient)
 				}
@@ -210,10 +442,31 @@ SYNC_LOOP:
 			serverGroupSummary.WithLabelValues(targets[i], api, status).Observe(took)
 		}
 
+		if s.metadata != nil {
+			s.metadata.SetURLs(targetURLs)
+		}
+
+		var apiClient promclient.API
+		if s.Cfg.LoadBalance != nil {
+			// The hosts are replicas, so each request is only sent to one of them
+			apiClient = promclient.DefaultLatencyTrackers.LoadBalance(targets, apiClients, *s.Cfg.LoadBalance)
+		} else {
+			multiAPI := promclient.NewMultiAPI(apiClients, s.Cfg.GetAntiAffinity(), apiClientMetricFunc, 1)
+			multiAPI.MergeMode = s.Cfg.MergeMode
+			multiAPI.DuplicateCheck = s.Cfg.ConcatDuplicateCheck
+			if s.metadata != nil {
+				multiAPI.MetricTypes = s.metadata.Type
+			}
+			apiClient = multiAPI
+		}
+
 		logrus.Debugf("Updating targets from discovery manager: %v", targets)
 		newState := &ServerGroupState{
-			Targets:   targets,
-			apiClient: promclient.NewMultiAPI(apiClients, s.Cfg.GetAntiAffinity(), apiClientMetricFunc, 1),
+			Targets:     targets,
+			apiClient:   apiClient,
+			adminClient: adminClients,
+			tsdbStatus:  tsdbStatusClients,
+			transformed: transformed,
 		}
 
 		if s.Cfg.IgnoreError {
@@ -221,6 +474,8 @@ SYNC_LOOP:
 		}
 
 		s.state.Store(newState)
+		// Forget the health of the hosts which left the servergroup
+		promclient.DefaultHealthMonitor.SetUpstreams(s, targets)
 
 		if !s.loaded {
 			s.loaded = true
@@ -254,6 +509,18 @@ func (s *ServerGroup) ApplyConfig(cfg *Config) error {
 		DialContext:     (&net.Dialer{Timeout: cfg.HTTPConfig.DialTimeout}).DialContext,
 	}
 
+	// Redirects are followed right before the transport, so the redirected requests
+	// have all the headers set by the RoundTrippers below
+	rt = &promclient.RedirectRoundTripper{
+		RoundTripper: rt,
+		MaxRedirects: cfg.HTTPConfig.MaxRedirects,
+		CrossHost:    cfg.HTTPConfig.CrossHostRedirects,
+	}
+
+	// Dry runs are answered right before the request would be sent, so the
+	// recorded requests have all the headers set by the RoundTrippers below
+	rt = &promclient.DryRunRoundTripper{RoundTripper: rt}
+
 	// If a bearer token is provided, create a round tripper that will set the
 	// Authorization header correctly on each request.
 	if len(cfg.HTTPConfig.HTTPConfig.BearerToken) > 0 {
@@ -266,11 +533,57 @@ func (s *ServerGroup) ApplyConfig(cfg *Config) error {
 		rt = config_util.NewBasicAuthRoundTripper(cfg.HTTPConfig.HTTPConfig.BasicAuth.Username, cfg.HTTPConfig.HTTPConfig.BasicAuth.Password, cfg.HTTPConfig.HTTPConfig.BasicAuth.PasswordFile, rt)
 	}
 
+	// Attribute the forwarded queries in the query log of the hosts (if configured)
+	if cfg.QueryComment != nil {
+		rt = &promclient.QueryCommentRoundTripper{Config: *cfg.QueryComment, RoundTripper: rt}
+	}
+
+	// The hosts are told the deadline of the queries, so they stop evaluating the
+	// ones the proxy no longer waits for
+	rt = &promclient.QueryTimeoutRoundTripper{RoundTripper: rt}
+
+	// The limit of the queries is sent to the hosts which support it, so they stop
+	// at the limit
+	rt = &promclient.QueryLimitRoundTripper{
+		RoundTripper: rt,
+		Support:      cfg.QueryLimit,
+		Capabilities: promclient.DefaultCapabilityProbes,
+	}
+
+	// The stats of the queries are captured for the QueryCostAPI (if configured)
+	if cfg.CostAccounting {
+		rt = &promclient.QueryStatsRoundTripper{RoundTripper: rt}
+	}
+
+	// Downstreams shedding load are backed off from for as long as they ask
+	rt = &promclient.RetryAfterRoundTripper{RoundTripper: rt}
+
+	// The max-age of responses is captured for the MaxAgeEnforcerAPI
+	rt = &promclient.CacheControlRoundTripper{RoundTripper: rt}
+
+	if cfg.HTTPConfig.RequestIDHeader != "" {
+		rt = &promclient.RequestIDRoundTripper{Header: cfg.HTTPConfig.RequestIDHeader, RoundTripper: rt}
+	}
+
 	s.Client = &http.Client{Transport: rt}
 
+	s.metadata = nil
+	if cfg.MetricMetadata != nil {
+		s.metadata = promclient.NewMetadataCache(s.Client, *cfg.MetricMetadata)
+	}
+
 	if err := s.targetManager.ApplyConfig(map[string]sd_config.ServiceDiscoveryConfig{"foo": cfg.Hosts}); err != nil {
 		return err
 	}
+	// Applying the config stopped the previous providers, so the consul watches are
+	// started again
+	for i, consulCfg := range cfg.ConsulWatchConfigs {
+		d, err := consulsd.NewDiscovery(consulCfg)
+		if err != nil {
+			return errors.Wrap(err, "error creating consul discovery")
+		}
+		s.targetManager.StartCustomProvider(s.ctx, fmt.Sprintf("consul_watch/%d", i), d)
+	}
 	return nil
 }
 
@@ -283,11 +596,34 @@ func (s *ServerGroup) State() *ServerGroupState {
 	return nil
 }
 
+// DeleteSeries deletes the data of the series matching any of the matchers in the
+// time range from the hosts of the servergroup. The matchers on the labels of a
+// host are stripped, and hosts which none of the matchers selects are skipped.
+func (s *ServerGroup) DeleteSeries(ctx context.Context, matches []string, startTime, endTime time.Time) error {
+	return s.State().adminClient.DeleteSeries(ctx, matches, startTime, endTime)
+}
+
+// Snapshot creates a snapshot of the data of all the hosts of the servergroup
+func (s *ServerGroup) Snapshot(ctx context.Context, skipHead bool) (*promclient.SnapshotResult, error) {
+	return s.State().adminClient.Snapshot(ctx, skipHead)
+}
+
+// TSDBStatus returns the merged TSDB status of the hosts of the servergroup
+func (s *ServerGroup) TSDBStatus(ctx context.Context) (*promclient.TSDBStatus, api.Warnings, error) {
+	return s.State().tsdbStatus.TSDBStatus(ctx)
+}
+
 // GetValue loads the raw data for a given set of matchers in the time range
 func (s *ServerGroup) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, api.Warnings, error) {
 	return s.State().apiClient.GetValue(ctx, start, end, matchers)
 }
 
+// StreamGetValue loads the raw data for a given set of matchers in the time range,
+// sending the series on the returned channel as they are read
+func (s *ServerGroup) StreamGetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (<-chan promclient.StreamedSeries, error) {
+	return promclient.StreamGetValue(ctx, s.State().apiClient, start, end, matchers)
+}
+
 // Query performs a query for the given time.
 func (s *ServerGroup) Query(ctx context.Context, query string, ts time.Time) (model.Value, api.Warnings, error) {
 	return s.State().apiClient.Query(ctx, query, ts)
@@ -298,6 +634,11 @@ func (s *ServerGroup) QueryRange(ctx context.Context, query string, r v1.Range)
 	return s.State().apiClient.QueryRange(ctx, query, r)
 }
 
+// StreamSeries finds series by label matchers, calling fn for each labelset
+func (s *ServerGroup) StreamSeries(ctx context.Context, matches []string, startTime time.Time, endTime time.Time, fn promclient.SeriesFunc) (api.Warnings, error) {
+	return promclient.StreamSeries(ctx, s.State().apiClient, matches, startTime, endTime, fn)
+}
+
 // LabelValues performs a query for the values of the given label.
 func (s *ServerGroup) LabelValues(ctx context.Context, label string) (model.LabelValues, api.Warnings, error) {
 	return s.State().apiClient.LabelValues(ctx, label)
@@ -308,6 +649,11 @@ func (s *ServerGroup) LabelNames(ctx context.Context) ([]string, api.Warnings, e
 	return s.State().apiClient.LabelNames(ctx)
 }
 
+// LabelNamesInRange returns the unique label names of series within the time range in sorted order.
+func (s *ServerGroup) LabelNamesInRange(ctx context.Context, startTime, endTime time.Time) ([]string, api.Warnings, error) {
+	return promclient.LabelNamesInRange(ctx, s.State().apiClient, startTime, endTime)
+}
+
 // Series finds series by label matchers.
 func (s *ServerGroup) Series(ctx context.Context, matches []string, startTime, endTime time.Time) ([]model.LabelSet, api.Warnings, error) {
 	return s.State().apiClient.Series(ctx, matches, startTime, endTime)
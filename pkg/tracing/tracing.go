@@ -0,0 +1,348 @@
+// Package tracing traces the requests to promproxy and the calls they make to the
+// downstreams. The spans of every request are recorded in memory, but its trace is
+// only exported if the request was slow, failed or asked to be traced, so tracing
+// all requests costs little more than the spans themselves.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+
+	"github.com/promproxy/pkg/logging"
+	"github.com/promproxy/pkg/metrics"
+)
+
+// MaxSpans is the max number of spans of a trace, further spans are dropped
+const MaxSpans = 1000
+
+// The sampling decisions of a trace
+const (
+	// DecisionForced traces requests which asked to be traced
+	DecisionForced = "forced"
+	// DecisionError traces requests which failed
+	DecisionError = "error"
+	// DecisionSlow traces requests which took at least the LatencyThreshold
+	DecisionSlow = "slow"
+	// DecisionDropped are the requests which aren't traced
+	DecisionDropped = "dropped"
+)
+
+var logger = logging.Component(logging.ComponentTracing)
+
+// Config configures which traces are exported
+type Config struct {
+	// LatencyThreshold is the duration from which the trace of a request is
+	// exported
+	LatencyThreshold time.Duration `yaml:"latency_threshold"`
+	// ForceHeader is the request header which forces the trace of the request to
+	// be exported (if set to any value)
+	ForceHeader string `yaml:"force_header"`
+	// ResponseHeader (if set) is the response header the ID of an exported trace is
+	// returned in, so it can be looked up from the client (e.g. a slow panel)
+	ResponseHeader string `yaml:"response_header"`
+}
+
+// DefaultConfig is the Config used for unset fields
+var DefaultConfig = Config{
+	LatencyThreshold: 5 * time.Second,
+	ForceHeader:      "X-Force-Trace",
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*c = DefaultConfig
+	type plain Config
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// Validate returns an error if the config isn't valid
+func (c Config) Validate() error {
+	if c.LatencyThreshold < 0 {
+		return fmt.Errorf("tracing latency_threshold must not be negative")
+	}
+	return nil
+}
+
+// Span is a timed operation of a Trace
+type Span struct {
+	TraceID    string
+	SpanID     string
+	ParentID   string
+	Name       string
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]string
+	Error      string
+
+	// trace guards the fields which change, as calls abandoned by the request
+	// may still finish their spans while the trace is exported
+	trace *Trace
+}
+
+// SetAttribute sets an attribute of the span, a nil span (of a context without a
+// trace) ignores it
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	s.Attributes[key] = value
+}
+
+// Finish ends the span, with the error of its operation (if any)
+func (s *Span) Finish(err error) {
+	if s == nil {
+		return
+	}
+	s.trace.mu.Lock()
+	defer s.trace.mu.Unlock()
+	s.End = time.Now()
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Trace is the spans of a request
+type Trace struct {
+	ID string
+
+	mu      sync.Mutex
+	spans   []*Span
+	dropped int
+}
+
+// Spans returns copies of the spans of the trace, and the number which were
+// dropped as the trace had MaxSpans already
+func (t *Trace) Spans() ([]Span, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	spans := make([]Span, len(t.spans))
+	for i, s := range t.spans {
+		spans[i] = *s
+		spans[i].Attributes = make(map[string]string, len(s.Attributes))
+		for k, v := range s.Attributes {
+			spans[i].Attributes[k] = v
+		}
+	}
+	return spans, t.dropped
+}
+
+func (t *Trace) newSpan(name, parentID string) *Span {
+	s := &Span{
+		TraceID:    t.ID,
+		SpanID:     newID(8),
+		ParentID:   parentID,
+		Name:       name,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		trace:      t,
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.spans) >= MaxSpans {
+		t.dropped++
+		return s
+	}
+	t.spans = append(t.spans, s)
+	return s
+}
+
+// newID returns a random hex ID of n bytes
+func newID(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+type traceKey struct{}
+
+type spanKey struct{}
+
+// Start starts a new trace with its root span
+func Start(ctx context.Context, name string) (context.Context, *Trace, *Span) {
+	t := &Trace{ID: newID(16)}
+	s := t.newSpan(name, "")
+	ctx = context.WithValue(ctx, traceKey{}, t)
+	return context.WithValue(ctx, spanKey{}, s), t, s
+}
+
+// StartSpan starts a span of the trace of the context, as a child of the span of
+// the context. Without a trace the span is nil, which can be used all the same.
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	t := TraceFromContext(ctx)
+	if t == nil {
+		return ctx, nil
+	}
+	var parentID string
+	if parent, ok := ctx.Value(spanKey{}).(*Span); ok {
+		parentID = parent.SpanID
+	}
+	s := t.newSpan(name, parentID)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// TraceFromContext returns the Trace of the context (nil if there is none)
+func TraceFromContext(ctx context.Context) *Trace {
+	t, _ := ctx.Value(traceKey{}).(*Trace)
+	return t
+}
+
+// Exporter exports the sampled traces
+type Exporter interface {
+	Export(t *Trace) error
+}
+
+// LogExporter exports the traces as log lines, one for each span
+type LogExporter struct{}
+
+// Export implements Exporter
+func (LogExporter) Export(t *Trace) error {
+	spans, dropped := t.Spans()
+	for _, s := range spans {
+		fields := logrus.Fields{
+			"trace_id":  s.TraceID,
+			"span_id":   s.SpanID,
+			"parent_id": s.ParentID,
+			"start":     s.Start,
+		}
+		if s.End.IsZero() {
+			fields["unfinished"] = true
+		} else {
+			fields["took"] = s.End.Sub(s.Start)
+		}
+		for k, v := range s.Attributes {
+			fields["attr_"+k] = v
+		}
+		if s.Error != "" {
+			fields["error"] = s.Error
+		}
+		logger.WithFields(fields).Info(s.Name)
+	}
+	if dropped > 0 {
+		logger.WithField("trace_id", t.ID).Warnf("Dropped %d spans over the limit of %d", dropped, MaxSpans)
+	}
+	return nil
+}
+
+// DefaultTracer is the Tracer of the requests to promproxy
+var DefaultTracer = NewTracer(LogExporter{})
+
+func init() {
+	metrics.MustRegister(DefaultTracer)
+}
+
+// NewTracer returns a Tracer which exports to the exporter, it doesn't trace
+// anything until it is configured
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		exporter:  exporter,
+		decisions: make(map[string]uint64),
+	}
+}
+
+// Tracer decides which traces are exported, and exports them. Its config can be
+// replaced at any time, which applies to the requests decided after.
+type Tracer struct {
+	mu           sync.Mutex
+	cfg          *Config
+	exporter     Exporter
+	decisions    map[string]uint64
+	exported     uint64
+	exportErrors uint64
+}
+
+// ApplyConfig replaces the config of the Tracer, a nil config disables tracing
+func (t *Tracer) ApplyConfig(cfg *Config) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cfg = cfg
+}
+
+// Config returns the config of the Tracer (nil if tracing is disabled)
+func (t *Tracer) Config() *Config {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.cfg
+}
+
+// Decide returns whether the trace of a request which took the given time is
+// exported, and why
+func (t *Tracer) Decide(cfg *Config, took time.Duration, failed, forced bool) (string, bool) {
+	decision := DecisionDropped
+	switch {
+	case forced:
+		decision = DecisionForced
+	case failed:
+		decision = DecisionError
+	case took >= cfg.LatencyThreshold:
+		decision = DecisionSlow
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.decisions[decision]++
+	return decision, decision != DecisionDropped
+}
+
+// Export exports the trace, logging the errors
+func (t *Tracer) Export(trace *Trace) {
+	err := t.exporter.Export(trace)
+	t.mu.Lock()
+	if err != nil {
+		t.exportErrors++
+	} else {
+		t.exported++
+	}
+	t.mu.Unlock()
+	if err != nil {
+		logger.WithField("trace_id", trace.ID).Errorf("Error exporting trace: %v", err)
+	}
+}
+
+var (
+	samplingDecisionsDesc = prometheus.NewDesc(
+		"promproxy_trace_sampling_decisions_total",
+		"Number of traces by whether (and why) they were exported",
+		[]string{"decision"}, nil,
+	)
+	tracesExportedDesc = prometheus.NewDesc(
+		"promproxy_traces_exported_total",
+		"Number of traces exported",
+		nil, nil,
+	)
+	traceExportErrorsDesc = prometheus.NewDesc(
+		"promproxy_trace_export_errors_total",
+		"Number of traces which failed to be exported",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector
+func (t *Tracer) Describe(ch chan<- *prometheus.Desc) {
+	ch <- samplingDecisionsDesc
+	ch <- tracesExportedDesc
+	ch <- traceExportErrorsDesc
+}
+
+// Collect implements prometheus.Collector
+func (t *Tracer) Collect(ch chan<- prometheus.Metric) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for decision, count := range t.decisions {
+		ch <- prometheus.MustNewConstMetric(samplingDecisionsDesc, prometheus.CounterValue, float64(count), decision)
+	}
+	ch <- prometheus.MustNewConstMetric(tracesExportedDesc, prometheus.CounterValue, float64(t.exported))
+	ch <- prometheus.MustNewConstMetric(traceExportErrorsDesc, prometheus.CounterValue, float64(t.exportErrors))
+}
@@ -0,0 +1,81 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTracerDecide(t *testing.T) {
+	cfg := &Config{LatencyThreshold: time.Second}
+	tests := []struct {
+		took     time.Duration
+		failed   bool
+		forced   bool
+		decision string
+	}{
+		{took: time.Millisecond, decision: DecisionDropped},
+		{took: time.Second, decision: DecisionSlow},
+		{took: time.Minute, decision: DecisionSlow},
+		{took: time.Millisecond, failed: true, decision: DecisionError},
+		{took: time.Minute, failed: true, decision: DecisionError},
+		{took: time.Millisecond, forced: true, decision: DecisionForced},
+		{took: time.Minute, failed: true, forced: true, decision: DecisionForced},
+	}
+
+	tracer := NewTracer(LogExporter{})
+	for i, test := range tests {
+		decision, record := tracer.Decide(cfg, test.took, test.failed, test.forced)
+		if decision != test.decision {
+			t.Fatalf("%d: mismatch in decision expected=%v actual=%v", i, test.decision, decision)
+		}
+		if record != (test.decision != DecisionDropped) {
+			t.Fatalf("%d: mismatch in record expected=%v actual=%v", i, !record, record)
+		}
+	}
+	if tracer.decisions[DecisionSlow] != 2 || tracer.decisions[DecisionForced] != 2 {
+		t.Fatalf("mismatch in decisions expected=2 slow and forced actual=%v", tracer.decisions)
+	}
+}
+
+func TestSpans(t *testing.T) {
+	// Without a trace spans are nil, and can be used all the same
+	ctx, span := StartSpan(context.TODO(), "untraced")
+	span.SetAttribute("k", "v")
+	span.Finish(nil)
+	if span != nil || TraceFromContext(ctx) != nil {
+		t.Fatalf("mismatch in span expected=nil actual=%v", span)
+	}
+
+	ctx, trace, root := Start(context.TODO(), "root")
+	childCtx, child := StartSpan(ctx, "child")
+	_, grandchild := StartSpan(childCtx, "grandchild")
+	grandchild.Finish(fmt.Errorf("failed"))
+	child.SetAttribute("upstream", "a")
+	child.Finish(nil)
+	root.Finish(nil)
+
+	spans, dropped := trace.Spans()
+	if len(spans) != 3 || dropped != 0 {
+		t.Fatalf("mismatch in spans expected=3 actual=%d (%d dropped)", len(spans), dropped)
+	}
+	if spans[0].ParentID != "" || spans[1].ParentID != spans[0].SpanID || spans[2].ParentID != spans[1].SpanID {
+		t.Fatalf("mismatch in parents of spans %v", spans)
+	}
+	if spans[1].Attributes["upstream"] != "a" || spans[2].Error != "failed" {
+		t.Fatalf("mismatch in spans %v", spans)
+	}
+	for _, s := range spans {
+		if s.TraceID != trace.ID {
+			t.Fatalf("mismatch in trace ID expected=%v actual=%v", trace.ID, s.TraceID)
+		}
+	}
+
+	for i := 0; i < MaxSpans; i++ {
+		StartSpan(ctx, "span")
+	}
+	if spans, dropped := trace.Spans(); len(spans) != MaxSpans || dropped != 3 {
+		t.Fatalf("mismatch in spans expected=%d (3 dropped) actual=%d (%d dropped)", MaxSpans, len(spans), dropped)
+	}
+}